@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// access grant sources, telling reviewers where to revoke the access
+const (
+	// accessSourceDirectory grants come from a directory group membership, they're revoked in the directory
+	accessSourceDirectory = "directory"
+	// accessSourceEstafette grants come from a group membership set in estafette but not backed by the directory, which the next sync removes unless the group is protected
+	accessSourceEstafette = "estafette"
+	// accessSourceDirect grants are roles assigned to the user directly in estafette
+	accessSourceDirect = "direct"
+)
+
+// AccessReview is a per-user entitlement report tracing the estafette roles of every user back to the groups and directory groups granting them, for periodic access reviews
+type AccessReview struct {
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Provider    string         `json:"provider"`
+	Grants      []*AccessGrant `json:"grants"`
+}
+
+// AccessGrant is a role a user has, or a group membership granting no role, and why the user has it
+type AccessGrant struct {
+	UserID    string `json:"userID"`
+	UserEmail string `json:"userEmail"`
+	// Role is empty for a group membership that grants no role
+	Role      string `json:"role,omitempty"`
+	GroupID   string `json:"groupID,omitempty"`
+	GroupName string `json:"groupName,omitempty"`
+	// DirectoryGroupID and DirectoryGroupName are the directory group granting the group membership, if any
+	DirectoryGroupID   string `json:"directoryGroupID,omitempty"`
+	DirectoryGroupName string `json:"directoryGroupName,omitempty"`
+	Source             string `json:"source"`
+}
+
+// newAccessReview returns the grants of all estafette users: the roles assigned to them directly and the roles of the groups they're a member of in estafette, traced back to the directory groups granting the memberships; memberships the next sync adds aren't access yet and are left out
+func newAccessReview(s state) *AccessReview {
+	review := &AccessReview{
+		GeneratedAt: time.Now().UTC(),
+		Provider:    s.provider.Name(),
+		Grants:      make([]*AccessGrant, 0),
+	}
+
+	for _, u := range s.users {
+		for _, r := range userRoles(u) {
+			review.Grants = append(review.Grants, &AccessGrant{UserID: u.ID, UserEmail: u.GetEmail(), Role: r, Source: accessSourceDirect})
+		}
+	}
+
+	groupsByID := indexGroupsByID(s.groups)
+	for _, l := range getLinks(s) {
+		if !l.InEstafette {
+			continue
+		}
+
+		source := accessSourceEstafette
+		if l.InDirectory {
+			source = accessSourceDirectory
+		}
+		roles := []string{""}
+		if g, ok := groupsByID[l.GroupID]; ok && len(groupRoles(g)) > 0 {
+			roles = groupRoles(g)
+		}
+		for _, r := range roles {
+			review.Grants = append(review.Grants, &AccessGrant{
+				UserID:             l.UserID,
+				UserEmail:          l.UserEmail,
+				Role:               r,
+				GroupID:            l.GroupID,
+				GroupName:          l.GroupName,
+				DirectoryGroupID:   l.DirectoryGroupID,
+				DirectoryGroupName: l.DirectoryGroupName,
+				Source:             source,
+			})
+		}
+	}
+
+	sort.SliceStable(review.Grants, func(i, j int) bool {
+		a, b := review.Grants[i], review.Grants[j]
+		if describeUser(a) != describeUser(b) {
+			return describeUser(a) < describeUser(b)
+		}
+		if a.Role != b.Role {
+			return a.Role < b.Role
+		}
+		if a.GroupName != b.GroupName {
+			return a.GroupName < b.GroupName
+		}
+		return a.DirectoryGroupName < b.DirectoryGroupName
+	})
+
+	return review
+}
+
+// writeAccessReview writes the review as json, csv or markdown
+func writeAccessReview(w io.Writer, review *AccessReview, format string) error {
+	switch format {
+	case "csv":
+		records := [][]string{{"user_id", "user_email", "role", "group_id", "group_name", "directory_group_id", "directory_group_name", "source"}}
+		for _, g := range review.Grants {
+			records = append(records, []string{g.UserID, g.UserEmail, g.Role, g.GroupID, g.GroupName, g.DirectoryGroupID, g.DirectoryGroupName, g.Source})
+		}
+		writer := csv.NewWriter(w)
+		err := writer.WriteAll(records)
+		if err != nil {
+			return fmt.Errorf("Failed writing access review: %w", err)
+		}
+		return nil
+
+	case "markdown":
+		var b strings.Builder
+		fmt.Fprintf(&b, "# Access review\n\nGenerated at %v from %v.\n\n", review.GeneratedAt.Format(time.RFC3339), review.Provider)
+		user := ""
+		for _, g := range review.Grants {
+			if describeUser(g) != user {
+				user = describeUser(g)
+				fmt.Fprintf(&b, "\n## %v\n\n| Role | Group | Directory group | Source |\n| --- | --- | --- | --- |\n", user)
+			}
+			fmt.Fprintf(&b, "| %v | %v | %v | %v |\n", escapeMarkdownCell(orDash(g.Role)), escapeMarkdownCell(orDash(g.GroupName)), escapeMarkdownCell(orDash(g.DirectoryGroupName)), g.Source)
+		}
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+
+	data, err := json.MarshalIndent(review, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed marshalling access review: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+
+	return err
+}
+
+// describeUser returns the email of the user of the grant, or its id if it has none
+func describeUser(g *AccessGrant) string {
+	if g.UserEmail != "" {
+		return g.UserEmail
+	}
+
+	return g.UserID
+}
+
+// orDash returns the value, or a dash if it's empty
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAccessReview(t *testing.T) {
+	t.Run("TracesRolesBackToDirectoryGroups", func(t *testing.T) {
+
+		administrator := "administrator"
+		viewer := "viewer"
+		s := state{
+			provider: &gsuiteClient{},
+			groups: []*contracts.Group{
+				{ID: "g1", Name: "platform", Roles: []*string{&administrator}, Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-platform"}}},
+				{ID: "g2", Name: "manual"},
+			},
+			users: []*contracts.User{
+				{
+					ID:         "u1",
+					Roles:      []*string{&viewer},
+					Identities: []*contracts.UserIdentity{{Provider: "google", ID: "101", Email: "jane@example.com"}},
+					Groups:     []*contracts.Group{{ID: "g1", Name: "platform"}, {ID: "g2", Name: "manual"}},
+				},
+			},
+			groupMembers: map[*DirectoryGroup][]*DirectoryMember{
+				{ID: "0abc", Name: "ci-platform"}: {{ID: "101", Email: "jane@example.com"}},
+			},
+		}
+
+		// act
+		review := newAccessReview(s)
+
+		if assert.Equal(t, 3, len(review.Grants)) {
+			assert.Equal(t, &AccessGrant{UserID: "u1", UserEmail: "jane@example.com", Role: "", GroupID: "g2", GroupName: "manual", Source: accessSourceEstafette}, review.Grants[0])
+			assert.Equal(t, &AccessGrant{UserID: "u1", UserEmail: "jane@example.com", Role: "administrator", GroupID: "g1", GroupName: "platform", DirectoryGroupID: "0abc", DirectoryGroupName: "ci-platform", Source: accessSourceDirectory}, review.Grants[1])
+			assert.Equal(t, &AccessGrant{UserID: "u1", UserEmail: "jane@example.com", Role: "viewer", Source: accessSourceDirect}, review.Grants[2])
+		}
+	})
+}
+
+func TestWriteAccessReview(t *testing.T) {
+	review := &AccessReview{
+		Provider: "gsuite",
+		Grants: []*AccessGrant{
+			{UserID: "u1", UserEmail: "jane@example.com", Role: "administrator", GroupID: "g1", GroupName: "platform", DirectoryGroupID: "0abc", DirectoryGroupName: "ci-platform", Source: accessSourceDirectory},
+		},
+	}
+
+	t.Run("WritesCSV", func(t *testing.T) {
+
+		var b bytes.Buffer
+
+		// act
+		err := writeAccessReview(&b, review, "csv")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "user_id,user_email,role,group_id,group_name,directory_group_id,directory_group_name,source\nu1,jane@example.com,administrator,g1,platform,0abc,ci-platform,directory\n", b.String())
+	})
+
+	t.Run("WritesMarkdownTablePerUser", func(t *testing.T) {
+
+		var b bytes.Buffer
+
+		// act
+		err := writeAccessReview(&b, review, "markdown")
+
+		assert.Nil(t, err)
+		assert.Contains(t, b.String(), "## jane@example.com\n\n| Role | Group | Directory group | Source |\n| --- | --- | --- | --- |\n| administrator | platform | ci-platform | directory |\n")
+	})
+}
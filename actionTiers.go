@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/estafette/estafette-ci-gsuite-synchronizer/reconcile"
+)
+
+// the tiers actions are applied in, one after the other, so no mutation references an entity that doesn't exist yet or removes one that's still referenced
+const (
+	actionTierOrganizations = iota
+	actionTierGroups
+	actionTierMemberships
+	actionTierRoles
+	actionTierGroupDeletions
+	actionTierCount
+)
+
+// actionTier returns the tier of the action: organizations come before the groups attached to them, groups before the memberships referencing them, memberships before the role changes of groups, and groups are only deleted once no membership references them anymore
+func actionTier(a *Action) int {
+	switch a.Type {
+	case ActionCreateOrganization, ActionUpdateOrganization:
+		return actionTierOrganizations
+
+	case ActionUpdateUser:
+		return actionTierMemberships
+
+	case ActionUpdateGroup:
+		if a.GroupBefore != nil && a.Group != nil && !reconcile.SameStrings(groupRoles(a.GroupBefore), groupRoles(a.Group)) {
+			return actionTierRoles
+		}
+		return actionTierGroups
+
+	case ActionDeleteGroup:
+		return actionTierGroupDeletions
+	}
+
+	return actionTierGroups
+}
+
+// tierActions splits the actions into their tiers, in the order they're applied in and keeping the order of the actions within a tier; empty tiers are left out
+func tierActions(actions []*Action) (tiers [][]*Action) {
+	byTier := make([][]*Action, actionTierCount)
+	for _, a := range actions {
+		tier := actionTier(a)
+		byTier[tier] = append(byTier[tier], a)
+	}
+
+	for _, tier := range byTier {
+		if len(tier) > 0 {
+			tiers = append(tiers, tier)
+		}
+	}
+
+	return
+}
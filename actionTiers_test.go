@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTierActions(t *testing.T) {
+	t.Run("OrdersOrganizationsGroupsMembershipsRolesAndDeletions", func(t *testing.T) {
+
+		administrator := "administrator"
+		deleteGroup := &Action{Type: ActionDeleteGroup, Group: &contracts.Group{ID: "g3", Name: "legacy"}}
+		grantRole := &Action{Type: ActionUpdateGroup, GroupBefore: &contracts.Group{ID: "g2", Name: "release"}, Group: &contracts.Group{ID: "g2", Name: "release", Roles: []*string{&administrator}}}
+		updateUser := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1"}}
+		renameGroup := &Action{Type: ActionUpdateGroup, GroupBefore: &contracts.Group{ID: "g1", Name: "platform"}, Group: &contracts.Group{ID: "g1", Name: "platform-team"}}
+		createGroup := &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "ops"}}
+		createOrganization := &Action{Type: ActionCreateOrganization, Organization: &contracts.Organization{Name: "retail"}}
+
+		// act
+		tiers := tierActions([]*Action{deleteGroup, grantRole, updateUser, renameGroup, createGroup, createOrganization})
+
+		assert.Equal(t, [][]*Action{{createOrganization}, {renameGroup, createGroup}, {updateUser}, {grantRole}, {deleteGroup}}, tiers)
+	})
+
+	t.Run("LeavesOutEmptyTiers", func(t *testing.T) {
+
+		updateUser := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1"}}
+
+		// act
+		tiers := tierActions([]*Action{updateUser})
+
+		assert.Equal(t, [][]*Action{{updateUser}}, tiers)
+	})
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// adminAPI serves the last sync report and the current drift, and pauses and resumes syncing in daemon mode, for an admin ui or chatops on top of the syncer; every request needs the --admin-api-token as bearer token
+type adminAPI struct {
+	token string
+	// lastRun returns the last sync run, or nil if there's none yet
+	lastRun func() *SyncRun
+	// computeDrift plans the actions a sync would apply now, without applying them
+	computeDrift func(ctx context.Context) ([]*Action, error)
+	// statsHistory returns the stats of the last successful syncs, or nil if no history is kept
+	statsHistory func() (*StatsHistory, error)
+	// resumed is called once syncing is resumed, to wake up the daemon waiting while paused; it can be nil
+	resumed func()
+	paused  int32
+}
+
+// adminStatusResponse is the json representation of the sync status
+type adminStatusResponse struct {
+	Paused bool `json:"paused"`
+}
+
+// adminDriftResponse is the json representation of the actions a sync would apply now
+type adminDriftResponse struct {
+	InSync  bool            `json:"inSync"`
+	Actions []*ReportAction `json:"actions"`
+}
+
+// adminStatsResponse is the json representation of the stats history, with the anomalies of the last run compared to the one before
+type adminStatsResponse struct {
+	Runs      []*RunStats     `json:"runs"`
+	Anomalies []*StatsAnomaly `json:"anomalies"`
+}
+
+// newAdminAPI returns an adminAPI, or nil if the token is empty so the admin api isn't served
+func newAdminAPI(token string, lastRun func() *SyncRun, computeDrift func(ctx context.Context) ([]*Action, error), statsHistory func() (*StatsHistory, error)) *adminAPI {
+	if token == "" {
+		return nil
+	}
+
+	return &adminAPI{token: token, lastRun: lastRun, computeDrift: computeDrift, statsHistory: statsHistory}
+}
+
+// register adds the admin endpoints to the mux; it's a no-op for a nil adminAPI
+func (a *adminAPI) register(mux *http.ServeMux) {
+	if a == nil {
+		return
+	}
+
+	mux.HandleFunc("/admin/report", a.authenticated(http.MethodGet, a.handleReport))
+	mux.HandleFunc("/admin/drift", a.authenticated(http.MethodGet, a.handleDrift))
+	mux.HandleFunc("/admin/stats", a.authenticated(http.MethodGet, a.handleStats))
+	mux.HandleFunc("/admin/status", a.authenticated(http.MethodGet, a.handleStatus))
+	mux.HandleFunc("/admin/pause", a.authenticated(http.MethodPost, a.handlePause))
+	mux.HandleFunc("/admin/resume", a.authenticated(http.MethodPost, a.handleResume))
+}
+
+// isPaused checks whether syncing was paused through the admin api; a nil adminAPI is never paused
+func (a *adminAPI) isPaused() bool {
+	return a != nil && atomic.LoadInt32(&a.paused) == 1
+}
+
+// authenticated only passes requests with the method and the admin token as bearer token on to the handler
+func (a *adminAPI) authenticated(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// handleReport returns the report of the last sync
+func (a *adminAPI) handleReport(w http.ResponseWriter, r *http.Request) {
+	run := a.lastRun()
+	if run == nil {
+		http.Error(w, "no sync has run yet", http.StatusNotFound)
+		return
+	}
+
+	writeAdminJSON(w, newReport(run))
+}
+
+// handleDrift returns the actions a sync would apply now
+func (a *adminAPI) handleDrift(w http.ResponseWriter, r *http.Request) {
+	actions, err := a.computeDrift(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	response := &adminDriftResponse{InSync: len(actions) == 0, Actions: newReport(&SyncRun{Actions: actions}).Actions}
+	writeAdminJSON(w, response)
+}
+
+// handleStats returns the stats history, flagging the counts of the last run that dropped beyond --anomaly-threshold since the run before
+func (a *adminAPI) handleStats(w http.ResponseWriter, r *http.Request) {
+	history, err := a.statsHistory()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if history == nil {
+		http.Error(w, "no stats history is kept, set --stats-history-file", http.StatusNotFound)
+		return
+	}
+
+	response := &adminStatsResponse{Runs: history.Runs, Anomalies: make([]*StatsAnomaly, 0)}
+	if len(history.Runs) > 1 {
+		response.Anomalies = detectAnomalies(history.Runs[len(history.Runs)-2], history.last(), *anomalyThreshold)
+	}
+	writeAdminJSON(w, response)
+}
+
+func (a *adminAPI) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, &adminStatusResponse{Paused: a.isPaused()})
+}
+
+func (a *adminAPI) handlePause(w http.ResponseWriter, r *http.Request) {
+	if atomic.CompareAndSwapInt32(&a.paused, 0, 1) {
+		log.Info().Msg("Paused syncing through the admin api")
+	}
+	writeAdminJSON(w, &adminStatusResponse{Paused: true})
+}
+
+func (a *adminAPI) handleResume(w http.ResponseWriter, r *http.Request) {
+	if atomic.CompareAndSwapInt32(&a.paused, 1, 0) {
+		log.Info().Msg("Resumed syncing through the admin api")
+		if a.resumed != nil {
+			a.resumed()
+		}
+	}
+	writeAdminJSON(w, &adminStatusResponse{Paused: false})
+}
+
+func writeAdminJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(value)
+}
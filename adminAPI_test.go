@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAPI(t *testing.T) {
+	var history *StatsHistory
+	newServer := func(drift []*Action) *healthServer {
+		server := newHealthServer(func(ctx context.Context) error { return nil }, time.Minute)
+		server.admin = newAdminAPI("admin-token", server.getLastRun, func(ctx context.Context) ([]*Action, error) { return drift, nil }, func() (*StatsHistory, error) { return history, nil })
+		return server
+	}
+	request := func(server *healthServer, method, path, token string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		server.handler().ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	t.Run("IsNotServedWithoutToken", func(t *testing.T) {
+
+		server := newHealthServer(func(ctx context.Context) error { return nil }, time.Minute)
+		server.admin = newAdminAPI("", server.getLastRun, nil, nil)
+
+		// act
+		recorder := request(server, "GET", "/admin/status", "")
+
+		assert.Nil(t, server.admin)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("RejectsRequestsWithWrongToken", func(t *testing.T) {
+
+		server := newServer(nil)
+
+		// act
+		recorder := request(server, "POST", "/admin/pause", "wrong-token")
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.False(t, server.admin.isPaused())
+	})
+
+	t.Run("PausesAndResumesSyncing", func(t *testing.T) {
+
+		server := newServer(nil)
+
+		// act
+		recorder := request(server, "POST", "/admin/pause", "admin-token")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.True(t, server.admin.isPaused())
+
+		// act
+		recorder = request(server, "POST", "/admin/resume", "admin-token")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.False(t, server.admin.isPaused())
+	})
+
+	t.Run("WakesUpPausedDaemonOnceResumed", func(t *testing.T) {
+
+		server := newServer(nil)
+		syncRequests := make(chan struct{}, 1)
+		server.admin.resumed = func() { syncRequests <- struct{}{} }
+		request(server, "POST", "/admin/pause", "admin-token")
+		done := make(chan struct{})
+		go func() {
+			waitForNextSync(time.Hour, time.Hour, nil, false, func() {}, syncRequests, nil)
+			close(done)
+		}()
+
+		// act
+		request(server, "POST", "/admin/resume", "admin-token")
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("waiting for the next sync didn't end once resumed")
+		}
+	})
+
+	t.Run("ReturnsCurrentDrift", func(t *testing.T) {
+
+		server := newServer([]*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}}})
+
+		// act
+		recorder := request(server, "GET", "/admin/drift", "admin-token")
+
+		var response adminDriftResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.Nil(t, err)
+		assert.False(t, response.InSync)
+		if assert.Equal(t, 1, len(response.Actions)) {
+			assert.Equal(t, "create group platform", response.Actions[0].Description)
+		}
+	})
+
+	t.Run("ReturnsReportOfLastSync", func(t *testing.T) {
+
+		server := newServer(nil)
+		server.setLastRun(&SyncRun{ID: "run-1", Provider: gsuiteProviderName, Actions: []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}}}})
+
+		// act
+		recorder := request(server, "GET", "/admin/report", "admin-token")
+
+		var report Report
+		err := json.Unmarshal(recorder.Body.Bytes(), &report)
+		assert.Nil(t, err)
+		assert.Equal(t, "run-1", report.RunID)
+		assert.True(t, report.Succeeded)
+		assert.Equal(t, 1, len(report.Actions))
+	})
+
+	t.Run("ReturnsStatsHistoryWithAnomaliesOfLastRun", func(t *testing.T) {
+
+		server := newServer(nil)
+		history = &StatsHistory{Runs: []*RunStats{{RunID: "run-1", DirectoryGroups: 10, DirectoryMembers: 100}, {RunID: "run-2", DirectoryGroups: 2, DirectoryMembers: 90}}}
+		threshold := *anomalyThreshold
+		*anomalyThreshold = 0.4
+		defer func() { history, *anomalyThreshold = nil, threshold }()
+
+		// act
+		recorder := request(server, "GET", "/admin/stats", "admin-token")
+
+		var response adminStatsResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(response.Runs))
+		if assert.Equal(t, 1, len(response.Anomalies)) {
+			assert.Equal(t, "directory groups", response.Anomalies[0].Metric)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// aggregateAdminRoleIDPrefix marks the ids of the groups generated for gsuite admin roles, followed by the admin role
+const aggregateAdminRoleIDPrefix = aggregateGroupIDPrefix + "admin-role/"
+
+// AdminRole maps a directory admin role to an estafette group the syncer generates with the estafette roles, holding the users the admin role is assigned to, so directory admins get the corresponding estafette capabilities
+type AdminRole struct {
+	// AdminRole is the name of the admin role, like Groups Admin, or _GROUPS_ADMIN_ROLE as the directory api names the system roles
+	AdminRole string `yaml:"adminRole"`
+	// Group is the name of the estafette group holding the users with the admin role
+	Group string `yaml:"group"`
+	// Roles are the estafette roles of the group, and thereby of its members
+	Roles []string `yaml:"roles,omitempty"`
+}
+
+// validateAdminRoles checks that every admin role maps to a group, and that admin roles are fetched at all
+func validateAdminRoles(adminRoles []*AdminRole, syncAdminRoles bool) error {
+	if len(adminRoles) > 0 && !syncAdminRoles {
+		return fmt.Errorf("Admin roles are only assigned with --gsuite-sync-admin-roles")
+	}
+
+	groups := map[string]bool{}
+	for i, r := range adminRoles {
+		if r == nil || r.AdminRole == "" || r.Group == "" {
+			return fmt.Errorf("Admin role %v needs an adminRole and a group", i)
+		}
+		if groups[r.Group] {
+			return fmt.Errorf("Admin role %v maps to group %v, which another admin role maps to already", r.AdminRole, r.Group)
+		}
+		groups[r.Group] = true
+	}
+
+	return nil
+}
+
+// adminRoleGroups returns a generated group for every admin role, with the directory users it's assigned to as members and the estafette roles as annotation
+func adminRoleGroups(adminRoles []*AdminRole, directoryUsers []*DirectoryUser) map[*DirectoryGroup][]*DirectoryMember {
+	groups := make(map[*DirectoryGroup][]*DirectoryMember, len(adminRoles))
+	for _, r := range adminRoles {
+		members := make([]*DirectoryMember, 0)
+		for _, u := range directoryUsers {
+			if hasAdminRole(u, r.AdminRole) {
+				members = append(members, &DirectoryMember{ID: u.ID, Email: u.Email})
+			}
+		}
+
+		roles := r.Roles
+		if roles == nil {
+			roles = []string{}
+		}
+		groups[&DirectoryGroup{ID: aggregateAdminRoleIDPrefix + normalizeAdminRole(r.AdminRole), Name: r.Group, Aggregate: true, Annotations: &GroupAnnotations{Roles: roles}}] = members
+	}
+
+	return groups
+}
+
+// hasAdminRole checks whether the admin role is assigned to the directory user
+func hasAdminRole(user *DirectoryUser, adminRole string) bool {
+	for _, r := range user.AdminRoles {
+		if normalizeAdminRole(r) == normalizeAdminRole(adminRole) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// normalizeAdminRole returns the admin role in lower case with spaces, so Groups Admin matches the system role _GROUPS_ADMIN_ROLE
+func normalizeAdminRole(adminRole string) string {
+	name := strings.TrimSpace(adminRole)
+	if strings.HasPrefix(name, "_") && strings.HasSuffix(name, "_ROLE") {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "_"), "_ROLE")
+	}
+
+	return strings.ToLower(strings.ReplaceAll(name, "_", " "))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateAdminRoles(t *testing.T) {
+	t.Run("ReturnsErrorIfAdminRolesAreNotSynced", func(t *testing.T) {
+
+		adminRoles := []*AdminRole{{AdminRole: "Groups Admin", Group: "groups-admins", Roles: []string{"administrator"}}}
+
+		// act
+		err := validateAdminRoles(adminRoles, false)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfTwoAdminRolesMapToTheSameGroup", func(t *testing.T) {
+
+		adminRoles := []*AdminRole{
+			{AdminRole: "Groups Admin", Group: "admins"},
+			{AdminRole: "Help Desk Admin", Group: "admins"},
+		}
+
+		// act
+		err := validateAdminRoles(adminRoles, true)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestAdminRoleGroups(t *testing.T) {
+	t.Run("ReturnsGroupWithUsersOfAdminRoleAndItsEstafetteRoles", func(t *testing.T) {
+
+		adminRoles := []*AdminRole{{AdminRole: "Groups Admin", Group: "groups-admins", Roles: []string{"administrator"}}}
+		directoryUsers := []*DirectoryUser{
+			{ID: "101", Email: "jane@example.com", AdminRoles: []string{"_GROUPS_ADMIN_ROLE"}},
+			{ID: "102", Email: "ted@example.com", AdminRoles: []string{"Help Desk Admin"}},
+		}
+
+		// act
+		groups := adminRoleGroups(adminRoles, directoryUsers)
+
+		if assert.Equal(t, 1, len(groups)) {
+			for group, members := range groups {
+				assert.Equal(t, aggregateAdminRoleIDPrefix+"groups admin", group.ID)
+				assert.Equal(t, "groups-admins", group.Name)
+				assert.True(t, group.Aggregate)
+				assert.Equal(t, []string{"administrator"}, group.Annotations.Roles)
+				assert.Equal(t, []*DirectoryMember{{ID: "101", Email: "jane@example.com"}}, members)
+			}
+		}
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// Adoption matches a directory group without estafette group to an estafette group created by hand before the syncer existed, by normalized name, so the syncer takes over the existing group instead of creating a duplicate next to it
+type Adoption struct {
+	DirectoryGroup *DirectoryGroup
+	// Group is the matched estafette group, nil if no group or more than one matches
+	Group *contracts.Group
+	// Candidates are the names of the estafette groups matching by normalized name; more than one can't be adopted automatically
+	Candidates []string
+	// Contested is set if another directory group matches the same estafette group, so neither adopts it
+	Contested bool
+}
+
+// adoptable checks whether the adoption has a single estafette group that no other directory group claims
+func (a *Adoption) adoptable() bool {
+	return a.Group != nil && !a.Contested
+}
+
+// planAdoptions matches every directory group that no estafette group has the identity of to the estafette groups without any identity of the provider, by the normalized estafette name of the directory group, see normalizeGroupName; directory groups matching none are left out
+func planAdoptions(groups []*contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember, options planOptions) []*Adoption {
+	unmanaged := make([]*contracts.Group, 0)
+	for _, g := range groups {
+		if !hasProviderIdentity(g, provider.Name()) && !options.isProtected(g) {
+			unmanaged = append(unmanaged, g)
+		}
+	}
+
+	adoptions := make([]*Adoption, 0)
+	claims := map[*contracts.Group][]*Adoption{}
+	for gg := range groupMembers {
+		if gg.Aggregate || hasLinkedGroup(groups, provider, gg) {
+			continue
+		}
+
+		name := normalizeGroupName(options.groupName(gg))
+		if name == "" {
+			continue
+		}
+
+		adoption := &Adoption{DirectoryGroup: gg}
+		var match *contracts.Group
+		for _, g := range unmanaged {
+			if normalizeGroupName(g.Name) == name {
+				adoption.Candidates = append(adoption.Candidates, g.Name)
+				match = g
+			}
+		}
+		if len(adoption.Candidates) == 0 {
+			continue
+		}
+		if len(adoption.Candidates) == 1 {
+			adoption.Group = match
+			claims[match] = append(claims[match], adoption)
+		}
+		sort.Strings(adoption.Candidates)
+		adoptions = append(adoptions, adoption)
+	}
+
+	for _, claimants := range claims {
+		if len(claimants) > 1 {
+			for _, a := range claimants {
+				a.Contested = true
+			}
+		}
+	}
+
+	sort.Slice(adoptions, func(i, j int) bool {
+		return adoptions[i].DirectoryGroup.Name < adoptions[j].DirectoryGroup.Name
+	})
+
+	return adoptions
+}
+
+// hasLinkedGroup checks whether an estafette group has the identity of the directory group
+func hasLinkedGroup(groups []*contracts.Group, provider Provider, directoryGroup *DirectoryGroup) bool {
+	for _, g := range groups {
+		if hasIdentity(g, provider.Name(), directoryGroup.ID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// adoptionActions returns an update per adoptable adoption attaching the identity of the directory group to the estafette group; its name, roles and members are left as they are and synced by the next sync
+func adoptionActions(adoptions []*Adoption, provider Provider) []*Action {
+	actions := make([]*Action, 0, len(adoptions))
+	for _, a := range adoptions {
+		if !a.adoptable() {
+			continue
+		}
+
+		adopted := copyGroup(a.Group)
+		applyGroupIdentity(adopted, &contracts.GroupIdentity{
+			Provider: provider.Name(),
+			ID:       a.DirectoryGroup.ID,
+			Name:     a.DirectoryGroup.Name,
+		})
+		applyGroupIdentity(adopted, groupVersionIdentity(provider, a.DirectoryGroup))
+
+		actions = append(actions, &Action{
+			Type:        ActionUpdateGroup,
+			GroupBefore: a.Group,
+			Group:       adopted,
+		})
+	}
+
+	return actions
+}
+
+// normalizeGroupName returns the name in lower case with only its letters and digits, so Platform Team, platform-team and platform_team match
+func normalizeGroupName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanAdoptions(t *testing.T) {
+	t.Run("MatchesUnmanagedEstafetteGroupByNormalizedName", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "Platform Team"},
+			{ID: "g2", Name: "release"},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-platform-team"}: {{ID: "1234"}},
+		}
+
+		// act
+		adoptions := planAdoptions(groups, &gsuiteClient{}, groupMembers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(adoptions)) {
+			assert.True(t, adoptions[0].adoptable())
+			assert.Equal(t, "g1", adoptions[0].Group.ID)
+		}
+	})
+
+	t.Run("SkipsEstafetteGroupsManagedByTheProvider", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0def", Name: "ci-platform-old"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-platform"}: {{ID: "1234"}},
+		}
+
+		// act
+		adoptions := planAdoptions(groups, &gsuiteClient{}, groupMembers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		assert.Equal(t, 0, len(adoptions))
+	})
+
+	t.Run("DoesNotAdoptIfSeveralGroupsMatch", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform-team"},
+			{ID: "g2", Name: "Platform_Team"},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-platform-team"}: {{ID: "1234"}},
+		}
+
+		// act
+		adoptions := planAdoptions(groups, &gsuiteClient{}, groupMembers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(adoptions)) {
+			assert.False(t, adoptions[0].adoptable())
+			assert.Equal(t, []string{"Platform_Team", "platform-team"}, adoptions[0].Candidates)
+		}
+	})
+
+	t.Run("DoesNotAdoptGroupMatchedByTwoDirectoryGroups", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform"},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-platform"}:  {{ID: "1234"}},
+			{ID: "0def", Name: "ci-Platform_"}: {{ID: "5678"}},
+		}
+
+		// act
+		adoptions := planAdoptions(groups, &gsuiteClient{}, groupMembers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 2, len(adoptions)) {
+			assert.True(t, adoptions[0].Contested)
+			assert.True(t, adoptions[1].Contested)
+		}
+		assert.Equal(t, 0, len(adoptionActions(adoptions, &gsuiteClient{})))
+	})
+}
+
+func TestAdoptionActions(t *testing.T) {
+	t.Run("AttachesDirectoryIdentityToAdoptedGroup", func(t *testing.T) {
+
+		group := &contracts.Group{ID: "g1", Name: "Platform Team"}
+		adoptions := []*Adoption{{DirectoryGroup: &DirectoryGroup{ID: "0abc", Name: "ci-platform-team"}, Group: group, Candidates: []string{"Platform Team"}}}
+
+		// act
+		actions := adoptionActions(adoptions, &gsuiteClient{})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, group, actions[0].GroupBefore)
+			assert.Equal(t, "Platform Team", actions[0].Group.Name)
+			assert.Contains(t, actions[0].Group.Identities, &contracts.GroupIdentity{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-platform-team"})
+			assert.Equal(t, 0, len(group.Identities))
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+)
+
+const (
+	// aggregateGroupIDPrefix marks the ids of groups generated by the syncer, so they can't clash with the ids of directory groups
+	aggregateGroupIDPrefix = "aggregate/"
+	aggregateEveryoneID    = aggregateGroupIDPrefix + "everyone"
+	aggregateAdminsID      = aggregateGroupIDPrefix + "admins"
+)
+
+// syncedGroupMembers returns the directory groups to synchronize: their members filtered by the member filter, with the aggregate groups added
+func (o planOptions) syncedGroupMembers(groupMembers map[*DirectoryGroup][]*DirectoryMember, directoryUsers []*DirectoryUser) map[*DirectoryGroup][]*DirectoryMember {
+	return addAggregateGroups(o.memberFilter.filterGroupMembers(groupMembers), o.memberFilter.filterDirectoryUsers(directoryUsers), o)
+}
+
+// addAggregateGroups returns the directory groups with the aggregate groups generated by the syncer added: everyone holding the members of all groups and admins holding the directory users that are admin, as convenient targets for pipeline permissions, and a group per mapped admin role
+func addAggregateGroups(groupMembers map[*DirectoryGroup][]*DirectoryMember, directoryUsers []*DirectoryUser, options planOptions) map[*DirectoryGroup][]*DirectoryMember {
+	if options.everyoneGroup == "" && options.adminsGroup == "" && len(options.adminRoles) == 0 {
+		return groupMembers
+	}
+
+	aggregated := make(map[*DirectoryGroup][]*DirectoryMember, len(groupMembers)+2+len(options.adminRoles))
+	for gg, members := range groupMembers {
+		aggregated[gg] = members
+	}
+
+	if options.everyoneGroup != "" {
+		everyone := make([]*DirectoryMember, 0)
+		seen := map[string]bool{}
+		for _, members := range groupMembers {
+			for _, m := range members {
+				key := aggregateMemberKey(m)
+				if !seen[key] {
+					seen[key] = true
+					everyone = append(everyone, m)
+				}
+			}
+		}
+		aggregated[&DirectoryGroup{ID: aggregateEveryoneID, Name: options.everyoneGroup, Aggregate: true}] = everyone
+	}
+
+	if options.adminsGroup != "" {
+		admins := make([]*DirectoryMember, 0)
+		for _, u := range directoryUsers {
+			if u.IsAdmin {
+				admins = append(admins, &DirectoryMember{ID: u.ID, Email: u.Email})
+			}
+		}
+		aggregated[&DirectoryGroup{ID: aggregateAdminsID, Name: options.adminsGroup, Aggregate: true}] = admins
+	}
+
+	for gg, members := range adminRoleGroups(options.adminRoles, directoryUsers) {
+		aggregated[gg] = members
+	}
+
+	return aggregated
+}
+
+// aggregateMemberKey identifies a member across groups by id, or by email for providers without stable member ids
+func aggregateMemberKey(m *DirectoryMember) string {
+	if m.ID != "" {
+		return m.ID
+	}
+
+	return strings.ToLower(m.Email)
+}
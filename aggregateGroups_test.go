@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddAggregateGroups(t *testing.T) {
+	t.Run("ReturnsGroupMembersAsIsIfNoAggregateGroupsAreEnabled", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234", Email: "john@example.com"}},
+		}
+
+		// act
+		aggregated := addAggregateGroups(groupMembers, nil, planOptions{})
+
+		assert.Equal(t, groupMembers, aggregated)
+	})
+
+	t.Run("AddsEveryoneGroupWithMembersOfAllGroupsOnce", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234", Email: "john@example.com"}, {ID: "5678", Email: "jane@example.com"}},
+			{ID: "ci-frontend@example.com", Name: "ci-frontend"}: {{ID: "1234", Email: "john@example.com"}},
+		}
+
+		// act
+		aggregated := addAggregateGroups(groupMembers, nil, planOptions{everyoneGroup: "everyone"})
+
+		assert.Equal(t, 3, len(aggregated))
+		for gg, members := range aggregated {
+			if gg.ID == aggregateEveryoneID {
+				assert.Equal(t, "everyone", gg.Name)
+				assert.Equal(t, 2, len(members))
+			}
+		}
+	})
+
+	t.Run("AddsAdminsGroupWithDirectoryAdministrators", func(t *testing.T) {
+
+		directoryUsers := []*DirectoryUser{
+			{ID: "1234", Email: "john@example.com", IsAdmin: true},
+			{ID: "5678", Email: "jane@example.com"},
+		}
+
+		// act
+		aggregated := addAggregateGroups(map[*DirectoryGroup][]*DirectoryMember{}, directoryUsers, planOptions{adminsGroup: "gsuite-admins"})
+
+		if assert.Equal(t, 1, len(aggregated)) {
+			for gg, members := range aggregated {
+				assert.Equal(t, aggregateAdminsID, gg.ID)
+				if assert.Equal(t, 1, len(members)) {
+					assert.Equal(t, "john@example.com", members[0].Email)
+				}
+			}
+		}
+	})
+}
+
+func TestPlanGroupsAndMembersWithAggregateGroups(t *testing.T) {
+	t.Run("CreatesAggregateGroupWithoutGroupPrefixTrimmed", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234", Email: "john@example.com"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, everyoneGroup: "ci-everyone"})
+
+		names := []string{}
+		for _, a := range actions {
+			if a.Type == ActionCreateGroup {
+				names = append(names, a.Group.Name)
+			}
+		}
+		assert.ElementsMatch(t, []string{"platform", "ci-everyone"}, names)
+	})
+}
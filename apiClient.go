@@ -1,46 +1,225 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	contracts "github.com/estafette/estafette-ci-contracts"
 	foundation "github.com/estafette/estafette-foundation"
 	"github.com/opentracing-contrib/go-stdlib/nethttp"
 	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
 	"github.com/rs/zerolog/log"
 	"github.com/sethgrid/pester"
-	admin "google.golang.org/api/admin/directory/v1"
+	"github.com/sony/gobreaker"
+	"golang.org/x/sync/errgroup"
 )
 
 const gsuiteProviderName = "gsuite"
 const googleProviderName = "google"
+const gcpProviderName = "gcp"
 
 type ApiClient interface {
 	GetToken(ctx context.Context, clientID, clientSecret string) (token string, err error)
 	GetOrganizations(ctx context.Context, token string) (organizations []*contracts.Organization, err error)
 	GetGroups(ctx context.Context, token string) (groups []*contracts.Group, err error)
 	GetUsers(ctx context.Context, token string) (users []*contracts.User, err error)
-	SynchronizeGroupsAndMembers(ctx context.Context, token string, groups []*contracts.Group, users []*contracts.User, gsuiteGroupMembers map[*admin.Group][]*admin.Member) (err error)
+	ApplyActions(ctx context.Context, token string, actions []*Action) (err error)
+	PostIntegrationLog(ctx context.Context, token string, integrationLog *IntegrationLog) (err error)
+	TriggerPipeline(ctx context.Context, token, pipeline, branch string) (err error)
 }
 
+// ApiClientOptions configures an ApiClient; fields left empty get the defaults of the corresponding --api-* flags
+type ApiClientOptions struct {
+	// Timeout is the timeout for a single request
+	Timeout time.Duration
+	// MaxRetries is the maximum number of attempts for a request
+	MaxRetries int
+	// Backoff is the name of the backoff strategy between attempts, see backoffStrategy
+	Backoff string
+	// BreakerFailures is the number of consecutive failed mutations after which the circuit breaker opens
+	BreakerFailures int
+	// BreakerCooldown is the time the circuit breaker stays open
+	BreakerCooldown time.Duration
+	// UsePatch sends json merge patches instead of entire entities
+	UsePatch bool
+	// UseIfMatch sends updates conditionally on the etag of the entity they're based on
+	UseIfMatch bool
+	// UseMembershipEndpoints adds and removes group memberships one by one instead of updating entire users
+	UseMembershipEndpoints bool
+	// UnknownFields is what to do with fields of entities the syncer doesn't know, see --api-unknown-fields
+	UnknownFields string
+	// PageSize is the number of entities to request per page
+	PageSize int
+	// Concurrency is the number of mutations applied in parallel
+	Concurrency int
+	// Faults injects failures into requests, for testing
+	Faults *faultInjector
+	// HTTPLog logs requests and responses
+	HTTPLog *httpLogger
+}
+
+// defaults for the api client options that are left empty
+const (
+	defaultApiTimeout         = 10 * time.Second
+	defaultApiMaxRetries      = 3
+	defaultApiBreakerFailures = 5
+	defaultApiBreakerCooldown = 30 * time.Second
+	defaultApiConcurrency     = 10
+)
+
 // NewApiClient returns a new ApiClient
-func NewApiClient(apiBaseURL, gsuiteGroupPrefix string) ApiClient {
+func NewApiClient(apiBaseURL string, auditLogger AuditLogger, options ApiClientOptions) ApiClient {
+
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultApiTimeout
+	}
+	maxRetries := options.MaxRetries
+	if maxRetries < 1 {
+		maxRetries = defaultApiMaxRetries
+	}
+	breakerFailures := options.BreakerFailures
+	if breakerFailures < 1 {
+		breakerFailures = defaultApiBreakerFailures
+	}
+	breakerCooldown := options.BreakerCooldown
+	if breakerCooldown <= 0 {
+		breakerCooldown = defaultApiBreakerCooldown
+	}
+	pageSize := options.PageSize
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultApiConcurrency
+	}
+
+	// create a single client for all requests, sending them through the shared transport so connections are reused across clients as well; pester only applies its own Timeout to clients it creates itself, so the timeout is set on the http client it wraps
+	client := pester.NewExtendedClient(&http.Client{Timeout: timeout, Transport: &nethttp.Transport{RoundTripper: &retryAfterTransport{next: options.HTTPLog.wrap(options.Faults.wrap(sharedRoundTripper()))}}})
+	client.MaxRetries = maxRetries
+	client.Backoff = backoffStrategy(options.Backoff)
+	// rate limited requests are retried with backoff like server errors
+	client.SetRetryOnHTTP429(true)
+	// pester reports every failed attempt before retrying it, which is where the run's retry budget gets used up
+	client.ContextLogHook = func(ctx context.Context, e pester.ErrEntry) {
+		if e.Attempt >= client.MaxRetries {
+			return
+		}
+		if !retryBudgetFromContext(ctx).take() {
+			logFromContext(ctx).Warn().Msgf("Retry budget is used up, not retrying %v %v", e.Verb, e.URL)
+			stopRetries(ctx)
+			return
+		}
+
+		// wait as long as an overloaded api asked for, on top of pester's own backoff that follows
+		if wait := requestRetriesFromContext(ctx).takeRetryAfter(); wait > 0 {
+			logFromContext(ctx).Debug().Msgf("Waiting %v before retrying %v %v as asked by the estafette api", wait, e.Verb, e.URL)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	// stop sending mutations for a while once the api keeps failing, instead of burning all retries on every entity
+	breaker := gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        "estafette-ci-api",
+		MaxRequests: 1,
+		Timeout:     breakerCooldown,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= uint32(breakerFailures)
+		},
+		IsSuccessful: func(err error) bool {
+			return !isServerError(err)
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			if to == gobreaker.StateOpen {
+				log.Warn().Msgf("Circuit breaker %v opened after %v consecutive failures, estafette api is degraded; pausing mutations for %v", name, breakerFailures, breakerCooldown)
+			} else {
+				log.Info().Msgf("Circuit breaker %v changed from %v to %v", name, from, to)
+			}
+		},
+	})
+
 	return &apiClient{
-		apiBaseURL:        apiBaseURL,
-		gsuiteGroupPrefix: gsuiteGroupPrefix,
+		endpoints:       newEstafetteEndpoints(apiBaseURL),
+		auditLogger:     auditLogger,
+		client:          client,
+		breaker:         breaker,
+		breakerCooldown: breakerCooldown,
+		usePatch:        options.UsePatch,
+		useIfMatch:      options.UseIfMatch,
+		pageSize:        pageSize,
+		concurrency:     concurrency,
+
+		useMembershipEndpoints: options.UseMembershipEndpoints,
+		unknownFields:          options.UnknownFields,
+	}
+}
+
+// backoffStrategy returns the pester backoff strategy with the given name, defaulting to exponential backoff with jitter
+func backoffStrategy(backoff string) pester.BackoffStrategy {
+	switch backoff {
+	case "default":
+		return pester.DefaultBackoff
+	case "linear":
+		return pester.LinearBackoff
+	case "linear-jitter":
+		return pester.LinearJitterBackoff
+	case "exponential":
+		return pester.ExponentialBackoff
 	}
+	return pester.ExponentialJitterBackoff
 }
 
 type apiClient struct {
-	apiBaseURL        string
-	gsuiteGroupPrefix string
+	endpoints   estafetteEndpoints
+	auditLogger AuditLogger
+	client      *pester.Client
+
+	breaker         *gobreaker.CircuitBreaker
+	breakerCooldown time.Duration
+
+	// usePatch sends updates as json merge patch; patchUnsupported is set once the api rejects a patch request
+	usePatch         bool
+	patchUnsupported int32
+
+	// useIfMatch fetches entities before updating them and sends their etag as If-Match header
+	useIfMatch bool
+
+	// useMembershipEndpoints adds and removes the group memberships of users one by one instead of updating the entire user; membershipUnsupported is set once the api turns out not to have the endpoints
+	useMembershipEndpoints bool
+	membershipUnsupported  int32
+
+	// unknownFields is how fields of responses the syncer doesn't know are handled, see decodeResponse; reportedUnknownFields holds the ones logged already
+	unknownFields         string
+	reportedUnknownFields sync.Map
+
+	// pageSize is the page size asked for when listing organizations, groups and users
+	pageSize int
+
+	// concurrency is the number of mutations applied in parallel within a tier, see actionTier
+	concurrency int
+
+	// credentials and latest token, for refreshing the token on 401 responses
+	tokenMutex   sync.Mutex
+	clientID     string
+	clientSecret string
+	token        string
 }
 
 func (c *apiClient) GetToken(ctx context.Context, clientID, clientSecret string) (token string, err error) {
@@ -57,24 +236,32 @@ func (c *apiClient) GetToken(ctx context.Context, clientID, clientSecret string)
 		return
 	}
 
-	getTokenURL := fmt.Sprintf("%v/api/auth/client/login", c.apiBaseURL)
+	getTokenURL := c.endpoints.clientLogin()
 	headers := map[string]string{
 		"Content-Type": "application/json",
 	}
 
-	responseBody, err := c.postRequest(getTokenURL, span, strings.NewReader(string(bytes)), headers)
+	responseBody, err := c.postRequest(ctx, getTokenURL, span, strings.NewReader(string(bytes)), headers)
+	if err != nil {
+		return
+	}
 
-	tokenResponse := struct {
-		Token string `json:"token"`
-	}{}
+	var tokenResponse estafetteTokenResponse
 
 	// unmarshal json body
 	err = json.Unmarshal(responseBody, &tokenResponse)
 	if err != nil {
-		log.Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get token response")
+		logFromContext(ctx).Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get token response")
 		return
 	}
 
+	// keep the credentials to be able to refresh the token when it expires during a long run
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+	c.clientID = clientID
+	c.clientSecret = clientSecret
+	c.token = tokenResponse.Token
+
 	return tokenResponse.Token, nil
 }
 
@@ -82,22 +269,32 @@ func (c *apiClient) GetOrganizations(ctx context.Context, token string) (organiz
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::GetOrganizations")
 	defer span.Finish()
 
-	pageNumber := 1
-	pageSize := 100
-	organizations = make([]*contracts.Organization, 0)
+	pageSize := c.pageSize
 
-	for {
-		orgs, pagination, err := c.getOrganizationsPage(ctx, token, pageNumber, pageSize)
-		if err != nil {
-			return organizations, err
-		}
-		organizations = append(organizations, orgs...)
+	// the first page tells how many pages there are, the others are fetched in parallel
+	firstPage, pagination, err := c.getOrganizationsPage(ctx, token, 1, pageSize)
+	if err != nil {
+		return nil, err
+	}
 
-		if pagination.TotalPages <= pageNumber {
-			break
-		}
+	// the api may use a smaller page size than asked for under load, the other pages are fetched with the size it used so the page numbers line up
+	if pagination.Size > 0 {
+		pageSize = pagination.Size
+	}
+
+	// pages are indexed by page number minus one, the first one is left empty since it was fetched already
+	pages := make([][]*contracts.Organization, pagination.TotalPages)
+	err = fetchRemainingPages(ctx, pagination.TotalPages, func(ctx context.Context, pageNumber int) (err error) {
+		pages[pageNumber-1], _, err = c.getOrganizationsPage(ctx, token, pageNumber, pageSize)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		pageNumber++
+	organizations = append(make([]*contracts.Organization, 0, len(firstPage)), firstPage...)
+	for _, page := range pages {
+		organizations = append(organizations, page...)
 	}
 
 	span.LogKV("organizations", len(organizations))
@@ -105,29 +302,46 @@ func (c *apiClient) GetOrganizations(ctx context.Context, token string) (organiz
 	return organizations, nil
 }
 
+// defaultPageSize is the page size used if none is configured
+const defaultPageSize = 100
+
+// pageConcurrency limits the number of pages of a list fetched in parallel
+const pageConcurrency = 5
+
+// fetchRemainingPages calls fetchPage for pages 2 up to totalPages in parallel; the first failure cancels the calls still in flight
+func fetchRemainingPages(ctx context.Context, totalPages int, fetchPage func(ctx context.Context, pageNumber int) error) error {
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(pageConcurrency)
+
+	for pageNumber := 2; pageNumber <= totalPages; pageNumber++ {
+		pageNumber := pageNumber
+		g.Go(func() error {
+			return fetchPage(ctx, pageNumber)
+		})
+	}
+
+	return g.Wait()
+}
+
 func (c *apiClient) getOrganizationsPage(ctx context.Context, token string, pageNumber, pageSize int) (organizations []*contracts.Organization, pagination contracts.Pagination, err error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::getOrganizationsPage")
 	defer span.Finish()
 
 	span.LogKV("page[number]", pageNumber, "page[size]", pageSize)
 
-	getOrganizationsURL := fmt.Sprintf("%v/api/organizations?page[number]=%v&page[size]=%v", c.apiBaseURL, pageNumber, pageSize)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", token),
-		"Content-Type":  "application/json",
+	getOrganizationsURL := c.endpoints.organizationsPage(pageNumber, pageSize)
+	responseBody, err := c.authenticatedRequest(ctx, "GET", getOrganizationsURL, span, token, nil)
+	if err != nil {
+		return
 	}
 
-	responseBody, err := c.getRequest(getOrganizationsURL, span, nil, headers)
-
-	var listResponse struct {
-		Items      []*contracts.Organization `json:"items"`
-		Pagination contracts.Pagination      `json:"pagination"`
-	}
+	var listResponse estafetteOrganizationsPage
 
 	// unmarshal json body
-	err = json.Unmarshal(responseBody, &listResponse)
+	err = c.decodeResponse(ctx, getOrganizationsURL, responseBody, &listResponse)
 	if err != nil {
-		log.Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get organizations response")
+		logFromContext(ctx).Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get organizations response")
 		return
 	}
 
@@ -142,22 +356,32 @@ func (c *apiClient) GetGroups(ctx context.Context, token string) (groups []*cont
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::GetGroups")
 	defer span.Finish()
 
-	pageNumber := 1
-	pageSize := 100
-	groups = make([]*contracts.Group, 0)
+	pageSize := c.pageSize
 
-	for {
-		grps, pagination, err := c.getGroupsPage(ctx, token, pageNumber, pageSize)
-		if err != nil {
-			return groups, err
-		}
-		groups = append(groups, grps...)
+	// the first page tells how many pages there are, the others are fetched in parallel
+	firstPage, pagination, err := c.getGroupsPage(ctx, token, 1, pageSize)
+	if err != nil {
+		return nil, err
+	}
 
-		if pagination.TotalPages <= pageNumber {
-			break
-		}
+	// the api may use a smaller page size than asked for under load, the other pages are fetched with the size it used so the page numbers line up
+	if pagination.Size > 0 {
+		pageSize = pagination.Size
+	}
+
+	// pages are indexed by page number minus one, the first one is left empty since it was fetched already
+	pages := make([][]*contracts.Group, pagination.TotalPages)
+	err = fetchRemainingPages(ctx, pagination.TotalPages, func(ctx context.Context, pageNumber int) (err error) {
+		pages[pageNumber-1], _, err = c.getGroupsPage(ctx, token, pageNumber, pageSize)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		pageNumber++
+	groups = append(make([]*contracts.Group, 0, len(firstPage)), firstPage...)
+	for _, page := range pages {
+		groups = append(groups, page...)
 	}
 
 	span.LogKV("groups", len(groups))
@@ -171,23 +395,18 @@ func (c *apiClient) getGroupsPage(ctx context.Context, token string, pageNumber,
 
 	span.LogKV("page[number]", pageNumber, "page[size]", pageSize)
 
-	getGroupsURL := fmt.Sprintf("%v/api/groups?page[number]=%v&page[size]=%v", c.apiBaseURL, pageNumber, pageSize)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", token),
-		"Content-Type":  "application/json",
+	getGroupsURL := c.endpoints.groupsPage(pageNumber, pageSize)
+	responseBody, err := c.authenticatedRequest(ctx, "GET", getGroupsURL, span, token, nil)
+	if err != nil {
+		return
 	}
 
-	responseBody, err := c.getRequest(getGroupsURL, span, nil, headers)
-
-	var listResponse struct {
-		Items      []*contracts.Group   `json:"items"`
-		Pagination contracts.Pagination `json:"pagination"`
-	}
+	var listResponse estafetteGroupsPage
 
 	// unmarshal json body
-	err = json.Unmarshal(responseBody, &listResponse)
+	err = c.decodeResponse(ctx, getGroupsURL, responseBody, &listResponse)
 	if err != nil {
-		log.Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get organizations response")
+		logFromContext(ctx).Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get groups response")
 		return
 	}
 
@@ -202,22 +421,32 @@ func (c *apiClient) GetUsers(ctx context.Context, token string) (users []*contra
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::GetUsers")
 	defer span.Finish()
 
-	pageNumber := 1
-	pageSize := 100
-	users = make([]*contracts.User, 0)
+	pageSize := c.pageSize
 
-	for {
-		usrs, pagination, err := c.getUsersPage(ctx, token, pageNumber, pageSize)
-		if err != nil {
-			return users, err
-		}
-		users = append(users, usrs...)
+	// the first page tells how many pages there are, the others are fetched in parallel
+	firstPage, pagination, err := c.getUsersPage(ctx, token, 1, pageSize)
+	if err != nil {
+		return nil, err
+	}
 
-		if pagination.TotalPages <= pageNumber {
-			break
-		}
+	// the api may use a smaller page size than asked for under load, the other pages are fetched with the size it used so the page numbers line up
+	if pagination.Size > 0 {
+		pageSize = pagination.Size
+	}
 
-		pageNumber++
+	// pages are indexed by page number minus one, the first one is left empty since it was fetched already
+	pages := make([][]*contracts.User, pagination.TotalPages)
+	err = fetchRemainingPages(ctx, pagination.TotalPages, func(ctx context.Context, pageNumber int) (err error) {
+		pages[pageNumber-1], _, err = c.getUsersPage(ctx, token, pageNumber, pageSize)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users = append(make([]*contracts.User, 0, len(firstPage)), firstPage...)
+	for _, page := range pages {
+		users = append(users, page...)
 	}
 
 	span.LogKV("users", len(users))
@@ -231,23 +460,18 @@ func (c *apiClient) getUsersPage(ctx context.Context, token string, pageNumber,
 
 	span.LogKV("page[number]", pageNumber, "page[size]", pageSize)
 
-	getUsersURL := fmt.Sprintf("%v/api/users?page[number]=%v&page[size]=%v", c.apiBaseURL, pageNumber, pageSize)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", token),
-		"Content-Type":  "application/json",
+	getUsersURL := c.endpoints.usersPage(pageNumber, pageSize)
+	responseBody, err := c.authenticatedRequest(ctx, "GET", getUsersURL, span, token, nil)
+	if err != nil {
+		return
 	}
 
-	responseBody, err := c.getRequest(getUsersURL, span, nil, headers)
-
-	var listResponse struct {
-		Items      []*contracts.User    `json:"items"`
-		Pagination contracts.Pagination `json:"pagination"`
-	}
+	var listResponse estafetteUsersPage
 
 	// unmarshal json body
-	err = json.Unmarshal(responseBody, &listResponse)
+	err = c.decodeResponse(ctx, getUsersURL, responseBody, &listResponse)
 	if err != nil {
-		log.Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get organizations response")
+		logFromContext(ctx).Error().Err(err).Str("body", string(responseBody)).Msgf("Failed unmarshalling get users response")
 		return
 	}
 
@@ -258,166 +482,164 @@ func (c *apiClient) getUsersPage(ctx context.Context, token string, pageNumber,
 	return users, listResponse.Pagination, nil
 }
 
-func (c *apiClient) SynchronizeGroupsAndMembers(ctx context.Context, token string, groups []*contracts.Group, users []*contracts.User, gsuiteGroupMembers map[*admin.Group][]*admin.Member) (err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::SynchronizeGroupsAndMembers")
+// PostIntegrationLog records the summary of a sync run in estafette; if token is empty the token of the last login is used.
+// It doesn't go through the circuit breaker, since the log is informational and shouldn't delay the end of a run against a degraded api
+func (c *apiClient) PostIntegrationLog(ctx context.Context, token string, integrationLog *IntegrationLog) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::PostIntegrationLog")
 	defer span.Finish()
 
-	// http://jmoiron.net/blog/limiting-concurrency-in-go/
-	concurrency := 10
-	semaphore := make(chan bool, concurrency)
+	span.LogKV("status", integrationLog.Status, "changes", len(integrationLog.Changes))
 
-	resultChannel := make(chan error, len(groups)+len(gsuiteGroupMembers)+len(users))
+	if c.latestToken(token) == "" {
+		return fmt.Errorf("Failed posting integration log, the run failed before logging in")
+	}
 
-	// loop estafette groups to see if any of them have to be updated from gsuite groups
-	for _, g := range groups {
-		// try to fill semaphore up to it's full size otherwise wait for a routine to finish
-		semaphore <- true
+	bytes, err := json.Marshal(integrationLog)
+	if err != nil {
+		return
+	}
 
-		go func(ctx context.Context, token string, g *contracts.Group, gsuiteGroupMembers map[*admin.Group][]*admin.Member) {
-			// lower semaphore once the routine's finished, making room for another one to start
-			defer func() { <-semaphore }()
+	integrationLogURL := c.endpoints.integrationLogs()
+	_, _, err = c.authenticatedRequestWithHeaders(ctx, "POST", integrationLogURL, span, token, bytes, nil, http.StatusOK, http.StatusCreated, http.StatusNoContent)
 
-			hasMatchingGsuiteGroup := false
-			for gg := range gsuiteGroupMembers {
-				// check estafette group identities for provider gsuite and id equal to gsuite group email address
-				for _, i := range g.Identities {
-					if i.Provider == gsuiteProviderName && i.ID == gg.Email {
-						hasMatchingGsuiteGroup = true
-
-						// we have a matching group in estafette, update it
-						desiredName := strings.TrimPrefix(gg.Name, c.gsuiteGroupPrefix)
-						if g.Name != desiredName || i.Name != gg.Name {
-							g.Name = desiredName
-							i.Name = gg.Name
-
-							err = c.updateGroup(ctx, token, g)
-							if err != nil {
-								resultChannel <- err
-								return
-							}
-						}
-					}
-				}
-			}
+	return
+}
 
-			if !hasMatchingGsuiteGroup {
-				// todo de-activate it??
-			}
+// TriggerPipeline starts a build of the branch of the estafette pipeline, given as source/owner/name; if token is empty the token of the last login is used.
+// Like the integration log it doesn't go through the circuit breaker, since it doesn't change groups, users or organizations
+func (c *apiClient) TriggerPipeline(ctx context.Context, token, pipeline, branch string) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::TriggerPipeline")
+	defer span.Finish()
+
+	span.LogKV("pipeline", pipeline, "branch", branch)
 
-			resultChannel <- nil
-		}(ctx, token, g, gsuiteGroupMembers)
+	repo := strings.Split(pipeline, "/")
+	if len(repo) != 3 || repo[0] == "" || repo[1] == "" || repo[2] == "" {
+		return fmt.Errorf("Pipeline %v is not of the form source/owner/name", pipeline)
 	}
 
-	// loop gsuite groups to see if any of them have to be created as estafette groups
-	for gg, m := range gsuiteGroupMembers {
-		// try to fill semaphore up to it's full size otherwise wait for a routine to finish
-		semaphore <- true
+	if c.latestToken(token) == "" {
+		return fmt.Errorf("Failed triggering pipeline %v, the run failed before logging in", pipeline)
+	}
 
-		go func(ctx context.Context, token string, gg *admin.Group, m []*admin.Member, groups []*contracts.Group) {
-			// lower semaphore once the routine's finished, making room for another one to start
-			defer func() { <-semaphore }()
+	bytes, err := json.Marshal(contracts.Build{
+		RepoSource: repo[0],
+		RepoOwner:  repo[1],
+		RepoName:   repo[2],
+		RepoBranch: branch,
+	})
+	if err != nil {
+		return
+	}
 
-			hasMatchingEstafetteGroup := false
-			for _, g := range groups {
-				// check estafette group identities for provider gsuite and id equal to gsuite group email address
-				for _, i := range g.Identities {
-					if i.Provider == gsuiteProviderName && i.ID == gg.Email {
-						hasMatchingEstafetteGroup = true
-					}
-				}
-			}
+	buildsURL := c.endpoints.pipelineBuilds(repo[0], repo[1], repo[2])
+	_, _, err = c.authenticatedRequestWithHeaders(ctx, "POST", buildsURL, span, token, bytes, nil, http.StatusOK, http.StatusCreated)
 
-			if !hasMatchingEstafetteGroup && len(m) > 0 {
-				// no matching group, create one
-
-				newGroup := &contracts.Group{
-					Name: strings.TrimPrefix(gg.Name, c.gsuiteGroupPrefix),
-					Identities: []*contracts.GroupIdentity{
-						{
-							Provider: gsuiteProviderName,
-							ID:       gg.Email,
-							Name:     gg.Name,
-						},
-					},
-				}
+	return
+}
 
-				err = c.createGroup(ctx, token, newGroup)
-				if err != nil {
-					resultChannel <- err
-					return
-				}
-			}
+func (c *apiClient) ApplyActions(ctx context.Context, token string, actions []*Action) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::ApplyActions")
+	defer span.Finish()
+
+	span.LogKV("actions", len(actions))
 
-			resultChannel <- nil
+	// stop starting new mutations once the credentials are rejected, since they'd all fail the same way
+	var aborted int32
 
-		}(ctx, token, gg, m, groups)
+	// a failing tier doesn't stop the next ones, the mutations depending on what failed fail on their own
+	for _, tier := range tierActions(actions) {
+		tierErr := c.applyTier(ctx, token, tier, &aborted)
+		if tierErr != nil && err == nil {
+			err = tierErr
+		}
 	}
 
-	// loop estafette users and check if their groups need to be updates
-	for _, u := range users {
+	return err
+}
+
+// applyTier applies the actions of a single tier in parallel, up to the concurrency at a time, and returns the first error
+func (c *apiClient) applyTier(ctx context.Context, token string, actions []*Action, aborted *int32) error {
+
+	// http://jmoiron.net/blog/limiting-concurrency-in-go/
+	concurrency := c.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	semaphore := make(chan bool, concurrency)
+
+	resultChannel := make(chan error, len(actions))
+
+	for _, a := range actions {
 		// try to fill semaphore up to it's full size otherwise wait for a routine to finish
 		semaphore <- true
 
-		go func(ctx context.Context, token string, user *contracts.User, groups []*contracts.Group, gsuiteGroupMembers map[*admin.Group][]*admin.Member) {
+		if atomic.LoadInt32(aborted) == 1 {
+			<-semaphore
+			a.Err = fmt.Errorf("Skipped action %v after the estafette api rejected the credentials", a)
+			resultChannel <- a.Err
+			continue
+		}
+		if shutdownSignalFromContext(ctx).isRequested() {
+			<-semaphore
+			a.Err = fmt.Errorf("Skipped action %v: %w", a, ErrShutdown)
+			resultChannel <- a.Err
+			continue
+		}
+
+		go func(ctx context.Context, token string, a *Action) {
 			// lower semaphore once the routine's finished, making room for another one to start
 			defer func() { <-semaphore }()
 
-			userGroups, err := c.getGroupsForUser(ctx, user, groups, gsuiteGroupMembers)
-			if err != nil {
-				resultChannel <- err
-				return
+			span, ctx, verbose := startEntitySpan(ctx, "ApiClient::ApplyAction", actionSpanTags(a), actionSpanKeys(a)...)
+			defer span.Finish()
+			if verbose {
+				span.LogKV("action", a.String())
 			}
 
-			dirty := false
-			for _, ug := range userGroups {
-				userHasGroup := false
-				for _, g := range user.Groups {
-					if g.ID == ug.ID {
-						userHasGroup = true
-						if g.Name != ug.Name {
-							g.Name = ug.Name
-							dirty = true
-						}
-					}
-				}
-				if !userHasGroup {
-					user.Groups = append(user.Groups, &contracts.Group{
-						ID:   ug.ID,
-						Name: ug.Name,
-					})
-					dirty = true
+			var err error
+			switch a.Type {
+			case ActionCreateGroup:
+				err = c.createGroup(ctx, token, a.Group)
+				if errors.Is(err, ErrConflict) {
+					err = c.adoptExistingGroup(ctx, token, a)
 				}
+			case ActionUpdateGroup:
+				err = c.updateGroup(ctx, token, a.GroupBefore, a.Group)
+			case ActionDeleteGroup:
+				err = c.deleteGroup(ctx, token, a.Group)
+			case ActionUpdateUser:
+				err = c.updateUser(ctx, token, a.UserBefore, a.User)
+			case ActionCreateOrganization:
+				err = c.createOrganization(ctx, token, a.Organization)
+			case ActionUpdateOrganization:
+				err = c.updateOrganization(ctx, token, a.OrganizationBefore, a.Organization)
+			default:
+				err = fmt.Errorf("Action type %v is not supported", a.Type)
 			}
 
-			// use downward loop to avoid running out of bounds when an item is removed
-			for i := len(user.Groups) - 1; i >= 0; i-- {
-				g := user.Groups[i]
-				isInUserGroups := false
-				for _, ug := range userGroups {
-					if g.ID == ug.ID {
-						isInUserGroups = true
+			// record the mutation, including failed ones
+			if c.auditLogger != nil {
+				auditErr := c.auditLogger.Log(ctx, a, err)
+				if auditErr != nil {
+					logFromContext(ctx).Error().Err(auditErr).Msgf("Failed recording audit entry for action %v", a)
+					if err == nil {
+						err = auditErr
 					}
 				}
-				if !isInUserGroups {
-					// memory-leak safe delete (https://github.com/golang/go/wiki/SliceTricks) without preserving order
-					copy(user.Groups[i:], user.Groups[i+1:])
-					user.Groups[len(user.Groups)-1] = nil // or the zero value of T
-					user.Groups = user.Groups[:len(user.Groups)-1]
-
-					dirty = true
-				}
 			}
 
-			if dirty {
-				err = c.updateUser(ctx, token, user)
-				if err != nil {
-					resultChannel <- err
-					return
-				}
+			if isAbortingError(err) {
+				atomic.StoreInt32(aborted, 1)
+			}
+			if err != nil {
+				ext.Error.Set(span, true)
+				span.LogKV("error", err.Error())
 			}
 
-			resultChannel <- nil
-		}(ctx, token, u, groups, gsuiteGroupMembers)
+			a.Err = err
+			resultChannel <- err
+		}(ctx, token, a)
 	}
 
 	// try to fill semaphore up to it's full size which only succeeds if all routines have finished
@@ -435,128 +657,507 @@ func (c *apiClient) SynchronizeGroupsAndMembers(ctx context.Context, token strin
 	return nil
 }
 
-func (c *apiClient) getGroupsForUser(ctx context.Context, user *contracts.User, groups []*contracts.Group, gsuiteGroupMembers map[*admin.Group][]*admin.Member) (groupsForUser []*contracts.Group, err error) {
+func (c *apiClient) createGroup(ctx context.Context, token string, group *contracts.Group) (err error) {
 
-	groupsForUser = make([]*contracts.Group, 0)
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::createGroup")
+	defer span.Finish()
 
-	for _, g := range groups {
-		for gg, members := range gsuiteGroupMembers {
-			// check estafette group identities for provider gsuite and id equal to gsuite group email address
-			for _, i := range g.Identities {
-				if i.Provider == gsuiteProviderName && i.ID == gg.Email {
-					// check members to see if any of them match one of the users providers
-					for _, m := range members {
-						for _, ui := range user.Identities {
-							if ui.Provider == googleProviderName && ui.ID == m.Id {
-								groupsForUser = append(groupsForUser, g)
-							}
-						}
-					}
-				}
-			}
-		}
+	span.LogKV("group.Name", group.Name)
+
+	bytes, err := json.Marshal(group)
+	if err != nil {
+		return
 	}
 
-	return
+	createGroupURL := c.endpoints.groups()
+
+	return c.createEntity(ctx, span, token, createGroupURL, "group/"+group.Name, bytes)
 }
 
-func (c *apiClient) createGroup(ctx context.Context, token string, group *contracts.Group) (err error) {
+// adoptExistingGroup turns the create of a group that already exists in estafette, for example created by another run or by hand with the same name, into an update attaching the directory identities to the existing group, so the conflict doesn't fail the sync
+func (c *apiClient) adoptExistingGroup(ctx context.Context, token string, a *Action) (err error) {
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::createGroup")
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::adoptExistingGroup")
 	defer span.Finish()
 
-	span.LogKV("group.Name", group.Name)
+	span.LogKV("group.Name", a.Group.Name)
 
-	bytes, err := json.Marshal(group)
+	groups, err := c.GetGroups(ctx, token)
 	if err != nil {
-		return
+		return fmt.Errorf("Failed looking up existing group %v: %w", a.Group.Name, err)
 	}
 
-	createGroupURL := fmt.Sprintf("%v/api/groups", c.apiBaseURL)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", token),
-		"Content-Type":  "application/json",
+	var existing *contracts.Group
+	for _, g := range groups {
+		if strings.EqualFold(g.Name, a.Group.Name) {
+			existing = g
+			break
+		}
 	}
+	if existing == nil {
+		return fmt.Errorf("Failed creating group %v, it conflicts with a group that can't be found: %w", a.Group.Name, ErrConflict)
+	}
+
+	updated := copyGroup(existing)
+	for _, i := range a.Group.Identities {
+		if !hasGroupIdentity(updated, i) {
+			updated.Identities = append(updated.Identities, i)
+		}
+	}
+
+	logFromContext(ctx).Warn().Msgf("Group %v already exists in estafette with id %v, attaching the directory identities to it instead of creating it", existing.Name, existing.ID)
+
+	// the action is recorded as the update it turned into
+	a.Type, a.GroupBefore, a.Group = ActionUpdateGroup, existing, updated
+	if len(updated.Identities) == len(existing.Identities) {
+		return nil
+	}
+
+	return c.updateGroup(ctx, token, existing, updated)
+}
+
+func (c *apiClient) deleteGroup(ctx context.Context, token string, group *contracts.Group) (err error) {
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::deleteGroup")
+	defer span.Finish()
+
+	span.LogKV("group.ID", group.ID, "group.Name", group.Name)
 
-	_, err = c.postRequest(createGroupURL, span, strings.NewReader(string(bytes)), headers, http.StatusCreated)
+	deleteGroupURL := c.endpoints.group(group.ID)
+	_, err = c.mutatingRequest(ctx, "DELETE", deleteGroupURL, span, token, nil, nil, http.StatusOK, http.StatusNoContent)
 
 	return
 }
 
-func (c *apiClient) updateGroup(ctx context.Context, token string, group *contracts.Group) (err error) {
+func (c *apiClient) updateGroup(ctx context.Context, token string, before, group *contracts.Group) (err error) {
 
 	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::updateGroup")
 	defer span.Finish()
 
 	span.LogKV("group.ID", group.ID, "group.Name", group.Name)
 
-	bytes, err := json.Marshal(group)
+	updateGroupURL := c.endpoints.group(group.ID)
+	if before == nil {
+		return c.updateEntity(ctx, span, token, updateGroupURL, nil, group)
+	}
+	return c.updateEntity(ctx, span, token, updateGroupURL, before, group)
+}
+
+func (c *apiClient) updateUser(ctx context.Context, token string, before, user *contracts.User) (err error) {
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::updateUser")
+	defer span.Finish()
+
+	span.LogKV("user.ID", user.ID, "user.Name", user.Name)
+
+	updateUserURL := c.endpoints.user(user.ID)
+	if before == nil {
+		return c.updateEntity(ctx, span, token, updateUserURL, nil, user)
+	}
+
+	if c.useMembershipEndpoints && atomic.LoadInt32(&c.membershipUnsupported) == 0 {
+		applied, err := c.updateMemberships(ctx, span, token, before, user)
+		if err != nil {
+			return err
+		}
+		if applied {
+			// the memberships are up to date, so only the other changes to the user are left
+			withMemberships := copyUser(before)
+			withMemberships.Groups = user.Groups
+			beforeMap, err := toJSONMap(withMemberships)
+			if err != nil {
+				return err
+			}
+			afterMap, err := toJSONMap(user)
+			if err != nil {
+				return err
+			}
+			if len(diffJSONMaps(beforeMap, afterMap)) == 0 {
+				return nil
+			}
+			before = withMemberships
+		}
+	}
+
+	return c.updateEntity(ctx, span, token, updateUserURL, before, user)
+}
+
+// updateMemberships adds the user to and removes it from the groups one by one with the membership endpoints, and returns whether it did; it doesn't if a group is created in the same run and has no id yet, a group is deleted in the meantime, or the api doesn't have the endpoints, in which case the memberships are left for updating the entire user
+func (c *apiClient) updateMemberships(ctx context.Context, span opentracing.Span, token string, before, user *contracts.User) (applied bool, err error) {
+
+	added, removed, ok := membershipDelta(before, user)
+	if !ok {
+		return false, nil
+	}
+
+	span.LogKV("membershipsAdded", len(added), "membershipsRemoved", len(removed))
+
+	for _, id := range added {
+		applied, err = c.sendMembership(ctx, span, token, "PUT", id, user.ID)
+		if !applied || err != nil {
+			return false, err
+		}
+	}
+	for _, id := range removed {
+		applied, err = c.sendMembership(ctx, span, token, "DELETE", id, user.ID)
+		if !applied || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// sendMembership adds or removes a single membership and returns whether it did; it doesn't if the group is deleted in the meantime, or if the api doesn't have the endpoints, in which case they're not used for the rest of the run
+func (c *apiClient) sendMembership(ctx context.Context, span opentracing.Span, token, method, groupID, userID string) (applied bool, err error) {
+
+	_, err = c.mutatingRequest(ctx, method, c.endpoints.groupMember(groupID, userID), span, token, nil, nil, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if errors.Is(err, ErrNotFound) {
+		err = c.checkMembershipRouteMissing(ctx, span, token, groupID, err)
+		if errors.Is(err, ErrNotFound) {
+			// the group is gone, updating the entire user leaves it out of the user's groups
+			logFromContext(ctx).Debug().Msgf("Group %v no longer exists, updating entire user %v instead", groupID, userID)
+			return false, nil
+		}
+	}
+	if errors.Is(err, ErrNotSupported) {
+		if atomic.CompareAndSwapInt32(&c.membershipUnsupported, 0, 1) {
+			logFromContext(ctx).Warn().Msgf("Estafette api doesn't support membership endpoints, falling back to updating entire users")
+		}
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// checkMembershipRouteMissing tells a 404 response to a membership request apart: older apis respond to the unknown route with a 404, so it returns ErrNotSupported if the group can still be retrieved, and the original error if the group is really gone
+func (c *apiClient) checkMembershipRouteMissing(ctx context.Context, span opentracing.Span, token, groupID string, membershipErr error) error {
+	_, err := c.authenticatedRequest(ctx, "GET", c.endpoints.group(groupID), span, token, nil)
+	if err != nil {
+		return membershipErr
+	}
+
+	return fmt.Errorf("Membership request for existing group %v responded with a 404: %w", groupID, ErrNotSupported)
+}
+
+// membershipDelta returns the ids of the groups the update adds the user to and removes it from; ok is false if an added group has no id yet because it's created in the same run
+func membershipDelta(before, after *contracts.User) (added, removed []string, ok bool) {
+	for _, g := range after.Groups {
+		if g.ID == "" {
+			return nil, nil, false
+		}
+		if !userHasGroup(before, g.ID) {
+			added = append(added, g.ID)
+		}
+	}
+	for _, g := range before.Groups {
+		if g.ID != "" && !userHasGroup(after, g.ID) {
+			removed = append(removed, g.ID)
+		}
+	}
+
+	return added, removed, true
+}
+
+func (c *apiClient) createOrganization(ctx context.Context, token string, organization *contracts.Organization) (err error) {
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::createOrganization")
+	defer span.Finish()
+
+	span.LogKV("organization.Name", organization.Name)
+
+	bytes, err := json.Marshal(organization)
 	if err != nil {
 		return
 	}
 
-	updateGroupURL := fmt.Sprintf("%v/api/groups/%v", c.apiBaseURL, group.ID)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", token),
-		"Content-Type":  "application/json",
+	createOrganizationURL := c.endpoints.organizations()
+
+	return c.createEntity(ctx, span, token, createOrganizationURL, "organization/"+organization.Name, bytes)
+}
+
+// createEntity posts the entity with an idempotency key, so a create that's retried after a timeout or lost response doesn't create a duplicate; the api answers a retry of a create it already applied with the entity created by the first attempt
+func (c *apiClient) createEntity(ctx context.Context, span opentracing.Span, token, uri, entity string, requestBody []byte) (err error) {
+
+	key := idempotencyKey(runIDFromContext(ctx), entity, requestBody)
+	span.LogKV("idempotencyKey", key)
+
+	_, responseHeaders, err := c.mutatingRequestWithHeaders(ctx, "POST", uri, span, token, requestBody, map[string]string{idempotencyKeyHeader: key}, http.StatusCreated, http.StatusOK)
+	if err != nil {
+		return
 	}
 
-	_, err = c.putRequest(updateGroupURL, span, strings.NewReader(string(bytes)), headers)
+	if responseHeaders.Get(idempotentReplayedHeader) == "true" {
+		logFromContext(ctx).Info().Msgf("Estafette api detected a duplicate create of %v, it was already created by an earlier attempt", entity)
+	}
 
-	return
+	return nil
 }
 
-func (c *apiClient) updateUser(ctx context.Context, token string, user *contracts.User) (err error) {
+// idempotencyKey derives the key for creating the entity in its desired state in a run, so all attempts of the same create share a key while a later run or a changed entity gets a new one
+func idempotencyKey(runID, entity string, desired []byte) string {
+	hash := sha256.New()
+	for _, part := range [][]byte{[]byte(runID), []byte(entity), desired} {
+		hash.Write(part)
+		hash.Write([]byte{0})
+	}
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::updateUser")
+	return hex.EncodeToString(hash.Sum(nil))[:32]
+}
+
+func (c *apiClient) updateOrganization(ctx context.Context, token string, before, organization *contracts.Organization) (err error) {
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApiClient::updateOrganization")
 	defer span.Finish()
 
-	span.LogKV("user.ID", user.ID, "user.Name", user.Name)
+	span.LogKV("organization.ID", organization.ID, "organization.Name", organization.Name)
+
+	updateOrganizationURL := c.endpoints.organization(organization.ID)
+	if before == nil {
+		return c.updateEntity(ctx, span, token, updateOrganizationURL, nil, organization)
+	}
+	return c.updateEntity(ctx, span, token, updateOrganizationURL, before, organization)
+}
+
+// updateEntity applies the changes between before and after to the entity; with --api-if-match it fetches the current entity first and applies the changes on top of it with an If-Match header, retrying when it's modified concurrently
+func (c *apiClient) updateEntity(ctx context.Context, span opentracing.Span, token, uri string, before, after interface{}) (err error) {
+
+	if !c.useIfMatch || before == nil {
+		return c.sendUpdate(ctx, span, token, uri, before, after, nil)
+	}
+
+	maxConflicts := 3
+	for attempt := 0; ; attempt++ {
+		current, etag, err := c.getEntity(ctx, span, token, uri)
+		if errors.Is(err, ErrNotSupported) {
+			logFromContext(ctx).Warn().Msgf("Estafette api doesn't support fetching %v, updating without If-Match header", uri)
+			return c.sendUpdate(ctx, span, token, uri, before, after, nil)
+		}
+		if err != nil {
+			return err
+		}
 
-	bytes, err := json.Marshal(user)
+		// re-apply the planned changes on the current entity, so concurrent edits to other fields aren't overwritten
+		rebased, err := rebaseUpdate(before, after, current)
+		if err != nil {
+			return err
+		}
+
+		var headers map[string]string
+		if etag != "" {
+			headers = map[string]string{"If-Match": etag}
+		}
+
+		err = c.sendUpdate(ctx, span, token, uri, current, rebased, headers)
+		if !errors.Is(err, ErrConflict) || attempt >= maxConflicts {
+			return err
+		}
+
+		logFromContext(ctx).Warn().Msgf("%v was modified concurrently, re-applying the changes", uri)
+		span.LogKV("conflict", attempt+1)
+	}
+}
+
+// sendUpdate sends only the changed fields as json merge patch if --api-patch is enabled and the api supports it, and otherwise replaces the entire entity with a put request
+func (c *apiClient) sendUpdate(ctx context.Context, span opentracing.Span, token, uri string, before, after interface{}, headers map[string]string) (err error) {
+
+	if c.usePatch && before != nil && atomic.LoadInt32(&c.patchUnsupported) == 0 {
+		patch, err := createMergePatch(before, after)
+		if err != nil {
+			return err
+		}
+
+		span.LogKV("patch", string(patch))
+
+		_, err = c.mutatingRequest(ctx, "PATCH", uri, span, token, patch, headers, http.StatusOK, http.StatusNoContent)
+		if errors.Is(err, ErrNotFound) {
+			err = c.checkPatchRouteMissing(ctx, span, token, uri, err)
+		}
+		if !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+
+		// remember the api doesn't support patch requests, so other updates don't try either
+		if atomic.CompareAndSwapInt32(&c.patchUnsupported, 0, 1) {
+			logFromContext(ctx).Warn().Msgf("Estafette api doesn't support patch requests, falling back to put requests")
+		}
+	}
+
+	bytes, err := json.Marshal(after)
 	if err != nil {
 		return
 	}
 
-	updateUserURL := fmt.Sprintf("%v/api/users/%v", c.apiBaseURL, user.ID)
-	headers := map[string]string{
-		"Authorization": fmt.Sprintf("Bearer %v", token),
-		"Content-Type":  "application/json",
+	_, err = c.mutatingRequest(ctx, "PUT", uri, span, token, bytes, headers)
+
+	return
+}
+
+// checkPatchRouteMissing tells a 404 response to a patch request apart: apis without a patch route respond to it with a 404 as well, so it returns ErrNotSupported if the entity can still be retrieved, and the original error if it's really gone
+func (c *apiClient) checkPatchRouteMissing(ctx context.Context, span opentracing.Span, token, uri string, patchErr error) error {
+	_, err := c.authenticatedRequest(ctx, "GET", uri, span, token, nil)
+	if err != nil {
+		return patchErr
+	}
+
+	return fmt.Errorf("Patch request for existing entity %v responded with a 404: %w", uri, ErrNotSupported)
+}
+
+// getEntity fetches the current json representation of an entity and its etag, if the api returns one
+func (c *apiClient) getEntity(ctx context.Context, span opentracing.Span, token, uri string) (entity map[string]interface{}, etag string, err error) {
+
+	responseBody, responseHeaders, err := c.authenticatedRequestWithHeaders(ctx, "GET", uri, span, token, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entity = map[string]interface{}{}
+	err = json.Unmarshal(responseBody, &entity)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed unmarshalling %v: %w", uri, err)
 	}
 
-	_, err = c.putRequest(updateUserURL, span, strings.NewReader(string(bytes)), headers)
+	return entity, responseHeaders.Get("ETag"), nil
+}
 
+// authenticatedRequest performs a request with the latest token; on a 401 response it logs in again with the client credentials and retries once with the refreshed token
+func (c *apiClient) authenticatedRequest(ctx context.Context, method, uri string, span opentracing.Span, token string, requestBody []byte, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	responseBody, _, err = c.authenticatedRequestWithHeaders(ctx, method, uri, span, token, requestBody, nil, allowedStatusCodes...)
 	return
 }
 
-func (c *apiClient) getRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("GET", uri, span, requestBody, headers, allowedStatusCodes...)
+// authenticatedRequestWithHeaders performs the request like authenticatedRequest, with additional request headers and returning the response headers
+func (c *apiClient) authenticatedRequestWithHeaders(ctx context.Context, method, uri string, span opentracing.Span, token string, requestBody []byte, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, responseHeaders http.Header, err error) {
+
+	token = c.latestToken(token)
+
+	responseBody, responseHeaders, err = c.makeRequestWithResponseHeaders(ctx, method, uri, span, bytes.NewReader(requestBody), c.authenticatedHeaders(method, token, headers), allowedStatusCodes...)
+	if !errors.Is(err, ErrUnauthorized) {
+		return
+	}
+
+	logFromContext(ctx).Warn().Msgf("%v %v responded with status code 401, refreshing token", method, uri)
+
+	token, err = c.refreshToken(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c.makeRequestWithResponseHeaders(ctx, method, uri, span, bytes.NewReader(requestBody), c.authenticatedHeaders(method, token, headers), allowedStatusCodes...)
 }
 
-func (c *apiClient) postRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("POST", uri, span, requestBody, headers, allowedStatusCodes...)
+// mutatingRequest performs an authenticated request through the circuit breaker; while the breaker is open it waits for the cool-down before trying again
+func (c *apiClient) mutatingRequest(ctx context.Context, method, uri string, span opentracing.Span, token string, requestBody []byte, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	responseBody, _, err = c.mutatingRequestWithHeaders(ctx, method, uri, span, token, requestBody, headers, allowedStatusCodes...)
+	return
 }
 
-func (c *apiClient) putRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("PUT", uri, span, requestBody, headers, allowedStatusCodes...)
+// mutatingRequestWithHeaders performs the request like mutatingRequest, but also returns the response headers
+func (c *apiClient) mutatingRequestWithHeaders(ctx context.Context, method, uri string, span opentracing.Span, token string, requestBody []byte, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, responseHeaders http.Header, err error) {
+
+	type response struct {
+		body    []byte
+		headers http.Header
+	}
+
+	maxCooldowns := 3
+	for attempt := 0; ; attempt++ {
+		result, err := c.breaker.Execute(func() (interface{}, error) {
+			responseBody, responseHeaders, err := c.authenticatedRequestWithHeaders(ctx, method, uri, span, token, requestBody, headers, allowedStatusCodes...)
+			return &response{body: responseBody, headers: responseHeaders}, err
+		})
+		if err == nil {
+			return result.(*response).body, result.(*response).headers, nil
+		}
+		if (err != gobreaker.ErrOpenState && err != gobreaker.ErrTooManyRequests) || attempt >= maxCooldowns {
+			return nil, nil, err
+		}
+
+		span.LogKV("breaker", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(c.breakerCooldown):
+		}
+	}
 }
 
-func (c *apiClient) deleteRequest(uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
-	return c.makeRequest("DELETE", uri, span, requestBody, headers, allowedStatusCodes...)
+func (c *apiClient) authenticatedHeaders(method, token string, headers map[string]string) map[string]string {
+	contentType := "application/json"
+	if method == "PATCH" {
+		contentType = "application/merge-patch+json"
+	}
+
+	authenticatedHeaders := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %v", token),
+		"Content-Type":  contentType,
+	}
+	for k, v := range headers {
+		authenticatedHeaders[k] = v
+	}
+
+	return authenticatedHeaders
 }
 
-func (c *apiClient) makeRequest(method, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+// latestToken returns the most recently refreshed token, or the passed token if it hasn't been refreshed
+func (c *apiClient) latestToken(token string) string {
+	c.tokenMutex.Lock()
+	defer c.tokenMutex.Unlock()
+
+	if c.token != "" {
+		return c.token
+	}
+	return token
+}
 
-	// create client, in order to add headers
-	client := pester.NewExtendedClient(&http.Client{Transport: &nethttp.Transport{}})
-	client.MaxRetries = 3
-	client.Backoff = pester.ExponentialJitterBackoff
-	client.KeepLog = true
-	client.Timeout = time.Second * 10
+// refreshToken logs in again unless another routine already refreshed the expired token
+func (c *apiClient) refreshToken(ctx context.Context, expiredToken string) (token string, err error) {
+	c.tokenMutex.Lock()
+	clientID, clientSecret, latestToken := c.clientID, c.clientSecret, c.token
+	c.tokenMutex.Unlock()
 
-	request, err := http.NewRequest(method, uri, requestBody)
+	if clientID == "" {
+		return "", ErrUnauthorized
+	}
+	if latestToken != "" && latestToken != expiredToken {
+		return latestToken, nil
+	}
+
+	return c.GetToken(ctx, clientID, clientSecret)
+}
+
+func (c *apiClient) getRequest(ctx context.Context, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "GET", uri, span, requestBody, headers, allowedStatusCodes...)
+}
+
+func (c *apiClient) postRequest(ctx context.Context, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "POST", uri, span, requestBody, headers, allowedStatusCodes...)
+}
+
+func (c *apiClient) putRequest(ctx context.Context, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "PUT", uri, span, requestBody, headers, allowedStatusCodes...)
+}
+
+func (c *apiClient) deleteRequest(ctx context.Context, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	return c.makeRequest(ctx, "DELETE", uri, span, requestBody, headers, allowedStatusCodes...)
+}
+
+func (c *apiClient) makeRequest(ctx context.Context, method, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, err error) {
+	responseBody, _, err = c.makeRequestWithResponseHeaders(ctx, method, uri, span, requestBody, headers, allowedStatusCodes...)
+	return
+}
+
+// makeRequestWithResponseHeaders performs the request like makeRequest, but also returns the response headers
+func (c *apiClient) makeRequestWithResponseHeaders(ctx context.Context, method, uri string, span opentracing.Span, requestBody io.Reader, headers map[string]string, allowedStatusCodes ...int) (responseBody []byte, responseHeaders http.Header, err error) {
+
+	// use the context so deadlines and cancellation abort the request and its retries, and the retry budget can stop retrying
+	ctx, retries := contextWithRequestRetries(ctx)
+	defer retries.cancel()
+
+	request, err := http.NewRequestWithContext(ctx, method, uri, requestBody)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// add tracing context
@@ -565,15 +1166,26 @@ func (c *apiClient) makeRequest(method, uri string, span opentracing.Span, reque
 	// collect additional information on setting up connections
 	request, ht := nethttp.TraceRequest(span.Tracer(), request)
 
+	// correlate the request with the sync run in the api logs
+	if runID := runIDFromContext(ctx); runID != "" {
+		request.Header.Set(syncRunIDHeader, runID)
+	}
+
 	// add headers
 	for k, v := range headers {
 		request.Header.Add(k, v)
 	}
 
 	// perform actual request
-	response, err := client.Do(request)
+	response, err := c.client.Do(request)
 	if err != nil {
-		return nil, err
+		if retries.wasStopped() {
+			if response != nil {
+				response.Body.Close()
+			}
+			return nil, nil, fmt.Errorf("Failed %v %v: %w", method, uri, ErrRetryBudgetExhausted)
+		}
+		return nil, nil, err
 	}
 	defer response.Body.Close()
 	ht.Finish()
@@ -583,7 +1195,8 @@ func (c *apiClient) makeRequest(method, uri string, span opentracing.Span, reque
 	}
 
 	if !foundation.IntArrayContains(allowedStatusCodes, response.StatusCode) {
-		return nil, fmt.Errorf("%v responded with status code %v", uri, response.StatusCode)
+		errorBody, _ := ioutil.ReadAll(io.LimitReader(response.Body, maxErrorBodyLength))
+		return nil, nil, &HTTPError{Method: method, URI: uri, StatusCode: response.StatusCode, Body: strings.TrimSpace(string(errorBody))}
 	}
 
 	body, err := ioutil.ReadAll(response.Body)
@@ -591,5 +1204,5 @@ func (c *apiClient) makeRequest(method, uri string, span opentracing.Span, reque
 		return
 	}
 
-	return body, nil
+	return body, response.Header, nil
 }
@@ -2,9 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	contracts "github.com/estafette/estafette-ci-contracts"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,7 +28,7 @@ func TestGetToken(t *testing.T) {
 		getBaseURL := os.Getenv("API_BASE_URL")
 		clientID := os.Getenv("CLIENT_ID")
 		clientSecret := os.Getenv("CLIENT_SECRET")
-		client := NewApiClient(getBaseURL, "")
+		client := NewApiClient(getBaseURL, nil, ApiClientOptions{})
 
 		// act
 		token, err := client.GetToken(ctx, clientID, clientSecret)
@@ -40,7 +49,7 @@ func TestGetOrganizations(t *testing.T) {
 		getBaseURL := os.Getenv("API_BASE_URL")
 		clientID := os.Getenv("CLIENT_ID")
 		clientSecret := os.Getenv("CLIENT_SECRET")
-		client := NewApiClient(getBaseURL, "")
+		client := NewApiClient(getBaseURL, nil, ApiClientOptions{})
 		token, err := client.GetToken(ctx, clientID, clientSecret)
 		assert.Nil(t, err)
 
@@ -63,7 +72,7 @@ func TestGetGroups(t *testing.T) {
 		getBaseURL := os.Getenv("API_BASE_URL")
 		clientID := os.Getenv("CLIENT_ID")
 		clientSecret := os.Getenv("CLIENT_SECRET")
-		client := NewApiClient(getBaseURL, "")
+		client := NewApiClient(getBaseURL, nil, ApiClientOptions{})
 		token, err := client.GetToken(ctx, clientID, clientSecret)
 		assert.Nil(t, err)
 
@@ -86,7 +95,7 @@ func TestGetUsers(t *testing.T) {
 		getBaseURL := os.Getenv("API_BASE_URL")
 		clientID := os.Getenv("CLIENT_ID")
 		clientSecret := os.Getenv("CLIENT_SECRET")
-		client := NewApiClient(getBaseURL, "")
+		client := NewApiClient(getBaseURL, nil, ApiClientOptions{})
 		token, err := client.GetToken(ctx, clientID, clientSecret)
 		assert.Nil(t, err)
 
@@ -97,3 +106,590 @@ func TestGetUsers(t *testing.T) {
 		assert.True(t, len(users) > 0)
 	})
 }
+
+func TestGetUsersWithMultiplePages(t *testing.T) {
+	t.Run("FetchesAllPagesWithPageSizeOfApiAndKeepsTheirOrder", func(t *testing.T) {
+
+		var mutex sync.Mutex
+		requestedPages := make([]string, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			page := r.URL.Query().Get("page[number]")
+			mutex.Lock()
+			requestedPages = append(requestedPages, page+"/"+r.URL.Query().Get("page[size]"))
+			mutex.Unlock()
+
+			fmt.Fprintf(w, `{"items":[{"id":"u%v-1"},{"id":"u%v-2"}],"pagination":{"page":%v,"size":2,"totalPages":4,"totalItems":8}}`, page, page, page)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{PageSize: 50})
+
+		// act
+		users, err := client.GetUsers(context.Background(), "token")
+
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{"1/50", "2/2", "3/2", "4/2"}, requestedPages)
+		if assert.Equal(t, 8, len(users)) {
+			assert.Equal(t, "u1-1", users[0].ID)
+			assert.Equal(t, "u3-2", users[5].ID)
+			assert.Equal(t, "u4-2", users[7].ID)
+		}
+	})
+
+	t.Run("ReturnsErrorIfAnyPageFails", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page[number]") == "3" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"items":[{"id":"u1"}],"pagination":{"page":1,"size":1,"totalPages":3,"totalItems":3}}`)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{})
+
+		// act
+		_, err := client.GetUsers(context.Background(), "token")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetGroupsWithTokenRefresh(t *testing.T) {
+	t.Run("RefreshesTokenAndRetriesRequestOn401", func(t *testing.T) {
+
+		logins := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/api/auth/client/login":
+				logins++
+				fmt.Fprintf(w, `{"token":"token-%v"}`, logins)
+			case "/api/groups":
+				if r.Header.Get("Authorization") != "Bearer token-2" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				fmt.Fprint(w, `{"items":[{"id":"g1","name":"platform"}],"pagination":{"page":1,"size":100,"totalPages":1,"totalItems":1}}`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		ctx := context.Background()
+		client := NewApiClient(server.URL, nil, ApiClientOptions{})
+		token, err := client.GetToken(ctx, "id", "secret")
+		assert.Nil(t, err)
+
+		// act
+		groups, err := client.GetGroups(ctx, token)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, logins)
+		assert.Equal(t, 1, len(groups))
+	})
+}
+
+func TestGetGroupsWithRunID(t *testing.T) {
+	t.Run("SendsRunIDHeader", func(t *testing.T) {
+
+		runIDs := make([]string, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			runIDs = append(runIDs, r.Header.Get(syncRunIDHeader))
+			fmt.Fprint(w, `{"items":[],"pagination":{"page":1,"size":100,"totalPages":1,"totalItems":0}}`)
+		}))
+		defer server.Close()
+
+		runID := newRunID()
+		ctx := contextWithRunID(context.Background(), runID)
+		client := NewApiClient(server.URL, nil, ApiClientOptions{})
+
+		// act
+		_, err := client.GetGroups(ctx, "token")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{runID}, runIDs)
+		assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", runID)
+	})
+}
+
+func TestGetGroupsWithTimeout(t *testing.T) {
+	t.Run("FailsRequestTakingLongerThanTimeout", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{Timeout: 50 * time.Millisecond, MaxRetries: 1})
+		start := time.Now()
+
+		// act
+		_, err := client.GetGroups(context.Background(), "token")
+
+		assert.NotNil(t, err)
+		assert.True(t, time.Since(start) < 2*time.Second)
+	})
+}
+
+func TestGetGroupsWithRetryBudget(t *testing.T) {
+	t.Run("StopsRetryingOnceBudgetIsUsedUp", func(t *testing.T) {
+
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		ctx := contextWithRetryBudget(context.Background(), newRetryBudget(2))
+		client := NewApiClient(server.URL, nil, ApiClientOptions{MaxRetries: 5}).(*apiClient)
+		client.client.Backoff = func(retry int) time.Duration { return 0 }
+
+		// act
+		_, err := client.GetGroups(ctx, "token")
+		_, secondErr := client.GetGroups(ctx, "token")
+
+		assert.True(t, errors.Is(err, ErrRetryBudgetExhausted))
+		assert.True(t, errors.Is(secondErr, ErrRetryBudgetExhausted))
+		assert.Equal(t, 4, requests)
+	})
+}
+
+func TestGetGroupsWithRetryAfter(t *testing.T) {
+	t.Run("WaitsAsLongAsApiAsksBeforeRetrying", func(t *testing.T) {
+
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprint(w, `{"items":[{"id":"g1","name":"platform"}],"pagination":{"page":1,"size":100,"totalPages":1,"totalItems":1}}`)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{}).(*apiClient)
+		client.client.Backoff = func(retry int) time.Duration { return 0 }
+		start := time.Now()
+
+		// act
+		groups, err := client.GetGroups(context.Background(), "token")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(groups))
+		assert.Equal(t, 2, requests)
+		assert.True(t, time.Since(start) >= time.Second)
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("ParsesSeconds", func(t *testing.T) {
+
+		// act
+		wait, ok := parseRetryAfter("5", now)
+
+		assert.True(t, ok)
+		assert.Equal(t, 5*time.Second, wait)
+	})
+
+	t.Run("ParsesHTTPDate", func(t *testing.T) {
+
+		// act
+		wait, ok := parseRetryAfter("Mon, 01 Jun 2020 12:00:30 GMT", now)
+
+		assert.True(t, ok)
+		assert.Equal(t, 30*time.Second, wait)
+	})
+
+	t.Run("CapsLongWaits", func(t *testing.T) {
+
+		// act
+		wait, ok := parseRetryAfter("3600", now)
+
+		assert.True(t, ok)
+		assert.Equal(t, maxRetryAfter, wait)
+	})
+
+	t.Run("ReturnsFalseForInvalidValue", func(t *testing.T) {
+
+		// act
+		_, ok := parseRetryAfter("soon", now)
+
+		assert.False(t, ok)
+	})
+}
+
+func TestIsServerError(t *testing.T) {
+	t.Run("ReturnsTrueFor5xxStatusCode", func(t *testing.T) {
+		assert.True(t, isServerError(&HTTPError{Method: "GET", URI: "/api/groups", StatusCode: http.StatusServiceUnavailable}))
+	})
+
+	t.Run("ReturnsFalseFor4xxStatusCode", func(t *testing.T) {
+		assert.False(t, isServerError(&HTTPError{Method: "GET", URI: "/api/groups", StatusCode: http.StatusBadRequest}))
+	})
+
+	t.Run("ReturnsTrueForRateLimitedStatusCode", func(t *testing.T) {
+		assert.True(t, isServerError(&HTTPError{Method: "PUT", URI: "/api/groups/g1", StatusCode: http.StatusTooManyRequests}))
+	})
+
+	t.Run("ReturnsFalseForNotImplementedStatusCode", func(t *testing.T) {
+		assert.False(t, isServerError(&HTTPError{Method: "PATCH", URI: "/api/groups/g1", StatusCode: http.StatusNotImplemented}))
+	})
+
+	t.Run("ReturnsTrueForTransportError", func(t *testing.T) {
+		assert.True(t, isServerError(fmt.Errorf("dial tcp: connection refused")))
+	})
+
+	t.Run("ReturnsFalseForCanceledContext", func(t *testing.T) {
+		assert.False(t, isServerError(context.Canceled))
+	})
+}
+
+func TestUpdateGroupWithPatch(t *testing.T) {
+	t.Run("SendsOnlyChangedFieldsAsMergePatch", func(t *testing.T) {
+
+		var method, contentType, body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			method, contentType, body = r.Method, r.Header.Get("Content-Type"), string(data)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UsePatch: true}).(*apiClient)
+		before := &contracts.Group{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com"}}}
+		after := &contracts.Group{ID: "g1", Name: "platform-team", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com"}}}
+
+		// act
+		err := client.updateGroup(context.Background(), "token", before, after)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "PATCH", method)
+		assert.Equal(t, "application/merge-patch+json", contentType)
+		assert.Equal(t, `{"name":"platform-team"}`, body)
+	})
+
+	t.Run("FallsBackToPutIfPatchIsNotAllowed", func(t *testing.T) {
+
+		methods := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method)
+			if r.Method == "PATCH" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UsePatch: true}).(*apiClient)
+		before := &contracts.Group{ID: "g1", Name: "platform"}
+		after := &contracts.Group{ID: "g1", Name: "platform-team"}
+
+		// act
+		err := client.updateGroup(context.Background(), "token", before, after)
+		assert.Nil(t, err)
+		err = client.updateGroup(context.Background(), "token", before, after)
+		assert.Nil(t, err)
+
+		assert.Equal(t, []string{"PATCH", "PUT", "PUT"}, methods)
+	})
+
+	t.Run("FallsBackToPutIfPatchRouteIsNotFound", func(t *testing.T) {
+
+		methods := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method)
+			if r.Method == "PATCH" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UsePatch: true}).(*apiClient)
+		before := &contracts.Group{ID: "g1", Name: "platform"}
+		after := &contracts.Group{ID: "g1", Name: "platform-team"}
+
+		// act
+		err := client.updateGroup(context.Background(), "token", before, after)
+		assert.Nil(t, err)
+		err = client.updateGroup(context.Background(), "token", before, after)
+		assert.Nil(t, err)
+
+		assert.Equal(t, []string{"PATCH", "GET", "PUT", "PUT"}, methods)
+	})
+
+	t.Run("ReturnsNotFoundIfPatchedEntityIsGone", func(t *testing.T) {
+
+		methods := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			methods = append(methods, r.Method)
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UsePatch: true}).(*apiClient)
+		before := &contracts.Group{ID: "g1", Name: "platform"}
+		after := &contracts.Group{ID: "g1", Name: "platform-team"}
+
+		// act
+		err := client.updateGroup(context.Background(), "token", before, after)
+
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.Equal(t, []string{"PATCH", "GET"}, methods)
+		assert.Equal(t, int32(0), client.patchUnsupported)
+	})
+}
+
+func TestUpdateUserWithMembershipEndpoints(t *testing.T) {
+	t.Run("AddsAndRemovesOnlyChangedMemberships", func(t *testing.T) {
+
+		requests := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UsePatch: true, UseMembershipEndpoints: true}).(*apiClient)
+		before := &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}, {ID: "g2", Name: "release"}}}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}, {ID: "g3", Name: "admins"}}}
+
+		// act
+		err := client.updateUser(context.Background(), "token", before, after)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PUT /api/groups/g3/members/u1", "DELETE /api/groups/g2/members/u1"}, requests)
+	})
+
+	t.Run("UpdatesOtherFieldsAfterMemberships", func(t *testing.T) {
+
+		requests := []string{}
+		var body string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			if r.Method == "PATCH" {
+				data, _ := ioutil.ReadAll(r.Body)
+				body = string(data)
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UsePatch: true, UseMembershipEndpoints: true}).(*apiClient)
+		before := &contracts.User{ID: "u1", Active: true}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}}
+
+		// act
+		err := client.updateUser(context.Background(), "token", before, after)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PUT /api/groups/g1/members/u1", "PATCH /api/users/u1"}, requests)
+		assert.Equal(t, `{"active":null}`, body)
+	})
+
+	t.Run("UpdatesEntireUserIfGroupIsCreatedInSameRun", func(t *testing.T) {
+
+		requests := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UseMembershipEndpoints: true}).(*apiClient)
+		before := &contracts.User{ID: "u1"}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{{Name: "platform"}}}
+
+		// act
+		err := client.updateUser(context.Background(), "token", before, after)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PUT /api/users/u1"}, requests)
+	})
+
+	t.Run("FallsBackToUpdatingEntireUserIfEndpointsAreMissing", func(t *testing.T) {
+
+		requests := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			if strings.Contains(r.URL.Path, "/members/") {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UseMembershipEndpoints: true}).(*apiClient)
+		before := &contracts.User{ID: "u1"}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}}
+
+		// act
+		err := client.updateUser(context.Background(), "token", before, after)
+		assert.Nil(t, err)
+		err = client.updateUser(context.Background(), "token", before, after)
+		assert.Nil(t, err)
+
+		assert.Equal(t, []string{"PUT /api/groups/g1/members/u1", "GET /api/groups/g1", "PUT /api/users/u1", "PUT /api/users/u1"}, requests)
+		assert.Equal(t, int32(1), client.membershipUnsupported)
+	})
+
+	t.Run("FallsBackToUpdatingEntireUserIfEndpointsAreNotAllowed", func(t *testing.T) {
+
+		requests := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			if strings.Contains(r.URL.Path, "/members/") {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UseMembershipEndpoints: true}).(*apiClient)
+		before := &contracts.User{ID: "u1"}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}}
+
+		// act
+		err := client.updateUser(context.Background(), "token", before, after)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PUT /api/groups/g1/members/u1", "PUT /api/users/u1"}, requests)
+		assert.Equal(t, int32(1), client.membershipUnsupported)
+	})
+
+	t.Run("UpdatesEntireUserOnlyForGroupDeletedInTheMeantime", func(t *testing.T) {
+
+		requests := []string{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			if strings.HasPrefix(r.URL.Path, "/api/groups/g1") {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UseMembershipEndpoints: true}).(*apiClient)
+
+		// act
+		err := client.updateUser(context.Background(), "token", &contracts.User{ID: "u1"}, &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}})
+		assert.Nil(t, err)
+		err = client.updateUser(context.Background(), "token", &contracts.User{ID: "u2"}, &contracts.User{ID: "u2", Groups: []*contracts.Group{{ID: "g2", Name: "release"}}})
+		assert.Nil(t, err)
+
+		assert.Equal(t, []string{"PUT /api/groups/g1/members/u1", "GET /api/groups/g1", "PUT /api/users/u1", "PUT /api/groups/g2/members/u2"}, requests)
+		assert.Equal(t, int32(0), client.membershipUnsupported)
+	})
+}
+
+func TestCreateMergePatch(t *testing.T) {
+	t.Run("SetsRemovedFieldsToNull", func(t *testing.T) {
+
+		operator := "operator"
+		before := &contracts.Group{ID: "g1", Name: "platform", Roles: []*string{&operator}}
+		after := &contracts.Group{ID: "g1", Name: "platform"}
+
+		// act
+		patch, err := createMergePatch(before, after)
+
+		assert.Nil(t, err)
+		assert.Equal(t, `{"roles":null}`, string(patch))
+	})
+}
+
+func TestUpdateGroupWithIfMatch(t *testing.T) {
+	t.Run("ReappliesChangesOnTopOfConcurrentModification", func(t *testing.T) {
+
+		gets := 0
+		var ifMatches, bodies []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "GET":
+				gets++
+				// the group gets a role through the ui between fetching and updating
+				w.Header().Set("ETag", fmt.Sprintf(`"v%v"`, gets))
+				fmt.Fprint(w, `{"id":"g1","name":"platform","roles":["administrator"]}`)
+			case "PUT":
+				data, _ := ioutil.ReadAll(r.Body)
+				ifMatches = append(ifMatches, r.Header.Get("If-Match"))
+				bodies = append(bodies, string(data))
+				if len(ifMatches) == 1 {
+					w.WriteHeader(http.StatusPreconditionFailed)
+				}
+			}
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{UseIfMatch: true}).(*apiClient)
+		before := &contracts.Group{ID: "g1", Name: "platform"}
+		after := &contracts.Group{ID: "g1", Name: "platform-team"}
+
+		// act
+		err := client.updateGroup(context.Background(), "token", before, after)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{`"v1"`, `"v2"`}, ifMatches)
+		assert.Equal(t, `{"id":"g1","name":"platform-team","roles":["administrator"]}`, bodies[1])
+	})
+}
+
+func TestCreateGroupWithIdempotencyKey(t *testing.T) {
+	t.Run("DoesNotCreateDuplicateIfRetriedAfterLostResponse", func(t *testing.T) {
+
+		created := map[string]bool{}
+		keys := make([]string, 0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyKeyHeader)
+			keys = append(keys, key)
+			if created[key] {
+				w.Header().Set(idempotentReplayedHeader, "true")
+				fmt.Fprint(w, `{"id":"g1","name":"platform"}`)
+				return
+			}
+			// the group gets created, but the response doesn't make it back to the syncer
+			created[key] = true
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{Backoff: "default"}).(*apiClient)
+		ctx := contextWithRunID(context.Background(), newRunID())
+
+		// act
+		err := client.createGroup(ctx, "token", &contracts.Group{Name: "platform"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(created))
+		if assert.Equal(t, 2, len(keys)) {
+			assert.Equal(t, keys[0], keys[1])
+		}
+	})
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	t.Run("DiffersPerRunEntityAndDesiredState", func(t *testing.T) {
+
+		// act
+		key := idempotencyKey("run-1", "group/platform", []byte(`{"name":"platform"}`))
+
+		assert.Equal(t, 32, len(key))
+		assert.Equal(t, key, idempotencyKey("run-1", "group/platform", []byte(`{"name":"platform"}`)))
+		assert.NotEqual(t, key, idempotencyKey("run-2", "group/platform", []byte(`{"name":"platform"}`)))
+		assert.NotEqual(t, key, idempotencyKey("run-1", "group/release", []byte(`{"name":"platform"}`)))
+		assert.NotEqual(t, key, idempotencyKey("run-1", "group/platform", []byte(`{"name":"platform","roles":["operator"]}`)))
+	})
+}
+
+func TestHTTPError(t *testing.T) {
+	t.Run("WrapsSentinelErrorForStatusCode", func(t *testing.T) {
+
+		// act
+		err := fmt.Errorf("Failed updating group: %w", &HTTPError{Method: "PUT", URI: "/api/groups/g1", StatusCode: http.StatusNotFound, Body: "group g1 does not exist"})
+
+		assert.True(t, errors.Is(err, ErrNotFound))
+		assert.False(t, errors.Is(err, ErrServerError))
+		assert.Equal(t, "Failed updating group: PUT /api/groups/g1 responded with status code 404: group g1 does not exist", err.Error())
+	})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// how responses of the estafette-ci-api with fields the syncer doesn't know are handled, selectable with --api-unknown-fields
+const (
+	apiUnknownFieldsIgnore = "ignore"
+	// apiUnknownFieldsLog logs every unknown field once, so a newer api is noticed before it changes a field the syncer relies on
+	apiUnknownFieldsLog = "log"
+	// apiUnknownFieldsFail fails decoding the response, for testing the syncer against a new api version
+	apiUnknownFieldsFail = "fail"
+)
+
+// ErrUnknownFields is returned for responses with fields the syncer doesn't know with --api-unknown-fields=fail
+var ErrUnknownFields = errors.New("unknown fields")
+
+// decodeResponse unmarshals the response body into value and handles the fields the syncer doesn't know according to --api-unknown-fields; unknown fields are logged once per client, since every page of a list repeats them
+func (c *apiClient) decodeResponse(ctx context.Context, uri string, responseBody []byte, value interface{}) error {
+	err := json.Unmarshal(responseBody, value)
+	if err != nil || c.unknownFields == apiUnknownFieldsIgnore || c.unknownFields == "" {
+		return err
+	}
+
+	unknown, err := unknownJSONFields(responseBody, reflect.TypeOf(value))
+	if err != nil || len(unknown) == 0 {
+		return err
+	}
+	if c.unknownFields == apiUnknownFieldsFail {
+		return fmt.Errorf("Failed decoding %v: %w %v; the estafette-ci-api is newer than this syncer", uri, ErrUnknownFields, strings.Join(unknown, ", "))
+	}
+
+	for _, f := range unknown {
+		if _, reported := c.reportedUnknownFields.LoadOrStore(f, true); !reported {
+			logFromContext(ctx).Warn().Msgf("Estafette api responded with field %v the syncer doesn't know, it may be newer than this syncer", f)
+		}
+	}
+
+	return nil
+}
+
+// unknownJSONFields returns the paths of the fields in the json that the type has no field for, like items[].identities[].verified; fields are matched case-insensitively like encoding/json does
+func unknownJSONFields(data []byte, t reflect.Type) ([]string, error) {
+	var raw interface{}
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	unknown := map[string]bool{}
+	collectUnknownJSONFields(raw, t, "", unknown)
+
+	fields := make([]string, 0, len(unknown))
+	for f := range unknown {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	return fields, nil
+}
+
+func collectUnknownJSONFields(raw interface{}, t reflect.Type, path string, unknown map[string]bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Map:
+			for key, nested := range v {
+				collectUnknownJSONFields(nested, t.Elem(), joinJSONPath(path, key), unknown)
+			}
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for key, nested := range v {
+				field, ok := fields[strings.ToLower(key)]
+				if !ok {
+					unknown[joinJSONPath(path, key)] = true
+					continue
+				}
+				collectUnknownJSONFields(nested, field.Type, joinJSONPath(path, key), unknown)
+			}
+		}
+
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for _, nested := range v {
+				collectUnknownJSONFields(nested, t.Elem(), path+"[]", unknown)
+			}
+		}
+	}
+}
+
+// jsonFields returns the fields of the struct type by their lowercased json name, including the ones of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				for n, ef := range jsonFields(embedded) {
+					fields[n] = ef
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = f
+	}
+
+	return fields
+}
+
+func joinJSONPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
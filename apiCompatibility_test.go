@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnknownJSONFields(t *testing.T) {
+	t.Run("ReturnsPathsOfFieldsTheTypeDoesNotHave", func(t *testing.T) {
+
+		data := []byte(`{"items":[{"id":"g1","name":"platform","archived":true,"identities":[{"provider":"gsuite","id":"0abc","verified":true}]},{"id":"g2","archived":false}],"pagination":{"page":1,"cursor":"abc"}}`)
+
+		// act
+		fields, err := unknownJSONFields(data, reflect.TypeOf(&estafetteGroupsPage{}))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"items[].archived", "items[].identities[].verified", "pagination.cursor"}, fields)
+	})
+
+	t.Run("MatchesFieldsCaseInsensitively", func(t *testing.T) {
+
+		data := []byte(`{"Items":[{"ID":"g1","Name":"platform"}]}`)
+
+		// act
+		fields, err := unknownJSONFields(data, reflect.TypeOf(&estafetteGroupsPage{}))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, fields)
+	})
+}
+
+func TestDecodeResponse(t *testing.T) {
+	t.Run("FailsForUnknownFieldsInFailMode", func(t *testing.T) {
+
+		client := &apiClient{unknownFields: apiUnknownFieldsFail}
+		var page estafetteUsersPage
+
+		// act
+		err := client.decodeResponse(context.Background(), "/api/users", []byte(`{"items":[{"id":"u1","lastLogin":"2020-06-01"}]}`), &page)
+
+		assert.True(t, errors.Is(err, ErrUnknownFields))
+		assert.Contains(t, err.Error(), "items[].lastLogin")
+	})
+
+	t.Run("DecodesResponseWithUnknownFieldsInLogMode", func(t *testing.T) {
+
+		client := &apiClient{unknownFields: apiUnknownFieldsLog}
+		var page estafetteUsersPage
+
+		// act
+		err := client.decodeResponse(context.Background(), "/api/users", []byte(`{"items":[{"id":"u1","lastLogin":"2020-06-01"}]}`), &page)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(page.Items)) {
+			assert.Equal(t, "u1", page.Items[0].ID)
+		}
+		_, reported := client.reportedUnknownFields.Load("items[].lastLogin")
+		assert.True(t, reported)
+	})
+}
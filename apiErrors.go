@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// sentinel errors wrapped by HTTPError, to check the class of an api failure with errors.Is
+var (
+	// ErrUnauthorized is returned for 401 responses, when the token is invalid or expired
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned for 403 responses, when the client lacks the roles for the request
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is returned for 404 responses, for example when an entity was deleted since it was fetched
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned for 409 and 412 responses, when the entity was modified since it was fetched
+	ErrConflict = errors.New("conflict")
+	// ErrNotSupported is returned for 405 and 501 responses, when the api doesn't support the method
+	ErrNotSupported = errors.New("not supported")
+	// ErrRateLimited is returned for 429 responses
+	ErrRateLimited = errors.New("rate limited")
+	// ErrServerError is returned for 5xx responses
+	ErrServerError = errors.New("server error")
+)
+
+// maxErrorBodyLength limits how much of the response body is kept in an HTTPError
+const maxErrorBodyLength = 1024
+
+// HTTPError is returned when the api responds with a status code that isn't allowed for the request
+type HTTPError struct {
+	Method     string
+	URI        string
+	StatusCode int
+	// Body holds the start of the response body, which usually explains the failure
+	Body string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%v %v responded with status code %v", e.Method, e.URI, e.StatusCode)
+	}
+	return fmt.Sprintf("%v %v responded with status code %v: %v", e.Method, e.URI, e.StatusCode, e.Body)
+}
+
+// Unwrap returns the sentinel error for the class of the status code, if any
+func (e *HTTPError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case e.StatusCode == http.StatusConflict, e.StatusCode == http.StatusPreconditionFailed:
+		return ErrConflict
+	case e.StatusCode == http.StatusMethodNotAllowed, e.StatusCode == http.StatusNotImplemented:
+		return ErrNotSupported
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrServerError
+	}
+	return nil
+}
+
+// isServerError returns true for errors indicating the api is failing or overloaded, as opposed to errors caused by the request itself
+func isServerError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		// the api doesn't implement the method, retrying won't help
+		if errors.Is(err, ErrNotSupported) {
+			return false
+		}
+		return errors.Is(err, ErrServerError) || errors.Is(err, ErrRateLimited)
+	}
+
+	// transport errors and timeouts
+	return true
+}
+
+// isAbortingError returns true for errors after which no other mutation can succeed either, because the credentials are rejected
+func isAbortingError(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden)
+}
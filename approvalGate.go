@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/opentracing-contrib/go-stdlib/nethttp"
+	"github.com/opentracing/opentracing-go"
+)
+
+// ApprovalStatus is the outcome of requesting approval for destructive actions
+type ApprovalStatus string
+
+const (
+	ApprovalPending  ApprovalStatus = "pending"
+	ApprovalApproved ApprovalStatus = "approved"
+	ApprovalRejected ApprovalStatus = "rejected"
+)
+
+var (
+	// ErrAwaitingApproval is set on destructive actions that weren't applied because they're still awaiting approval
+	ErrAwaitingApproval = errors.New("awaiting approval")
+	// ErrApprovalRejected is set on destructive actions that weren't applied because their approval was rejected
+	ErrApprovalRejected = errors.New("approval rejected")
+)
+
+// ApprovalGate requests approval for destructive actions before they're applied, while the other actions of a sync are applied right away
+type ApprovalGate interface {
+	RequestApproval(ctx context.Context, runID string, actions []*Action) (ApprovalStatus, error)
+}
+
+// approvalRequest is the json body posted to the approval webhook
+type approvalRequest struct {
+	RunID    string          `json:"runID"`
+	Provider string          `json:"provider"`
+	Actions  []*ReportAction `json:"actions"`
+}
+
+// approvalResponse is the json body the approval webhook responds with, and its status url returns while the approval is pending
+type approvalResponse struct {
+	Status    ApprovalStatus `json:"status"`
+	StatusURL string         `json:"statusURL,omitempty"`
+}
+
+// newApprovalGate returns the approval gate configured with the flags, or nil if destructive actions don't need approval
+func newApprovalGate() (ApprovalGate, error) {
+	switch {
+	case *approvalWebhookURL != "" && *approvalPlanFile != "":
+		return nil, fmt.Errorf("--approval-webhook-url and --approval-plan-file can't be used together")
+	case *approvalWebhookURL != "":
+		return NewWebhookApprovalGate(*approvalWebhookURL, *approvalPollInterval, *approvalTimeout), nil
+	case *approvalPlanFile != "":
+		if *planSigningKey == "" {
+			return nil, fmt.Errorf("--approval-plan-file needs --plan-signing-key to sign the plan")
+		}
+		return NewPlanFileApprovalGate(*approvalPlanFile, *planSigningKey), nil
+	}
+
+	return nil, nil
+}
+
+// NewWebhookApprovalGate returns an ApprovalGate posting the destructive actions to an approval system and polling the status url it responds with until they're approved or rejected, or the timeout expires
+func NewWebhookApprovalGate(url string, pollInterval, timeout time.Duration) ApprovalGate {
+	return &webhookApprovalGate{
+		url:          url,
+		pollInterval: pollInterval,
+		timeout:      timeout,
+		client:       &http.Client{Transport: &nethttp.Transport{RoundTripper: sharedRoundTripper()}, Timeout: 30 * time.Second},
+	}
+}
+
+type webhookApprovalGate struct {
+	url          string
+	pollInterval time.Duration
+	timeout      time.Duration
+	client       *http.Client
+}
+
+func (g *webhookApprovalGate) RequestApproval(ctx context.Context, runID string, actions []*Action) (status ApprovalStatus, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "WebhookApprovalGate::RequestApproval")
+	defer span.Finish()
+
+	span.LogKV("actions", len(actions))
+
+	body, err := json.Marshal(&approvalRequest{RunID: runID, Provider: *provider, Actions: newReport(&SyncRun{Actions: actions}).Actions})
+	if err != nil {
+		return
+	}
+
+	response, err := g.do(ctx, http.MethodPost, g.url, body)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	for response.Status == ApprovalPending {
+		if response.StatusURL == "" {
+			return "", fmt.Errorf("Approval webhook responded with a pending approval without status url")
+		}
+
+		logFromContext(ctx).Info().Msgf("Waiting for approval of %v destructive actions at %v", len(actions), response.StatusURL)
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("No approval within --approval-timeout of %v: %w", g.timeout, ctx.Err())
+		case <-time.After(g.pollInterval):
+		}
+
+		statusURL := response.StatusURL
+		response, err = g.do(ctx, http.MethodGet, statusURL, nil)
+		if err != nil {
+			return
+		}
+		if response.StatusURL == "" {
+			response.StatusURL = statusURL
+		}
+	}
+
+	switch response.Status {
+	case ApprovalApproved, ApprovalRejected:
+		return response.Status, nil
+	}
+
+	return "", fmt.Errorf("Approval webhook responded with unknown status %v", response.Status)
+}
+
+func (g *webhookApprovalGate) do(ctx context.Context, method, url string, body []byte) (*approvalResponse, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := g.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("Failed requesting approval from %v: %w", url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("Failed requesting approval from %v, status code %v", url, response.StatusCode)
+	}
+
+	var approval approvalResponse
+	if err = json.NewDecoder(response.Body).Decode(&approval); err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling approval response from %v: %w", url, err)
+	}
+
+	return &approval, nil
+}
+
+// NewPlanFileApprovalGate returns an ApprovalGate writing the destructive actions to a signed plan file, to be applied with the apply command once approved, for instance in a pipeline stage behind an estafette manual gate
+func NewPlanFileApprovalGate(path, signingKey string) ApprovalGate {
+	return &planFileApprovalGate{
+		path:       path,
+		signingKey: signingKey,
+	}
+}
+
+type planFileApprovalGate struct {
+	path       string
+	signingKey string
+}
+
+func (g *planFileApprovalGate) RequestApproval(ctx context.Context, runID string, actions []*Action) (ApprovalStatus, error) {
+	plan := &PlanFile{RunID: runID, Provider: *provider, CreatedAt: time.Now().UTC(), Actions: actions}
+	if err := writePlanFile(g.path, g.signingKey, plan); err != nil {
+		return "", err
+	}
+
+	logFromContext(ctx).Info().Msgf("Wrote %v destructive actions to %v, apply them with the apply command once approved", len(actions), g.path)
+
+	return ApprovalPending, nil
+}
+
+// splitDestructiveActions splits the actions into the ones that can be applied right away and the ones that need approval: deleted groups and removed group memberships; user updates that add memberships as well are split in two, so the additions don't wait for approval
+func splitDestructiveActions(actions []*Action) (immediate, destructive []*Action) {
+	for _, a := range actions {
+		switch a.Type {
+		case ActionDeleteGroup:
+			destructive = append(destructive, a)
+
+		case ActionUpdateUser:
+			_, removed := diffGroupNames(a.UserBefore.Groups, a.User.Groups)
+			if len(removed) == 0 {
+				immediate = append(immediate, a)
+				continue
+			}
+
+			// keep the removed memberships and the roles revoked along with them in the immediate update, and remove them in a second update on top of it
+			kept := copyUser(a.User)
+			kept.Groups = copyUser(a.UserBefore).Groups
+			for _, g := range a.User.Groups {
+				if !userInGroup(a.UserBefore, g.ID) {
+					kept.Groups = append(kept.Groups, g)
+				}
+			}
+			for _, r := range revokedRoles(a.UserBefore, a.User) {
+				role := r
+				kept.Roles = append(kept.Roles, &role)
+			}
+			beforeMap, _ := toJSONMap(a.UserBefore)
+			keptMap, _ := toJSONMap(kept)
+			if !reflect.DeepEqual(beforeMap, keptMap) {
+				immediate = append(immediate, &Action{Type: ActionUpdateUser, UserBefore: a.UserBefore, User: kept})
+				destructive = append(destructive, &Action{Type: ActionUpdateUser, UserBefore: kept, User: a.User})
+				continue
+			}
+			destructive = append(destructive, a)
+
+		default:
+			immediate = append(immediate, a)
+		}
+	}
+
+	return
+}
+
+// applyApprovedActions requests approval for the destructive actions and applies them once approved; actions that aren't applied get ErrAwaitingApproval or ErrApprovalRejected set, which doesn't fail the sync
+func applyApprovedActions(ctx context.Context, gate ApprovalGate, apiClient ApiClient, token string, actions []*Action) error {
+	status, err := gate.RequestApproval(ctx, runIDFromContext(ctx), actions)
+	if err != nil {
+		for _, a := range actions {
+			a.Err = fmt.Errorf("Skipped action %v, requesting approval failed: %w", a, err)
+		}
+		return fmt.Errorf("Failed requesting approval for %v destructive actions: %w", len(actions), err)
+	}
+
+	switch status {
+	case ApprovalApproved:
+		logFromContext(ctx).Info().Msgf("Applying %v approved destructive actions", len(actions))
+		return apiClient.ApplyActions(ctx, token, actions)
+	case ApprovalRejected:
+		logFromContext(ctx).Warn().Msgf("Approval of %v destructive actions was rejected, not applying them", len(actions))
+		err = ErrApprovalRejected
+	default:
+		logFromContext(ctx).Info().Msgf("%v destructive actions are awaiting approval", len(actions))
+		err = ErrAwaitingApproval
+	}
+
+	for _, a := range actions {
+		a.Err = err
+	}
+
+	return nil
+}
+
+// userInGroup checks whether the user is a member of the group with the id
+func userInGroup(user *contracts.User, groupID string) bool {
+	for _, g := range user.Groups {
+		if g.ID == groupID {
+			return true
+		}
+	}
+
+	return false
+}
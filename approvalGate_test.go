@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitDestructiveActions(t *testing.T) {
+	t.Run("DefersDeletedGroups", func(t *testing.T) {
+
+		group := &contracts.Group{ID: "1", Name: "team"}
+		actions := []*Action{
+			{Type: ActionCreateGroup, Group: &contracts.Group{Name: "other-team"}},
+			{Type: ActionDeleteGroup, GroupBefore: group, Group: group},
+		}
+
+		// act
+		immediate, destructive := splitDestructiveActions(actions)
+
+		assert.Equal(t, []*Action{actions[0]}, immediate)
+		assert.Equal(t, []*Action{actions[1]}, destructive)
+	})
+
+	t.Run("SplitsUserUpdateAddingAndRemovingMemberships", func(t *testing.T) {
+
+		team := &contracts.Group{ID: "1", Name: "team"}
+		otherTeam := &contracts.Group{ID: "2", Name: "other-team"}
+		before := &contracts.User{ID: "u1", Groups: []*contracts.Group{team}}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{otherTeam}}
+		actions := []*Action{{Type: ActionUpdateUser, UserBefore: before, User: after}}
+
+		// act
+		immediate, destructive := splitDestructiveActions(actions)
+
+		if assert.Equal(t, 1, len(immediate)) {
+			assert.Equal(t, before, immediate[0].UserBefore)
+			assert.Equal(t, []*contracts.Group{team, otherTeam}, immediate[0].User.Groups)
+		}
+		if assert.Equal(t, 1, len(destructive)) {
+			assert.Equal(t, immediate[0].User, destructive[0].UserBefore)
+			assert.Equal(t, after, destructive[0].User)
+		}
+	})
+
+	t.Run("DefersRolesRevokedWithRemovedMembership", func(t *testing.T) {
+
+		admin := "administrator"
+		operator := "operator"
+		admins := &contracts.Group{ID: "1", Name: "admins", Roles: []*string{&admin}}
+		team := &contracts.Group{ID: "2", Name: "team"}
+		before := &contracts.User{ID: "u1", Groups: []*contracts.Group{admins}, Roles: []*string{&admin}}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{team}, Roles: []*string{&operator}}
+		actions := []*Action{{Type: ActionUpdateUser, UserBefore: before, User: after}}
+
+		// act
+		immediate, destructive := splitDestructiveActions(actions)
+
+		if assert.Equal(t, 1, len(immediate)) {
+			assert.Equal(t, []*contracts.Group{admins, team}, immediate[0].User.Groups)
+			assert.Equal(t, []*string{&operator, &admin}, immediate[0].User.Roles)
+			assert.Equal(t, []string(nil), revokedRoles(before, immediate[0].User))
+		}
+		if assert.Equal(t, 1, len(destructive)) {
+			assert.Equal(t, []string{"administrator"}, revokedRoles(destructive[0].UserBefore, destructive[0].User))
+			assert.Equal(t, after, destructive[0].User)
+		}
+	})
+
+	t.Run("DefersUserUpdateOnlyRemovingMemberships", func(t *testing.T) {
+
+		team := &contracts.Group{ID: "1", Name: "team"}
+		actions := []*Action{{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1", Groups: []*contracts.Group{team}}, User: &contracts.User{ID: "u1"}}}
+
+		// act
+		immediate, destructive := splitDestructiveActions(actions)
+
+		assert.Equal(t, 0, len(immediate))
+		assert.Equal(t, actions, destructive)
+	})
+}
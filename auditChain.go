@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ActionRunSummary is the action of the audit entry closing the entries of a run, signed with --audit-log-signing-key-file
+const ActionRunSummary ActionType = "run-summary"
+
+// auditEntryHash returns the sha256 of the entry without its hash and signature; since the entry holds the hash of the previous one, changing or removing any entry breaks the chain after it
+func auditEntryHash(entry *AuditEntry) (string, error) {
+	unhashed := *entry
+	unhashed.Hash = ""
+	unhashed.Signature = ""
+
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", fmt.Errorf("Failed marshalling audit entry: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// chainAuditEntry links the entry to the previous one and sets its hash
+func chainAuditEntry(entry *AuditEntry, previousHash string) (err error) {
+	entry.PreviousHash = previousHash
+	entry.Hash, err = auditEntryHash(entry)
+
+	return
+}
+
+// lastAuditHash returns the hash of the last entry in an existing audit log, so the entries of a new run continue its chain
+func lastAuditHash(r io.Reader) (string, error) {
+	hash := ""
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry AuditEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				return "", fmt.Errorf("Failed unmarshalling audit entry: %w", jsonErr)
+			}
+			hash = entry.Hash
+		}
+		if err == io.EOF {
+			return hash, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// readAuditSigningKey reads the pem encoded pkcs8 ed25519 private key to sign run summaries with, or returns nil if the path is empty
+func readAuditSigningKey(path string) (ed25519.PrivateKey, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed parsing audit log signing key %v: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("Audit log signing key %v isn't an ed25519 key", path)
+	}
+
+	return privateKey, nil
+}
+
+// readAuditPublicKey reads the pem encoded pkix ed25519 public key to verify run summaries with
+func readAuditPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEMFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed parsing audit log public key %v: %w", path, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("Audit log public key %v isn't an ed25519 key", path)
+	}
+
+	return publicKey, nil
+}
+
+func readPEMFile(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading %v: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%v isn't pem encoded", path)
+	}
+
+	return block, nil
+}
+
+// signAuditSummary signs the hash of the run summary, which covers the entire chain before it
+func signAuditSummary(entry *AuditEntry, key ed25519.PrivateKey) {
+	entry.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(entry.Hash)))
+}
+
+// AuditVerification is the result of verifying an audit log
+type AuditVerification struct {
+	Entries    int
+	SignedRuns int
+	// UnsignedEntries is the number of entries after the last verified run summary, which are only protected by the hash chain
+	UnsignedEntries int
+}
+
+// VerifyAuditLog checks the hash chain of the audit log entries and, if a public key is given, the signatures of the run summaries; it returns an error for the first entry that was altered, removed or inserted
+func VerifyAuditLog(r io.Reader, publicKey ed25519.PublicKey) (*AuditVerification, error) {
+
+	verification := &AuditVerification{}
+	previousHash := ""
+	runEntries := 0
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry AuditEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				return verification, fmt.Errorf("Line %v isn't an audit entry: %w", lineNumber, jsonErr)
+			}
+
+			// entries written before hash chaining was added have no hash, but once the chain started every entry needs one
+			if entry.Hash == "" && previousHash != "" {
+				return verification, fmt.Errorf("Line %v has no hash, but follows chained entries", lineNumber)
+			}
+			if entry.Hash != "" {
+				if entry.PreviousHash != previousHash {
+					return verification, fmt.Errorf("Line %v doesn't follow the entry before it, entries were removed or inserted", lineNumber)
+				}
+				hash, hashErr := auditEntryHash(&entry)
+				if hashErr != nil {
+					return verification, hashErr
+				}
+				if hash != entry.Hash {
+					return verification, fmt.Errorf("Line %v was altered, its hash doesn't match", lineNumber)
+				}
+			}
+			previousHash = entry.Hash
+
+			if entry.Action == ActionRunSummary {
+				if publicKey != nil {
+					signature, decodeErr := base64.StdEncoding.DecodeString(entry.Signature)
+					if decodeErr != nil || !ed25519.Verify(publicKey, []byte(entry.Hash), signature) {
+						return verification, fmt.Errorf("Line %v isn't signed with the audit log signing key", lineNumber)
+					}
+					verification.SignedRuns++
+					runEntries = 0
+				}
+			} else {
+				verification.Entries++
+				runEntries++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return verification, err
+		}
+	}
+
+	verification.UnsignedEntries = runEntries
+
+	return verification, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSignedAuditLog logs two runs of two actions each to an audit log file signed with a new key, and returns the file and the public key
+func writeSignedAuditLog(t *testing.T, dir string) (string, ed25519.PublicKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	assert.Nil(t, err)
+	keyFile := filepath.Join(dir, "key.pem")
+	assert.Nil(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+
+	path := filepath.Join(dir, "audit.jsonl")
+	for _, runID := range []string{"run-1", "run-2"} {
+		ctx := contextWithRunID(context.Background(), runID)
+		auditLogger, err := NewAuditLogger(ctx, path, keyFile, "test")
+		assert.Nil(t, err)
+		assert.Nil(t, auditLogger.Log(ctx, &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "team"}}, nil))
+		assert.Nil(t, auditLogger.Log(ctx, &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "other-team"}}, nil))
+		assert.Nil(t, auditLogger.Close(ctx))
+	}
+
+	return path, publicKey
+}
+
+func TestVerifyAuditLog(t *testing.T) {
+	t.Run("VerifiesChainAndSignaturesAcrossRuns", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "audit")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path, publicKey := writeSignedAuditLog(t, dir)
+		data, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+
+		// act
+		verification, err := VerifyAuditLog(bytes.NewReader(data), publicKey)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 4, verification.Entries)
+		assert.Equal(t, 2, verification.SignedRuns)
+		assert.Equal(t, 0, verification.UnsignedEntries)
+	})
+
+	t.Run("ReturnsErrorForAlteredEntry", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "audit")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path, publicKey := writeSignedAuditLog(t, dir)
+		data, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		altered := strings.Replace(string(data), `"other-team"`, `"admins"`, 1)
+
+		// act
+		_, err = VerifyAuditLog(strings.NewReader(altered), publicKey)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "Line 2")
+	})
+
+	t.Run("ReturnsErrorForRemovedEntry", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "audit")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path, publicKey := writeSignedAuditLog(t, dir)
+		data, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		lines := strings.SplitAfter(string(data), "\n")
+		removed := strings.Join(append(lines[:1], lines[2:]...), "")
+
+		// act
+		_, err = VerifyAuditLog(strings.NewReader(removed), publicKey)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "Line 2")
+	})
+
+	t.Run("ReturnsErrorForSummarySignedWithAnotherKey", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "audit")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path, _ := writeSignedAuditLog(t, dir)
+		data, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		otherPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+		assert.Nil(t, err)
+
+		// act
+		_, err = VerifyAuditLog(bytes.NewReader(data), otherPublicKey)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "Line 3")
+	})
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	bigquery "google.golang.org/api/bigquery/v2"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// AuditEntry records a single mutation with the entity before and after applying it
+type AuditEntry struct {
+	Time        time.Time       `json:"time"`
+	RunID       string          `json:"runID,omitempty"`
+	TriggeredBy string          `json:"triggeredBy"`
+	Action      ActionType      `json:"action"`
+	EntityType  string          `json:"entityType"`
+	EntityID    string          `json:"entityID,omitempty"`
+	EntityName  string          `json:"entityName"`
+	Before      json.RawMessage `json:"before,omitempty"`
+	After       json.RawMessage `json:"after,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	// RevokedRoles are the roles taken from the user along with the groups that granted them
+	RevokedRoles []string `json:"revokedRoles,omitempty"`
+
+	// PreviousHash and Hash chain the entries, so altering or removing one is detected by the verify-audit-log command
+	PreviousHash string `json:"previousHash,omitempty"`
+	Hash         string `json:"hash,omitempty"`
+
+	// Entries and Signature are only set on the run summary, signed with --audit-log-signing-key-file
+	Entries   int    `json:"entries,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type AuditLogger interface {
+	Log(ctx context.Context, action *Action, actionErr error) (err error)
+	Close(ctx context.Context) (err error)
+}
+
+// NewAuditLogger returns an AuditLogger writing to a local file, a gs://bucket/path location or a bq://project.dataset.table table; if destination is empty nothing gets recorded; with a signing key file every run closes with a signed summary
+func NewAuditLogger(ctx context.Context, destination, signingKeyFile, triggeredBy string) (AuditLogger, error) {
+
+	signingKey, err := readAuditSigningKey(signingKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case destination == "":
+		return &auditLogger{triggeredBy: triggeredBy}, nil
+
+	case strings.HasPrefix(destination, "gs://"):
+		bucketAndPath := strings.SplitN(strings.TrimPrefix(destination, "gs://"), "/", 2)
+		objectPrefix := ""
+		if len(bucketAndPath) > 1 {
+			objectPrefix = bucketAndPath[1]
+		}
+
+		storageService, err := storage.NewService(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return newGCSAuditLogger(ctx, storageService, bucketAndPath[0], objectPrefix, signingKey, triggeredBy)
+
+	case strings.HasPrefix(destination, "bq://"):
+		projectDatasetTable := strings.Split(strings.TrimPrefix(destination, "bq://"), ".")
+		if len(projectDatasetTable) != 3 {
+			return nil, fmt.Errorf("Audit log destination %v is not of the form bq://project.dataset.table", destination)
+		}
+
+		bigQueryClient, err := NewBigQueryClient(ctx, projectDatasetTable[0], projectDatasetTable[1])
+		if err != nil {
+			return nil, err
+		}
+
+		return &auditLogger{
+			triggeredBy:    triggeredBy,
+			signingKey:     signingKey,
+			bigQueryClient: bigQueryClient,
+			table:          projectDatasetTable[2],
+		}, nil
+	}
+
+	file, err := os.OpenFile(destination, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	// continue the hash chain of the earlier runs in the file
+	previousHash, err := lastAuditHash(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("Failed reading audit log %v: %w", destination, err)
+	}
+
+	return &auditLogger{
+		triggeredBy:  triggeredBy,
+		signingKey:   signingKey,
+		previousHash: previousHash,
+		file:         file,
+	}, nil
+}
+
+// auditGCSBatchSize is the number of entries uploaded together as one object to a gs:// audit log
+const auditGCSBatchSize = 100
+
+// newGCSAuditLogger returns an AuditLogger uploading the entries of the run in batches of objects under the prefix, continuing the hash chain of the last object written before it
+func newGCSAuditLogger(ctx context.Context, storageService *storage.Service, bucket, objectPrefix string, signingKey ed25519.PrivateKey, triggeredBy string) (AuditLogger, error) {
+
+	previousHash, err := lastGCSAuditHash(ctx, storageService, bucket, path.Join(objectPrefix, "audit-"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading audit log in gs://%v/%v: %w", bucket, objectPrefix, err)
+	}
+
+	return &auditLogger{
+		triggeredBy:    triggeredBy,
+		signingKey:     signingKey,
+		previousHash:   previousHash,
+		storageService: storageService,
+		bucket:         bucket,
+		objectName:     path.Join(objectPrefix, fmt.Sprintf("audit-%v", time.Now().UTC().Format("20060102T150405Z"))),
+	}, nil
+}
+
+// lastGCSAuditHash returns the hash of the last entry of the last audit object with the name prefix; the object names start with the time of their run followed by the batch number, so the last one by name is the last one written
+func lastGCSAuditHash(ctx context.Context, storageService *storage.Service, bucket, namePrefix string) (string, error) {
+	lastName := ""
+	err := storageService.Objects.List(bucket).Prefix(namePrefix).Fields("items(name)", "nextPageToken").Pages(ctx, func(objects *storage.Objects) error {
+		for _, o := range objects.Items {
+			if o.Name > lastName {
+				lastName = o.Name
+			}
+		}
+		return nil
+	})
+	if err != nil || lastName == "" {
+		return "", err
+	}
+
+	response, err := storageService.Objects.Get(bucket, lastName).Context(ctx).Download()
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	return lastAuditHash(response.Body)
+}
+
+type auditLogger struct {
+	triggeredBy string
+	mutex       sync.Mutex
+
+	// hash chain of the entries and the key to sign the run summary with, if any
+	previousHash string
+	entries      int
+	signingKey   ed25519.PrivateKey
+
+	// local file destination
+	file *os.File
+
+	// gcs destination, entries are buffered and uploaded in batches, each as an object named after the run and the batch number
+	storageService  *storage.Service
+	bucket          string
+	objectName      string
+	buffer          bytes.Buffer
+	bufferedEntries int
+	batches         int
+
+	// bigquery destination, entries are inserted with the streaming api
+	bigQueryClient BigQueryClient
+	table          string
+}
+
+func (l *auditLogger) Log(ctx context.Context, action *Action, actionErr error) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "AuditLogger::Log")
+	defer span.Finish()
+
+	entry, err := newAuditEntry(action, actionErr, l.triggeredBy, runIDFromContext(ctx))
+	if err != nil {
+		return
+	}
+
+	return l.write(ctx, entry)
+}
+
+// write chains the entry to the previous one and writes it to the destination
+func (l *auditLogger) write(ctx context.Context, entry *AuditEntry) (err error) {
+	if l.bigQueryClient == nil && l.storageService == nil && l.file == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	if err = chainAuditEntry(entry, l.previousHash); err != nil {
+		l.mutex.Unlock()
+		return
+	}
+	l.previousHash = entry.Hash
+	if entry.Action == ActionRunSummary {
+		signAuditSummary(entry, l.signingKey)
+	} else {
+		l.entries++
+	}
+
+	if l.bigQueryClient != nil {
+		// the rows are inserted concurrently, the chain is followed through their hashes
+		l.mutex.Unlock()
+
+		row := map[string]bigquery.JsonValue{
+			"time":        entry.Time.Format(time.RFC3339Nano),
+			"runID":       entry.RunID,
+			"triggeredBy": entry.TriggeredBy,
+			"action":      string(entry.Action),
+			"entityType":  entry.EntityType,
+			"entityID":    entry.EntityID,
+			"entityName":  entry.EntityName,
+			"before":      string(entry.Before),
+			"after":       string(entry.After),
+			"error":       entry.Error,
+			// the chain is written whether or not the run summaries are signed; tables without these columns drop them, since unknown values are ignored
+			"previousHash": entry.PreviousHash,
+			"hash":         entry.Hash,
+			"entries":      entry.Entries,
+			"signature":    entry.Signature,
+		}
+
+		if len(entry.RevokedRoles) > 0 {
+			row["revokedRoles"] = entry.RevokedRoles
+		}
+
+		return l.bigQueryClient.InsertRows(ctx, l.table, []map[string]bigquery.JsonValue{row})
+	}
+	defer l.mutex.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.file != nil {
+		_, err = l.file.Write(line)
+		return err
+	}
+	l.buffer.Write(line)
+	l.bufferedEntries++
+	if l.bufferedEntries < auditGCSBatchSize {
+		return nil
+	}
+
+	return l.flushBatch(ctx)
+}
+
+// flushBatch uploads the buffered entries as the next batch object of the run; on failure they stay buffered to be uploaded with the next batch
+func (l *auditLogger) flushBatch(ctx context.Context) error {
+	if l.buffer.Len() == 0 {
+		return nil
+	}
+
+	objectName := fmt.Sprintf("%v-%05d.jsonl", l.objectName, l.batches)
+	_, err := l.storageService.Objects.Insert(l.bucket, &storage.Object{Name: objectName, ContentType: "application/x-ndjson"}).Media(bytes.NewReader(l.buffer.Bytes())).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Failed uploading audit log to gs://%v/%v: %w", l.bucket, objectName, err)
+	}
+	l.buffer.Reset()
+	l.bufferedEntries = 0
+	l.batches++
+
+	return nil
+}
+
+func (l *auditLogger) Close(ctx context.Context) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "AuditLogger::Close")
+	defer span.Finish()
+
+	if l.signingKey != nil && l.entries > 0 {
+		if err = l.writeRunSummary(ctx); err != nil {
+			return fmt.Errorf("Failed writing signed run summary: %w", err)
+		}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.file != nil {
+		return l.file.Close()
+	}
+
+	if l.storageService != nil {
+		return l.flushBatch(ctx)
+	}
+
+	return nil
+}
+
+// writeRunSummary closes the entries of the run with a summary whose signature covers the chain up to it
+func (l *auditLogger) writeRunSummary(ctx context.Context) error {
+	l.mutex.Lock()
+	entries := l.entries
+	l.mutex.Unlock()
+
+	return l.write(ctx, &AuditEntry{
+		Time:        time.Now().UTC(),
+		RunID:       runIDFromContext(ctx),
+		TriggeredBy: l.triggeredBy,
+		Action:      ActionRunSummary,
+		Entries:     entries,
+	})
+}
+
+func newAuditEntry(action *Action, actionErr error, triggeredBy, runID string) (entry *AuditEntry, err error) {
+
+	entry = &AuditEntry{
+		Time:        time.Now().UTC(),
+		RunID:       runID,
+		TriggeredBy: triggeredBy,
+		Action:      action.Type,
+	}
+
+	if actionErr != nil {
+		entry.Error = actionErr.Error()
+	}
+
+	var before, after interface{}
+	switch {
+	case action.Group != nil:
+		entry.EntityType = "group"
+		entry.EntityID = action.Group.ID
+		entry.EntityName = action.Group.Name
+		if action.GroupBefore != nil {
+			before = action.GroupBefore
+		}
+		after = action.Group
+
+	case action.User != nil:
+		entry.EntityType = "user"
+		entry.EntityID = action.User.ID
+		entry.EntityName = action.User.GetEmail()
+		if action.UserBefore != nil {
+			before = action.UserBefore
+		}
+		after = action.User
+		entry.RevokedRoles = revokedRoles(action.UserBefore, action.User)
+	}
+
+	if before != nil {
+		entry.Before, err = json.Marshal(before)
+		if err != nil {
+			return
+		}
+	}
+	if after != nil {
+		entry.After, err = json.Marshal(after)
+		if err != nil {
+			return
+		}
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+type fakeBigQueryClient struct {
+	mutex sync.Mutex
+	rows  []map[string]bigquery.JsonValue
+}
+
+func (c *fakeBigQueryClient) InsertRows(ctx context.Context, table string, rows []map[string]bigquery.JsonValue) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.rows = append(c.rows, rows...)
+	return nil
+}
+
+// fakeGCSBucket serves the listing, download and multipart upload of objects in a single bucket
+type fakeGCSBucket struct {
+	mutex   sync.Mutex
+	objects map[string]string
+}
+
+func (b *fakeGCSBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/audit/o":
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+			http.Error(w, "expected multipart upload", http.StatusBadRequest)
+			return
+		}
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		metadataPart, err := reader.NextPart()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var object storage.Object
+		if err := json.NewDecoder(metadataPart).Decode(&object); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mediaPart, err := reader.NextPart()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content, _ := ioutil.ReadAll(mediaPart)
+		b.objects[object.Name] = string(content)
+		json.NewEncoder(w).Encode(object)
+
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/audit/o":
+		items := []*storage.Object{}
+		for name := range b.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				items = append(items, &storage.Object{Name: name})
+			}
+		}
+		json.NewEncoder(w).Encode(storage.Objects{Items: items})
+
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/audit/o/") && r.URL.Query().Get("alt") == "media":
+		content, ok := b.objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/audit/o/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newFakeGCSService returns a storage service talking to a fake gcs server with the objects, and the bucket it serves
+func newFakeGCSService(t *testing.T, objects map[string]string) (*storage.Service, *fakeGCSBucket, func()) {
+	bucket := &fakeGCSBucket{objects: objects}
+	server := httptest.NewServer(bucket)
+	storageService, err := storage.NewService(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return storageService, bucket, server.Close
+}
+
+func TestAuditLogger(t *testing.T) {
+	t.Run("ChainsBigQueryRowsWithoutSigningKey", func(t *testing.T) {
+
+		bigQueryClient := &fakeBigQueryClient{}
+		auditLogger := &auditLogger{triggeredBy: "test", bigQueryClient: bigQueryClient, table: "audit"}
+		ctx := contextWithRunID(context.Background(), "run-1")
+
+		// act
+		assert.Nil(t, auditLogger.Log(ctx, &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "team"}}, nil))
+		assert.Nil(t, auditLogger.Log(ctx, &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "other-team"}}, nil))
+		assert.Nil(t, auditLogger.Close(ctx))
+
+		if assert.Equal(t, 2, len(bigQueryClient.rows)) {
+			assert.Equal(t, "", bigQueryClient.rows[0]["previousHash"])
+			assert.NotEqual(t, "", bigQueryClient.rows[0]["hash"])
+			assert.Equal(t, bigQueryClient.rows[0]["hash"], bigQueryClient.rows[1]["previousHash"])
+			assert.NotEqual(t, "", bigQueryClient.rows[1]["hash"])
+		}
+	})
+
+	t.Run("ContinuesChainOfLastGCSObject", func(t *testing.T) {
+
+		storageService, bucket, closeServer := newFakeGCSService(t, map[string]string{
+			"syncer/audit-20200101T000000Z.jsonl": `{"hash":"first"}` + "\n" + `{"previousHash":"first","hash":"earlier"}` + "\n",
+			"syncer/audit-20200102T000000Z.jsonl": `{"hash":"second"}` + "\n" + `{"previousHash":"second","hash":"last"}` + "\n",
+			"other/audit-20200103T000000Z.jsonl":  `{"hash":"other"}` + "\n",
+		})
+		defer closeServer()
+		ctx := contextWithRunID(context.Background(), "run-1")
+
+		// act
+		auditLogger, err := newGCSAuditLogger(ctx, storageService, "audit", "syncer", nil, "test")
+		assert.Nil(t, err)
+		assert.Nil(t, auditLogger.Log(ctx, &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "team"}}, nil))
+		assert.Nil(t, auditLogger.Close(ctx))
+
+		assert.Equal(t, 4, len(bucket.objects))
+		for name, content := range bucket.objects {
+			if name > "syncer/audit-20200102T000000Z.jsonl" && strings.HasPrefix(name, "syncer/") {
+				var entry AuditEntry
+				assert.Nil(t, json.Unmarshal([]byte(content), &entry))
+				assert.Equal(t, "last", entry.PreviousHash)
+				assert.Equal(t, "team", entry.EntityName)
+			}
+		}
+	})
+
+	t.Run("WritesRevokedRolesToBigQuery", func(t *testing.T) {
+
+		bigQueryClient := &fakeBigQueryClient{}
+		auditLogger := &auditLogger{triggeredBy: "test", bigQueryClient: bigQueryClient, table: "audit"}
+		administrator := "administrator"
+		userBefore := &contracts.User{ID: "1", Email: "jane@example.com", Roles: []*string{&administrator}}
+		user := &contracts.User{ID: "1", Email: "jane@example.com"}
+		ctx := contextWithRunID(context.Background(), "run-1")
+
+		// act
+		err := auditLogger.Log(ctx, &Action{Type: ActionUpdateUser, UserBefore: userBefore, User: user}, nil)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(bigQueryClient.rows)) {
+			assert.Equal(t, []string{"administrator"}, bigQueryClient.rows[0]["revokedRoles"])
+		}
+	})
+
+	t.Run("UploadsGCSObjectPerBatchOfEntries", func(t *testing.T) {
+
+		storageService, bucket, closeServer := newFakeGCSService(t, map[string]string{})
+		defer closeServer()
+		ctx := contextWithRunID(context.Background(), "run-1")
+		auditLogger, err := newGCSAuditLogger(ctx, storageService, "audit", "syncer", nil, "test")
+		assert.Nil(t, err)
+
+		// act
+		for i := 0; i < auditGCSBatchSize+auditGCSBatchSize/2; i++ {
+			assert.Nil(t, auditLogger.Log(ctx, &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: fmt.Sprintf("team-%v", i)}}, nil))
+		}
+		uploadedBeforeClose := len(bucket.objects)
+		assert.Nil(t, auditLogger.Close(ctx))
+
+		assert.Equal(t, 1, uploadedBeforeClose)
+		names := []string{}
+		for name := range bucket.objects {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if assert.Equal(t, 2, len(names)) {
+			assert.True(t, strings.HasSuffix(names[0], "-00000.jsonl"))
+			assert.True(t, strings.HasSuffix(names[1], "-00001.jsonl"))
+			firstBatch := strings.Split(strings.TrimSpace(bucket.objects[names[0]]), "\n")
+			secondBatch := strings.Split(strings.TrimSpace(bucket.objects[names[1]]), "\n")
+			assert.Equal(t, auditGCSBatchSize, len(firstBatch))
+			assert.Equal(t, auditGCSBatchSize/2, len(secondBatch))
+			var lastOfFirst, firstOfSecond AuditEntry
+			assert.Nil(t, json.Unmarshal([]byte(firstBatch[len(firstBatch)-1]), &lastOfFirst))
+			assert.Nil(t, json.Unmarshal([]byte(secondBatch[0]), &firstOfSecond))
+			assert.Equal(t, lastOfFirst.Hash, firstOfSecond.PreviousHash)
+		}
+	})
+
+	t.Run("StartsChainIfBucketHasNoAuditObjects", func(t *testing.T) {
+
+		storageService, _, closeServer := newFakeGCSService(t, map[string]string{})
+		defer closeServer()
+
+		// act
+		previousHash, err := lastGCSAuditHash(context.Background(), storageService, "audit", "syncer/audit-")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", previousHash)
+	})
+}
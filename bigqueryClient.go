@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+type BigQueryClient interface {
+	InsertRows(ctx context.Context, table string, rows []map[string]bigquery.JsonValue) (err error)
+}
+
+// NewBigQueryClient returns a new BigQueryClient streaming rows into tables of a single dataset
+func NewBigQueryClient(ctx context.Context, projectID, dataset string) (BigQueryClient, error) {
+
+	bigqueryService, err := bigquery.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bigQueryClient{
+		projectID:       projectID,
+		dataset:         dataset,
+		bigqueryService: bigqueryService,
+	}, nil
+}
+
+type bigQueryClient struct {
+	projectID       string
+	dataset         string
+	bigqueryService *bigquery.Service
+}
+
+func (c *bigQueryClient) InsertRows(ctx context.Context, table string, rows []map[string]bigquery.JsonValue) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "BigQueryClient::InsertRows")
+	defer span.Finish()
+
+	span.LogKV("table", table, "rows", len(rows))
+
+	if len(rows) == 0 {
+		return nil
+	}
+
+	request := &bigquery.TableDataInsertAllRequest{
+		Rows: make([]*bigquery.TableDataInsertAllRequestRows, 0, len(rows)),
+		// tables created before columns like runID were added keep accepting rows, without those columns
+		IgnoreUnknownValues: true,
+	}
+	for _, r := range rows {
+		request.Rows = append(request.Rows, &bigquery.TableDataInsertAllRequestRows{Json: r})
+	}
+
+	response, err := c.bigqueryService.Tabledata.InsertAll(c.projectID, c.dataset, table, request).Context(ctx).Do()
+	if err != nil {
+		return
+	}
+
+	if len(response.InsertErrors) > 0 && len(response.InsertErrors[0].Errors) > 0 {
+		return fmt.Errorf("Inserting %v rows into bigquery table %v.%v.%v failed for %v rows: %v", len(rows), c.projectID, c.dataset, table, len(response.InsertErrors), response.InsertErrors[0].Errors[0].Message)
+	}
+
+	return nil
+}
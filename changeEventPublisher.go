@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/opentracing-contrib/go-stdlib/nethttp"
+	"github.com/opentracing/opentracing-go"
+)
+
+// ChangeEventPublisher delivers directory change events to a feed outside of the syncer, for instance for security teams following access changes
+type ChangeEventPublisher interface {
+	Publish(ctx context.Context, events []*ChangeEvent) (err error)
+}
+
+// NewWebhookPublisher returns a ChangeEventPublisher posting the events as a json array to the url in a single request
+func NewWebhookPublisher(url string, timeout time.Duration) ChangeEventPublisher {
+	return &webhookPublisher{
+		url:    url,
+		client: &http.Client{Transport: &nethttp.Transport{RoundTripper: sharedRoundTripper()}, Timeout: timeout},
+	}
+}
+
+type webhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+func (p *webhookPublisher) Publish(ctx context.Context, events []*ChangeEvent) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "WebhookPublisher::Publish")
+	defer span.Finish()
+
+	span.LogKV("events", len(events))
+
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("Failed posting change events to webhook: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Failed posting change events to webhook, status code %v", response.StatusCode)
+	}
+
+	return nil
+}
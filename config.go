@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config holds the settings that can be set in the config file, in addition to the ones set with flags
+type Config struct {
+	// ProtectedGroups are the names or ids of estafette groups the syncer never modifies
+	ProtectedGroups []string `yaml:"protectedGroups,omitempty"`
+	// NameTransforms are applied in order to directory group names, after trimming the group prefix, to get the estafette group names
+	NameTransforms []*NameTransform `yaml:"nameTransforms,omitempty"`
+	// IncludeMembers and ExcludeMembers are glob patterns for the emails or ids of directory members to keep or drop, in addition to the flags
+	IncludeMembers []string `yaml:"includeMembers,omitempty"`
+	ExcludeMembers []string `yaml:"excludeMembers,omitempty"`
+	// GroupPrefixes hold the roles, organizations and name transforms for the groups of each --gsuite-group-prefix
+	GroupPrefixes []*GroupPrefix `yaml:"groupPrefixes,omitempty"`
+	// Policies are the compliance rules checked for the directory groups before applying changes
+	Policies []*Policy `yaml:"policies,omitempty"`
+	// SyncGroupDescriptions records the description of every directory group on its estafette group and keeps it updated
+	SyncGroupDescriptions bool `yaml:"syncGroupDescriptions,omitempty"`
+	// AdminRoles map directory admin roles to estafette groups with estafette roles, see AdminRole
+	AdminRoles []*AdminRole `yaml:"adminRoles,omitempty"`
+}
+
+// readConfig reads the yaml config file; if path is empty it returns an empty config
+func readConfig(path string) (config *Config, err error) {
+
+	config = &Config{}
+
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateConfigSchema(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = yaml.UnmarshalStrict(data, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// validateConfig checks the settings of the config that the schema can't, like the regular expressions of the name transforms and policies, the group prefixes without a --gsuite-group-prefix and the admin roles without --gsuite-sync-admin-roles, so a broken config fails at startup instead of at the first sync
+func validateConfig(config *Config) error {
+	_, err := compileNameTransforms(config.NameTransforms)
+	if err != nil {
+		return fmt.Errorf("Invalid name transforms: %w", err)
+	}
+
+	_, err = compileGroupPrefixes(*gsuiteGroupPrefixes, config.GroupPrefixes)
+	if err != nil {
+		return fmt.Errorf("Invalid group prefixes: %w", err)
+	}
+
+	_, err = compilePolicies(config.Policies)
+	if err != nil {
+		return fmt.Errorf("Invalid policies: %w", err)
+	}
+
+	err = validateAdminRoles(config.AdminRoles, *gsuiteSyncAdminRoles)
+	if err != nil {
+		return fmt.Errorf("Invalid admin roles: %w", err)
+	}
+
+	return nil
+}
+
+// getMemberPatterns returns the member patterns from the comma-separated flag value and the config file combined
+func getMemberPatterns(flagValue string, configPatterns []string) (patterns []string) {
+
+	patterns = make([]string, 0)
+
+	for _, p := range append(strings.Split(flagValue, ","), configPatterns...) {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+
+	return
+}
+
+// gsuiteAdminEmails returns the admin emails to impersonate from the comma-separated flag value, in the order to try them
+func gsuiteAdminEmails() (emails []string) {
+
+	emails = make([]string, 0)
+
+	for _, e := range strings.Split(*gsuiteAdminEmail, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			emails = append(emails, e)
+		}
+	}
+
+	return
+}
+
+// getProtectedGroups returns the protected groups from the comma-separated flag value and the config file combined
+func getProtectedGroups(flagValue string, config *Config) (protectedGroups []string) {
+
+	protectedGroups = make([]string, 0)
+
+	for _, g := range strings.Split(flagValue, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			protectedGroups = append(protectedGroups, g)
+		}
+	}
+
+	for _, g := range config.ProtectedGroups {
+		if g = strings.TrimSpace(g); g != "" {
+			protectedGroups = append(protectedGroups, g)
+		}
+	}
+
+	return
+}
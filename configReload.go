@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configReloader holds the config the daemon syncs with, re-reading the config file when it changes, so changed mapping rules and filters are applied on the next sync without restarting the pod
+type configReloader struct {
+	path        string
+	mutex       sync.Mutex
+	config      *Config
+	fingerprint string
+}
+
+// newConfigReloader returns a configReloader starting with the config read from path; without a config file the config never changes
+func newConfigReloader(path string, config *Config) *configReloader {
+	return &configReloader{
+		path:        path,
+		config:      config,
+		fingerprint: fingerprintFile(path),
+	}
+}
+
+// current returns the config for the next sync, reloading the config file first if its content changed since, so a change the watcher missed is still picked up
+func (r *configReloader) current() *Config {
+	r.reload()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.config
+}
+
+// reload re-reads the config file if its content changed, and logs what changed; an invalid config is logged and the previous one kept, so a typo doesn't stop the syncs
+func (r *configReloader) reload() {
+	if r.path == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// the file can't be read while it's being replaced, it's reloaded once the new one is in place
+	fingerprint := fingerprintFile(r.path)
+	if fingerprint == "" || fingerprint == r.fingerprint {
+		return
+	}
+	r.fingerprint = fingerprint
+
+	config, err := readConfig(r.path)
+	if err == nil {
+		err = validateConfig(config)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Failed reloading config file %v, keeping the previous config", r.path)
+		return
+	}
+
+	changes := diffConfigs(r.config, config)
+	log.Info().Msgf("Reloaded config file %v with %v changed lines, applying it on the next sync", r.path, len(changes))
+	for _, c := range changes {
+		log.Info().Str("config", r.path).Msgf("Config change: %v", c)
+	}
+
+	r.config = config
+}
+
+// watch reloads the config file as soon as it changes until the context is done, so mistakes are logged right away instead of at the next sync. It watches the directory of the file, since kubernetes swaps mounted configmaps by replacing a symlink rather than writing the file
+func (r *configReloader) watch(ctx context.Context) error {
+	if r.path == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = watcher.Add(filepath.Dir(r.path))
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+
+	logFromContext(ctx).Info().Msgf("Watching config file %v for changes", r.path)
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				r.reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logFromContext(ctx).Warn().Err(err).Msgf("Failed watching config file %v, changes are picked up at the next sync", r.path)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// diffConfigs returns the lines of the yaml form of the configs that were removed, prefixed with -, and added, prefixed with +
+func diffConfigs(before, after *Config) []string {
+	beforeLines := configLines(before)
+	afterLines := configLines(after)
+
+	// the longest common subsequence of lines is kept, everything else is removed or added
+	common := make([][]int, len(beforeLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(afterLines)+1)
+	}
+	for i := len(beforeLines) - 1; i >= 0; i-- {
+		for j := len(afterLines) - 1; j >= 0; j-- {
+			if beforeLines[i] == afterLines[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	changes := make([]string, 0)
+	i, j := 0, 0
+	for i < len(beforeLines) || j < len(afterLines) {
+		switch {
+		case i < len(beforeLines) && j < len(afterLines) && beforeLines[i] == afterLines[j]:
+			i++
+			j++
+		case j == len(afterLines) || (i < len(beforeLines) && common[i+1][j] >= common[i][j+1]):
+			changes = append(changes, "- "+beforeLines[i])
+			i++
+		default:
+			changes = append(changes, "+ "+afterLines[j])
+			j++
+		}
+	}
+
+	return changes
+}
+
+// configLines returns the lines of the config marshalled as yaml
+func configLines(config *Config) []string {
+	if config == nil {
+		return nil
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil
+	}
+
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigReloader(t *testing.T) {
+	t.Run("AppliesChangedConfigFile", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "config")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		configFile := filepath.Join(dir, "config.yaml")
+		assert.Nil(t, ioutil.WriteFile(configFile, []byte("protectedGroups:\n- admins\n"), 0600))
+		config, err := readConfig(configFile)
+		assert.Nil(t, err)
+		reloader := newConfigReloader(configFile, config)
+
+		assert.Nil(t, ioutil.WriteFile(configFile, []byte("protectedGroups:\n- admins\n- owners\n"), 0600))
+
+		// act
+		current := reloader.current()
+
+		assert.Equal(t, []string{"admins", "owners"}, current.ProtectedGroups)
+	})
+
+	t.Run("KeepsPreviousConfigIfChangedConfigFileIsInvalid", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "config")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		configFile := filepath.Join(dir, "config.yaml")
+		assert.Nil(t, ioutil.WriteFile(configFile, []byte("protectedGroups:\n- admins\n"), 0600))
+		config, err := readConfig(configFile)
+		assert.Nil(t, err)
+		reloader := newConfigReloader(configFile, config)
+
+		assert.Nil(t, ioutil.WriteFile(configFile, []byte("protectedGroupz:\n- owners\n"), 0600))
+
+		// act
+		current := reloader.current()
+
+		assert.Equal(t, config, current)
+	})
+
+	t.Run("ReturnsConfigAsIsWithoutConfigFile", func(t *testing.T) {
+
+		config := &Config{}
+		reloader := newConfigReloader("", config)
+
+		// act
+		current := reloader.current()
+
+		assert.Equal(t, config, current)
+	})
+}
+
+func TestDiffConfigs(t *testing.T) {
+	t.Run("ReturnsRemovedAndAddedLines", func(t *testing.T) {
+
+		before := &Config{ProtectedGroups: []string{"admins", "owners"}}
+		after := &Config{ProtectedGroups: []string{"admins", "maintainers"}, SyncGroupDescriptions: true}
+
+		// act
+		changes := diffConfigs(before, after)
+
+		assert.Equal(t, []string{"- - owners", "+ - maintainers", "+ syncGroupDescriptions: true"}, changes)
+	})
+
+	t.Run("ReturnsNoLinesForEqualConfigs", func(t *testing.T) {
+
+		// act
+		changes := diffConfigs(&Config{ProtectedGroups: []string{"admins"}}, &Config{ProtectedGroups: []string{"admins"}})
+
+		assert.Equal(t, 0, len(changes))
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// configSchemaError is a key or value of the config file that doesn't fit the Config type, at the line and column it starts
+type configSchemaError struct {
+	line    int
+	column  int
+	message string
+}
+
+func (e *configSchemaError) String() string {
+	return fmt.Sprintf("line %v, column %v: %v", e.line, e.column, e.message)
+}
+
+// configSchemaErrors are all the schema errors in the config file, so they can be fixed in one go
+type configSchemaErrors []*configSchemaError
+
+func (e configSchemaErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, err := range e {
+		lines = append(lines, err.String())
+	}
+
+	return fmt.Sprintf("config file doesn't match the schema:\n  %v", strings.Join(lines, "\n  "))
+}
+
+// validateConfigSchema checks the config file against the schema defined by the Config type and the types of its fields, returning every unknown key, with the known key it's probably a typo of, and every value of the wrong kind with its line and column; misconfigurations like a typoed gropuPrefixes would otherwise be unmarshalled as an empty setting
+func validateConfigSchema(data []byte) error {
+	var document yamlv3.Node
+	err := yamlv3.Unmarshal(data, &document)
+	if err != nil {
+		return fmt.Errorf("Failed parsing config file: %w", err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+
+	errs := make(configSchemaErrors, 0)
+	validateConfigNode(document.Content[0], reflect.TypeOf(Config{}), "config", &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validateConfigNode checks the node against the type of the setting at path, appending the errors to errs
+func validateConfigNode(node *yamlv3.Node, t reflect.Type, path string, errs *configSchemaErrors) {
+	if node.Kind == yamlv3.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// an empty value leaves the setting unset
+	if node.Kind == yamlv3.ScalarNode && node.ShortTag() == "!!null" {
+		return
+	}
+
+	fail := func(node *yamlv3.Node, format string, args ...interface{}) {
+		*errs = append(*errs, &configSchemaError{line: node.Line, column: node.Column, message: fmt.Sprintf(format, args...)})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yamlv3.MappingNode {
+			fail(node, "%v should be a mapping, not %v", path, describeConfigNode(node))
+			return
+		}
+		fields := configFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				if suggestion := suggestConfigKey(key.Value, fields); suggestion != "" {
+					fail(key, "unknown key %q in %v, did you mean %q?", key.Value, path, suggestion)
+				} else {
+					fail(key, "unknown key %q in %v", key.Value, path)
+				}
+				continue
+			}
+			validateConfigNode(value, field.Type, path+"."+key.Value, errs)
+		}
+
+	case reflect.Slice:
+		if node.Kind != yamlv3.SequenceNode {
+			fail(node, "%v should be a list, not %v", path, describeConfigNode(node))
+			return
+		}
+		for i, item := range node.Content {
+			validateConfigNode(item, t.Elem(), fmt.Sprintf("%v[%v]", path, i), errs)
+		}
+
+	case reflect.Bool:
+		if node.Kind != yamlv3.ScalarNode || !isConfigBool(node.Value) {
+			fail(node, "%v should be true or false, not %v", path, describeConfigNode(node))
+		}
+
+	case reflect.Int:
+		if _, err := strconv.Atoi(node.Value); node.Kind != yamlv3.ScalarNode || err != nil {
+			fail(node, "%v should be a number, not %v", path, describeConfigNode(node))
+		}
+
+	case reflect.String:
+		if node.Kind != yamlv3.ScalarNode {
+			fail(node, "%v should be a string, not %v", path, describeConfigNode(node))
+		}
+	}
+}
+
+// configFields returns the fields of the struct type by the key they're set with in the config file
+func configFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		fields[key] = field
+	}
+
+	return fields
+}
+
+// describeConfigNode describes the value of the node for an error message
+func describeConfigNode(node *yamlv3.Node) string {
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		return "a mapping"
+	case yamlv3.SequenceNode:
+		return "a list"
+	}
+
+	return strconv.Quote(node.Value)
+}
+
+// isConfigBool checks whether the value is a boolean as the config file is unmarshalled, which accepts the yaml 1.1 forms like yes and off as well
+func isConfigBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "true", "false", "yes", "no", "on", "off", "y", "n":
+		return true
+	}
+
+	return false
+}
+
+// suggestConfigKey returns the known key closest to the unknown one, or an empty string if none is close enough to be a typo of it
+func suggestConfigKey(unknown string, fields map[string]reflect.StructField) (suggestion string) {
+	best := len(unknown)/3 + 1
+	for key := range fields {
+		distance := editDistance(strings.ToLower(unknown), strings.ToLower(key))
+		if distance < best || (distance == best && suggestion != "" && key < suggestion) {
+			best = distance
+			suggestion = key
+		}
+	}
+
+	return suggestion
+}
+
+// editDistance returns the number of inserted, deleted, substituted or swapped adjacent characters needed to turn a into b
+func editDistance(a, b string) int {
+	distances := make([][]int, len(a)+1)
+	for i := range distances {
+		distances[i] = make([]int, len(b)+1)
+		distances[i][0] = i
+	}
+	for j := range distances[0] {
+		distances[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := distances[i-1][j] + 1
+			if distances[i][j-1]+1 < d {
+				d = distances[i][j-1] + 1
+			}
+			if distances[i-1][j-1]+cost < d {
+				d = distances[i-1][j-1] + cost
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && distances[i-2][j-2]+1 < d {
+				d = distances[i-2][j-2] + 1
+			}
+			distances[i][j] = d
+		}
+	}
+
+	return distances[len(a)][len(b)]
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateConfigSchema(t *testing.T) {
+	t.Run("AcceptsValidConfig", func(t *testing.T) {
+
+		data := []byte(`
+protectedGroups:
+- admins
+groupPrefixes:
+- prefix: ci-
+  roles:
+  - operator
+  nameTransforms:
+  - replace:
+      pattern: "-"
+      with: " "
+policies:
+- name: small-groups
+  maxMembers: 50
+  allowExternalMembers: false
+syncGroupDescriptions: yes
+`)
+
+		// act
+		err := validateConfigSchema(data)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("AcceptsEmptyConfig", func(t *testing.T) {
+
+		// act
+		err := validateConfigSchema([]byte(""))
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("ReturnsUnknownKeysWithLineColumnAndSuggestion", func(t *testing.T) {
+
+		data := []byte(`gropuPrefixes:
+- prefix: ci-
+groupPrefixes:
+- prefix: ci-
+  role:
+  - operator
+`)
+
+		// act
+		err := validateConfigSchema(data)
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "config file doesn't match the schema:\n"+
+				"  line 1, column 1: unknown key \"gropuPrefixes\" in config, did you mean \"groupPrefixes\"?\n"+
+				"  line 5, column 3: unknown key \"role\" in config.groupPrefixes[0], did you mean \"roles\"?", err.Error())
+		}
+	})
+
+	t.Run("ReturnsUnknownKeysWithoutSuggestionIfNoKeyIsClose", func(t *testing.T) {
+
+		// act
+		err := validateConfigSchema([]byte("verbose: true\n"))
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "config file doesn't match the schema:\n  line 1, column 1: unknown key \"verbose\" in config", err.Error())
+		}
+	})
+
+	t.Run("ReturnsValuesOfTheWrongKind", func(t *testing.T) {
+
+		data := []byte(`protectedGroups: admins
+policies:
+- name: small-groups
+  maxMembers: many
+`)
+
+		// act
+		err := validateConfigSchema(data)
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "config file doesn't match the schema:\n"+
+				"  line 1, column 18: config.protectedGroups should be a list, not \"admins\"\n"+
+				"  line 4, column 15: config.policies[0].maxMembers should be a number, not \"many\"", err.Error())
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfig(t *testing.T) {
+	t.Run("ReturnsEmptyConfigIfPathIsEmpty", func(t *testing.T) {
+
+		// act
+		config, err := readConfig("")
+
+		assert.Nil(t, err)
+		assert.Equal(t, &Config{}, config)
+	})
+
+	t.Run("ReturnsErrorForUnknownKeys", func(t *testing.T) {
+
+		dir, _ := ioutil.TempDir("", "config")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "config.yaml")
+		_ = ioutil.WriteFile(path, []byte("protectedGroup:\n- admins\n"), 0644)
+
+		// act
+		_, err := readConfig(path)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGetProtectedGroups(t *testing.T) {
+	t.Run("CombinesFlagAndConfigFile", func(t *testing.T) {
+
+		// act
+		protectedGroups := getProtectedGroups("admins, g1,", &Config{ProtectedGroups: []string{"release-managers"}})
+
+		assert.Equal(t, []string{"admins", "g1", "release-managers"}, protectedGroups)
+	})
+}
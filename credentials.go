@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// credentialsPollInterval is how often the daemon checks the credential files for rotated keys and secrets
+const credentialsPollInterval = 10 * time.Second
+
+// getClientSecret returns the client secret from --client-secret-file if set, read on every call so a rotated secret is picked up without restarting, or from --client-secret otherwise
+func getClientSecret() (string, error) {
+	if *clientSecretFile == "" {
+		return *clientSecret, nil
+	}
+
+	secret, err := ioutil.ReadFile(*clientSecretFile)
+	if err != nil {
+		return "", fmt.Errorf("Failed reading client secret file %v: %w", *clientSecretFile, err)
+	}
+
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// credentialsWatcher detects changes to the service account key and client secret files, so the daemon can tell when key rotation tooling replaced them; mounted kubernetes secrets are swapped with a symlink, so the content is compared rather than the modification time
+type credentialsWatcher struct {
+	files        []string
+	fingerprints map[string]string
+}
+
+// newCredentialsWatcher returns a credentialsWatcher for the files that are set, or nil if none are
+func newCredentialsWatcher(files ...string) *credentialsWatcher {
+	w := &credentialsWatcher{fingerprints: map[string]string{}}
+	for _, f := range files {
+		if f != "" {
+			w.files = append(w.files, f)
+			w.fingerprints[f] = fingerprintFile(f)
+		}
+	}
+	if len(w.files) == 0 {
+		return nil
+	}
+
+	return w
+}
+
+// changed returns the files whose content changed since the last call; a nil credentialsWatcher never reports changes
+func (w *credentialsWatcher) changed() (files []string) {
+	if w == nil {
+		return nil
+	}
+
+	for _, f := range w.files {
+		fingerprint := fingerprintFile(f)
+		if fingerprint != w.fingerprints[f] {
+			w.fingerprints[f] = fingerprint
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+// fingerprintFile returns a hash of the file content, or an empty string if it can't be read, for example while it's being replaced
+func fingerprintFile(path string) string {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+// newDaemonCredentialsWatcher returns a credentialsWatcher for the credential files the syncer reads
+func newDaemonCredentialsWatcher() *credentialsWatcher {
+	keyFile := ""
+	if *provider == gsuiteProviderName || *shadowProvider == gsuiteProviderName {
+		keyFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	return newCredentialsWatcher(keyFile, *clientSecretFile)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialsWatcher(t *testing.T) {
+	t.Run("ReturnsNilIfNoFilesAreSet", func(t *testing.T) {
+
+		// act
+		watcher := newCredentialsWatcher("", "")
+
+		assert.Nil(t, watcher)
+		assert.Equal(t, 0, len(watcher.changed()))
+	})
+
+	t.Run("ReportsFilesWithChangedContentOnce", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "credentials")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "key.json")
+		secretFile := filepath.Join(dir, "secret")
+		assert.Nil(t, ioutil.WriteFile(keyFile, []byte(`{"private_key_id":"1"}`), 0600))
+		assert.Nil(t, ioutil.WriteFile(secretFile, []byte("secret-1"), 0600))
+		watcher := newCredentialsWatcher(keyFile, secretFile)
+
+		assert.Nil(t, ioutil.WriteFile(keyFile, []byte(`{"private_key_id":"2"}`), 0600))
+
+		// act
+		changed := watcher.changed()
+
+		assert.Equal(t, []string{keyFile}, changed)
+		assert.Equal(t, 0, len(watcher.changed()))
+	})
+}
+
+func TestGetClientSecret(t *testing.T) {
+	t.Run("ReadsRotatedSecretFromFile", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "credentials")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		secretFile := filepath.Join(dir, "secret")
+		defer func(value string) { *clientSecretFile = value }(*clientSecretFile)
+		*clientSecretFile = secretFile
+
+		assert.Nil(t, ioutil.WriteFile(secretFile, []byte("secret-1\n"), 0600))
+		secret, err := getClientSecret()
+		assert.Nil(t, err)
+		assert.Equal(t, "secret-1", secret)
+
+		assert.Nil(t, ioutil.WriteFile(secretFile, []byte("secret-2\n"), 0600))
+
+		// act
+		secret, err = getClientSecret()
+
+		assert.Nil(t, err)
+		assert.Equal(t, "secret-2", secret)
+	})
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// healthServer serves the liveness, readiness and last sync status of the syncer in daemon mode
+type healthServer struct {
+	// checkReadiness verifies the credentials and reachability of the apis
+	checkReadiness func(ctx context.Context) error
+	// readinessInterval is the minimum time between readiness checks, to avoid hitting the apis on every probe
+	readinessInterval time.Duration
+	// readinessTimeout bounds a readiness check, which runs detached from the probe that triggered it
+	readinessTimeout time.Duration
+
+	// admin is nil unless the admin api is enabled
+	admin *adminAPI
+	// slack is nil unless slack slash commands are enabled
+	slack *slackCommandHandler
+	// tenants aggregates the health of this syncer with the syncers of the other estafette environments on /tenants
+	tenants *tenantSummarizer
+
+	mutex              sync.Mutex
+	lastRun            *SyncRun
+	readinessCheckedAt time.Time
+	readinessErr       error
+	// readinessChecking is set while a readiness check runs, so concurrent probes wait for it instead of checking as well
+	readinessChecking bool
+	// readinessDone is closed once the last readiness check finished
+	readinessDone chan struct{}
+}
+
+// readinessCheckTimeout is the time a readiness check gets; kubernetes gives up on a probe after a second by default, but a login and a directory call can take longer, so the check outlives the probe and its result serves the next one
+const readinessCheckTimeout = 30 * time.Second
+
+// lastSyncResponse is the json representation of the last sync run
+type lastSyncResponse struct {
+	Result           string     `json:"result"`
+	RunID            string     `json:"runID,omitempty"`
+	StartedAt        *time.Time `json:"startedAt,omitempty"`
+	FinishedAt       *time.Time `json:"finishedAt,omitempty"`
+	Provider         string     `json:"provider,omitempty"`
+	Tenant           string     `json:"tenant,omitempty"`
+	DirectoryGroups  int        `json:"directoryGroups"`
+	DirectoryMembers int        `json:"directoryMembers"`
+	Groups           int        `json:"groups"`
+	Users            int        `json:"users"`
+	Actions          int        `json:"actions"`
+	FailedActions    int        `json:"failedActions"`
+	Error            string     `json:"error,omitempty"`
+}
+
+func newHealthServer(checkReadiness func(ctx context.Context) error, readinessInterval time.Duration) *healthServer {
+	return &healthServer{
+		checkReadiness:    checkReadiness,
+		readinessInterval: readinessInterval,
+		readinessTimeout:  readinessCheckTimeout,
+	}
+}
+
+func (s *healthServer) setLastRun(run *SyncRun) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.lastRun = run
+}
+
+// resetReadiness clears the cached readiness check, so the next probe checks the credentials again
+func (s *healthServer) resetReadiness() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.readinessCheckedAt = time.Time{}
+}
+
+// getLastRun returns the last sync run, or nil if there's none yet
+func (s *healthServer) getLastRun() *SyncRun {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.lastRun
+}
+
+func (s *healthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/lastsync", s.handleLastSync)
+	mux.HandleFunc("/version", s.handleVersion)
+	mux.HandleFunc("/tenants", s.handleTenants)
+	mux.Handle("/debug/vars", expvar.Handler())
+	s.admin.register(mux)
+	s.slack.register(mux)
+
+	return mux
+}
+
+// handleHealthz reports the process is alive
+func (s *healthServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok"))
+}
+
+// handleReadyz reports whether the credentials are valid, the apis are reachable and the last sync didn't fail; the check runs without holding the mutex, so a slow api doesn't block the other endpoints and recording a run, and on its own context, so a probe giving up doesn't cancel it
+func (s *healthServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	due := !s.readinessChecking && (s.readinessCheckedAt.IsZero() || time.Since(s.readinessCheckedAt) > s.readinessInterval)
+	if due {
+		s.readinessChecking = true
+		s.readinessDone = make(chan struct{})
+		go s.runReadinessCheck(s.readinessDone)
+	}
+	done := s.readinessDone
+	s.mutex.Unlock()
+
+	select {
+	case <-done:
+	case <-r.Context().Done():
+	}
+
+	s.mutex.Lock()
+	checked := !s.readinessCheckedAt.IsZero()
+	readinessErr := s.readinessErr
+	lastRun := s.lastRun
+	s.mutex.Unlock()
+
+	switch {
+	case !checked:
+		http.Error(w, "Readiness check is still running", http.StatusServiceUnavailable)
+	case readinessErr != nil:
+		http.Error(w, readinessErr.Error(), http.StatusServiceUnavailable)
+	case lastRun != nil && lastRun.Err != nil:
+		http.Error(w, lastRun.Err.Error(), http.StatusServiceUnavailable)
+	default:
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	}
+}
+
+// runReadinessCheck checks the readiness and records the result, unless the check was canceled, which says nothing about the apis
+func (s *healthServer) runReadinessCheck(done chan struct{}) {
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.readinessTimeout)
+	defer cancel()
+	err := s.checkReadiness(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !errors.Is(err, context.Canceled) {
+		s.readinessErr = err
+		s.readinessCheckedAt = time.Now()
+	}
+	s.readinessChecking = false
+}
+
+// handleLastSync returns the timestamps, result and counts of the last sync as json
+func (s *healthServer) handleLastSync(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	response := newLastSyncResponse(s.lastRun)
+	s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// handleVersion returns the build info with the compiled-in providers and enabled features as json
+func (s *healthServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(newVersionInfo(true))
+}
+
+// handleTenants returns the last sync of this syncer and of the --tenant-peer syncers as json, with a healthy flag for alerting on all estafette environments at once
+func (s *healthServer) handleTenants(w http.ResponseWriter, r *http.Request) {
+	tenants := s.tenants
+	if tenants == nil {
+		tenants = newTenantSummarizer(nil)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(tenants.summarize(r.Context(), s.getLastRun()))
+}
+
+func newLastSyncResponse(run *SyncRun) *lastSyncResponse {
+	if run == nil {
+		return &lastSyncResponse{Result: "none"}
+	}
+
+	response := &lastSyncResponse{
+		Result:           "succeeded",
+		RunID:            run.ID,
+		StartedAt:        &run.StartedAt,
+		FinishedAt:       &run.FinishedAt,
+		Provider:         run.Provider,
+		Tenant:           run.Tenant,
+		DirectoryGroups:  run.DirectoryGroups,
+		DirectoryMembers: run.DirectoryMembers,
+		Groups:           run.Groups,
+		Users:            run.Users,
+		Actions:          len(run.Actions),
+	}
+	for _, a := range run.Actions {
+		if a.Err != nil {
+			response.FailedActions++
+		}
+	}
+	if run.Err != nil {
+		response.Result = "failed"
+		response.Error = run.Err.Error()
+	}
+
+	return response
+}
+
+// runDaemon synchronizes on the schedule and serves the health endpoints until the process is stopped
+func runDaemon(ctx context.Context, config *Config, schedule *syncSchedule, listenAddress string) {
+
+	configs := newConfigReloader(*configFile, config)
+	err := configs.watch(ctx)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msgf("Failed watching config file %v, changes are picked up at the next sync", *configFile)
+	}
+
+	server := newHealthServer(func(ctx context.Context) error {
+		directoryProvider, err := checkConnectivity(ctx, newApiClient(nil))
+		closeProvider(ctx, directoryProvider)
+		return err
+	}, time.Minute)
+	computeDrift := func(ctx context.Context) ([]*Action, error) {
+		state, err := fetchState(ctx, newApiClient(nil))
+		if err != nil {
+			return nil, err
+		}
+		return planState(ctx, configs.current(), state)
+	}
+
+	// a sync requested through slack wakes up the daemon, unless one is pending already
+	syncRequests := make(chan struct{}, 1)
+	triggerSync := func() bool {
+		select {
+		case syncRequests <- struct{}{}:
+			return true
+		default:
+			return false
+		}
+	}
+
+	server.admin = newAdminAPI(*adminAPIToken, server.getLastRun, computeDrift, func() (*StatsHistory, error) {
+		return readStatsHistory(ctx, *statsHistoryFile)
+	})
+	if server.admin != nil {
+		server.admin.resumed = func() { triggerSync() }
+	}
+	server.tenants = newTenantSummarizer(*tenantPeers)
+	server.slack = newSlackCommandHandler(*slackSigningSecret, server.getLastRun, computeDrift, triggerSync, server.admin.isPaused)
+
+	go func() {
+		logFromContext(ctx).Info().Msgf("Serving health endpoints on %v", listenAddress)
+		err := http.ListenAndServe(listenAddress, server.handler())
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed serving health endpoints")
+		}
+	}()
+
+	if *provider == gsuiteProviderName && *gsuiteMemberCacheTTL > 0 {
+		logFromContext(ctx).Info().Msgf("Caching members of unchanged gsuite groups for up to %v across syncs", *gsuiteMemberCacheTTL)
+		gsuiteMemberCache = newMemberCache(*gsuiteMemberCacheTTL)
+	}
+	if *provider == gsuiteProviderName && *gsuiteConditionalFetch {
+		logFromContext(ctx).Info().Msg("Requesting unchanged gsuite group and member lists with their etags across syncs")
+		gsuiteListCache = newListCache()
+	}
+
+	// every sync builds its own clients from the credential files, so rotated credentials only need to be noticed
+	watcher := newDaemonCredentialsWatcher()
+
+	logFromContext(ctx).Info().Msgf("Synchronizing %v", schedule)
+
+	for {
+		if server.admin.isPaused() {
+			next, _ := schedule.next(time.Now(), time.Now())
+			logFromContext(ctx).Info().Msgf("Syncing is paused through the admin api, checking again at %v or once resumed", next.Format(time.RFC3339))
+			// resuming requests a sync, so the wait ends right away; shutdown ends it as well
+			waitForNextSync(time.Until(next), credentialsPollInterval, watcher, false, server.resetReadiness, syncRequests, shutdownSignalFromContext(ctx).done())
+			if shutdownSignalFromContext(ctx).isRequested() {
+				logFromContext(ctx).Info().Msg("Stopping the paused daemon because of the shutdown request")
+				return
+			}
+			continue
+		}
+
+		gsuiteQuota.adapt(schedule.approximateInterval(time.Now()), time.Now())
+		startedAt := time.Now()
+		run, err := syncOnce(ctx, configs.current())
+		server.setLastRun(run)
+
+		if shutdownSignalFromContext(ctx).isRequested() {
+			logFromContext(ctx).Info().Msg("Stopping the daemon because of the shutdown request")
+			return
+		}
+
+		if err != nil {
+			logFromContext(ctx).Error().Err(err).Msg("Failed synchronizing to estafette")
+		} else {
+			logFromContext(ctx).Info().Msgf("Applied %v actions", len(run.Actions))
+		}
+
+		now := time.Now()
+		next, skipped := schedule.next(startedAt, now)
+		if skipped > 0 {
+			logFromContext(ctx).Warn().Msgf("Skipped %v scheduled syncs since the previous sync was still running", skipped)
+		}
+		wait := untilNextSync(next.Sub(now), run, now)
+		logFromContext(ctx).Info().Msgf("Sleeping for %v until the next sync", wait)
+		waitForNextSync(wait, credentialsPollInterval, watcher, err != nil, server.resetReadiness, syncRequests, shutdownSignalFromContext(ctx).done())
+		if shutdownSignalFromContext(ctx).isRequested() {
+			logFromContext(ctx).Info().Msg("Stopping the daemon because of the shutdown request")
+			return
+		}
+	}
+}
+
+// waitForNextSync sleeps for the interval while polling the credential files for rotation; after a failed sync it returns as soon as they change, so a sync broken by a revoked key is retried with the new one right away. It returns early for a sync requested on syncRequests, and when shutdown is requested, as well
+func waitForNextSync(interval, pollInterval time.Duration, watcher *credentialsWatcher, lastSyncFailed bool, reloaded func(), syncRequests <-chan struct{}, shutdown <-chan struct{}) {
+
+	next := time.NewTimer(interval)
+	defer next.Stop()
+
+	// without credential files to watch the poll channel stays nil, so it never fires
+	var poll <-chan time.Time
+	if watcher != nil {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-next.C:
+			return
+		case <-shutdown:
+			return
+		case <-syncRequests:
+			log.Info().Msg("Starting a requested sync")
+			return
+		case <-poll:
+			files := watcher.changed()
+			if len(files) == 0 {
+				continue
+			}
+			log.Info().Msgf("Reloading rotated credentials in %v", strings.Join(files, ", "))
+			reloaded()
+			if lastSyncFailed {
+				log.Info().Msg("Retrying the failed sync with the rotated credentials")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthServer(t *testing.T) {
+	t.Run("ReadyzReturnsServiceUnavailableIfLastSyncFailed", func(t *testing.T) {
+
+		server := newHealthServer(func(ctx context.Context) error { return nil }, time.Minute)
+		server.setLastRun(&SyncRun{Err: errors.New("Failed fetching groups")})
+
+		recorder := httptest.NewRecorder()
+
+		// act
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	})
+
+	t.Run("ReadyzCachesReadinessCheck", func(t *testing.T) {
+
+		checks := 0
+		server := newHealthServer(func(ctx context.Context) error { checks++; return nil }, time.Minute)
+
+		// act
+		for i := 0; i < 3; i++ {
+			recorder := httptest.NewRecorder()
+			server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+			assert.Equal(t, http.StatusOK, recorder.Code)
+		}
+
+		assert.Equal(t, 1, checks)
+	})
+
+	t.Run("ReadyzKeepsCheckingAfterProbeGaveUp", func(t *testing.T) {
+
+		release := make(chan struct{})
+		checkErrs := make(chan error, 1)
+		server := newHealthServer(func(ctx context.Context) error {
+			<-release
+			checkErrs <- ctx.Err()
+			return nil
+		}, time.Minute)
+		probeCtx, cancelProbe := context.WithCancel(context.Background())
+		cancelProbe()
+		recorder := httptest.NewRecorder()
+
+		// act
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil).WithContext(probeCtx))
+
+		assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+		close(release)
+		assert.Nil(t, <-checkErrs)
+		recorder = httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("ReadyzDoesntCacheCanceledReadinessCheck", func(t *testing.T) {
+
+		checks := 0
+		server := newHealthServer(func(ctx context.Context) error {
+			checks++
+			if checks == 1 {
+				return fmt.Errorf("Failed retrieving token: %w", context.Canceled)
+			}
+			return nil
+		}, time.Minute)
+		recorder := httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		recorder = httptest.NewRecorder()
+
+		// act
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, 2, checks)
+	})
+
+	t.Run("LastSyncIsNotBlockedBySlowReadinessCheck", func(t *testing.T) {
+
+		checking := make(chan struct{})
+		release := make(chan struct{})
+		server := newHealthServer(func(ctx context.Context) error {
+			close(checking)
+			<-release
+			return nil
+		}, time.Minute)
+		defer close(release)
+		go server.handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/readyz", nil))
+		<-checking
+
+		recorder := httptest.NewRecorder()
+
+		// act
+		server.setLastRun(&SyncRun{Provider: gsuiteProviderName})
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/lastsync", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	})
+
+	t.Run("LastSyncReturnsCountsOfLastRun", func(t *testing.T) {
+
+		server := newHealthServer(func(ctx context.Context) error { return nil }, time.Minute)
+		server.setLastRun(&SyncRun{
+			Provider:        gsuiteProviderName,
+			DirectoryGroups: 2,
+			Actions:         []*Action{{Type: ActionCreateGroup}, {Type: ActionUpdateUser, Err: errors.New("Conflict")}},
+		})
+
+		recorder := httptest.NewRecorder()
+
+		// act
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/lastsync", nil))
+
+		var response lastSyncResponse
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.Nil(t, err)
+		assert.Equal(t, "succeeded", response.Result)
+		assert.Equal(t, 2, response.DirectoryGroups)
+		assert.Equal(t, 2, response.Actions)
+		assert.Equal(t, 1, response.FailedActions)
+	})
+
+	t.Run("VersionReturnsBuildInfoWithCapabilities", func(t *testing.T) {
+
+		server := newHealthServer(func(ctx context.Context) error { return nil }, time.Minute)
+
+		recorder := httptest.NewRecorder()
+
+		// act
+		server.handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/version", nil))
+
+		var response VersionInfo
+		err := json.Unmarshal(recorder.Body.Bytes(), &response)
+		assert.Nil(t, err)
+		assert.Equal(t, goVersion, response.GoVersion)
+		if assert.NotNil(t, response.Capabilities) {
+			assert.Equal(t, compiledProviders, response.Capabilities.CompiledProviders)
+			assert.Equal(t, *provider, response.Capabilities.Provider)
+		}
+	})
+}
+
+func TestWaitForNextSync(t *testing.T) {
+	t.Run("RetriesFailedSyncAsSoonAsCredentialsAreRotated", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "daemon")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "key.json")
+		assert.Nil(t, ioutil.WriteFile(keyFile, []byte(`{"private_key_id":"1"}`), 0600))
+		watcher := newCredentialsWatcher(keyFile)
+
+		reloads := 0
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			ioutil.WriteFile(keyFile, []byte(`{"private_key_id":"2"}`), 0600)
+		}()
+		start := time.Now()
+
+		// act
+		waitForNextSync(time.Minute, 10*time.Millisecond, watcher, true, func() { reloads++ }, nil, nil)
+
+		assert.True(t, time.Since(start) < time.Minute)
+		assert.Equal(t, 1, reloads)
+	})
+}
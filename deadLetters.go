@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// DeadLetter is a directory group whose members couldn't be listed, not even when retried at the end of the fetch, for instance because it was deleted mid-run; the run continues without it and its estafette group is left as it is, so a failing listing never removes access
+type DeadLetter struct {
+	Group string `json:"group"`
+	// ID is the id of the directory group, which the identity of its estafette group holds
+	ID       string `json:"id,omitempty"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+}
+
+// deadLetterList collects the dead-lettered groups of a run; it's safe for concurrent use
+type deadLetterList struct {
+	mutex   sync.Mutex
+	letters []*DeadLetter
+}
+
+// newDeadLetterList returns an empty deadLetterList
+func newDeadLetterList() *deadLetterList {
+	return &deadLetterList{letters: make([]*DeadLetter, 0)}
+}
+
+// add records the group with the id as dead-lettered
+func (l *deadLetterList) add(group, id string, err error, attempts int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.letters = append(l.letters, &DeadLetter{Group: group, ID: id, Error: err.Error(), Attempts: attempts})
+}
+
+// list returns the dead-lettered groups; a nil deadLetterList has none
+func (l *deadLetterList) list() []*DeadLetter {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return append([]*DeadLetter(nil), l.letters...)
+}
+
+type deadLetterListContextKey struct{}
+
+// contextWithDeadLetterList returns the context with the list that providers add groups they fail to list the members of to, instead of failing the fetch
+func contextWithDeadLetterList(ctx context.Context, l *deadLetterList) context.Context {
+	return context.WithValue(ctx, deadLetterListContextKey{}, l)
+}
+
+// deadLetterListFromContext returns the list set with contextWithDeadLetterList, or nil if there's none, in which case a failing group fails the fetch
+func deadLetterListFromContext(ctx context.Context) *deadLetterList {
+	l, _ := ctx.Value(deadLetterListContextKey{}).(*deadLetterList)
+	return l
+}
+
+// deadLetteredGroupIDs returns the ids of the estafette groups of the dead-lettered directory groups, which are protected for the run since their members aren't known
+func deadLetteredGroupIDs(groups []*contracts.Group, provider Provider, deadLetters []*DeadLetter) (ids []string) {
+	if provider == nil || len(deadLetters) == 0 {
+		return nil
+	}
+
+	dead := make(map[string]bool, len(deadLetters))
+	for _, d := range deadLetters {
+		// identities of an older version hold the group email instead of its id, and aren't migrated while its members are unknown
+		dead[d.Group] = true
+		if d.ID != "" {
+			dead[d.ID] = true
+		}
+	}
+
+	for _, g := range groups {
+		for _, i := range g.Identities {
+			if i.Provider == provider.Name() && dead[i.ID] {
+				ids = append(ids, g.ID)
+				break
+			}
+		}
+	}
+
+	return ids
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestGsuiteClientWithDeadLetterList(t *testing.T) {
+	t.Run("RetriesGroupThatFailedAtTheEndOfTheFetch", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.failMemberLists("ci-release@example.com", 1)
+		deadLetters := newDeadLetterList()
+		ctx := contextWithDeadLetterList(context.Background(), deadLetters)
+		client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 2, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+
+		// act
+		groupMembers, err := client.GetGroupsWithMembers(ctx)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(groupMembers))
+		assert.Equal(t, 0, len(deadLetters.list()))
+	})
+
+	t.Run("DeadLettersGroupThatKeepsFailingAndContinuesWithTheOthers", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.failMemberLists("ci-release@example.com", 2)
+		deadLetters := newDeadLetterList()
+		ctx := contextWithDeadLetterList(context.Background(), deadLetters)
+		client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 2, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+
+		// act
+		groupMembers, err := client.GetGroupsWithMembers(ctx)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(groupMembers))
+		if assert.Equal(t, 1, len(deadLetters.list())) {
+			assert.Equal(t, "ci-release@example.com", deadLetters.list()[0].Group)
+			assert.Equal(t, 2, deadLetters.list()[0].Attempts)
+		}
+	})
+
+	t.Run("FailsFetchWithoutDeadLetterList", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.failMemberLists("ci-platform@example.com", 1)
+		ctx := context.Background()
+		client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+
+		// act
+		_, err = client.GetGroupsWithMembers(ctx)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestDeadLetteredGroupIDs(t *testing.T) {
+	t.Run("ReturnsEstafetteGroupsWithIdentityOfDeadLetteredGroup", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		provider, err := NewGsuiteClient(context.Background(), "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com"}}},
+			{ID: "g2", Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-release@example.com"}}},
+			{ID: "g3", Name: "other", Identities: []*contracts.GroupIdentity{{Provider: "hr", ID: "ci-release@example.com"}}},
+		}
+
+		// act
+		ids := deadLetteredGroupIDs(groups, provider, []*DeadLetter{{Group: "ci-release@example.com"}})
+
+		assert.Equal(t, []string{"g2"}, ids)
+	})
+}
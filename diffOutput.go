@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiReset  = "\x1b[0m"
+)
+
+// GroupDiff is the change to a single estafette group in a diff: created, deleted, renamed or updated, with the members added to and removed from it
+type GroupDiff struct {
+	Group          string   `json:"group"`
+	Change         string   `json:"change,omitempty"`
+	OldName        string   `json:"oldName,omitempty"`
+	AddedMembers   []string `json:"addedMembers,omitempty"`
+	RemovedMembers []string `json:"removedMembers,omitempty"`
+}
+
+// Diff is the human-readable form of the actions of a sync, grouped by estafette group; changes that aren't about groups, like user profiles and organizations, are listed as they are
+type Diff struct {
+	Groups       []*GroupDiff `json:"groups"`
+	OtherChanges []string     `json:"otherChanges,omitempty"`
+}
+
+// newDiff groups the actions by estafette group, turning the group memberships changed by user actions into member deltas of the groups
+func newDiff(actions []*Action) *Diff {
+
+	diff := &Diff{Groups: make([]*GroupDiff, 0)}
+	byName := map[string]*GroupDiff{}
+	groupDiff := func(name string) *GroupDiff {
+		if d, ok := byName[name]; ok {
+			return d
+		}
+		d := &GroupDiff{Group: name}
+		byName[name] = d
+		diff.Groups = append(diff.Groups, d)
+		return d
+	}
+
+	for _, a := range actions {
+		switch a.Type {
+		case ActionCreateGroup:
+			groupDiff(a.Group.Name).Change = "create"
+
+		case ActionDeleteGroup:
+			groupDiff(a.Group.Name).Change = "delete"
+
+		case ActionUpdateGroup:
+			d := groupDiff(a.Group.Name)
+			d.Change = "update"
+			if a.GroupBefore.Name != a.Group.Name {
+				d.Change = "rename"
+				d.OldName = a.GroupBefore.Name
+			}
+
+		case ActionUpdateUser:
+			added, removed := diffGroupNames(a.UserBefore.Groups, a.User.Groups)
+			for _, name := range added {
+				d := groupDiff(name)
+				d.AddedMembers = append(d.AddedMembers, a.User.GetEmail())
+			}
+			for _, name := range removed {
+				d := groupDiff(name)
+				d.RemovedMembers = append(d.RemovedMembers, a.User.GetEmail())
+			}
+			if len(added) == 0 && len(removed) == 0 {
+				diff.OtherChanges = append(diff.OtherChanges, a.String())
+			}
+
+		default:
+			diff.OtherChanges = append(diff.OtherChanges, a.String())
+		}
+	}
+
+	sort.Slice(diff.Groups, func(i, j int) bool { return diff.Groups[i].Group < diff.Groups[j].Group })
+	for _, d := range diff.Groups {
+		sort.Strings(d.AddedMembers)
+		sort.Strings(d.RemovedMembers)
+	}
+	sort.Strings(diff.OtherChanges)
+
+	return diff
+}
+
+// writeDiff writes the diff in the output format, one of text, json, table and markdown; text is colored if color is set
+func writeDiff(w io.Writer, diff *Diff, output string, color bool) error {
+	switch output {
+	case "json":
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+
+	case "table":
+		return writeDiffTable(w, diff)
+
+	case "markdown":
+		return writeDiffMarkdown(w, diff)
+	}
+
+	return writeDiffText(w, diff, color)
+}
+
+// writeDiffText writes a line per group, created groups green and prefixed with +, deleted ones red and prefixed with - and renamed or updated ones yellow and prefixed with ~, followed by the members added to and removed from it
+func writeDiffText(w io.Writer, diff *Diff, color bool) error {
+	paint := func(code, text string) string {
+		if !color {
+			return text
+		}
+		return code + text + ansiReset
+	}
+
+	var b bytes.Buffer
+	for _, d := range diff.Groups {
+		switch d.Change {
+		case "create":
+			b.WriteString(paint(ansiGreen, "+ "+d.Group) + "\n")
+		case "delete":
+			b.WriteString(paint(ansiRed, "- "+d.Group) + "\n")
+		case "rename":
+			b.WriteString(paint(ansiYellow, fmt.Sprintf("~ %v → %v", d.OldName, d.Group)) + "\n")
+		case "update":
+			b.WriteString(paint(ansiYellow, "~ "+d.Group) + "\n")
+		default:
+			b.WriteString("  " + d.Group + "\n")
+		}
+		for _, m := range d.AddedMembers {
+			b.WriteString("    " + paint(ansiGreen, "+ "+m) + "\n")
+		}
+		for _, m := range d.RemovedMembers {
+			b.WriteString("    " + paint(ansiRed, "- "+m) + "\n")
+		}
+	}
+	for _, c := range diff.OtherChanges {
+		b.WriteString(paint(ansiYellow, "~ "+c) + "\n")
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// writeDiffTable writes a row per group with its change and the number of members added and removed
+func writeDiffTable(w io.Writer, diff *Diff) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tCHANGE\tADDED\tREMOVED")
+	for _, d := range diff.Groups {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", d.Group, describeGroupChange(d), len(d.AddedMembers), len(d.RemovedMembers))
+	}
+	for _, c := range diff.OtherChanges {
+		fmt.Fprintf(tw, "\t%v\t\t\n", c)
+	}
+
+	return tw.Flush()
+}
+
+// writeDiffMarkdown writes the diff as a markdown table with the added and removed members, for pasting in a pull request or issue
+func writeDiffMarkdown(w io.Writer, diff *Diff) error {
+	var b bytes.Buffer
+	b.WriteString("| Group | Change | Added members | Removed members |\n")
+	b.WriteString("|---|---|---|---|\n")
+	for _, d := range diff.Groups {
+		fmt.Fprintf(&b, "| %v | %v | %v | %v |\n", escapeMarkdownCell(d.Group), escapeMarkdownCell(describeGroupChange(d)), escapeMarkdownCell(strings.Join(d.AddedMembers, ", ")), escapeMarkdownCell(strings.Join(d.RemovedMembers, ", ")))
+	}
+	if len(diff.OtherChanges) > 0 {
+		b.WriteString("\n")
+		for _, c := range diff.OtherChanges {
+			fmt.Fprintf(&b, "- %v\n", c)
+		}
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// describeGroupChange returns the change of the group for the table and markdown outputs, with the old name of renamed groups
+func describeGroupChange(d *GroupDiff) string {
+	switch {
+	case d.Change == "rename":
+		return "rename from " + d.OldName
+	case d.Change == "":
+		return "members"
+	}
+
+	return d.Change
+}
+
+// escapeMarkdownCell escapes the pipes in the text, so it stays within its table cell
+func escapeMarkdownCell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
+
+// useColor checks whether to color the diff for the --color setting; with auto only output to a terminal is colored, unless NO_COLOR is set
+func useColor(setting string, f *os.File) bool {
+	switch setting {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	stat, err := f.Stat()
+
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
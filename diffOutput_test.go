@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDiff(t *testing.T) {
+	t.Run("GroupsMembershipChangesOfUsersByGroup", func(t *testing.T) {
+
+		platform := &contracts.Group{ID: "g1", Name: "platform"}
+		release := &contracts.Group{ID: "g2", Name: "release"}
+		actions := []*Action{
+			{Type: ActionCreateGroup, Group: &contracts.Group{Name: "security"}},
+			{Type: ActionUpdateGroup, GroupBefore: &contracts.Group{ID: "g2", Name: "releases"}, Group: release},
+			{Type: ActionDeleteGroup, GroupBefore: &contracts.Group{ID: "g3", Name: "legacy"}, Group: &contracts.Group{ID: "g3", Name: "legacy"}},
+			{Type: ActionUpdateUser, UserBefore: &contracts.User{Identities: []*contracts.UserIdentity{{Email: "john@example.com"}}, Groups: []*contracts.Group{platform}}, User: &contracts.User{Identities: []*contracts.UserIdentity{{Email: "john@example.com"}}, Groups: []*contracts.Group{release}}},
+			{Type: ActionUpdateUser, UserBefore: &contracts.User{Identities: []*contracts.UserIdentity{{Email: "jane@example.com"}}}, User: &contracts.User{Identities: []*contracts.UserIdentity{{Email: "jane@example.com"}}, Groups: []*contracts.Group{platform}}},
+		}
+
+		// act
+		diff := newDiff(actions)
+
+		assert.Equal(t, []*GroupDiff{
+			{Group: "legacy", Change: "delete"},
+			{Group: "platform", AddedMembers: []string{"jane@example.com"}, RemovedMembers: []string{"john@example.com"}},
+			{Group: "release", Change: "rename", OldName: "releases", AddedMembers: []string{"john@example.com"}},
+			{Group: "security", Change: "create"},
+		}, diff.Groups)
+		assert.Empty(t, diff.OtherChanges)
+	})
+}
+
+func TestWriteDiff(t *testing.T) {
+
+	diff := &Diff{Groups: []*GroupDiff{
+		{Group: "platform", Change: "create", AddedMembers: []string{"john@example.com"}},
+		{Group: "release", Change: "rename", OldName: "releases", RemovedMembers: []string{"jane@example.com"}},
+	}}
+
+	t.Run("WritesColoredText", func(t *testing.T) {
+
+		var b bytes.Buffer
+
+		// act
+		err := writeDiff(&b, diff, "text", true)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "\x1b[32m+ platform\x1b[0m\n    \x1b[32m+ john@example.com\x1b[0m\n\x1b[33m~ releases → release\x1b[0m\n    \x1b[31m- jane@example.com\x1b[0m\n", b.String())
+	})
+
+	t.Run("WritesPlainTextWithoutColor", func(t *testing.T) {
+
+		var b bytes.Buffer
+
+		// act
+		err := writeDiff(&b, diff, "text", false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "+ platform\n    + john@example.com\n~ releases → release\n    - jane@example.com\n", b.String())
+	})
+
+	t.Run("WritesTable", func(t *testing.T) {
+
+		var b bytes.Buffer
+
+		// act
+		err := writeDiff(&b, diff, "table", false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "GROUP     CHANGE                ADDED  REMOVED\nplatform  create                1      0\nrelease   rename from releases  0      1\n", b.String())
+	})
+
+	t.Run("WritesMarkdown", func(t *testing.T) {
+
+		var b bytes.Buffer
+
+		// act
+		err := writeDiff(&b, diff, "markdown", false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "| Group | Change | Added members | Removed members |\n|---|---|---|---|\n| platform | create | john@example.com |  |\n| release | rename from releases |  | jane@example.com |\n", b.String())
+	})
+
+	t.Run("WritesJSON", func(t *testing.T) {
+
+		var b bytes.Buffer
+
+		// act
+		err := writeDiff(&b, diff, "json", false)
+
+		assert.Nil(t, err)
+		assert.JSONEq(t, `{"groups":[{"group":"platform","change":"create","addedMembers":["john@example.com"]},{"group":"release","change":"rename","oldName":"releases","removedMembers":["jane@example.com"]}]}`, b.String())
+	})
+}
+
+func TestUseColor(t *testing.T) {
+	t.Run("OnlyColorsTerminalsWithAuto", func(t *testing.T) {
+
+		f, err := os.Open(os.DevNull)
+		assert.Nil(t, err)
+		defer f.Close()
+
+		// act
+		auto := useColor("auto", f)
+		always := useColor("always", f)
+
+		// /dev/null is a character device, but NO_COLOR may be set in the environment running the tests
+		_, noColor := os.LookupEnv("NO_COLOR")
+		assert.Equal(t, !noColor, auto)
+		assert.True(t, always)
+		assert.False(t, useColor("never", f))
+	})
+}
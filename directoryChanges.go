@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	changeEventGroupAdded    = "group-added"
+	changeEventGroupDeleted  = "group-deleted"
+	changeEventGroupRenamed  = "group-renamed"
+	changeEventMemberAdded   = "member-added"
+	changeEventMemberRemoved = "member-removed"
+)
+
+// DirectorySnapshot holds the directory groups and their members as fetched by a sync, to detect changes in the directory between syncs
+type DirectorySnapshot struct {
+	Provider string `json:"provider"`
+	// Groups are the directory groups by their id
+	Groups map[string]*DirectorySnapshotGroup `json:"groups"`
+}
+
+// DirectorySnapshotGroup is a directory group in a DirectorySnapshot
+type DirectorySnapshotGroup struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	// Members are the emails of the members, or their ids for members without email, sorted
+	Members []string `json:"members"`
+}
+
+// ChangeEvent is a change in the directory since the previous sync, regardless of whether estafette needed to change for it
+type ChangeEvent struct {
+	Type     string    `json:"type"`
+	Provider string    `json:"provider"`
+	GroupID  string    `json:"groupID"`
+	Group    string    `json:"group"`
+	Member   string    `json:"member,omitempty"`
+	Previous string    `json:"previous,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// String returns a human-readable description of the event
+func (e *ChangeEvent) String() string {
+	switch e.Type {
+	case changeEventGroupAdded:
+		return fmt.Sprintf("%v group %v added", e.Provider, e.Group)
+	case changeEventGroupDeleted:
+		return fmt.Sprintf("%v group %v deleted", e.Provider, e.Group)
+	case changeEventGroupRenamed:
+		return fmt.Sprintf("%v group %v renamed to %v", e.Provider, e.Previous, e.Group)
+	case changeEventMemberAdded:
+		return fmt.Sprintf("member %v added to %v group %v", e.Member, e.Provider, e.Group)
+	case changeEventMemberRemoved:
+		return fmt.Sprintf("member %v removed from %v group %v", e.Member, e.Provider, e.Group)
+	}
+
+	return fmt.Sprintf("%v %v", e.Type, e.Group)
+}
+
+// newDirectorySnapshot returns the snapshot of the fetched directory groups and members
+func newDirectorySnapshot(provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) *DirectorySnapshot {
+
+	snapshot := &DirectorySnapshot{
+		Provider: provider.Name(),
+		Groups:   map[string]*DirectorySnapshotGroup{},
+	}
+
+	for gg, members := range groupMembers {
+		group := &DirectorySnapshotGroup{
+			Name:    gg.Name,
+			Email:   gg.Email,
+			Members: make([]string, 0, len(members)),
+		}
+		for _, m := range members {
+			if m.Email != "" {
+				group.Members = append(group.Members, m.Email)
+			} else {
+				group.Members = append(group.Members, m.ID)
+			}
+		}
+		sort.Strings(group.Members)
+
+		snapshot.Groups[gg.ID] = group
+	}
+
+	return snapshot
+}
+
+// diffDirectorySnapshots returns the change events between the previous and current snapshot, sorted by group and type
+func diffDirectorySnapshots(previous, current *DirectorySnapshot, now time.Time) (events []*ChangeEvent) {
+
+	events = make([]*ChangeEvent, 0)
+
+	event := func(eventType, groupID string, group *DirectorySnapshotGroup) *ChangeEvent {
+		e := &ChangeEvent{
+			Type:     eventType,
+			Provider: current.Provider,
+			GroupID:  groupID,
+			Group:    group.Name,
+			Time:     now,
+		}
+		events = append(events, e)
+		return e
+	}
+
+	for id, group := range current.Groups {
+		previousGroup, ok := previous.Groups[id]
+		if !ok {
+			event(changeEventGroupAdded, id, group)
+			for _, m := range group.Members {
+				event(changeEventMemberAdded, id, group).Member = m
+			}
+			continue
+		}
+
+		if previousGroup.Name != group.Name {
+			event(changeEventGroupRenamed, id, group).Previous = previousGroup.Name
+		}
+
+		previousMembers := map[string]bool{}
+		for _, m := range previousGroup.Members {
+			previousMembers[m] = true
+		}
+		for _, m := range group.Members {
+			if !previousMembers[m] {
+				event(changeEventMemberAdded, id, group).Member = m
+			}
+			delete(previousMembers, m)
+		}
+		for m := range previousMembers {
+			event(changeEventMemberRemoved, id, group).Member = m
+		}
+	}
+
+	for id, previousGroup := range previous.Groups {
+		if _, ok := current.Groups[id]; !ok {
+			event(changeEventGroupDeleted, id, previousGroup)
+		}
+	}
+
+	// group events come before member events of the same group, so a feed reads like the changes happened
+	order := map[string]int{changeEventGroupAdded: 0, changeEventGroupRenamed: 1, changeEventMemberAdded: 2, changeEventMemberRemoved: 3, changeEventGroupDeleted: 4}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].GroupID != events[j].GroupID {
+			return events[i].GroupID < events[j].GroupID
+		}
+		if events[i].Type != events[j].Type {
+			return order[events[i].Type] < order[events[j].Type]
+		}
+		return events[i].Member < events[j].Member
+	})
+
+	return
+}
+
+// readDirectorySnapshot reads the directory snapshot of the previous sync from the state store; it returns nil if there's none yet
+func readDirectorySnapshot(ctx context.Context, path string) (*DirectorySnapshot, error) {
+
+	data, err := getStateStore().Read(ctx, path)
+	if errors.Is(err, ErrStateNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading directory snapshot %v: %w", path, err)
+	}
+
+	var snapshot DirectorySnapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling directory snapshot %v: %w", path, err)
+	}
+	if snapshot.Groups == nil {
+		snapshot.Groups = map[string]*DirectorySnapshotGroup{}
+	}
+
+	return &snapshot, nil
+}
+
+// writeDirectorySnapshot replaces the snapshot in the state store
+func writeDirectorySnapshot(ctx context.Context, path string, snapshot *DirectorySnapshot) error {
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = getStateStore().Write(ctx, path, data)
+	if err != nil {
+		return fmt.Errorf("Failed writing directory snapshot %v: %w", path, err)
+	}
+
+	return nil
+}
+
+// publishDirectoryChanges logs the changes in the directory since the snapshot in path and posts them to the publisher if not nil, then replaces the snapshot.
+// If publishing fails the snapshot is kept, so the events are published again with the next sync; failures are only logged since the events are informational.
+func publishDirectoryChanges(ctx context.Context, path string, publisher ChangeEventPublisher, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) (events []*ChangeEvent) {
+
+	current := newDirectorySnapshot(provider, groupMembers)
+
+	previous, err := readDirectorySnapshot(ctx, path)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed reading previous directory snapshot, not publishing directory changes")
+		return nil
+	}
+
+	if previous == nil || previous.Provider != current.Provider {
+		// without a previous snapshot every group would show up as added, so the first sync only records the baseline
+		logFromContext(ctx).Info().Msgf("Recording initial %v directory snapshot with %v groups in %v", current.Provider, len(current.Groups), path)
+	} else {
+		events = diffDirectorySnapshots(previous, current, time.Now().UTC())
+		for _, e := range events {
+			logFromContext(ctx).Info().Str("event", e.Type).Str("group", e.Group).Str("member", e.Member).Msgf("Directory change: %v", e)
+		}
+
+		if publisher != nil && len(events) > 0 {
+			err = publisher.Publish(ctx, events)
+			if err != nil {
+				logFromContext(ctx).Warn().Err(err).Msgf("Failed publishing %v directory changes, publishing them again next sync", len(events))
+				return events
+			}
+		}
+	}
+
+	err = writeDirectorySnapshot(ctx, path, current)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed writing directory snapshot")
+	}
+
+	return events
+}
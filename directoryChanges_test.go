@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffDirectorySnapshots(t *testing.T) {
+	t.Run("ReturnsGroupAndMemberChanges", func(t *testing.T) {
+
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		previous := newDirectorySnapshot(&gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "g1", Name: "ci-platform"}: {{ID: "m1", Email: "alice@example.com"}, {ID: "m2", Email: "bob@example.com"}},
+			{ID: "g2", Name: "ci-release"}:  {{ID: "m1", Email: "alice@example.com"}},
+		})
+		current := newDirectorySnapshot(&gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "g1", Name: "ci-platform-team"}: {{ID: "m1", Email: "alice@example.com"}, {ID: "m3"}},
+			{ID: "g3", Name: "ci-admins"}:        {{ID: "m2", Email: "bob@example.com"}},
+		})
+
+		// act
+		events := diffDirectorySnapshots(previous, current, now)
+
+		assert.Equal(t, []*ChangeEvent{
+			{Type: changeEventGroupRenamed, Provider: gsuiteProviderName, GroupID: "g1", Group: "ci-platform-team", Previous: "ci-platform", Time: now},
+			{Type: changeEventMemberAdded, Provider: gsuiteProviderName, GroupID: "g1", Group: "ci-platform-team", Member: "m3", Time: now},
+			{Type: changeEventMemberRemoved, Provider: gsuiteProviderName, GroupID: "g1", Group: "ci-platform-team", Member: "bob@example.com", Time: now},
+			{Type: changeEventGroupDeleted, Provider: gsuiteProviderName, GroupID: "g2", Group: "ci-release", Time: now},
+			{Type: changeEventGroupAdded, Provider: gsuiteProviderName, GroupID: "g3", Group: "ci-admins", Time: now},
+			{Type: changeEventMemberAdded, Provider: gsuiteProviderName, GroupID: "g3", Group: "ci-admins", Member: "bob@example.com", Time: now},
+		}, events)
+		assert.Equal(t, "gsuite group ci-platform renamed to ci-platform-team", events[0].String())
+	})
+}
+
+func TestPublishDirectoryChanges(t *testing.T) {
+	t.Run("RecordsBaselineOnFirstSyncAndPublishesChangesAfterwards", func(t *testing.T) {
+
+		published := make([]*ChangeEvent, 0)
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var events []*ChangeEvent
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&events))
+			published = append(published, events...)
+		}))
+		defer webhook.Close()
+		publisher := NewWebhookPublisher(webhook.URL, 10*time.Second)
+		dir, _ := ioutil.TempDir("", "directory")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "directory.json")
+		platform := &DirectoryGroup{ID: "g1", Name: "ci-platform"}
+
+		// act
+		initialEvents := publishDirectoryChanges(context.Background(), path, publisher, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{platform: {{ID: "m1", Email: "alice@example.com"}}})
+		events := publishDirectoryChanges(context.Background(), path, publisher, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{platform: {}})
+
+		assert.Equal(t, 0, len(initialEvents))
+		if assert.Equal(t, 1, len(events)) {
+			assert.Equal(t, changeEventMemberRemoved, events[0].Type)
+			assert.Equal(t, "alice@example.com", events[0].Member)
+		}
+		assert.Equal(t, 1, len(published))
+	})
+
+	t.Run("KeepsSnapshotIfPublishingFails", func(t *testing.T) {
+
+		webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer webhook.Close()
+		publisher := NewWebhookPublisher(webhook.URL, 10*time.Second)
+		dir, _ := ioutil.TempDir("", "directory")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "directory.json")
+		platform := &DirectoryGroup{ID: "g1", Name: "ci-platform"}
+		publishDirectoryChanges(context.Background(), path, nil, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{platform: {}})
+
+		// act
+		publishDirectoryChanges(context.Background(), path, publisher, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{platform: {{ID: "m1", Email: "alice@example.com"}}})
+
+		snapshot, err := readDirectorySnapshot(context.Background(), path)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, snapshot.Groups["g1"].Members)
+	})
+}
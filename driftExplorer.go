@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// driftExplorer lets an operator browse the directory groups, their estafette counterparts and the pending changes in a terminal, pick which changes to apply and apply them, for onboarding an existing estafette installation one group at a time
+type driftExplorer struct {
+	rows     []*explorerRow
+	actions  []*Action
+	selected []bool
+	// apply applies the selected actions
+	apply func(ctx context.Context, actions []*Action) error
+}
+
+// explorerRow is a directory group with its estafette group, or an estafette group of the provider whose directory group is gone, and the pending changes touching it
+type explorerRow struct {
+	name      string
+	directory *DirectoryGroup
+	members   int
+	estafette *contracts.Group
+	// actions are the indexes of the actions touching the group
+	actions []int
+}
+
+// newDriftExplorer returns an explorer for the state and the actions planned for it, with all actions selected
+func newDriftExplorer(s state, actions []*Action, apply func(ctx context.Context, actions []*Action) error) *driftExplorer {
+	e := &driftExplorer{
+		actions:  actions,
+		selected: make([]bool, len(actions)),
+		apply:    apply,
+	}
+	for i := range e.selected {
+		e.selected[i] = true
+	}
+
+	directoryGroups := make([]*DirectoryGroup, 0, len(s.groupMembers))
+	for gg := range s.groupMembers {
+		directoryGroups = append(directoryGroups, gg)
+	}
+	sort.Slice(directoryGroups, func(i, j int) bool {
+		return directoryGroups[i].Name < directoryGroups[j].Name
+	})
+
+	linked := map[*contracts.Group]bool{}
+	for _, gg := range directoryGroups {
+		row := &explorerRow{directory: gg, members: len(s.groupMembers[gg])}
+		for _, g := range s.groups {
+			if hasIdentity(g, s.provider.Name(), gg.ID) {
+				row.estafette = g
+				row.name = g.Name
+				linked[g] = true
+			}
+		}
+		// a group without estafette counterpart gets the name it's created with, if it's created at all
+		if row.estafette == nil {
+			for _, a := range actions {
+				if a.Type == ActionCreateGroup && hasIdentity(a.Group, s.provider.Name(), gg.ID) {
+					row.name = a.Group.Name
+				}
+			}
+		}
+		e.rows = append(e.rows, row)
+	}
+	for _, g := range s.groups {
+		if !linked[g] && hasProviderIdentity(g, s.provider.Name()) {
+			e.rows = append(e.rows, &explorerRow{name: g.Name, estafette: g})
+		}
+	}
+
+	for i, a := range actions {
+		for _, name := range actionGroupNames(a) {
+			for _, row := range e.rows {
+				if row.name != "" && strings.EqualFold(row.name, name) {
+					row.actions = append(row.actions, i)
+				}
+			}
+		}
+	}
+
+	return e
+}
+
+// hasIdentity checks whether the group has an identity of the provider with the id
+func hasIdentity(group *contracts.Group, provider, id string) bool {
+	for _, i := range group.Identities {
+		if i.Provider == provider && i.ID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasProviderIdentity checks whether the group has an identity of the provider, so it's managed by the syncer
+func hasProviderIdentity(group *contracts.Group, provider string) bool {
+	for _, i := range group.Identities {
+		if i.Provider == provider {
+			return true
+		}
+	}
+
+	return false
+}
+
+// actionGroupNames returns the names of the estafette groups the action changes, or changes the members of
+func actionGroupNames(a *Action) []string {
+	switch a.Type {
+	case ActionCreateGroup, ActionUpdateGroup, ActionDeleteGroup:
+		names := []string{a.Group.Name}
+		if a.GroupBefore != nil && a.GroupBefore.Name != a.Group.Name {
+			names = append(names, a.GroupBefore.Name)
+		}
+		return names
+
+	case ActionUpdateUser:
+		added, removed := diffGroupNames(a.UserBefore.Groups, a.User.Groups)
+		return append(added, removed...)
+	}
+
+	return nil
+}
+
+// explorerView is a screen of the drift explorer
+type explorerView int
+
+const (
+	// explorerGroupsView lists the groups with their pending changes
+	explorerGroupsView explorerView = iota
+	// explorerGroupView shows a single group with its pending changes
+	explorerGroupView
+	// explorerChangesView lists all pending changes
+	explorerChangesView
+)
+
+// explorerChrome is the number of lines the explorer shows around the list of groups or changes
+const explorerChrome = 8
+
+// explorerModel is the bubbletea model showing the drift explorer in the terminal
+type explorerModel struct {
+	ctx      context.Context
+	explorer *driftExplorer
+	view     explorerView
+	// group is the row shown in the group view
+	group int
+	// groupCursor and changeCursor are the highlighted row of the groups view and change of the group or changes view
+	groupCursor  int
+	changeCursor int
+	// height is the height of the terminal, or zero as long as it's unknown
+	height     int
+	confirming bool
+	applying   bool
+	status     string
+	err        error
+}
+
+// explorerAppliedMsg reports the outcome of applying the selected changes
+type explorerAppliedMsg struct {
+	actions int
+	err     error
+}
+
+// run shows the explorer in the terminal until the selected changes are applied or the operator quits
+func (e *driftExplorer) run(ctx context.Context, in io.Reader, out io.Writer) error {
+	model := &explorerModel{ctx: ctx, explorer: e}
+
+	_, err := tea.NewProgram(model, tea.WithContext(ctx), tea.WithInput(in), tea.WithOutput(out), tea.WithAltScreen()).Run()
+	if err != nil {
+		return err
+	}
+	if model.status != "" {
+		fmt.Fprintln(out, model.status)
+	}
+
+	return model.err
+}
+
+func (m *explorerModel) Init() tea.Cmd {
+	return nil
+}
+
+func (m *explorerModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
+
+	case explorerAppliedMsg:
+		m.applying = false
+		if msg.err != nil {
+			m.err = fmt.Errorf("Failed applying %v changes: %w", msg.actions, msg.err)
+			m.status = ""
+			return m, tea.Quit
+		}
+		m.status = fmt.Sprintf("applied %v changes", msg.actions)
+		return m, tea.Quit
+
+	case tea.KeyMsg:
+		return m.handleKey(msg.String())
+	}
+
+	return m, nil
+}
+
+func (m *explorerModel) handleKey(key string) (tea.Model, tea.Cmd) {
+	// the changes are applied in the background, quitting halfway would leave them partially applied
+	if m.applying {
+		return m, nil
+	}
+	m.status = ""
+
+	if m.confirming {
+		m.confirming = false
+		if !strings.EqualFold(key, "y") {
+			m.status = "not applied"
+			return m, nil
+		}
+		selected := m.explorer.selectedActions()
+		m.applying = true
+		m.status = fmt.Sprintf("applying %v changes...", len(selected))
+		return m, func() tea.Msg {
+			return explorerAppliedMsg{actions: len(selected), err: m.explorer.apply(m.ctx, selected)}
+		}
+	}
+
+	switch key {
+	case "q", "ctrl+c":
+		return m, tea.Quit
+	case "up", "k":
+		m.moveCursor(-1)
+	case "down", "j":
+		m.moveCursor(1)
+	case "enter":
+		if m.view == explorerGroupsView && len(m.explorer.rows) > 0 {
+			m.view, m.group, m.changeCursor = explorerGroupView, m.groupCursor, 0
+		}
+	case "esc", "backspace":
+		m.view = explorerGroupsView
+	case "tab":
+		if m.view == explorerChangesView {
+			m.view = explorerGroupsView
+		} else {
+			m.view, m.changeCursor = explorerChangesView, 0
+		}
+	case " ", "x":
+		if changes := m.changes(); m.view != explorerGroupsView && len(changes) > 0 {
+			i := changes[m.changeCursor]
+			m.explorer.selected[i] = !m.explorer.selected[i]
+		}
+	case "a":
+		m.explorer.selectAll(true)
+		m.status = fmt.Sprintf("selected all %v changes", len(m.explorer.actions))
+	case "n":
+		m.explorer.selectAll(false)
+		m.status = "deselected all changes"
+	case "p":
+		if len(m.explorer.selectedActions()) == 0 {
+			m.status = "no changes selected"
+			break
+		}
+		m.confirming = true
+	}
+
+	return m, nil
+}
+
+// moveCursor moves the cursor of the current view by delta, within the rows or changes it shows
+func (m *explorerModel) moveCursor(delta int) {
+	cursor, length := &m.changeCursor, len(m.changes())
+	if m.view == explorerGroupsView {
+		cursor, length = &m.groupCursor, len(m.explorer.rows)
+	}
+	*cursor += delta
+	if *cursor >= length {
+		*cursor = length - 1
+	}
+	if *cursor < 0 {
+		*cursor = 0
+	}
+}
+
+// changes returns the indexes of the actions the group or changes view shows
+func (m *explorerModel) changes() []int {
+	if m.view == explorerGroupView {
+		return m.explorer.rows[m.group].actions
+	}
+
+	indexes := make([]int, len(m.explorer.actions))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	return indexes
+}
+
+func (m *explorerModel) View() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%v groups, %v of %v pending changes selected\n\n", len(m.explorer.rows), len(m.explorer.selectedActions()), len(m.explorer.actions))
+
+	switch m.view {
+	case explorerGroupsView:
+		lines := make([]string, len(m.explorer.rows))
+		for i, row := range m.explorer.rows {
+			lines[i] = describeExplorerRow(i, row)
+		}
+		m.writeList(&b, lines, m.groupCursor)
+		b.WriteString("\n↑/↓ move • enter show group • tab all changes • a/n select all/none • p apply • q quit\n")
+
+	case explorerGroupView:
+		row := m.explorer.rows[m.group]
+		if row.directory != nil {
+			fmt.Fprintf(&b, "directory group %v (%v), %v members\n", row.directory.Name, row.directory.ID, row.members)
+		} else {
+			b.WriteString("no directory group\n")
+		}
+		if row.estafette != nil {
+			fmt.Fprintf(&b, "estafette group %v (%v), roles %v\n", row.estafette.Name, row.estafette.ID, strings.Join(groupRoles(row.estafette), ", "))
+		} else {
+			b.WriteString("no estafette group\n")
+		}
+		if len(row.actions) == 0 {
+			b.WriteString("in sync\n")
+		}
+		m.writeList(&b, m.describeChanges(row.actions), m.changeCursor)
+		b.WriteString("\n↑/↓ move • space select • esc back • p apply • q quit\n")
+
+	case explorerChangesView:
+		if len(m.explorer.actions) == 0 {
+			b.WriteString("no pending changes, estafette is in sync\n")
+		}
+		m.writeList(&b, m.describeChanges(m.changes()), m.changeCursor)
+		b.WriteString("\n↑/↓ move • space select • a/n select all/none • tab groups • p apply • q quit\n")
+	}
+
+	switch {
+	case m.confirming:
+		fmt.Fprintf(&b, "apply %v of %v changes? a user added to a group that's created needs both changes [y/N] ", len(m.explorer.selectedActions()), len(m.explorer.actions))
+	case m.status != "":
+		b.WriteString(m.status)
+	}
+
+	return b.String()
+}
+
+// writeList writes the lines that fit the terminal, scrolled so the line under the cursor is visible and marked
+func (m *explorerModel) writeList(b *strings.Builder, lines []string, cursor int) {
+	from, to := 0, len(lines)
+	if visible := m.height - explorerChrome; m.height > 0 && visible > 0 && len(lines) > visible {
+		from = cursor - visible/2
+		if from < 0 {
+			from = 0
+		}
+		if from+visible > len(lines) {
+			from = len(lines) - visible
+		}
+		to = from + visible
+	}
+
+	for i := from; i < to; i++ {
+		mark := "  "
+		if i == cursor {
+			mark = "> "
+		}
+		b.WriteString(mark + lines[i] + "\n")
+	}
+}
+
+// describeExplorerRow returns the 1-based number of the row with its directory and estafette group and number of pending changes
+func describeExplorerRow(i int, row *explorerRow) string {
+	directoryName, estafetteName := "-", "-"
+	if row.directory != nil {
+		directoryName = row.directory.Name
+	}
+	if row.estafette != nil {
+		estafetteName = row.estafette.Name
+	} else if row.name != "" {
+		estafetteName = row.name + " (new)"
+	}
+
+	return fmt.Sprintf("%4d  %v -> %v, %v pending changes", i+1, directoryName, estafetteName, len(row.actions))
+}
+
+// describeChanges returns the actions with the indexes with their 1-based number, and [x] marking the selected ones
+func (m *explorerModel) describeChanges(indexes []int) []string {
+	lines := make([]string, len(indexes))
+	for n, i := range indexes {
+		mark := " "
+		if m.explorer.selected[i] {
+			mark = "x"
+		}
+		lines[n] = fmt.Sprintf("[%v] %4d  %v", mark, i+1, m.explorer.actions[i])
+	}
+
+	return lines
+}
+
+func (e *driftExplorer) selectAll(selected bool) {
+	for i := range e.selected {
+		e.selected[i] = selected
+	}
+}
+
+// selectedActions returns the selected actions in their planned order
+func (e *driftExplorer) selectedActions() []*Action {
+	actions := make([]*Action, 0, len(e.actions))
+	for i, a := range e.actions {
+		if e.selected[i] {
+			actions = append(actions, a)
+		}
+	}
+
+	return actions
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDriftExplorer(t *testing.T) {
+	newExplorer := func(applied *[]*Action) *driftExplorer {
+		platform := &DirectoryGroup{ID: "0abc", Name: "ci-platform"}
+		release := &DirectoryGroup{ID: "0def", Name: "ci-release"}
+		s := state{
+			provider: &gsuiteClient{},
+			groups: []*contracts.Group{
+				{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-platform"}}},
+				{ID: "g2", Name: "legacy", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0xyz", Name: "ci-legacy"}}},
+			},
+			groupMembers: map[*DirectoryGroup][]*DirectoryMember{
+				platform: {{ID: "1234"}},
+				release:  {{ID: "5678"}},
+			},
+		}
+		actions := []*Action{
+			{Type: ActionCreateGroup, Group: &contracts.Group{Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0def", Name: "ci-release"}}}},
+			{Type: ActionDeleteGroup, Group: s.groups[1]},
+		}
+
+		return newDriftExplorer(s, actions, func(ctx context.Context, actions []*Action) error {
+			*applied = actions
+			return nil
+		})
+	}
+
+	// press sends the keys to the model, running the commands they return like the program would
+	press := func(m *explorerModel, keys ...string) {
+		keyTypes := map[string]tea.KeyType{"enter": tea.KeyEnter, "esc": tea.KeyEsc, "tab": tea.KeyTab, "down": tea.KeyDown, "up": tea.KeyUp, " ": tea.KeySpace}
+		for _, key := range keys {
+			var msg tea.Msg = tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(key)}
+			if keyType, ok := keyTypes[key]; ok {
+				msg = tea.KeyMsg{Type: keyType}
+			}
+			for msg != nil {
+				_, cmd := m.Update(msg)
+				msg = nil
+				if cmd != nil {
+					msg = cmd()
+				}
+				if _, ok := msg.(tea.QuitMsg); ok {
+					msg = nil
+				}
+			}
+		}
+	}
+
+	t.Run("ListsDirectoryGroupsWithTheirEstafetteGroupsAndOrphanedEstafetteGroups", func(t *testing.T) {
+
+		var applied []*Action
+		model := &explorerModel{ctx: context.Background(), explorer: newExplorer(&applied)}
+
+		// act
+		view := model.View()
+
+		assert.Contains(t, view, ">    1  ci-platform -> platform, 0 pending changes")
+		assert.Contains(t, view, "     2  ci-release -> release (new), 1 pending changes")
+		assert.Contains(t, view, "     3  - -> legacy, 1 pending changes")
+		assert.Nil(t, applied)
+	})
+
+	t.Run("ShowsPendingChangesOfSelectedGroup", func(t *testing.T) {
+
+		var applied []*Action
+		model := &explorerModel{ctx: context.Background(), explorer: newExplorer(&applied)}
+
+		// act
+		press(model, "down", "enter")
+
+		view := model.View()
+		assert.Contains(t, view, "directory group ci-release (0def), 1 members")
+		assert.Contains(t, view, "no estafette group")
+		assert.Contains(t, view, "> [x]    1  create group release")
+		assert.NotContains(t, view, "delete group legacy")
+	})
+
+	t.Run("AppliesOnlySelectedChangesOnceConfirmed", func(t *testing.T) {
+
+		var applied []*Action
+		model := &explorerModel{ctx: context.Background(), explorer: newExplorer(&applied)}
+
+		// act
+		press(model, "tab", "down", " ")
+		view := model.View()
+		press(model, "p", "y")
+
+		assert.Contains(t, view, "> [ ]    2  delete group legacy")
+		assert.Nil(t, model.err)
+		assert.Equal(t, "applied 1 changes", model.status)
+		if assert.Equal(t, 1, len(applied)) {
+			assert.Equal(t, ActionCreateGroup, applied[0].Type)
+		}
+	})
+
+	t.Run("DoesNotApplyWithoutConfirmation", func(t *testing.T) {
+
+		var applied []*Action
+		model := &explorerModel{ctx: context.Background(), explorer: newExplorer(&applied)}
+
+		// act
+		press(model, "p", "n")
+
+		assert.Contains(t, model.View(), "not applied")
+		assert.Nil(t, applied)
+	})
+
+	t.Run("ScrollsListToKeepCursorVisible", func(t *testing.T) {
+
+		var applied []*Action
+		model := &explorerModel{ctx: context.Background(), explorer: newExplorer(&applied)}
+		model.Update(tea.WindowSizeMsg{Height: explorerChrome + 1})
+
+		// act
+		press(model, "down", "down")
+
+		view := model.View()
+		assert.Contains(t, view, ">    3  - -> legacy")
+		assert.NotContains(t, view, "ci-platform")
+	})
+
+	t.Run("QuitsProgramWithoutApplying", func(t *testing.T) {
+
+		var applied []*Action
+		explorer := newExplorer(&applied)
+		var out bytes.Buffer
+
+		// act
+		err := explorer.run(context.Background(), strings.NewReader("q"), &out)
+
+		assert.Nil(t, err)
+		assert.Nil(t, applied)
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/estafette/estafette-ci-gsuite-synchronizer/reconcile"
+)
+
+// DuplicateGroups are estafette groups carrying the identity of the same directory group, left behind by past runs that created a group twice; the reconciler would update all copies, so only the surviving copy is synced and the others are protected until they're repaired
+type DuplicateGroups struct {
+	// ID is the id of the directory group in the identity
+	ID     string
+	Groups []*contracts.Group
+	// Survivor is the copy that's kept, the one with the most members or the lowest id if that's a tie
+	Survivor *contracts.Group
+}
+
+// DuplicateUsers are estafette users matching the same directory user, which the estafette api can't merge, so they're only flagged
+type DuplicateUsers struct {
+	// Key is the identity id or email address the users share
+	Key   string
+	Users []*contracts.User
+}
+
+// findDuplicateGroups returns the sets of estafette groups sharing an identity of the provider, ordered by identity id
+func findDuplicateGroups(groups []*contracts.Group, users []*contracts.User, provider Provider) []*DuplicateGroups {
+	if provider == nil {
+		return nil
+	}
+
+	byID := map[string][]*contracts.Group{}
+	for _, g := range groups {
+		ids := map[string]bool{}
+		for _, i := range g.Identities {
+			if i.Provider == provider.Name() && !ids[i.ID] {
+				ids[i.ID] = true
+				byID[i.ID] = append(byID[i.ID], g)
+			}
+		}
+	}
+
+	memberCounts := map[string]int{}
+	for _, u := range users {
+		for _, g := range u.Groups {
+			memberCounts[g.ID]++
+		}
+	}
+
+	duplicates := make([]*DuplicateGroups, 0)
+	for id, copies := range byID {
+		if len(copies) < 2 {
+			continue
+		}
+		sort.Slice(copies, func(i, j int) bool {
+			if memberCounts[copies[i].ID] != memberCounts[copies[j].ID] {
+				return memberCounts[copies[i].ID] > memberCounts[copies[j].ID]
+			}
+			return copies[i].ID < copies[j].ID
+		})
+		duplicates = append(duplicates, &DuplicateGroups{ID: id, Groups: copies, Survivor: copies[0]})
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].ID < duplicates[j].ID
+	})
+
+	return duplicates
+}
+
+// findDuplicateUsers returns the sets of estafette users matching the same directory user, ordered by the key they share
+func findDuplicateUsers(users []*contracts.User, provider Provider) []*DuplicateUsers {
+	if provider == nil {
+		return nil
+	}
+
+	duplicates := make([]*DuplicateUsers, 0)
+	for key, matches := range indexUsersByMemberKey(users, provider) {
+		if len(matches) < 2 {
+			continue
+		}
+		sorted := append([]*contracts.User{}, matches...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].ID < sorted[j].ID
+		})
+		duplicates = append(duplicates, &DuplicateUsers{Key: key, Users: sorted})
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].Key < duplicates[j].Key
+	})
+
+	return duplicates
+}
+
+// duplicateGroupIDs returns the ids of the duplicate copies that don't survive, which are protected for the run so the reconciler only updates the surviving copy
+func duplicateGroupIDs(groups []*contracts.Group, users []*contracts.User, provider Provider) (ids []string) {
+	for _, d := range findDuplicateGroups(groups, users, provider) {
+		for _, g := range d.Groups[1:] {
+			ids = append(ids, g.ID)
+		}
+	}
+
+	return ids
+}
+
+// logDuplicateIdentities warns about estafette groups and users carrying the same directory identity, pointing to the repair command
+func logDuplicateIdentities(ctx context.Context, s state) {
+	for _, d := range findDuplicateGroups(s.groups, s.users, s.provider) {
+		logFromContext(ctx).Warn().Msgf("Estafette groups %v carry the same %v identity %v, only syncing %v until they're merged with the repair command", strings.Join(groupIDs(d.Groups), ", "), s.provider.Name(), d.ID, d.Survivor.ID)
+	}
+	for _, d := range findDuplicateUsers(s.users, s.provider) {
+		logFromContext(ctx).Warn().Msgf("Estafette users %v match the same %v user %v", strings.Join(userIDs(d.Users), ", "), s.provider.Name(), d.Key)
+	}
+}
+
+// planDuplicateRepair returns the actions merging the duplicate copies into their survivor: the survivor gets the roles and organizations of all copies, the members of the other copies are moved to the survivor, and the other copies are deleted
+func planDuplicateRepair(duplicates []*DuplicateGroups, users []*contracts.User) (actions []*Action) {
+	actions = make([]*Action, 0)
+
+	for _, d := range duplicates {
+		roles := groupRoles(d.Survivor)
+		organizations := groupOrganizations(d.Survivor)
+		for _, g := range d.Groups[1:] {
+			roles = appendMissingStrings(roles, groupRoles(g))
+			organizations = appendMissingStrings(organizations, groupOrganizations(g))
+		}
+		if reconcile.SameStrings(roles, groupRoles(d.Survivor)) && reconcile.SameStrings(organizations, groupOrganizations(d.Survivor)) {
+			continue
+		}
+
+		merged := copyGroup(d.Survivor)
+		setGroupRoles(merged, roles)
+		for _, g := range d.Groups[1:] {
+			for _, o := range g.Organizations {
+				if !containsString(groupOrganizations(merged), o.Name) {
+					merged.Organizations = append(merged.Organizations, o)
+				}
+			}
+		}
+		actions = append(actions, &Action{Type: ActionUpdateGroup, GroupBefore: d.Survivor, Group: merged})
+	}
+
+	// users are updated once for all duplicates they're a member of
+	for _, u := range users {
+		updated := copyUser(u)
+		dirty := false
+		for _, d := range duplicates {
+			for _, g := range d.Groups[1:] {
+				if !removeUserGroup(updated, g.ID) {
+					continue
+				}
+				dirty = true
+				if !userHasGroup(updated, d.Survivor.ID) {
+					updated.Groups = append(updated.Groups, &contracts.Group{ID: d.Survivor.ID, Name: d.Survivor.Name})
+				}
+			}
+		}
+		if dirty {
+			actions = append(actions, &Action{Type: ActionUpdateUser, UserBefore: u, User: updated})
+		}
+	}
+
+	for _, d := range duplicates {
+		for _, g := range d.Groups[1:] {
+			actions = append(actions, &Action{Type: ActionDeleteGroup, GroupBefore: g, Group: g})
+		}
+	}
+
+	return actions
+}
+
+// appendMissingStrings appends the values of b that a doesn't hold yet to a
+func appendMissingStrings(a, b []string) []string {
+	for _, v := range b {
+		if !containsString(a, v) {
+			a = append(a, v)
+		}
+	}
+
+	return a
+}
+
+// containsString checks whether values holds the value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removeUserGroup removes the group with the id from the groups of the user and returns whether the user had it
+func removeUserGroup(user *contracts.User, id string) (removed bool) {
+	groups := make([]*contracts.Group, 0, len(user.Groups))
+	for _, g := range user.Groups {
+		if g.ID == id {
+			removed = true
+			continue
+		}
+		groups = append(groups, g)
+	}
+	user.Groups = groups
+
+	return
+}
+
+// describeDuplicates returns a line per set of duplicates, for the repair command to print
+func describeDuplicates(groups []*DuplicateGroups, users []*DuplicateUsers) []string {
+	lines := make([]string, 0, len(groups)+len(users))
+	for _, d := range groups {
+		lines = append(lines, fmt.Sprintf("groups %v share identity %v, keeping %v (%v)", strings.Join(groupIDs(d.Groups), ", "), d.ID, d.Survivor.Name, d.Survivor.ID))
+	}
+	for _, d := range users {
+		lines = append(lines, fmt.Sprintf("users %v share identity %v, merge them in estafette by hand", strings.Join(userIDs(d.Users), ", "), d.Key))
+	}
+
+	return lines
+}
+
+func groupIDs(groups []*contracts.Group) []string {
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+
+	return ids
+}
+
+func userIDs(users []*contracts.User) []string {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+
+	return ids
+}
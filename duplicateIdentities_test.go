@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDuplicateGroups(t *testing.T) {
+	t.Run("KeepsCopyWithMostMembers", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-platform"}}},
+			{ID: "g2", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-platform"}}},
+			{ID: "g3", Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0def", Name: "ci-release"}}},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Groups: []*contracts.Group{{ID: "g2", Name: "platform"}}},
+		}
+
+		// act
+		duplicates := findDuplicateGroups(groups, users, &gsuiteClient{})
+
+		if assert.Equal(t, 1, len(duplicates)) {
+			assert.Equal(t, "0abc", duplicates[0].ID)
+			assert.Equal(t, "g2", duplicates[0].Survivor.ID)
+		}
+		assert.Equal(t, []string{"g1"}, duplicateGroupIDs(groups, users, &gsuiteClient{}))
+	})
+}
+
+func TestFindDuplicateUsers(t *testing.T) {
+	t.Run("ReturnsUsersMatchingTheSameDirectoryUser", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{ID: "u2", Identities: []*contracts.UserIdentity{{Provider: "google", ID: "101", Email: "jane@example.com"}}},
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: "google", ID: "101", Email: "jane@example.com"}}},
+			{ID: "u3", Identities: []*contracts.UserIdentity{{Provider: "google", ID: "102", Email: "ted@example.com"}}},
+		}
+
+		// act
+		duplicates := findDuplicateUsers(users, &gsuiteClient{})
+
+		if assert.Equal(t, 1, len(duplicates)) {
+			assert.Equal(t, "101", duplicates[0].Key)
+			assert.Equal(t, "u1", duplicates[0].Users[0].ID)
+			assert.Equal(t, "u2", duplicates[0].Users[1].ID)
+		}
+	})
+}
+
+func TestPlanDuplicateRepair(t *testing.T) {
+	t.Run("MovesMembersToSurvivorAndDeletesOtherCopies", func(t *testing.T) {
+
+		administrator := "administrator"
+		survivor := &contracts.Group{ID: "g2", Name: "platform"}
+		duplicate := &contracts.Group{ID: "g1", Name: "platform", Roles: []*string{&administrator}}
+		duplicates := []*DuplicateGroups{{ID: "0abc", Groups: []*contracts.Group{survivor, duplicate}, Survivor: survivor}}
+		users := []*contracts.User{
+			{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}},
+			{ID: "u2", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}, {ID: "g2", Name: "platform"}}},
+			{ID: "u3", Groups: []*contracts.Group{{ID: "g2", Name: "platform"}}},
+		}
+
+		// act
+		actions := planDuplicateRepair(duplicates, users)
+
+		if assert.Equal(t, 4, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, []string{"administrator"}, groupRoles(actions[0].Group))
+			assert.Equal(t, ActionUpdateUser, actions[1].Type)
+			assert.Equal(t, []*contracts.Group{{ID: "g2", Name: "platform"}}, actions[1].User.Groups)
+			assert.Equal(t, ActionUpdateUser, actions[2].Type)
+			assert.Equal(t, []*contracts.Group{{ID: "g2", Name: "platform"}}, actions[2].User.Groups)
+			assert.Equal(t, ActionDeleteGroup, actions[3].Type)
+			assert.Equal(t, "g1", actions[3].Group.ID)
+		}
+	})
+}
@@ -0,0 +1,710 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// parseSyncFlags parses the flags for a sync against the fake apis, so all other flags get their defaults
+func parseSyncFlags(t *testing.T, directoryAPI *fakeDirectoryAPI, estafetteAPI *fakeEstafetteAPI, args ...string) {
+	// boolean flags set by an earlier test keep their value if they're not passed again, and repeatable flags accumulate
+	*syncForce = false
+	*apiIntegrationLog = false
+	*gsuiteSyncGroupSettings = false
+	*gsuiteGroupPrefixes = nil
+	*gsuiteCustomerIDs = nil
+	*triggerPipelineName = ""
+	*verifyMemberEmails = false
+	*approvalWebhookURL = ""
+	*gsuiteSyncMembershipExpiry = false
+	*gsuiteSyncDynamicGroups = false
+	*statsHistoryFile = ""
+	*auditLog = ""
+
+	_, err := kingpin.CommandLine.Parse(append([]string{
+		"sync",
+		"--api-base-url=" + estafetteAPI.URL,
+		"--client-id=syncer",
+		"--client-secret=secret",
+		"--gsuite-domain=example.com",
+		"--gsuite-admin-email=admin@example.com",
+		"--gsuite-group-prefix=ci-",
+		"--gsuite-api-endpoint=" + directoryAPI.URL,
+	}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncEndToEnd(t *testing.T) {
+	t.Run("CreatesGroupsAndUpdatesMembershipsOverMultipleRuns", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		directoryAPI.seedGroup("marketing@example.com", "marketing", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+		estafetteAPI.seedUser("u2", "5678", "jane@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--force")
+		ctx := context.Background()
+
+		// act
+		_, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"POST /api/groups"}, estafetteAPI.recordedMutations())
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{"PATCH /api/users/u1", "PATCH /api/users/u2"}, estafetteAPI.recordedMutations())
+		assert.Equal(t, "platform", estafetteAPI.users[0].Groups[0].Name)
+
+		directoryAPI.removeMember("ci-platform@example.com", "jane@example.com")
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PATCH /api/users/u2"}, estafetteAPI.recordedMutations())
+		assert.Equal(t, 0, len(estafetteAPI.users[1].Groups))
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("RollsBackTheChangesOfARunRecordedInTheAuditLog", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		dir, err := ioutil.TempDir("", "rollback")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		auditLogFile := filepath.Join(dir, "audit.jsonl")
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--force", "--audit-log="+auditLogFile)
+		ctx := context.Background()
+		createRun, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		membershipRun, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+		assert.Equal(t, 1, len(estafetteAPI.users[0].Groups))
+
+		readEntries := func(runID string) []*AuditEntry {
+			file, err := os.Open(auditLogFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			entries, err := readRunAuditEntries(file, runID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return entries
+		}
+
+		// act
+		_, err = rollbackRun(ctx, newApiClient(nil), readEntries(membershipRun.ID), false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PATCH /api/users/u1"}, estafetteAPI.recordedMutations())
+		assert.Equal(t, 0, len(estafetteAPI.users[0].Groups))
+
+		// act
+		_, err = rollbackRun(ctx, newApiClient(nil), readEntries(createRun.ID), false)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"DELETE /api/groups/g1"}, estafetteAPI.recordedMutations())
+		assert.Equal(t, 0, len(estafetteAPI.groups))
+	})
+
+	t.Run("AttachesIdentityToGroupThatAlreadyExistsWithTheSameName", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedGroup("g1", "platform")
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI)
+		ctx := context.Background()
+
+		// act
+		run, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"POST /api/groups", "PATCH /api/groups/g1"}, estafetteAPI.recordedMutations())
+		assert.Equal(t, ActionUpdateGroup, run.Actions[0].Type)
+		if assert.Equal(t, 1, len(estafetteAPI.groups)) && assert.Equal(t, 2, len(estafetteAPI.groups[0].Identities)) {
+			assert.Equal(t, "ci-platform@example.com", estafetteAPI.groups[0].Identities[0].ID)
+		}
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PATCH /api/users/u1"}, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("SkipsMembersThatArentUsersOfTheDomainWithVerifyMemberEmails", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		// an external account on a lookalike domain got added to the group
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "9999", Email: "john@examp1e.com"})
+		directoryAPI.seedUser("1234", "john@example.com")
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+		estafetteAPI.seedUser("u2", "9999", "john@examp1e.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--verify-member-emails")
+		ctx := context.Background()
+		_, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PATCH /api/users/u1"}, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("RemovesMembersOnceTheirMembershipExpired", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		directoryAPI.seedMembershipExpiry("ci-platform@example.com", "jane@example.com", time.Now().Add(-time.Minute))
+		expireTime := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+		directoryAPI.seedMembershipExpiry("ci-platform@example.com", "john@example.com", expireTime)
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+		estafetteAPI.seedUser("u2", "5678", "jane@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--gsuite-sync-membership-expiry")
+		ctx := context.Background()
+		_, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+
+		// act
+		run, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PATCH /api/users/u1"}, estafetteAPI.recordedMutations())
+		if assert.NotNil(t, run.NextMembershipExpiry) {
+			assert.True(t, expireTime.Equal(*run.NextMembershipExpiry))
+		}
+	})
+
+	t.Run("MarksGroupsOfDynamicDirectoryGroupsWithTheirQuery", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedDynamicGroup("ci-platform@example.com", "user.organizations.exists(org, org.department=='platform')")
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--gsuite-sync-dynamic-groups")
+
+		// act
+		_, err := syncOnce(context.Background(), &Config{})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(estafetteAPI.groups)) {
+			assert.Contains(t, estafetteAPI.groups[0].Identities, &contracts.GroupIdentity{Provider: "gsuite-dynamic", ID: "ci-platform@example.com", Name: "user.organizations.exists(org, org.department=='platform')"})
+		}
+	})
+
+	t.Run("RunReturnsReportWithAllActions", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI)
+
+		// act
+		report, err := runWithReport(context.Background(), &Config{})
+
+		assert.Nil(t, err)
+		assert.True(t, report.Succeeded)
+		assert.NotEqual(t, "", report.RunID)
+		assert.Equal(t, 2, report.DirectoryGroups)
+		if assert.Equal(t, 2, len(report.Actions)) {
+			assert.Equal(t, ActionCreateGroup, report.Actions[0].Type)
+			assert.Equal(t, "", report.Actions[0].Error)
+		}
+	})
+
+	t.Run("AbortsRunRemovingTooManyMembershipsWithoutForce", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI)
+		ctx := context.Background()
+		_, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		_, err = syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+
+		directoryAPI.removeMember("ci-platform@example.com", "john@example.com")
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("RemovesMembershipsOnlyOnceApproved", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		var requested approvalRequest
+		approvalAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				_ = json.NewDecoder(r.Body).Decode(&requested)
+				_ = json.NewEncoder(w).Encode(&approvalResponse{Status: ApprovalPending, StatusURL: "http://" + r.Host + "/status"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(&approvalResponse{Status: ApprovalApproved})
+		}))
+		defer approvalAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+		estafetteAPI.seedUser("u2", "5678", "jane@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--force", "--approval-webhook-url="+approvalAPI.URL, "--approval-poll-interval=10ms")
+		ctx := context.Background()
+		_, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		_, err = syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+
+		directoryAPI.removeMember("ci-platform@example.com", "jane@example.com")
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"PATCH /api/users/u2"}, estafetteAPI.recordedMutations())
+		if assert.Equal(t, 1, len(requested.Actions)) {
+			assert.Equal(t, "update user jane@example.com, remove from groups platform", requested.Actions[0].Description)
+		}
+	})
+
+	t.Run("KeepsMembershipsWhoseRemovalWasRejected", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		approvalAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(&approvalResponse{Status: ApprovalRejected})
+		}))
+		defer approvalAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+		estafetteAPI.seedUser("u2", "5678", "jane@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--force", "--approval-webhook-url="+approvalAPI.URL)
+		ctx := context.Background()
+		_, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		_, err = syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+
+		directoryAPI.removeMember("ci-platform@example.com", "jane@example.com")
+
+		// act
+		run, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+		if assert.Equal(t, 1, len(run.Actions)) {
+			assert.True(t, errors.Is(run.Actions[0].Err, ErrApprovalRejected))
+		}
+	})
+
+	t.Run("AbortsRunWhoseDirectoryMembersDroppedBeyondAnomalyThreshold", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		dir, err := ioutil.TempDir("", "stats-history")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+		estafetteAPI.seedUser("u2", "5678", "jane@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--max-change-ratio=1", "--stats-history-file="+filepath.Join(dir, "stats.json"))
+		ctx := context.Background()
+		_, err = syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+
+		directoryAPI.removeMember("ci-platform@example.com", "john@example.com")
+		directoryAPI.removeMember("ci-platform@example.com", "jane@example.com")
+
+		// act
+		run, err := syncOnce(ctx, &Config{})
+
+		assert.True(t, errors.Is(err, ErrStatsAnomaly))
+		assert.Equal(t, []*StatsAnomaly{{Metric: "directory members", Previous: 3, Current: 1, Change: -2.0 / 3}}, run.Anomalies)
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+
+		history, err := readStatsHistory(context.Background(), filepath.Join(dir, "stats.json"))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(history.Runs))
+	})
+
+	t.Run("LeavesGroupWhoseMembersKeepFailingToListAsItIs", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--max-change-ratio=1")
+		ctx := context.Background()
+		_, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		_, err = syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+		estafetteAPI.recordedMutations()
+
+		directoryAPI.failMemberLists("ci-release@example.com", 2)
+
+		// act
+		run, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+		assert.Equal(t, 2, len(estafetteAPI.users[0].Groups))
+		if assert.Equal(t, 1, len(run.DeadLetters)) {
+			assert.Equal(t, "ci-release@example.com", run.DeadLetters[0].Group)
+		}
+	})
+
+	t.Run("SkipsAllChangesOnceShutdownWasRequested", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI)
+		shutdown := newShutdownSignal(time.Minute)
+		shutdown.request()
+		ctx := contextWithShutdownSignal(context.Background(), shutdown)
+
+		// act
+		run, err := syncOnce(ctx, &Config{})
+
+		assert.True(t, errors.Is(err, ErrShutdown))
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+		if assert.Equal(t, 1, len(run.Actions)) {
+			assert.True(t, errors.Is(run.Actions[0].Err, ErrShutdown))
+		}
+	})
+
+	t.Run("AbortsRunWithMoreDirectoryGroupsThanMaxGroups", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--max-groups=1")
+		ctx := context.Background()
+
+		// act
+		_, err := syncOnce(ctx, &Config{})
+
+		assert.True(t, errors.Is(err, ErrDirectoryLimitExceeded))
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("PostsIntegrationLogForEveryRun", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--api-integration-log")
+		ctx := context.Background()
+
+		// act
+		_, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(estafetteAPI.integrationLogs)) {
+			integrationLog := estafetteAPI.integrationLogs[0]
+			assert.Equal(t, integrationLogStatusSucceeded, integrationLog.Status)
+			assert.Equal(t, gsuiteProviderName, integrationLog.Provider)
+			assert.Equal(t, 1, integrationLog.DirectoryGroups)
+			if assert.Equal(t, 1, len(integrationLog.Changes)) {
+				assert.Equal(t, ActionCreateGroup, integrationLog.Changes[0].Type)
+				assert.Equal(t, "create group platform", integrationLog.Changes[0].Description)
+			}
+		}
+	})
+
+	t.Run("TriggersPipelineOnlyAfterRunsThatChangedAnything", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--trigger-pipeline=github.com/estafette/rbac-manifests")
+		ctx := context.Background()
+
+		// act
+		_, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(estafetteAPI.triggeredBuilds)) {
+			build := estafetteAPI.triggeredBuilds[0]
+			assert.Equal(t, "github.com/estafette/rbac-manifests", build.GetFullRepoPath())
+			assert.Equal(t, "main", build.RepoBranch)
+		}
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(estafetteAPI.triggeredBuilds))
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(estafetteAPI.triggeredBuilds))
+	})
+
+	t.Run("MigratesGroupLinkedByEmailToTheGroupID", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.setGroupID("ci-platform@example.com", "01x0gk37")
+		estafetteAPI.seedGroup("g1", "platform")
+		estafetteAPI.groups[0].Identities = []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI)
+		ctx := context.Background()
+
+		// act
+		_, err := syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []string{"PATCH /api/groups/g1", "PATCH /api/users/u1"}, estafetteAPI.recordedMutations())
+		assert.Equal(t, []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "01x0gk37", Name: "ci-platform"}, {Provider: "gsuite-version", ID: "01x0gk37", Name: "2"}}, estafetteAPI.groups[0].Identities)
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Empty(t, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("RecordsGroupSettingsAsIdentity", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroupSettings("ci-platform@example.com", true, "ANYONE_CAN_JOIN")
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--gsuite-sync-group-settings")
+		ctx := context.Background()
+		_, err := syncOnce(ctx, &Config{})
+		assert.Nil(t, err)
+
+		directoryAPI.seedGroupSettings("ci-platform@example.com", false, "INVITED_CAN_JOIN")
+		estafetteAPI.recordedMutations()
+
+		// act
+		_, err = syncOnce(ctx, &Config{})
+
+		assert.Nil(t, err)
+		assert.Contains(t, estafetteAPI.recordedMutations(), "PATCH /api/groups/g1")
+		if assert.Equal(t, 3, len(estafetteAPI.groups[0].Identities)) {
+			assert.Equal(t, "gsuite-settings", estafetteAPI.groups[0].Identities[1].Provider)
+			assert.Equal(t, "allowExternalMembers=false,whoCanJoin=INVITED_CAN_JOIN", estafetteAPI.groups[0].Identities[1].Name)
+		}
+	})
+
+	t.Run("StopsRunExceedingRunTimeout", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--run-timeout=1ns")
+		ctx := context.Background()
+
+		// act
+		run, err := syncOnce(ctx, &Config{})
+
+		assert.True(t, errors.Is(err, ErrRunTimeout))
+		assert.Equal(t, err, run.Err)
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+	})
+}
+
+func TestApplyPlanEndToEnd(t *testing.T) {
+	t.Run("AppliesPlanOnTopOfTheCurrentState", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--force")
+		ctx := context.Background()
+		apiClient := newApiClient(nil)
+		state, err := fetchState(ctx, apiClient)
+		assert.Nil(t, err)
+		actions, err := planState(ctx, &Config{}, state)
+		assert.Nil(t, err)
+
+		// act
+		err = applyPlan(ctx, &Config{}, apiClient, &PlanFile{RunID: newRunID(), Actions: actions})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"POST /api/groups"}, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("RefusesPlanOnceTheDirectoryViolatesAPolicyEnforcedByFailing", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+
+		parseSyncFlags(t, directoryAPI, estafetteAPI, "--force")
+		ctx := context.Background()
+		apiClient := newApiClient(nil)
+		state, err := fetchState(ctx, apiClient)
+		assert.Nil(t, err)
+		actions, err := planState(ctx, &Config{}, state)
+		assert.Nil(t, err)
+
+		directoryAPI.seedGroup("ci-contractors@example.com", "ci-contractors", "", &admin.Member{Id: "9999", Email: "jane@contractor.com"})
+		config := &Config{Policies: []*Policy{{Name: "company-only", AllowedDomains: []string{"example.com"}, Enforcement: policyEnforcementFail}}}
+
+		// act
+		err = applyPlan(ctx, config, apiClient, &PlanFile{RunID: newRunID(), Actions: actions})
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "Failed policy checks")
+		}
+		assert.Equal(t, []string{}, estafetteAPI.recordedMutations())
+	})
+}
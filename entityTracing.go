@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+)
+
+// entitySampling decides which groups and users get a span of their own; it's nil unless set up from the flags, in which case no entity is traced
+var entitySampling *entitySampler
+
+// entitySampler picks a share of the entities to trace, so large runs don't explode trace storage, and always traces the entities marked as traced, so a specific problem group can be traced fully
+type entitySampler struct {
+	rate   float64
+	mutex  sync.Mutex
+	random *rand.Rand
+	traced map[string]bool
+}
+
+// newEntitySampler returns an entitySampler tracing the share rate of the entities, and the entities with any of the traced emails, names or ids
+func newEntitySampler(rate float64, traced []string) *entitySampler {
+	s := &entitySampler{
+		rate:   rate,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		traced: map[string]bool{},
+	}
+	for _, t := range traced {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			s.traced[t] = true
+		}
+	}
+
+	return s
+}
+
+// sample returns whether to trace the entity with the keys, its emails, names and ids, and whether to trace it verbosely because any of them is marked as traced; the other keys of a traced entity are marked as traced too, so the estafette group of a directory group traced by email is traced as well once its id is known
+func (s *entitySampler) sample(keys ...string) (sampled, verbose bool) {
+	if s == nil {
+		return false, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, k := range keys {
+		if s.traced[strings.ToLower(k)] {
+			verbose = true
+			break
+		}
+	}
+	if verbose {
+		for _, k := range keys {
+			if k != "" {
+				s.traced[strings.ToLower(k)] = true
+			}
+		}
+		return true, true
+	}
+
+	return s.rate > 0 && s.random.Float64() < s.rate, false
+}
+
+// startEntitySpan starts a child span for the entity with the keys if it's sampled, tagged with the non-empty tags; for verbosely traced entities the trace is sampled regardless of the tracer's sampler. For entities that aren't sampled it returns a noop span and the context as it is
+func startEntitySpan(ctx context.Context, operationName string, tags map[string]string, keys ...string) (span opentracing.Span, spanCtx context.Context, verbose bool) {
+	sampled, verbose := entitySampling.sample(keys...)
+	if !sampled {
+		return opentracing.NoopTracer{}.StartSpan(operationName), ctx, false
+	}
+
+	span, spanCtx = opentracing.StartSpanFromContext(ctx, operationName)
+	for k, v := range tags {
+		if v != "" {
+			span.SetTag(k, v)
+		}
+	}
+	if verbose {
+		ext.SamplingPriority.Set(span, 1)
+		span.SetTag("verbose", true)
+	}
+
+	return span, spanCtx, verbose
+}
+
+// actionSpanKeys returns the keys the entity the action mutates is traced by: the name and identity ids of a group, or the email of a user and the names of the groups the user is added to or removed from
+func actionSpanKeys(a *Action) (keys []string) {
+	switch {
+	case a.Group != nil:
+		keys = append(keys, a.Group.ID, a.Group.Name)
+		for _, i := range a.Group.Identities {
+			keys = append(keys, i.ID)
+		}
+	case a.User != nil:
+		keys = append(keys, a.User.ID, a.User.GetEmail())
+		var before []*contracts.Group
+		if a.UserBefore != nil {
+			before = a.UserBefore.Groups
+		}
+		added, removed := diffGroupNames(before, a.User.Groups)
+		keys = append(keys, added...)
+		keys = append(keys, removed...)
+	case a.Organization != nil:
+		keys = append(keys, a.Organization.ID, a.Organization.Name)
+	}
+
+	return
+}
+
+// actionSpanTags returns the identifiers of the entity the action mutates to tag its span with
+func actionSpanTags(a *Action) map[string]string {
+	tags := map[string]string{"action": string(a.Type)}
+	switch {
+	case a.Group != nil:
+		tags["group.id"] = a.Group.ID
+		tags["group.name"] = a.Group.Name
+	case a.User != nil:
+		tags["user.id"] = a.User.ID
+		tags["user.email"] = a.User.GetEmail()
+	case a.Organization != nil:
+		tags["organization.id"] = a.Organization.ID
+		tags["organization.name"] = a.Organization.Name
+	}
+
+	return tags
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntitySampler(t *testing.T) {
+	t.Run("NeverSamplesWithoutSampler", func(t *testing.T) {
+
+		var sampler *entitySampler
+
+		// act
+		sampled, verbose := sampler.sample("ci-group@example.com")
+
+		assert.False(t, sampled)
+		assert.False(t, verbose)
+	})
+
+	t.Run("SamplesByRate", func(t *testing.T) {
+
+		never := newEntitySampler(0, nil)
+		always := newEntitySampler(1, nil)
+
+		// act
+		neverSampled, _ := never.sample("ci-group@example.com")
+		alwaysSampled, verbose := always.sample("ci-group@example.com")
+
+		assert.False(t, neverSampled)
+		assert.True(t, alwaysSampled)
+		assert.False(t, verbose)
+	})
+
+	t.Run("TracesOtherKeysOfTracedEntityVerbosely", func(t *testing.T) {
+
+		sampler := newEntitySampler(0, []string{"CI-Group@example.com"})
+		sampled, verbose := sampler.sample("ci-group@example.com", "0abc123")
+		assert.True(t, sampled)
+		assert.True(t, verbose)
+
+		// act
+		sampled, verbose = sampler.sample("group", "0abc123")
+
+		assert.True(t, sampled)
+		assert.True(t, verbose)
+	})
+}
+
+func TestStartEntitySpan(t *testing.T) {
+	t.Run("ReturnsContextAsIsForEntityThatIsNotSampled", func(t *testing.T) {
+
+		defer func(sampler *entitySampler) { entitySampling = sampler }(entitySampling)
+		entitySampling = newEntitySampler(0, nil)
+		ctx := context.Background()
+
+		// act
+		span, spanCtx, verbose := startEntitySpan(ctx, "ApiClient::ApplyAction", nil, "group")
+		span.Finish()
+
+		assert.Equal(t, ctx, spanCtx)
+		assert.False(t, verbose)
+	})
+
+	t.Run("TagsSpanOfTracedEntity", func(t *testing.T) {
+
+		defer func(tracer opentracing.Tracer) { opentracing.SetGlobalTracer(tracer) }(opentracing.GlobalTracer())
+		tracer := mocktracer.New()
+		opentracing.SetGlobalTracer(tracer)
+		defer func(sampler *entitySampler) { entitySampling = sampler }(entitySampling)
+		entitySampling = newEntitySampler(0, []string{"ci-group@example.com"})
+		action := &Action{Type: ActionUpdateGroup, Group: &contracts.Group{ID: "g1", Name: "group", Identities: []*contracts.GroupIdentity{{Provider: "gsuite", ID: "ci-group@example.com"}}}}
+
+		// act
+		span, _, verbose := startEntitySpan(context.Background(), "ApiClient::ApplyAction", actionSpanTags(action), actionSpanKeys(action)...)
+		span.Finish()
+
+		assert.True(t, verbose)
+		if assert.Equal(t, 1, len(tracer.FinishedSpans())) {
+			finished := tracer.FinishedSpans()[0]
+			assert.Equal(t, "ApiClient::ApplyAction", finished.OperationName)
+			assert.Equal(t, "g1", finished.Tag("group.id"))
+			assert.Equal(t, "group", finished.Tag("group.name"))
+			assert.Equal(t, true, finished.Tag("verbose"))
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// estafetteEndpoints builds the urls of the estafette-ci-api endpoints the syncer uses, so every path is defined once and ids are escaped; it's hand-written rather than a typed client generated from an openapi spec or shared through estafette-ci-contracts, since the api publishes no spec and contracts has no client, so new endpoints and their response types are added here by hand until one of them does
+type estafetteEndpoints struct {
+	baseURL string
+}
+
+// newEstafetteEndpoints returns the endpoints of the estafette-ci-api at the base url
+func newEstafetteEndpoints(baseURL string) estafetteEndpoints {
+	return estafetteEndpoints{baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// clientLogin returns the url to exchange client credentials for a jwt, responding with an estafetteTokenResponse
+func (e estafetteEndpoints) clientLogin() string {
+	return e.baseURL + "/api/auth/client/login"
+}
+
+// organizations returns the url to create an organization
+func (e estafetteEndpoints) organizations() string {
+	return e.baseURL + "/api/organizations"
+}
+
+// organizationsPage returns the url of a page of organizations, responding with an estafetteOrganizationsPage
+func (e estafetteEndpoints) organizationsPage(pageNumber, pageSize int) string {
+	return e.organizations() + pageQuery(pageNumber, pageSize)
+}
+
+// organization returns the url of a single organization
+func (e estafetteEndpoints) organization(id string) string {
+	return e.organizations() + "/" + url.PathEscape(id)
+}
+
+// groups returns the url to create a group
+func (e estafetteEndpoints) groups() string {
+	return e.baseURL + "/api/groups"
+}
+
+// groupsPage returns the url of a page of groups, responding with an estafetteGroupsPage
+func (e estafetteEndpoints) groupsPage(pageNumber, pageSize int) string {
+	return e.groups() + pageQuery(pageNumber, pageSize)
+}
+
+// group returns the url of a single group
+func (e estafetteEndpoints) group(id string) string {
+	return e.groups() + "/" + url.PathEscape(id)
+}
+
+// groupMember returns the url to add the user to the group with a put request, or to remove it with a delete request
+func (e estafetteEndpoints) groupMember(groupID, userID string) string {
+	return e.group(groupID) + "/members/" + url.PathEscape(userID)
+}
+
+// usersPage returns the url of a page of users, responding with an estafetteUsersPage
+func (e estafetteEndpoints) usersPage(pageNumber, pageSize int) string {
+	return e.baseURL + "/api/users" + pageQuery(pageNumber, pageSize)
+}
+
+// user returns the url of a single user
+func (e estafetteEndpoints) user(id string) string {
+	return e.baseURL + "/api/users/" + url.PathEscape(id)
+}
+
+// integrationLogs returns the url to post an IntegrationLog of a sync run to
+func (e estafetteEndpoints) integrationLogs() string {
+	return e.baseURL + "/api/integrations/gsuite/syncs"
+}
+
+// pipelineBuilds returns the url to start a build of the pipeline
+func (e estafetteEndpoints) pipelineBuilds(source, owner, name string) string {
+	return fmt.Sprintf("%v/api/pipelines/%v/%v/%v/builds", e.baseURL, url.PathEscape(source), url.PathEscape(owner), url.PathEscape(name))
+}
+
+// pageQuery returns the query string selecting a page of a list endpoint; the brackets are left unescaped, as the api expects them
+func pageQuery(pageNumber, pageSize int) string {
+	return fmt.Sprintf("?page[number]=%v&page[size]=%v", pageNumber, pageSize)
+}
+
+// estafetteTokenResponse is the response of the client login endpoint
+type estafetteTokenResponse struct {
+	Token string `json:"token"`
+}
+
+// estafetteOrganizationsPage is a page of the organizations list endpoint
+type estafetteOrganizationsPage struct {
+	Items      []*contracts.Organization `json:"items"`
+	Pagination contracts.Pagination      `json:"pagination"`
+}
+
+// estafetteGroupsPage is a page of the groups list endpoint
+type estafetteGroupsPage struct {
+	Items      []*contracts.Group   `json:"items"`
+	Pagination contracts.Pagination `json:"pagination"`
+}
+
+// estafetteUsersPage is a page of the users list endpoint
+type estafetteUsersPage struct {
+	Items      []*contracts.User    `json:"items"`
+	Pagination contracts.Pagination `json:"pagination"`
+}
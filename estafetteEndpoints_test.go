@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstafetteEndpoints(t *testing.T) {
+	t.Run("BuildsPageUrlsWithUnescapedBrackets", func(t *testing.T) {
+
+		endpoints := newEstafetteEndpoints("https://ci.example.com/")
+
+		// act
+		groupsPage := endpoints.groupsPage(2, 50)
+
+		assert.Equal(t, "https://ci.example.com/api/groups?page[number]=2&page[size]=50", groupsPage)
+	})
+
+	t.Run("EscapesIdsInEntityUrls", func(t *testing.T) {
+
+		endpoints := newEstafetteEndpoints("https://ci.example.com")
+
+		// act
+		user := endpoints.user("a/b c")
+
+		assert.Equal(t, "https://ci.example.com/api/users/a%2Fb%20c", user)
+		assert.Equal(t, "https://ci.example.com/api/pipelines/github.com/estafette/estafette-ci-api/builds", endpoints.pipelineBuilds("github.com", "estafette", "estafette-ci-api"))
+	})
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/opentracing/opentracing-go"
+	storage "google.golang.org/api/storage/v1"
+)
+
+type exportedDirectoryGroup struct {
+	ID      string             `json:"id"`
+	Name    string             `json:"name"`
+	Email   string             `json:"email,omitempty"`
+	Members []*DirectoryMember `json:"members"`
+}
+
+// exportedLink explains why an estafette user is or should be a member of an estafette group
+type exportedLink struct {
+	UserID             string `json:"userID"`
+	UserEmail          string `json:"userEmail"`
+	GroupID            string `json:"groupID"`
+	GroupName          string `json:"groupName"`
+	DirectoryGroupID   string `json:"directoryGroupID,omitempty"`
+	DirectoryGroupName string `json:"directoryGroupName,omitempty"`
+	MemberID           string `json:"memberID,omitempty"`
+	// InEstafette is true if the user is a member of the group in estafette; if false the next sync adds it
+	InEstafette bool `json:"inEstafette"`
+	// InDirectory is true if the group membership is granted by the directory; if false the next sync removes it
+	InDirectory bool `json:"inDirectory"`
+}
+
+type exportFileWriter func(ctx context.Context, name string, data []byte) error
+
+// exportState writes the directory groups and members, the estafette groups and users and their linkage as json or csv files to a local directory or a gs://bucket/path location
+func exportState(ctx context.Context, s state, format, output string) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Export::State")
+	defer span.Finish()
+
+	writeFile, err := newExportFileWriter(ctx, output)
+	if err != nil {
+		return
+	}
+
+	directoryGroups := make([]*exportedDirectoryGroup, 0, len(s.groupMembers))
+	for _, g := range sortedDirectoryGroups(s.groupMembers) {
+		directoryGroups = append(directoryGroups, &exportedDirectoryGroup{
+			ID:      g.ID,
+			Name:    g.Name,
+			Email:   g.Email,
+			Members: s.groupMembers[g],
+		})
+	}
+
+	links := getLinks(s)
+
+	span.LogKV("format", format, "links", len(links))
+
+	if format == "csv" {
+		return exportStateAsCSV(ctx, writeFile, s, directoryGroups, links)
+	}
+
+	return exportStateAsJSON(ctx, writeFile, s, directoryGroups, links)
+}
+
+// newExportFileWriter returns a function writing files to a local directory or to a gcs bucket if the output starts with gs://
+func newExportFileWriter(ctx context.Context, output string) (exportFileWriter, error) {
+	if strings.HasPrefix(output, "gs://") {
+		bucketAndPrefix := strings.SplitN(strings.TrimPrefix(output, "gs://"), "/", 2)
+		bucket := bucketAndPrefix[0]
+		prefix := ""
+		if len(bucketAndPrefix) > 1 {
+			prefix = bucketAndPrefix[1]
+		}
+
+		storageService, err := storage.NewService(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return func(ctx context.Context, name string, data []byte) error {
+			_, err := storageService.Objects.Insert(bucket, &storage.Object{Name: path.Join(prefix, name)}).Media(bytes.NewReader(data)).Context(ctx).Do()
+			return err
+		}, nil
+	}
+
+	err := os.MkdirAll(output, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, name string, data []byte) error {
+		return ioutil.WriteFile(filepath.Join(output, name), data, 0644)
+	}, nil
+}
+
+// getLinks returns for every user which estafette groups the directory grants and which ones the user currently has, in the order of the users, estafette groups and directory groups
+func getLinks(s state) (links []*exportedLink) {
+
+	links = make([]*exportedLink, 0)
+	directoryGroups := sortedDirectoryGroups(s.groupMembers)
+
+	for _, u := range s.users {
+		grantedGroupIDs := map[string]bool{}
+
+		for _, g := range s.groups {
+			for _, gg := range directoryGroups {
+				members := s.groupMembers[gg]
+				for _, i := range g.Identities {
+					if i.Provider != s.provider.Name() || i.ID != gg.ID {
+						continue
+					}
+					for _, m := range members {
+						if userMatchesMember(u, s.provider, m) {
+							grantedGroupIDs[g.ID] = true
+							links = append(links, &exportedLink{
+								UserID:             u.ID,
+								UserEmail:          u.GetEmail(),
+								GroupID:            g.ID,
+								GroupName:          g.Name,
+								DirectoryGroupID:   gg.ID,
+								DirectoryGroupName: gg.Name,
+								MemberID:           m.ID,
+								InEstafette:        userHasGroup(u, g.ID),
+								InDirectory:        true,
+							})
+						}
+					}
+				}
+			}
+		}
+
+		// memberships that aren't backed by the directory
+		for _, g := range u.Groups {
+			if !grantedGroupIDs[g.ID] {
+				links = append(links, &exportedLink{
+					UserID:      u.ID,
+					UserEmail:   u.GetEmail(),
+					GroupID:     g.ID,
+					GroupName:   g.Name,
+					InEstafette: true,
+				})
+			}
+		}
+	}
+
+	return
+}
+
+func userHasGroup(user *contracts.User, groupID string) bool {
+	for _, g := range user.Groups {
+		if g.ID == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+func exportStateAsJSON(ctx context.Context, writeFile exportFileWriter, s state, directoryGroups []*exportedDirectoryGroup, links []*exportedLink) (err error) {
+
+	bytes, err := json.MarshalIndent(struct {
+		ExportedAt      time.Time                 `json:"exportedAt"`
+		Provider        string                    `json:"provider"`
+		DirectoryGroups []*exportedDirectoryGroup `json:"directoryGroups"`
+		Groups          []*contracts.Group        `json:"groups"`
+		Users           []*contracts.User         `json:"users"`
+		Links           []*exportedLink           `json:"links"`
+	}{time.Now().UTC(), s.provider.Name(), directoryGroups, s.groups, s.users, links}, "", "  ")
+	if err != nil {
+		return
+	}
+
+	return writeFile(ctx, "state.json", bytes)
+}
+
+func exportStateAsCSV(ctx context.Context, writeFile exportFileWriter, s state, directoryGroups []*exportedDirectoryGroup, links []*exportedLink) (err error) {
+
+	directoryRecords := [][]string{{"provider", "group_id", "group_name", "member_id", "member_email"}}
+	for _, g := range directoryGroups {
+		for _, m := range g.Members {
+			directoryRecords = append(directoryRecords, []string{s.provider.Name(), g.ID, g.Name, m.ID, m.Email})
+		}
+	}
+	err = writeCSVFile(ctx, writeFile, "directory_group_members.csv", directoryRecords)
+	if err != nil {
+		return
+	}
+
+	groupRecords := [][]string{{"group_id", "group_name", "identity_provider", "identity_id"}}
+	for _, g := range s.groups {
+		for _, i := range g.Identities {
+			groupRecords = append(groupRecords, []string{g.ID, g.Name, i.Provider, i.ID})
+		}
+		if len(g.Identities) == 0 {
+			groupRecords = append(groupRecords, []string{g.ID, g.Name, "", ""})
+		}
+	}
+	err = writeCSVFile(ctx, writeFile, "groups.csv", groupRecords)
+	if err != nil {
+		return
+	}
+
+	userRecords := [][]string{{"user_id", "user_name", "user_email", "active", "group_ids", "group_names"}}
+	for _, u := range s.users {
+		groupIDs := make([]string, 0, len(u.Groups))
+		groupNames := make([]string, 0, len(u.Groups))
+		for _, g := range u.Groups {
+			groupIDs = append(groupIDs, g.ID)
+			groupNames = append(groupNames, g.Name)
+		}
+		userRecords = append(userRecords, []string{u.ID, u.GetName(), u.GetEmail(), strconv.FormatBool(u.Active), strings.Join(groupIDs, ";"), strings.Join(groupNames, ";")})
+	}
+	err = writeCSVFile(ctx, writeFile, "users.csv", userRecords)
+	if err != nil {
+		return
+	}
+
+	linkRecords := [][]string{{"user_id", "user_email", "group_id", "group_name", "directory_group_id", "directory_group_name", "member_id", "in_estafette", "in_directory"}}
+	for _, l := range links {
+		linkRecords = append(linkRecords, []string{l.UserID, l.UserEmail, l.GroupID, l.GroupName, l.DirectoryGroupID, l.DirectoryGroupName, l.MemberID, strconv.FormatBool(l.InEstafette), strconv.FormatBool(l.InDirectory)})
+	}
+
+	return writeCSVFile(ctx, writeFile, "links.csv", linkRecords)
+}
+
+func writeCSVFile(ctx context.Context, writeFile exportFileWriter, name string, records [][]string) (err error) {
+	var buffer bytes.Buffer
+
+	writer := csv.NewWriter(&buffer)
+	err = writer.WriteAll(records)
+	if err != nil {
+		return
+	}
+
+	return writeFile(ctx, name, buffer.Bytes())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetLinks(t *testing.T) {
+	t.Run("ReturnsLinksForGrantedAndUngrantedMemberships", func(t *testing.T) {
+
+		s := state{
+			provider: &gsuiteClient{},
+			groups: []*contracts.Group{
+				{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com"}}},
+				{ID: "g2", Name: "manual"},
+			},
+			users: []*contracts.User{
+				{
+					ID:         "u1",
+					Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}},
+					Groups:     []*contracts.Group{{ID: "g2", Name: "manual"}},
+				},
+			},
+			groupMembers: map[*DirectoryGroup][]*DirectoryMember{
+				{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234", Email: "john@example.com"}},
+			},
+		}
+
+		// act
+		links := getLinks(s)
+
+		if assert.Equal(t, 2, len(links)) {
+			assert.Equal(t, "g1", links[0].GroupID)
+			assert.Equal(t, "ci-platform@example.com", links[0].DirectoryGroupID)
+			assert.True(t, links[0].InDirectory)
+			assert.False(t, links[0].InEstafette)
+
+			assert.Equal(t, "g2", links[1].GroupID)
+			assert.False(t, links[1].InDirectory)
+			assert.True(t, links[1].InEstafette)
+		}
+	})
+
+	t.Run("ReturnsLinksInOrderOfDirectoryGroups", func(t *testing.T) {
+
+		s := state{
+			provider: &gsuiteClient{},
+			groups: []*contracts.Group{
+				{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{
+					{Provider: gsuiteProviderName, ID: "ci-platform@example.com"},
+					{Provider: gsuiteProviderName, ID: "ci-infra@example.com"},
+					{Provider: gsuiteProviderName, ID: "ci-admins@example.com"},
+				}},
+			},
+			users: []*contracts.User{
+				{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}}},
+			},
+			groupMembers: map[*DirectoryGroup][]*DirectoryMember{
+				{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234", Email: "john@example.com"}},
+				{ID: "ci-infra@example.com", Name: "ci-infra"}:       {{ID: "1234", Email: "john@example.com"}},
+				{ID: "ci-admins@example.com", Name: "ci-admins"}:     {{ID: "1234", Email: "john@example.com"}},
+			},
+		}
+
+		for i := 0; i < 10; i++ {
+			// act
+			links := getLinks(s)
+
+			if assert.Equal(t, 3, len(links)) {
+				assert.Equal(t, "ci-admins@example.com", links[0].DirectoryGroupID)
+				assert.Equal(t, "ci-infra@example.com", links[1].DirectoryGroupID)
+				assert.Equal(t, "ci-platform@example.com", links[2].DirectoryGroupID)
+			}
+		}
+	})
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	admin "google.golang.org/api/admin/directory/v1"
+	groupssettings "google.golang.org/api/groupssettings/v1"
+)
+
+// fakeDirectoryAPI serves the parts of the gsuite directory and resource manager apis the gsuite client uses, from seeded fixtures
+type fakeDirectoryAPI struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	groups  []*admin.Group
+	members map[string][]*admin.Member
+	users   []*admin.User
+	// settings are returned by the groups settings api, groups without seeded settings get the gsuite defaults
+	settings map[string]*groupssettings.Groups
+	// memberLists counts the requests listing group members
+	memberLists int
+	// notModified counts the list requests answered with 304 because their If-None-Match etag was current
+	notModified int
+	// memberListFailures is the number of times listing the members of a group still fails, by group email
+	memberListFailures map[string]int
+	// expirations are returned by the cloud identity api, by group and member email
+	expirations map[string]map[string]time.Time
+	// dynamicQueries are the membership queries of dynamic groups returned by the cloud identity api, by group email
+	dynamicQueries map[string]string
+	// groupCustomers are the customers of groups listed by customer, by group email
+	groupCustomers map[string]string
+	// roles and roleAssignments are the admin roles and the users they're assigned to
+	roles           []*admin.Role
+	roleAssignments []*admin.RoleAssignment
+}
+
+func newFakeDirectoryAPI() *fakeDirectoryAPI {
+	api := &fakeDirectoryAPI{
+		members:            map[string][]*admin.Member{},
+		settings:           map[string]*groupssettings.Groups{},
+		expirations:        map[string]map[string]time.Time{},
+		dynamicQueries:     map[string]string{},
+		memberListFailures: map[string]int{},
+		groupCustomers:     map[string]string{},
+	}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.handle))
+
+	return api
+}
+
+// seedGroup adds a group with members identified by their google user id and email address
+func (api *fakeDirectoryAPI) seedGroup(email, name, description string, members ...*admin.Member) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.groups = append(api.groups, &admin.Group{Id: email, Email: email, Name: name, Description: description, Etag: `"` + email + `"`, DirectMembersCount: int64(len(members))})
+	api.members[email] = members
+}
+
+// setGroupID sets the immutable id of the group, which seedGroup sets to its email address
+func (api *fakeDirectoryAPI) setGroupID(email, id string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	for _, g := range api.groups {
+		if g.Email == email {
+			g.Id = id
+		}
+	}
+}
+
+// setGroupCustomer makes the group belong to the customer, so it's only listed for that customer when listing groups by customer
+func (api *fakeDirectoryAPI) setGroupCustomer(email, customerID string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.groupCustomers[email] = customerID
+}
+
+// seedUser adds a user of the domain, as listed by the users api
+func (api *fakeDirectoryAPI) seedUser(id, email string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.users = append(api.users, &admin.User{Id: id, PrimaryEmail: email})
+}
+
+// seedAdminRole assigns the admin role to the user, adding the role if it isn't seeded yet
+func (api *fakeDirectoryAPI) seedAdminRole(userID string, roleID int64, roleName string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	seeded := false
+	for _, r := range api.roles {
+		seeded = seeded || r.RoleId == roleID
+	}
+	if !seeded {
+		api.roles = append(api.roles, &admin.Role{RoleId: roleID, RoleName: roleName})
+	}
+	api.roleAssignments = append(api.roleAssignments, &admin.RoleAssignment{AssignedTo: userID, RoleId: roleID})
+}
+
+// seedGroupSettings sets the access settings of the group
+func (api *fakeDirectoryAPI) seedGroupSettings(email string, allowExternalMembers bool, whoCanJoin string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.settings[email] = &groupssettings.Groups{Email: email, AllowExternalMembers: fmt.Sprint(allowExternalMembers), WhoCanJoin: whoCanJoin}
+}
+
+// seedMembershipExpiry makes the membership of the member in the group time-bound, like a grant with an expiration in the cloud identity console
+func (api *fakeDirectoryAPI) seedMembershipExpiry(groupEmail, memberEmail string, expireTime time.Time) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.expirations[groupEmail] == nil {
+		api.expirations[groupEmail] = map[string]time.Time{}
+	}
+	api.expirations[groupEmail][memberEmail] = expireTime
+}
+
+// seedDynamicGroup makes the group a dynamic group with the membership query
+func (api *fakeDirectoryAPI) seedDynamicGroup(email, query string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.dynamicQueries[email] = query
+}
+
+// failMemberLists makes the next times requests listing the members of the group fail, like for a group deleted mid-run
+func (api *fakeDirectoryAPI) failMemberLists(groupEmail string, times int) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.memberListFailures[groupEmail] = times
+}
+
+// removeMember removes the member from the group, like an admin would in the gsuite console
+func (api *fakeDirectoryAPI) removeMember(groupEmail, memberEmail string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	members := make([]*admin.Member, 0)
+	for _, m := range api.members[groupEmail] {
+		if m.Email != memberEmail {
+			members = append(members, m)
+		}
+	}
+	api.members[groupEmail] = members
+	for _, g := range api.groups {
+		if g.Email == groupEmail {
+			g.DirectMembersCount = int64(len(members))
+		}
+	}
+}
+
+func (api *fakeDirectoryAPI) handle(w http.ResponseWriter, r *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/admin/directory/v1/")
+	switch {
+	case path == "groups":
+		groups := api.groups
+		if customerID := r.URL.Query().Get("customer"); customerID != "" {
+			groups = make([]*admin.Group, 0)
+			for _, g := range api.groups {
+				if api.groupCustomers[g.Email] == customerID {
+					groups = append(groups, g)
+				}
+			}
+		}
+		etag := listEtag(groups)
+		if api.writeNotModified(w, r, etag) {
+			return
+		}
+		writeJSON(w, http.StatusOK, &admin.Groups{Groups: groups, Etag: etag})
+	case strings.HasPrefix(path, "groups/") && strings.HasSuffix(path, "/members"):
+		groupKey, _ := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(path, "groups/"), "/members"))
+		api.memberLists++
+		if api.memberListFailures[groupKey] > 0 {
+			api.memberListFailures[groupKey]--
+			http.Error(w, "group not found", http.StatusNotFound)
+			return
+		}
+		etag := listEtag(api.members[groupKey])
+		if api.writeNotModified(w, r, etag) {
+			return
+		}
+		writeJSON(w, http.StatusOK, &admin.Members{Members: api.members[groupKey], Etag: etag})
+	case strings.HasPrefix(path, "groups/"):
+		groupKey, _ := url.PathUnescape(strings.TrimPrefix(path, "groups/"))
+		for _, g := range api.groups {
+			if g.Id == groupKey || g.Email == groupKey {
+				writeJSON(w, http.StatusOK, g)
+				return
+			}
+		}
+		http.Error(w, "group not found", http.StatusNotFound)
+	case path == "users":
+		writeJSON(w, http.StatusOK, &admin.Users{Users: api.users})
+	case strings.HasPrefix(path, "users/"):
+		userKey, _ := url.PathUnescape(strings.TrimPrefix(path, "users/"))
+		for _, u := range api.users {
+			if u.Id == userKey || u.PrimaryEmail == userKey {
+				writeJSON(w, http.StatusOK, u)
+				return
+			}
+		}
+		http.Error(w, "user not found", http.StatusNotFound)
+	case strings.HasPrefix(path, "customer/") && strings.HasSuffix(path, "/roles"):
+		writeJSON(w, http.StatusOK, &admin.Roles{Items: api.roles})
+	case strings.HasPrefix(path, "customer/") && strings.HasSuffix(path, "/roleassignments"):
+		writeJSON(w, http.StatusOK, &admin.RoleAssignments{Items: api.roleAssignments})
+	case strings.HasPrefix(r.URL.Path, "/groups/v1/groups/"):
+		groupKey, _ := url.PathUnescape(strings.TrimPrefix(r.URL.Path, "/groups/v1/groups/"))
+		settings, ok := api.settings[groupKey]
+		if !ok {
+			settings = &groupssettings.Groups{Email: groupKey, AllowExternalMembers: "false", WhoCanJoin: "CAN_REQUEST_TO_JOIN"}
+		}
+		writeJSON(w, http.StatusOK, settings)
+	case r.URL.Path == "/cloudidentity/v1/groups:lookup":
+		writeJSON(w, http.StatusOK, map[string]string{"name": "groups/" + r.URL.Query().Get("groupKey.id")})
+	case strings.HasPrefix(r.URL.Path, "/cloudidentity/v1/groups/") && strings.HasSuffix(r.URL.Path, "/memberships"):
+		groupKey := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/cloudidentity/v1/groups/"), "/memberships")
+		memberships := make([]interface{}, 0)
+		for _, m := range api.members[groupKey] {
+			role := map[string]interface{}{"name": "MEMBER"}
+			if expireTime, ok := api.expirations[groupKey][m.Email]; ok {
+				role["expiryDetail"] = map[string]interface{}{"expireTime": expireTime}
+			}
+			memberships = append(memberships, map[string]interface{}{"preferredMemberKey": map[string]string{"id": m.Email}, "roles": []interface{}{role}})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"memberships": memberships})
+	case strings.HasPrefix(r.URL.Path, "/cloudidentity/v1/groups/"):
+		groupKey := strings.TrimPrefix(r.URL.Path, "/cloudidentity/v1/groups/")
+		group := map[string]interface{}{"name": "groups/" + groupKey}
+		if query, ok := api.dynamicQueries[groupKey]; ok {
+			group["dynamicGroupMetadata"] = map[string]interface{}{
+				"queries": []interface{}{map[string]string{"resourceType": "USER", "query": query}},
+				"status":  map[string]string{"status": "UP_TO_DATE"},
+			}
+		}
+		writeJSON(w, http.StatusOK, group)
+	case r.URL.Path == "/v1/organizations:search":
+		fmt.Fprint(w, `{"organizations":[]}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeEstafetteAPI serves the parts of the estafette api the syncer uses from seeded fixtures, and records every mutation
+type fakeEstafetteAPI struct {
+	*httptest.Server
+
+	mutex         sync.Mutex
+	organizations []*contracts.Organization
+	groups        []*contracts.Group
+	users         []*contracts.User
+	mutations     []string
+	// integrationLogs holds the posted integration logs, which aren't recorded as mutations
+	integrationLogs []*IntegrationLog
+	// triggeredBuilds holds the builds started with TriggerPipeline, which aren't recorded as mutations either
+	triggeredBuilds []*contracts.Build
+}
+
+func newFakeEstafetteAPI() *fakeEstafetteAPI {
+	api := &fakeEstafetteAPI{
+		organizations: []*contracts.Organization{},
+		groups:        []*contracts.Group{},
+		users:         []*contracts.User{},
+		mutations:     []string{},
+	}
+	api.Server = httptest.NewServer(http.HandlerFunc(api.handle))
+
+	return api
+}
+
+// seedUser adds a user that logged in with google, identified by the google user id and email address
+func (api *fakeEstafetteAPI) seedUser(id, googleID, email string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.users = append(api.users, &contracts.User{
+		ID:         id,
+		Active:     true,
+		Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: googleID, Email: email}},
+	})
+}
+
+// seedGroup adds a group without identities, like one created by hand in estafette
+func (api *fakeEstafetteAPI) seedGroup(id, name string) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.groups = append(api.groups, &contracts.Group{ID: id, Name: name})
+}
+
+// recordedMutations returns the mutations since the last call, as method and path
+func (api *fakeEstafetteAPI) recordedMutations() []string {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	mutations := api.mutations
+	api.mutations = []string{}
+
+	return mutations
+}
+
+func (api *fakeEstafetteAPI) handle(w http.ResponseWriter, r *http.Request) {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if r.URL.Path == "/api/auth/client/login" {
+		fmt.Fprint(w, `{"token":"fake-token"}`)
+		return
+	}
+
+	if r.URL.Path == "/api/integrations/gsuite/syncs" && r.Method == "POST" {
+		var integrationLog IntegrationLog
+		if readJSON(w, r, &integrationLog) {
+			api.integrationLogs = append(api.integrationLogs, &integrationLog)
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/pipelines/") && strings.HasSuffix(r.URL.Path, "/builds") && r.Method == "POST" {
+		var build contracts.Build
+		if readJSON(w, r, &build) {
+			api.triggeredBuilds = append(api.triggeredBuilds, &build)
+			writeJSON(w, http.StatusCreated, &build)
+		}
+		return
+	}
+
+	if r.Method != "GET" {
+		api.mutations = append(api.mutations, fmt.Sprintf("%v %v", r.Method, r.URL.Path))
+	}
+
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/")
+
+	switch {
+	case len(segments) == 1 && r.Method == "GET":
+		switch segments[0] {
+		case "organizations":
+			writeList(w, api.organizations, len(api.organizations))
+		case "groups":
+			writeList(w, api.groups, len(api.groups))
+		case "users":
+			writeList(w, api.users, len(api.users))
+		default:
+			http.NotFound(w, r)
+		}
+
+	case len(segments) == 1 && segments[0] == "groups" && r.Method == "POST":
+		var group contracts.Group
+		if !readJSON(w, r, &group) {
+			return
+		}
+		for _, g := range api.groups {
+			if g.Name == group.Name {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "group already exists"})
+				return
+			}
+		}
+		group.ID = fmt.Sprintf("g%v", len(api.groups)+1)
+		api.groups = append(api.groups, &group)
+		writeJSON(w, http.StatusCreated, &group)
+
+	case len(segments) == 2 && segments[0] == "groups" && r.Method == "DELETE" && api.findGroup(segments[1]) != nil:
+		for i, g := range api.groups {
+			if g.ID == segments[1] {
+				api.groups = append(api.groups[:i], api.groups[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case len(segments) == 2 && segments[0] == "groups" && api.findGroup(segments[1]) != nil:
+		api.handleEntity(w, r, api.findGroup(segments[1]))
+
+	case len(segments) == 2 && segments[0] == "users" && api.findUser(segments[1]) != nil:
+		api.handleEntity(w, r, api.findUser(segments[1]))
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleEntity gets, replaces or patches a single group or user in place
+func (api *fakeEstafetteAPI) handleEntity(w http.ResponseWriter, r *http.Request, entity interface{}) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, entity)
+
+	case "PUT":
+		var replacement map[string]interface{}
+		if !readJSON(w, r, &replacement) {
+			return
+		}
+		replaceEntity(entity, replacement)
+		writeJSON(w, http.StatusOK, entity)
+
+	case "PATCH":
+		var patch map[string]interface{}
+		if !readJSON(w, r, &patch) {
+			return
+		}
+		current, _ := toJSONMap(entity)
+		replaceEntity(entity, applyMergePatch(current, patch))
+		writeJSON(w, http.StatusOK, entity)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// replaceEntity overwrites the group or user with the json fields, clearing the fields that are left out
+func replaceEntity(entity interface{}, fields map[string]interface{}) {
+	switch e := entity.(type) {
+	case *contracts.Group:
+		*e = contracts.Group{}
+	case *contracts.User:
+		*e = contracts.User{}
+	}
+
+	data, _ := json.Marshal(fields)
+	_ = json.Unmarshal(data, entity)
+}
+
+func (api *fakeEstafetteAPI) findGroup(id string) *contracts.Group {
+	for _, g := range api.groups {
+		if g.ID == id {
+			return g
+		}
+	}
+	return nil
+}
+
+func (api *fakeEstafetteAPI) findUser(id string) *contracts.User {
+	for _, u := range api.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// writeList writes all items as a single page, like the estafette api does for small collections
+func writeList(w http.ResponseWriter, items interface{}, count int) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":      items,
+		"pagination": contracts.Pagination{Page: 1, Size: 100, TotalPages: 1, TotalItems: count},
+	})
+}
+
+// writeNotModified responds with 304 if the request's If-None-Match matches the etag of the list
+func (api *fakeDirectoryAPI) writeNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if r.Header.Get("If-None-Match") != etag {
+		return false
+	}
+
+	api.notModified++
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// listEtag returns an etag changing with the items of a list
+func listEtag(items interface{}) string {
+	data, _ := json.Marshal(items)
+	return fmt.Sprintf(`"%x"`, sha1.Sum(data))
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	data, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(data, value)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
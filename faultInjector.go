@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// faultInjector randomly fails http requests with rate limiting, server errors and timeouts, so operators can check that retries, backoff and partial failure handling recover from them
+type faultInjector struct {
+	rate float64
+
+	// random isn't safe for concurrent use, so it's guarded by mutex
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// injectedFaults are the injected status codes, with 0 for a timeout
+var injectedFaults = []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable, 0}
+
+// newFaultInjector returns a faultInjector failing the given share of requests, or nil if rate is zero so no requests are affected
+func newFaultInjector(rate float64, seed int64) *faultInjector {
+	if rate <= 0 {
+		return nil
+	}
+
+	log.Warn().Msgf("Fault injection is enabled, failing %v%% of requests to the apis", rate*100)
+
+	return &faultInjector{
+		rate:   rate,
+		random: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// wrap returns a transport injecting faults before requests reach the next transport; it returns next as is for a nil faultInjector
+func (f *faultInjector) wrap(next http.RoundTripper) http.RoundTripper {
+	if f == nil {
+		return next
+	}
+	if next == nil {
+		next = sharedRoundTripper()
+	}
+
+	return &faultInjectingTransport{injector: f, next: next}
+}
+
+// pick returns the fault to inject, or false if the request should go through
+func (f *faultInjector) pick() (statusCode int, inject bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.random.Float64() >= f.rate {
+		return 0, false
+	}
+
+	return injectedFaults[f.random.Intn(len(injectedFaults))], true
+}
+
+type faultInjectingTransport struct {
+	injector *faultInjector
+	next     http.RoundTripper
+}
+
+func (t *faultInjectingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	statusCode, inject := t.injector.pick()
+	if !inject {
+		return t.next.RoundTrip(request)
+	}
+
+	// the body is consumed by real transports, so do the same to allow retrying requests with a body
+	if request.Body != nil {
+		_, _ = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+	}
+
+	if statusCode == 0 {
+		log.Debug().Msgf("Injecting timeout for %v %v", request.Method, request.URL)
+		return nil, &injectedTimeoutError{}
+	}
+
+	log.Debug().Msgf("Injecting %v response for %v %v", statusCode, request.Method, request.URL)
+
+	// use the google api error format, so the gsuite client reports the injected status code
+	body := fmt.Sprintf(`{"error":{"code":%v,"message":"injected fault"}}`, statusCode)
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%v %v", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+	}, nil
+}
+
+// injectedTimeoutError is returned for injected timeouts; it implements net.Error like the errors of a timed out request
+type injectedTimeoutError struct{}
+
+func (e *injectedTimeoutError) Error() string {
+	return "injected fault: timeout awaiting response headers"
+}
+
+func (e *injectedTimeoutError) Timeout() bool {
+	return true
+}
+
+func (e *injectedTimeoutError) Temporary() bool {
+	return true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestFaultInjector(t *testing.T) {
+	t.Run("ReturnsNextTransportIfDisabled", func(t *testing.T) {
+
+		// act
+		faults := newFaultInjector(0, 1)
+
+		assert.Nil(t, faults)
+		assert.Equal(t, http.DefaultTransport, faults.wrap(http.DefaultTransport))
+	})
+
+	t.Run("InjectsRateLimitingServerErrorsAndTimeouts", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("request shouldn't reach the server")
+		}))
+		defer server.Close()
+		client := &http.Client{Transport: newFaultInjector(1, 1).wrap(http.DefaultTransport)}
+
+		statusCodes := map[int]bool{}
+		timeouts := 0
+		for i := 0; i < 50; i++ {
+			// act
+			response, err := client.Get(server.URL)
+
+			var timeoutErr *injectedTimeoutError
+			if errors.As(err, &timeoutErr) {
+				timeouts++
+				continue
+			}
+			if assert.Nil(t, err) {
+				statusCodes[response.StatusCode] = true
+				response.Body.Close()
+			}
+		}
+
+		assert.Equal(t, map[int]bool{http.StatusTooManyRequests: true, http.StatusInternalServerError: true, http.StatusServiceUnavailable: true}, statusCodes)
+		assert.True(t, timeouts > 0)
+	})
+}
+
+func TestApiClientWithInjectedFaults(t *testing.T) {
+	t.Run("RecoversByRetrying", func(t *testing.T) {
+
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+		estafetteAPI.seedUser("u1", "1234", "alice@example.com")
+		client := NewApiClient(estafetteAPI.URL, nil, ApiClientOptions{MaxRetries: 10, BreakerFailures: 100, UsePatch: true, UseIfMatch: true, Faults: newFaultInjector(0.3, 1)}).(*apiClient)
+		client.client.Backoff = func(retry int) time.Duration { return 0 }
+		ctx := context.Background()
+
+		// act
+		token, err := client.GetToken(ctx, "client-id", "client-secret")
+		assert.Nil(t, err)
+		users, err := client.GetUsers(ctx, token)
+		assert.Nil(t, err)
+		err = client.ApplyActions(ctx, token, []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}}})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(users))
+		assert.Equal(t, []string{"POST /api/groups"}, estafetteAPI.recordedMutations())
+	})
+
+	t.Run("ReportsFailedActionsIfRetriesAreExhausted", func(t *testing.T) {
+
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+		client := NewApiClient(estafetteAPI.URL, nil, ApiClientOptions{MaxRetries: 1, BreakerFailures: 100, UsePatch: true, UseIfMatch: true, Faults: newFaultInjector(1, 1)})
+		ctx := context.Background()
+		actions := []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}}, {Type: ActionCreateGroup, Group: &contracts.Group{Name: "release"}}}
+
+		// act
+		err := client.ApplyActions(ctx, "fake-token", actions)
+
+		assert.NotNil(t, err)
+		assert.NotNil(t, actions[0].Err)
+		assert.NotNil(t, actions[1].Err)
+		assert.Equal(t, 0, len(estafetteAPI.recordedMutations()))
+	})
+}
+
+func TestGsuiteClientWithInjectedFaults(t *testing.T) {
+	t.Run("ReturnsInjectedFaults", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "")
+		client, err := NewGsuiteClient(context.Background(), "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, APIEndpoint: directoryAPI.URL, Faults: newFaultInjector(1, 1)})
+		assert.Nil(t, err)
+
+		// act
+		_, err = client.GetGroups(context.Background())
+
+		var apiErr *googleapi.Error
+		var timeoutErr *injectedTimeoutError
+		assert.True(t, errors.As(err, &apiErr) || errors.As(err, &timeoutErr), "unexpected error %v", err)
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/api/storage/v1"
+)
+
+// NewGCSStateStore returns a StateStore keeping every key as object in the bucket, under the prefix; gcs replaces objects atomically, so an interrupted write keeps the previous object
+func NewGCSStateStore(storageService *storage.Service, bucket, prefix string) StateStore {
+	return &gcsStateStore{
+		storageService: storageService,
+		bucket:         bucket,
+		prefix:         prefix,
+	}
+}
+
+type gcsStateStore struct {
+	storageService *storage.Service
+	bucket         string
+	prefix         string
+}
+
+func (s *gcsStateStore) Read(ctx context.Context, key string) (data []byte, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GCSStateStore::Read")
+	defer span.Finish()
+
+	name := s.objectName(key)
+	response, err := s.storageService.Objects.Get(s.bucket, name).Context(ctx).Download()
+	if isNotFound(err) {
+		return nil, fmt.Errorf("%w: object gs://%v/%v doesn't exist", ErrStateNotFound, s.bucket, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed downloading gs://%v/%v: %w", s.bucket, name, err)
+	}
+	defer response.Body.Close()
+
+	return ioutil.ReadAll(response.Body)
+}
+
+func (s *gcsStateStore) Write(ctx context.Context, key string, data []byte) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GCSStateStore::Write")
+	defer span.Finish()
+
+	name := s.objectName(key)
+	_, err := s.storageService.Objects.Insert(s.bucket, &storage.Object{Name: name, ContentType: "application/json"}).Media(bytes.NewReader(data)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Failed uploading gs://%v/%v: %w", s.bucket, name, err)
+	}
+
+	return nil
+}
+
+func (s *gcsStateStore) objectName(key string) string {
+	return path.Join(s.prefix, stateKey(key))
+}
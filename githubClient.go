@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/opentracing-contrib/go-stdlib/nethttp"
+	"github.com/opentracing/opentracing-go"
+	"github.com/sethgrid/pester"
+)
+
+const githubProviderName = "github"
+
+type GithubClient interface {
+	Provider
+}
+
+// NewGithubClient returns a new GithubClient
+func NewGithubClient(githubAPIBaseURL, githubOrganization, githubToken string) GithubClient {
+
+	// create a single client for all requests, sending them through the shared transport so connections are reused; the timeout is set on the http client, since pester ignores its own Timeout for a client it's given
+	client := pester.NewExtendedClient(&http.Client{Timeout: time.Second * 10, Transport: &nethttp.Transport{RoundTripper: sharedRoundTripper()}})
+	client.MaxRetries = 3
+	client.Backoff = pester.ExponentialJitterBackoff
+	client.KeepLog = true
+
+	return &githubClient{
+		githubAPIBaseURL:   githubAPIBaseURL,
+		githubOrganization: githubOrganization,
+		githubToken:        githubToken,
+		client:             client,
+	}
+}
+
+type githubClient struct {
+	githubAPIBaseURL   string
+	githubOrganization string
+	githubToken        string
+	client             *pester.Client
+}
+
+type githubTeam struct {
+	ID   int    `json:"id"`
+	Slug string `json:"slug"`
+	Name string `json:"name"`
+}
+
+type githubUser struct {
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+	Email string `json:"email"`
+}
+
+func (c *githubClient) Name() string {
+	return githubProviderName
+}
+
+func (c *githubClient) UserIdentityProvider() string {
+	return githubProviderName
+}
+
+func (c *githubClient) GetGroupsWithMembers(ctx context.Context) (groupMembers map[*DirectoryGroup][]*DirectoryMember, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GithubClient::GetGroupsWithMembers")
+	defer span.Finish()
+
+	groupMembers = map[*DirectoryGroup][]*DirectoryMember{}
+
+	teams := make([]*githubTeam, 0)
+	err = c.getAllPages(ctx, fmt.Sprintf("%v/orgs/%v/teams?per_page=100", c.githubAPIBaseURL, c.githubOrganization), func(body []byte) error {
+		var page []*githubTeam
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		teams = append(teams, page...)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	groupMemberCount := 0
+	for _, t := range teams {
+		users := make([]*githubUser, 0)
+		err = c.getAllPages(ctx, fmt.Sprintf("%v/orgs/%v/teams/%v/members?per_page=100", c.githubAPIBaseURL, c.githubOrganization, t.Slug), func(body []byte) error {
+			var page []*githubUser
+			if err := json.Unmarshal(body, &page); err != nil {
+				return err
+			}
+			users = append(users, page...)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+
+		members := make([]*DirectoryMember, 0, len(users))
+		for _, u := range users {
+			members = append(members, &DirectoryMember{
+				ID:    strconv.Itoa(u.ID),
+				Email: u.Email,
+			})
+		}
+
+		// use the team id as identity id, since team names and slugs can be changed
+		groupMembers[&DirectoryGroup{
+			ID:   strconv.Itoa(t.ID),
+			Name: t.Name,
+		}] = members
+		groupMemberCount += len(members)
+	}
+
+	span.LogKV("groups", len(groupMembers), "groupmembers", groupMemberCount)
+
+	return
+}
+
+var githubNextLinkRegex = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// getAllPages follows the link headers returned by the github api until there's no next page
+func (c *githubClient) getAllPages(ctx context.Context, uri string, handlePage func(body []byte) error) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GithubClient::getAllPages")
+	defer span.Finish()
+
+	for uri != "" {
+		body, linkHeader, err := c.getRequest(ctx, uri, span)
+		if err != nil {
+			return err
+		}
+
+		err = handlePage(body)
+		if err != nil {
+			return err
+		}
+
+		uri = ""
+		if matches := githubNextLinkRegex.FindStringSubmatch(linkHeader); len(matches) == 2 {
+			uri = matches[1]
+		}
+	}
+
+	return nil
+}
+
+func (c *githubClient) getRequest(ctx context.Context, uri string, span opentracing.Span) (responseBody []byte, linkHeader string, err error) {
+
+	request, err := http.NewRequestWithContext(ctx, "GET", uri, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// add tracing context
+	request = request.WithContext(opentracing.ContextWithSpan(request.Context(), span))
+
+	// collect additional information on setting up connections
+	request, ht := nethttp.TraceRequest(span.Tracer(), request)
+
+	request.Header.Add("Accept", "application/vnd.github.v3+json")
+	request.Header.Add("Authorization", fmt.Sprintf("token %v", c.githubToken))
+
+	// perform actual request
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	ht.Finish()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%v responded with status code %v", uri, response.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return
+	}
+
+	return body, response.Header.Get("Link"), nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGithubGetGroupsWithMembers(t *testing.T) {
+	t.Run("ReturnsTeamsWithMembersFollowingNextLinks", func(t *testing.T) {
+
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "token abc", r.Header.Get("Authorization"))
+
+			switch r.URL.Path + "?" + r.URL.RawQuery {
+			case "/orgs/estafette/teams?per_page=100":
+				w.Header().Set("Link", fmt.Sprintf(`<%v/orgs/estafette/teams?per_page=100&page=2>; rel="next", <%v/orgs/estafette/teams?per_page=100&page=2>; rel="last"`, server.URL, server.URL))
+				fmt.Fprint(w, `[{"id":1,"slug":"platform","name":"Platform"}]`)
+			case "/orgs/estafette/teams?per_page=100&page=2":
+				fmt.Fprint(w, `[{"id":2,"slug":"release-managers","name":"Release Managers"}]`)
+			case "/orgs/estafette/teams/platform/members?per_page=100":
+				fmt.Fprint(w, `[{"id":11,"login":"john"},{"id":12,"login":"jane"}]`)
+			case "/orgs/estafette/teams/release-managers/members?per_page=100":
+				fmt.Fprint(w, `[]`)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		client := NewGithubClient(server.URL, "estafette", "abc")
+
+		// act
+		groupMembers, err := client.GetGroupsWithMembers(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(groupMembers))
+		for g, members := range groupMembers {
+			switch g.ID {
+			case "1":
+				assert.Equal(t, "Platform", g.Name)
+				assert.Equal(t, 2, len(members))
+				assert.Equal(t, "11", members[0].ID)
+			case "2":
+				assert.Equal(t, "Release Managers", g.Name)
+				assert.Equal(t, 0, len(members))
+			default:
+				assert.Fail(t, "unexpected group", g.ID)
+			}
+		}
+	})
+}
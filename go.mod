@@ -4,14 +4,37 @@ go 1.14
 
 require (
 	github.com/alecthomas/kingpin v2.2.6+incompatible
+	github.com/alicebob/miniredis/v2 v2.30.0
+	github.com/charmbracelet/bubbletea v0.25.0
 	github.com/estafette/estafette-ci-contracts v0.0.208
 	github.com/estafette/estafette-foundation v0.0.57
+	github.com/fsnotify/fsnotify v1.4.7
+	github.com/go-ldap/ldap/v3 v3.2.3
+	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/golang/protobuf v1.3.5
+	github.com/hashicorp/go-hclog v0.9.2
+	github.com/hashicorp/go-plugin v1.2.2
+	github.com/hashicorp/yamux v0.0.0-20181012175058-2f1d1f20f75d // indirect
+	github.com/mitchellh/go-testing-interface v1.0.4 // indirect
 	github.com/opentracing-contrib/go-stdlib v1.0.0
 	github.com/opentracing/opentracing-go v1.1.0
+	github.com/robfig/cron v0.0.0-20180505203441-b41be1df6967
 	github.com/rs/zerolog v1.19.0
 	github.com/sethgrid/pester v1.1.0
+	github.com/sony/gobreaker v0.5.0
 	github.com/stretchr/testify v1.6.1
 	github.com/uber/jaeger-client-go v2.23.1+incompatible
+	golang.org/x/crypto v0.16.0 // indirect
+	golang.org/x/mod v0.14.0 // indirect
+	golang.org/x/net v0.19.0 // indirect
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	golang.org/x/sync v0.5.0
+	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/term v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/tools v0.16.1 // indirect
 	google.golang.org/api v0.26.0
+	google.golang.org/grpc v1.28.0
+	gopkg.in/yaml.v2 v2.2.2
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// groupAnnotationsRegex matches the estafette:{key: value, ...} annotation in a directory group description
+var groupAnnotationsRegex = regexp.MustCompile(`estafette:\s*\{([^}]*)\}`)
+
+// GroupAnnotations are the sync settings delegated admins can set in the description of their directory group
+type GroupAnnotations struct {
+	// Roles are the estafette roles for the group; if nil the roles in estafette are left alone
+	Roles []string
+	// Organizations are the names of the estafette organizations for the group; if nil the organizations in estafette are left alone
+	Organizations []string
+}
+
+// stripGroupAnnotations returns the group description without its estafette:{...} annotation, which is meant for the syncer rather than for people
+func stripGroupAnnotations(description string) string {
+	return strings.TrimSpace(groupAnnotationsRegex.ReplaceAllString(description, ""))
+}
+
+// parseGroupAnnotations reads annotations like estafette:{role: admin, org: retail} from a group description; keys can be repeated to set multiple values
+func parseGroupAnnotations(description string) (annotations *GroupAnnotations, err error) {
+	matches := groupAnnotationsRegex.FindStringSubmatch(description)
+	if len(matches) != 2 {
+		return nil, nil
+	}
+
+	annotations = &GroupAnnotations{}
+
+	for _, pair := range strings.Split(matches[1], ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		keyAndValue := strings.SplitN(pair, ":", 2)
+		if len(keyAndValue) != 2 || strings.TrimSpace(keyAndValue[1]) == "" {
+			return nil, fmt.Errorf("Annotation %q is not of the form key: value", strings.TrimSpace(pair))
+		}
+
+		key := strings.ToLower(strings.TrimSpace(keyAndValue[0]))
+		value := strings.TrimSpace(keyAndValue[1])
+
+		switch key {
+		case "role":
+			annotations.Roles = append(annotations.Roles, value)
+		case "org":
+			annotations.Organizations = append(annotations.Organizations, value)
+		default:
+			return nil, fmt.Errorf("Annotation key %q is not supported, use role or org", key)
+		}
+	}
+
+	return annotations, nil
+}
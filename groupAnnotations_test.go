@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGroupAnnotations(t *testing.T) {
+	t.Run("ReturnsNilIfDescriptionHasNoAnnotations", func(t *testing.T) {
+
+		// act
+		annotations, err := parseGroupAnnotations("Platform team")
+
+		assert.Nil(t, err)
+		assert.Nil(t, annotations)
+	})
+
+	t.Run("ReturnsRolesAndOrganizations", func(t *testing.T) {
+
+		// act
+		annotations, err := parseGroupAnnotations("Platform team estafette:{role: administrator, role: operator, org: retail}")
+
+		assert.Nil(t, err)
+		if assert.NotNil(t, annotations) {
+			assert.Equal(t, []string{"administrator", "operator"}, annotations.Roles)
+			assert.Equal(t, []string{"retail"}, annotations.Organizations)
+		}
+	})
+
+	t.Run("LeavesOrganizationsNilIfNotAnnotated", func(t *testing.T) {
+
+		// act
+		annotations, err := parseGroupAnnotations("estafette:{role: operator}")
+
+		assert.Nil(t, err)
+		if assert.NotNil(t, annotations) {
+			assert.Equal(t, []string{"operator"}, annotations.Roles)
+			assert.Nil(t, annotations.Organizations)
+		}
+	})
+
+	t.Run("ReturnsErrorForUnsupportedKey", func(t *testing.T) {
+
+		// act
+		_, err := parseGroupAnnotations("estafette:{team: platform}")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForMissingValue", func(t *testing.T) {
+
+		// act
+		_, err := parseGroupAnnotations("estafette:{role}")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestStripGroupAnnotations(t *testing.T) {
+	t.Run("ReturnsDescriptionWithoutAnnotations", func(t *testing.T) {
+
+		// act
+		description := stripGroupAnnotations("Platform team estafette:{role: operator}")
+
+		assert.Equal(t, "Platform team", description)
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GroupPrefix holds the settings for the directory groups with one of the --gsuite-group-prefix prefixes, for example to give ciadmin- groups admin roles while ci- groups become regular groups
+type GroupPrefix struct {
+	Prefix string `yaml:"prefix"`
+	// Roles and Organizations are set on the estafette groups for directory groups with the prefix, unless set with annotations in the directory group
+	Roles         []string `yaml:"roles,omitempty"`
+	Organizations []string `yaml:"organizations,omitempty"`
+	// NameTransforms are applied to the names of directory groups with the prefix after trimming it, before the global name transforms
+	NameTransforms []*NameTransform `yaml:"nameTransforms,omitempty"`
+}
+
+// groupPrefix is a compiled GroupPrefix
+type groupPrefix struct {
+	prefix         string
+	roles          []string
+	organizations  []string
+	nameTransforms []nameTransformFunc
+}
+
+// compileGroupPrefixes returns the group prefixes passed with the flags, with the settings for them from the config file; settings for a prefix that isn't passed with the flags are an error, since its groups aren't retrieved
+func compileGroupPrefixes(flagPrefixes []string, configPrefixes []*GroupPrefix) (prefixes []*groupPrefix, err error) {
+
+	prefixes = make([]*groupPrefix, 0, len(flagPrefixes))
+	byPrefix := map[string]*groupPrefix{}
+
+	for _, p := range flagPrefixes {
+		if p = strings.TrimSpace(p); p == "" || byPrefix[p] != nil {
+			continue
+		}
+		byPrefix[p] = &groupPrefix{prefix: p}
+		prefixes = append(prefixes, byPrefix[p])
+	}
+
+	configured := map[string]bool{}
+	for i, cp := range configPrefixes {
+		if cp == nil || strings.TrimSpace(cp.Prefix) == "" {
+			return nil, fmt.Errorf("Group prefix %v has no prefix", i+1)
+		}
+
+		prefix := strings.TrimSpace(cp.Prefix)
+		gp, ok := byPrefix[prefix]
+		if !ok {
+			return nil, fmt.Errorf("Group prefix %v isn't passed with --gsuite-group-prefix", prefix)
+		}
+		if configured[prefix] {
+			return nil, fmt.Errorf("Group prefix %v is configured more than once", prefix)
+		}
+		configured[prefix] = true
+
+		gp.nameTransforms, err = compileNameTransforms(cp.NameTransforms)
+		if err != nil {
+			return nil, fmt.Errorf("Group prefix %v has invalid name transforms: %w", prefix, err)
+		}
+		gp.roles = cp.Roles
+		gp.organizations = cp.Organizations
+	}
+
+	return prefixes, nil
+}
+
+// matchGroupPrefix returns the longest group prefix the directory group name starts with, or nil if it has none of them
+func (o planOptions) matchGroupPrefix(name string) *groupPrefix {
+	var match *groupPrefix
+	for _, gp := range o.groupPrefixes {
+		if strings.HasPrefix(name, gp.prefix) && (match == nil || len(gp.prefix) > len(match.prefix)) {
+			match = gp
+		}
+	}
+
+	return match
+}
+
+// groupAnnotations returns the roles and organizations for the directory group: the ones annotated in the directory, falling back to the ones for its group prefix
+func (o planOptions) groupAnnotations(directoryGroup *DirectoryGroup) *GroupAnnotations {
+	gp := o.matchGroupPrefix(directoryGroup.Name)
+	if directoryGroup.Aggregate || gp == nil || (len(gp.roles) == 0 && len(gp.organizations) == 0) {
+		return directoryGroup.Annotations
+	}
+
+	annotations := &GroupAnnotations{}
+	if directoryGroup.Annotations != nil {
+		*annotations = *directoryGroup.Annotations
+	}
+	if annotations.Roles == nil && len(gp.roles) > 0 {
+		annotations.Roles = gp.roles
+	}
+	if annotations.Organizations == nil && len(gp.organizations) > 0 {
+		annotations.Organizations = gp.organizations
+	}
+
+	return annotations
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileGroupPrefixes(t *testing.T) {
+	t.Run("ReturnsFlagPrefixesWithConfiguredSettings", func(t *testing.T) {
+
+		// act
+		prefixes, err := compileGroupPrefixes([]string{"ci-", "ciadmin-", "ci-"}, []*GroupPrefix{{Prefix: "ciadmin-", Roles: []string{"administrator"}, NameTransforms: []*NameTransform{{Namespace: "admin"}}}})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(prefixes)) {
+			assert.Equal(t, "ci-", prefixes[0].prefix)
+			assert.Equal(t, "ciadmin-", prefixes[1].prefix)
+			assert.Equal(t, []string{"administrator"}, prefixes[1].roles)
+			assert.Equal(t, 1, len(prefixes[1].nameTransforms))
+		}
+	})
+
+	t.Run("ReturnsErrorForSettingsOfPrefixNotPassedWithFlags", func(t *testing.T) {
+
+		// act
+		_, err := compileGroupPrefixes([]string{"ci-"}, []*GroupPrefix{{Prefix: "ciadmin-", Roles: []string{"administrator"}}})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestPlanGroupsAndMembersWithMultipleGroupPrefixes(t *testing.T) {
+	t.Run("AppliesSettingsOfTheLongestMatchingPrefix", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}:             {{ID: "1234", Email: "john@example.com"}},
+			{ID: "ci-admin-platform@example.com", Name: "ci-admin-platform"}: {{ID: "1234", Email: "john@example.com"}},
+		}
+		prefixes, err := compileGroupPrefixes([]string{"ci-", "ci-admin-"}, []*GroupPrefix{{Prefix: "ci-admin-", Roles: []string{"administrator"}, NameTransforms: []*NameTransform{{Namespace: "admin"}}}})
+		assert.Nil(t, err)
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: prefixes})
+
+		roles := map[string][]string{}
+		for _, a := range actions {
+			if a.Type == ActionCreateGroup {
+				roles[a.Group.Name] = groupRoles(a.Group)
+			}
+		}
+		assert.Equal(t, map[string][]string{"platform": {}, "admin/platform": {"administrator"}}, roles)
+	})
+}
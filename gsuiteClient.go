@@ -2,71 +2,592 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/opentracing/opentracing-go"
-	"golang.org/x/oauth2"
+	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"golang.org/x/sync/errgroup"
 	admin "google.golang.org/api/admin/directory/v1"
 	crmv1 "google.golang.org/api/cloudresourcemanager/v1"
-	iam "google.golang.org/api/iam/v1"
+	crmv2 "google.golang.org/api/cloudresourcemanager/v2"
+	"google.golang.org/api/googleapi"
+	groupssettings "google.golang.org/api/groupssettings/v1"
+	"google.golang.org/api/option"
 )
 
 type GsuiteClient interface {
+	StreamingProvider
+	UserProvider
 	GetOrganizations(ctx context.Context) (organizations []*crmv1.Organization, err error)
+	GetResourceHierarchy(ctx context.Context) (nodes []*ResourceNode, err error)
 	GetGroups(ctx context.Context) (groups []*admin.Group, err error)
 	GetGroupMembers(ctx context.Context, groups []*admin.Group) (groupMembers map[*admin.Group][]*admin.Member, err error)
 }
 
+// GsuiteClientOptions configures a GsuiteClient, see the --gsuite-* flags
+type GsuiteClientOptions struct {
+	// CustomerIDs are the customer ids to list groups of instead of the domain
+	CustomerIDs []string
+	// AdminEmails are the admins to impersonate, the first one that can list groups is used
+	AdminEmails []string
+	// GroupPrefixes and GroupDomains filter the groups to synchronize
+	GroupPrefixes []string
+	GroupDomains  []string
+	// Concurrency is the number of groups to list members of in parallel
+	Concurrency int
+	// UserAttributeMapping maps custom schema fields of users to estafette user properties
+	UserAttributeMapping map[string]string
+	// SyncUserProfiles, SyncGroupSettings, SyncMembershipExpiry, SyncDynamicGroups and SyncAdminRoles turn on retrieving the corresponding data
+	SyncUserProfiles     bool
+	SyncGroupSettings    bool
+	SyncMembershipExpiry bool
+	SyncDynamicGroups    bool
+	SyncAdminRoles       bool
+	// Scopes are the oauth scopes requested for the service account
+	Scopes []string
+	// MemberCache, ListCache and Quota are shared across clients; they can be nil
+	MemberCache *memberCache
+	ListCache   *listCache
+	Quota       *quotaTracker
+	// APIEndpoint is the base url of a fake or emulated api to talk to without credentials, for testing
+	APIEndpoint string
+	// Faults injects failures into requests, for testing
+	Faults *faultInjector
+	// HTTPLog logs requests and responses
+	HTTPLog *httpLogger
+}
+
 // NewGsuiteClient returns a new GsuiteClient
-func NewGsuiteClient(ctx context.Context, gsuiteDomain, gsuiteAdminEmail, gsuiteGroupPrefix string) (GsuiteClient, error) {
+func NewGsuiteClient(ctx context.Context, gsuiteDomain string, options GsuiteClientOptions) (GsuiteClient, error) {
 
-	// use service account with G Suite Domain-wide Delegation enabled to authenticate against gsuite apis
-	serviceAccountKeyFileBytes, err := ioutil.ReadFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
-	if err != nil {
-		return nil, err
+	var adminOptions, settingsOptions, gcpOptions []option.ClientOption
+	var cloudIdentityClient *http.Client
+	identityEndpoint := cloudIdentityEndpoint
+	if options.APIEndpoint != "" {
+		// talk to a fake or emulated api without credentials, for testing
+		client := &http.Client{Transport: options.HTTPLog.wrap(options.Faults.wrap(options.Quota.wrap(sharedRoundTripper())))}
+		cloudIdentityClient = client
+		identityEndpoint = options.APIEndpoint + "/cloudidentity/v1/"
+		adminOptions = []option.ClientOption{option.WithEndpoint(options.APIEndpoint + "/admin/directory/v1/"), option.WithHTTPClient(client)}
+		settingsOptions = []option.ClientOption{option.WithEndpoint(options.APIEndpoint + "/groups/v1/groups/"), option.WithHTTPClient(client)}
+		gcpOptions = []option.ClientOption{option.WithEndpoint(options.APIEndpoint + "/"), option.WithHTTPClient(client)}
+	} else {
+		// use service account with G Suite Domain-wide Delegation enabled to authenticate against gsuite apis
+		serviceAccountKeyFileBytes, err := ioutil.ReadFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+		if err != nil {
+			return nil, err
+		}
+
+		jwtConfig, err := google.JWTConfigFromJSON(serviceAccountKeyFileBytes, options.Scopes...)
+		if err != nil {
+			return nil, err
+		}
+
+		// set subject to user that allowed service account with g-suite delegation to impersonate that user
+		adminClient, subject, err := impersonateAdmin(ctx, jwtConfig, options.AdminEmails, func(ctx context.Context, client *http.Client) error {
+			return probeGroupsAccess(ctx, client, gsuiteDomain, firstCustomerID(options.CustomerIDs))
+		})
+		if err != nil {
+			return nil, err
+		}
+		logFromContext(ctx).Info().Msgf("Impersonating gsuite admin %v", subject)
+		adminClient.Transport = options.HTTPLog.wrap(options.Faults.wrap(options.Quota.wrap(adminClient.Transport)))
+		adminOptions = []option.ClientOption{option.WithHTTPClient(adminClient)}
+		settingsOptions = adminOptions
+		cloudIdentityClient = adminClient
+
+		// use service account to authenticate against gcp apis, which are only read
+		googleClient, err := google.DefaultClient(sharedTransportContext(ctx), crmv1.CloudPlatformReadOnlyScope)
+		if err != nil {
+			return nil, err
+		}
+		googleClient.Transport = options.HTTPLog.wrap(options.Faults.wrap(googleClient.Transport))
+		gcpOptions = []option.ClientOption{option.WithHTTPClient(googleClient)}
 	}
 
-	jwtConfig, err := google.JWTConfigFromJSON(serviceAccountKeyFileBytes, admin.AdminDirectoryGroupReadonlyScope, admin.AdminDirectoryGroupMemberReadonlyScope, admin.AdminDirectoryUserReadonlyScope)
+	adminService, err := admin.NewService(ctx, adminOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	// set subject to user that allowed service account with g-suite delegation to impersonate that user
-	jwtConfig.Subject = gsuiteAdminEmail
-	googleClientForGSuite := jwtConfig.Client(oauth2.NoContext)
+	var groupsSettingsService *groupssettings.Service
+	if options.SyncGroupSettings {
+		groupsSettingsService, err = groupssettings.NewService(ctx, settingsOptions...)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	adminService, err := admin.New(googleClientForGSuite)
+	crmv1Service, err := crmv1.NewService(ctx, gcpOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	// use service account to authenticate against gcp apis
-	googleClient, err := google.DefaultClient(ctx, iam.CloudPlatformScope)
+	crmv2Service, err := crmv2.NewService(ctx, gcpOptions...)
 	if err != nil {
 		return nil, err
 	}
 
-	crmv1Service, err := crmv1.New(googleClient)
-	if err != nil {
-		return nil, err
+	concurrency := options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	if !options.SyncMembershipExpiry && !options.SyncDynamicGroups {
+		cloudIdentityClient = nil
 	}
 
 	return &gsuiteClient{
-		gsuiteDomain:      gsuiteDomain,
-		gsuiteGroupPrefix: gsuiteGroupPrefix,
-		adminService:      adminService,
-		crmv1Service:      crmv1Service,
+		gsuiteDomain:          gsuiteDomain,
+		gsuiteCustomerIDs:     options.CustomerIDs,
+		gsuiteGroupPrefixes:   options.GroupPrefixes,
+		gsuiteGroupDomains:    options.GroupDomains,
+		concurrency:           concurrency,
+		userAttributeMapping:  options.UserAttributeMapping,
+		syncUserProfiles:      options.SyncUserProfiles,
+		adminService:          adminService,
+		groupsSettings:        groupsSettingsService,
+		crmv1Service:          crmv1Service,
+		crmv2Service:          crmv2Service,
+		memberCache:           options.MemberCache,
+		listCache:             options.ListCache,
+		cloudIdentityClient:   cloudIdentityClient,
+		cloudIdentityEndpoint: identityEndpoint,
+		syncMembershipExpiry:  options.SyncMembershipExpiry,
+		syncDynamicGroups:     options.SyncDynamicGroups,
+		syncAdminRoles:        options.SyncAdminRoles,
 	}, nil
 }
 
+// impersonateAdmin returns a client impersonating the first of the admin emails that passes probe, so a sync keeps working when an admin gets suspended, loses privileges or leaves the company; a single admin email is used without probing
+func impersonateAdmin(ctx context.Context, jwtConfig *jwt.Config, adminEmails []string, probe func(ctx context.Context, client *http.Client) error) (client *http.Client, subject string, err error) {
+	if len(adminEmails) == 0 {
+		return nil, "", fmt.Errorf("No gsuite admin email to impersonate")
+	}
+
+	for i, email := range adminEmails {
+		config := *jwtConfig
+		config.Subject = email
+		client = config.Client(sharedTransportContext(ctx))
+
+		if len(adminEmails) == 1 {
+			return client, email, nil
+		}
+
+		err = probe(ctx, client)
+		if err == nil {
+			return client, email, nil
+		}
+
+		if i < len(adminEmails)-1 {
+			logFromContext(ctx).Warn().Err(err).Msgf("Failed impersonating gsuite admin %v, falling back to %v", email, adminEmails[i+1])
+		}
+	}
+
+	return nil, "", fmt.Errorf("Failed impersonating any of gsuite admins %v, the last one failed with: %w", strings.Join(adminEmails, ", "), err)
+}
+
+// probeGroupsAccess checks whether the client can list the groups of the domain, or of the customer if set
+func probeGroupsAccess(ctx context.Context, client *http.Client, domain, customerID string) error {
+	adminService, err := admin.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return err
+	}
+
+	_, err = listGroupsIn(adminService.Groups.List(), domain, customerID).MaxResults(1).Context(ctx).Do()
+
+	return err
+}
+
+// listGroupsIn scopes the groups list call to all domains of the customer, or to the domain if customerID is empty
+func listGroupsIn(listCall *admin.GroupsListCall, domain, customerID string) *admin.GroupsListCall {
+	if customerID != "" {
+		return listCall.Customer(customerID)
+	}
+
+	return listCall.Domain(domain)
+}
+
+// listUsersIn scopes the users list call to all domains of the customer, or to the domain if customerID is empty
+func listUsersIn(listCall *admin.UsersListCall, domain, customerID string) *admin.UsersListCall {
+	if customerID != "" {
+		return listCall.Customer(customerID)
+	}
+
+	return listCall.Domain(domain)
+}
+
+// firstCustomerID returns the first of the customer ids, or an empty string to use the domain instead
+func firstCustomerID(customerIDs []string) string {
+	if len(customerIDs) == 0 {
+		return ""
+	}
+
+	return customerIDs[0]
+}
+
 type gsuiteClient struct {
-	gsuiteDomain      string
-	gsuiteGroupPrefix string
-	adminService      *admin.Service
-	crmv1Service      *crmv1.Service
+	gsuiteDomain string
+	// gsuiteCustomerIDs are the customers whose groups and users are listed across all their domains instead of only those of gsuiteDomain, like the tenants a reseller admin manages
+	gsuiteCustomerIDs   []string
+	gsuiteGroupPrefixes []string
+	// gsuiteGroupDomains are the domains of the customers to sync the groups of, or all their domains if empty
+	gsuiteGroupDomains []string
+	concurrency        int
+	// userAttributeMapping maps estafette user properties to Schema.Field custom schema fields
+	userAttributeMapping map[string]string
+	syncUserProfiles     bool
+	adminService         *admin.Service
+	crmv1Service         *crmv1.Service
+	crmv2Service         *crmv2.Service
+	// groupsSettings is nil unless group settings are synchronized
+	groupsSettings *groupssettings.Service
+	// memberCache is nil unless members are cached across daemon cycles
+	memberCache *memberCache
+	// listCache is nil unless group and member lists are fetched conditionally across daemon cycles
+	listCache *listCache
+	// cloudIdentityClient is nil unless membership expirations or dynamic groups are synchronized
+	cloudIdentityClient   *http.Client
+	cloudIdentityEndpoint string
+	syncMembershipExpiry  bool
+	syncDynamicGroups     bool
+	syncAdminRoles        bool
+}
+
+// ResourceNode is a gcp organization, folder or project
+type ResourceNode struct {
+	// ID is the resource name, like organizations/123, folders/456 or projects/my-project
+	ID   string
+	Name string
+	// Path holds the names of the ancestors and the node itself, separated by slashes
+	Path string
+}
+
+func (c *gsuiteClient) Name() string {
+	return gsuiteProviderName
+}
+
+func (c *gsuiteClient) UserIdentityProvider() string {
+	return googleProviderName
+}
+
+// customerIDs returns the customers to list groups and users of, or a single empty id for listing those of the domain
+func (c *gsuiteClient) customerIDs() []string {
+	if len(c.gsuiteCustomerIDs) == 0 {
+		return []string{""}
+	}
+
+	return c.gsuiteCustomerIDs
+}
+
+func (c *gsuiteClient) GetGroupsWithMembers(ctx context.Context) (groupMembers map[*DirectoryGroup][]*DirectoryMember, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetGroupsWithMembers")
+	defer span.Finish()
+
+	groupMembers = map[*DirectoryGroup][]*DirectoryMember{}
+
+	groups, err := c.GetGroups(ctx)
+	if err != nil {
+		return
+	}
+	groups = groupPriority.prioritizeGsuiteGroups(groups)
+
+	gsuiteGroupMembers, err := c.GetGroupMembers(ctx, groups)
+	if err != nil {
+		return
+	}
+
+	groupSettings, err := c.getGroupSettings(ctx, groups)
+	if err != nil {
+		return
+	}
+
+	identityGroups, err := c.getCloudIdentityGroups(ctx, groups)
+	if err != nil {
+		return
+	}
+
+	for g, m := range gsuiteGroupMembers {
+		groupWithMembers := toDirectoryGroupWithMembers(g, m, groupSettings[g], identityGroups[g], c.sourceDomain(g))
+		groupMembers[groupWithMembers.Group] = groupWithMembers.Members
+	}
+
+	return
+}
+
+func (c *gsuiteClient) StreamGroupsWithMembers(ctx context.Context, groupsWithMembers chan<- *DirectoryGroupWithMembers) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::StreamGroupsWithMembers")
+	defer span.Finish()
+
+	defer close(groupsWithMembers)
+
+	// priority groups are matched by email or name, which takes listing all groups up front to stream them before the others; the groups are small compared to their members
+	if groupPriority != nil {
+		var groups []*admin.Group
+		groups, err = c.GetGroups(ctx)
+		if err != nil {
+			return
+		}
+		priority, others := groupPriority.splitGsuiteGroups(groups)
+		err = c.streamGroups(ctx, priority, groupsWithMembers)
+		if err != nil {
+			return
+		}
+		err = c.streamGroups(ctx, others, groupsWithMembers)
+		if err != nil {
+			return
+		}
+		span.LogKV("groups", len(groups), "prioritygroups", len(priority))
+		return nil
+	}
+
+	groupCount := 0
+	for _, customerID := range c.customerIDs() {
+		nextPageToken := ""
+		for {
+			var groups []*admin.Group
+			groups, nextPageToken, err = c.getGroupsPage(ctx, customerID, nextPageToken)
+			if err != nil {
+				return
+			}
+
+			err = c.streamGroups(ctx, groups, groupsWithMembers)
+			if err != nil {
+				return
+			}
+			groupCount += len(groups)
+
+			if nextPageToken == "" {
+				break
+			}
+		}
+	}
+
+	span.LogKV("groups", groupCount)
+
+	return nil
+}
+
+// streamGroups fetches the members of the groups in parallel and passes each group on as soon as its members are known
+func (c *gsuiteClient) streamGroups(ctx context.Context, groups []*admin.Group, groupsWithMembers chan<- *DirectoryGroupWithMembers) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+
+	for _, group := range groups {
+		group := group
+		g.Go(func() error {
+			members, err := c.getGroupMembersPage(gctx, group)
+			if err != nil {
+				return fmt.Errorf("Failed fetching members of gsuite group %v: %w", group.Email, err)
+			}
+
+			settings, err := c.getGroupSettingsForGroup(gctx, group)
+			if err != nil {
+				return err
+			}
+
+			identityGroup, err := c.getCloudIdentityGroup(gctx, group)
+			if err != nil {
+				return err
+			}
+
+			select {
+			case groupsWithMembers <- toDirectoryGroupWithMembers(group, members, settings, identityGroup, c.sourceDomain(group)):
+				return nil
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+		})
+	}
+
+	return g.Wait()
+}
+
+func (c *gsuiteClient) GetDirectoryUsers(ctx context.Context) (users []*DirectoryUser, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetDirectoryUsers")
+	defer span.Finish()
+
+	users = make([]*DirectoryUser, 0)
+
+	// only fetch the custom schemas that are mapped
+	schemas := map[string]bool{}
+	for _, field := range c.userAttributeMapping {
+		schemas[strings.SplitN(field, ".", 2)[0]] = true
+	}
+	schemaNames := make([]string, 0, len(schemas))
+	for schema := range schemas {
+		schemaNames = append(schemaNames, schema)
+	}
+
+	for _, customerID := range c.customerIDs() {
+		var adminRoles map[string][]string
+		if c.syncAdminRoles {
+			adminRoles, err = c.getAdminRoleAssignments(ctx, customerID)
+			if err != nil {
+				return users, err
+			}
+		}
+
+		nextPageToken := ""
+		for {
+			// retrieving users (by page)
+			listCall := c.adminService.Users.List()
+			listUsersIn(listCall, c.gsuiteDomain, customerID)
+			if len(schemaNames) > 0 {
+				listCall.Projection("custom")
+				listCall.CustomFieldMask(strings.Join(schemaNames, ","))
+			}
+			if nextPageToken != "" {
+				listCall.PageToken(nextPageToken)
+			}
+			resp, err := listCall.Context(ctx).Do()
+			if err != nil {
+				return users, err
+			}
+
+			for _, u := range resp.Users {
+				attributes, err := mapCustomSchemaFields(u.CustomSchemas, c.userAttributeMapping)
+				if err != nil {
+					return users, fmt.Errorf("Failed reading custom schemas of gsuite user %v: %w", u.PrimaryEmail, err)
+				}
+
+				directoryUser := &DirectoryUser{
+					ID:         u.Id,
+					Email:      u.PrimaryEmail,
+					IsAdmin:    u.IsAdmin,
+					Suspended:  u.Suspended,
+					Attributes: attributes,
+					AdminRoles: adminRoles[u.Id],
+				}
+				if c.syncUserProfiles {
+					directoryUser.Profile = &DirectoryUserProfile{
+						AvatarURL: u.ThumbnailPhotoUrl,
+					}
+					if u.Name != nil {
+						directoryUser.Profile.Name = u.Name.FullName
+						directoryUser.Profile.GivenName = u.Name.GivenName
+						directoryUser.Profile.FamilyName = u.Name.FamilyName
+					}
+				}
+
+				users = append(users, directoryUser)
+			}
+
+			if resp.NextPageToken == "" {
+				break
+			}
+			nextPageToken = resp.NextPageToken
+		}
+	}
+
+	span.LogKV("users", len(users))
+
+	return
+}
+
+// DirectoryGroupExists checks whether the gsuite group with the id still exists, including groups without the group prefix
+func (c *gsuiteClient) DirectoryGroupExists(ctx context.Context, id string) (exists bool, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::DirectoryGroupExists")
+	defer span.Finish()
+
+	_, err = c.adminService.Groups.Get(id).Fields("id").Context(ctx).Do()
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed fetching gsuite group %v: %w", id, err)
+	}
+
+	return true, nil
+}
+
+// DirectoryUserExists checks whether the gsuite user with the id still exists; suspended users exist
+func (c *gsuiteClient) DirectoryUserExists(ctx context.Context, id string) (exists bool, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::DirectoryUserExists")
+	defer span.Finish()
+
+	_, err = c.adminService.Users.Get(id).Fields("id").Context(ctx).Do()
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed fetching gsuite user %v: %w", id, err)
+	}
+
+	return true, nil
+}
+
+// isNotFound checks whether the error is a 404 response of a google api
+func isNotFound(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// getAdminRoleAssignments returns the names of the admin roles assigned to each user of the customer, by user id
+func (c *gsuiteClient) getAdminRoleAssignments(ctx context.Context, customerID string) (adminRoles map[string][]string, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetAdminRoleAssignments")
+	defer span.Finish()
+
+	// the roles and their assignments are listed per customer, the one of the admin if no customer id is set
+	customer := customerID
+	if customer == "" {
+		customer = "my_customer"
+	}
+
+	roleNames := map[int64]string{}
+	nextPageToken := ""
+	for {
+		listCall := c.adminService.Roles.List(customer)
+		if nextPageToken != "" {
+			listCall.PageToken(nextPageToken)
+		}
+		resp, err := listCall.Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("Failed listing gsuite admin roles: %w", err)
+		}
+		for _, r := range resp.Items {
+			roleNames[r.RoleId] = r.RoleName
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		nextPageToken = resp.NextPageToken
+	}
+
+	adminRoles = map[string][]string{}
+	nextPageToken = ""
+	for {
+		listCall := c.adminService.RoleAssignments.List(customer)
+		if nextPageToken != "" {
+			listCall.PageToken(nextPageToken)
+		}
+		resp, err := listCall.Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("Failed listing gsuite admin role assignments: %w", err)
+		}
+		for _, a := range resp.Items {
+			if name, ok := roleNames[a.RoleId]; ok {
+				adminRoles[a.AssignedTo] = append(adminRoles[a.AssignedTo], name)
+			}
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		nextPageToken = resp.NextPageToken
+	}
+
+	span.LogKV("assignments", len(adminRoles))
+
+	return adminRoles, nil
 }
 
 func (c *gsuiteClient) GetOrganizations(ctx context.Context) (organizations []*crmv1.Organization, err error) {
@@ -85,35 +606,119 @@ func (c *gsuiteClient) GetOrganizations(ctx context.Context) (organizations []*c
 	return organizations, nil
 }
 
-func (c *gsuiteClient) GetGroups(ctx context.Context) (groups []*admin.Group, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetGroups")
+func (c *gsuiteClient) GetResourceHierarchy(ctx context.Context) (nodes []*ResourceNode, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetResourceHierarchy")
 	defer span.Finish()
 
-	groups = make([]*admin.Group, 0)
-	nextPageToken := ""
+	nodes = make([]*ResourceNode, 0)
 
-	for {
-		// retrieving groups (by page)
-		listCall := c.adminService.Groups.List()
-		listCall.Domain(c.gsuiteDomain)
-		if nextPageToken != "" {
-			listCall.PageToken(nextPageToken)
+	organizations, err := c.GetOrganizations(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, o := range organizations {
+		if o.LifecycleState != "" && o.LifecycleState != "ACTIVE" {
+			continue
 		}
-		resp, err := listCall.Do()
+
+		node := &ResourceNode{
+			ID:   o.Name,
+			Name: o.DisplayName,
+			Path: o.DisplayName,
+		}
+		nodes = append(nodes, node)
+
+		descendants, err := c.getResourceDescendants(ctx, node)
 		if err != nil {
-			return groups, err
+			return nodes, err
 		}
+		nodes = append(nodes, descendants...)
+	}
 
-		for _, group := range resp.Groups {
-			if strings.HasPrefix(group.Name, c.gsuiteGroupPrefix) {
-				groups = append(groups, group)
+	span.LogKV("nodes", len(nodes))
+
+	return nodes, nil
+}
+
+// getResourceDescendants recursively retrieves the active folders and projects under an organization or folder
+func (c *gsuiteClient) getResourceDescendants(ctx context.Context, parent *ResourceNode) (nodes []*ResourceNode, err error) {
+
+	nodes = make([]*ResourceNode, 0)
+
+	err = c.crmv2Service.Folders.List().Parent(parent.ID).Pages(ctx, func(resp *crmv2.ListFoldersResponse) error {
+		for _, f := range resp.Folders {
+			if f.LifecycleState != "" && f.LifecycleState != "ACTIVE" {
+				continue
+			}
+
+			node := &ResourceNode{
+				ID:   f.Name,
+				Name: f.DisplayName,
+				Path: parent.Path + "/" + f.DisplayName,
+			}
+			nodes = append(nodes, node)
+
+			descendants, err := c.getResourceDescendants(ctx, node)
+			if err != nil {
+				return err
+			}
+			nodes = append(nodes, descendants...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nodes, fmt.Errorf("Failed listing folders of %v: %w", parent.ID, err)
+	}
+
+	// resource names are of the form organizations/123 or folders/456, the projects api filters on the singular type and the id
+	typeAndID := strings.SplitN(parent.ID, "/", 2)
+	if len(typeAndID) != 2 {
+		return nodes, fmt.Errorf("Resource name %v is not of the form type/id", parent.ID)
+	}
+	filter := fmt.Sprintf("parent.type:%v parent.id:%v", strings.TrimSuffix(typeAndID[0], "s"), typeAndID[1])
+
+	err = c.crmv1Service.Projects.List().Filter(filter).Pages(ctx, func(resp *crmv1.ListProjectsResponse) error {
+		for _, p := range resp.Projects {
+			if p.LifecycleState != "" && p.LifecycleState != "ACTIVE" {
+				continue
 			}
+
+			nodes = append(nodes, &ResourceNode{
+				ID:   "projects/" + p.ProjectId,
+				Name: p.Name,
+				Path: parent.Path + "/" + p.Name,
+			})
 		}
+		return nil
+	})
+	if err != nil {
+		return nodes, fmt.Errorf("Failed listing projects of %v: %w", parent.ID, err)
+	}
 
-		if resp.NextPageToken == "" {
-			break
+	return nodes, nil
+}
+
+func (c *gsuiteClient) GetGroups(ctx context.Context) (groups []*admin.Group, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetGroups")
+	defer span.Finish()
+
+	groups = make([]*admin.Group, 0)
+	for _, customerID := range c.customerIDs() {
+		nextPageToken := ""
+		for {
+			var page []*admin.Group
+			page, nextPageToken, err = c.getGroupsPage(ctx, customerID, nextPageToken)
+			if err != nil {
+				return
+			}
+
+			groups = append(groups, page...)
+
+			if nextPageToken == "" {
+				break
+			}
 		}
-		nextPageToken = resp.NextPageToken
 	}
 
 	span.LogKV("groups", len(groups))
@@ -121,59 +726,162 @@ func (c *gsuiteClient) GetGroups(ctx context.Context) (groups []*admin.Group, er
 	return
 }
 
-func (c *gsuiteClient) GetGroupMembers(ctx context.Context, groups []*admin.Group) (groupMembers map[*admin.Group][]*admin.Member, err error) {
-	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetGroupMembers")
-	defer span.Finish()
+// getGroupsPage retrieves a single page of groups of the customer, or of the domain if customerID is empty, filtered by the group prefixes
+func (c *gsuiteClient) getGroupsPage(ctx context.Context, customerID, pageToken string) (groups []*admin.Group, nextPageToken string, err error) {
 
-	groupMembers = map[*admin.Group][]*admin.Member{}
+	listCall := listGroupsIn(c.adminService.Groups.List(), c.gsuiteDomain, customerID)
+	if pageToken != "" {
+		listCall.PageToken(pageToken)
+	}
+	// page tokens are only unique within the customer
+	cacheKey := pageToken
+	if customerID != "" {
+		cacheKey = customerID + "/" + pageToken
+	}
+	cached := c.listCache.groupsPage(cacheKey)
+	if cached != nil {
+		listCall.IfNoneMatch(cached.Etag)
+	}
+	resp, err := listCall.Context(ctx).Do()
+	if cached != nil && googleapi.IsNotModified(err) {
+		resp, err = cached, nil
+	}
+	if err != nil {
+		return
+	}
+	c.listCache.putGroupsPage(cacheKey, resp)
+
+	groups = make([]*admin.Group, 0, len(resp.Groups))
+	for _, group := range resp.Groups {
+		if !c.inGroupDomains(group) {
+			continue
+		}
+		for _, prefix := range c.gsuiteGroupPrefixes {
+			if strings.HasPrefix(group.Name, prefix) {
+				groups = append(groups, group)
+				break
+			}
+		}
+	}
 
-	groupMemberCount := 0
+	return groups, resp.NextPageToken, nil
+}
 
-	// http://jmoiron.net/blog/limiting-concurrency-in-go/
-	concurrency := 10
-	semaphore := make(chan bool, concurrency)
+// inGroupDomains checks whether the group is homed in one of the domains to sync the groups of
+func (c *gsuiteClient) inGroupDomains(group *admin.Group) bool {
+	if len(c.gsuiteGroupDomains) == 0 {
+		return true
+	}
+	for _, domain := range c.gsuiteGroupDomains {
+		if strings.EqualFold(groupDomain(group.Email), domain) {
+			return true
+		}
+	}
 
-	resultChannel := make(chan struct {
-		group   *admin.Group
-		members []*admin.Member
-		err     error
-	}, len(groups))
+	return false
+}
 
-	for _, group := range groups {
-		// try to fill semaphore up to it's full size otherwise wait for a routine to finish
-		semaphore <- true
+// sourceDomain returns the domain the group is homed in when listing by customer, where groups come from several domains; listing by domain they all come from that domain, so it returns none
+func (c *gsuiteClient) sourceDomain(group *admin.Group) string {
+	if len(c.gsuiteCustomerIDs) == 0 {
+		return ""
+	}
 
-		go func(ctx context.Context, semaphore chan bool, group *admin.Group) {
-			// lower semaphore once the routine's finished, making room for another one to start
-			defer func() { <-semaphore }()
+	return groupDomain(group.Email)
+}
 
-			members, err := c.getGroupMembersPage(ctx, group)
+// groupDomain returns the domain of the group's email address, like eu.example.com for ci-team@eu.example.com
+func groupDomain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return strings.ToLower(email[i+1:])
+	}
 
-			resultChannel <- struct {
-				group   *admin.Group
-				members []*admin.Member
-				err     error
-			}{group, members, err}
+	return ""
+}
 
-		}(ctx, semaphore, group)
+func (c *gsuiteClient) GetGroupMembers(ctx context.Context, groups []*admin.Group) (groupMembers map[*admin.Group][]*admin.Member, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::GetGroupMembers")
+	defer span.Finish()
+
+	groupMembers = map[*admin.Group][]*admin.Member{}
+
+	// without a dead-letter list the first failure fails the fetch, as before
+	deadLetters := deadLetterListFromContext(ctx)
+	if deadLetters == nil {
+		err = c.getMembersOfGroups(ctx, groups, groupMembers, nil)
+		if err != nil {
+			return groupMembers, err
+		}
+		span.LogKV("groupmembers", countGsuiteMembers(groupMembers))
+		return
 	}
 
-	// try to fill semaphore up to it's full size which only succeeds if all routines have finished
-	for i := 0; i < cap(semaphore); i++ {
-		semaphore <- true
+	// a group failing to list doesn't stop the others; the failed ones are retried once all others are done, and dead-lettered if they fail again
+	failed := map[*admin.Group]error{}
+	err = c.getMembersOfGroups(ctx, groups, groupMembers, failed)
+	if err != nil {
+		return groupMembers, err
 	}
 
-	close(resultChannel)
-	for r := range resultChannel {
-		if r.err != nil {
+	if len(failed) > 0 {
+		retries := make([]*admin.Group, 0, len(failed))
+		for group, groupErr := range failed {
+			logFromContext(ctx).Warn().Err(groupErr).Msgf("Failed fetching members of gsuite group %v, retrying once the other groups are fetched", group.Email)
+			retries = append(retries, group)
+		}
+
+		failed = map[*admin.Group]error{}
+		err = c.getMembersOfGroups(ctx, retries, groupMembers, failed)
+		if err != nil {
 			return groupMembers, err
 		}
+		for group, groupErr := range failed {
+			logFromContext(ctx).Error().Err(groupErr).Msgf("Failed fetching members of gsuite group %v again, leaving its estafette group as it is for this run", group.Email)
+			deadLetters.add(group.Email, group.Id, groupErr, 2)
+		}
+	}
+
+	span.LogKV("groupmembers", countGsuiteMembers(groupMembers), "deadletters", len(failed))
+
+	return
+}
+
+// getMembersOfGroups lists the members of multiple groups in parallel into groupMembers; with failed set a group failing to list is added to it instead of canceling the others, otherwise the first failure cancels the calls still in flight. A canceled context always fails
+func (c *gsuiteClient) getMembersOfGroups(ctx context.Context, groups []*admin.Group, groupMembers map[*admin.Group][]*admin.Member, failed map[*admin.Group]error) error {
+	var mutex sync.Mutex
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+
+	for _, group := range groups {
+		group := group
+		g.Go(func() error {
+			members, err := c.getGroupMembersPage(gctx, group)
+
+			mutex.Lock()
+			defer mutex.Unlock()
 
-		groupMembers[r.group] = r.members
-		groupMemberCount += len(r.members)
+			if err != nil {
+				if failed == nil || gctx.Err() != nil {
+					return fmt.Errorf("Failed fetching members of gsuite group %v: %w", group.Email, err)
+				}
+				failed[group] = err
+				return nil
+			}
+			groupMembers[group] = members
+
+			return nil
+		})
 	}
 
-	span.LogKV("groupmembers", groupMemberCount)
+	return g.Wait()
+}
+
+// countGsuiteMembers returns the number of members of all groups combined
+func countGsuiteMembers(groupMembers map[*admin.Group][]*admin.Member) (count int) {
+	for _, members := range groupMembers {
+		count += len(members)
+	}
 
 	return
 }
@@ -181,10 +889,14 @@ func (c *gsuiteClient) GetGroupMembers(ctx context.Context, groups []*admin.Grou
 func (c *gsuiteClient) getGroupMembersPage(ctx context.Context, group *admin.Group) (members []*admin.Member, err error) {
 	members = make([]*admin.Member, 0)
 
-	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::getGroupMembersPage")
+	// a span per group is only recorded for the sampled groups and the ones passed with --trace-groups
+	span, ctx, verbose := startEntitySpan(ctx, "GsuiteClient::getGroupMembersPage", map[string]string{"group.email": group.Email, "group.id": group.Id}, group.Email, group.Id, group.Name)
 	defer span.Finish()
 
-	span.LogKV("group", group.Email)
+	if cached, ok := c.memberCache.get(group); ok {
+		span.LogKV("cached", true, "members", len(cached))
+		return cached, nil
+	}
 
 	nextPageToken := ""
 	for {
@@ -193,10 +905,20 @@ func (c *gsuiteClient) getGroupMembersPage(ctx context.Context, group *admin.Gro
 		if nextPageToken != "" {
 			listCall.PageToken(nextPageToken)
 		}
-		resp, err := listCall.Do()
+		// an unchanged page comes back as 304 without a body, so the cached page is used
+		cached := c.listCache.membersPage(group.Email, nextPageToken)
+		if cached != nil {
+			listCall.IfNoneMatch(cached.Etag)
+		}
+		resp, err := listCall.Context(ctx).Do()
+		if cached != nil && googleapi.IsNotModified(err) {
+			span.LogKV("notmodified", nextPageToken)
+			resp, err = cached, nil
+		}
 		if err != nil {
 			return members, err
 		}
+		c.listCache.putMembersPage(group.Email, nextPageToken, resp)
 
 		members = append(members, resp.Members...)
 
@@ -207,6 +929,145 @@ func (c *gsuiteClient) getGroupMembersPage(ctx context.Context, group *admin.Gro
 	}
 
 	span.LogKV("members", len(members))
+	if verbose {
+		emails := make([]string, 0, len(members))
+		for _, m := range members {
+			emails = append(emails, m.Email)
+		}
+		span.LogKV("memberEmails", strings.Join(emails, ","))
+	}
+	c.memberCache.put(group, members)
 
 	return members, nil
 }
+
+// getGroupSettings retrieves the settings of the groups in parallel; it returns an empty map if group settings aren't synchronized
+func (c *gsuiteClient) getGroupSettings(ctx context.Context, groups []*admin.Group) (groupSettings map[*admin.Group]*DirectoryGroupSettings, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::getGroupSettings")
+	defer span.Finish()
+
+	groupSettings = map[*admin.Group]*DirectoryGroupSettings{}
+	if c.groupsSettings == nil {
+		return
+	}
+
+	var mutex sync.Mutex
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+
+	for _, group := range groups {
+		group := group
+		g.Go(func() error {
+			settings, err := c.getGroupSettingsForGroup(ctx, group)
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			groupSettings[group] = settings
+
+			return nil
+		})
+	}
+
+	err = g.Wait()
+
+	return
+}
+
+// getGroupSettingsForGroup retrieves the settings of a single group, or nil if group settings aren't synchronized
+func (c *gsuiteClient) getGroupSettingsForGroup(ctx context.Context, group *admin.Group) (*DirectoryGroupSettings, error) {
+	if c.groupsSettings == nil {
+		return nil, nil
+	}
+
+	settings, err := c.groupsSettings.Groups.Get(group.Email).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("Failed fetching settings of gsuite group %v: %w", group.Email, err)
+	}
+
+	return &DirectoryGroupSettings{
+		AllowExternalMembers: settings.AllowExternalMembers == "true",
+		WhoCanJoin:           settings.WhoCanJoin,
+	}, nil
+}
+
+func toDirectoryGroupWithMembers(group *admin.Group, members []*admin.Member, settings *DirectoryGroupSettings, identityGroup *cloudIdentityGroup, domain string) *DirectoryGroupWithMembers {
+	// invalid annotations shouldn't break the sync for all groups, so they're ignored
+	annotations, err := parseGroupAnnotations(group.Description)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Ignoring invalid annotations in description of gsuite group %v", group.Email)
+	}
+
+	groupWithMembers := &DirectoryGroupWithMembers{
+		Group: &DirectoryGroup{
+			ID:          group.Id,
+			Name:        group.Name,
+			Email:       group.Email,
+			Description: stripGroupAnnotations(group.Description),
+			Annotations: annotations,
+			Settings:    settings,
+			Dynamic:     identityGroup.dynamicGroup(),
+			Domain:      domain,
+		},
+		Members: make([]*DirectoryMember, 0, len(members)),
+	}
+	for _, member := range members {
+		directoryMember := &DirectoryMember{
+			ID:    member.Id,
+			Email: member.Email,
+		}
+		if expireTime, ok := identityGroup.expiration(member.Email); ok {
+			directoryMember.ExpireTime = &expireTime
+		}
+		groupWithMembers.Members = append(groupWithMembers.Members, directoryMember)
+	}
+
+	return groupWithMembers
+}
+
+// mapCustomSchemaFields returns the value of the Schema.Field custom schema field for every mapped property, or an empty value if the user doesn't have it; values of multi-valued fields are joined with a comma
+func mapCustomSchemaFields(customSchemas map[string]googleapi.RawMessage, mapping map[string]string) (attributes map[string]string, err error) {
+
+	attributes = map[string]string{}
+
+	for property, field := range mapping {
+		attributes[property] = ""
+
+		schemaAndField := strings.SplitN(field, ".", 2)
+		if len(schemaAndField) != 2 {
+			continue
+		}
+
+		rawSchema, ok := customSchemas[schemaAndField[0]]
+		if !ok {
+			continue
+		}
+
+		var fields map[string]interface{}
+		err = json.Unmarshal(rawSchema, &fields)
+		if err != nil {
+			return
+		}
+
+		switch value := fields[schemaAndField[1]].(type) {
+		case nil:
+		case string:
+			attributes[property] = value
+		case []interface{}:
+			values := make([]string, 0, len(value))
+			for _, v := range value {
+				if multiValue, ok := v.(map[string]interface{}); ok && multiValue["value"] != nil {
+					values = append(values, fmt.Sprint(multiValue["value"]))
+				}
+			}
+			attributes[property] = strings.Join(values, ",")
+		default:
+			attributes[property] = fmt.Sprint(value)
+		}
+	}
+
+	return attributes, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2/jwt"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestMapCustomSchemaFields(t *testing.T) {
+	t.Run("ReturnsMappedSingleAndMultiValuedFields", func(t *testing.T) {
+
+		customSchemas := map[string]googleapi.RawMessage{
+			"EmployeeInfo": googleapi.RawMessage(`{"CostCenter":"1000","EmployeeID":42,"Teams":[{"value":"platform"},{"value":"release"}]}`),
+		}
+		mapping := map[string]string{
+			"costCenter": "EmployeeInfo.CostCenter",
+			"employeeID": "EmployeeInfo.EmployeeID",
+			"teams":      "EmployeeInfo.Teams",
+		}
+
+		// act
+		attributes, err := mapCustomSchemaFields(customSchemas, mapping)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"costCenter": "1000", "employeeID": "42", "teams": "platform,release"}, attributes)
+	})
+
+	t.Run("ReturnsEmptyValueForMissingSchemaOrField", func(t *testing.T) {
+
+		customSchemas := map[string]googleapi.RawMessage{
+			"EmployeeInfo": googleapi.RawMessage(`{"CostCenter":"1000"}`),
+		}
+		mapping := map[string]string{
+			"team":     "EmployeeInfo.Team",
+			"location": "Location.Building",
+		}
+
+		// act
+		attributes, err := mapCustomSchemaFields(customSchemas, mapping)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"team": "", "location": ""}, attributes)
+	})
+}
+
+func TestImpersonateAdmin(t *testing.T) {
+	t.Run("FallsBackToTheNextAdminIfTheFirstIsSuspended", func(t *testing.T) {
+
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err)
+
+		// the token endpoint rejects the suspended admin
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			w.Header().Set("Content-Type", "application/json")
+			if strings.Contains(decodeJWTClaims(r.Form.Get("assertion")), "suspended@example.com") {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Invalid email or User ID"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"fake-token","token_type":"Bearer","expires_in":3600}`)
+		}))
+		defer tokenServer.Close()
+
+		apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer apiServer.Close()
+
+		jwtConfig := &jwt.Config{
+			Email:      "syncer@project.iam.gserviceaccount.com",
+			PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}),
+			TokenURL:   tokenServer.URL,
+		}
+		probe := func(ctx context.Context, client *http.Client) error {
+			response, err := client.Get(apiServer.URL)
+			if err != nil {
+				return err
+			}
+			return response.Body.Close()
+		}
+
+		// act
+		client, subject, err := impersonateAdmin(context.Background(), jwtConfig, []string{"suspended@example.com", "backup@example.com"}, probe)
+
+		assert.Nil(t, err)
+		assert.NotNil(t, client)
+		assert.Equal(t, "backup@example.com", subject)
+		assert.Equal(t, "", jwtConfig.Subject)
+	})
+
+	t.Run("ReturnsErrorIfNoAdminCanBeImpersonated", func(t *testing.T) {
+
+		probe := func(ctx context.Context, client *http.Client) error {
+			return fmt.Errorf("forbidden")
+		}
+
+		// act
+		_, _, err := impersonateAdmin(context.Background(), &jwt.Config{}, []string{"first@example.com", "second@example.com"}, probe)
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "first@example.com, second@example.com")
+	})
+}
+
+func TestGsuiteClientWithCustomerIDs(t *testing.T) {
+	t.Run("ListsGroupsOfEveryCustomer", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-release@example.org", "ci-release", "", &admin.Member{Id: "5678", Email: "jane@example.org"})
+		directoryAPI.seedGroup("ci-legacy@example.net", "ci-legacy", "", &admin.Member{Id: "9012", Email: "joe@example.net"})
+		directoryAPI.setGroupCustomer("ci-platform@example.com", "C01")
+		directoryAPI.setGroupCustomer("ci-release@example.org", "C02")
+		directoryAPI.setGroupCustomer("ci-legacy@example.net", "C03")
+		ctx := context.Background()
+		client, err := NewGsuiteClient(ctx, "", GsuiteClientOptions{CustomerIDs: []string{"C01", "C02"}, GroupPrefixes: []string{"ci-"}, Concurrency: 1, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+
+		// act
+		groups, err := client.GetGroups(ctx)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(groups)) {
+			assert.Equal(t, "ci-platform@example.com", groups[0].Email)
+			assert.Equal(t, "ci-release@example.org", groups[1].Email)
+		}
+	})
+
+	t.Run("ListsOnlyGroupsOfGroupDomainsWithTheirDomain", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-platform@eu.example.com", "ci-platform", "", &admin.Member{Id: "5678", Email: "jane@eu.example.com"})
+		directoryAPI.seedGroup("ci-release@us.example.com", "ci-release", "", &admin.Member{Id: "9012", Email: "joe@us.example.com"})
+		directoryAPI.setGroupCustomer("ci-platform@example.com", "C01")
+		directoryAPI.setGroupCustomer("ci-platform@eu.example.com", "C01")
+		directoryAPI.setGroupCustomer("ci-release@us.example.com", "C01")
+		ctx := context.Background()
+		client, err := NewGsuiteClient(ctx, "", GsuiteClientOptions{CustomerIDs: []string{"C01"}, GroupPrefixes: []string{"ci-"}, GroupDomains: []string{"EU.example.com", "us.example.com"}, Concurrency: 1, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+
+		// act
+		groupMembers, err := client.GetGroupsWithMembers(ctx)
+
+		assert.Nil(t, err)
+		domains := map[string]string{}
+		for g := range groupMembers {
+			domains[g.Email] = g.Domain
+		}
+		assert.Equal(t, map[string]string{"ci-platform@eu.example.com": "eu.example.com", "ci-release@us.example.com": "us.example.com"}, domains)
+	})
+}
+
+func TestGsuiteClientWithAdminRoles(t *testing.T) {
+	t.Run("SetsAdminRolesAssignedToDirectoryUsers", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedUser("101", "jane@example.com")
+		directoryAPI.seedUser("102", "ted@example.com")
+		directoryAPI.seedAdminRole("101", 1, "_GROUPS_ADMIN_ROLE")
+		directoryAPI.seedAdminRole("101", 2, "Help Desk Admin")
+		ctx := context.Background()
+		client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, SyncAdminRoles: true, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+
+		// act
+		users, err := client.GetDirectoryUsers(ctx)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(users)) {
+			assert.Equal(t, []string{"_GROUPS_ADMIN_ROLE", "Help Desk Admin"}, users[0].AdminRoles)
+			assert.Equal(t, 0, len(users[1].AdminRoles))
+		}
+	})
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"golang.org/x/sync/errgroup"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// cloudIdentityGroupsScope is needed to read membership expirations and dynamic group queries, which only the cloud identity api returns
+const cloudIdentityGroupsScope = "https://www.googleapis.com/auth/cloud-identity.groups.readonly"
+
+// cloudIdentityEndpoint is the base url of the cloud identity api
+const cloudIdentityEndpoint = "https://cloudidentity.googleapis.com/v1/"
+
+// cloudIdentityDynamicGroupUpToDate is the status of a dynamic group whose memberships are resolved from its current query
+const cloudIdentityDynamicGroupUpToDate = "UP_TO_DATE"
+
+// cloudIdentityGroup holds what the syncer reads from the cloud identity api for a gsuite group
+type cloudIdentityGroup struct {
+	// expirations are the expire times of time-bound memberships by lowercased member email; nil unless membership expiry is synchronized
+	expirations map[string]time.Time
+	// dynamic is set for dynamic groups if dynamic groups are synchronized
+	dynamic *DirectoryGroupDynamic
+}
+
+// expiration returns the expire time of the membership of the member if it's time-bound; it's nil-safe
+func (g *cloudIdentityGroup) expiration(email string) (time.Time, bool) {
+	if g == nil {
+		return time.Time{}, false
+	}
+	expireTime, ok := g.expirations[strings.ToLower(email)]
+
+	return expireTime, ok
+}
+
+// dynamicGroup returns the membership query of a dynamic group, or nil for regular groups; it's nil-safe
+func (g *cloudIdentityGroup) dynamicGroup() *DirectoryGroupDynamic {
+	if g == nil {
+		return nil
+	}
+
+	return g.dynamic
+}
+
+// cloudIdentityGroupDetails is a group as returned by the cloud identity api; the generated client doesn't know about dynamic groups, so it's decoded here
+type cloudIdentityGroupDetails struct {
+	DynamicGroupMetadata *struct {
+		Queries []struct {
+			ResourceType string `json:"resourceType"`
+			Query        string `json:"query"`
+		} `json:"queries"`
+		Status struct {
+			Status string `json:"status"`
+		} `json:"status"`
+	} `json:"dynamicGroupMetadata,omitempty"`
+}
+
+// cloudIdentityMemberships is a page of memberships as returned by the cloud identity api; the generated client doesn't know about expiry details either
+type cloudIdentityMemberships struct {
+	Memberships []struct {
+		PreferredMemberKey struct {
+			ID string `json:"id"`
+		} `json:"preferredMemberKey"`
+		Roles []struct {
+			Name         string `json:"name"`
+			ExpiryDetail *struct {
+				ExpireTime time.Time `json:"expireTime"`
+			} `json:"expiryDetail,omitempty"`
+		} `json:"roles"`
+	} `json:"memberships"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// getCloudIdentityGroups retrieves the cloud identity details of the groups in parallel; it returns an empty map if neither membership expiry nor dynamic groups are synchronized
+func (c *gsuiteClient) getCloudIdentityGroups(ctx context.Context, groups []*admin.Group) (identityGroups map[*admin.Group]*cloudIdentityGroup, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GsuiteClient::getCloudIdentityGroups")
+	defer span.Finish()
+
+	identityGroups = map[*admin.Group]*cloudIdentityGroup{}
+	if c.cloudIdentityClient == nil {
+		return
+	}
+
+	var mutex sync.Mutex
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(c.concurrency)
+
+	for _, group := range groups {
+		group := group
+		g.Go(func() error {
+			identityGroup, err := c.getCloudIdentityGroup(ctx, group)
+			if err != nil {
+				return err
+			}
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			identityGroups[group] = identityGroup
+
+			return nil
+		})
+	}
+
+	err = g.Wait()
+
+	return
+}
+
+// getCloudIdentityGroup retrieves the cloud identity details of a single group, or nil if neither membership expiry nor dynamic groups are synchronized
+func (c *gsuiteClient) getCloudIdentityGroup(ctx context.Context, group *admin.Group) (*cloudIdentityGroup, error) {
+	if c.cloudIdentityClient == nil {
+		return nil, nil
+	}
+
+	var lookup struct {
+		Name string `json:"name"`
+	}
+	err := c.getCloudIdentity(ctx, "groups:lookup?groupKey.id="+url.QueryEscape(group.Email), &lookup)
+	if err != nil {
+		return nil, fmt.Errorf("Failed looking up gsuite group %v in the cloud identity api: %w", group.Email, err)
+	}
+
+	identityGroup := &cloudIdentityGroup{}
+	if c.syncDynamicGroups {
+		identityGroup.dynamic, err = c.getDynamicGroup(ctx, group, lookup.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.syncMembershipExpiry {
+		identityGroup.expirations, err = c.getMembershipExpirations(ctx, group, lookup.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return identityGroup, nil
+}
+
+// getDynamicGroup returns the membership query of a dynamic group, or nil for a regular group
+func (c *gsuiteClient) getDynamicGroup(ctx context.Context, group *admin.Group, name string) (*DirectoryGroupDynamic, error) {
+	var details cloudIdentityGroupDetails
+	err := c.getCloudIdentity(ctx, name, &details)
+	if err != nil {
+		return nil, fmt.Errorf("Failed fetching gsuite group %v from the cloud identity api: %w", group.Email, err)
+	}
+	if details.DynamicGroupMetadata == nil {
+		return nil, nil
+	}
+
+	queries := make([]string, 0, len(details.DynamicGroupMetadata.Queries))
+	for _, q := range details.DynamicGroupMetadata.Queries {
+		queries = append(queries, q.Query)
+	}
+
+	// the members listed by the directory are resolved from the query, which may still be in progress after it changed
+	status := details.DynamicGroupMetadata.Status.Status
+	if status != cloudIdentityDynamicGroupUpToDate {
+		logFromContext(ctx).Warn().Msgf("Dynamic gsuite group %v has status %v, its members may not match its query yet", group.Email, status)
+	}
+
+	return &DirectoryGroupDynamic{Query: strings.Join(queries, " || ")}, nil
+}
+
+// getMembershipExpirations retrieves the expirations of the time-bound memberships of a single group, by lowercased member email
+func (c *gsuiteClient) getMembershipExpirations(ctx context.Context, group *admin.Group, name string) (map[string]time.Time, error) {
+
+	expirations := map[string]time.Time{}
+	pageToken := ""
+	for {
+		var page cloudIdentityMemberships
+		err := c.getCloudIdentity(ctx, fmt.Sprintf("%v/memberships?view=FULL&pageSize=500&pageToken=%v", name, url.QueryEscape(pageToken)), &page)
+		if err != nil {
+			return nil, fmt.Errorf("Failed fetching memberships of gsuite group %v from the cloud identity api: %w", group.Email, err)
+		}
+
+		for _, m := range page.Memberships {
+			for _, r := range m.Roles {
+				if r.Name == "MEMBER" && r.ExpiryDetail != nil && !r.ExpiryDetail.ExpireTime.IsZero() {
+					expirations[strings.ToLower(m.PreferredMemberKey.ID)] = r.ExpiryDetail.ExpireTime
+				}
+			}
+		}
+
+		pageToken = page.NextPageToken
+		if pageToken == "" {
+			return expirations, nil
+		}
+	}
+}
+
+func (c *gsuiteClient) getCloudIdentity(ctx context.Context, path string, value interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cloudIdentityEndpoint+path, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.cloudIdentityClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("status code %v", response.StatusCode)
+	}
+
+	return json.NewDecoder(response.Body).Decode(value)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// gsuiteListCache holds the group and member list pages with their etags across daemon cycles; like the gsuiteMemberCache it lives outside the gsuiteClient because every sync creates a new client
+var gsuiteListCache *listCache
+
+// listCache keeps the last response of every group and member list page, so the next request for the page is sent with If-None-Match and an unchanged page comes back as an empty 304 instead of the entire list
+type listCache struct {
+	mutex       sync.Mutex
+	groupPages  map[string]*admin.Groups
+	memberPages map[string]*admin.Members
+}
+
+// newListCache returns an empty listCache
+func newListCache() *listCache {
+	return &listCache{
+		groupPages:  map[string]*admin.Groups{},
+		memberPages: map[string]*admin.Members{},
+	}
+}
+
+// groupsPage returns the cached page of groups with the page token, or nil if there's none; a nil listCache never has pages
+func (c *listCache) groupsPage(pageToken string) *admin.Groups {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.groupPages[pageToken]
+}
+
+// putGroupsPage caches the page of groups with the page token; pages without an etag aren't cached, since they can't be requested conditionally
+func (c *listCache) putGroupsPage(pageToken string, page *admin.Groups) {
+	if c == nil || page.Etag == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.groupPages[pageToken] = page
+}
+
+// membersPage returns the cached page of members of the group with the page token, or nil if there's none; a nil listCache never has pages
+func (c *listCache) membersPage(groupEmail, pageToken string) *admin.Members {
+	if c == nil {
+		return nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.memberPages[groupEmail+"/"+pageToken]
+}
+
+// putMembersPage caches the page of members of the group with the page token; pages without an etag aren't cached, since they can't be requested conditionally
+func (c *listCache) putMembersPage(groupEmail, pageToken string, page *admin.Members) {
+	if c == nil || page.Etag == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.memberPages[groupEmail+"/"+pageToken] = page
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestGsuiteClientWithListCache(t *testing.T) {
+	t.Run("RequestsUnchangedListsWithTheirEtags", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		cache := newListCache()
+		ctx := context.Background()
+
+		// every sync creates a new client, sharing the cache
+		fetch := func() map[*DirectoryGroup][]*DirectoryMember {
+			client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, ListCache: cache, APIEndpoint: directoryAPI.URL})
+			assert.Nil(t, err)
+			groupMembers, err := client.GetGroupsWithMembers(ctx)
+			assert.Nil(t, err)
+			return groupMembers
+		}
+		fetch()
+
+		// act
+		groupMembers := fetch()
+
+		assert.Equal(t, 3, directoryAPI.notModified)
+		assert.Equal(t, 3, countMembers(groupMembers))
+	})
+
+	t.Run("FetchesChangedListsAgain", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		cache := newListCache()
+		ctx := context.Background()
+
+		fetch := func() map[*DirectoryGroup][]*DirectoryMember {
+			client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, ListCache: cache, APIEndpoint: directoryAPI.URL})
+			assert.Nil(t, err)
+			groupMembers, err := client.GetGroupsWithMembers(ctx)
+			assert.Nil(t, err)
+			return groupMembers
+		}
+		fetch()
+		directoryAPI.removeMember("ci-platform@example.com", "jane@example.com")
+
+		// act
+		groupMembers := fetch()
+
+		// the groups list changed with the member count of the group, so only the members of the other group weren't modified
+		assert.Equal(t, 1, directoryAPI.notModified)
+		for g, members := range groupMembers {
+			assert.Equal(t, 1, len(members), g.Name)
+		}
+	})
+
+	t.Run("IsNilSafe", func(t *testing.T) {
+
+		var cache *listCache
+
+		// act
+		cache.putGroupsPage("", &admin.Groups{Etag: "1"})
+		cache.putMembersPage("ci-platform@example.com", "", &admin.Members{Etag: "1"})
+
+		assert.Nil(t, cache.groupsPage(""))
+		assert.Nil(t, cache.membersPage("ci-platform@example.com", ""))
+	})
+}
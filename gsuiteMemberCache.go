@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// gsuiteMemberCache holds the members of gsuite groups across daemon cycles, so groups that didn't change aren't listed again every interval; it lives outside the gsuiteClient because every sync creates a new client
+var gsuiteMemberCache *memberCache
+
+// memberCache caches the members of gsuite groups by the version of the group, which changes with its etag or member count; entries expire after the ttl, to pick up member changes that don't change the group
+type memberCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mutex   sync.Mutex
+	entries map[string]*memberCacheEntry
+}
+
+type memberCacheEntry struct {
+	version  string
+	members  []*admin.Member
+	cachedAt time.Time
+}
+
+// newMemberCache returns a memberCache with entries expiring after the ttl, or nil if the ttl is zero so members are always listed
+func newMemberCache(ttl time.Duration) *memberCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	return &memberCache{ttl: ttl, now: time.Now, entries: map[string]*memberCacheEntry{}}
+}
+
+// get returns the cached members of the group if its version didn't change and the entry didn't expire; a nil memberCache never has members
+func (c *memberCache) get(group *admin.Group) (members []*admin.Member, ok bool) {
+	version := memberCacheVersion(group)
+	if c == nil || version == "" {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[group.Email]
+	if !ok || entry.version != version || c.now().Sub(entry.cachedAt) > c.ttl {
+		return nil, false
+	}
+
+	return entry.members, true
+}
+
+// put caches the members of the group; groups without an etag aren't cached, since their changes can't be detected
+func (c *memberCache) put(group *admin.Group, members []*admin.Member) {
+	version := memberCacheVersion(group)
+	if c == nil || version == "" {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[group.Email] = &memberCacheEntry{version: version, members: members, cachedAt: c.now()}
+}
+
+// memberCacheVersion returns the etag and direct member count of the group, or an empty string if it has no etag
+func memberCacheVersion(group *admin.Group) string {
+	if group.Etag == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%v/%v", group.Etag, group.DirectMembersCount)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestMemberCache(t *testing.T) {
+	t.Run("ReturnsNilIfTTLIsZero", func(t *testing.T) {
+
+		// act
+		cache := newMemberCache(0)
+
+		assert.Nil(t, cache)
+		cache.put(&admin.Group{Email: "ci-platform@example.com", Etag: "1"}, nil)
+		_, ok := cache.get(&admin.Group{Email: "ci-platform@example.com", Etag: "1"})
+		assert.False(t, ok)
+	})
+
+	t.Run("MissesIfGroupVersionChangedOrEntryExpired", func(t *testing.T) {
+
+		now := time.Now()
+		cache := newMemberCache(time.Hour)
+		cache.now = func() time.Time { return now }
+		group := &admin.Group{Email: "ci-platform@example.com", Etag: "1", DirectMembersCount: 1}
+		cache.put(group, []*admin.Member{{Id: "1234", Email: "john@example.com"}})
+
+		// act
+		members, hit := cache.get(group)
+		_, hitAfterMemberAdded := cache.get(&admin.Group{Email: "ci-platform@example.com", Etag: "1", DirectMembersCount: 2})
+		_, hitAfterEtagChanged := cache.get(&admin.Group{Email: "ci-platform@example.com", Etag: "2", DirectMembersCount: 1})
+		now = now.Add(2 * time.Hour)
+		_, hitAfterExpiry := cache.get(group)
+
+		assert.True(t, hit)
+		assert.Equal(t, 1, len(members))
+		assert.False(t, hitAfterMemberAdded)
+		assert.False(t, hitAfterEtagChanged)
+		assert.False(t, hitAfterExpiry)
+	})
+
+	t.Run("DoesNotCacheGroupsWithoutEtag", func(t *testing.T) {
+
+		cache := newMemberCache(time.Hour)
+		group := &admin.Group{Email: "ci-platform@example.com"}
+		cache.put(group, []*admin.Member{})
+
+		// act
+		_, ok := cache.get(group)
+
+		assert.False(t, ok)
+	})
+}
+
+func TestGsuiteClientWithMemberCache(t *testing.T) {
+	t.Run("OnlyListsMembersOfChangedGroupsAgain", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"}, &admin.Member{Id: "5678", Email: "jane@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		cache := newMemberCache(time.Hour)
+		ctx := context.Background()
+
+		// every sync creates a new client, sharing the cache
+		fetch := func() map[*DirectoryGroup][]*DirectoryMember {
+			client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, MemberCache: cache, APIEndpoint: directoryAPI.URL})
+			assert.Nil(t, err)
+			groupMembers, err := client.GetGroupsWithMembers(ctx)
+			assert.Nil(t, err)
+			return groupMembers
+		}
+		fetch()
+		directoryAPI.removeMember("ci-platform@example.com", "jane@example.com")
+
+		// act
+		groupMembers := fetch()
+
+		assert.Equal(t, 3, directoryAPI.memberLists)
+		for g, members := range groupMembers {
+			assert.Equal(t, 1, len(members), g.Name)
+		}
+	})
+}
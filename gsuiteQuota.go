@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// gsuiteQuota counts the directory api calls of every sync; like the gsuiteMemberCache it lives outside the gsuiteClient, so the calls of earlier syncs in the last day count towards the quota estimate as well
+var gsuiteQuota *quotaTracker
+
+// quotaWindow is the period the daily quota of the directory api applies to; google resets it at midnight pacific time, a rolling window of a day is a conservative estimate of that
+const quotaWindow = 24 * time.Hour
+
+// quotaTracker counts the directory api calls of the current sync and keeps the counts of the syncs in the last day, to estimate the remaining --gsuite-daily-quota and throttle a daemon whose syncs would exceed it
+type quotaTracker struct {
+	// calls is updated atomically, so it comes first to be 64-bit aligned
+	calls      int64
+	dailyQuota int
+
+	mutex   sync.Mutex
+	runs    []quotaUsage
+	reduced bool
+}
+
+// quotaUsage is the number of directory api calls of a sync that finished at the time
+type quotaUsage struct {
+	at    time.Time
+	calls int64
+}
+
+// newQuotaTracker returns a quotaTracker estimating the remaining calls of the daily quota; with a daily quota of zero it only counts calls
+func newQuotaTracker(dailyQuota int) *quotaTracker {
+	return &quotaTracker{dailyQuota: dailyQuota}
+}
+
+// wrap returns a transport counting the directory api requests going through next; other google apis have quotas of their own and aren't counted. A nil quotaTracker returns next as is
+func (q *quotaTracker) wrap(next http.RoundTripper) http.RoundTripper {
+	if q == nil {
+		return next
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+
+	return &quotaCountingTransport{quota: q, next: next}
+}
+
+// finishRun returns the directory api calls of the sync that just finished and the estimated calls left of the daily quota, or -1 without a daily quota, and starts counting the next sync
+func (q *quotaTracker) finishRun(now time.Time) (calls int64, remaining int) {
+	if q == nil {
+		return 0, -1
+	}
+
+	calls = atomic.SwapInt64(&q.calls, 0)
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.runs = append(q.runs, quotaUsage{at: now, calls: calls})
+	q.prune(now)
+
+	return calls, q.remaining()
+}
+
+// projectedDailyCalls estimates the calls a day of syncing every interval takes, from the calls of the last sync; without an interval it's the calls used in the last day plus one more sync like the last
+func (q *quotaTracker) projectedDailyCalls(interval time.Duration) int64 {
+	if len(q.runs) == 0 {
+		return 0
+	}
+
+	last := q.runs[len(q.runs)-1].calls
+	if interval <= 0 {
+		return q.used() + last
+	}
+
+	syncsPerDay := int64((quotaWindow + interval - 1) / interval)
+
+	return last * syncsPerDay
+}
+
+// adapt checks before every daemon sync whether syncing every interval is projected to exceed the daily quota; if so it warns and switches to incremental syncs, reusing the members of unchanged groups, and halves the concurrency to spread the calls; the concurrency is restored once the projection fits the quota again
+func (q *quotaTracker) adapt(interval time.Duration, now time.Time) {
+	if q == nil || q.dailyQuota <= 0 {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.prune(now)
+	projected := q.projectedDailyCalls(interval)
+	exceeds := projected > int64(q.dailyQuota)
+
+	if exceeds && !q.reduced {
+		log.Warn().Msgf("Syncing every %v is projected to take %v directory api calls a day, exceeding --gsuite-daily-quota of %v; halving --gsuite-concurrency and reusing the members of unchanged groups", interval, projected, q.dailyQuota)
+		if gsuiteMemberCache == nil {
+			gsuiteMemberCache = newMemberCache(quotaWindow)
+		}
+	}
+	if !exceeds && q.reduced {
+		log.Info().Msgf("Syncing every %v is projected to take %v directory api calls a day, within --gsuite-daily-quota of %v; restoring --gsuite-concurrency", interval, projected, q.dailyQuota)
+	}
+
+	q.reduced = exceeds
+}
+
+// concurrency returns the configured concurrency, or half of it while the daily quota is projected to be exceeded
+func (q *quotaTracker) concurrency(configured int) int {
+	if q == nil {
+		return configured
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.reduced && configured > 1 {
+		return configured / 2
+	}
+
+	return configured
+}
+
+// prune drops the syncs that finished longer ago than the quota window
+func (q *quotaTracker) prune(now time.Time) {
+	i := 0
+	for i < len(q.runs) && now.Sub(q.runs[i].at) >= quotaWindow {
+		i++
+	}
+	q.runs = q.runs[i:]
+}
+
+// used returns the directory api calls of the syncs in the quota window
+func (q *quotaTracker) used() (calls int64) {
+	for _, r := range q.runs {
+		calls += r.calls
+	}
+
+	return
+}
+
+// remaining returns the estimated calls left of the daily quota, or -1 without a daily quota
+func (q *quotaTracker) remaining() int {
+	if q.dailyQuota <= 0 {
+		return -1
+	}
+
+	remaining := q.dailyQuota - int(q.used())
+	if remaining < 0 {
+		return 0
+	}
+
+	return remaining
+}
+
+// quotaCountingTransport counts the directory api requests, including retries, since every one of them counts towards the quota
+type quotaCountingTransport struct {
+	quota *quotaTracker
+	next  http.RoundTripper
+}
+
+func (t *quotaCountingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if strings.Contains(request.URL.Path, "/admin/directory/") {
+		atomic.AddInt64(&t.quota.calls, 1)
+	}
+
+	return t.next.RoundTrip(request)
+}
+
+// recordQuotaUsage sets the directory api calls of the run and the estimated calls left of the daily quota, warning if another sync like it would exceed the quota
+func recordQuotaUsage(run *SyncRun) {
+	if gsuiteQuota == nil {
+		return
+	}
+
+	calls, remaining := gsuiteQuota.finishRun(time.Now())
+	run.GsuiteAPICalls = calls
+	if remaining < 0 {
+		log.Info().Msgf("Used %v directory api calls", calls)
+		return
+	}
+
+	run.GsuiteQuotaRemaining = &remaining
+	log.Info().Msgf("Used %v directory api calls, an estimated %v of --gsuite-daily-quota of %v are left", calls, remaining, gsuiteQuota.dailyQuota)
+	if calls > int64(remaining) {
+		log.Warn().Msgf("Another full sync taking %v directory api calls would exceed the estimated %v calls left of --gsuite-daily-quota", calls, remaining)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestQuotaTracker(t *testing.T) {
+	t.Run("CountsDirectoryAPICallsOfTheClient", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Id: "1234", Email: "john@example.com"})
+		quota := newQuotaTracker(1000)
+		ctx := context.Background()
+		client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, Quota: quota, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+		_, err = client.GetGroupsWithMembers(ctx)
+		assert.Nil(t, err)
+
+		// act
+		calls, remaining := quota.finishRun(time.Now())
+
+		assert.Equal(t, int64(1+directoryAPI.memberLists), calls)
+		assert.Equal(t, 1000-int(calls), remaining)
+	})
+
+	t.Run("ReturnsNoRemainingCallsWithoutDailyQuota", func(t *testing.T) {
+
+		quota := newQuotaTracker(0)
+		quota.calls = 50
+
+		// act
+		calls, remaining := quota.finishRun(time.Now())
+
+		assert.Equal(t, int64(50), calls)
+		assert.Equal(t, -1, remaining)
+	})
+
+	t.Run("OnlyCountsCallsOfTheLastDayTowardsTheQuota", func(t *testing.T) {
+
+		now := time.Now()
+		quota := newQuotaTracker(1000)
+		quota.calls = 600
+		quota.finishRun(now.Add(-25 * time.Hour))
+		quota.calls = 300
+
+		// act
+		_, remaining := quota.finishRun(now)
+
+		assert.Equal(t, 700, remaining)
+	})
+
+	t.Run("HalvesConcurrencyWhileSyncsAreProjectedToExceedTheQuota", func(t *testing.T) {
+
+		defer func(cache *memberCache) { gsuiteMemberCache = cache }(gsuiteMemberCache)
+		gsuiteMemberCache = nil
+		now := time.Now()
+		quota := newQuotaTracker(1000)
+		quota.calls = 100
+		quota.finishRun(now)
+
+		// act
+		quota.adapt(time.Hour, now)
+		reduced := quota.concurrency(10)
+		quota.adapt(4*time.Hour, now)
+		restored := quota.concurrency(10)
+
+		assert.Equal(t, 5, reduced)
+		assert.Equal(t, 10, restored)
+		assert.NotNil(t, gsuiteMemberCache)
+	})
+
+	t.Run("IsNilSafe", func(t *testing.T) {
+
+		var quota *quotaTracker
+
+		// act
+		calls, remaining := quota.finishRun(time.Now())
+		quota.adapt(time.Hour, time.Now())
+
+		assert.Equal(t, int64(0), calls)
+		assert.Equal(t, -1, remaining)
+		assert.Equal(t, 10, quota.concurrency(10))
+	})
+}
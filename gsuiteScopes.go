@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	admin "google.golang.org/api/admin/directory/v1"
+	groupssettings "google.golang.org/api/groupssettings/v1"
+)
+
+// gsuiteFeatures are the enabled features that determine which scopes the service account's domain-wide delegation has to be granted
+type gsuiteFeatures struct {
+	// syncUsers is set if directory users are fetched, for user profiles, attributes, the admins aggregate group or verifying member emails
+	syncUsers bool
+	// syncGroupSettings is set if the access settings of groups are fetched
+	syncGroupSettings bool
+	// syncMembershipExpiry is set if the expirations of time-bound memberships are fetched from the cloud identity api
+	syncMembershipExpiry bool
+	// syncDynamicGroups is set if the queries of dynamic groups are fetched from the cloud identity api
+	syncDynamicGroups bool
+	// syncAdminRoles is set if the admin role assignments of users are fetched
+	syncAdminRoles bool
+}
+
+// gsuiteScope is a scope requested for domain-wide delegation, with the feature that needs it
+type gsuiteScope struct {
+	scope   string
+	feature string
+}
+
+// requiredScopes returns the scopes for the enabled features, so admins only have to delegate what the syncer uses; groups and members are read with the groups scope alone
+func (f gsuiteFeatures) requiredScopes() []gsuiteScope {
+	scopes := []gsuiteScope{{scope: admin.AdminDirectoryGroupReadonlyScope, feature: "groups and members"}}
+	if f.syncUsers {
+		scopes = append(scopes, gsuiteScope{scope: admin.AdminDirectoryUserReadonlyScope, feature: "user profiles, attributes, the admins aggregate group, --verify-member-emails and --sync-all-domain-users"})
+	}
+	if f.syncAdminRoles {
+		scopes = append(scopes, gsuiteScope{scope: admin.AdminDirectoryRolemanagementReadonlyScope, feature: "--gsuite-sync-admin-roles"})
+	}
+	if f.syncGroupSettings {
+		scopes = append(scopes, gsuiteScope{scope: groupssettings.AppsGroupsSettingsScope, feature: "--gsuite-sync-group-settings"})
+	}
+	if f.syncMembershipExpiry || f.syncDynamicGroups {
+		scopes = append(scopes, gsuiteScope{scope: cloudIdentityGroupsScope, feature: "--gsuite-sync-membership-expiry and --gsuite-sync-dynamic-groups"})
+	}
+
+	return scopes
+}
+
+// gsuiteScopes returns the scopes to request for the enabled features
+func gsuiteScopes(features gsuiteFeatures) []string {
+	scopes := make([]string, 0)
+	for _, s := range features.requiredScopes() {
+		scopes = append(scopes, s.scope)
+	}
+
+	return scopes
+}
+
+// describeGsuiteScopes returns the scopes to request with the feature needing each, for logging at startup
+func describeGsuiteScopes(features gsuiteFeatures) []string {
+	descriptions := make([]string, 0)
+	for _, s := range features.requiredScopes() {
+		descriptions = append(descriptions, fmt.Sprintf("%v for %v", s.scope, s.feature))
+	}
+
+	return descriptions
+}
+
+// gsuiteFeaturesFromFlags returns the gsuite features enabled with the flags
+func gsuiteFeaturesFromFlags() gsuiteFeatures {
+	return gsuiteFeatures{
+		syncUsers:            directoryUsersNeeded(),
+		syncGroupSettings:    *gsuiteSyncGroupSettings,
+		syncMembershipExpiry: *gsuiteSyncMembershipExpiry,
+		syncDynamicGroups:    *gsuiteSyncDynamicGroups,
+		syncAdminRoles:       *gsuiteSyncAdminRoles,
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+	groupssettings "google.golang.org/api/groupssettings/v1"
+)
+
+func TestGsuiteScopes(t *testing.T) {
+	t.Run("RequestsOnlyGroupsScopeIfOnlyGroupsAreSynced", func(t *testing.T) {
+
+		// act
+		scopes := gsuiteScopes(gsuiteFeatures{})
+
+		assert.Equal(t, []string{admin.AdminDirectoryGroupReadonlyScope}, scopes)
+	})
+
+	t.Run("RequestsScopesOfEnabledFeatures", func(t *testing.T) {
+
+		// act
+		scopes := gsuiteScopes(gsuiteFeatures{syncUsers: true, syncGroupSettings: true})
+
+		assert.Equal(t, []string{admin.AdminDirectoryGroupReadonlyScope, admin.AdminDirectoryUserReadonlyScope, groupssettings.AppsGroupsSettingsScope}, scopes)
+	})
+}
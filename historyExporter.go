@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	bigquery "google.golang.org/api/bigquery/v2"
+)
+
+// SyncRun summarizes a single synchronization run
+type SyncRun struct {
+	// ID is set on every log line, span, api request and audit entry of the run
+	ID         string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Provider   string
+	// Tenant is the estafette environment the run synced to, see tenantName
+	Tenant           string
+	DirectoryGroups  int
+	DirectoryMembers int
+	Groups           int
+	Users            int
+	Actions          []*Action
+	// NameConflicts are the estafette group names claimed by multiple directory groups; they're not detected in streaming mode
+	NameConflicts []*NameConflict
+	// PolicyViolations are the violations of the configured policies, including the ones that failed the run
+	PolicyViolations []*PolicyViolation
+	// NextMembershipExpiry is the earliest expiration of a time-bound membership that's still active, if any
+	NextMembershipExpiry *time.Time
+	// Anomalies are the directory counts that dropped beyond --anomaly-threshold since the previous run
+	Anomalies []*StatsAnomaly
+	// DeadLetters are the directory groups whose members couldn't be listed, whose estafette groups were left as they are; in streaming mode such a group fails the run instead
+	DeadLetters []*DeadLetter
+	// UnprovisionedUsers are the emails of the directory users without an estafette user, if --sync-all-domain-users is set
+	UnprovisionedUsers []string
+	// GsuiteAPICalls are the directory api calls of the run, counted with the gsuite provider
+	GsuiteAPICalls int64
+	// GsuiteQuotaRemaining is the estimated number of directory api calls left of --gsuite-daily-quota after the run, if set
+	GsuiteQuotaRemaining *int
+	Err                  error
+}
+
+type HistoryExporter interface {
+	ExportRun(ctx context.Context, run *SyncRun) (err error)
+}
+
+// NewHistoryExporter returns a HistoryExporter appending one row per run to the runs table and one row per action to the actions table
+func NewHistoryExporter(bigQueryClient BigQueryClient, runsTable, actionsTable string) HistoryExporter {
+	return &historyExporter{
+		bigQueryClient: bigQueryClient,
+		runsTable:      runsTable,
+		actionsTable:   actionsTable,
+	}
+}
+
+type historyExporter struct {
+	bigQueryClient BigQueryClient
+	runsTable      string
+	actionsTable   string
+}
+
+func (e *historyExporter) ExportRun(ctx context.Context, run *SyncRun) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "HistoryExporter::ExportRun")
+	defer span.Finish()
+
+	failedActions := 0
+	actionRows := make([]map[string]bigquery.JsonValue, 0, len(run.Actions))
+	for _, a := range run.Actions {
+		actionError := ""
+		if a.Err != nil {
+			actionError = a.Err.Error()
+			failedActions++
+		}
+
+		actionRows = append(actionRows, map[string]bigquery.JsonValue{
+			"runID":       run.ID,
+			"startedAt":   run.StartedAt.Format(time.RFC3339Nano),
+			"provider":    run.Provider,
+			"tenant":      run.Tenant,
+			"type":        string(a.Type),
+			"entityID":    a.entityID(),
+			"description": a.String(),
+			"error":       actionError,
+		})
+	}
+
+	runError := ""
+	if run.Err != nil {
+		runError = run.Err.Error()
+	}
+
+	err = e.bigQueryClient.InsertRows(ctx, e.runsTable, []map[string]bigquery.JsonValue{
+		{
+			"runID":            run.ID,
+			"startedAt":        run.StartedAt.Format(time.RFC3339Nano),
+			"finishedAt":       run.FinishedAt.Format(time.RFC3339Nano),
+			"durationSeconds":  run.FinishedAt.Sub(run.StartedAt).Seconds(),
+			"provider":         run.Provider,
+			"tenant":           run.Tenant,
+			"directoryGroups":  run.DirectoryGroups,
+			"directoryMembers": run.DirectoryMembers,
+			"groups":           run.Groups,
+			"users":            run.Users,
+			"actions":          len(run.Actions),
+			"failedActions":    failedActions,
+			"error":            runError,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed exporting run: %w", err)
+	}
+
+	return e.bigQueryClient.InsertRows(ctx, e.actionsTable, actionRows)
+}
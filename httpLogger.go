@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// maxLoggedBodyLength limits the logged request and response bodies, so a page of directory members doesn't flood the logs
+const maxLoggedBodyLength = 4096
+
+const redacted = "[REDACTED]"
+
+// redactedHeaders are the headers whose values are never logged
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// secretKeyPattern matches json fields, form fields and query parameters holding credentials
+var secretKeyPattern = regexp.MustCompile(`(?i)(secret|password|assertion|private_?key|credential|^key$|^token$|access_?token|refresh_?token|id_?token)`)
+
+// httpLogger logs every http request to the directory and estafette apis with its status and duration and optionally the bodies, with credentials redacted, to debug failed syncs
+type httpLogger struct {
+	bodies bool
+}
+
+// newHTTPLogger returns an httpLogger, or nil if disabled so requests aren't logged
+func newHTTPLogger(enabled, bodies bool) *httpLogger {
+	if !enabled {
+		return nil
+	}
+
+	return &httpLogger{bodies: bodies}
+}
+
+// wrap returns a transport logging requests sent through the next transport; it returns next as is for a nil httpLogger
+func (l *httpLogger) wrap(next http.RoundTripper) http.RoundTripper {
+	if l == nil {
+		return next
+	}
+	if next == nil {
+		next = sharedRoundTripper()
+	}
+
+	return &loggingTransport{logger: l, next: next}
+}
+
+type loggingTransport struct {
+	logger *httpLogger
+	next   http.RoundTripper
+}
+
+func (t *loggingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+
+	var requestBody []byte
+	if t.logger.bodies && request.Body != nil {
+		var err error
+		requestBody, err = ioutil.ReadAll(request.Body)
+		request.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// round trippers shouldn't modify the request, so the body is replaced on a copy
+		request = request.Clone(request.Context())
+		request.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	start := time.Now()
+	response, err := t.next.RoundTrip(request)
+	duration := time.Since(start)
+
+	event := log.Info().
+		Str("method", request.Method).
+		Str("url", redactURL(request.URL)).
+		Dict("requestHeaders", redactHeaders(request.Header)).
+		Dur("duration", duration)
+
+	if t.logger.bodies && len(requestBody) > 0 {
+		event = event.Str("requestBody", redactBody(requestBody, request.Header.Get("Content-Type")))
+	}
+
+	if err != nil {
+		event.Err(err).Msgf("HTTP %v %v failed", request.Method, request.URL.Host)
+		return response, err
+	}
+
+	event = event.Int("status", response.StatusCode).Dict("responseHeaders", redactHeaders(response.Header))
+
+	if t.logger.bodies && response.Body != nil {
+		responseBody, readErr := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if readErr != nil {
+			event.Err(readErr).Msgf("HTTP %v %v returned %v", request.Method, request.URL.Host, response.StatusCode)
+			return nil, readErr
+		}
+		response.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+		event = event.Str("responseBody", redactBody(responseBody, response.Header.Get("Content-Type")))
+	}
+
+	event.Msgf("HTTP %v %v returned %v", request.Method, request.URL.Host, response.StatusCode)
+
+	return response, nil
+}
+
+// redactHeaders returns the headers for logging, with the values of credential headers replaced
+func redactHeaders(headers http.Header) *zerolog.Event {
+	dict := zerolog.Dict()
+	for name, values := range headers {
+		value := strings.Join(values, ", ")
+		for _, h := range redactedHeaders {
+			if strings.EqualFold(name, h) {
+				value = redacted
+			}
+		}
+		dict = dict.Str(name, value)
+	}
+
+	return dict
+}
+
+// redactURL returns the url for logging, with credentials in the user info and query parameters replaced
+func redactURL(u *url.URL) string {
+	redactedURL := *u
+	if redactedURL.User != nil {
+		redactedURL.User = url.User(redacted)
+	}
+
+	query := redactedURL.Query()
+	for key := range query {
+		if secretKeyPattern.MatchString(key) {
+			query.Set(key, redacted)
+		}
+	}
+	redactedURL.RawQuery = query.Encode()
+
+	return redactedURL.String()
+}
+
+// redactBody returns the json or form encoded body for logging with the values of credential fields replaced, truncated to maxLoggedBodyLength; other bodies are only truncated
+func redactBody(body []byte, contentType string) string {
+
+	redactedBody := string(body)
+
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		form, err := url.ParseQuery(string(body))
+		if err == nil {
+			for key := range form {
+				if secretKeyPattern.MatchString(key) {
+					form.Set(key, redacted)
+				}
+			}
+			redactedBody = form.Encode()
+		}
+
+	default:
+		var value interface{}
+		if json.Unmarshal(body, &value) == nil {
+			if data, err := json.Marshal(redactJSON(value)); err == nil {
+				redactedBody = string(data)
+			}
+		}
+	}
+
+	if len(redactedBody) > maxLoggedBodyLength {
+		return redactedBody[:maxLoggedBodyLength] + "..."
+	}
+
+	return redactedBody
+}
+
+// redactJSON replaces the values of credential fields in the json value in place and returns it
+func redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if secretKeyPattern.MatchString(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactJSON(nested)
+			}
+		}
+
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactJSON(nested)
+		}
+	}
+
+	return value
+}
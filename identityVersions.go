@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// identityVersion is the version of the format of the group identities the syncer writes; it's recorded in a marker identity next to them, so identities written by older versions can be told apart and migrated instead of no longer linking their estafette group to its directory group
+const identityVersion = 2
+
+// groupVersionProviderSuffix is appended to the provider name for the identity recording the identity version of a directory group, like gsuite-version
+const groupVersionProviderSuffix = "-version"
+
+// identityMigration upgrades the group identities written by the version before it
+type identityMigration struct {
+	version     int
+	description string
+	// legacyID returns the id the identities of the directory group had before this version, or an empty string if it didn't change
+	legacyID func(directoryGroup *DirectoryGroup) string
+}
+
+// identityMigrations are applied in order to identities written by older versions; unmarked identities were written before versions were recorded and are version 1
+var identityMigrations = []identityMigration{
+	{
+		version:     2,
+		description: "key identities by the immutable directory group id instead of the group email address, so renaming the group's address doesn't orphan its estafette group",
+		legacyID: func(directoryGroup *DirectoryGroup) string {
+			if directoryGroup.Email == "" || strings.EqualFold(directoryGroup.Email, directoryGroup.ID) {
+				return ""
+			}
+			return directoryGroup.Email
+		},
+	},
+}
+
+// groupVersionIdentity returns the marker identity recording that the identities of the directory group are of the current version
+func groupVersionIdentity(provider Provider, directoryGroup *DirectoryGroup) *contracts.GroupIdentity {
+	return &contracts.GroupIdentity{
+		Provider: provider.Name() + groupVersionProviderSuffix,
+		ID:       directoryGroup.ID,
+		Name:     strconv.Itoa(identityVersion),
+	}
+}
+
+// identityVersionOf returns the version of the identities with the id, as recorded in their marker identity, or 1 if they have none
+func identityVersionOf(group *contracts.Group, provider Provider, id string) int {
+	for _, i := range group.Identities {
+		if i.Provider == provider.Name()+groupVersionProviderSuffix && i.ID == id {
+			if version, err := strconv.Atoi(i.Name); err == nil {
+				return version
+			}
+		}
+	}
+
+	return 1
+}
+
+// legacyIdentityIDs returns the ids older versions wrote the identities of the directory group with, to look up the estafette groups not migrated yet
+func legacyIdentityIDs(directoryGroup *DirectoryGroup) (ids []string) {
+	for _, m := range identityMigrations {
+		if id := m.legacyID(directoryGroup); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return
+}
+
+// migrateGroupIdentities upgrades the identities of the provider written by older versions to the current version, rewriting their ids and the ids of the identities recording the settings, query and description of the directory group along with them, and marks them with the current version. Identities of directory groups that weren't fetched are left as they are, since their new id isn't known, and migrated on a later run
+func migrateGroupIdentities(group *contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) (changed bool) {
+	for _, i := range group.Identities {
+		if i.Provider != provider.Name() {
+			continue
+		}
+		version := identityVersionOf(group, provider, i.ID)
+		if version >= identityVersion {
+			continue
+		}
+
+		for gg := range groupMembers {
+			if gg.ID == i.ID {
+				// the id didn't change, so there's nothing to migrate
+				break
+			}
+
+			migrated := false
+			for _, m := range identityMigrations {
+				if m.version <= version {
+					continue
+				}
+				if legacyID := m.legacyID(gg); legacyID != "" && strings.EqualFold(legacyID, i.ID) {
+					renameGroupIdentities(group, provider, i.ID, gg.ID)
+					migrated = true
+				}
+			}
+			if migrated {
+				applyGroupIdentity(group, groupVersionIdentity(provider, gg))
+				changed = true
+				break
+			}
+		}
+	}
+
+	return
+}
+
+// renameGroupIdentities changes the id of the identity of the provider and the identities recording its metadata from oldID to newID
+func renameGroupIdentities(group *contracts.Group, provider Provider, oldID, newID string) {
+	for _, i := range group.Identities {
+		if i.ID == oldID && (i.Provider == provider.Name() || strings.HasPrefix(i.Provider, provider.Name()+"-")) {
+			i.ID = newID
+		}
+	}
+}
+
+// migrateGroups returns the groups with their identities migrated to the current version, see migrateGroupIdentities, and the groups as they were before for the migrated ones
+func migrateGroups(groups []*contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) (migratedGroups []*contracts.Group, before map[*contracts.Group]*contracts.Group) {
+	migratedGroups = make([]*contracts.Group, 0, len(groups))
+	before = map[*contracts.Group]*contracts.Group{}
+
+	for _, g := range groups {
+		migratedGroup := copyGroup(g)
+		if migrateGroupIdentities(migratedGroup, provider, groupMembers) {
+			migratedGroups = append(migratedGroups, migratedGroup)
+			before[migratedGroup] = g
+			continue
+		}
+		migratedGroups = append(migratedGroups, g)
+	}
+
+	return
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateGroupIdentities(t *testing.T) {
+	t.Run("RekeysIdentitiesWrittenWithTheGroupEmailAlongWithTheirMetadata", func(t *testing.T) {
+
+		group := &contracts.Group{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{
+			{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"},
+			{Provider: "gsuite-settings", ID: "ci-platform@example.com", Name: "allowExternalMembers=false,whoCanJoin=INVITED_CAN_JOIN"},
+		}}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "01x0gk37", Name: "ci-platform", Email: "ci-platform@example.com"}: {},
+		}
+
+		// act
+		changed := migrateGroupIdentities(group, &gsuiteClient{}, groupMembers)
+
+		assert.True(t, changed)
+		assert.Equal(t, []*contracts.GroupIdentity{
+			{Provider: gsuiteProviderName, ID: "01x0gk37", Name: "ci-platform"},
+			{Provider: "gsuite-settings", ID: "01x0gk37", Name: "allowExternalMembers=false,whoCanJoin=INVITED_CAN_JOIN"},
+			{Provider: "gsuite-version", ID: "01x0gk37", Name: "2"},
+		}, group.Identities)
+	})
+
+	t.Run("LeavesIdentitiesOfGroupsThatWerentFetched", func(t *testing.T) {
+
+		group := &contracts.Group{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "01x0gk38", Name: "ci-release", Email: "ci-release@example.com"}: {},
+		}
+
+		// act
+		changed := migrateGroupIdentities(group, &gsuiteClient{}, groupMembers)
+
+		assert.False(t, changed)
+		assert.Equal(t, "ci-platform@example.com", group.Identities[0].ID)
+	})
+
+	t.Run("LeavesIdentitiesOfTheCurrentVersion", func(t *testing.T) {
+
+		group := &contracts.Group{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "01x0gk37", Name: "ci-platform"}}}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "01x0gk37", Name: "ci-platform", Email: "ci-platform@example.com"}: {},
+		}
+
+		// act
+		changed := migrateGroupIdentities(group, &gsuiteClient{}, groupMembers)
+
+		assert.False(t, changed)
+		assert.Equal(t, 1, len(group.Identities))
+	})
+}
+
+func TestPlanGroupsAndMembersWithLegacyIdentities(t *testing.T) {
+	t.Run("UpdatesMigratedGroupInsteadOfCreatingAnother", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "01x0gk37", Name: "ci-platform", Email: "ci-platform@example.com"}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, "ci-platform@example.com", actions[0].GroupBefore.Identities[0].ID)
+			assert.Equal(t, "01x0gk37", actions[0].Group.Identities[0].ID)
+		}
+	})
+}
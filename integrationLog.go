@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+)
+
+// maxIntegrationLogChanges limits the changes in an integration log, so a large initial sync doesn't exceed the api's request size limits
+const maxIntegrationLogChanges = 500
+
+// IntegrationLog summarizes a sync run for the estafette api, so admins can see when the last sync happened and what changed from the estafette ui
+type IntegrationLog struct {
+	Integration      string    `json:"integration"`
+	RunID            string    `json:"runID,omitempty"`
+	Provider         string    `json:"provider"`
+	Tenant           string    `json:"tenant,omitempty"`
+	TriggeredBy      string    `json:"triggeredBy,omitempty"`
+	StartedAt        time.Time `json:"startedAt"`
+	FinishedAt       time.Time `json:"finishedAt"`
+	Status           string    `json:"status"`
+	Error            string    `json:"error,omitempty"`
+	DirectoryGroups  int       `json:"directoryGroups"`
+	DirectoryMembers int       `json:"directoryMembers"`
+	Groups           int       `json:"groups"`
+	Users            int       `json:"users"`
+	FailedChanges    int       `json:"failedChanges"`
+
+	Changes []*IntegrationLogChange `json:"changes"`
+	// OmittedChanges counts the changes left out beyond maxIntegrationLogChanges
+	OmittedChanges int `json:"omittedChanges,omitempty"`
+
+	NameConflicts    []*NameConflict    `json:"nameConflicts,omitempty"`
+	PolicyViolations []*PolicyViolation `json:"policyViolations,omitempty"`
+}
+
+// IntegrationLogChange is a single change applied by a sync run
+type IntegrationLogChange struct {
+	Type        ActionType `json:"type"`
+	EntityID    string     `json:"entityID,omitempty"`
+	Description string     `json:"description"`
+	Error       string     `json:"error,omitempty"`
+}
+
+const (
+	integrationLogStatusSucceeded = "succeeded"
+	integrationLogStatusFailed    = "failed"
+)
+
+// newIntegrationLog returns the integration log for the run
+func newIntegrationLog(run *SyncRun, triggeredBy string) *IntegrationLog {
+
+	integrationLog := &IntegrationLog{
+		Integration:      app,
+		RunID:            run.ID,
+		Provider:         run.Provider,
+		Tenant:           run.Tenant,
+		TriggeredBy:      triggeredBy,
+		StartedAt:        run.StartedAt,
+		FinishedAt:       run.FinishedAt,
+		Status:           integrationLogStatusSucceeded,
+		DirectoryGroups:  run.DirectoryGroups,
+		DirectoryMembers: run.DirectoryMembers,
+		Groups:           run.Groups,
+		Users:            run.Users,
+		Changes:          make([]*IntegrationLogChange, 0, len(run.Actions)),
+		NameConflicts:    run.NameConflicts,
+		PolicyViolations: run.PolicyViolations,
+	}
+
+	if run.Err != nil {
+		integrationLog.Status = integrationLogStatusFailed
+		integrationLog.Error = run.Err.Error()
+	}
+
+	for _, a := range run.Actions {
+		change := &IntegrationLogChange{
+			Type:        a.Type,
+			EntityID:    a.entityID(),
+			Description: a.String(),
+		}
+		if a.Err != nil {
+			change.Error = a.Err.Error()
+			integrationLog.FailedChanges++
+		}
+
+		if len(integrationLog.Changes) >= maxIntegrationLogChanges {
+			integrationLog.OmittedChanges++
+			continue
+		}
+		integrationLog.Changes = append(integrationLog.Changes, change)
+	}
+
+	return integrationLog
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewIntegrationLog(t *testing.T) {
+	t.Run("ReturnsFailedLogWithFailedChanges", func(t *testing.T) {
+
+		run := &SyncRun{
+			Provider: gsuiteProviderName,
+			Actions: []*Action{
+				{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}},
+				{Type: ActionUpdateGroup, GroupBefore: &contracts.Group{ID: "g1", Name: "release"}, Group: &contracts.Group{ID: "g1", Name: "releases"}, Err: errors.New("conflict")},
+			},
+			Err: errors.New("Failed applying 1 action"),
+		}
+
+		// act
+		integrationLog := newIntegrationLog(run, "test")
+
+		assert.Equal(t, integrationLogStatusFailed, integrationLog.Status)
+		assert.Equal(t, "Failed applying 1 action", integrationLog.Error)
+		assert.Equal(t, 1, integrationLog.FailedChanges)
+		if assert.Equal(t, 2, len(integrationLog.Changes)) {
+			assert.Equal(t, "", integrationLog.Changes[0].Error)
+			assert.Equal(t, "g1", integrationLog.Changes[1].EntityID)
+			assert.Equal(t, "rename group release to releases", integrationLog.Changes[1].Description)
+			assert.Equal(t, "conflict", integrationLog.Changes[1].Error)
+		}
+	})
+
+	t.Run("OmitsChangesBeyondLimit", func(t *testing.T) {
+
+		run := &SyncRun{Provider: gsuiteProviderName}
+		for i := 0; i < maxIntegrationLogChanges+10; i++ {
+			run.Actions = append(run.Actions, &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: fmt.Sprintf("group-%v", i)}})
+		}
+
+		// act
+		integrationLog := newIntegrationLog(run, "test")
+
+		assert.Equal(t, integrationLogStatusSucceeded, integrationLog.Status)
+		assert.Equal(t, maxIntegrationLogChanges, len(integrationLog.Changes))
+		assert.Equal(t, 10, integrationLog.OmittedChanges)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/opentracing-contrib/go-stdlib/nethttp"
+)
+
+// serviceAccountDir holds the token, ca certificate and namespace kubernetes mounts in every pod
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesClient requests the kubernetes api as the service account of the pod; client-go doesn't build with the go version and dependencies of this module, and the syncer only needs plain json requests for a few objects
+type KubernetesClient interface {
+	// Namespace returns the namespace the client works in
+	Namespace() string
+	// Request sends the request object as json to the path and decodes the json response into the response object unless it's nil; it returns the status code of the response, with an error if it's not a 2xx one
+	Request(ctx context.Context, method, path string, requestObject, responseObject interface{}) (statusCode int, err error)
+}
+
+// NewKubernetesClient returns a KubernetesClient talking to the kubernetes api at apiURL with the token returned by token
+func NewKubernetesClient(apiURL string, token func() (string, error), namespace string, client *http.Client) KubernetesClient {
+	return &kubernetesClient{
+		apiURL:    strings.TrimSuffix(apiURL, "/"),
+		token:     token,
+		namespace: namespace,
+		client:    client,
+	}
+}
+
+// newInClusterKubernetesClient returns a KubernetesClient using the service account of the pod the syncer runs in, trusting the cluster ca
+func newInClusterKubernetesClient() (KubernetesClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("Not running inside kubernetes, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	namespace, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading namespace of the pod: %w", err)
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading kubernetes ca certificate: %w", err)
+	}
+	rootCAs := x509.NewCertPool()
+	if !rootCAs.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("Failed parsing kubernetes ca certificate")
+	}
+
+	// bound service account tokens get rotated, so the token is read for every request
+	token := func() (string, error) {
+		data, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		return strings.TrimSpace(string(data)), err
+	}
+
+	client := &http.Client{
+		Transport: &nethttp.Transport{RoundTripper: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: rootCAs}}},
+		Timeout:   10 * time.Second,
+	}
+
+	return NewKubernetesClient("https://"+net.JoinHostPort(host, port), token, strings.TrimSpace(string(namespace)), client), nil
+}
+
+type kubernetesClient struct {
+	apiURL    string
+	token     func() (string, error)
+	namespace string
+	client    *http.Client
+}
+
+func (c *kubernetesClient) Namespace() string {
+	return c.namespace
+}
+
+func (c *kubernetesClient) Request(ctx context.Context, method, path string, requestObject, responseObject interface{}) (statusCode int, err error) {
+
+	var body []byte
+	if requestObject != nil {
+		body, err = json.Marshal(requestObject)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	token, err := c.token()
+	if err != nil {
+		return 0, fmt.Errorf("Failed reading kubernetes service account token: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("Failed requesting %v %v: %w", method, path, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("Failed requesting %v %v, status code %v", method, path, response.StatusCode)
+	}
+
+	if responseObject == nil {
+		return response.StatusCode, nil
+	}
+
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(responseObject)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetesClient(t *testing.T) {
+	newFakeKubernetesAPI := func(tokens *[]string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*tokens = append(*tokens, r.Header.Get("Authorization"))
+			switch r.URL.Path {
+			case "/api/v1/namespaces/estafette/pods/syncer":
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"metadata":{"name":"syncer","uid":"1234"}}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	}
+
+	t.Run("DecodesResponseAndReadsTokenForEveryRequest", func(t *testing.T) {
+
+		var tokens []string
+		server := newFakeKubernetesAPI(&tokens)
+		defer server.Close()
+		reads := 0
+		token := func() (string, error) {
+			reads++
+			return fmt.Sprintf("token-%v", reads), nil
+		}
+		client := NewKubernetesClient(server.URL+"/", token, "estafette", server.Client())
+		var first, second kubernetesObject
+
+		// act
+		_, err := client.Request(context.Background(), http.MethodGet, "/api/v1/namespaces/estafette/pods/syncer", nil, &first)
+		assert.Nil(t, err)
+		statusCode, err := client.Request(context.Background(), http.MethodGet, "/api/v1/namespaces/estafette/pods/syncer", nil, &second)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, statusCode)
+		assert.Equal(t, "1234", second.Metadata.UID)
+		assert.Equal(t, []string{"Bearer token-1", "Bearer token-2"}, tokens)
+		assert.Equal(t, "estafette", client.Namespace())
+	})
+
+	t.Run("ReturnsStatusCodeWithErrorForUnsuccessfulResponse", func(t *testing.T) {
+
+		var tokens []string
+		server := newFakeKubernetesAPI(&tokens)
+		defer server.Close()
+		client := NewKubernetesClient(server.URL, func() (string, error) { return "fake-token", nil }, "estafette", server.Client())
+
+		// act
+		statusCode, err := client.Request(context.Background(), http.MethodGet, "/api/v1/namespaces/estafette/configmaps/state", nil, nil)
+
+		assert.Equal(t, http.StatusNotFound, statusCode)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "status code 404")
+		}
+	})
+
+	t.Run("ReturnsErrorIfTokenCantBeRead", func(t *testing.T) {
+
+		var tokens []string
+		server := newFakeKubernetesAPI(&tokens)
+		defer server.Close()
+		client := NewKubernetesClient(server.URL, func() (string, error) { return "", errors.New("no such file") }, "estafette", server.Client())
+
+		// act
+		_, err := client.Request(context.Background(), http.MethodGet, "/api/v1/namespaces/estafette/pods/syncer", nil, nil)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(tokens))
+	})
+
+	t.Run("ReturnsErrorOutsideKubernetes", func(t *testing.T) {
+
+		if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+			t.Skip("running inside kubernetes")
+		}
+
+		// act
+		_, err := newInClusterKubernetesClient()
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// eventComponent is the component events are reported by, regardless of the app name set at build time
+const eventComponent = "estafette-ci-gsuite-syncer"
+
+const (
+	eventReasonSyncSucceeded  = "SyncSucceeded"
+	eventReasonSyncFailed     = "SyncFailed"
+	eventReasonLargeChangeSet = "LargeChangeSet"
+
+	eventTypeNormal  = "Normal"
+	eventTypeWarning = "Warning"
+)
+
+// KubernetesEventRecorder reports the outcome of sync runs as kubernetes events on the job or deployment running the syncer, so kubectl describe and cluster alerting show its health without external monitoring
+type KubernetesEventRecorder interface {
+	RecordRun(ctx context.Context, run *SyncRun) (err error)
+}
+
+// NewKubernetesEventRecorder returns a KubernetesEventRecorder for the pod, posting events with the kubernetes client; runs applying at least largeChangeSet changes get a warning event as well, unless it's zero
+func NewKubernetesEventRecorder(client KubernetesClient, podName string, largeChangeSet int) KubernetesEventRecorder {
+	return &kubernetesEventRecorder{
+		client:         client,
+		podName:        podName,
+		largeChangeSet: largeChangeSet,
+	}
+}
+
+// newInClusterEventRecorder returns a KubernetesEventRecorder using the service account of the pod the syncer runs in
+func newInClusterEventRecorder(largeChangeSet int) (KubernetesEventRecorder, error) {
+	client, err := newInClusterKubernetesClient()
+	if err != nil {
+		return nil, err
+	}
+
+	// the pod name is the host name, unless the pod spec overrides it
+	podName, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving pod name: %w", err)
+	}
+
+	return NewKubernetesEventRecorder(client, podName, largeChangeSet), nil
+}
+
+type kubernetesEventRecorder struct {
+	client         KubernetesClient
+	podName        string
+	largeChangeSet int
+}
+
+// kubernetesObject holds the fields of kubernetes objects the recorder uses
+type kubernetesObject struct {
+	APIVersion string               `json:"apiVersion,omitempty"`
+	Kind       string               `json:"kind,omitempty"`
+	Metadata   kubernetesObjectMeta `json:"metadata"`
+}
+
+type kubernetesObjectMeta struct {
+	Name            string                     `json:"name,omitempty"`
+	GenerateName    string                     `json:"generateName,omitempty"`
+	Namespace       string                     `json:"namespace,omitempty"`
+	UID             string                     `json:"uid,omitempty"`
+	OwnerReferences []kubernetesOwnerReference `json:"ownerReferences,omitempty"`
+}
+
+type kubernetesOwnerReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+	Controller *bool  `json:"controller,omitempty"`
+}
+
+type kubernetesObjectReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	UID        string `json:"uid"`
+}
+
+type kubernetesEventSource struct {
+	Component string `json:"component"`
+	Host      string `json:"host,omitempty"`
+}
+
+// kubernetesEvent is a core/v1 event
+type kubernetesEvent struct {
+	kubernetesObject
+	InvolvedObject     kubernetesObjectReference `json:"involvedObject"`
+	Reason             string                    `json:"reason"`
+	Message            string                    `json:"message"`
+	Type               string                    `json:"type"`
+	Source             kubernetesEventSource     `json:"source"`
+	FirstTimestamp     time.Time                 `json:"firstTimestamp"`
+	LastTimestamp      time.Time                 `json:"lastTimestamp"`
+	Count              int                       `json:"count"`
+	ReportingComponent string                    `json:"reportingComponent"`
+	ReportingInstance  string                    `json:"reportingInstance"`
+}
+
+func (r *kubernetesEventRecorder) RecordRun(ctx context.Context, run *SyncRun) (err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "KubernetesEventRecorder::RecordRun")
+	defer span.Finish()
+
+	owner, err := r.getOwner(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed retrieving the object owning pod %v: %w", r.podName, err)
+	}
+
+	applied := countAppliedActions(run.Actions)
+
+	if run.Err != nil {
+		err = r.postEvent(ctx, owner, eventTypeWarning, eventReasonSyncFailed, fmt.Sprintf("Sync run %v failed after applying %v of %v changes: %v", run.ID, applied, len(run.Actions), run.Err))
+	} else {
+		err = r.postEvent(ctx, owner, eventTypeNormal, eventReasonSyncSucceeded, fmt.Sprintf("Sync run %v applied %v changes for %v %v groups with %v members", run.ID, applied, run.DirectoryGroups, run.Provider, run.DirectoryMembers))
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.largeChangeSet > 0 && applied >= r.largeChangeSet {
+		return r.postEvent(ctx, owner, eventTypeWarning, eventReasonLargeChangeSet, fmt.Sprintf("Sync run %v applied %v changes, at least --kubernetes-events-large-change-set of %v", run.ID, applied, r.largeChangeSet))
+	}
+
+	return nil
+}
+
+// getOwner returns the job or deployment controlling the pod, or the pod itself if it runs on its own
+func (r *kubernetesEventRecorder) getOwner(ctx context.Context) (*kubernetesObjectReference, error) {
+
+	var pod kubernetesObject
+	_, err := r.client.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%v/pods/%v", r.client.Namespace(), r.podName), nil, &pod)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := controllerOf(pod)
+	if owner == nil {
+		return &kubernetesObjectReference{APIVersion: "v1", Kind: "Pod", Name: pod.Metadata.Name, Namespace: r.client.Namespace(), UID: pod.Metadata.UID}, nil
+	}
+
+	// pods of deployments are controlled by a replicaset in between
+	if owner.Kind == "ReplicaSet" {
+		var replicaSet kubernetesObject
+		_, err = r.client.Request(ctx, http.MethodGet, fmt.Sprintf("/apis/apps/v1/namespaces/%v/replicasets/%v", r.client.Namespace(), owner.Name), nil, &replicaSet)
+		if err != nil {
+			return nil, err
+		}
+		if deployment := controllerOf(replicaSet); deployment != nil {
+			owner = deployment
+		}
+	}
+
+	return &kubernetesObjectReference{APIVersion: owner.APIVersion, Kind: owner.Kind, Name: owner.Name, Namespace: r.client.Namespace(), UID: owner.UID}, nil
+}
+
+func (r *kubernetesEventRecorder) postEvent(ctx context.Context, involvedObject *kubernetesObjectReference, eventType, reason, message string) error {
+	now := time.Now().UTC()
+
+	event := kubernetesEvent{
+		kubernetesObject: kubernetesObject{
+			APIVersion: "v1",
+			Kind:       "Event",
+			Metadata:   kubernetesObjectMeta{GenerateName: involvedObject.Name + ".", Namespace: r.client.Namespace()},
+		},
+		InvolvedObject:     *involvedObject,
+		Reason:             reason,
+		Message:            message,
+		Type:               eventType,
+		Source:             kubernetesEventSource{Component: eventComponent, Host: r.podName},
+		FirstTimestamp:     now,
+		LastTimestamp:      now,
+		Count:              1,
+		ReportingComponent: eventComponent,
+		ReportingInstance:  r.podName,
+	}
+
+	_, err := r.client.Request(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%v/events", r.client.Namespace()), &event, nil)
+
+	return err
+}
+
+// controllerOf returns the owner reference of the object's controller, or nil if it has none
+func controllerOf(object kubernetesObject) *kubernetesOwnerReference {
+	for _, o := range object.Metadata.OwnerReferences {
+		if o.Controller != nil && *o.Controller {
+			owner := o
+			return &owner
+		}
+	}
+
+	return nil
+}
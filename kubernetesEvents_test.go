@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetesEventRecorder(t *testing.T) {
+	newFakeKubernetesAPI := func(events *[]*kubernetesEvent) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer fake-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			switch r.URL.Path {
+			case "/api/v1/namespaces/estafette/pods/syncer-7d9f-x2k4":
+				fmt.Fprint(w, `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"syncer-7d9f-x2k4","uid":"p1","ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"syncer-7d9f","uid":"rs1","controller":true}]}}`)
+			case "/apis/apps/v1/namespaces/estafette/replicasets/syncer-7d9f":
+				fmt.Fprint(w, `{"apiVersion":"apps/v1","kind":"ReplicaSet","metadata":{"name":"syncer-7d9f","uid":"rs1","ownerReferences":[{"apiVersion":"apps/v1","kind":"Deployment","name":"syncer","uid":"d1","controller":true}]}}`)
+			case "/api/v1/namespaces/estafette/events":
+				var event kubernetesEvent
+				json.NewDecoder(r.Body).Decode(&event)
+				*events = append(*events, &event)
+				w.WriteHeader(http.StatusCreated)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	}
+	token := func() (string, error) { return "fake-token", nil }
+
+	t.Run("RecordsSucceededRunOnOwningDeployment", func(t *testing.T) {
+
+		events := []*kubernetesEvent{}
+		server := newFakeKubernetesAPI(&events)
+		defer server.Close()
+
+		recorder := NewKubernetesEventRecorder(NewKubernetesClient(server.URL, token, "estafette", server.Client()), "syncer-7d9f-x2k4", 2)
+
+		// act
+		err := recorder.RecordRun(context.Background(), &SyncRun{ID: "run-1", Provider: gsuiteProviderName, Actions: []*Action{{Type: ActionCreateGroup}}})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(events)) {
+			assert.Equal(t, eventReasonSyncSucceeded, events[0].Reason)
+			assert.Equal(t, eventTypeNormal, events[0].Type)
+			assert.Equal(t, kubernetesObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "syncer", Namespace: "estafette", UID: "d1"}, events[0].InvolvedObject)
+		}
+	})
+
+	t.Run("RecordsFailedRunWithLargeChangeSetAsWarnings", func(t *testing.T) {
+
+		events := []*kubernetesEvent{}
+		server := newFakeKubernetesAPI(&events)
+		defer server.Close()
+
+		recorder := NewKubernetesEventRecorder(NewKubernetesClient(server.URL, token, "estafette", server.Client()), "syncer-7d9f-x2k4", 2)
+		run := &SyncRun{
+			ID:      "run-1",
+			Actions: []*Action{{Type: ActionCreateGroup}, {Type: ActionUpdateUser}, {Type: ActionUpdateUser, Err: errors.New("conflict")}},
+			Err:     errors.New("conflict"),
+		}
+
+		// act
+		err := recorder.RecordRun(context.Background(), run)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(events)) {
+			assert.Equal(t, eventReasonSyncFailed, events[0].Reason)
+			assert.Equal(t, "Sync run run-1 failed after applying 2 of 3 changes: conflict", events[0].Message)
+			assert.Equal(t, eventReasonLargeChangeSet, events[1].Reason)
+			assert.Equal(t, eventTypeWarning, events[1].Type)
+		}
+	})
+}
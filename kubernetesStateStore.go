@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// kubernetesDataKeyInvalidChars are the characters not allowed in the data keys of configmaps and secrets
+var kubernetesDataKeyInvalidChars = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// NewKubernetesStateStore returns a StateStore keeping every key in the data of the configmap or secret with the name, in the namespace of the kubernetes client; kind is either configmap or secret, and the object is created on the first write
+func NewKubernetesStateStore(client KubernetesClient, kind, name string) StateStore {
+	resource := "configmaps"
+	if kind == stateStoreSecret {
+		resource = "secrets"
+	}
+
+	return &kubernetesStateStore{
+		client:   client,
+		resource: resource,
+		name:     name,
+	}
+}
+
+type kubernetesStateStore struct {
+	client   KubernetesClient
+	resource string
+	name     string
+}
+
+func (s *kubernetesStateStore) Read(ctx context.Context, key string) (data []byte, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "KubernetesStateStore::Read")
+	defer span.Finish()
+
+	object, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := object.values()[kubernetesDataKey(key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v has no key %v", ErrStateNotFound, s.describe(), kubernetesDataKey(key))
+	}
+
+	return value, nil
+}
+
+// Write updates the key in the configmap or secret with the resource version it was read at, so concurrent writers can't overwrite each others keys unnoticed
+func (s *kubernetesStateStore) Write(ctx context.Context, key string, data []byte) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "KubernetesStateStore::Write")
+	defer span.Finish()
+
+	object, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	body := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": s.name, "namespace": s.client.Namespace()},
+	}
+	values := map[string][]byte{}
+	if object != nil {
+		values = object.values()
+		body["metadata"].(map[string]interface{})["resourceVersion"] = object.resourceVersion
+	}
+	values[kubernetesDataKey(key)] = data
+
+	if s.resource == "secrets" {
+		body["kind"] = "Secret"
+		body["data"] = values
+	} else {
+		stringValues := make(map[string]string, len(values))
+		for k, v := range values {
+			stringValues[k] = string(v)
+		}
+		body["data"] = stringValues
+	}
+
+	if object == nil {
+		return s.write(ctx, http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%v/%v", s.client.Namespace(), s.resource), body)
+	}
+
+	return s.write(ctx, http.MethodPut, fmt.Sprintf("/api/v1/namespaces/%v/%v/%v", s.client.Namespace(), s.resource, s.name), body)
+}
+
+// kubernetesStateObject is a configmap or secret as read by the state store
+type kubernetesStateObject struct {
+	// resourceVersion is sent back on updates, so a concurrent update fails instead of being overwritten
+	resourceVersion string
+	data            map[string][]byte
+}
+
+// values returns a copy of the data of the object
+func (o *kubernetesStateObject) values() map[string][]byte {
+	values := map[string][]byte{}
+	if o == nil {
+		return values
+	}
+	for k, v := range o.data {
+		values[k] = v
+	}
+
+	return values
+}
+
+// get returns the configmap or secret, or nil if it doesn't exist yet
+func (s *kubernetesStateStore) get(ctx context.Context) (*kubernetesStateObject, error) {
+	var response struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Data json.RawMessage `json:"data"`
+	}
+	statusCode, err := s.client.Request(ctx, http.MethodGet, fmt.Sprintf("/api/v1/namespaces/%v/%v/%v", s.client.Namespace(), s.resource, s.name), nil, &response)
+	if statusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	object := &kubernetesStateObject{resourceVersion: response.Metadata.ResourceVersion, data: map[string][]byte{}}
+	if len(response.Data) == 0 {
+		return object, nil
+	}
+	// the values of secrets are base64 encoded, which encoding/json decodes for byte slices
+	if s.resource == "secrets" {
+		err = json.Unmarshal(response.Data, &object.data)
+	} else {
+		var stringValues map[string]string
+		err = json.Unmarshal(response.Data, &stringValues)
+		for k, v := range stringValues {
+			object.data[k] = []byte(v)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling %v: %w", s.describe(), err)
+	}
+
+	return object, nil
+}
+
+// write creates or updates the configmap or secret, returning an error saying so if it was changed concurrently
+func (s *kubernetesStateStore) write(ctx context.Context, method, path string, body interface{}) error {
+	statusCode, err := s.client.Request(ctx, method, path, body, nil)
+	if statusCode == http.StatusConflict {
+		return fmt.Errorf("Failed requesting %v %v, %v was changed concurrently", method, path, s.describe())
+	}
+
+	return err
+}
+
+// describe returns the kind and name of the object for error messages
+func (s *kubernetesStateStore) describe() string {
+	if s.resource == "secrets" {
+		return fmt.Sprintf("secret %v/%v", s.client.Namespace(), s.name)
+	}
+
+	return fmt.Sprintf("configmap %v/%v", s.client.Namespace(), s.name)
+}
+
+// kubernetesDataKey returns the key as a valid data key, which is its file name with invalid characters replaced by an underscore
+func kubernetesDataKey(key string) string {
+	return kubernetesDataKeyInvalidChars.ReplaceAllString(stateKey(key), "_")
+}
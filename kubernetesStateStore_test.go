@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetesStateStore(t *testing.T) {
+	// newFakeKubernetesAPI serves a single configmap or secret with optimistic concurrency on its resource version
+	newFakeKubernetesAPI := func(resource string) (*httptest.Server, *map[string]interface{}) {
+		var object map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodGet && r.URL.Path == "/api/v1/namespaces/estafette/"+resource+"/state":
+				if object == nil {
+					http.NotFound(w, r)
+					return
+				}
+				json.NewEncoder(w).Encode(object)
+			case r.Method == http.MethodPost && r.URL.Path == "/api/v1/namespaces/estafette/"+resource:
+				json.NewDecoder(r.Body).Decode(&object)
+				object["metadata"].(map[string]interface{})["resourceVersion"] = "1"
+				w.WriteHeader(http.StatusCreated)
+			case r.Method == http.MethodPut && r.URL.Path == "/api/v1/namespaces/estafette/"+resource+"/state":
+				var updated map[string]interface{}
+				json.NewDecoder(r.Body).Decode(&updated)
+				version := object["metadata"].(map[string]interface{})["resourceVersion"].(string)
+				if updated["metadata"].(map[string]interface{})["resourceVersion"] != version {
+					w.WriteHeader(http.StatusConflict)
+					return
+				}
+				next, _ := strconv.Atoi(version)
+				updated["metadata"].(map[string]interface{})["resourceVersion"] = strconv.Itoa(next + 1)
+				object = updated
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		return server, &object
+	}
+	token := func() (string, error) { return "fake-token", nil }
+
+	t.Run("ReturnsErrStateNotFoundBeforeFirstWrite", func(t *testing.T) {
+
+		server, _ := newFakeKubernetesAPI("configmaps")
+		defer server.Close()
+		store := NewKubernetesStateStore(NewKubernetesClient(server.URL, token, "estafette", server.Client()), stateStoreConfigMap, "state")
+
+		// act
+		_, err := store.Read(context.Background(), "/data/work-queue.json")
+
+		assert.True(t, errors.Is(err, ErrStateNotFound))
+	})
+
+	t.Run("CreatesConfigMapAndKeepsOtherKeysOnUpdate", func(t *testing.T) {
+
+		server, object := newFakeKubernetesAPI("configmaps")
+		defer server.Close()
+		store := NewKubernetesStateStore(NewKubernetesClient(server.URL, token, "estafette", server.Client()), stateStoreConfigMap, "state")
+		ctx := context.Background()
+
+		// act
+		err := store.Write(ctx, "/data/work-queue.json", []byte(`[]`))
+		assert.Nil(t, err)
+		err = store.Write(ctx, "/data/stats-history.json", []byte(`{}`))
+		assert.Nil(t, err)
+
+		assert.Equal(t, map[string]interface{}{"work-queue.json": "[]", "stats-history.json": "{}"}, (*object)["data"])
+		data, err := store.Read(ctx, "/data/work-queue.json")
+		assert.Nil(t, err)
+		assert.Equal(t, "[]", string(data))
+	})
+
+	t.Run("StoresSecretValuesBase64Encoded", func(t *testing.T) {
+
+		server, object := newFakeKubernetesAPI("secrets")
+		defer server.Close()
+		store := NewKubernetesStateStore(NewKubernetesClient(server.URL, token, "estafette", server.Client()), stateStoreSecret, "state")
+		ctx := context.Background()
+
+		// act
+		err := store.Write(ctx, "last-applied.json", []byte(`{}`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, "Secret", (*object)["kind"])
+		assert.Equal(t, map[string]interface{}{"last-applied.json": "e30="}, (*object)["data"])
+		data, err := store.Read(ctx, "last-applied.json")
+		assert.Nil(t, err)
+		assert.Equal(t, "{}", string(data))
+	})
+
+	t.Run("ReturnsErrorIfChangedConcurrently", func(t *testing.T) {
+
+		server, _ := newFakeKubernetesAPI("configmaps")
+		defer server.Close()
+		client := NewKubernetesClient(server.URL, token, "estafette", server.Client())
+		ctx := context.Background()
+		assert.Nil(t, NewKubernetesStateStore(client, stateStoreConfigMap, "state").Write(ctx, "work-queue.json", []byte(`[]`)))
+		store := NewKubernetesStateStore(&concurrentlyUpdatedKubernetesClient{KubernetesClient: client}, stateStoreConfigMap, "state")
+
+		// act
+		err := store.Write(ctx, "work-queue.json", []byte(`[{}]`))
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "configmap estafette/state was changed concurrently")
+		}
+	})
+}
+
+// concurrentlyUpdatedKubernetesClient updates the object with another write right after every read
+type concurrentlyUpdatedKubernetesClient struct {
+	KubernetesClient
+}
+
+func (c *concurrentlyUpdatedKubernetesClient) Request(ctx context.Context, method, path string, requestObject, responseObject interface{}) (int, error) {
+	statusCode, err := c.KubernetesClient.Request(ctx, method, path, requestObject, responseObject)
+	if method == http.MethodGet && err == nil {
+		err = NewKubernetesStateStore(c.KubernetesClient, stateStoreConfigMap, "state").Write(ctx, "stats-history.json", []byte(`{}`))
+	}
+
+	return statusCode, err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/estafette/estafette-ci-gsuite-synchronizer/reconcile"
+)
+
+// readLastApplied reads the snapshot of the group fields applied by the previous sync from the state store; it returns nil if path is empty and an empty snapshot if there's none yet
+func readLastApplied(ctx context.Context, path string) (*reconcile.Snapshot, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := getStateStore().Read(ctx, path)
+	if errors.Is(err, ErrStateNotFound) {
+		return reconcile.NewSnapshot(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading last-applied snapshot %v: %w", path, err)
+	}
+
+	snapshot := reconcile.NewSnapshot()
+	err = json.Unmarshal(data, snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling last-applied snapshot %v: %w", path, err)
+	}
+	if snapshot.Groups == nil {
+		snapshot.Groups = map[string]*reconcile.GroupFields{}
+	}
+
+	return snapshot, nil
+}
+
+// writeLastApplied replaces the snapshot in the state store
+func writeLastApplied(ctx context.Context, path string, snapshot *reconcile.Snapshot) error {
+	if path == "" || snapshot == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = getStateStore().Write(ctx, path, data)
+	if err != nil {
+		return fmt.Errorf("Failed writing last-applied snapshot %v: %w", path, err)
+	}
+
+	return nil
+}
+
+// recordLastApplied stores the fields applied for the directory groups in the snapshot; groups whose action failed keep their previous fields, so the change is retried next sync
+func recordLastApplied(snapshot *reconcile.Snapshot, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember, options planOptions, actions []*Action) {
+	if snapshot == nil {
+		return
+	}
+
+	failed := map[string]bool{}
+	for _, a := range actions {
+		if a.Err == nil || a.Group == nil {
+			continue
+		}
+		for _, i := range a.Group.Identities {
+			if i.Provider == provider.Name() {
+				failed[reconcile.GroupKey(i.Provider, i.ID)] = true
+			}
+		}
+	}
+
+	for gg := range groupMembers {
+		key := reconcile.GroupKey(provider.Name(), gg.ID)
+		if !failed[key] {
+			snapshot.Groups[key] = desiredGroupFields(gg, options)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/opentracing/opentracing-go"
+)
+
+const ldapProviderName = "ldap"
+
+type LdapClient interface {
+	Provider
+}
+
+// NewLdapClient returns a new LdapClient
+func NewLdapClient(ldapURL, ldapBindDN, ldapBindPassword, ldapBaseDN, ldapGroupFilter, ldapMemberAttribute, ldapUserFilter, ldapEmailAttribute string) LdapClient {
+	return &ldapClient{
+		ldapURL:             ldapURL,
+		ldapBindDN:          ldapBindDN,
+		ldapBindPassword:    ldapBindPassword,
+		ldapBaseDN:          ldapBaseDN,
+		ldapGroupFilter:     ldapGroupFilter,
+		ldapMemberAttribute: ldapMemberAttribute,
+		ldapUserFilter:      ldapUserFilter,
+		ldapEmailAttribute:  ldapEmailAttribute,
+	}
+}
+
+type ldapClient struct {
+	ldapURL             string
+	ldapBindDN          string
+	ldapBindPassword    string
+	ldapBaseDN          string
+	ldapGroupFilter     string
+	ldapMemberAttribute string
+	ldapUserFilter      string
+	ldapEmailAttribute  string
+}
+
+func (c *ldapClient) Name() string {
+	return ldapProviderName
+}
+
+func (c *ldapClient) UserIdentityProvider() string {
+	// estafette users don't log in with ldap, so members are matched by email address
+	return ""
+}
+
+func (c *ldapClient) GetGroupsWithMembers(ctx context.Context) (groupMembers map[*DirectoryGroup][]*DirectoryMember, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "LdapClient::GetGroupsWithMembers")
+	defer span.Finish()
+
+	groupMembers = map[*DirectoryGroup][]*DirectoryMember{}
+
+	conn, err := ldap.DialURL(c.ldapURL)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if c.ldapBindDN != "" {
+		err = conn.Bind(c.ldapBindDN, c.ldapBindPassword)
+		if err != nil {
+			return
+		}
+	}
+
+	searchRequest := ldap.NewSearchRequest(c.ldapBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false, c.ldapGroupFilter, []string{"cn", "mail", c.ldapMemberAttribute}, nil)
+	searchResult, err := conn.SearchWithPaging(searchRequest, 500)
+	if err != nil {
+		return
+	}
+
+	// members are often part of many groups, so only resolve them once
+	resolvedMembers := map[string]*DirectoryMember{}
+	groupMemberCount := 0
+
+	for _, entry := range searchResult.Entries {
+		group := &DirectoryGroup{
+			ID:    entry.DN,
+			Name:  entry.GetAttributeValue("cn"),
+			Email: entry.GetAttributeValue("mail"),
+		}
+
+		members := make([]*DirectoryMember, 0)
+		for _, value := range entry.GetAttributeValues(c.ldapMemberAttribute) {
+			member, ok := resolvedMembers[value]
+			if !ok {
+				member, err = c.resolveMember(conn, value)
+				if err != nil {
+					return groupMembers, err
+				}
+				resolvedMembers[value] = member
+			}
+			if member != nil {
+				members = append(members, member)
+			}
+		}
+
+		groupMembers[group] = members
+		groupMemberCount += len(members)
+	}
+
+	span.LogKV("groups", len(groupMembers), "groupmembers", groupMemberCount)
+
+	return
+}
+
+// resolveMember looks up the member entry, either by dn for member/uniqueMember attributes or with the user filter for memberUid attributes
+func (c *ldapClient) resolveMember(conn *ldap.Conn, value string) (member *DirectoryMember, err error) {
+
+	var searchRequest *ldap.SearchRequest
+	if _, dnErr := ldap.ParseDN(value); dnErr == nil && value != "" {
+		searchRequest = ldap.NewSearchRequest(value, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false, "(objectClass=*)", []string{c.ldapEmailAttribute}, nil)
+	} else {
+		searchRequest = ldap.NewSearchRequest(c.ldapBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, 0, false, fmt.Sprintf(c.ldapUserFilter, ldap.EscapeFilter(value)), []string{c.ldapEmailAttribute}, nil)
+	}
+
+	searchResult, err := conn.Search(searchRequest)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			// member no longer exists, skip it
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if len(searchResult.Entries) == 0 {
+		return nil, nil
+	}
+
+	return &DirectoryMember{
+		ID:    searchResult.Entries[0].DN,
+		Email: searchResult.Entries[0].GetAttributeValue(c.ldapEmailAttribute),
+	}, nil
+}
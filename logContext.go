@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type loggerContextKey struct{}
+
+// contextWithLogger returns the context with the logger, so everything logging with logFromContext carries its fields
+func contextWithLogger(ctx context.Context, logger zerolog.Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, &logger)
+}
+
+// logFromContext returns the logger set with contextWithLogger, carrying the run id, provider, domain and mode, or the global logger if there's none
+func logFromContext(ctx context.Context) *zerolog.Logger {
+	if logger, ok := ctx.Value(loggerContextKey{}).(*zerolog.Logger); ok {
+		return logger
+	}
+
+	return &log.Logger
+}
+
+// contextWithModeLogger returns the context with a logger carrying the provider, the domain or other tenant of the directory, the estafette environment and the mode the syncer runs in, so the logs of several deployments can be told apart in the log aggregator
+func contextWithModeLogger(ctx context.Context, mode string) context.Context {
+	logContext := logFromContext(ctx).With().Str("provider", *provider).Str("mode", mode)
+	if domain := directoryDomain(); domain != "" {
+		logContext = logContext.Str("domain", domain)
+	}
+	if tenant := tenantName(); tenant != "" {
+		logContext = logContext.Str("tenant", tenant)
+	}
+
+	return contextWithLogger(ctx, logContext.Logger())
+}
+
+// contextWithRunLogger returns the context with the logger of the context extended with the run id, so the logs of a run, including the ones of its concurrent member fetches, can be filtered by it
+func contextWithRunLogger(ctx context.Context, runID string) context.Context {
+	return contextWithLogger(ctx, logFromContext(ctx).With().Str("runID", runID).Logger())
+}
+
+// runMode returns the mode to log for the command, which is daemon for a sync on an interval or schedule
+func runMode(command string) string {
+	if command == syncCommand.FullCommand() && (*syncInterval > 0 || *syncCronSchedule != "") {
+		return "daemon"
+	}
+
+	return command
+}
+
+// directoryDomain returns the domain, customers, base dn or organization of the directory the provider synchronizes from, or an empty string if it has none
+func directoryDomain() string {
+	switch *provider {
+	case gsuiteProviderName:
+		if len(*gsuiteCustomerIDs) > 0 {
+			return strings.Join(*gsuiteCustomerIDs, ",")
+		}
+		return *gsuiteDomain
+	case ldapProviderName:
+		return *ldapBaseDN
+	case githubProviderName:
+		return *githubOrganization
+	}
+
+	return ""
+}
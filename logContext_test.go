@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogFromContext(t *testing.T) {
+	t.Run("ReturnsGlobalLoggerWithoutLoggerInContext", func(t *testing.T) {
+
+		// act
+		logger := logFromContext(context.Background())
+
+		assert.NotNil(t, logger)
+	})
+
+	t.Run("LogsModeAndRunFields", func(t *testing.T) {
+
+		defer func(value string) { *provider = value }(*provider)
+		defer func(value string) { *gsuiteDomain = value }(*gsuiteDomain)
+		*provider = gsuiteProviderName
+		*gsuiteDomain = "example.com"
+
+		var b bytes.Buffer
+		ctx := contextWithLogger(context.Background(), zerolog.New(&b))
+		ctx = contextWithModeLogger(ctx, "daemon")
+		ctx = contextWithRunLogger(ctx, "run-1")
+
+		// act
+		logFromContext(ctx).Info().Msg("Fetching groups")
+
+		var fields map[string]interface{}
+		err := json.Unmarshal(b.Bytes(), &fields)
+		assert.Nil(t, err)
+		assert.Equal(t, "run-1", fields["runID"])
+		assert.Equal(t, "gsuite", fields["provider"])
+		assert.Equal(t, "example.com", fields["domain"])
+		assert.Equal(t, "daemon", fields["mode"])
+	})
+}
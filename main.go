@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"io"
+	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin"
 	foundation "github.com/estafette/estafette-foundation"
@@ -23,20 +30,248 @@ var (
 	goVersion = runtime.Version()
 
 	// params for apiClient
-	apiBaseURL   = kingpin.Flag("api-base-url", "The base url of the estafette-ci-api to communicate with").Envar("API_BASE_URL").Required().String()
-	clientID     = kingpin.Flag("client-id", "The id of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_ID").Required().String()
-	clientSecret = kingpin.Flag("client-secret", "The secret of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_SECRET").Required().String()
+	apiBaseURL       = kingpin.Flag("api-base-url", "The base url of the estafette-ci-api to communicate with").Envar("API_BASE_URL").String()
+	clientID         = kingpin.Flag("client-id", "The id of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_ID").String()
+	clientSecret     = kingpin.Flag("client-secret", "The secret of the client as configured in Estafette, to securely communicate with the api.").Envar("CLIENT_SECRET").String()
+	clientSecretFile = kingpin.Flag("client-secret-file", "The file holding the secret of the client, instead of --client-secret; it's read for every sync so a rotated secret is picked up without restarting.").Envar("CLIENT_SECRET_FILE").String()
+	apiTimeout       = kingpin.Flag("api-timeout", "The timeout for a single request to the estafette-ci-api.").Default("10s").Envar("API_TIMEOUT").Duration()
+	apiRetries       = kingpin.Flag("api-max-retries", "The maximum number of attempts for a request to the estafette-ci-api.").Default("3").Envar("API_MAX_RETRIES").Int()
+	apiConcurrency   = kingpin.Flag("api-concurrency", "The number of mutations to send to the estafette-ci-api in parallel; organizations, groups, memberships, role changes and group deletions are applied one after the other.").Default("10").Envar("API_CONCURRENCY").Int()
+	apiPageSize      = kingpin.Flag("api-page-size", "The number of organizations, groups or users to request per page from the estafette-ci-api; it may return smaller pages under load.").Default("100").Envar("API_PAGE_SIZE").Int()
+	apiBackoff       = kingpin.Flag("api-backoff", "The backoff strategy between attempts of a request to the estafette-ci-api.").Default("exponential-jitter").Envar("API_BACKOFF").Enum("default", "linear", "linear-jitter", "exponential", "exponential-jitter")
+
+	apiBreakerFailures = kingpin.Flag("api-breaker-failures", "The number of consecutive failed mutations after which the circuit breaker stops sending mutations to the estafette-ci-api.").Default("5").Envar("API_BREAKER_FAILURES").Int()
+	apiBreakerCooldown = kingpin.Flag("api-breaker-cooldown", "The time the circuit breaker waits before sending mutations to the estafette-ci-api again.").Default("30s").Envar("API_BREAKER_COOLDOWN").Duration()
+	apiPatch           = kingpin.Flag("api-patch", "Sends only the changed fields of groups, users and organizations as json merge patch; falls back to replacing the entire entity if the estafette-ci-api doesn't support it.").Default("true").Envar("API_PATCH").Bool()
+	apiIfMatch         = kingpin.Flag("api-if-match", "Fetches groups, users and organizations right before updating them and sends their etag as If-Match header; concurrent modifications are kept and the changes re-applied on top of them.").Default("true").Envar("API_IF_MATCH").Bool()
+	apiMemberships     = kingpin.Flag("api-membership-endpoints", "Adds and removes group memberships one by one with the membership endpoints of the estafette-ci-api instead of updating the entire user, so concurrent membership changes can't overwrite each other; falls back to updating the entire user if the api doesn't have them.").Envar("API_MEMBERSHIP_ENDPOINTS").Bool()
+	apiUnknownFields   = kingpin.Flag("api-unknown-fields", "What to do with fields in estafette-ci-api responses the syncer doesn't know: ignore them, log each of them once, or fail the request, for testing against a newer api.").Default(apiUnknownFieldsLog).Envar("API_UNKNOWN_FIELDS").Enum(apiUnknownFieldsIgnore, apiUnknownFieldsLog, apiUnknownFieldsFail)
+	apiIntegrationLog  = kingpin.Flag("api-integration-log", "Posts a summary of every sync run to the estafette api, so admins can see when the last sync happened and what changed from the estafette ui.").Envar("API_INTEGRATION_LOG").Bool()
+
+	// params for triggering a pipeline after changes
+	triggerPipelineName   = kingpin.Flag("trigger-pipeline", "An estafette pipeline to build after every sync that changed anything, as source/owner/name like github.com/estafette/rbac-manifests, so downstream automation runs when access changes.").Envar("TRIGGER_PIPELINE").String()
+	triggerPipelineBranch = kingpin.Flag("trigger-pipeline-branch", "The branch of --trigger-pipeline to build.").Default("main").Envar("TRIGGER_PIPELINE_BRANCH").String()
+
+	// params for emailing the report of every run
+	reportEmailTo     = kingpin.Flag("report-email-to", "Comma-separated email addresses, like security or platform distribution lists, to send the report of every sync to.").Envar("REPORT_EMAIL_TO").String()
+	reportEmailFrom   = kingpin.Flag("report-email-from", "The sender of the emailed reports; with --report-email-via=gmail the service account impersonates it, which needs the https://www.googleapis.com/auth/gmail.send scope in its domain-wide delegation.").Envar("REPORT_EMAIL_FROM").String()
+	reportEmailFormat = kingpin.Flag("report-email-format", "The format of the emailed reports.").Default("html").Envar("REPORT_EMAIL_FORMAT").Enum("html", "markdown")
+	reportEmailVia    = kingpin.Flag("report-email-via", "Sends the reports through the --smtp-address server or the gmail api.").Default("smtp").Envar("REPORT_EMAIL_VIA").Enum("smtp", "gmail")
+	smtpAddress       = kingpin.Flag("smtp-address", "The host:port of the smtp server to send reports through.").Envar("SMTP_ADDRESS").String()
+	smtpUsername      = kingpin.Flag("smtp-username", "The username to authenticate to the smtp server with; without it reports are sent unauthenticated.").Envar("SMTP_USERNAME").String()
+	smtpPassword      = kingpin.Flag("smtp-password", "The password to authenticate to the smtp server with.").Envar("SMTP_PASSWORD").String()
+
+	// params for kubernetes events
+	kubernetesEvents               = kingpin.Flag("kubernetes-events", "Records the outcome of every sync as kubernetes event on the job or deployment running the syncer; requires permission to get pods and replicasets and create events in its namespace.").Envar("KUBERNETES_EVENTS").Bool()
+	kubernetesEventsLargeChangeSet = kingpin.Flag("kubernetes-events-large-change-set", "The number of applied changes from which a sync records a LargeChangeSet warning event as well; disabled if zero.").Default("100").Envar("KUBERNETES_EVENTS_LARGE_CHANGE_SET").Int()
+
+	// params for directory change events
+	directorySnapshotFile      = kingpin.Flag("directory-snapshot-file", "A json file recording the directory groups and members fetched by the previous sync, to log every group and membership change in the directory since then; not supported with --streaming.").Envar("DIRECTORY_SNAPSHOT_FILE").String()
+	changeEventsWebhookURL     = kingpin.Flag("change-events-webhook-url", "An url to post the directory changes logged because of --directory-snapshot-file to, as json array.").Envar("CHANGE_EVENTS_WEBHOOK_URL").String()
+	changeEventsWebhookTimeout = kingpin.Flag("change-events-webhook-timeout", "The timeout for posting directory changes to the webhook.").Default("10s").Envar("CHANGE_EVENTS_WEBHOOK_TIMEOUT").Duration()
+
+	// params for approving destructive changes
+	approvalWebhookURL   = kingpin.Flag("approval-webhook-url", "An approval system to post deleted groups and removed group memberships to for approval before applying them, polling the status url it responds with; the other changes are applied right away. Not supported with --streaming.").Envar("APPROVAL_WEBHOOK_URL").String()
+	approvalPollInterval = kingpin.Flag("approval-poll-interval", "The interval for polling the status of a pending approval.").Default("15s").Envar("APPROVAL_POLL_INTERVAL").Duration()
+	approvalTimeout      = kingpin.Flag("approval-timeout", "The time to wait for approval before failing the sync, leaving the destructive changes for the next sync.").Default("30m").Envar("APPROVAL_TIMEOUT").Duration()
+	approvalPlanFile     = kingpin.Flag("approval-plan-file", "Writes deleted groups and removed group memberships to this signed plan file instead of applying them, to apply with the apply command once approved, for instance behind an estafette manual gate; the other changes are applied right away. Not supported with --streaming.").Envar("APPROVAL_PLAN_FILE").String()
+
+	// params for run limits
+	runTimeout          = kingpin.Flag("run-timeout", "The maximum duration of a complete sync; a sync exceeding it stops, logs how far it got and exits with code 3. Disabled if zero.").Default("0s").Envar("RUN_TIMEOUT").Duration()
+	shutdownGracePeriod = kingpin.Flag("shutdown-grace-period", "On SIGTERM or SIGINT no new changes are applied, and the requests in flight get this long to finish before they're canceled; the audit log, report and spans are flushed and a sync that didn't apply all changes exits with code 4. Keep it below the terminationGracePeriodSeconds of the pod.").Default("20s").Envar("SHUTDOWN_GRACE_PERIOD").Duration()
+	retryBudgetSize     = kingpin.Flag("retry-budget", "The maximum number of retries of all requests to the estafette api in a sync combined; once used up failed requests aren't retried anymore. Unlimited if zero.").Default("0").Envar("RETRY_BUDGET").Int()
+	maxGroups           = kingpin.Flag("max-groups", "Aborts a sync before applying anything if the directory returns more groups than this, which more likely means a broken prefix or filter than actual growth. Unlimited if zero.").Default("0").Envar("MAX_GROUPS").Int()
+	maxUsers            = kingpin.Flag("max-users", "Aborts a sync before applying anything if the directory groups hold more distinct members, or the directory more users, than this. Unlimited if zero.").Default("0").Envar("MAX_USERS").Int()
+
+	// params for the state store
+	stateStoreType           = kingpin.Flag("state-store", "Where to keep the --last-applied-file, --work-queue-file, --stats-history-file, --directory-snapshot-file and --membership-expiry-file between runs: as local files, as keys of a configmap or secret in the namespace of the pod, as objects in a gcs bucket or as redis strings; the other stores use the file names as keys, so they have to be distinct, and a daemon keeps its state when its pod moves to another node.").Default(stateStoreFile).Envar("STATE_STORE").Enum(stateStoreFile, stateStoreConfigMap, stateStoreSecret, stateStoreGCS, stateStoreRedis)
+	stateStoreName           = kingpin.Flag("state-store-name", "The name of the configmap or secret to keep the state in with --state-store=configmap or secret; it's created if it doesn't exist, and can't hold more than 1MiB. The service account needs permission to get, create and update it.").Default("estafette-ci-gsuite-syncer-state").Envar("STATE_STORE_NAME").String()
+	stateStoreGCSLocation    = kingpin.Flag("state-store-gcs-location", "The gs://bucket/path location to keep the state objects in with --state-store=gcs.").Envar("STATE_STORE_GCS_LOCATION").String()
+	stateStoreRedisAddress   = kingpin.Flag("state-store-redis-address", "The host:port or redis:// url of the redis server to keep the state in with --state-store=redis; a rediss:// url connects over tls.").Envar("STATE_STORE_REDIS_ADDRESS").String()
+	stateStoreRedisPassword  = kingpin.Flag("state-store-redis-password", "The password to authenticate to the redis server with, overriding the one in a redis:// url; unauthenticated if empty.").Envar("STATE_STORE_REDIS_PASSWORD").String()
+	stateStoreRedisKeyPrefix = kingpin.Flag("state-store-redis-key-prefix", "The prefix of the redis keys to keep the state in, so multiple syncers can share a redis server.").Default("estafette-ci-gsuite-syncer:").Envar("STATE_STORE_REDIS_KEY_PREFIX").String()
+
+	// params for http logging
+	logHTTP             = kingpin.Flag("log-http", "Logs the method, url, status and duration of every request to the directory and estafette apis, with credentials redacted, for debugging failed syncs.").Envar("LOG_HTTP").Bool()
+	logHTTPBodies       = kingpin.Flag("log-http-bodies", "Logs the request and response bodies as well with --log-http, with credential fields redacted and truncated to 4096 bytes.").Envar("LOG_HTTP_BODIES").Bool()
+	httpMaxConnsPerHost = kingpin.Flag("http-max-conns-per-host", "The maximum number of connections to a single api host, shared by all clients and kept open for reuse; new and reused connections per host are counted on /debug/vars in daemon mode.").Default("20").Envar("HTTP_MAX_CONNS_PER_HOST").Int()
+
+	// params for tracing
+	traceEntitySampleRate = kingpin.Flag("trace-entity-sample-rate", "The share of groups and users to record a span of their own for when fetching and reconciling them, so large runs don't explode trace storage.").Default("0.01").Envar("TRACE_ENTITY_SAMPLE_RATE").Float64()
+	traceGroups           = kingpin.Flag("trace-groups", "The email, name or id of a group to always trace fully, with its members and the changes to it and its members, regardless of the sample rates; can be repeated.").Envar("TRACE_GROUPS").Strings()
+
+	// params for fault injection
+	faultInjectionRate = kingpin.Flag("fault-injection-rate", "The share of requests to the directory and estafette apis to fail with a 429, 500 or 503 response or a timeout, for checking that retries and backoff recover; disabled if zero.").Default("0").Envar("FAULT_INJECTION_RATE").Hidden().Float64()
+
+	// params for version info
+	showVersion      = kingpin.Flag("version", "Prints the version and build info and exits.").Bool()
+	showCapabilities = kingpin.Flag("capabilities", "With --version, prints the compiled-in providers and which features the flags enable as well.").Bool()
+
+	// params for config file
+	configFile = kingpin.Flag("config-file", "A yaml file with settings in addition to the flags.").Envar("CONFIG_FILE").String()
+
+	// params for planner
+	priorityGroups         = kingpin.Flag("priority-groups", "Comma-separated glob patterns for the emails or names of directory groups, or the names of estafette groups, that are fetched and reconciled before all other groups in every run, like the admins and release managers, so their memberships are correct even if the run fails later on.").Envar("PRIORITY_GROUPS").String()
+	protectedGroups        = kingpin.Flag("protected-groups", "Comma-separated names or ids of estafette groups that are never modified, nor have their members changed, even if they have a matching directory identity.").Envar("PROTECTED_GROUPS").String()
+	organizationRules      = kingpin.Flag("organization-rule", "Attaches created groups with an email matching the regular expression to an estafette organization, as pattern=organization; can be repeated, the first matching rule wins.").Envar("ORGANIZATION_RULES").Strings()
+	statsHistoryFile       = kingpin.Flag("stats-history-file", "A json file keeping the directory and estafette counts of the last successful syncs, to detect anomalies against; disabled if empty.").Envar("STATS_HISTORY_FILE").String()
+	statsHistorySize       = kingpin.Flag("stats-history-size", "The number of successful syncs to keep in the --stats-history-file.").Default("30").Envar("STATS_HISTORY_SIZE").Int()
+	anomalyThreshold       = kingpin.Flag("anomaly-threshold", "The share the number of directory groups or members can drop since the last successful sync before a sync refuses to apply without --force, since that usually means the directory returned a partial view; only checked with --stats-history-file, disabled if zero.").Default("0.4").Envar("ANOMALY_THRESHOLD").Float64()
+	lastAppliedFile        = kingpin.Flag("last-applied-file", "A json file recording the group fields applied by the previous sync, so names, roles and organizations edited in estafette are kept unless they changed in the directory; if empty they're overwritten every sync.").Envar("LAST_APPLIED_FILE").String()
+	workQueueFile          = kingpin.Flag("work-queue-file", "A json file queueing the mutations that failed even after their retries, to re-attempt them at the start of the next run before reconciling, so changes survive estafette api outages spanning multiple runs; disabled if empty.").Envar("WORK_QUEUE_FILE").String()
+	membershipExpiryFile   = kingpin.Flag("membership-expiry-file", "A json file tracking when the next time-bound membership expires, so the sync removing it stays scheduled on time across restarts and syncs that fail before fetching the directory; disabled if empty.").Envar("MEMBERSHIP_EXPIRY_FILE").String()
+	workQueueMaxAttempts   = kingpin.Flag("work-queue-max-attempts", "The number of failed attempts after which a queued mutation is dropped from the --work-queue-file; unlimited if zero.").Default("10").Envar("WORK_QUEUE_MAX_ATTEMPTS").Int()
+	managedFields          = kingpin.Flag("managed-fields", "Comma-separated group fields the syncer updates, any of name, identities, members, roles and organizations; the others are left as they are in estafette.").Default("name,identities,members,roles,organizations").Envar("MANAGED_FIELDS").String()
+	nameConflictResolution = kingpin.Flag("name-conflict-resolution", "What to do with directory groups that map to an estafette group name claimed by another directory group: skip them, suffix their name with their directory id, or merge their members into the group holding the name; conflicts between directory groups aren't detected with --streaming.").Default(nameConflictSkip).Envar("NAME_CONFLICT_RESOLUTION").Enum(nameConflictSkip, nameConflictSuffix, nameConflictMerge)
+	syncEmptyGroups        = kingpin.Flag("sync-empty-groups", "Creates estafette groups for directory groups without members as well.").Envar("SYNC_EMPTY_GROUPS").Bool()
+	cleanupEmptyGroups     = kingpin.Flag("cleanup-empty-groups", "Deletes estafette groups whose directory groups have no members anymore; estafette groups have no inactive state, so they're deleted rather than deactivated.").Envar("CLEANUP_EMPTY_GROUPS").Bool()
+	includeMembers         = kingpin.Flag("include-members", "Comma-separated glob patterns for the emails or ids of the only directory members to give estafette group memberships, for example *@example.com; all members if empty.").Envar("INCLUDE_MEMBERS").String()
+	excludeMembers         = kingpin.Flag("exclude-members", "Comma-separated glob patterns for the emails or ids of directory members never to give estafette group memberships, like bots and shared mailboxes, for example *-bot@*.").Envar("EXCLUDE_MEMBERS").String()
+	excludeServiceAccounts = kingpin.Flag("exclude-service-accounts", "Never gives gcp service accounts that are members of directory groups estafette group memberships.").Envar("EXCLUDE_SERVICE_ACCOUNTS").Bool()
+	verifyMemberEmails     = kingpin.Flag("verify-member-emails", "Only gives directory members estafette group memberships if the users api confirms they're users of the directory domain, rather than trusting the email suffix; unverifiable members like external accounts or lookalike domains are logged and skipped.").Envar("VERIFY_MEMBER_EMAILS").Bool()
+	syncAllDomainUsers     = kingpin.Flag("sync-all-domain-users", "Fetches all users of the directory rather than only the members of synchronized groups, and reports the ones that have no estafette user yet since they never logged in to estafette.").Envar("SYNC_ALL_DOMAIN_USERS").Bool()
+	aggregateEveryoneGroup = kingpin.Flag("aggregate-group-everyone", "The name of an estafette group generated by the syncer holding the members of all synchronized groups, for example everyone; disabled if empty.").Envar("AGGREGATE_GROUP_EVERYONE").String()
+	aggregateAdminsGroup   = kingpin.Flag("aggregate-group-admins", "The name of an estafette group generated by the syncer holding the directory administrators, for example gsuite-admins; disabled if empty or if the provider can't retrieve users.").Envar("AGGREGATE_GROUP_ADMINS").String()
+
+	// params for labeling metrics, logs and reports
+	tenant      = kingpin.Flag("tenant", "The name of the estafette environment the syncer serves, like production, to label its metrics, logs and reports with, so one dashboard covers the syncers of all environments; defaults to the domain or other tenant of the directory.").Envar("TENANT").String()
+	tenantPeers = kingpin.Flag("tenant-peer", "The base url of the health endpoints of the syncer of another estafette environment, like http://syncer.staging:5000, whose last sync the /tenants endpoint aggregates in daemon mode; can be repeated.").Envar("TENANT_PEERS").Strings()
+
+	// params for selecting the directory provider
+	provider       = kingpin.Flag("provider", "The directory provider to synchronize groups and members from.").Default(gsuiteProviderName).Envar("PROVIDER").Enum(gsuiteProviderName, ldapProviderName, githubProviderName, pluginProviderName)
+	shadowProvider = kingpin.Flag("shadow-provider", "A second directory provider to fetch groups and members from as well, logging where its view differs from --provider without applying it, to de-risk migrating to another provider; it's configured with its own provider flags.").Envar("SHADOW_PROVIDER").Enum("", gsuiteProviderName, ldapProviderName, githubProviderName, pluginProviderName)
 
 	// params for gsuiteClient
-	gsuiteDomain      = kingpin.Flag("gsuite-domain", "The domain used by gsuite.").Envar("GSUITE_DOMAIN").Required().String()
-	gsuiteAdminEmail  = kingpin.Flag("gsuite-admin-email", "Email address for gsuite admin user that allowed the service account to impersonate him/her.").Envar("GSUITE_ADMIN_EMAIL").Required().String()
-	gsuiteGroupPrefix = kingpin.Flag("gsuite-group-prefix", "The prefix to use for gsuite groups in order to leave alone all non-prefixed groups.").Envar("GSUITE_GROUP_PREFIX").Required().String()
+	gsuiteDomain           = kingpin.Flag("gsuite-domain", "The domain used by gsuite.").Envar("GSUITE_DOMAIN").String()
+	gsuiteCustomerIDs      = kingpin.Flag("gsuite-customer-id", "The id of a gsuite customer to list the groups and users of across all its domains instead of only those of --gsuite-domain, or my_customer for the customer of the impersonated admin; can be repeated to sync the customer tenants a reseller admin manages.").Envar("GSUITE_CUSTOMER_ID").Strings()
+	gsuiteGroupDomains     = kingpin.Flag("gsuite-group-domain", "A domain to sync the groups of when listing by --gsuite-customer-id, leaving the groups of the customer's other domains and subdomains alone; can be repeated. The groups of all domains of the customer are synced if not set.").Envar("GSUITE_GROUP_DOMAIN").Strings()
+	gsuiteAdminEmail       = kingpin.Flag("gsuite-admin-email", "Email address for gsuite admin user that allowed the service account to impersonate him/her; comma-separated admins are tried in order until one can read the groups, so a suspended admin doesn't stop the sync.").Envar("GSUITE_ADMIN_EMAIL").String()
+	gsuiteGroupPrefixes    = kingpin.Flag("gsuite-group-prefix", "The prefix to use for gsuite groups in order to leave alone all non-prefixed groups; can be repeated to sync groups with multiple prefixes, each with its own roles, organizations and name transforms set in the groupPrefixes of the config file.").Envar("GSUITE_GROUP_PREFIX").Strings()
+	gsuiteConcurrency      = kingpin.Flag("gsuite-concurrency", "The number of gsuite groups to fetch members for in parallel.").Default("10").Envar("GSUITE_CONCURRENCY").Int()
+	gsuiteDailyQuota       = kingpin.Flag("gsuite-daily-quota", "The daily quota of directory api calls of the google cloud project, to report the calls left after every sync; in daemon mode syncs projected to exceed it run with half the concurrency and reuse the members of unchanged groups. Only counted if zero.").Default("0").Envar("GSUITE_DAILY_QUOTA").Int()
+	gsuiteConditionalFetch = kingpin.Flag("gsuite-conditional-fetch", "In daemon mode, keeps the group and member lists with their etags and requests them with If-None-Match every interval, so unchanged lists come back as an empty 304 response.").Envar("GSUITE_CONDITIONAL_FETCH").Bool()
+	gsuiteMemberCacheTTL   = kingpin.Flag("gsuite-member-cache-ttl", "In daemon mode, reuses the members of gsuite groups whose etag and member count didn't change for up to this long instead of listing them every interval; disabled if zero.").Default("0s").Envar("GSUITE_MEMBER_CACHE_TTL").Duration()
+
+	gsuiteSyncResourceHierarchy = kingpin.Flag("gsuite-sync-resource-hierarchy", "Creates an estafette organization for every gcp organization, folder and project, named by its path in the resource hierarchy.").Envar("GSUITE_SYNC_RESOURCE_HIERARCHY").Bool()
+	gsuiteSyncUserProfiles      = kingpin.Flag("gsuite-sync-user-profiles", "Keeps the name, given and family name and avatar of estafette users up to date with their gsuite user.").Envar("GSUITE_SYNC_USER_PROFILES").Bool()
+	gsuiteSyncGroupSettings     = kingpin.Flag("gsuite-sync-group-settings", "Records whether gsuite groups allow external members and who can join them as a gsuite-settings identity on the estafette group; requires the apps.groups.settings scope.").Envar("GSUITE_SYNC_GROUP_SETTINGS").Bool()
+	gsuiteSyncMembershipExpiry  = kingpin.Flag("gsuite-sync-membership-expiry", "Reads the expirations of time-bound gsuite group memberships from the cloud identity api and removes members from the estafette group once theirs passed, scheduling a sync for it in daemon mode; requires the cloud-identity.groups.readonly scope.").Envar("GSUITE_SYNC_MEMBERSHIP_EXPIRY").Bool()
+	gsuiteSyncAdminRoles        = kingpin.Flag("gsuite-sync-admin-roles", "Reads the admin role assignments of gsuite users, to give the users of the admin roles mapped with adminRoles in the --config-file the corresponding estafette roles; needs the https://www.googleapis.com/auth/admin.directory.rolemanagement.readonly scope in the domain-wide delegation.").Envar("GSUITE_SYNC_ADMIN_ROLES").Bool()
+	gsuiteSyncDynamicGroups     = kingpin.Flag("gsuite-sync-dynamic-groups", "Reads the membership query of dynamic gsuite groups from the cloud identity api and records it as a gsuite-dynamic identity on their estafette group, so users can tell its members are managed by the query and direct edits get reverted; requires the cloud-identity.groups.readonly scope.").Envar("GSUITE_SYNC_DYNAMIC_GROUPS").Bool()
+	gsuiteUserAttributeMapping  = kingpin.Flag("gsuite-user-attribute-mapping", "Maps a gsuite user custom schema field to an estafette user property, as property=Schema.Field; can be repeated.").Envar("GSUITE_USER_ATTRIBUTE_MAPPING").StringMap()
+	gsuiteAPIEndpoint           = kingpin.Flag("gsuite-api-endpoint", "The base url of a fake or emulated directory and resource manager api to use without credentials, for testing.").Envar("GSUITE_API_ENDPOINT").Hidden().String()
+
+	// params for ldapClient
+	ldapURL             = kingpin.Flag("ldap-url", "The url of the ldap server, for example ldaps://ldap.example.com:636.").Envar("LDAP_URL").String()
+	ldapBindDN          = kingpin.Flag("ldap-bind-dn", "The dn to bind with; if empty an anonymous bind is used.").Envar("LDAP_BIND_DN").String()
+	ldapBindPassword    = kingpin.Flag("ldap-bind-password", "The password for the bind dn.").Envar("LDAP_BIND_PASSWORD").String()
+	ldapBaseDN          = kingpin.Flag("ldap-base-dn", "The base dn to search groups and users in.").Envar("LDAP_BASE_DN").String()
+	ldapGroupFilter     = kingpin.Flag("ldap-group-filter", "The filter to select the groups to synchronize.").Default("(objectClass=groupOfNames)").Envar("LDAP_GROUP_FILTER").String()
+	ldapMemberAttribute = kingpin.Flag("ldap-member-attribute", "The group attribute holding its members, either as dn (member, uniqueMember) or as uid (memberUid).").Default("member").Envar("LDAP_MEMBER_ATTRIBUTE").String()
+	ldapUserFilter      = kingpin.Flag("ldap-user-filter", "The filter to find a user for a member attribute value that isn't a dn.").Default("(uid=%v)").Envar("LDAP_USER_FILTER").String()
+	ldapEmailAttribute  = kingpin.Flag("ldap-email-attribute", "The user attribute holding the email address to match estafette users with.").Default("mail").Envar("LDAP_EMAIL_ATTRIBUTE").String()
+
+	// params for githubClient
+	githubAPIBaseURL   = kingpin.Flag("github-api-base-url", "The base url of the github api, override for github enterprise.").Default("https://api.github.com").Envar("GITHUB_API_BASE_URL").String()
+	githubOrganization = kingpin.Flag("github-organization", "The github organization to synchronize teams from.").Envar("GITHUB_ORGANIZATION").String()
+	githubToken        = kingpin.Flag("github-token", "A github token with read:org scope to list the organization's teams and their members.").Envar("GITHUB_TOKEN").String()
+
+	// params for pluginClient
+	pluginPath = kingpin.Flag("plugin-path", "The executable of an out-of-tree directory provider, serving the DirectoryProvider grpc service of directoryProvider.proto with hashicorp/go-plugin.").Envar("PLUGIN_PATH").String()
+	pluginArgs = kingpin.Flag("plugin-arg", "An argument to start the plugin with; can be repeated.").Envar("PLUGIN_ARGS").Strings()
+
+	// params for auditLogger
+	auditLog               = kingpin.Flag("audit-log", "Records every mutation to a local file, gs://bucket/path location or bq://project.dataset.table table; disabled if empty. The entries are hash chained, continuing across runs in a file or bucket, so altering or removing any of them is detected by the verify-audit-log command; bq tables need previousHash and hash columns for the chain.").Envar("AUDIT_LOG").String()
+	auditLogSigningKeyFile = kingpin.Flag("audit-log-signing-key-file", "A pem encoded pkcs8 ed25519 private key to sign a summary closing the audit log entries of every run with; bq tables need entries and signature columns for it.").Envar("AUDIT_LOG_SIGNING_KEY_FILE").String()
+	triggeredBy            = kingpin.Flag("triggered-by", "Who or what triggered the run, recorded in the audit log; defaults to the app, version and host name.").Envar("TRIGGERED_BY").String()
+
+	// params for historyExporter
+	historyBigQueryProject      = kingpin.Flag("history-bigquery-project", "The gcp project of the bigquery dataset to append sync history to.").Envar("HISTORY_BIGQUERY_PROJECT").String()
+	historyBigQueryDataset      = kingpin.Flag("history-bigquery-dataset", "The bigquery dataset to append sync history to; disabled if empty.").Envar("HISTORY_BIGQUERY_DATASET").String()
+	historyBigQueryRunsTable    = kingpin.Flag("history-bigquery-runs-table", "The bigquery table to append a row per sync run to.").Default("sync_runs").Envar("HISTORY_BIGQUERY_RUNS_TABLE").String()
+	historyBigQueryActionsTable = kingpin.Flag("history-bigquery-actions-table", "The bigquery table to append a row per applied action to.").Default("sync_actions").Envar("HISTORY_BIGQUERY_ACTIONS_TABLE").String()
+
+	// subcommands
+	syncCommand              = kingpin.Command("sync", "Synchronizes directory groups and members to estafette.").Default()
+	diffCommand              = kingpin.Command("diff", "Shows the changes sync would make to estafette without applying them.")
+	validateCommand          = kingpin.Command("validate", "Checks the configuration, credentials and reachability of the apis.")
+	exportCommand            = kingpin.Command("export", "Exports the current directory and estafette state.")
+	verifyAuditLogCommand    = kingpin.Command("verify-audit-log", "Verifies the hash chain of the --audit-log file and the signatures of its run summaries, to prove the access change history wasn't altered.")
+	planCommand              = kingpin.Command("plan", "Writes the changes sync would make to a signed plan file, to be reviewed and approved before applying it.")
+	applyCommand             = kingpin.Command("apply", "Applies exactly the changes in a signed plan file, refusing if estafette changed since in a way that affects them.")
+	adoptCommand             = kingpin.Command("adopt", "Attaches the directory identities to the estafette groups created by hand before the syncer was set up, matching directory groups to them by normalized name, so sync takes them over instead of creating duplicates.")
+	repairCommand            = kingpin.Command("repair", "Merges the estafette groups carrying the same directory identity into the copy with the most members, moving the members of the other copies to it and deleting them, and lists the estafette users matching the same directory user.")
+	cleanupIdentitiesCommand = kingpin.Command("cleanup-identities", "Finds the directory identities of estafette groups and users whose directory group or user was deleted, and removes them or deactivates the users and deletes the groups left without identity, depending on --policy, keeping the identity graph consistent over years of churn; protected groups are left alone.")
+	simulateCommand          = kingpin.Command("simulate", "Shows the estafette groups, roles and organizations a user would get if they were added to a directory group, for approvers of workspace group requests; nothing is changed.")
+	accessReviewCommand      = kingpin.Command("access-review", "Writes a report of the estafette roles and group memberships of every user, traced back to the directory groups granting them, for periodic access reviews.")
+	tuiCommand               = kingpin.Command("tui", "Browses the directory groups, their estafette groups and the pending changes interactively in the terminal, applying only the selected changes; meant for onboarding an existing estafette installation.")
+	rollbackCommand          = kingpin.Command("rollback", "Reverses the changes a sync recorded in the --audit-log file, deleting the groups it created, recreating the ones it deleted and restoring the fields of the groups and users it updated.")
+
+	planSigningKey = kingpin.Flag("plan-signing-key", "The key to sign plan files with and verify them before applying, so an approved plan can't be edited.").Envar("PLAN_SIGNING_KEY").String()
+
+	// params for the sync, plan, apply and tui commands, which all check the guardrails before applying changes
+	syncMaxChangeRatio = kingpin.Flag("max-change-ratio", "The maximum share of existing group memberships, groups or user roles a sync, plan, apply or tui can remove without --force or an interactive confirmation.").Default("0.25").Envar("SYNC_MAX_CHANGE_RATIO").Float64()
+	syncForce          = kingpin.Flag("force", "Applies the changes even if they exceed --max-change-ratio or --anomaly-threshold.").Envar("SYNC_FORCE").Bool()
+
+	// params for sync command
+	syncInterval       = syncCommand.Flag("interval", "Runs as a daemon synchronizing every interval after the previous sync and serving /healthz, /readyz and /lastsync; if zero and --schedule isn't set either it synchronizes once.").Default("0s").Envar("SYNC_INTERVAL").Duration()
+	syncCronSchedule   = syncCommand.Flag("schedule", "Runs as a daemon synchronizing at the times of the standard cron expression, like */15 * * * *, instead of every --interval; times passing while the previous sync is still running are skipped.").Envar("SYNC_SCHEDULE").String()
+	syncJitter         = syncCommand.Flag("jitter", "In daemon mode, delays every sync by a random duration of up to this long, so replicas and other scheduled jobs don't all hit the apis at once.").Default("0s").Envar("SYNC_JITTER").Duration()
+	syncListenAddress  = syncCommand.Flag("listen-address", "The address to serve the health endpoints on in daemon mode.").Default(":5000").Envar("SYNC_LISTEN_ADDRESS").String()
+	adminAPIToken      = syncCommand.Flag("admin-api-token", "The bearer token for the admin api served in daemon mode next to the health endpoints, to get the last sync report and the current drift and to pause and resume syncing; disabled if empty.").Envar("ADMIN_API_TOKEN").String()
+	slackSigningSecret = syncCommand.Flag("slack-signing-secret", "The signing secret of the slack app whose /gsuite-sync status|diff|run slash command is served on /slack/commands in daemon mode; disabled if empty.").Envar("SLACK_SIGNING_SECRET").String()
+	syncPreflight      = syncCommand.Flag("preflight", "Checks the estafette login, the service account key and its domain-wide delegation for every scope before the first sync, failing with a remediation instead of deep inside the first request; run the validate command for a full check.").Default("true").Envar("SYNC_PREFLIGHT").Bool()
+	syncReportFile     = syncCommand.Flag("report-file", "The file to write a json report of the sync with all its actions and errors to, for controllers running the syncer; it's written for failed syncs as well.").Envar("SYNC_REPORT_FILE").String()
+	syncStreaming      = syncCommand.Flag("streaming", "Applies the changes group by group while the directory is being fetched instead of loading the entire directory first; only supported by the gsuite provider.").Envar("SYNC_STREAMING").Bool()
+
+	// params for diff command
+	diffDetailed = diffCommand.Flag("detailed", "Prints every change as json merge patch in a stable order instead of the --output format, so plans can be compared across runs.").Envar("DIFF_DETAILED").Bool()
+	diffOutput   = diffCommand.Flag("output", "The format to print the changes in: text lists them per group, json, table and markdown are meant for review in other tools.").Default("text").Envar("DIFF_OUTPUT").Enum("text", "json", "table", "markdown")
+	diffColor    = diffCommand.Flag("color", "Colors the text output, created groups and added members green and deleted groups and removed members red; with auto only when printing to a terminal and NO_COLOR isn't set.").Default("auto").Envar("DIFF_COLOR").Enum("auto", "always", "never")
+
+	// params for verify-audit-log command
+	verifyAuditLogPublicKeyFile = verifyAuditLogCommand.Flag("public-key-file", "The pem encoded pkix ed25519 public key of --audit-log-signing-key-file to verify the run summaries with; only the hash chain is verified if empty.").Envar("VERIFY_AUDIT_LOG_PUBLIC_KEY_FILE").String()
+
+	// params for plan and apply commands
+	planOutputFile = planCommand.Flag("out", "The file to write the signed plan to.").Default("plan.json").Envar("PLAN_OUT").String()
+	applyPlanFile  = applyCommand.Flag("plan", "The signed plan file to apply.").Default("plan.json").Envar("APPLY_PLAN").String()
+
+	// params for adopt command
+	adoptYes = adoptCommand.Flag("yes", "Adopts the matched groups without asking for confirmation.").Envar("ADOPT_YES").Bool()
+
+	// params for repair command
+	repairDryRun = repairCommand.Flag("dry-run", "Prints the duplicates and the changes merging them without applying them.").Envar("REPAIR_DRY_RUN").Bool()
+
+	// params for cleanup-identities command
+	cleanupIdentitiesPolicy = cleanupIdentitiesCommand.Flag("policy", "What to do with the estafette groups and users with stale identities: remove-identity removes the stale identities, deactivate deactivates the users and deletes the groups without any identity of the provider left, removing the stale identities of the other groups.").Default(staleIdentityPolicyRemove).Envar("CLEANUP_IDENTITIES_POLICY").Enum(staleIdentityPolicyRemove, staleIdentityPolicyDeactivate)
+	cleanupIdentitiesDryRun = cleanupIdentitiesCommand.Flag("dry-run", "Prints the stale identities and the changes cleaning them up without applying them.").Envar("CLEANUP_IDENTITIES_DRY_RUN").Bool()
+
+	// params for simulate command
+	simulateAdd = simulateCommand.Flag("add", "The email address of the user to add.").Required().Envar("SIMULATE_ADD").String()
+	simulateTo  = simulateCommand.Flag("to", "The email address or name of the directory group to add the user to.").Required().Envar("SIMULATE_TO").String()
+
+	// params for access-review command
+	accessReviewFormat = accessReviewCommand.Flag("format", "The format to write the report in.").Default("json").Envar("ACCESS_REVIEW_FORMAT").Enum("json", "csv", "markdown")
+	accessReviewOut    = accessReviewCommand.Flag("out", "The file to write the report to; printed if empty.").Envar("ACCESS_REVIEW_OUT").String()
+
+	// params for rollback command
+	rollbackRunID  = rollbackCommand.Flag("run-id", "The id of the sync to reverse, as logged with every line of its output and recorded in the audit log.").Required().Envar("ROLLBACK_RUN_ID").String()
+	rollbackDryRun = rollbackCommand.Flag("dry-run", "Prints the changes reversing the sync without applying them.").Envar("ROLLBACK_DRY_RUN").Bool()
+
+	// params for export command
+	exportFormat    = exportCommand.Flag("format", "The format to export the state in.").Default("json").Envar("EXPORT_FORMAT").Enum("json", "csv")
+	exportOutputDir = exportCommand.Flag("output-dir", "The local directory or gs://bucket/path location to write the exported files to.").Default(".").Envar("EXPORT_OUTPUT_DIR").String()
 )
 
 func main() {
 
 	// parse command line parameters
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	if *showVersion {
+		_ = writeVersionInfo(os.Stdout, newVersionInfo(*showCapabilities))
+		return
+	}
 
 	// init log format from envvar ESTAFETTE_LOG_FORMAT
 	foundation.InitLoggingFromEnv(foundation.NewApplicationInfo(appgroup, app, version, branch, revision, buildDate))
@@ -49,50 +284,588 @@ func main() {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "Main")
 	defer span.Finish()
 
-	apiClient := NewApiClient(*apiBaseURL, *gsuiteGroupPrefix)
+	shutdown := newShutdownSignal(*shutdownGracePeriod)
+	shutdown.notify()
+	ctx = contextWithShutdownSignal(ctx, shutdown)
+	ctx = contextWithModeLogger(ctx, runMode(command))
+	entitySampling = newEntitySampler(*traceEntitySampleRate, *traceGroups)
 
-	token, err := apiClient.GetToken(ctx, *clientID, *clientSecret)
-	handleError(closer, err, "Failed retrieving JWT token")
+	// verifying an audit log doesn't talk to estafette or the directory, so it doesn't need their flags
+	if command == verifyAuditLogCommand.FullCommand() {
+		runVerifyAuditLog(closer)
+		return
+	}
 
-	organizations, err := apiClient.GetOrganizations(ctx, token)
-	handleError(closer, err, "Failed fetching organizations")
+	if *apiBaseURL == "" || *clientID == "" {
+		handleError(closer, errors.New("flags --api-base-url and --client-id are required"), "Invalid configuration")
+	}
+	if *clientSecret == "" && *clientSecretFile == "" {
+		handleError(closer, errors.New("flag --client-secret or --client-secret-file is required"), "Invalid configuration")
+	}
+	// rolling back only talks to estafette
+	if command != rollbackCommand.FullCommand() {
+		validateProviderFlags(closer)
+	}
 
-	log.Info().Msgf("Fetched %v organizations", len(organizations))
+	// only the scopes of the enabled features are requested, so admins know exactly what to delegate
+	if *provider == gsuiteProviderName {
+		log.Info().Msgf("Requesting gsuite scopes %v", strings.Join(describeGsuiteScopes(gsuiteFeaturesFromFlags()), ", "))
+		gsuiteQuota = newQuotaTracker(*gsuiteDailyQuota)
+	}
 
-	groups, err := apiClient.GetGroups(ctx, token)
-	handleError(closer, err, "Failed fetching groups")
+	config, err := readConfig(*configFile)
+	handleError(closer, err, "Failed reading config file")
+	handleError(closer, validateConfig(config), "Invalid config file")
 
-	log.Info().Msgf("Fetched %v groups", len(groups))
+	stateStore, err = newStateStore(ctx)
+	handleError(closer, err, "Failed creating state store")
 
-	users, err := apiClient.GetUsers(ctx, token)
-	handleError(closer, err, "Failed fetching users")
+	groupPriority, err = newGroupPrioritizer(getMemberPatterns(*priorityGroups, nil))
+	handleError(closer, err, "Invalid configuration")
 
-	log.Info().Msgf("Fetched %v users", len(users))
+	if *triggeredBy == "" {
+		hostname, _ := os.Hostname()
+		*triggeredBy = fmt.Sprintf("%v %v on %v", app, version, hostname)
+	}
 
-	gsuiteClient, err := NewGsuiteClient(ctx, *gsuiteDomain, *gsuiteAdminEmail, *gsuiteGroupPrefix)
-	handleError(closer, err, "Failed creating gsuite client")
+	switch command {
+	case diffCommand.FullCommand():
+		runDiff(ctx, closer, config, newApiClient(nil))
+	case validateCommand.FullCommand():
+		runValidate(ctx, closer, newApiClient(nil))
+	case exportCommand.FullCommand():
+		runExport(ctx, closer, newApiClient(nil))
+	case planCommand.FullCommand():
+		runPlan(ctx, closer, config, newApiClient(nil))
+	case applyCommand.FullCommand():
+		runApply(ctx, closer, config)
+	case rollbackCommand.FullCommand():
+		runRollback(ctx, closer)
+	case adoptCommand.FullCommand():
+		runAdopt(ctx, closer, config)
+	case repairCommand.FullCommand():
+		runRepair(ctx, closer)
+	case cleanupIdentitiesCommand.FullCommand():
+		runCleanupIdentities(ctx, closer, config)
+	case simulateCommand.FullCommand():
+		runSimulate(ctx, closer, config)
+	case accessReviewCommand.FullCommand():
+		runAccessReview(ctx, closer)
+	case tuiCommand.FullCommand():
+		runTUI(ctx, closer, config)
+	case syncCommand.FullCommand():
+		if *syncPreflight {
+			checks := runPreflight(ctx, newApiClient(nil), false)
+			logPreflight(checks)
+			handleError(closer, preflightFailed(checks), "Invalid configuration")
+		}
+		if *syncInterval > 0 || *syncCronSchedule != "" {
+			schedule, err := newSyncSchedule(*syncInterval, *syncCronSchedule, *syncJitter)
+			handleError(closer, err, "Invalid configuration")
+			runDaemon(ctx, config, schedule, *syncListenAddress)
+		} else {
+			runSync(ctx, closer, config)
+		}
+	}
 
-	gsuiteOrganizations, err := gsuiteClient.GetOrganizations(ctx)
-	handleError(closer, err, "Failed fetching gsuite organizations")
+	log.Info().Msg("Done!")
+}
 
-	log.Info().Msgf("Fetched %v gsuite organizations", len(gsuiteOrganizations))
+// runSync applies all changes needed to bring estafette in sync with the directory
+func runSync(ctx context.Context, closer io.Closer, config *Config) {
+	report, err := runWithReport(ctx, config)
+	if *syncReportFile != "" {
+		if reportErr := writeReport(*syncReportFile, report); reportErr != nil {
+			logFromContext(ctx).Error().Err(reportErr).Msg("Failed writing sync report")
+		}
+	}
+	if errors.Is(err, ErrRunTimeout) {
+		closer.Close()
+		logFromContext(ctx).Error().Err(err).Msgf("Failed synchronizing %v groups to estafette in time", *provider)
+		os.Exit(exitCodeRunTimeout)
+	}
+	handleShutdown(closer, err, fmt.Sprintf("Stopped synchronizing %v groups to estafette before applying all changes", *provider))
+	handleError(closer, err, fmt.Sprintf("Failed synchronizing %v groups to estafette", *provider))
 
-	gsuiteGroups, err := gsuiteClient.GetGroups(ctx)
-	handleError(closer, err, "Failed fetching gsuite groups")
+	logFromContext(ctx).Info().Msgf("Applied %v actions for %v %v groups with %v name conflicts", len(report.Actions), report.DirectoryGroups, report.Provider, len(report.NameConflicts))
+}
 
-	log.Info().Msgf("Fetched %v gsuite groups", len(gsuiteGroups))
+// runDiff prints the changes a sync would apply without applying them
+func runDiff(ctx context.Context, closer io.Closer, config *Config, apiClient ApiClient) {
+	state, err := fetchState(ctx, apiClient)
+	handleError(closer, err, "Failed fetching state")
 
-	gsuiteGroupMembers, err := gsuiteClient.GetGroupMembers(ctx, gsuiteGroups)
-	handleError(closer, err, "Failed fetching gsuite group members")
+	actions, err := planState(ctx, config, state)
+	handleError(closer, err, "Failed planning changes")
 
-	for group, members := range gsuiteGroupMembers {
-		log.Info().Msgf("Fetched %v gsuite members for group %v", len(members), group.Email)
+	// with json output the notes go to stderr, so stdout stays a valid document
+	notes := os.Stdout
+	if *diffOutput == "json" && !*diffDetailed {
+		notes = os.Stderr
 	}
 
-	err = apiClient.SynchronizeGroupsAndMembers(ctx, token, groups, users, gsuiteGroupMembers)
-	handleError(closer, err, "Failed updating synchronizing gsuite groups to estafette")
+	options, err := getPlanOptions(config, state)
+	handleError(closer, err, "Failed planning changes")
+	for _, c := range detectNameConflicts(state.groups, state.provider, options.syncedGroupMembers(state.groupMembers, state.directoryUsers), options) {
+		fmt.Fprintf(notes, "name conflict: %v\n", c)
+	}
 
-	log.Info().Msg("Done!")
+	policies, err := compilePolicies(config.Policies)
+	handleError(closer, err, "Invalid policies")
+	for _, v := range evaluatePolicies(policies, state.groupMembers) {
+		fmt.Fprintf(notes, "policy violation (%v): %v\n", v.Enforcement, v)
+	}
+
+	if *diffDetailed {
+		if len(actions) == 0 {
+			fmt.Println("No changes, estafette is in sync")
+			return
+		}
+		plan, err := formatPlan(actions)
+		handleError(closer, err, "Failed formatting changes")
+		fmt.Print(plan)
+		fmt.Printf("%v changes\n", len(actions))
+		return
+	}
+
+	// json is printed even without changes, so tools reading it always get a document
+	if len(actions) == 0 && *diffOutput != "json" {
+		fmt.Println("No changes, estafette is in sync")
+		return
+	}
+
+	err = writeDiff(os.Stdout, newDiff(actions), *diffOutput, useColor(*diffColor, os.Stdout))
+	handleError(closer, err, "Failed printing changes")
+	if *diffOutput == "text" || *diffOutput == "table" {
+		fmt.Printf("%v changes\n", len(actions))
+	}
+}
+
+// runPlan writes the changes a sync would apply to a signed plan file, for review before applying it with runApply
+func runPlan(ctx context.Context, closer io.Closer, config *Config, apiClient ApiClient) {
+	state, err := fetchState(ctx, apiClient)
+	handleError(closer, err, "Failed fetching state")
+
+	actions, err := planState(ctx, config, state)
+	handleError(closer, err, "Failed planning changes")
+
+	for _, a := range actions {
+		fmt.Println(a)
+	}
+
+	err = checkGuardrails(ctx, config, state, actions, newStateRun(state))
+	handleError(closer, err, "Refusing to plan changes")
+
+	plan := &PlanFile{RunID: newRunID(), Provider: state.provider.Name(), CreatedAt: time.Now().UTC(), Actions: actions}
+	err = writePlanFile(*planOutputFile, *planSigningKey, plan)
+	handleError(closer, err, "Failed writing plan")
+
+	fmt.Printf("%v changes planned in %v\n", len(actions), *planOutputFile)
+}
+
+// runApply applies the changes in a signed plan file on top of the current estafette state; the run id of the plan is reused, so a retried apply sends the same idempotency keys
+func runApply(ctx context.Context, closer io.Closer, config *Config) {
+	plan, err := readPlanFile(*applyPlanFile, *planSigningKey)
+	handleError(closer, err, "Invalid plan")
+	if plan.Provider != *provider {
+		handleError(closer, fmt.Errorf("plan %v was made for provider %v, not %v", *applyPlanFile, plan.Provider, *provider), "Invalid plan")
+	}
+
+	ctx = contextWithRunID(ctx, plan.RunID)
+	auditLogger, err := NewAuditLogger(ctx, *auditLog, *auditLogSigningKeyFile, *triggeredBy)
+	handleError(closer, err, "Failed creating audit logger")
+	apiClient := newApiClient(auditLogger)
+
+	err = applyPlan(ctx, config, apiClient, plan)
+	auditErr := auditLogger.Close(ctx)
+	handleShutdown(closer, err, "Stopped applying plan before applying all changes")
+	handleError(closer, err, "Failed applying plan")
+	handleError(closer, auditErr, "Failed closing audit log")
+
+	logFromContext(ctx).Info().Msgf("Applied %v actions of plan %v made at %v", len(plan.Actions), *applyPlanFile, plan.CreatedAt.Format(time.RFC3339))
+}
+
+// applyPlan applies the actions of the plan after checking they still apply to the current estafette state and pass the guardrails against the current directory
+func applyPlan(ctx context.Context, config *Config, apiClient ApiClient, plan *PlanFile) error {
+	state, err := fetchState(ctx, apiClient)
+	if err != nil {
+		return fmt.Errorf("Failed fetching state: %w", err)
+	}
+
+	err = rebasePlan(plan.Actions, state)
+	if err != nil {
+		return fmt.Errorf("Refusing to apply plan: %w", err)
+	}
+	err = checkGuardrails(ctx, config, state, plan.Actions, newStateRun(state))
+	if err != nil {
+		return fmt.Errorf("Refusing to apply plan: %w", err)
+	}
+
+	applyCtx, cancel := shutdownSignalFromContext(ctx).bound(ctx)
+	defer cancel()
+
+	return shutdownError(ctx, plan.Actions, apiClient.ApplyActions(applyCtx, state.token, plan.Actions))
+}
+
+// runAdopt reports which estafette groups the directory groups match by normalized name and, once confirmed, attaches the directory identities to the ones that match a single group; the adoptions are recorded in the audit log like a sync
+func runAdopt(ctx context.Context, closer io.Closer, config *Config) {
+	ctx = contextWithRunID(ctx, newRunID())
+	auditLogger, err := NewAuditLogger(ctx, *auditLog, *auditLogSigningKeyFile, *triggeredBy)
+	handleError(closer, err, "Failed creating audit logger")
+	apiClient := newApiClient(auditLogger)
+
+	state, err := fetchState(ctx, apiClient)
+	handleError(closer, err, "Failed fetching state")
+
+	options, err := getPlanOptions(config, state)
+	handleError(closer, err, "Failed planning adoptions")
+
+	adoptions := planAdoptions(state.groups, state.provider, options.syncedGroupMembers(state.groupMembers, state.directoryUsers), options)
+	for _, a := range adoptions {
+		switch {
+		case a.Contested:
+			fmt.Printf("skip %v: estafette group %v matches other directory groups as well\n", a.DirectoryGroup.Name, a.Group.Name)
+		case a.Group == nil:
+			fmt.Printf("skip %v: matches several estafette groups %v\n", a.DirectoryGroup.Name, strings.Join(a.Candidates, ", "))
+		default:
+			fmt.Printf("adopt %v: estafette group %v (%v)\n", a.DirectoryGroup.Name, a.Group.Name, a.Group.ID)
+		}
+	}
+
+	actions := adoptionActions(adoptions, state.provider)
+	if len(actions) == 0 {
+		fmt.Println("No estafette groups to adopt")
+		handleError(closer, auditLogger.Close(ctx), "Failed closing audit log")
+		return
+	}
+
+	if !*adoptYes {
+		fmt.Printf("Adopt %v estafette groups? [y/N] ", len(actions))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if !strings.EqualFold(strings.TrimSpace(answer), "y") {
+			fmt.Println("Nothing adopted")
+			handleError(closer, auditLogger.Close(ctx), "Failed closing audit log")
+			return
+		}
+	}
+
+	applyCtx, cancel := shutdownSignalFromContext(ctx).bound(ctx)
+	defer cancel()
+	err = shutdownError(ctx, actions, apiClient.ApplyActions(applyCtx, state.token, actions))
+	auditErr := auditLogger.Close(ctx)
+	handleShutdown(closer, err, "Stopped adopting before adopting all groups")
+	handleError(closer, err, "Failed adopting groups")
+	handleError(closer, auditErr, "Failed closing audit log")
+
+	logFromContext(ctx).Info().Msgf("Adopted %v estafette groups, their names, roles and members are synced by the next sync", len(actions))
+}
+
+// runRepair merges the estafette groups sharing a directory identity, see planDuplicateRepair, and lists the users sharing one, which have to be merged by hand; the repair is recorded in the audit log like a sync
+func runRepair(ctx context.Context, closer io.Closer) {
+	ctx = contextWithRunID(ctx, newRunID())
+	auditLogger, err := NewAuditLogger(ctx, *auditLog, *auditLogSigningKeyFile, *triggeredBy)
+	handleError(closer, err, "Failed creating audit logger")
+	apiClient := newApiClient(auditLogger)
+
+	// the directory isn't needed, the identities carry the provider name
+	state, err := fetchEstafetteState(ctx, apiClient)
+	handleError(closer, err, "Failed fetching state")
+	state.provider, err = createProvider(ctx)
+	handleError(closer, err, "Failed creating provider")
+	closeProvider(ctx, state.provider)
+
+	duplicateGroups := findDuplicateGroups(state.groups, state.users, state.provider)
+	duplicateUsers := findDuplicateUsers(state.users, state.provider)
+	for _, line := range describeDuplicates(duplicateGroups, duplicateUsers) {
+		fmt.Println(line)
+	}
+
+	actions := planDuplicateRepair(duplicateGroups, state.users)
+	if len(actions) == 0 {
+		fmt.Println("No duplicate groups to merge")
+		handleError(closer, auditLogger.Close(ctx), "Failed closing audit log")
+		return
+	}
+	for _, a := range actions {
+		fmt.Println(a)
+	}
+	if *repairDryRun {
+		fmt.Printf("%v changes, not applied because of --dry-run\n", len(actions))
+		handleError(closer, auditLogger.Close(ctx), "Failed closing audit log")
+		return
+	}
+
+	applyCtx, cancel := shutdownSignalFromContext(ctx).bound(ctx)
+	defer cancel()
+	err = shutdownError(ctx, actions, apiClient.ApplyActions(applyCtx, state.token, actions))
+	auditErr := auditLogger.Close(ctx)
+	handleShutdown(closer, err, "Stopped repairing before applying all changes")
+	handleError(closer, err, "Failed repairing duplicates")
+	handleError(closer, auditErr, "Failed closing audit log")
+
+	logFromContext(ctx).Info().Msgf("Merged %v sets of duplicate groups with %v changes", len(duplicateGroups), len(actions))
+}
+
+// runCleanupIdentities cleans up the identities referencing deleted directory groups and users according to --policy, see findStaleIdentities
+func runCleanupIdentities(ctx context.Context, closer io.Closer, config *Config) {
+	ctx = contextWithRunID(ctx, newRunID())
+	auditLogger, err := NewAuditLogger(ctx, *auditLog, *auditLogSigningKeyFile, *triggeredBy)
+	handleError(closer, err, "Failed creating audit logger")
+	apiClient := newApiClient(auditLogger)
+
+	state, err := fetchState(ctx, apiClient)
+	handleError(closer, err, "Failed fetching state")
+	lookupProvider, ok := state.provider.(IdentityLookupProvider)
+	if !ok {
+		handleError(closer, fmt.Errorf("Provider %v can't look up groups and users by id", state.provider.Name()), "Failed finding stale identities")
+	}
+
+	options, err := getPlanOptions(config, state)
+	handleError(closer, err, "Failed finding stale identities")
+
+	stale, err := findStaleIdentities(ctx, state.groups, state.users, lookupProvider, state.groupMembers, options)
+	handleError(closer, err, "Failed finding stale identities")
+	for _, line := range describeStaleIdentities(stale) {
+		fmt.Println(line)
+	}
+
+	actions := planStaleIdentityCleanup(stale, state.provider, *cleanupIdentitiesPolicy)
+	if len(actions) == 0 {
+		fmt.Println("No stale identities to clean up")
+		handleError(closer, auditLogger.Close(ctx), "Failed closing audit log")
+		return
+	}
+	for _, a := range actions {
+		fmt.Println(a)
+	}
+	if *cleanupIdentitiesDryRun {
+		fmt.Printf("%v changes, not applied because of --dry-run\n", len(actions))
+		handleError(closer, auditLogger.Close(ctx), "Failed closing audit log")
+		return
+	}
+
+	applyCtx, cancel := shutdownSignalFromContext(ctx).bound(ctx)
+	defer cancel()
+	err = shutdownError(ctx, actions, apiClient.ApplyActions(applyCtx, state.token, actions))
+	auditErr := auditLogger.Close(ctx)
+	handleShutdown(closer, err, "Stopped cleaning up before applying all changes")
+	handleError(closer, err, "Failed cleaning up stale identities")
+	handleError(closer, auditErr, "Failed closing audit log")
+
+	logFromContext(ctx).Info().Msgf("Cleaned up %v stale identities with %v changes", len(stale), len(actions))
+}
+
+// runSimulate prints the estafette access adding --add to --to in the directory would grant, see simulateMembership
+func runSimulate(ctx context.Context, closer io.Closer, config *Config) {
+	state, err := fetchState(ctx, newApiClient(nil))
+	handleError(closer, err, "Failed fetching state")
+
+	options, err := getPlanOptions(config, state)
+	handleError(closer, err, "Failed simulating membership")
+
+	simulation, err := simulateMembership(state.groups, state.users, state.provider, state.groupMembers, state.directoryUsers, options, *simulateAdd, *simulateTo)
+	handleError(closer, err, "Failed simulating membership")
+
+	err = writeSimulation(os.Stdout, simulation)
+	handleError(closer, err, "Failed printing simulation")
+}
+
+// runAccessReview writes the access review report to --out, or prints it
+func runAccessReview(ctx context.Context, closer io.Closer) {
+	state, err := fetchState(ctx, newApiClient(nil))
+	handleError(closer, err, "Failed fetching state")
+
+	out := os.Stdout
+	if *accessReviewOut != "" {
+		out, err = os.Create(*accessReviewOut)
+		handleError(closer, err, "Failed creating access review file")
+		defer out.Close()
+	}
+
+	review := newAccessReview(state)
+	err = writeAccessReview(out, review, *accessReviewFormat)
+	handleError(closer, err, "Failed writing access review")
+
+	logFromContext(ctx).Info().Msgf("Reported %v grants of %v users", len(review.Grants), len(state.users))
+}
+
+// runTUI lets the operator explore the drift between the directory and estafette and apply the selected changes, recorded in the audit log like a sync
+func runTUI(ctx context.Context, closer io.Closer, config *Config) {
+	ctx = contextWithRunID(ctx, newRunID())
+	auditLogger, err := NewAuditLogger(ctx, *auditLog, *auditLogSigningKeyFile, *triggeredBy)
+	handleError(closer, err, "Failed creating audit logger")
+	apiClient := newApiClient(auditLogger)
+
+	state, err := fetchState(ctx, apiClient)
+	handleError(closer, err, "Failed fetching state")
+
+	actions, err := planState(ctx, config, state)
+	handleError(closer, err, "Failed planning changes")
+
+	explorer := newDriftExplorer(state, actions, func(ctx context.Context, actions []*Action) error {
+		err := checkGuardrails(ctx, config, state, actions, newStateRun(state))
+		if err != nil {
+			return err
+		}
+		applyCtx, cancel := shutdownSignalFromContext(ctx).bound(ctx)
+		defer cancel()
+		return shutdownError(ctx, actions, apiClient.ApplyActions(applyCtx, state.token, actions))
+	})
+	err = explorer.run(ctx, os.Stdin, os.Stdout)
+	auditErr := auditLogger.Close(ctx)
+	handleShutdown(closer, err, "Stopped applying before applying all selected changes")
+	handleError(closer, err, "Failed exploring drift")
+	handleError(closer, auditErr, "Failed closing audit log")
+}
+
+// runRollback reverses the changes of the sync with --run-id as recorded in the local audit log, after verifying its hash chain; the rollback is recorded in the audit log as a run of its own
+func runRollback(ctx context.Context, closer io.Closer) {
+	if *auditLog == "" || strings.HasPrefix(*auditLog, "gs://") || strings.HasPrefix(*auditLog, "bq://") {
+		handleError(closer, errors.New("flag --audit-log needs to be a local file to roll back from"), "Invalid configuration")
+	}
+
+	file, err := os.Open(*auditLog)
+	handleError(closer, err, "Failed opening audit log")
+	_, err = VerifyAuditLog(file, nil)
+	handleError(closer, err, "Audit log was tampered with")
+	_, err = file.Seek(0, io.SeekStart)
+	handleError(closer, err, "Failed reading audit log")
+	entries, err := readRunAuditEntries(file, *rollbackRunID)
+	file.Close()
+	handleError(closer, err, "Failed reading audit log")
+	if len(entries) == 0 {
+		handleError(closer, fmt.Errorf("no changes of run %v are recorded in %v", *rollbackRunID, *auditLog), "Nothing to roll back")
+	}
+
+	runID := newRunID()
+	ctx = contextWithRunID(ctx, runID)
+	logFromContext(ctx).Info().Msgf("Rolling back %v changes of run %v as run %v", len(entries), *rollbackRunID, runID)
+
+	auditLogger, err := NewAuditLogger(ctx, *auditLog, *auditLogSigningKeyFile, *triggeredBy)
+	handleError(closer, err, "Failed creating audit logger")
+
+	actions, err := rollbackRun(ctx, newApiClient(auditLogger), entries, *rollbackDryRun)
+	auditErr := auditLogger.Close(ctx)
+	handleError(closer, err, fmt.Sprintf("Failed rolling back run %v", *rollbackRunID))
+	handleError(closer, auditErr, "Failed closing audit log")
+
+	for _, a := range actions {
+		fmt.Println(a)
+	}
+	if *rollbackDryRun {
+		fmt.Printf("%v changes would roll back run %v\n", len(actions), *rollbackRunID)
+		return
+	}
+
+	logFromContext(ctx).Info().Msgf("Rolled back run %v with %v changes", *rollbackRunID, len(actions))
+}
+
+// runVerifyAuditLog verifies the local audit log and exits with an error if any entry was altered, removed or inserted
+func runVerifyAuditLog(closer io.Closer) {
+	if *auditLog == "" || strings.HasPrefix(*auditLog, "gs://") || strings.HasPrefix(*auditLog, "bq://") {
+		handleError(closer, errors.New("flag --audit-log needs to be a local file to verify"), "Invalid configuration")
+	}
+
+	var publicKey ed25519.PublicKey
+	if *verifyAuditLogPublicKeyFile != "" {
+		var err error
+		publicKey, err = readAuditPublicKey(*verifyAuditLogPublicKeyFile)
+		handleError(closer, err, "Invalid configuration")
+	}
+
+	file, err := os.Open(*auditLog)
+	handleError(closer, err, "Failed opening audit log")
+	defer file.Close()
+
+	verification, err := VerifyAuditLog(file, publicKey)
+	handleError(closer, err, "Audit log was tampered with")
+
+	log.Info().Msgf("Verified %v audit log entries in %v, closed by %v signed run summaries; %v entries after the last signed summary are only covered by the hash chain", verification.Entries, *auditLog, verification.SignedRuns, verification.UnsignedEntries)
+}
+
+// runValidate checks whether the configuration and credentials are valid and the apis are reachable, with a remediation for every failed check
+func runValidate(ctx context.Context, closer io.Closer, apiClient ApiClient) {
+	checks := runPreflight(ctx, apiClient, true)
+	logPreflight(checks)
+	handleError(closer, preflightFailed(checks), "Invalid configuration")
+
+	logFromContext(ctx).Info().Msg("Configuration is valid")
+}
+
+// runExport writes the current directory and estafette state and their linkage to files for audits
+func runExport(ctx context.Context, closer io.Closer, apiClient ApiClient) {
+	state, err := fetchState(ctx, apiClient)
+	handleError(closer, err, "Failed fetching state")
+
+	err = exportState(ctx, state, *exportFormat, *exportOutputDir)
+	handleError(closer, err, "Failed exporting state")
+
+	logFromContext(ctx).Info().Msgf("Exported state as %v to %v", *exportFormat, *exportOutputDir)
+}
+
+// newApiClient returns an ApiClient configured with the api flags, recording mutations with the audit logger if not nil
+func newApiClient(auditLogger AuditLogger) ApiClient {
+	return NewApiClient(*apiBaseURL, auditLogger, ApiClientOptions{
+		Timeout:                *apiTimeout,
+		MaxRetries:             *apiRetries,
+		Backoff:                *apiBackoff,
+		BreakerFailures:        *apiBreakerFailures,
+		BreakerCooldown:        *apiBreakerCooldown,
+		UsePatch:               *apiPatch,
+		UseIfMatch:             *apiIfMatch,
+		UseMembershipEndpoints: *apiMemberships,
+		UnknownFields:          *apiUnknownFields,
+		PageSize:               *apiPageSize,
+		Concurrency:            *apiConcurrency,
+		Faults:                 newFaultInjector(*faultInjectionRate, time.Now().UnixNano()),
+		HTTPLog:                newHTTPLogger(*logHTTP, *logHTTPBodies),
+	})
+}
+
+// validateProviderFlags checks the flags that are required for the selected provider
+func validateProviderFlags(jaegerCloser io.Closer) {
+	if *gsuiteSyncResourceHierarchy && *provider != gsuiteProviderName {
+		handleError(jaegerCloser, errors.New("flag --gsuite-sync-resource-hierarchy is only supported by the gsuite provider"), "Invalid configuration")
+	}
+
+	validateNamedProviderFlags(jaegerCloser, *provider)
+
+	if *shadowProvider != "" {
+		if *shadowProvider == *provider {
+			handleError(jaegerCloser, fmt.Errorf("flag --shadow-provider %v has to differ from --provider", *shadowProvider), "Invalid configuration")
+		}
+		validateNamedProviderFlags(jaegerCloser, *shadowProvider)
+	}
+}
+
+// validateNamedProviderFlags checks the flags that are required for the provider with the name
+func validateNamedProviderFlags(jaegerCloser io.Closer, name string) {
+	switch name {
+	case gsuiteProviderName:
+		if (*gsuiteDomain == "" && len(*gsuiteCustomerIDs) == 0) || len(gsuiteAdminEmails()) == 0 || len(*gsuiteGroupPrefixes) == 0 {
+			handleError(jaegerCloser, errors.New("flags --gsuite-domain or --gsuite-customer-id, --gsuite-admin-email and --gsuite-group-prefix are required"), "Invalid gsuite configuration")
+		}
+		if len(*gsuiteGroupDomains) > 0 && len(*gsuiteCustomerIDs) == 0 {
+			handleError(jaegerCloser, errors.New("flag --gsuite-group-domain only applies when listing by --gsuite-customer-id"), "Invalid gsuite configuration")
+		}
+		for property, field := range *gsuiteUserAttributeMapping {
+			if len(strings.SplitN(field, ".", 2)) != 2 {
+				handleError(jaegerCloser, fmt.Errorf("flag --gsuite-user-attribute-mapping %v=%v is not of the form property=Schema.Field", property, field), "Invalid gsuite configuration")
+			}
+		}
+	case ldapProviderName:
+		if *ldapURL == "" || *ldapBaseDN == "" {
+			handleError(jaegerCloser, errors.New("flags --ldap-url and --ldap-base-dn are required"), "Invalid ldap configuration")
+		}
+	case githubProviderName:
+		if *githubOrganization == "" || *githubToken == "" {
+			handleError(jaegerCloser, errors.New("flags --github-organization and --github-token are required"), "Invalid github configuration")
+		}
+	case pluginProviderName:
+		if *pluginPath == "" {
+			handleError(jaegerCloser, errors.New("flag --plugin-path is required"), "Invalid plugin configuration")
+		}
+	}
 }
 
 func handleError(jaegerCloser io.Closer, err error, message string) {
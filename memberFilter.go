@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// serviceAccountDomain is the email domain of gcp service accounts, which can be members of gsuite groups
+const serviceAccountDomain = "gserviceaccount.com"
+
+// memberFilter keeps bots, shared mailboxes and service accounts out of the synchronized memberships, so only humans get estafette group memberships
+type memberFilter struct {
+	// include are glob patterns for the email or id of the only members to keep; if empty all members are kept unless excluded
+	include []string
+	// exclude are glob patterns for the email or id of members to drop, for example *-bot@*
+	exclude                []string
+	excludeServiceAccounts bool
+}
+
+// newMemberFilter validates the patterns and returns a memberFilter, or nil if nothing gets filtered
+func newMemberFilter(include, exclude []string, excludeServiceAccounts bool) (*memberFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 && !excludeServiceAccounts {
+		return nil, nil
+	}
+
+	f := &memberFilter{excludeServiceAccounts: excludeServiceAccounts}
+	for _, p := range include {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("Invalid member pattern %v: %w", p, err)
+		}
+		f.include = append(f.include, strings.ToLower(p))
+	}
+	for _, p := range exclude {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("Invalid member pattern %v: %w", p, err)
+		}
+		f.exclude = append(f.exclude, strings.ToLower(p))
+	}
+
+	return f, nil
+}
+
+// allows checks whether the member with email and id is kept; a nil memberFilter keeps all members
+func (f *memberFilter) allows(email, id string) bool {
+	if f == nil {
+		return true
+	}
+
+	email = strings.ToLower(email)
+	if f.excludeServiceAccounts && strings.HasSuffix(email, "."+serviceAccountDomain) {
+		return false
+	}
+	if matchesAnyPattern(f.exclude, email, id) {
+		return false
+	}
+
+	return len(f.include) == 0 || matchesAnyPattern(f.include, email, id)
+}
+
+// filterGroupMembers returns the directory groups with only the members the filter keeps
+func (f *memberFilter) filterGroupMembers(groupMembers map[*DirectoryGroup][]*DirectoryMember) map[*DirectoryGroup][]*DirectoryMember {
+	if f == nil {
+		return groupMembers
+	}
+
+	filtered := make(map[*DirectoryGroup][]*DirectoryMember, len(groupMembers))
+	for gg, members := range groupMembers {
+		filtered[gg] = f.filterMembers(members)
+	}
+
+	return filtered
+}
+
+// filterMembers returns the members the filter keeps
+func (f *memberFilter) filterMembers(members []*DirectoryMember) []*DirectoryMember {
+	if f == nil {
+		return members
+	}
+
+	filtered := make([]*DirectoryMember, 0, len(members))
+	for _, m := range members {
+		if f.allows(m.Email, m.ID) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered
+}
+
+// filterDirectoryUsers returns the directory users the filter keeps, so excluded users don't get their profile or properties updated either
+func (f *memberFilter) filterDirectoryUsers(directoryUsers []*DirectoryUser) []*DirectoryUser {
+	if f == nil || directoryUsers == nil {
+		return directoryUsers
+	}
+
+	filtered := make([]*DirectoryUser, 0, len(directoryUsers))
+	for _, u := range directoryUsers {
+		if f.allows(u.Email, u.ID) {
+			filtered = append(filtered, u)
+		}
+	}
+
+	return filtered
+}
+
+func matchesAnyPattern(patterns []string, email, id string) bool {
+	for _, p := range patterns {
+		if email != "" {
+			if matched, _ := path.Match(p, email); matched {
+				return true
+			}
+		}
+		if id != "" {
+			if matched, _ := path.Match(p, id); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
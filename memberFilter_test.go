@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemberFilter(t *testing.T) {
+	t.Run("ReturnsNilIfNothingIsFiltered", func(t *testing.T) {
+
+		// act
+		filter, err := newMemberFilter([]string{}, nil, false)
+
+		assert.Nil(t, err)
+		assert.Nil(t, filter)
+		assert.True(t, filter.allows("ci-bot@example.com", "1234"))
+	})
+
+	t.Run("DropsExcludedMembersAndServiceAccounts", func(t *testing.T) {
+
+		filter, err := newMemberFilter([]string{"*@example.com", "*.gserviceaccount.com"}, []string{"*-bot@*", "shared-*"}, true)
+		assert.Nil(t, err)
+
+		// act
+		members := filter.filterMembers([]*DirectoryMember{
+			{ID: "1", Email: "John@Example.com"},
+			{ID: "2", Email: "deploy-bot@example.com"},
+			{ID: "3", Email: "shared-mailbox@example.com"},
+			{ID: "4", Email: "ci@project.iam.gserviceaccount.com"},
+			{ID: "5", Email: "jane@partner.com"},
+		})
+
+		if assert.Equal(t, 1, len(members)) {
+			assert.Equal(t, "1", members[0].ID)
+		}
+	})
+
+	t.Run("ReturnsErrorForInvalidPattern", func(t *testing.T) {
+
+		// act
+		_, err := newMemberFilter(nil, []string{"[bot"}, false)
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestPlanGroupsAndMembersWithMemberFilter(t *testing.T) {
+	t.Run("DoesNotAddExcludedMembersToGroups", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}}},
+			{ID: "u2", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "5678", Email: "deploy-bot@example.com"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234", Email: "john@example.com"}, {ID: "5678", Email: "deploy-bot@example.com"}},
+		}
+		filter, err := newMemberFilter(nil, []string{"*-bot@*"}, false)
+		assert.Nil(t, err)
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, memberFilter: filter})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, "u1", actions[0].User.ID)
+		}
+	})
+}
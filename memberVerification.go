@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// verifyMembers returns the directory groups with only the members that are users of the directory domain according to its users api, and the members that couldn't be verified; an email that merely ends in the domain isn't enough, so external accounts and lookalike domains don't get estafette group memberships
+func verifyMembers(groupMembers map[*DirectoryGroup][]*DirectoryMember, directoryUsers []*DirectoryUser) (verified map[*DirectoryGroup][]*DirectoryMember, unverified map[*DirectoryGroup][]*DirectoryMember) {
+
+	usersByID := map[string]bool{}
+	usersByEmail := map[string]bool{}
+	for _, u := range directoryUsers {
+		if u.ID != "" {
+			usersByID[u.ID] = true
+		}
+		if u.Email != "" {
+			usersByEmail[strings.ToLower(u.Email)] = true
+		}
+	}
+
+	verified = make(map[*DirectoryGroup][]*DirectoryMember, len(groupMembers))
+	unverified = map[*DirectoryGroup][]*DirectoryMember{}
+	for gg, members := range groupMembers {
+		verified[gg] = make([]*DirectoryMember, 0, len(members))
+		for _, m := range members {
+			// the id is authoritative, the email is only used for members without one
+			if (m.ID != "" && usersByID[m.ID]) || (m.ID == "" && usersByEmail[strings.ToLower(m.Email)]) {
+				verified[gg] = append(verified[gg], m)
+			} else {
+				unverified[gg] = append(unverified[gg], m)
+			}
+		}
+	}
+
+	return
+}
+
+// verifyStateMembers drops the members that aren't users of the directory domain from the state, logging each of them so they can be looked into
+func verifyStateMembers(s state) (state, error) {
+	if s.directoryUsers == nil {
+		return s, fmt.Errorf("--verify-member-emails needs the %v provider to list its users, which it doesn't support", s.provider.Name())
+	}
+
+	verified, unverified := verifyMembers(s.groupMembers, s.directoryUsers)
+	for gg, members := range unverified {
+		for _, m := range members {
+			log.Warn().Msgf("Skipping member %v of %v group %v, it isn't a user of the directory domain", m.Email, s.provider.Name(), gg.Name)
+		}
+	}
+	s.groupMembers = verified
+
+	return s, nil
+}
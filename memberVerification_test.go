@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyMembers(t *testing.T) {
+	t.Run("KeepsOnlyMembersThatAreDirectoryUsers", func(t *testing.T) {
+
+		group := &DirectoryGroup{ID: "ci-platform@example.com", Name: "ci-platform"}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			group: {
+				{ID: "1234", Email: "john@example.com"},
+				{ID: "9999", Email: "jane@example.com"},
+				{Email: "Bob@Example.com"},
+				{Email: "john@examp1e.com"},
+			},
+		}
+		directoryUsers := []*DirectoryUser{
+			{ID: "1234", Email: "john@example.com"},
+			{ID: "5678", Email: "jane@example.com"},
+			{ID: "4321", Email: "bob@example.com"},
+		}
+
+		// act
+		verified, unverified := verifyMembers(groupMembers, directoryUsers)
+
+		if assert.Equal(t, 2, len(verified[group])) {
+			assert.Equal(t, "john@example.com", verified[group][0].Email)
+			assert.Equal(t, "Bob@Example.com", verified[group][1].Email)
+		}
+		if assert.Equal(t, 2, len(unverified[group])) {
+			assert.Equal(t, "jane@example.com", unverified[group][0].Email)
+			assert.Equal(t, "john@examp1e.com", unverified[group][1].Email)
+		}
+	})
+}
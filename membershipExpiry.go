@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// expiryMargin is added to the next membership expiry when scheduling a sync for it, so the expiry has passed once the sync fetches the members
+const expiryMargin = 5 * time.Second
+
+// activeMembers returns the members whose membership didn't expire yet; the directory can still list expired members for a while, but their access should end on time
+func activeMembers(group *DirectoryGroup, members []*DirectoryMember, providerName string, now time.Time) []*DirectoryMember {
+
+	active := make([]*DirectoryMember, 0, len(members))
+	for _, m := range members {
+		if m.ExpireTime != nil && !now.Before(*m.ExpireTime) {
+			log.Info().Msgf("Skipping member %v of %v group %v, the membership expired at %v", m.Email, providerName, group.Name, m.ExpireTime.Format(time.RFC3339))
+			continue
+		}
+		active = append(active, m)
+	}
+
+	return active
+}
+
+// dropExpiredMembers returns the directory groups with only the members whose membership didn't expire yet
+func dropExpiredMembers(groupMembers map[*DirectoryGroup][]*DirectoryMember, providerName string, now time.Time) map[*DirectoryGroup][]*DirectoryMember {
+
+	active := make(map[*DirectoryGroup][]*DirectoryMember, len(groupMembers))
+	for gg, members := range groupMembers {
+		active[gg] = activeMembers(gg, members, providerName, now)
+	}
+
+	return active
+}
+
+// earliestExpiry returns the earliest of next and the expirations of the members, or nil if there's none
+func earliestExpiry(next *time.Time, members []*DirectoryMember) *time.Time {
+	for _, m := range members {
+		if m.ExpireTime != nil && (next == nil || m.ExpireTime.Before(*next)) {
+			next = m.ExpireTime
+		}
+	}
+
+	return next
+}
+
+// nextMembershipExpiry returns the earliest expiration of the memberships of all directory groups, or nil if there's none
+func nextMembershipExpiry(groupMembers map[*DirectoryGroup][]*DirectoryMember) (next *time.Time) {
+	for _, members := range groupMembers {
+		next = earliestExpiry(next, members)
+	}
+
+	return
+}
+
+// untilNextSync returns the interval, or the time until the next membership expiry of the run if it's sooner, so time-bound access is removed when it expires instead of up to an interval later
+func untilNextSync(interval time.Duration, run *SyncRun, now time.Time) time.Duration {
+	if run == nil || run.NextMembershipExpiry == nil {
+		return interval
+	}
+
+	untilExpiry := run.NextMembershipExpiry.Sub(now) + expiryMargin
+	if untilExpiry < expiryMargin {
+		untilExpiry = expiryMargin
+	}
+	if untilExpiry < interval {
+		return untilExpiry
+	}
+
+	return interval
+}
+
+// trackedMembershipExpiry is the next membership expiry kept in the state store between runs
+type trackedMembershipExpiry struct {
+	NextMembershipExpiry *time.Time `json:"nextMembershipExpiry,omitempty"`
+}
+
+// trackMembershipExpiry records the next membership expiry of the run in the state store, so the sync removing it stays scheduled across restarts; a failed run might not have fetched the directory, so it's scheduled for the tracked expiry if that's sooner and still ahead. It does nothing if path is empty
+func trackMembershipExpiry(ctx context.Context, path string, run *SyncRun, now time.Time) error {
+	if path == "" || run == nil {
+		return nil
+	}
+
+	if run.Err != nil {
+		data, err := getStateStore().Read(ctx, path)
+		if err != nil && !errors.Is(err, ErrStateNotFound) {
+			return fmt.Errorf("Failed reading membership expiry %v: %w", path, err)
+		}
+		if err == nil {
+			var tracked trackedMembershipExpiry
+			if err = json.Unmarshal(data, &tracked); err != nil {
+				return fmt.Errorf("Failed unmarshalling membership expiry %v: %w", path, err)
+			}
+			next := tracked.NextMembershipExpiry
+			if next != nil && next.After(now) && (run.NextMembershipExpiry == nil || next.Before(*run.NextMembershipExpiry)) {
+				run.NextMembershipExpiry = next
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(&trackedMembershipExpiry{NextMembershipExpiry: run.NextMembershipExpiry}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = getStateStore().Write(ctx, path, data)
+	if err != nil {
+		return fmt.Errorf("Failed writing membership expiry %v: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveMembers(t *testing.T) {
+	t.Run("SkipsMembersWhoseMembershipExpired", func(t *testing.T) {
+
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		expired := now.Add(-time.Second)
+		expiring := now.Add(time.Hour)
+		members := []*DirectoryMember{
+			{ID: "1", Email: "john@example.com"},
+			{ID: "2", Email: "jane@example.com", ExpireTime: &expired},
+			{ID: "3", Email: "joe@example.com", ExpireTime: &expiring},
+		}
+
+		// act
+		active := activeMembers(&DirectoryGroup{Name: "team"}, members, gsuiteProviderName, now)
+
+		assert.Equal(t, []*DirectoryMember{members[0], members[2]}, active)
+		assert.Equal(t, &expiring, earliestExpiry(nil, active))
+	})
+}
+
+func TestUntilNextSync(t *testing.T) {
+	t.Run("ReturnsIntervalWithoutExpiringMemberships", func(t *testing.T) {
+
+		// act
+		wait := untilNextSync(time.Hour, &SyncRun{}, time.Now())
+
+		assert.Equal(t, time.Hour, wait)
+	})
+
+	t.Run("ReturnsTimeUntilMembershipExpiresIfSooner", func(t *testing.T) {
+
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		expiry := now.Add(10 * time.Minute)
+
+		// act
+		wait := untilNextSync(time.Hour, &SyncRun{NextMembershipExpiry: &expiry}, now)
+
+		assert.Equal(t, 10*time.Minute+expiryMargin, wait)
+	})
+
+	t.Run("ReturnsIntervalIfMembershipExpiresLater", func(t *testing.T) {
+
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		expiry := now.Add(2 * time.Hour)
+
+		// act
+		wait := untilNextSync(time.Hour, &SyncRun{NextMembershipExpiry: &expiry}, now)
+
+		assert.Equal(t, time.Hour, wait)
+	})
+}
+
+func TestTrackMembershipExpiry(t *testing.T) {
+	t.Run("SchedulesFailedRunForExpiryTrackedBySuccessfulRun", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "expiry")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "membership-expiry.json")
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		expiry := now.Add(10 * time.Minute)
+		ctx := context.Background()
+		assert.Nil(t, trackMembershipExpiry(ctx, path, &SyncRun{NextMembershipExpiry: &expiry}, now))
+		failedRun := &SyncRun{Err: errors.New("estafette is down")}
+
+		// act
+		err = trackMembershipExpiry(ctx, path, failedRun, now.Add(time.Minute))
+
+		assert.Nil(t, err)
+		if assert.NotNil(t, failedRun.NextMembershipExpiry) {
+			assert.True(t, expiry.Equal(*failedRun.NextMembershipExpiry))
+		}
+	})
+
+	t.Run("ClearsTrackedExpiryAfterSuccessfulRunWithoutExpiringMemberships", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "expiry")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "membership-expiry.json")
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		expiry := now.Add(10 * time.Minute)
+		ctx := context.Background()
+		assert.Nil(t, trackMembershipExpiry(ctx, path, &SyncRun{NextMembershipExpiry: &expiry}, now))
+		assert.Nil(t, trackMembershipExpiry(ctx, path, &SyncRun{}, now))
+		failedRun := &SyncRun{Err: errors.New("estafette is down")}
+
+		// act
+		err = trackMembershipExpiry(ctx, path, failedRun, now)
+
+		assert.Nil(t, err)
+		assert.Nil(t, failedRun.NextMembershipExpiry)
+	})
+
+	t.Run("IgnoresTrackedExpiryThatPassedAlready", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "expiry")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "membership-expiry.json")
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		expiry := now.Add(10 * time.Minute)
+		ctx := context.Background()
+		assert.Nil(t, trackMembershipExpiry(ctx, path, &SyncRun{NextMembershipExpiry: &expiry}, now))
+		failedRun := &SyncRun{Err: errors.New("estafette is down")}
+
+		// act
+		err = trackMembershipExpiry(ctx, path, failedRun, now.Add(time.Hour))
+
+		assert.Nil(t, err)
+		assert.Nil(t, failedRun.NextMembershipExpiry)
+	})
+}
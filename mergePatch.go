@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// createMergePatch returns the json merge patch (rfc 7386) that turns the json representation of before into the one of after; arrays are replaced as a whole
+func createMergePatch(before, after interface{}) ([]byte, error) {
+
+	beforeMap, err := toJSONMap(before)
+	if err != nil {
+		return nil, err
+	}
+	afterMap, err := toJSONMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(diffJSONMaps(beforeMap, afterMap))
+}
+
+// toJSONMap marshals the value to json and back into a generic map, so it can be compared field by field
+func toJSONMap(value interface{}) (m map[string]interface{}, err error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+
+	m = map[string]interface{}{}
+	err = json.Unmarshal(data, &m)
+
+	return
+}
+
+func diffJSONMaps(before, after map[string]interface{}) map[string]interface{} {
+
+	patch := map[string]interface{}{}
+
+	for key, afterValue := range after {
+		beforeValue, ok := before[key]
+		if !ok {
+			patch[key] = afterValue
+			continue
+		}
+
+		beforeObject, beforeIsObject := beforeValue.(map[string]interface{})
+		afterObject, afterIsObject := afterValue.(map[string]interface{})
+		if beforeIsObject && afterIsObject {
+			if nested := diffJSONMaps(beforeObject, afterObject); len(nested) > 0 {
+				patch[key] = nested
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(beforeValue, afterValue) {
+			patch[key] = afterValue
+		}
+	}
+
+	// fields left out of after are removed by setting them to null
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			patch[key] = nil
+		}
+	}
+
+	return patch
+}
+
+// applyMergePatch applies the json merge patch to the target in place and returns it
+func applyMergePatch(target, patch map[string]interface{}) map[string]interface{} {
+
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+
+		patchObject, patchIsObject := value.(map[string]interface{})
+		targetObject, targetIsObject := target[key].(map[string]interface{})
+		if patchIsObject && targetIsObject {
+			target[key] = applyMergePatch(targetObject, patchObject)
+			continue
+		}
+
+		target[key] = value
+	}
+
+	return target
+}
+
+// rebaseUpdate applies the changes from before to after on top of current, which is the entity as it is now
+func rebaseUpdate(before, after interface{}, current map[string]interface{}) (rebased map[string]interface{}, err error) {
+
+	beforeMap, err := toJSONMap(before)
+	if err != nil {
+		return
+	}
+	afterMap, err := toJSONMap(after)
+	if err != nil {
+		return
+	}
+	rebased, err = toJSONMap(current)
+	if err != nil {
+		return
+	}
+
+	return applyMergePatch(rebased, diffJSONMaps(beforeMap, afterMap)), nil
+}
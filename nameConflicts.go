@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	foundation "github.com/estafette/estafette-foundation"
+)
+
+const (
+	// nameConflictSkip keeps the name for the first directory group and doesn't create or rename groups for the others
+	nameConflictSkip = "skip"
+	// nameConflictSuffix keeps the name for the first directory group and suffixes the names for the others with their directory id
+	nameConflictSuffix = "suffix"
+	// nameConflictMerge adds the identities of the other directory groups to the group of the first, so it gets the members of all of them
+	nameConflictMerge = "merge"
+)
+
+// NameConflict is an estafette group name claimed by multiple directory groups
+type NameConflict struct {
+	Name string `json:"name"`
+	// DirectoryGroupIDs are the ids of the directory groups claiming the name; the first one keeps it
+	DirectoryGroupIDs []string `json:"directoryGroupIDs"`
+	Resolution        string   `json:"resolution"`
+
+	// linked holds the directory group ids that have an estafette group already, those are never merged
+	linked map[string]bool
+}
+
+// String returns a human-readable description of the conflict
+func (c *NameConflict) String() string {
+	return fmt.Sprintf("directory groups %v map to estafette group %v, resolved by %v", strings.Join(c.DirectoryGroupIDs, ", "), c.Name, c.Resolution)
+}
+
+// detectNameConflicts returns the estafette group names claimed by more than one directory group, sorted by name.
+//
+// A directory group claims the name it maps to if it has members or an estafette group already, or if empty groups are synchronized; directory groups that are gone claim the name of their estafette group.
+// Directory groups whose estafette group already holds the name come first, so resolving a conflict never renames an existing group.
+func detectNameConflicts(groups []*contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember, options planOptions) (conflicts []*NameConflict) {
+
+	inDirectory := map[string]bool{}
+	for gg := range groupMembers {
+		inDirectory[gg.ID] = true
+	}
+
+	// estafette groups and their names by the directory group id they're linked to
+	linkedGroups := map[string]*contracts.Group{}
+	linkedNames := map[string]string{}
+	for _, g := range groups {
+		for _, i := range g.Identities {
+			if i.Provider == provider.Name() {
+				linkedGroups[i.ID] = g
+				linkedNames[i.ID] = g.Name
+			}
+		}
+	}
+
+	claims := map[string][]string{}
+	claim := func(name, id string) {
+		if !foundation.StringArrayContains(claims[name], id) {
+			claims[name] = append(claims[name], id)
+		}
+	}
+
+	for id, name := range linkedNames {
+		if !inDirectory[id] || !options.manages(managedFieldName) {
+			claim(name, id)
+		}
+	}
+	for gg, members := range groupMembers {
+		_, linked := linkedNames[gg.ID]
+		if (len(members) > 0 || linked || options.syncEmptyGroups) && options.manages(managedFieldName) {
+			claim(options.transformGroupName(gg), gg.ID)
+		}
+	}
+
+	conflicts = make([]*NameConflict, 0)
+	for name, ids := range claims {
+		// directory groups merged into a single estafette group earlier don't conflict with each other
+		claimants := map[interface{}]bool{}
+		for _, id := range ids {
+			if g, ok := linkedGroups[id]; ok {
+				claimants[g] = true
+			} else {
+				claimants[id] = true
+			}
+		}
+		if len(claimants) < 2 {
+			continue
+		}
+
+		sort.Slice(ids, func(i, j int) bool {
+			holdsI, holdsJ := linkedNames[ids[i]] == name, linkedNames[ids[j]] == name
+			if holdsI != holdsJ {
+				return holdsI
+			}
+			return ids[i] < ids[j]
+		})
+
+		resolution := options.nameConflictResolution
+		if resolution == "" {
+			resolution = nameConflictSkip
+		}
+
+		linked := map[string]bool{}
+		for _, id := range ids {
+			if _, ok := linkedNames[id]; ok {
+				linked[id] = true
+			}
+		}
+
+		conflicts = append(conflicts, &NameConflict{
+			Name:              name,
+			DirectoryGroupIDs: ids,
+			Resolution:        resolution,
+			linked:            linked,
+		})
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Name < conflicts[j].Name
+	})
+
+	return
+}
+
+// withNameConflicts returns the options with the resolutions of the conflicts applied to the directory groups losing the name
+func (o planOptions) withNameConflicts(conflicts []*NameConflict) planOptions {
+
+	o.nameOverrides = map[string]string{}
+	o.skippedGroups = map[string]bool{}
+	o.mergedGroups = map[string]string{}
+
+	for _, c := range conflicts {
+		winner := c.DirectoryGroupIDs[0]
+		for _, id := range c.DirectoryGroupIDs[1:] {
+			switch c.Resolution {
+			case nameConflictSuffix:
+				o.nameOverrides[id] = fmt.Sprintf("%v (%v)", c.Name, id)
+			case nameConflictMerge:
+				// groups that exist already can't be merged without losing their settings, so they're only kept from renaming
+				if c.linked[id] {
+					o.skippedGroups[id] = true
+				} else {
+					o.mergedGroups[id] = winner
+				}
+			default:
+				o.skippedGroups[id] = true
+			}
+		}
+	}
+
+	return o
+}
+
+// keepsName checks whether the estafette group of the directory group keeps its current name, because another directory group won the name it maps to
+func (o planOptions) keepsName(directoryGroupID string) bool {
+	_, merged := o.mergedGroups[directoryGroupID]
+	return o.skippedGroups[directoryGroupID] || merged
+}
+
+// mergedIdentities returns the identities of the directory groups merged into the group of the winning directory group
+func (o planOptions) mergedIdentities(provider Provider, winnerID string, groupMembers map[*DirectoryGroup][]*DirectoryMember) (identities []*contracts.GroupIdentity) {
+
+	identities = make([]*contracts.GroupIdentity, 0)
+	for gg := range groupMembers {
+		if winner, ok := o.mergedGroups[gg.ID]; ok && winner == winnerID {
+			identities = append(identities, &contracts.GroupIdentity{
+				Provider: provider.Name(),
+				ID:       gg.ID,
+				Name:     gg.Name,
+			})
+		}
+	}
+
+	sort.Slice(identities, func(i, j int) bool {
+		return identities[i].ID < identities[j].ID
+	})
+
+	return
+}
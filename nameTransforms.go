@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// NameTransform is a single step in turning a directory group name into an estafette group name; exactly one of its fields is set
+type NameTransform struct {
+	StripPrefix string            `yaml:"stripPrefix,omitempty"`
+	StripSuffix string            `yaml:"stripSuffix,omitempty"`
+	Replace     *ReplaceTransform `yaml:"replace,omitempty"`
+	// TitleCase upper-cases the first letter of every space-separated word
+	TitleCase bool `yaml:"titleCase,omitempty"`
+	// Namespace is prepended to the name, separated by a slash
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// ReplaceTransform replaces all matches of the regular expression Pattern with With, which can refer to submatches as $1
+type ReplaceTransform struct {
+	Pattern string `yaml:"pattern"`
+	With    string `yaml:"with"`
+}
+
+// nameTransformFunc applies a compiled NameTransform
+type nameTransformFunc func(name string) string
+
+// compileNameTransforms validates the transforms and compiles them into functions applied in the configured order
+func compileNameTransforms(transforms []*NameTransform) (funcs []nameTransformFunc, err error) {
+
+	funcs = make([]nameTransformFunc, 0, len(transforms))
+
+	for i, t := range transforms {
+		if t == nil {
+			return nil, fmt.Errorf("Name transform %v is empty", i+1)
+		}
+
+		set := 0
+		var f nameTransformFunc
+
+		if t.StripPrefix != "" {
+			set++
+			prefix := t.StripPrefix
+			f = func(name string) string { return strings.TrimPrefix(name, prefix) }
+		}
+		if t.StripSuffix != "" {
+			set++
+			suffix := t.StripSuffix
+			f = func(name string) string { return strings.TrimSuffix(name, suffix) }
+		}
+		if t.Replace != nil {
+			set++
+			pattern, err := regexp.Compile(t.Replace.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Name transform %v has invalid pattern %v: %w", i+1, t.Replace.Pattern, err)
+			}
+			with := t.Replace.With
+			f = func(name string) string { return pattern.ReplaceAllString(name, with) }
+		}
+		if t.TitleCase {
+			set++
+			f = titleCase
+		}
+		if t.Namespace != "" {
+			set++
+			namespace := t.Namespace
+			f = func(name string) string { return namespace + "/" + name }
+		}
+
+		if set != 1 {
+			return nil, fmt.Errorf("Name transform %v has to set exactly one of stripPrefix, stripSuffix, replace, titleCase or namespace", i+1)
+		}
+
+		funcs = append(funcs, f)
+	}
+
+	return funcs, nil
+}
+
+// titleCase upper-cases the first letter of every space-separated word and leaves the other letters as they are
+func titleCase(name string) string {
+	runes := []rune(name)
+	for i := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(runes[i])
+		}
+	}
+
+	return string(runes)
+}
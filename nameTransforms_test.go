@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCompileNameTransforms(t *testing.T) {
+	t.Run("AppliesTransformsInOrder", func(t *testing.T) {
+
+		var config Config
+		err := yaml.UnmarshalStrict([]byte(`
+nameTransforms:
+- stripSuffix: -group
+- replace:
+    pattern: "[-_]+"
+    with: " "
+- titleCase: true
+- namespace: gsuite
+`), &config)
+		assert.Nil(t, err)
+
+		// act
+		transforms, err := compileNameTransforms(config.NameTransforms)
+
+		assert.Nil(t, err)
+		options := planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, nameTransforms: transforms}
+		assert.Equal(t, "gsuite/Team Platform", options.groupName(&DirectoryGroup{Name: "ci-team-platform-group"}))
+		assert.Equal(t, "gsuite/Release Managers", options.groupName(&DirectoryGroup{Name: "ci-release_managers"}))
+	})
+
+	t.Run("ReturnsErrorIfTransformSetsMultipleFields", func(t *testing.T) {
+
+		// act
+		_, err := compileNameTransforms([]*NameTransform{{StripPrefix: "team-", TitleCase: true}})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidPattern", func(t *testing.T) {
+
+		// act
+		_, err := compileNameTransforms([]*NameTransform{{Replace: &ReplaceTransform{Pattern: "team-("}}})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestGroupName(t *testing.T) {
+	t.Run("FallsBackToNameWithoutPrefixIfTransformsLeaveNothing", func(t *testing.T) {
+
+		options := planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, nameTransforms: []nameTransformFunc{func(name string) string { return "" }}}
+
+		// act
+		name := options.groupName(&DirectoryGroup{Name: "ci-platform"})
+
+		assert.Equal(t, "platform", name)
+	})
+}
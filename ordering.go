@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sort"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// sortedDirectoryGroups returns the directory groups ordered by id, so iterating them doesn't depend on the random order of the map and repeated runs plan the same actions in the same order
+func sortedDirectoryGroups(groupMembers map[*DirectoryGroup][]*DirectoryMember) []*DirectoryGroup {
+	groups := make([]*DirectoryGroup, 0, len(groupMembers))
+	for gg := range groupMembers {
+		groups = append(groups, gg)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].ID != groups[j].ID {
+			return groups[i].ID < groups[j].ID
+		}
+		return groups[i].Email < groups[j].Email
+	})
+
+	return groups
+}
+
+// sortDirectoryMembers orders the members of every directory group by email and id in place, since providers return them in the order they were fetched in
+func sortDirectoryMembers(groupMembers map[*DirectoryGroup][]*DirectoryMember) {
+	for _, members := range groupMembers {
+		sort.SliceStable(members, func(i, j int) bool {
+			if members[i].Email != members[j].Email {
+				return members[i].Email < members[j].Email
+			}
+			return members[i].ID < members[j].ID
+		})
+	}
+}
+
+// sortEstafetteState orders the fetched groups by name and the users by id, and the roles, organizations and groups within them, in place, so comparing them and writing them back doesn't depend on the order estafette returned them in
+func sortEstafetteState(groups []*contracts.Group, users []*contracts.User) {
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Name != groups[j].Name {
+			return groups[i].Name < groups[j].Name
+		}
+		return groups[i].ID < groups[j].ID
+	})
+	for _, g := range groups {
+		sortGroupFields(g)
+	}
+
+	sort.SliceStable(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	for _, u := range users {
+		sortUserFields(u)
+	}
+}
+
+// sortActions orders the roles, organizations and groups within the groups and users the actions write, in place, so repeated runs send identical payloads
+func sortActions(actions []*Action) {
+	for _, a := range actions {
+		if a.Group != nil && a.Group != a.GroupBefore {
+			sortGroupFields(a.Group)
+		}
+		if a.User != nil && a.User != a.UserBefore {
+			sortUserFields(a.User)
+		}
+	}
+}
+
+// sortGroupFields orders the roles and organizations of the group by name; identities keep their order, since the first one is the directory group the estafette group was created for
+func sortGroupFields(group *contracts.Group) {
+	sortRoles(group.Roles)
+	sortOrganizations(group.Organizations)
+}
+
+// sortUserFields orders the groups of the user by name and id, and its roles and organizations by name; identities keep their order, since estafette takes the email and provider of the user from the first one
+func sortUserFields(user *contracts.User) {
+	sort.SliceStable(user.Groups, func(i, j int) bool {
+		a, b := user.Groups[i], user.Groups[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+	sortRoles(user.Roles)
+	sortOrganizations(user.Organizations)
+}
+
+func sortRoles(roles []*string) {
+	sort.SliceStable(roles, func(i, j int) bool {
+		return roles[i] != nil && (roles[j] == nil || *roles[i] < *roles[j])
+	})
+}
+
+func sortOrganizations(organizations []*contracts.Organization) {
+	sort.SliceStable(organizations, func(i, j int) bool {
+		return organizations[i].Name < organizations[j].Name
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanGroupsAndMembersOrdering(t *testing.T) {
+	t.Run("PlansIdenticalActionsOnRepeatedRuns", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{}
+		for _, id := range []string{"0c", "0a", "0e", "0b", "0d"} {
+			groupMembers[&DirectoryGroup{ID: id, Name: "ci-" + id, Email: "ci-" + id + "@example.com"}] = []*DirectoryMember{{Email: "john@example.com"}}
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}}},
+		}
+		options := planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}}
+
+		// act
+		first, _ := json.Marshal(planGroupsAndMembers([]*contracts.Group{}, users, &gsuiteClient{}, groupMembers, nil, options))
+
+		for i := 0; i < 10; i++ {
+			again, _ := json.Marshal(planGroupsAndMembers([]*contracts.Group{}, users, &gsuiteClient{}, groupMembers, nil, options))
+			assert.Equal(t, string(first), string(again))
+		}
+	})
+
+	t.Run("CreatesGroupsInOrderOfDirectoryGroupID", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0b", Name: "ci-release"}:  {{Email: "john@example.com"}},
+			{ID: "0a", Name: "ci-platform"}: {{Email: "jane@example.com"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 2, len(actions)) {
+			assert.Equal(t, "platform", actions[0].Group.Name)
+			assert.Equal(t, "release", actions[1].Group.Name)
+		}
+	})
+}
+
+func TestSortEstafetteState(t *testing.T) {
+	t.Run("OrdersGroupsUsersAndTheirFieldsButNotIdentities", func(t *testing.T) {
+
+		viewer, operator := "viewer", "operator"
+		groups := []*contracts.Group{
+			{ID: "g2", Name: "release", Roles: []*string{&viewer, &operator}},
+			{ID: "g1", Name: "platform", Organizations: []*contracts.Organization{{Name: "retail"}, {Name: "finance"}}},
+		}
+		users := []*contracts.User{
+			{
+				ID:         "u2",
+				Identities: []*contracts.UserIdentity{{Provider: googleProviderName, Email: "jane@example.com"}, {Provider: "github", Name: "jane"}},
+				Groups:     []*contracts.Group{{ID: "g2", Name: "release"}, {ID: "g1", Name: "platform"}},
+			},
+			{ID: "u1"},
+		}
+
+		// act
+		sortEstafetteState(groups, users)
+
+		assert.Equal(t, []string{"g1", "g2"}, groupIDs(groups))
+		assert.Equal(t, []string{"operator", "viewer"}, groupRoles(groups[1]))
+		assert.Equal(t, []string{"finance", "retail"}, groupOrganizations(groups[0]))
+		assert.Equal(t, []string{"u1", "u2"}, userIDs(users))
+		assert.Equal(t, []string{"g1", "g2"}, groupIDs(users[1].Groups))
+		assert.Equal(t, googleProviderName, users[1].Identities[0].Provider)
+	})
+}
+
+func TestSortDirectoryMembers(t *testing.T) {
+	t.Run("OrdersMembersByEmail", func(t *testing.T) {
+
+		group := &DirectoryGroup{ID: "0a"}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			group: {{Email: "ted@example.com"}, {Email: "jane@example.com"}, {Email: "john@example.com"}},
+		}
+
+		// act
+		sortDirectoryMembers(groupMembers)
+
+		assert.Equal(t, "jane@example.com", groupMembers[group][0].Email)
+		assert.Equal(t, "john@example.com", groupMembers[group][1].Email)
+		assert.Equal(t, "ted@example.com", groupMembers[group][2].Email)
+	})
+}
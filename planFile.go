@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// planFileVersion is increased when the plan file format changes, so apply refuses plans it can't read correctly
+const planFileVersion = 1
+
+// ErrPlanDrifted is returned by apply if estafette changed since the plan was made in a way that affects the planned actions
+var ErrPlanDrifted = errors.New("estafette changed since the plan was made")
+
+// PlanFile holds the actions planned by the plan command for the apply command, signed with --plan-signing-key so apply can tell the reviewed plan wasn't edited
+type PlanFile struct {
+	Version   int       `json:"version"`
+	RunID     string    `json:"runID"`
+	Provider  string    `json:"provider"`
+	CreatedAt time.Time `json:"createdAt"`
+	Actions   []*Action `json:"actions"`
+	Signature string    `json:"signature,omitempty"`
+}
+
+// signature returns the hmac of the plan without its signature
+func (p *PlanFile) signature(key string) (string, error) {
+	unsigned := *p
+	unsigned.Signature = ""
+
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", fmt.Errorf("Failed marshalling plan: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// writePlanFile signs the plan and writes it to the path
+func writePlanFile(path, key string, plan *PlanFile) (err error) {
+	if key == "" {
+		return fmt.Errorf("--plan-signing-key is required to sign the plan")
+	}
+
+	plan.Version = planFileVersion
+	plan.Signature, err = plan.signature(key)
+	if err != nil {
+		return
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed marshalling plan: %w", err)
+	}
+
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Failed writing plan to %v: %w", path, err)
+	}
+
+	return nil
+}
+
+// readPlanFile reads the plan from the path and verifies its signature
+func readPlanFile(path, key string) (*PlanFile, error) {
+	if key == "" {
+		return nil, fmt.Errorf("--plan-signing-key is required to verify the plan")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading plan from %v: %w", path, err)
+	}
+
+	var plan PlanFile
+	if err = json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling plan from %v: %w", path, err)
+	}
+	if plan.Version != planFileVersion {
+		return nil, fmt.Errorf("Plan %v has version %v, this syncer only applies version %v", path, plan.Version, planFileVersion)
+	}
+
+	expected, err := plan.signature(key)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(expected), []byte(plan.Signature)) {
+		return nil, fmt.Errorf("Plan %v isn't signed with --plan-signing-key, it was edited or signed with another key", path)
+	}
+
+	return &plan, nil
+}
+
+// rebasePlan checks the planned actions against the live estafette state and rebases the updates on top of it, so fields changed in estafette that the plan doesn't touch are kept; it returns ErrPlanDrifted if an entity to create exists by now, an entity to change is gone or had any of the planned fields changed, or a group to delete had any field changed since the plan was made
+func rebasePlan(actions []*Action, s state) error {
+
+	drifted := make([]string, 0)
+	for _, a := range actions {
+		reason, err := rebaseAction(a, s)
+		if err != nil {
+			return fmt.Errorf("Failed rebasing %v: %w", a, err)
+		}
+		if reason != "" {
+			drifted = append(drifted, fmt.Sprintf("%v: %v", a, reason))
+		}
+	}
+
+	if len(drifted) > 0 {
+		return fmt.Errorf("%w, run plan again: %v", ErrPlanDrifted, strings.Join(drifted, "; "))
+	}
+
+	return nil
+}
+
+// rebaseAction rebases the action on the live entity and returns why it drifted, or an empty string if it didn't
+func rebaseAction(a *Action, s state) (drift string, err error) {
+	switch a.Type {
+	case ActionCreateGroup:
+		for _, g := range s.groups {
+			if strings.EqualFold(g.Name, a.Group.Name) {
+				return "the group exists by now", nil
+			}
+		}
+
+	case ActionCreateOrganization:
+		for _, o := range s.organizations {
+			if strings.EqualFold(o.Name, a.Organization.Name) {
+				return "the organization exists by now", nil
+			}
+		}
+
+	case ActionUpdateGroup, ActionDeleteGroup:
+		var live *contracts.Group
+		for _, g := range s.groups {
+			if g.ID == a.GroupBefore.ID {
+				live = g
+			}
+		}
+		if live == nil {
+			return "the group doesn't exist anymore", nil
+		}
+		if a.Type == ActionDeleteGroup {
+			return deleteDrift(a.GroupBefore, live)
+		}
+		rebased := &contracts.Group{}
+		drift, err = rebaseEntity(a.GroupBefore, a.Group, live, rebased)
+		a.GroupBefore, a.Group = live, rebased
+
+	case ActionUpdateUser:
+		var live *contracts.User
+		for _, u := range s.users {
+			if u.ID == a.UserBefore.ID {
+				live = u
+			}
+		}
+		if live == nil {
+			return "the user doesn't exist anymore", nil
+		}
+		rebased := &contracts.User{}
+		drift, err = rebaseEntity(a.UserBefore, a.User, live, rebased)
+		a.UserBefore, a.User = live, rebased
+
+	case ActionUpdateOrganization:
+		var live *contracts.Organization
+		for _, o := range s.organizations {
+			if o.ID == a.OrganizationBefore.ID {
+				live = o
+			}
+		}
+		if live == nil {
+			return "the organization doesn't exist anymore", nil
+		}
+		rebased := &contracts.Organization{}
+		drift, err = rebaseEntity(a.OrganizationBefore, a.Organization, live, rebased)
+		a.OrganizationBefore, a.Organization = live, rebased
+	}
+
+	return
+}
+
+// rebaseEntity applies the planned change from before to after on top of the live entity into rebased, and returns the fields the plan changes that were changed in estafette as well
+func rebaseEntity(before, after, live, rebased interface{}) (drift string, err error) {
+
+	beforeMap, err := toJSONMap(before)
+	if err != nil {
+		return
+	}
+	afterMap, err := toJSONMap(after)
+	if err != nil {
+		return
+	}
+	liveMap, err := toJSONMap(live)
+	if err != nil {
+		return
+	}
+
+	planned := diffJSONMaps(beforeMap, afterMap)
+	changed := diffJSONMaps(beforeMap, liveMap)
+	fields := make([]string, 0)
+	for field := range planned {
+		if _, ok := changed[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) > 0 {
+		return changedFieldsDrift(fields), nil
+	}
+
+	rebasedMap, err := rebaseUpdate(before, after, liveMap)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(rebasedMap)
+	if err != nil {
+		return
+	}
+
+	return "", json.Unmarshal(data, rebased)
+}
+
+// deleteDrift returns the fields of the entity to delete that were changed in estafette since the plan was made, so a delete doesn't discard changes that weren't reviewed with the plan
+func deleteDrift(before, live interface{}) (drift string, err error) {
+
+	beforeMap, err := toJSONMap(before)
+	if err != nil {
+		return
+	}
+	liveMap, err := toJSONMap(live)
+	if err != nil {
+		return
+	}
+
+	changed := diffJSONMaps(beforeMap, liveMap)
+	if len(changed) == 0 {
+		return "", nil
+	}
+	fields := make([]string, 0, len(changed))
+	for field := range changed {
+		fields = append(fields, field)
+	}
+
+	return changedFieldsDrift(fields), nil
+}
+
+func changedFieldsDrift(fields []string) string {
+	sort.Strings(fields)
+	return fmt.Sprintf("%v changed since the plan was made", strings.Join(fields, ", "))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanFile(t *testing.T) {
+	t.Run("ReadsPlanSignedWithTheSameKey", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "plan")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plan.json")
+		plan := &PlanFile{RunID: "run-1", Provider: "gsuite", CreatedAt: time.Now().UTC(), Actions: []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "team"}}}}
+		assert.Nil(t, writePlanFile(path, "key", plan))
+
+		// act
+		read, err := readPlanFile(path, "key")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "run-1", read.RunID)
+		assert.Equal(t, 1, len(read.Actions))
+		assert.Equal(t, "team", read.Actions[0].Group.Name)
+	})
+
+	t.Run("RefusesEditedPlan", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "plan")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plan.json")
+		assert.Nil(t, writePlanFile(path, "key", &PlanFile{Actions: []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "team"}}}}))
+		data, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(path, []byte(strings.Replace(string(data), `"team"`, `"admins"`, 1)), 0644))
+
+		// act
+		_, err = readPlanFile(path, "key")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("RefusesPlanSignedWithAnotherKey", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "plan")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "plan.json")
+		assert.Nil(t, writePlanFile(path, "key", &PlanFile{}))
+
+		// act
+		_, err = readPlanFile(path, "other-key")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestRebasePlan(t *testing.T) {
+	t.Run("KeepsFieldsChangedInEstafetteThatThePlanDoesntTouch", func(t *testing.T) {
+
+		before := &contracts.Group{ID: "1", Name: "team"}
+		after := &contracts.Group{ID: "1", Name: "team", Identities: []*contracts.GroupIdentity{{Provider: "gsuite", ID: "g1", Name: "team"}}}
+		actions := []*Action{{Type: ActionUpdateGroup, GroupBefore: before, Group: after}}
+		s := state{groups: []*contracts.Group{{ID: "1", Name: "renamed-team"}}}
+
+		// act
+		err := rebasePlan(actions, s)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "renamed-team", actions[0].Group.Name)
+		assert.Equal(t, 1, len(actions[0].Group.Identities))
+		assert.Equal(t, "renamed-team", actions[0].GroupBefore.Name)
+	})
+
+	t.Run("ReturnsErrPlanDriftedIfAPlannedFieldChangedInEstafette", func(t *testing.T) {
+
+		before := &contracts.Group{ID: "1", Name: "team"}
+		after := &contracts.Group{ID: "1", Name: "team", Identities: []*contracts.GroupIdentity{{Provider: "gsuite", ID: "g1", Name: "team"}}}
+		actions := []*Action{{Type: ActionUpdateGroup, GroupBefore: before, Group: after}}
+		s := state{groups: []*contracts.Group{{ID: "1", Name: "team", Identities: []*contracts.GroupIdentity{{Provider: "gsuite", ID: "g2", Name: "other"}}}}}
+
+		// act
+		err := rebasePlan(actions, s)
+
+		assert.True(t, errors.Is(err, ErrPlanDrifted))
+		assert.Contains(t, err.Error(), "identities")
+	})
+
+	t.Run("ReturnsErrPlanDriftedIfAGroupToCreateExists", func(t *testing.T) {
+
+		actions := []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "team"}}}
+		s := state{groups: []*contracts.Group{{ID: "1", Name: "Team"}}}
+
+		// act
+		err := rebasePlan(actions, s)
+
+		assert.True(t, errors.Is(err, ErrPlanDrifted))
+	})
+
+	t.Run("ReturnsErrPlanDriftedIfAGroupToDeleteIsGone", func(t *testing.T) {
+
+		actions := []*Action{{Type: ActionDeleteGroup, GroupBefore: &contracts.Group{ID: "1", Name: "team"}}}
+
+		// act
+		err := rebasePlan(actions, state{})
+
+		assert.True(t, errors.Is(err, ErrPlanDrifted))
+	})
+
+	t.Run("ReturnsErrPlanDriftedIfAGroupToDeleteChangedInEstafette", func(t *testing.T) {
+
+		actions := []*Action{{Type: ActionDeleteGroup, GroupBefore: &contracts.Group{ID: "1", Name: "team"}}}
+		s := state{groups: []*contracts.Group{{ID: "1", Name: "team", Identities: []*contracts.GroupIdentity{{Provider: "gsuite", ID: "g1", Name: "team"}}}}}
+
+		// act
+		err := rebasePlan(actions, s)
+
+		assert.True(t, errors.Is(err, ErrPlanDrifted))
+		assert.Contains(t, err.Error(), "identities changed since the plan was made")
+	})
+
+	t.Run("DeletesGroupUnchangedInEstafette", func(t *testing.T) {
+
+		actions := []*Action{{Type: ActionDeleteGroup, GroupBefore: &contracts.Group{ID: "1", Name: "team"}}}
+		s := state{groups: []*contracts.Group{{ID: "1", Name: "team"}}}
+
+		// act
+		err := rebasePlan(actions, s)
+
+		assert.Nil(t, err)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// formatPlan serializes the actions deterministically, one entry per action sorted by type and entity with the changed fields as json merge patch, so plans can be reviewed and compared across runs
+func formatPlan(actions []*Action) (string, error) {
+
+	entries := make([]string, 0, len(actions))
+	for _, a := range actions {
+		before, after, key := a.entities()
+
+		beforeMap, err := toJSONMap(before)
+		if err != nil {
+			return "", fmt.Errorf("Failed formatting %v: %w", a, err)
+		}
+		afterMap, err := toJSONMap(after)
+		if err != nil {
+			return "", fmt.Errorf("Failed formatting %v: %w", a, err)
+		}
+
+		// json.Marshal sorts map keys, sorting arrays of entities as well makes the patch independent of fetch order
+		patch, err := json.MarshalIndent(sortJSONArrays(diffJSONMaps(beforeMap, afterMap)), "  ", "  ")
+		if err != nil {
+			return "", fmt.Errorf("Failed formatting %v: %w", a, err)
+		}
+
+		entries = append(entries, fmt.Sprintf("%v %v\n  %s\n", a.Type, key, patch))
+	}
+	sort.Strings(entries)
+
+	return strings.Join(entries, ""), nil
+}
+
+// entities returns the entity before and after the action and a key identifying it
+func (a *Action) entities() (before, after interface{}, key string) {
+	switch {
+	case a.Type == ActionDeleteGroup:
+		return a.GroupBefore, nil, a.GroupBefore.Name
+
+	case a.Group != nil:
+		key = a.Group.Name
+		if a.GroupBefore != nil {
+			return a.GroupBefore, a.Group, a.GroupBefore.Name
+		}
+		return nil, a.Group, key
+
+	case a.User != nil:
+		key = a.User.GetEmail()
+		if a.UserBefore != nil {
+			return a.UserBefore, a.User, key
+		}
+		return nil, a.User, key
+
+	case a.Organization != nil:
+		key = a.Organization.Name
+		if a.OrganizationBefore != nil {
+			return a.OrganizationBefore, a.Organization, a.OrganizationBefore.Name
+		}
+		return nil, a.Organization, key
+	}
+
+	return nil, nil, ""
+}
+
+// sortJSONArrays sorts arrays of objects in the json value by their id and name in place and returns the value
+func sortJSONArrays(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			v[key] = sortJSONArrays(nested)
+		}
+
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = sortJSONArrays(nested)
+		}
+		sort.SliceStable(v, func(i, j int) bool {
+			return jsonSortKey(v[i]) < jsonSortKey(v[j])
+		})
+	}
+
+	return value
+}
+
+func jsonSortKey(value interface{}) string {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%v/%v", object["id"], object["name"])
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrites the golden files in testdata/plans with the current plans.")
+
+// planScenario is the estafette and directory state a golden plan is computed from
+type planScenario struct {
+	GroupPrefix     string               `json:"groupPrefix"`
+	Groups          []*contracts.Group   `json:"groups"`
+	Users           []*contracts.User    `json:"users"`
+	DirectoryGroups []*planScenarioGroup `json:"directoryGroups"`
+}
+
+type planScenarioGroup struct {
+	ID      string             `json:"id"`
+	Name    string             `json:"name"`
+	Email   string             `json:"email"`
+	Members []*DirectoryMember `json:"members"`
+}
+
+// TestPlanGolden compares the plan for every scenario in testdata/plans with its golden file; run with -update to accept changes in sync semantics
+func TestPlanGolden(t *testing.T) {
+
+	scenarios, err := filepath.Glob(filepath.Join("testdata", "plans", "*.json"))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, scenarios)
+
+	for _, path := range scenarios {
+		name := filepath.Base(path[:len(path)-len(".json")])
+		t.Run(name, func(t *testing.T) {
+
+			data, err := ioutil.ReadFile(path)
+			assert.Nil(t, err)
+			var scenario planScenario
+			assert.Nil(t, json.Unmarshal(data, &scenario))
+
+			groupMembers := map[*DirectoryGroup][]*DirectoryMember{}
+			for _, g := range scenario.DirectoryGroups {
+				groupMembers[&DirectoryGroup{ID: g.ID, Name: g.Name, Email: g.Email}] = g.Members
+			}
+
+			// act
+			actions := planGroupsAndMembers(scenario.Groups, scenario.Users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: scenario.GroupPrefix}}})
+			plan, err := formatPlan(actions)
+
+			assert.Nil(t, err)
+			goldenPath := filepath.Join("testdata", "plans", name+".golden")
+			if *updateGolden {
+				assert.Nil(t, ioutil.WriteFile(goldenPath, []byte(plan), 0644))
+			}
+			golden, err := ioutil.ReadFile(goldenPath)
+			if assert.Nil(t, err, "missing golden file, run go test -run TestPlanGolden -update") {
+				assert.Equal(t, string(golden), plan)
+			}
+		})
+	}
+}
+
+func TestFormatPlan(t *testing.T) {
+	t.Run("IsIndependentOfActionAndGroupOrder", func(t *testing.T) {
+
+		before := &contracts.User{ID: "u1", Groups: []*contracts.Group{}}
+		after := &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g2", Name: "release"}, {ID: "g1", Name: "platform"}}}
+		reordered := &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}, {ID: "g2", Name: "release"}}}
+		create := &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}}
+
+		// act
+		plan, err := formatPlan([]*Action{{Type: ActionUpdateUser, UserBefore: before, User: after}, create})
+		reorderedPlan, reorderedErr := formatPlan([]*Action{create, {Type: ActionUpdateUser, UserBefore: before, User: reordered}})
+
+		assert.Nil(t, err)
+		assert.Nil(t, reorderedErr)
+		assert.Equal(t, plan, reorderedPlan)
+	})
+}
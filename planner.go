@@ -0,0 +1,1129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/estafette/estafette-ci-gsuite-synchronizer/reconcile"
+)
+
+type ActionType string
+
+const (
+	profileGivenNameProperty  = "givenName"
+	profileFamilyNameProperty = "familyName"
+)
+
+// the fields of estafette groups the syncer can own, as set with --managed-fields
+const (
+	managedFieldName          = "name"
+	managedFieldIdentities    = "identities"
+	managedFieldMembers       = "members"
+	managedFieldRoles         = "roles"
+	managedFieldOrganizations = "organizations"
+)
+
+var allManagedFields = []string{managedFieldName, managedFieldIdentities, managedFieldMembers, managedFieldRoles, managedFieldOrganizations}
+
+const (
+	ActionCreateGroup ActionType = "create-group"
+	ActionUpdateGroup ActionType = "update-group"
+	ActionDeleteGroup ActionType = "delete-group"
+	ActionUpdateUser  ActionType = "update-user"
+
+	ActionCreateOrganization ActionType = "create-organization"
+	ActionUpdateOrganization ActionType = "update-organization"
+)
+
+// planOptions holds the settings that determine how directory groups map to estafette groups
+type planOptions struct {
+	// groupPrefixes are trimmed from directory group names to get the estafette group name, each with its own roles, organizations and name transforms
+	groupPrefixes []*groupPrefix
+	// nameTransforms are applied to directory group names after trimming the group prefix
+	nameTransforms []nameTransformFunc
+	// syncEmptyGroups creates estafette groups for directory groups without members as well
+	syncEmptyGroups bool
+	// cleanupEmptyGroups deletes estafette groups whose directory groups have no members anymore
+	cleanupEmptyGroups bool
+	// nameConflictResolution determines what happens to directory groups mapping to a name claimed by another directory group, one of skip, suffix and merge
+	nameConflictResolution string
+	// nameOverrides, skippedGroups and mergedGroups hold the resolved name conflicts by directory group id, see withNameConflicts
+	nameOverrides map[string]string
+	skippedGroups map[string]bool
+	mergedGroups  map[string]string
+	// organizationRules attach created groups to the organization of the first rule matching the group
+	organizationRules []*organizationRule
+	// protectedGroups are the names or ids of estafette groups that are never modified, nor have their members changed
+	protectedGroups []string
+	// lastApplied holds the group fields as applied by the previous sync, so fields edited in estafette are kept; if nil the owned fields are overwritten
+	lastApplied *reconcile.Snapshot
+	// managedFields are the group fields the syncer updates, all others are left as they are in estafette; if nil all fields are managed
+	managedFields map[string]bool
+	// memberFilter drops members that never get estafette group memberships, like bots and service accounts; if nil all members are kept
+	memberFilter *memberFilter
+	// syncDescriptions records the descriptions of directory groups on their estafette groups, see applyGroupDescription
+	syncDescriptions bool
+	// everyoneGroup and adminsGroup are the names of the aggregate groups generated by the syncer, see addAggregateGroups; each is disabled if empty
+	everyoneGroup string
+	adminsGroup   string
+	// adminRoles generate a group per directory admin role, see adminRoleGroups
+	adminRoles []*AdminRole
+}
+
+// groupName returns the estafette group name for the directory group, taking resolved name conflicts into account
+func (o planOptions) groupName(directoryGroup *DirectoryGroup) string {
+	if override, ok := o.nameOverrides[directoryGroup.ID]; ok {
+		return override
+	}
+
+	return o.transformGroupName(directoryGroup)
+}
+
+// transformGroupName returns the directory group name with its group prefix trimmed and the name transforms of the prefix and the global ones applied; if the transforms leave nothing it falls back to the name without the group prefix, and aggregate groups keep their name
+func (o planOptions) transformGroupName(directoryGroup *DirectoryGroup) string {
+	if directoryGroup.Aggregate {
+		return directoryGroup.Name
+	}
+
+	name := directoryGroup.Name
+	transforms := o.nameTransforms
+	if gp := o.matchGroupPrefix(name); gp != nil {
+		name = strings.TrimPrefix(name, gp.prefix)
+		transforms = append(append([]nameTransformFunc{}, gp.nameTransforms...), o.nameTransforms...)
+	}
+
+	transformed := name
+	for _, transform := range transforms {
+		transformed = transform(transformed)
+	}
+	if strings.TrimSpace(transformed) == "" {
+		return name
+	}
+
+	return transformed
+}
+
+// manages checks whether the syncer owns the group field
+func (o planOptions) manages(field string) bool {
+	return o.managedFields == nil || o.managedFields[field]
+}
+
+// parseManagedFields parses the comma-separated managed fields, returning an error for fields the syncer doesn't know
+func parseManagedFields(value string) (managedFields map[string]bool, err error) {
+
+	managedFields = map[string]bool{}
+
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		known := false
+		for _, mf := range allManagedFields {
+			if f == mf {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("Managed field %v is unknown, use any of %v", f, strings.Join(allManagedFields, ","))
+		}
+
+		managedFields[f] = true
+	}
+
+	return managedFields, nil
+}
+
+// isProtected checks whether the estafette group is in the protected groups by id or name
+func (o planOptions) isProtected(group *contracts.Group) bool {
+	for _, pg := range o.protectedGroups {
+		if (group.ID != "" && group.ID == pg) || group.Name == pg {
+			return true
+		}
+	}
+
+	return false
+}
+
+// organizationRule attaches estafette groups created for directory groups with an email matching the pattern to the organization
+type organizationRule struct {
+	pattern      *regexp.Regexp
+	organization *contracts.Organization
+}
+
+// organizationForGroup returns the organization of the first rule matching the email of the directory group, or its id if it has no email
+func (o planOptions) organizationForGroup(group *DirectoryGroup) *contracts.Organization {
+	email := group.Email
+	if email == "" {
+		email = group.ID
+	}
+
+	for _, r := range o.organizationRules {
+		if r.pattern.MatchString(email) {
+			return r.organization
+		}
+	}
+
+	return nil
+}
+
+// parseOrganizationRules parses rules of the form pattern=organization, where organization is the name of one of the estafette organizations
+func parseOrganizationRules(rules []string, organizations []*contracts.Organization) (organizationRules []*organizationRule, err error) {
+
+	organizationRules = make([]*organizationRule, 0, len(rules))
+
+	for _, rule := range rules {
+		// split on the last = since the pattern can contain one
+		separatorIndex := strings.LastIndex(rule, "=")
+		if separatorIndex <= 0 || separatorIndex == len(rule)-1 {
+			return nil, fmt.Errorf("Organization rule %v is not of the form pattern=organization", rule)
+		}
+
+		pattern, err := regexp.Compile(rule[:separatorIndex])
+		if err != nil {
+			return nil, fmt.Errorf("Organization rule %v has an invalid pattern: %w", rule, err)
+		}
+
+		organizationName := rule[separatorIndex+1:]
+		var organization *contracts.Organization
+		for _, o := range organizations {
+			if o.Name == organizationName {
+				organization = &contracts.Organization{ID: o.ID, Name: o.Name}
+			}
+		}
+		if organization == nil {
+			return nil, fmt.Errorf("Organization rule %v refers to unknown organization %v", rule, organizationName)
+		}
+
+		organizationRules = append(organizationRules, &organizationRule{
+			pattern:      pattern,
+			organization: organization,
+		})
+	}
+
+	return organizationRules, nil
+}
+
+// Action is a single mutation to estafette needed to bring it in sync with the directory
+type Action struct {
+	Type ActionType `json:"type"`
+
+	// GroupBefore and UserBefore hold the entity as fetched from estafette, they're empty for created entities
+	GroupBefore *contracts.Group `json:"groupBefore,omitempty"`
+	Group       *contracts.Group `json:"group,omitempty"`
+	UserBefore  *contracts.User  `json:"userBefore,omitempty"`
+	User        *contracts.User  `json:"user,omitempty"`
+
+	OrganizationBefore *contracts.Organization `json:"organizationBefore,omitempty"`
+	Organization       *contracts.Organization `json:"organization,omitempty"`
+
+	// Err is set when applying the action failed
+	Err error `json:"-"`
+}
+
+// String returns a human-readable description of the action
+func (a *Action) String() string {
+	switch a.Type {
+	case ActionCreateGroup:
+		return fmt.Sprintf("create group %v", a.Group.Name)
+
+	case ActionUpdateGroup:
+		if a.GroupBefore.Name != a.Group.Name {
+			return fmt.Sprintf("rename group %v to %v", a.GroupBefore.Name, a.Group.Name)
+		}
+		return fmt.Sprintf("update group %v", a.Group.Name)
+
+	case ActionDeleteGroup:
+		return fmt.Sprintf("delete group %v", a.Group.Name)
+
+	case ActionUpdateUser:
+		added, removed := diffGroupNames(a.UserBefore.Groups, a.User.Groups)
+		description := fmt.Sprintf("update user %v", a.User.GetEmail())
+		if len(added) > 0 {
+			description += fmt.Sprintf(", add to groups %v", strings.Join(added, ", "))
+		}
+		if len(removed) > 0 {
+			description += fmt.Sprintf(", remove from groups %v", strings.Join(removed, ", "))
+		}
+		if roles := revokedRoles(a.UserBefore, a.User); len(roles) > 0 {
+			description += fmt.Sprintf(", revoke roles %v", strings.Join(roles, ", "))
+		}
+		if !reflect.DeepEqual(a.UserBefore.Identities, a.User.Identities) {
+			description += ", update profile"
+		}
+		if !reflect.DeepEqual(a.UserBefore.Preferences, a.User.Preferences) {
+			description += ", update properties"
+		}
+		if a.UserBefore.Active && !a.User.Active {
+			description += ", deactivate"
+		}
+		return description
+
+	case ActionCreateOrganization:
+		return fmt.Sprintf("create organization %v", a.Organization.Name)
+
+	case ActionUpdateOrganization:
+		return fmt.Sprintf("rename organization %v to %v", a.OrganizationBefore.Name, a.Organization.Name)
+	}
+
+	return string(a.Type)
+}
+
+// entityID returns the estafette id of the group, user or organization the action changes; it's empty for created entities
+func (a *Action) entityID() string {
+	switch {
+	case a.Group != nil:
+		return a.Group.ID
+	case a.User != nil:
+		return a.User.ID
+	case a.Organization != nil:
+		return a.Organization.ID
+	}
+
+	return ""
+}
+
+// planGroupsAndMembers computes the actions needed to synchronize the directory groups and their members to estafette, without mutating the fetched estafette entities
+func planGroupsAndMembers(groups []*contracts.Group, users []*contracts.User, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember, directoryUsers []*DirectoryUser, options planOptions) (actions []*Action) {
+
+	groupMembers = options.syncedGroupMembers(groupMembers, directoryUsers)
+	directoryUsers = options.memberFilter.filterDirectoryUsers(directoryUsers)
+
+	actions, plannedGroups := planGroups(groups, provider, groupMembers, options)
+
+	userActions := planUsers(users, provider, options, func(user *contracts.User) []*contracts.Group {
+		return getGroupsForUser(user, plannedGroups, provider, groupMembers)
+	}, indexDirectoryUsers(users, provider, directoryUsers), indexGroupsByID(groups))
+
+	actions = append(actions, userActions...)
+	sortActions(actions)
+
+	return actions
+}
+
+// planGroups computes the actions to create and update estafette groups for the directory groups, and returns the estafette groups as they'll be after applying those actions
+func planGroups(groups []*contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember, options planOptions) (actions []*Action, plannedGroups []*contracts.Group) {
+
+	actions = make([]*Action, 0)
+
+	// link the groups with identities written by older versions to their directory groups again before matching them
+	groups, groupsBeforeMigration := migrateGroups(groups, provider, groupMembers)
+
+	options = options.withNameConflicts(detectNameConflicts(groups, provider, groupMembers, options))
+
+	// groups as they'll be after applying the group actions, in order to use up-to-date names for user groups
+	plannedGroups = make([]*contracts.Group, 0, len(groups))
+
+	// loop estafette groups to see if any of them have to be updated from directory groups
+	for _, g := range groups {
+		// leave protected groups alone and keep them out of the planned groups, so no members get added to them
+		if options.isProtected(g) {
+			continue
+		}
+
+		updatedGroup := copyGroup(g)
+		dirty := false
+		groupBefore := g
+		if original, migrated := groupsBeforeMigration[g]; migrated {
+			groupBefore = original
+			dirty = true
+		}
+
+		for gg := range groupMembers {
+			// check estafette group identities for the provider and id equal to the directory group id
+			for _, i := range updatedGroup.Identities {
+				if i.Provider == provider.Name() && i.ID == gg.ID {
+					// we have a matching group in estafette, update it
+					if options.manages(managedFieldIdentities) && i.Name != gg.Name {
+						i.Name = gg.Name
+						dirty = true
+					}
+					if reconcileGroup(updatedGroup, provider, gg, options) {
+						dirty = true
+					}
+					if options.manages(managedFieldIdentities) && applyGroupSettings(updatedGroup, provider, gg) {
+						dirty = true
+					}
+					if options.manages(managedFieldIdentities) && applyDynamicGroup(updatedGroup, provider, gg) {
+						dirty = true
+					}
+					if options.manages(managedFieldIdentities) && applyGroupDomain(updatedGroup, provider, gg) {
+						dirty = true
+					}
+					if options.syncDescriptions && options.manages(managedFieldIdentities) && applyGroupDescription(updatedGroup, provider, gg) {
+						dirty = true
+					}
+				}
+			}
+		}
+
+		// add the identities of directory groups merged into this group because they map to the same name
+		if options.manages(managedFieldIdentities) {
+			for _, i := range g.Identities {
+				if i.Provider != provider.Name() {
+					continue
+				}
+				for _, mi := range options.mergedIdentities(provider, i.ID, groupMembers) {
+					if !hasGroupIdentity(updatedGroup, mi) {
+						updatedGroup.Identities = append(updatedGroup.Identities, mi)
+						dirty = true
+					}
+				}
+			}
+		}
+
+		// groups whose directory groups all lost their members are deleted instead, since estafette groups can't be deactivated
+		if options.cleanupEmptyGroups && hasOnlyEmptyDirectoryGroups(g, provider, groupMembers) {
+			actions = append(actions, &Action{
+				Type:        ActionDeleteGroup,
+				GroupBefore: groupBefore,
+				Group:       groupBefore,
+			})
+			continue
+		}
+
+		if dirty {
+			actions = append(actions, &Action{
+				Type:        ActionUpdateGroup,
+				GroupBefore: groupBefore,
+				Group:       updatedGroup,
+			})
+			plannedGroups = append(plannedGroups, updatedGroup)
+		} else {
+			plannedGroups = append(plannedGroups, groupBefore)
+		}
+	}
+
+	// loop directory groups to see if any of them have to be created as estafette groups
+	for _, gg := range sortedDirectoryGroups(groupMembers) {
+		m := groupMembers[gg]
+		hasMatchingEstafetteGroup := false
+		for _, g := range groups {
+			// check estafette group identities for the provider and id equal to the directory group id
+			for _, i := range g.Identities {
+				if i.Provider == provider.Name() && i.ID == gg.ID {
+					hasMatchingEstafetteGroup = true
+				}
+			}
+		}
+
+		// directory groups that lost a name conflict are either left out or merged into the group of the winner
+		if _, merged := options.mergedGroups[gg.ID]; merged || options.skippedGroups[gg.ID] {
+			continue
+		}
+
+		if !hasMatchingEstafetteGroup && (len(m) > 0 || options.syncEmptyGroups) {
+			// no matching group, create one
+			newGroup := &contracts.Group{
+				Name: options.groupName(gg),
+				Identities: []*contracts.GroupIdentity{
+					{
+						Provider: provider.Name(),
+						ID:       gg.ID,
+						Name:     gg.Name,
+					},
+				},
+			}
+			newGroup.Identities = append(newGroup.Identities, options.mergedIdentities(provider, gg.ID, groupMembers)...)
+			applyGroupSettings(newGroup, provider, gg)
+			applyDynamicGroup(newGroup, provider, gg)
+			applyGroupDomain(newGroup, provider, gg)
+			if options.syncDescriptions {
+				applyGroupDescription(newGroup, provider, gg)
+			}
+			applyGroupIdentity(newGroup, groupVersionIdentity(provider, gg))
+			// don't create a group that takes the name of a protected group
+			if options.isProtected(newGroup) {
+				continue
+			}
+			if organization := options.organizationForGroup(gg); organization != nil {
+				newGroup.Organizations = []*contracts.Organization{organization}
+			}
+			applyGroupAnnotations(newGroup, options.groupAnnotations(gg))
+
+			actions = append(actions, &Action{
+				Type:  ActionCreateGroup,
+				Group: newGroup,
+			})
+		}
+	}
+
+	return
+}
+
+// planUsers computes the actions to update the groups of estafette users to the groups returned by groupsForUser, revoking the roles of the groups they're removed from, and their properties to the ones of the matching directory user
+func planUsers(users []*contracts.User, provider Provider, options planOptions, groupsForUser func(user *contracts.User) []*contracts.Group, directoryUsersByUserID map[string]*DirectoryUser, groupsByID map[string]*contracts.Group) (actions []*Action) {
+
+	actions = make([]*Action, 0)
+
+	// loop estafette users and check if their groups need to be updated
+	for _, u := range users {
+		userGroups := groupsForUser(u)
+		updatedUser := copyUser(u)
+
+		dirty := false
+		// leave the group memberships alone if the syncer doesn't own them
+		if options.manages(managedFieldMembers) {
+			for _, ug := range userGroups {
+				userHasGroup := false
+				for _, g := range updatedUser.Groups {
+					if g.ID == ug.ID {
+						userHasGroup = true
+						if g.Name != ug.Name {
+							g.Name = ug.Name
+							dirty = true
+						}
+					}
+				}
+				if !userHasGroup {
+					updatedUser.Groups = append(updatedUser.Groups, &contracts.Group{
+						ID:   ug.ID,
+						Name: ug.Name,
+					})
+					dirty = true
+				}
+			}
+
+			// use downward loop to avoid running out of bounds when an item is removed
+			for i := len(updatedUser.Groups) - 1; i >= 0; i-- {
+				g := updatedUser.Groups[i]
+				if options.isProtected(g) {
+					continue
+				}
+				isInUserGroups := false
+				for _, ug := range userGroups {
+					if g.ID == ug.ID {
+						isInUserGroups = true
+					}
+				}
+				if !isInUserGroups {
+					// memory-leak safe delete (https://github.com/golang/go/wiki/SliceTricks) without preserving order
+					copy(updatedUser.Groups[i:], updatedUser.Groups[i+1:])
+					updatedUser.Groups[len(updatedUser.Groups)-1] = nil // or the zero value of T
+					updatedUser.Groups = updatedUser.Groups[:len(updatedUser.Groups)-1]
+
+					dirty = true
+				}
+			}
+
+			if revokeGroupRoles(updatedUser, u, groupsByID) {
+				dirty = true
+			}
+		}
+
+		if directoryUser, ok := directoryUsersByUserID[u.ID]; ok && applyDirectoryUser(updatedUser, provider, directoryUser) {
+			dirty = true
+		}
+
+		if dirty {
+			actions = append(actions, &Action{
+				Type:       ActionUpdateUser,
+				UserBefore: u,
+				User:       updatedUser,
+			})
+		}
+	}
+
+	return
+}
+
+// planOrganizations computes the actions to create and rename estafette organizations so they mirror the gcp resource hierarchy, named by their path in the hierarchy
+func planOrganizations(organizations []*contracts.Organization, nodes []*ResourceNode) (actions []*Action) {
+
+	actions = make([]*Action, 0)
+
+	for _, n := range nodes {
+		var matchingOrganization *contracts.Organization
+		for _, o := range organizations {
+			for _, i := range o.Identities {
+				if i.Provider == gcpProviderName && i.ID == n.ID {
+					matchingOrganization = o
+				}
+			}
+		}
+
+		if matchingOrganization == nil {
+			actions = append(actions, &Action{
+				Type: ActionCreateOrganization,
+				Organization: &contracts.Organization{
+					Name: n.Path,
+					Identities: []*contracts.OrganizationIdentity{
+						{
+							Provider: gcpProviderName,
+							ID:       n.ID,
+							Name:     n.Name,
+						},
+					},
+				},
+			})
+			continue
+		}
+
+		if matchingOrganization.Name != n.Path {
+			updatedOrganization := copyOrganization(matchingOrganization)
+			updatedOrganization.Name = n.Path
+			for _, i := range updatedOrganization.Identities {
+				if i.Provider == gcpProviderName && i.ID == n.ID {
+					i.Name = n.Name
+				}
+			}
+
+			actions = append(actions, &Action{
+				Type:               ActionUpdateOrganization,
+				OrganizationBefore: matchingOrganization,
+				Organization:       updatedOrganization,
+			})
+		}
+	}
+
+	return
+}
+
+// applyGroupAnnotations sets the roles and organizations annotated in the directory on the estafette group and returns whether it changed
+func applyGroupAnnotations(group *contracts.Group, annotations *GroupAnnotations) (changed bool) {
+	if annotations == nil {
+		return false
+	}
+
+	if annotations.Roles != nil && !reconcile.SameStrings(groupRoles(group), annotations.Roles) {
+		setGroupRoles(group, annotations.Roles)
+		changed = true
+	}
+
+	if annotations.Organizations != nil && !reconcile.SameStrings(groupOrganizations(group), annotations.Organizations) {
+		setGroupOrganizations(group, annotations.Organizations)
+		changed = true
+	}
+
+	return
+}
+
+// reconcileGroup merges the name and annotations of the directory group into the estafette group, keeping the changes made in estafette since the last applied sync, and returns whether it changed
+func reconcileGroup(group *contracts.Group, provider Provider, directoryGroup *DirectoryGroup, options planOptions) (changed bool) {
+
+	desired := desiredGroupFields(directoryGroup, options)
+
+	actual := reconcile.GroupFields{
+		Name:          group.Name,
+		Roles:         groupRoles(group),
+		Organizations: groupOrganizations(group),
+	}
+
+	// fields the syncer doesn't own are desired as they are, so the merge leaves them alone; the same goes for names another directory group won
+	if !options.manages(managedFieldName) || options.keepsName(directoryGroup.ID) {
+		desired.Name = actual.Name
+	}
+	if !options.manages(managedFieldRoles) {
+		desired.Roles = actual.Roles
+	}
+	if !options.manages(managedFieldOrganizations) {
+		desired.Organizations = actual.Organizations
+	}
+
+	merged, changed := reconcile.MergeGroup(*desired, actual, options.lastApplied.LastAppliedGroup(reconcile.GroupKey(provider.Name(), directoryGroup.ID)))
+	if !changed {
+		return false
+	}
+
+	group.Name = merged.Name
+	if !reconcile.SameStrings(actual.Roles, merged.Roles) {
+		setGroupRoles(group, merged.Roles)
+	}
+	if !reconcile.SameStrings(actual.Organizations, merged.Organizations) {
+		setGroupOrganizations(group, merged.Organizations)
+	}
+
+	return true
+}
+
+// groupSettingsProviderSuffix is appended to the provider name for the identity recording the settings of a directory group, like gsuite-settings
+const groupSettingsProviderSuffix = "-settings"
+
+// groupSettingsIdentity returns the identity recording the settings of the directory group on its estafette group, with the settings as comma-separated key=value pairs for its name; it's nil if the provider doesn't retrieve settings
+func groupSettingsIdentity(provider Provider, directoryGroup *DirectoryGroup) *contracts.GroupIdentity {
+	if directoryGroup.Settings == nil {
+		return nil
+	}
+
+	return &contracts.GroupIdentity{
+		Provider: provider.Name() + groupSettingsProviderSuffix,
+		ID:       directoryGroup.ID,
+		Name:     fmt.Sprintf("allowExternalMembers=%v,whoCanJoin=%v", directoryGroup.Settings.AllowExternalMembers, directoryGroup.Settings.WhoCanJoin),
+	}
+}
+
+// applyGroupSettings adds or updates the settings identity of the directory group on the estafette group and returns whether it changed
+func applyGroupSettings(group *contracts.Group, provider Provider, directoryGroup *DirectoryGroup) (changed bool) {
+	settingsIdentity := groupSettingsIdentity(provider, directoryGroup)
+	if settingsIdentity == nil {
+		return false
+	}
+
+	return applyGroupIdentity(group, settingsIdentity)
+}
+
+// groupDynamicProviderSuffix is appended to the provider name for the identity marking a group as dynamic, like gsuite-dynamic
+const groupDynamicProviderSuffix = "-dynamic"
+
+// applyDynamicGroup marks the estafette group of a dynamic directory group with an identity holding its membership query, so users can tell its members are managed by the directory and direct edits get reverted; the mark is removed once the directory group isn't dynamic anymore
+func applyDynamicGroup(group *contracts.Group, provider Provider, directoryGroup *DirectoryGroup) (changed bool) {
+	dynamicProvider := provider.Name() + groupDynamicProviderSuffix
+	if directoryGroup.Dynamic == nil {
+		return removeGroupIdentity(group, dynamicProvider, directoryGroup.ID)
+	}
+
+	return applyGroupIdentity(group, &contracts.GroupIdentity{
+		Provider: dynamicProvider,
+		ID:       directoryGroup.ID,
+		Name:     directoryGroup.Dynamic.Query,
+	})
+}
+
+// groupDomainProviderSuffix is appended to the provider name for the identity holding the domain a directory group is homed in, like gsuite-domain
+const groupDomainProviderSuffix = "-domain"
+
+// applyGroupDomain records the domain the directory group is homed in on the estafette group as an identity, so groups with the same name in different domains of a multi-domain directory can be told apart; the identity is removed once the provider doesn't report the domain anymore
+func applyGroupDomain(group *contracts.Group, provider Provider, directoryGroup *DirectoryGroup) (changed bool) {
+	domainProvider := provider.Name() + groupDomainProviderSuffix
+	if directoryGroup.Domain == "" {
+		return removeGroupIdentity(group, domainProvider, directoryGroup.ID)
+	}
+
+	return applyGroupIdentity(group, &contracts.GroupIdentity{
+		Provider: domainProvider,
+		ID:       directoryGroup.ID,
+		Name:     directoryGroup.Domain,
+	})
+}
+
+// groupDescriptionProviderSuffix is appended to the provider name for the identity holding the description of a directory group, like gsuite-description
+const groupDescriptionProviderSuffix = "-description"
+
+// applyGroupDescription records the description of the directory group on the estafette group as an identity, since estafette groups have no description of their own, so users see the context admins wrote in the directory; the identity is removed once the description is cleared
+func applyGroupDescription(group *contracts.Group, provider Provider, directoryGroup *DirectoryGroup) (changed bool) {
+	descriptionProvider := provider.Name() + groupDescriptionProviderSuffix
+	if directoryGroup.Description == "" {
+		return removeGroupIdentity(group, descriptionProvider, directoryGroup.ID)
+	}
+
+	return applyGroupIdentity(group, &contracts.GroupIdentity{
+		Provider: descriptionProvider,
+		ID:       directoryGroup.ID,
+		Name:     directoryGroup.Description,
+	})
+}
+
+// applyGroupIdentity adds the identity to the group, or updates the name of its identity with the same provider and id, and returns whether it changed
+func applyGroupIdentity(group *contracts.Group, identity *contracts.GroupIdentity) (changed bool) {
+	for _, i := range group.Identities {
+		if i.Provider == identity.Provider && i.ID == identity.ID {
+			if i.Name == identity.Name {
+				return false
+			}
+			i.Name = identity.Name
+			return true
+		}
+	}
+
+	group.Identities = append(group.Identities, identity)
+
+	return true
+}
+
+// removeGroupIdentity removes the identity with the provider and id from the group and returns whether it had one
+func removeGroupIdentity(group *contracts.Group, provider, id string) (changed bool) {
+	identities := make([]*contracts.GroupIdentity, 0, len(group.Identities))
+	for _, i := range group.Identities {
+		if i.Provider == provider && i.ID == id {
+			changed = true
+			continue
+		}
+		identities = append(identities, i)
+	}
+	if changed {
+		group.Identities = identities
+	}
+
+	return
+}
+
+// hasOnlyEmptyDirectoryGroups checks whether the group is linked to directory groups of the provider and all of them are without members; groups linked to directory groups that are gone are left alone
+func hasOnlyEmptyDirectoryGroups(group *contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) bool {
+
+	linked := 0
+	for _, i := range group.Identities {
+		if i.Provider != provider.Name() {
+			continue
+		}
+		linked++
+
+		found := false
+		for gg, members := range groupMembers {
+			if gg.ID == i.ID {
+				if len(members) > 0 {
+					return false
+				}
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return linked > 0
+}
+
+// hasGroupIdentity checks whether the group has an identity with the provider and id of identity
+func hasGroupIdentity(group *contracts.Group, identity *contracts.GroupIdentity) bool {
+	for _, i := range group.Identities {
+		if i.Provider == identity.Provider && i.ID == identity.ID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupRoles returns the roles of the estafette group as strings
+func groupRoles(group *contracts.Group) []string {
+	roles := make([]string, 0, len(group.Roles))
+	for _, r := range group.Roles {
+		if r != nil {
+			roles = append(roles, *r)
+		}
+	}
+
+	return roles
+}
+
+func setGroupRoles(group *contracts.Group, roles []string) {
+	group.Roles = make([]*string, 0, len(roles))
+	for _, r := range roles {
+		role := r
+		group.Roles = append(group.Roles, &role)
+	}
+}
+
+// groupOrganizations returns the names of the organizations of the estafette group
+func groupOrganizations(group *contracts.Group) []string {
+	organizations := make([]string, 0, len(group.Organizations))
+	for _, o := range group.Organizations {
+		organizations = append(organizations, o.Name)
+	}
+
+	return organizations
+}
+
+func setGroupOrganizations(group *contracts.Group, names []string) {
+	organizations := make([]*contracts.Organization, 0, len(names))
+	for _, name := range names {
+		organization := &contracts.Organization{Name: name}
+		// keep organizations the group already has as they are, so their ids are retained
+		for _, o := range group.Organizations {
+			if o.Name == name {
+				organization = o
+			}
+		}
+		organizations = append(organizations, organization)
+	}
+	group.Organizations = organizations
+}
+
+// desiredGroupFields returns the group fields the syncer applies for the directory group, as recorded in the last-applied snapshot
+func desiredGroupFields(directoryGroup *DirectoryGroup, options planOptions) *reconcile.GroupFields {
+	fields := &reconcile.GroupFields{
+		Name: options.groupName(directoryGroup),
+	}
+	if annotations := options.groupAnnotations(directoryGroup); annotations != nil {
+		fields.Roles = annotations.Roles
+		fields.Organizations = annotations.Organizations
+	}
+
+	return fields
+}
+
+// revokeGroupRoles removes the roles of the groups the user was removed from from the user itself, unless one of the groups the user stays in has them as well, so a role estafette copied onto the user doesn't outlive the group that granted it; it returns whether any role was revoked
+func revokeGroupRoles(user, before *contracts.User, groupsByID map[string]*contracts.Group) (changed bool) {
+	if len(user.Roles) == 0 {
+		return false
+	}
+
+	lostRoles := map[string]bool{}
+	for _, bg := range before.Groups {
+		if g, ok := groupsByID[bg.ID]; ok {
+			for _, r := range groupRoles(g) {
+				lostRoles[r] = true
+			}
+		}
+	}
+	// roles of groups the user stays in aren't lost
+	for _, ug := range user.Groups {
+		if g, ok := groupsByID[ug.ID]; ok {
+			for _, r := range groupRoles(g) {
+				delete(lostRoles, r)
+			}
+		}
+	}
+	if len(lostRoles) == 0 {
+		return false
+	}
+
+	roles := make([]*string, 0, len(user.Roles))
+	for _, r := range user.Roles {
+		if r != nil && lostRoles[*r] {
+			changed = true
+			continue
+		}
+		roles = append(roles, r)
+	}
+	user.Roles = roles
+
+	return
+}
+
+// revokedRoles returns the roles the user had before and doesn't have anymore
+func revokedRoles(before, user *contracts.User) (roles []string) {
+	if before == nil || user == nil {
+		return nil
+	}
+
+	kept := map[string]bool{}
+	for _, r := range user.Roles {
+		if r != nil {
+			kept[*r] = true
+		}
+	}
+	for _, r := range before.Roles {
+		if r != nil && !kept[*r] {
+			roles = append(roles, *r)
+		}
+	}
+
+	return
+}
+
+// indexGroupsByID returns the groups by their estafette id
+func indexGroupsByID(groups []*contracts.Group) map[string]*contracts.Group {
+	groupsByID := make(map[string]*contracts.Group, len(groups))
+	for _, g := range groups {
+		groupsByID[g.ID] = g
+	}
+
+	return groupsByID
+}
+
+// applyDirectoryUser sets the profile of the directory user on the matching identities and its attributes as properties of the estafette user and returns whether it changed
+func applyDirectoryUser(user *contracts.User, provider Provider, directoryUser *DirectoryUser) (changed bool) {
+	attributes := directoryUser.Attributes
+
+	if directoryUser.Profile != nil {
+		member := &DirectoryMember{ID: directoryUser.ID, Email: directoryUser.Email}
+		for _, ui := range user.Identities {
+			if !identityMatchesMember(ui, provider, member) {
+				continue
+			}
+			if directoryUser.Profile.Name != "" && ui.Name != directoryUser.Profile.Name {
+				ui.Name = directoryUser.Profile.Name
+				changed = true
+			}
+			if ui.Avatar != directoryUser.Profile.AvatarURL {
+				ui.Avatar = directoryUser.Profile.AvatarURL
+				changed = true
+			}
+		}
+
+		// the identities have no fields for given and family name, so they're stored as properties
+		attributes = map[string]string{
+			profileGivenNameProperty:  directoryUser.Profile.GivenName,
+			profileFamilyNameProperty: directoryUser.Profile.FamilyName,
+		}
+		for property, value := range directoryUser.Attributes {
+			attributes[property] = value
+		}
+	}
+
+	for property, value := range attributes {
+		current, hasProperty := user.Preferences[property]
+		switch {
+		case value == "" && hasProperty:
+			delete(user.Preferences, property)
+			changed = true
+		case value != "" && (!hasProperty || current != value):
+			if user.Preferences == nil {
+				user.Preferences = map[string]interface{}{}
+			}
+			user.Preferences[property] = value
+			changed = true
+		}
+	}
+
+	return
+}
+
+// indexDirectoryUsers returns the directory users by the id of the estafette user they match with
+func indexDirectoryUsers(users []*contracts.User, provider Provider, directoryUsers []*DirectoryUser) map[string]*DirectoryUser {
+	index := map[string]*DirectoryUser{}
+	if len(directoryUsers) == 0 {
+		return index
+	}
+
+	usersByMemberKey := indexUsersByMemberKey(users, provider)
+	for _, du := range directoryUsers {
+		for _, u := range usersByMemberKey[memberKey(provider, &DirectoryMember{ID: du.ID, Email: du.Email})] {
+			index[u.ID] = du
+		}
+	}
+
+	return index
+}
+
+func getGroupsForUser(user *contracts.User, groups []*contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) (groupsForUser []*contracts.Group) {
+
+	groupsForUser = make([]*contracts.Group, 0)
+
+	for _, g := range groups {
+		for gg, members := range groupMembers {
+			// check estafette group identities for the provider and id equal to the directory group id
+			for _, i := range g.Identities {
+				if i.Provider == provider.Name() && i.ID == gg.ID {
+					// check members to see if any of them match one of the users providers
+					for _, m := range members {
+						if userMatchesMember(user, provider, m) {
+							groupsForUser = append(groupsForUser, g)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// userMatchesMember checks whether one of the user's identities belongs to the directory member
+func userMatchesMember(user *contracts.User, provider Provider, member *DirectoryMember) bool {
+	for _, ui := range user.Identities {
+		if identityMatchesMember(ui, provider, member) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// identityMatchesMember checks whether the user identity belongs to the directory member
+func identityMatchesMember(identity *contracts.UserIdentity, provider Provider, member *DirectoryMember) bool {
+	if provider.UserIdentityProvider() != "" {
+		return identity.Provider == provider.UserIdentityProvider() && identity.ID == member.ID
+	}
+
+	return identity.Email != "" && strings.EqualFold(identity.Email, member.Email)
+}
+
+// removalRatio returns the largest share of the existing group memberships, groups or user roles that the actions remove; memberships of deleted groups count as removed, so deleting groups doesn't bypass --max-change-ratio
+func removalRatio(actions []*Action, groups []*contracts.Group, users []*contracts.User) float64 {
+	memberships, roles := 0, 0
+	for _, u := range users {
+		memberships += len(u.Groups)
+		roles += len(u.Roles)
+	}
+
+	deletedGroups := map[string]bool{}
+	removedMemberships := map[string]bool{}
+	removedRoles := 0
+	for _, a := range actions {
+		switch a.Type {
+		case ActionDeleteGroup:
+			deletedGroups[a.GroupBefore.ID] = true
+		case ActionUpdateUser:
+			for _, g := range a.UserBefore.Groups {
+				if !userHasGroup(a.User, g.ID) {
+					removedMemberships[a.UserBefore.ID+"/"+g.ID] = true
+				}
+			}
+			removedRoles += len(revokedRoles(a.UserBefore, a.User))
+		}
+	}
+	for _, u := range users {
+		for _, g := range u.Groups {
+			if deletedGroups[g.ID] {
+				removedMemberships[u.ID+"/"+g.ID] = true
+			}
+		}
+	}
+
+	ratio := 0.0
+	if memberships > 0 {
+		ratio = math.Max(ratio, float64(len(removedMemberships))/float64(memberships))
+	}
+	if len(groups) > 0 {
+		ratio = math.Max(ratio, float64(len(deletedGroups))/float64(len(groups)))
+	}
+	if roles > 0 {
+		ratio = math.Max(ratio, float64(removedRoles)/float64(roles))
+	}
+
+	return ratio
+}
+
+// diffGroupNames returns the names of the groups only in after and the names of the groups only in before
+func diffGroupNames(before, after []*contracts.Group) (added, removed []string) {
+	for _, a := range after {
+		found := false
+		for _, b := range before {
+			if a.ID == b.ID {
+				found = true
+			}
+		}
+		if !found {
+			added = append(added, a.Name)
+		}
+	}
+	for _, b := range before {
+		found := false
+		for _, a := range after {
+			if a.ID == b.ID {
+				found = true
+			}
+		}
+		if !found {
+			removed = append(removed, b.Name)
+		}
+	}
+
+	return
+}
+
+// copyGroup returns a deep copy of the group, so it can be modified without affecting the fetched state
+func copyGroup(group *contracts.Group) *contracts.Group {
+	var groupCopy contracts.Group
+	bytes, _ := json.Marshal(group)
+	_ = json.Unmarshal(bytes, &groupCopy)
+
+	return &groupCopy
+}
+
+// copyUser returns a deep copy of the user, so it can be modified without affecting the fetched state
+func copyUser(user *contracts.User) *contracts.User {
+	var userCopy contracts.User
+	bytes, _ := json.Marshal(user)
+	_ = json.Unmarshal(bytes, &userCopy)
+
+	return &userCopy
+}
+
+// copyOrganization returns a deep copy of the organization, so it can be modified without affecting the fetched state
+func copyOrganization(organization *contracts.Organization) *contracts.Organization {
+	var organizationCopy contracts.Organization
+	bytes, _ := json.Marshal(organization)
+	_ = json.Unmarshal(bytes, &organizationCopy)
+
+	return &organizationCopy
+}
@@ -0,0 +1,814 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/estafette/estafette-ci-gsuite-synchronizer/reconcile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanGroupsAndMembers(t *testing.T) {
+	t.Run("ReturnsCreateGroupActionForNewDirectoryGroupWithMembers", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionCreateGroup, actions[0].Type)
+			assert.Equal(t, "platform", actions[0].Group.Name)
+			assert.Equal(t, gsuiteProviderName, actions[0].Group.Identities[0].Provider)
+			assert.Equal(t, "ci-platform@example.com", actions[0].Group.Identities[0].ID)
+		}
+	})
+
+	t.Run("ReturnsNoActionForNewDirectoryGroupWithoutMembers", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		assert.Equal(t, 0, len(actions))
+	})
+
+	t.Run("ReturnsUpdateGroupActionForRenamedDirectoryGroupWithoutModifyingFetchedGroup", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform-team"}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, "platform-team", actions[0].Group.Name)
+			assert.Equal(t, "platform", actions[0].GroupBefore.Name)
+			assert.Equal(t, "rename group platform to platform-team", actions[0].String())
+		}
+		assert.Equal(t, "platform", groups[0].Name)
+	})
+
+	t.Run("ReturnsUpdateUserActionForAddedAndRemovedMemberships", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+			{ID: "g2", Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-release@example.com", Name: "ci-release"}}},
+		}
+		users := []*contracts.User{
+			{
+				ID:         "u1",
+				Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}},
+				Groups:     []*contracts.Group{{ID: "g2", Name: "release"}},
+			},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234"}},
+			{ID: "ci-release@example.com", Name: "ci-release"}:   {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateUser, actions[0].Type)
+			assert.Equal(t, 1, len(actions[0].User.Groups))
+			assert.Equal(t, "g1", actions[0].User.Groups[0].ID)
+			assert.Equal(t, "update user john@example.com, add to groups platform, remove from groups release", actions[0].String())
+		}
+		assert.Equal(t, "g2", users[0].Groups[0].ID)
+	})
+
+	t.Run("ReturnsCreateGroupActionWithAnnotatedRolesAndOrganizations", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Annotations: &GroupAnnotations{Roles: []string{"administrator"}, Organizations: []string{"retail"}}}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionCreateGroup, actions[0].Type)
+			assert.Equal(t, "administrator", *actions[0].Group.Roles[0])
+			assert.Equal(t, "retail", actions[0].Group.Organizations[0].Name)
+		}
+	})
+
+	t.Run("ReturnsUpdateGroupActionForChangedAnnotatedRoles", func(t *testing.T) {
+
+		operator := "operator"
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}, Roles: []*string{&operator}, Organizations: []*contracts.Organization{{ID: "o1", Name: "retail"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Annotations: &GroupAnnotations{Roles: []string{"administrator"}}}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, 1, len(actions[0].Group.Roles))
+			assert.Equal(t, "administrator", *actions[0].Group.Roles[0])
+			assert.Equal(t, "o1", actions[0].Group.Organizations[0].ID)
+		}
+		assert.Equal(t, "operator", *groups[0].Roles[0])
+	})
+
+	t.Run("ReturnsNoActionIfAnnotationsMatch", func(t *testing.T) {
+
+		operator := "operator"
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}, Roles: []*string{&operator}, Organizations: []*contracts.Organization{{ID: "o1", Name: "retail"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Annotations: &GroupAnnotations{Roles: []string{"operator"}, Organizations: []string{"retail"}}}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		assert.Equal(t, 0, len(actions))
+	})
+}
+
+func TestPlanGroupsAndMembersWithOrganizationRules(t *testing.T) {
+	t.Run("ReturnsCreateGroupActionWithOrganizationOfFirstMatchingRule", func(t *testing.T) {
+
+		rules, err := parseOrganizationRules([]string{`^ci-retail-.*@example\.com$=retail`, `.*=default`}, []*contracts.Organization{{ID: "o1", Name: "retail"}, {ID: "o2", Name: "default"}})
+		assert.Nil(t, err)
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-retail-platform@example.com", Name: "ci-retail-platform", Email: "ci-retail-platform@example.com"}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, organizationRules: rules})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionCreateGroup, actions[0].Type)
+			assert.Equal(t, 1, len(actions[0].Group.Organizations))
+			assert.Equal(t, "o1", actions[0].Group.Organizations[0].ID)
+		}
+	})
+}
+
+func TestPlanGroupsAndMembersWithProtectedGroups(t *testing.T) {
+	t.Run("ReturnsNoActionsForProtectedGroupsOrTheirMemberships", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "admins", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-admins@example.com", Name: "ci-admins"}}},
+			{ID: "g2", Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-release@example.com", Name: "ci-release"}}},
+		}
+		users := []*contracts.User{
+			{
+				ID:         "u1",
+				Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}},
+				Groups:     []*contracts.Group{{ID: "g2", Name: "release"}},
+			},
+			{
+				ID:         "u2",
+				Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "5678", Email: "jane@example.com"}},
+			},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-admins@example.com", Name: "ci-administrators"}: {{ID: "5678"}},
+			{ID: "ci-release@example.com", Name: "ci-release"}:       {},
+			{ID: "ci-platform@example.com", Name: "ci-platform"}:     {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, protectedGroups: []string{"g1", "release", "platform"}})
+
+		assert.Equal(t, 0, len(actions))
+	})
+}
+
+func TestPlanGroupsAndMembersWithLastApplied(t *testing.T) {
+	t.Run("KeepsRolesAndNameEditedInEstafette", func(t *testing.T) {
+
+		operator := "operator"
+		administrator := "administrator"
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform-engineering", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}, Roles: []*string{&operator, &administrator}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Annotations: &GroupAnnotations{Roles: []string{"operator"}}}: {},
+		}
+		lastApplied := reconcile.NewSnapshot()
+		lastApplied.Groups[reconcile.GroupKey(gsuiteProviderName, "ci-platform@example.com")] = &reconcile.GroupFields{Name: "platform", Roles: []string{"operator"}}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, lastApplied: lastApplied})
+
+		assert.Equal(t, 0, len(actions))
+	})
+
+	t.Run("RemovesRolesRemovedFromDirectoryOnly", func(t *testing.T) {
+
+		operator := "operator"
+		administrator := "administrator"
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}, Roles: []*string{&operator, &administrator}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Annotations: &GroupAnnotations{Roles: []string{}}}: {},
+		}
+		lastApplied := reconcile.NewSnapshot()
+		lastApplied.Groups[reconcile.GroupKey(gsuiteProviderName, "ci-platform@example.com")] = &reconcile.GroupFields{Name: "platform", Roles: []string{"operator"}}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, lastApplied: lastApplied})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, 1, len(actions[0].Group.Roles))
+			assert.Equal(t, "administrator", *actions[0].Group.Roles[0])
+		}
+	})
+}
+
+func TestPlanGroupsAndMembersWithManagedFields(t *testing.T) {
+	t.Run("LeavesUnmanagedRolesAndMembershipsAlone", func(t *testing.T) {
+
+		operator := "operator"
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}, Roles: []*string{&operator}},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform-team", Annotations: &GroupAnnotations{Roles: []string{"administrator"}}}: {{ID: "1234"}},
+		}
+		managedFields, err := parseManagedFields("name,identities")
+		assert.Nil(t, err)
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, managedFields: managedFields})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, "rename group platform to platform-team", actions[0].String())
+			assert.Equal(t, "operator", *actions[0].Group.Roles[0])
+		}
+	})
+
+	t.Run("ReturnsErrorForUnknownField", func(t *testing.T) {
+
+		// act
+		_, err := parseManagedFields("name,description")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestPlanGroupsAndMembersWithEmptyGroups(t *testing.T) {
+	t.Run("ReturnsCreateGroupActionForEmptyDirectoryGroupIfSyncEmptyGroupsIsSet", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, syncEmptyGroups: true})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, "create group platform", actions[0].String())
+		}
+	})
+
+	t.Run("ReturnsDeleteGroupActionForGroupWithoutDirectoryMembersIfCleanupEmptyGroupsIsSet", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+			{ID: "g2", Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-release@example.com", Name: "ci-release"}, {Provider: gsuiteProviderName, ID: "ci-release-managers@example.com", Name: "ci-release-managers"}}},
+			{ID: "g3", Name: "legacy", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-legacy@example.com", Name: "ci-legacy"}}},
+			{ID: "g4", Name: "admins"},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234"}}, Groups: []*contracts.Group{{ID: "g1", Name: "platform"}, {ID: "g2", Name: "release"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}:                 {},
+			{ID: "ci-release@example.com", Name: "ci-release"}:                   {},
+			{ID: "ci-release-managers@example.com", Name: "ci-release-managers"}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, cleanupEmptyGroups: true})
+
+		descriptions := []string{}
+		for _, a := range actions {
+			descriptions = append(descriptions, a.String())
+		}
+		assert.Contains(t, descriptions, "delete group platform")
+		assert.Contains(t, descriptions, "update user , remove from groups platform")
+		assert.NotContains(t, descriptions, "delete group release")
+		assert.NotContains(t, descriptions, "delete group legacy")
+	})
+}
+
+func TestPlanGroupsAndMembersWithGroupDomains(t *testing.T) {
+	t.Run("RecordsDomainOfDirectoryGroupOnCreatedGroup", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-platform", Email: "ci-platform@eu.example.com", Domain: "eu.example.com"}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionCreateGroup, actions[0].Type)
+			assert.Contains(t, actions[0].Group.Identities, &contracts.GroupIdentity{Provider: "gsuite-domain", ID: "0abc", Name: "eu.example.com"})
+		}
+	})
+}
+
+func TestPlanGroupsAndMembersWithDynamicGroups(t *testing.T) {
+	t.Run("MarksGroupCreatedForDynamicDirectoryGroupWithItsQuery", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Dynamic: &DirectoryGroupDynamic{Query: "user.organizations.exists(org, org.department=='platform')"}}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionCreateGroup, actions[0].Type)
+			assert.Contains(t, actions[0].Group.Identities, &contracts.GroupIdentity{Provider: "gsuite-dynamic", ID: "ci-platform@example.com", Name: "user.organizations.exists(org, org.department=='platform')"})
+		}
+	})
+
+	t.Run("RemovesMarkOnceDirectoryGroupIsNotDynamicAnymore", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}, {Provider: "gsuite-dynamic", ID: "ci-platform@example.com", Name: "user.is_active"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}, actions[0].Group.Identities)
+		}
+	})
+}
+
+func TestPlanGroupsAndMembersWithGroupDescriptions(t *testing.T) {
+	t.Run("RecordsDescriptionOfDirectoryGroupOnCreatedGroup", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Description: "Builds and runs the ci platform"}: {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, syncDescriptions: true})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Contains(t, actions[0].Group.Identities, &contracts.GroupIdentity{Provider: "gsuite-description", ID: "ci-platform@example.com", Name: "Builds and runs the ci platform"})
+		}
+	})
+
+	t.Run("UpdatesDescriptionChangedInDirectory", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}, {Provider: "gsuite-description", ID: "ci-platform@example.com", Name: "Runs the ci platform"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Description: "Builds and runs the ci platform"}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, syncDescriptions: true})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Contains(t, actions[0].Group.Identities, &contracts.GroupIdentity{Provider: "gsuite-description", ID: "ci-platform@example.com", Name: "Builds and runs the ci platform"})
+		}
+	})
+
+	t.Run("RemovesDescriptionClearedInDirectory", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}, {Provider: "gsuite-description", ID: "ci-platform@example.com", Name: "Runs the ci platform"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, syncDescriptions: true})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}, actions[0].Group.Identities)
+		}
+	})
+
+	t.Run("LeavesDescriptionsAloneWhenDisabled", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform", Description: "Builds and runs the ci platform"}: {},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, []*contracts.User{}, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		assert.Equal(t, 0, len(actions))
+	})
+}
+
+func TestPlanGroupsAndMembersWithRoleRevocation(t *testing.T) {
+	administrator, operator := "administrator", "operator"
+
+	t.Run("RevokesRoleOfGroupUserIsRemovedFrom", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Roles: []*string{&administrator}, Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}}, Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}, Roles: []*string{&administrator, &operator}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "5678"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, []*string{&operator}, actions[0].User.Roles)
+			assert.Equal(t, "update user john@example.com, remove from groups platform, revoke roles administrator", actions[0].String())
+		}
+	})
+
+	t.Run("KeepsRoleAnotherGroupOfTheUserGrants", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Roles: []*string{&administrator}, Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+			{ID: "g2", Name: "release", Roles: []*string{&administrator}, Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-release@example.com", Name: "ci-release"}}},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}}, Groups: []*contracts.Group{{ID: "g1", Name: "platform"}, {ID: "g2", Name: "release"}}, Roles: []*string{&administrator}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "5678"}},
+			{ID: "ci-release@example.com", Name: "ci-release"}:   {{ID: "1234"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, groupMembers, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, []*string{&administrator}, actions[0].User.Roles)
+		}
+	})
+}
+
+func TestPlanGroupsAndMembersWithNameConflicts(t *testing.T) {
+
+	// both directory groups map to platform after stripping the suffix
+	transforms, _ := compileNameTransforms([]*NameTransform{{StripSuffix: "-team"}})
+	newGroupMembers := func() map[*DirectoryGroup][]*DirectoryMember {
+		return map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}:           {{ID: "1234"}},
+			{ID: "ci-platform-team@example.com", Name: "ci-platform-team"}: {{ID: "5678"}},
+		}
+	}
+
+	t.Run("SkipsDirectoryGroupsLosingTheName", func(t *testing.T) {
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, newGroupMembers(), nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, nameTransforms: transforms, nameConflictResolution: nameConflictSkip})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, "create group platform", actions[0].String())
+			assert.Equal(t, "ci-platform-team@example.com", actions[0].Group.Identities[0].ID)
+		}
+	})
+
+	t.Run("SuffixesDirectoryGroupsLosingTheName", func(t *testing.T) {
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, []*contracts.User{}, &gsuiteClient{}, newGroupMembers(), nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, nameTransforms: transforms, nameConflictResolution: nameConflictSuffix})
+
+		names := []string{}
+		for _, a := range actions {
+			names = append(names, a.Group.Name)
+		}
+		assert.ElementsMatch(t, []string{"platform", "platform (ci-platform@example.com)"}, names)
+	})
+
+	t.Run("MergesDirectoryGroupsIntoGroupHoldingTheName", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234"}}, Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}},
+			{ID: "u2", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "5678"}}},
+		}
+		options := planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}, nameTransforms: transforms, nameConflictResolution: nameConflictMerge}
+
+		// act
+		actions := planGroupsAndMembers(groups, users, &gsuiteClient{}, newGroupMembers(), nil, options)
+
+		if assert.Equal(t, 2, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, 2, len(actions[0].Group.Identities))
+			assert.Equal(t, "ci-platform-team@example.com", actions[0].Group.Identities[1].ID)
+			assert.Equal(t, "update user , add to groups platform", actions[1].String())
+
+			// act
+			conflicts := detectNameConflicts([]*contracts.Group{actions[0].Group}, &gsuiteClient{}, newGroupMembers(), options)
+
+			assert.Equal(t, 0, len(conflicts))
+		}
+	})
+}
+
+func TestDetectNameConflicts(t *testing.T) {
+	t.Run("KeepsNameForDirectoryGroupWhoseEstafetteGroupHoldsIt", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform-team@example.com", Name: "ci-platform-team"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "ci-platform@example.com", Name: "ci-platform"}: {{ID: "1234"}},
+		}
+
+		// act
+		conflicts := detectNameConflicts(groups, &gsuiteClient{}, groupMembers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(conflicts)) {
+			assert.Equal(t, "directory groups ci-platform-team@example.com, ci-platform@example.com map to estafette group platform, resolved by skip", conflicts[0].String())
+		}
+	})
+}
+
+func TestPlanOrganizations(t *testing.T) {
+	t.Run("ReturnsCreateAndRenameActionsForResourceHierarchy", func(t *testing.T) {
+
+		organizations := []*contracts.Organization{
+			{ID: "o1", Name: "example.com", Identities: []*contracts.OrganizationIdentity{{Provider: gcpProviderName, ID: "organizations/123", Name: "example.com"}}},
+			{ID: "o2", Name: "example.com/retail", Identities: []*contracts.OrganizationIdentity{{Provider: gcpProviderName, ID: "folders/456", Name: "retail"}}},
+		}
+		nodes := []*ResourceNode{
+			{ID: "organizations/123", Name: "example.com", Path: "example.com"},
+			{ID: "folders/456", Name: "retail-europe", Path: "example.com/retail-europe"},
+			{ID: "projects/shop", Name: "shop", Path: "example.com/retail-europe/shop"},
+		}
+
+		// act
+		actions := planOrganizations(organizations, nodes)
+
+		if assert.Equal(t, 2, len(actions)) {
+			assert.Equal(t, "rename organization example.com/retail to example.com/retail-europe", actions[0].String())
+			assert.Equal(t, "retail-europe", actions[0].Organization.Identities[0].Name)
+			assert.Equal(t, "create organization example.com/retail-europe/shop", actions[1].String())
+			assert.Equal(t, "projects/shop", actions[1].Organization.Identities[0].ID)
+		}
+		assert.Equal(t, "example.com/retail", organizations[1].Name)
+	})
+}
+
+func TestParseOrganizationRules(t *testing.T) {
+	t.Run("ReturnsErrorForUnknownOrganization", func(t *testing.T) {
+
+		// act
+		_, err := parseOrganizationRules([]string{".*=retail"}, []*contracts.Organization{{ID: "o2", Name: "default"}})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForRuleWithoutOrganization", func(t *testing.T) {
+
+		// act
+		_, err := parseOrganizationRules([]string{".*"}, []*contracts.Organization{})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("SplitsOnLastEqualsSign", func(t *testing.T) {
+
+		// act
+		rules, err := parseOrganizationRules([]string{"a=b=retail"}, []*contracts.Organization{{ID: "o1", Name: "retail"}})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(rules)) {
+			assert.Equal(t, "a=b", rules[0].pattern.String())
+		}
+	})
+}
+
+func TestPlanGroupsAndMembersWithDirectoryUsers(t *testing.T) {
+	t.Run("ReturnsUpdateUserActionForChangedProperties", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{
+				ID:          "u1",
+				Identities:  []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}},
+				Preferences: map[string]interface{}{"team": "platform", "costCenter": "1000"},
+			},
+		}
+		directoryUsers := []*DirectoryUser{
+			{ID: "1234", Email: "john@example.com", Attributes: map[string]string{"team": "release", "costCenter": "", "employeeID": "42"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, users, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{}, directoryUsers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, ActionUpdateUser, actions[0].Type)
+			assert.Equal(t, map[string]interface{}{"team": "release", "employeeID": "42"}, actions[0].User.Preferences)
+			assert.Equal(t, "update user john@example.com, update properties", actions[0].String())
+		}
+		assert.Equal(t, "platform", users[0].Preferences["team"])
+	})
+
+	t.Run("ReturnsUpdateUserActionForChangedProfile", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{
+				ID: "u1",
+				Identities: []*contracts.UserIdentity{
+					{Provider: googleProviderName, ID: "1234", Email: "john@example.com", Name: "John"},
+					{Provider: "github", ID: "5678", Name: "johnd"},
+				},
+			},
+		}
+		directoryUsers := []*DirectoryUser{
+			{ID: "1234", Email: "john@example.com", Profile: &DirectoryUserProfile{Name: "John Doe", GivenName: "John", FamilyName: "Doe", AvatarURL: "https://example.com/john.png"}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, users, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{}, directoryUsers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		if assert.Equal(t, 1, len(actions)) {
+			assert.Equal(t, "John Doe", actions[0].User.Identities[0].Name)
+			assert.Equal(t, "https://example.com/john.png", actions[0].User.Identities[0].Avatar)
+			assert.Equal(t, "johnd", actions[0].User.Identities[1].Name)
+			assert.Equal(t, map[string]interface{}{"givenName": "John", "familyName": "Doe"}, actions[0].User.Preferences)
+			assert.Equal(t, "update user john@example.com, update profile, update properties", actions[0].String())
+		}
+		assert.Equal(t, "John", users[0].Identities[0].Name)
+	})
+
+	t.Run("ReturnsNoActionIfPropertiesMatch", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{
+				ID:          "u1",
+				Identities:  []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}},
+				Preferences: map[string]interface{}{"team": "platform"},
+			},
+		}
+		directoryUsers := []*DirectoryUser{
+			{ID: "1234", Email: "john@example.com", Attributes: map[string]string{"team": "platform", "costCenter": ""}},
+		}
+
+		// act
+		actions := planGroupsAndMembers([]*contracts.Group{}, users, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{}, directoryUsers, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}})
+
+		assert.Equal(t, 0, len(actions))
+	})
+}
+
+func TestRemovalRatio(t *testing.T) {
+	t.Run("ReturnsShareOfExistingMembershipsRemoved", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{ID: "u1", Groups: []*contracts.Group{{ID: "g1"}, {ID: "g2"}}},
+			{ID: "u2", Groups: []*contracts.Group{{ID: "g1"}, {ID: "g2"}}},
+		}
+		actions := []*Action{
+			{Type: ActionUpdateUser, UserBefore: users[0], User: &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g3"}}}},
+			{Type: ActionCreateGroup, Group: &contracts.Group{Name: "g4"}},
+		}
+
+		// act
+		ratio := removalRatio(actions, []*contracts.Group{{ID: "g1"}, {ID: "g2"}}, users)
+
+		assert.Equal(t, 0.5, ratio)
+	})
+
+	t.Run("CountsMembershipsOfDeletedGroupsAsRemoved", func(t *testing.T) {
+
+		groups := []*contracts.Group{{ID: "g1"}, {ID: "g2"}, {ID: "g3"}, {ID: "g4"}, {ID: "g5"}}
+		users := []*contracts.User{
+			{ID: "u1", Groups: []*contracts.Group{{ID: "g1"}, {ID: "g2"}}},
+			{ID: "u2", Groups: []*contracts.Group{{ID: "g1"}, {ID: "g3"}}},
+		}
+		actions := []*Action{
+			{Type: ActionUpdateUser, UserBefore: users[0], User: &contracts.User{ID: "u1", Groups: []*contracts.Group{{ID: "g2"}}}},
+			{Type: ActionDeleteGroup, GroupBefore: groups[0], Group: groups[0]},
+		}
+
+		// act
+		ratio := removalRatio(actions, groups, users)
+
+		assert.Equal(t, 0.5, ratio)
+	})
+
+	t.Run("CountsDeletedGroupsWithoutMemberships", func(t *testing.T) {
+
+		groups := []*contracts.Group{{ID: "g1"}, {ID: "g2"}, {ID: "g3"}, {ID: "g4"}}
+		actions := []*Action{
+			{Type: ActionDeleteGroup, GroupBefore: groups[0], Group: groups[0]},
+			{Type: ActionDeleteGroup, GroupBefore: groups[1], Group: groups[1]},
+			{Type: ActionDeleteGroup, GroupBefore: groups[2], Group: groups[2]},
+		}
+
+		// act
+		ratio := removalRatio(actions, groups, []*contracts.User{{ID: "u1", Groups: []*contracts.Group{{ID: "g4"}}}})
+
+		assert.Equal(t, 0.75, ratio)
+	})
+
+	t.Run("CountsRevokedUserRoles", func(t *testing.T) {
+
+		administrator, operator := "administrator", "operator"
+		users := []*contracts.User{
+			{ID: "u1", Roles: []*string{&administrator, &operator}},
+		}
+		actions := []*Action{
+			{Type: ActionUpdateUser, UserBefore: users[0], User: &contracts.User{ID: "u1", Roles: []*string{&operator}}},
+		}
+
+		// act
+		ratio := removalRatio(actions, []*contracts.Group{}, users)
+
+		assert.Equal(t, 0.5, ratio)
+	})
+
+	t.Run("ReturnsZeroIfUsersHaveNoMemberships", func(t *testing.T) {
+
+		// act
+		ratio := removalRatio([]*Action{}, []*contracts.Group{}, []*contracts.User{{ID: "u1"}})
+
+		assert.Equal(t, 0.0, ratio)
+	})
+}
+
+func TestUserMatchesMember(t *testing.T) {
+	t.Run("ReturnsTrueIfGoogleIdentityIDMatchesGsuiteMemberID", func(t *testing.T) {
+
+		user := &contracts.User{
+			Identities: []*contracts.UserIdentity{
+				{Provider: googleProviderName, ID: "1234", Email: "john@example.com"},
+			},
+		}
+
+		// act
+		matches := userMatchesMember(user, &gsuiteClient{}, &DirectoryMember{ID: "1234", Email: "other@example.com"})
+
+		assert.True(t, matches)
+	})
+
+	t.Run("ReturnsFalseIfOnlyEmailMatchesGsuiteMember", func(t *testing.T) {
+
+		user := &contracts.User{
+			Identities: []*contracts.UserIdentity{
+				{Provider: googleProviderName, ID: "1234", Email: "john@example.com"},
+			},
+		}
+
+		// act
+		matches := userMatchesMember(user, &gsuiteClient{}, &DirectoryMember{ID: "5678", Email: "john@example.com"})
+
+		assert.False(t, matches)
+	})
+
+	t.Run("ReturnsTrueIfEmailMatchesLdapMemberCaseInsensitively", func(t *testing.T) {
+
+		user := &contracts.User{
+			Identities: []*contracts.UserIdentity{
+				{Provider: googleProviderName, ID: "1234", Email: "John@Example.com"},
+			},
+		}
+
+		// act
+		matches := userMatchesMember(user, &ldapClient{}, &DirectoryMember{ID: "uid=john,ou=people,dc=example,dc=com", Email: "john@example.com"})
+
+		assert.True(t, matches)
+	})
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/go-plugin"
+	"github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+)
+
+const (
+	pluginProviderName = "plugin"
+
+	// pluginDispenseName is the name the directory provider is served under in the plugin set
+	pluginDispenseName = "directory"
+)
+
+// pluginHandshake makes sure the executable is a directory provider speaking the same protocol version; version 1 was json-rpc over stdin and stdout
+var pluginHandshake = plugin.HandshakeConfig{
+	ProtocolVersion:  2,
+	MagicCookieKey:   "ESTAFETTE_DIRECTORY_PROVIDER_PLUGIN",
+	MagicCookieValue: "directory-provider",
+}
+
+// PluginClient is a Provider backed by an out-of-tree executable, so custom directories can be synchronized without forking the syncer.
+//
+// The plugin is served with hashicorp/go-plugin and implements the DirectoryProvider grpc service of directoryProvider.proto. It's started
+// once when the client is created and stopped with Close; anything it writes to stderr ends up in the syncer logs, at the level of an hclog
+// json line or a [DEBUG], [INFO], [WARN] or [ERROR] prefix, and at debug level otherwise.
+type PluginClient interface {
+	UserProvider
+	Close() error
+}
+
+// DirectoryProviderPlugin is the DirectoryProvider grpc service of directoryProvider.proto
+type DirectoryProviderPlugin interface {
+	Describe(ctx context.Context, request *PluginRequest) (*PluginDescribeResponse, error)
+	GetGroupsWithMembers(ctx context.Context, request *PluginRequest) (*PluginGroupsResponse, error)
+	// GetDirectoryUsers is only called if user profiles or properties are synchronized
+	GetDirectoryUsers(ctx context.Context, request *PluginRequest) (*PluginUsersResponse, error)
+}
+
+// NewPluginClient starts the plugin and returns a new PluginClient for it, after the handshake checked it speaks the same protocol version
+func NewPluginClient(ctx context.Context, pluginPath string, pluginArgs []string) (PluginClient, error) {
+	process := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  pluginHandshake,
+		Plugins:          map[string]plugin.Plugin{pluginDispenseName: &directoryProviderGRPCPlugin{}},
+		Cmd:              exec.Command(pluginPath, pluginArgs...),
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolGRPC},
+		Logger:           newPluginLogger(logFromContext(ctx)),
+	})
+
+	rpcClient, err := process.Client()
+	if err != nil {
+		process.Kill()
+		return nil, fmt.Errorf("Failed starting plugin: %w", err)
+	}
+	dispensed, err := rpcClient.Dispense(pluginDispenseName)
+	if err != nil {
+		process.Kill()
+		return nil, fmt.Errorf("Failed connecting to plugin: %w", err)
+	}
+
+	c, err := newPluginClient(ctx, dispensed.(DirectoryProviderPlugin), process.Kill)
+	if err != nil {
+		process.Kill()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func newPluginClient(ctx context.Context, provider DirectoryProviderPlugin, kill func()) (*pluginClient, error) {
+	response, err := provider.Describe(ctx, &PluginRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed calling plugin method Describe: %w", err)
+	}
+	if response.Name == "" {
+		return nil, fmt.Errorf("Plugin didn't return a name")
+	}
+
+	return &pluginClient{
+		provider:             provider,
+		kill:                 kill,
+		name:                 response.Name,
+		userIdentityProvider: response.UserIdentityProvider,
+	}, nil
+}
+
+type pluginClient struct {
+	provider             DirectoryProviderPlugin
+	kill                 func()
+	name                 string
+	userIdentityProvider string
+}
+
+// PluginRequest is the parameter of every plugin method; it's empty for now, but leaves room to pass settings in later protocol versions
+type PluginRequest struct{}
+
+type PluginDescribeResponse struct {
+	// Name is stored as provider in the identities of groups created from this plugin
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// UserIdentityProvider is the estafette user identity provider member ids are matched against; if empty members are matched by email address
+	UserIdentityProvider string `protobuf:"bytes,2,opt,name=user_identity_provider,json=userIdentityProvider,proto3" json:"userIdentityProvider,omitempty"`
+}
+
+type PluginGroupsResponse struct {
+	Groups []*PluginGroup `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+}
+
+type PluginGroup struct {
+	// ID has to be stable across runs
+	ID    string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name  string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Email string `protobuf:"bytes,3,opt,name=email,proto3" json:"email,omitempty"`
+	// Roles and Organizations are applied to the estafette group like the annotations in a gsuite group description
+	Roles         []string        `protobuf:"bytes,4,rep,name=roles,proto3" json:"roles,omitempty"`
+	Organizations []string        `protobuf:"bytes,5,rep,name=organizations,proto3" json:"organizations,omitempty"`
+	Members       []*PluginMember `protobuf:"bytes,6,rep,name=members,proto3" json:"members,omitempty"`
+}
+
+type PluginMember struct {
+	ID    string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Email string `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+}
+
+type PluginUsersResponse struct {
+	Users []*PluginUser `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+}
+
+type PluginUser struct {
+	ID         string             `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Email      string             `protobuf:"bytes,2,opt,name=email,proto3" json:"email,omitempty"`
+	Profile    *PluginUserProfile `protobuf:"bytes,3,opt,name=profile,proto3" json:"profile,omitempty"`
+	Attributes map[string]string  `protobuf:"bytes,4,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+type PluginUserProfile struct {
+	Name       string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	GivenName  string `protobuf:"bytes,2,opt,name=given_name,json=givenName,proto3" json:"givenName,omitempty"`
+	FamilyName string `protobuf:"bytes,3,opt,name=family_name,json=familyName,proto3" json:"familyName,omitempty"`
+	AvatarURL  string `protobuf:"bytes,4,opt,name=avatar_url,json=avatarUrl,proto3" json:"avatarURL,omitempty"`
+}
+
+// the messages are written by hand after directoryProvider.proto, so building the syncer doesn't need protoc; the struct tags are all the proto package needs to marshal them
+func (m *PluginRequest) Reset()                  { *m = PluginRequest{} }
+func (m *PluginRequest) String() string          { return proto.CompactTextString(m) }
+func (*PluginRequest) ProtoMessage()             {}
+func (m *PluginDescribeResponse) Reset()         { *m = PluginDescribeResponse{} }
+func (m *PluginDescribeResponse) String() string { return proto.CompactTextString(m) }
+func (*PluginDescribeResponse) ProtoMessage()    {}
+func (m *PluginGroupsResponse) Reset()           { *m = PluginGroupsResponse{} }
+func (m *PluginGroupsResponse) String() string   { return proto.CompactTextString(m) }
+func (*PluginGroupsResponse) ProtoMessage()      {}
+func (m *PluginGroup) Reset()                    { *m = PluginGroup{} }
+func (m *PluginGroup) String() string            { return proto.CompactTextString(m) }
+func (*PluginGroup) ProtoMessage()               {}
+func (m *PluginMember) Reset()                   { *m = PluginMember{} }
+func (m *PluginMember) String() string           { return proto.CompactTextString(m) }
+func (*PluginMember) ProtoMessage()              {}
+func (m *PluginUsersResponse) Reset()            { *m = PluginUsersResponse{} }
+func (m *PluginUsersResponse) String() string    { return proto.CompactTextString(m) }
+func (*PluginUsersResponse) ProtoMessage()       {}
+func (m *PluginUser) Reset()                     { *m = PluginUser{} }
+func (m *PluginUser) String() string             { return proto.CompactTextString(m) }
+func (*PluginUser) ProtoMessage()                {}
+func (m *PluginUserProfile) Reset()              { *m = PluginUserProfile{} }
+func (m *PluginUserProfile) String() string      { return proto.CompactTextString(m) }
+func (*PluginUserProfile) ProtoMessage()         {}
+
+func (c *pluginClient) Name() string {
+	return c.name
+}
+
+func (c *pluginClient) UserIdentityProvider() string {
+	return c.userIdentityProvider
+}
+
+func (c *pluginClient) GetGroupsWithMembers(ctx context.Context) (groupMembers map[*DirectoryGroup][]*DirectoryMember, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PluginClient::GetGroupsWithMembers")
+	defer span.Finish()
+
+	response, err := c.provider.GetGroupsWithMembers(ctx, &PluginRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed calling plugin method GetGroupsWithMembers: %w", err)
+	}
+
+	groupMembers = map[*DirectoryGroup][]*DirectoryMember{}
+	for _, g := range response.Groups {
+		group := &DirectoryGroup{
+			ID:    g.ID,
+			Name:  g.Name,
+			Email: g.Email,
+		}
+		if len(g.Roles) > 0 || len(g.Organizations) > 0 {
+			group.Annotations = &GroupAnnotations{
+				Roles:         g.Roles,
+				Organizations: g.Organizations,
+			}
+		}
+
+		members := make([]*DirectoryMember, 0, len(g.Members))
+		for _, m := range g.Members {
+			members = append(members, &DirectoryMember{
+				ID:    m.ID,
+				Email: m.Email,
+			})
+		}
+
+		groupMembers[group] = members
+	}
+
+	return
+}
+
+func (c *pluginClient) GetDirectoryUsers(ctx context.Context) (users []*DirectoryUser, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PluginClient::GetDirectoryUsers")
+	defer span.Finish()
+
+	response, err := c.provider.GetDirectoryUsers(ctx, &PluginRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed calling plugin method GetDirectoryUsers: %w", err)
+	}
+
+	users = make([]*DirectoryUser, 0, len(response.Users))
+	for _, u := range response.Users {
+		user := &DirectoryUser{
+			ID:         u.ID,
+			Email:      u.Email,
+			Attributes: u.Attributes,
+		}
+		if u.Profile != nil {
+			user.Profile = &DirectoryUserProfile{
+				Name:       u.Profile.Name,
+				GivenName:  u.Profile.GivenName,
+				FamilyName: u.Profile.FamilyName,
+				AvatarURL:  u.Profile.AvatarURL,
+			}
+		}
+
+		users = append(users, user)
+	}
+
+	return
+}
+
+// Close stops the plugin; the name and user identity provider remain available, since they're used after the directory is fetched
+func (c *pluginClient) Close() error {
+	if c.kill != nil {
+		c.kill()
+	}
+
+	return nil
+}
+
+// directoryProviderGRPCPlugin serves a DirectoryProviderPlugin with go-plugin, or dispenses a client for it; impl is only set on the plugin side
+type directoryProviderGRPCPlugin struct {
+	plugin.NetRPCUnsupportedPlugin
+	impl DirectoryProviderPlugin
+}
+
+func (p *directoryProviderGRPCPlugin) GRPCServer(broker *plugin.GRPCBroker, s *grpc.Server) error {
+	s.RegisterService(&directoryProviderServiceDesc, p.impl)
+	return nil
+}
+
+func (p *directoryProviderGRPCPlugin) GRPCClient(ctx context.Context, broker *plugin.GRPCBroker, conn *grpc.ClientConn) (interface{}, error) {
+	return &directoryProviderGRPCClient{conn: conn}, nil
+}
+
+// directoryProviderGRPCClient calls the DirectoryProvider grpc service in the plugin process
+type directoryProviderGRPCClient struct {
+	conn *grpc.ClientConn
+}
+
+func (c *directoryProviderGRPCClient) Describe(ctx context.Context, request *PluginRequest) (*PluginDescribeResponse, error) {
+	response := &PluginDescribeResponse{}
+	err := c.conn.Invoke(ctx, "/estafette.directory.v1.DirectoryProvider/Describe", request, response)
+	return response, err
+}
+
+func (c *directoryProviderGRPCClient) GetGroupsWithMembers(ctx context.Context, request *PluginRequest) (*PluginGroupsResponse, error) {
+	response := &PluginGroupsResponse{}
+	err := c.conn.Invoke(ctx, "/estafette.directory.v1.DirectoryProvider/GetGroupsWithMembers", request, response)
+	return response, err
+}
+
+func (c *directoryProviderGRPCClient) GetDirectoryUsers(ctx context.Context, request *PluginRequest) (*PluginUsersResponse, error) {
+	response := &PluginUsersResponse{}
+	err := c.conn.Invoke(ctx, "/estafette.directory.v1.DirectoryProvider/GetDirectoryUsers", request, response)
+	return response, err
+}
+
+// directoryProviderServiceDesc describes the DirectoryProvider grpc service of directoryProvider.proto for serving it
+var directoryProviderServiceDesc = grpc.ServiceDesc{
+	ServiceName: "estafette.directory.v1.DirectoryProvider",
+	HandlerType: (*DirectoryProviderPlugin)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Describe",
+			Handler: directoryProviderHandler("Describe", func(ctx context.Context, provider DirectoryProviderPlugin, request *PluginRequest) (interface{}, error) {
+				return provider.Describe(ctx, request)
+			}),
+		},
+		{
+			MethodName: "GetGroupsWithMembers",
+			Handler: directoryProviderHandler("GetGroupsWithMembers", func(ctx context.Context, provider DirectoryProviderPlugin, request *PluginRequest) (interface{}, error) {
+				return provider.GetGroupsWithMembers(ctx, request)
+			}),
+		},
+		{
+			MethodName: "GetDirectoryUsers",
+			Handler: directoryProviderHandler("GetDirectoryUsers", func(ctx context.Context, provider DirectoryProviderPlugin, request *PluginRequest) (interface{}, error) {
+				return provider.GetDirectoryUsers(ctx, request)
+			}),
+		},
+	},
+	Metadata: "directoryProvider.proto",
+}
+
+// directoryProviderHandler returns the grpc handler decoding the request of the method and passing it to the DirectoryProviderPlugin, through the interceptor if any
+func directoryProviderHandler(method string, call func(ctx context.Context, provider DirectoryProviderPlugin, request *PluginRequest) (interface{}, error)) func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		request := &PluginRequest{}
+		if err := dec(request); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(ctx, srv.(DirectoryProviderPlugin), request)
+		}
+
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/estafette.directory.v1.DirectoryProvider/" + method,
+		}
+		return interceptor(ctx, request, info, func(ctx context.Context, request interface{}) (interface{}, error) {
+			return call(ctx, srv.(DirectoryProviderPlugin), request.(*PluginRequest))
+		})
+	}
+}
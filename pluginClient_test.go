@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-plugin"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeDirectoryProvider serves the plugin grpc methods the way an out-of-tree provider would
+type fakeDirectoryProvider struct {
+	calls int32
+}
+
+func (p *fakeDirectoryProvider) Describe(ctx context.Context, request *PluginRequest) (*PluginDescribeResponse, error) {
+	atomic.AddInt32(&p.calls, 1)
+	return &PluginDescribeResponse{Name: "hr"}, nil
+}
+
+func (p *fakeDirectoryProvider) GetGroupsWithMembers(ctx context.Context, request *PluginRequest) (*PluginGroupsResponse, error) {
+	atomic.AddInt32(&p.calls, 1)
+	return &PluginGroupsResponse{
+		Groups: []*PluginGroup{
+			{ID: "1", Name: "platform", Roles: []string{"administrator"}, Members: []*PluginMember{{ID: "e1", Email: "john@example.com"}}},
+		},
+	}, nil
+}
+
+// GetDirectoryUsers returns the number of calls the provider served, so a test can tell all calls went to the same process
+func (p *fakeDirectoryProvider) GetDirectoryUsers(ctx context.Context, request *PluginRequest) (*PluginUsersResponse, error) {
+	calls := atomic.AddInt32(&p.calls, 1)
+	if os.Getenv("FAKE_PLUGIN_WITHOUT_USERS") != "" {
+		return nil, status.Error(codes.Unimplemented, "method GetDirectoryUsers not implemented")
+	}
+
+	return &PluginUsersResponse{
+		Users: []*PluginUser{
+			{ID: "e1", Email: "john@example.com", Profile: &PluginUserProfile{GivenName: "John"}, Attributes: map[string]string{"calls": strconv.Itoa(int(calls))}},
+		},
+	}, nil
+}
+
+// TestPluginHelperProcess serves the fake provider as a plugin when the test binary is started by NewPluginClient, which sets the handshake cookie
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv(pluginHandshake.MagicCookieKey) == "" {
+		return
+	}
+
+	handshake := pluginHandshake
+	if version, err := strconv.Atoi(os.Getenv("FAKE_PLUGIN_PROTOCOL_VERSION")); err == nil {
+		handshake.ProtocolVersion = uint(version)
+	}
+
+	fmt.Fprintln(os.Stderr, "[WARN] serving the fake directory")
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: handshake,
+		Plugins:         map[string]plugin.Plugin{pluginDispenseName: &directoryProviderGRPCPlugin{impl: &fakeDirectoryProvider{}}},
+		GRPCServer:      plugin.DefaultGRPCServer,
+	})
+	os.Exit(0)
+}
+
+func TestPluginClient(t *testing.T) {
+	t.Run("ReturnsGroupsWithMembersFromPlugin", func(t *testing.T) {
+
+		rpcClient, _ := plugin.TestPluginGRPCConn(t, map[string]plugin.Plugin{pluginDispenseName: &directoryProviderGRPCPlugin{impl: &fakeDirectoryProvider{}}})
+		defer rpcClient.Close()
+		dispensed, err := rpcClient.Dispense(pluginDispenseName)
+		assert.Nil(t, err)
+		client, err := newPluginClient(context.Background(), dispensed.(DirectoryProviderPlugin), nil)
+		assert.Nil(t, err)
+
+		// act
+		groupMembers, err := client.GetGroupsWithMembers(context.Background())
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hr", client.Name())
+		if assert.Equal(t, 1, len(groupMembers)) {
+			for g, members := range groupMembers {
+				assert.Equal(t, "platform", g.Name)
+				assert.Equal(t, []string{"administrator"}, g.Annotations.Roles)
+				assert.Equal(t, "john@example.com", members[0].Email)
+			}
+		}
+	})
+
+	t.Run("ServesAllCallsFromASinglePluginProcess", func(t *testing.T) {
+
+		var logs bytes.Buffer
+		ctx := contextWithLogger(context.Background(), zerolog.New(&logs))
+		client, err := NewPluginClient(ctx, os.Args[0], []string{"-test.run=^TestPluginHelperProcess$"})
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		// act
+		_, err = client.GetGroupsWithMembers(ctx)
+		assert.Nil(t, err)
+		users, err := client.GetDirectoryUsers(ctx)
+		client.Close()
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(users)) {
+			assert.Equal(t, "3", users[0].Attributes["calls"])
+			assert.Equal(t, "John", users[0].Profile.GivenName)
+		}
+		assert.Contains(t, logs.String(), `"level":"warn"`)
+		assert.Contains(t, logs.String(), "serving the fake directory")
+	})
+
+	t.Run("ReturnsErrorIfProtocolVersionDiffers", func(t *testing.T) {
+
+		os.Setenv("FAKE_PLUGIN_PROTOCOL_VERSION", "1")
+		defer os.Unsetenv("FAKE_PLUGIN_PROTOCOL_VERSION")
+
+		// act
+		_, err := NewPluginClient(context.Background(), os.Args[0], []string{"-test.run=^TestPluginHelperProcess$"})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorIfPluginDoesNotServeMethod", func(t *testing.T) {
+
+		os.Setenv("FAKE_PLUGIN_WITHOUT_USERS", "true")
+		defer os.Unsetenv("FAKE_PLUGIN_WITHOUT_USERS")
+		rpcClient, _ := plugin.TestPluginGRPCConn(t, map[string]plugin.Plugin{pluginDispenseName: &directoryProviderGRPCPlugin{impl: &fakeDirectoryProvider{}}})
+		defer rpcClient.Close()
+		dispensed, err := rpcClient.Dispense(pluginDispenseName)
+		assert.Nil(t, err)
+		client, err := newPluginClient(context.Background(), dispensed.(DirectoryProviderPlugin), nil)
+		assert.Nil(t, err)
+
+		// act
+		_, err = client.GetDirectoryUsers(context.Background())
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/rs/zerolog"
+)
+
+// pluginLogger passes the logs of go-plugin and the stderr of the plugin process on to zerolog, so they carry the run id and other fields of the syncer logs
+type pluginLogger struct {
+	logger zerolog.Logger
+	name   string
+}
+
+func newPluginLogger(logger *zerolog.Logger) hclog.Logger {
+	return &pluginLogger{logger: *logger}
+}
+
+func (l *pluginLogger) log(event *zerolog.Event, msg string, args []interface{}) {
+	if l.name != "" {
+		event = event.Str("plugin", l.name)
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		event = event.Interface(fmt.Sprint(args[i]), args[i+1])
+	}
+	event.Msg(msg)
+}
+
+func (l *pluginLogger) Trace(msg string, args ...interface{}) {
+	l.log(l.logger.Trace(), msg, args)
+}
+
+func (l *pluginLogger) Debug(msg string, args ...interface{}) {
+	l.log(l.logger.Debug(), msg, args)
+}
+
+func (l *pluginLogger) Info(msg string, args ...interface{}) {
+	l.log(l.logger.Info(), msg, args)
+}
+
+func (l *pluginLogger) Warn(msg string, args ...interface{}) {
+	l.log(l.logger.Warn(), msg, args)
+}
+
+func (l *pluginLogger) Error(msg string, args ...interface{}) {
+	l.log(l.logger.Error(), msg, args)
+}
+
+func (l *pluginLogger) IsTrace() bool { return l.enabled(zerolog.TraceLevel) }
+func (l *pluginLogger) IsDebug() bool { return l.enabled(zerolog.DebugLevel) }
+func (l *pluginLogger) IsInfo() bool  { return l.enabled(zerolog.InfoLevel) }
+func (l *pluginLogger) IsWarn() bool  { return l.enabled(zerolog.WarnLevel) }
+func (l *pluginLogger) IsError() bool { return l.enabled(zerolog.ErrorLevel) }
+
+func (l *pluginLogger) enabled(level zerolog.Level) bool {
+	return level >= l.logger.GetLevel() && level >= zerolog.GlobalLevel()
+}
+
+func (l *pluginLogger) With(args ...interface{}) hclog.Logger {
+	logContext := l.logger.With()
+	for i := 0; i+1 < len(args); i += 2 {
+		logContext = logContext.Interface(fmt.Sprint(args[i]), args[i+1])
+	}
+
+	return &pluginLogger{logger: logContext.Logger(), name: l.name}
+}
+
+// Named is called by go-plugin with the file name of the plugin executable, to log its stderr with
+func (l *pluginLogger) Named(name string) hclog.Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+
+	return &pluginLogger{logger: l.logger, name: name}
+}
+
+func (l *pluginLogger) ResetNamed(name string) hclog.Logger {
+	return &pluginLogger{logger: l.logger, name: name}
+}
+
+func (l *pluginLogger) SetLevel(level hclog.Level) {
+	switch level {
+	case hclog.Trace:
+		l.logger = l.logger.Level(zerolog.TraceLevel)
+	case hclog.Debug:
+		l.logger = l.logger.Level(zerolog.DebugLevel)
+	case hclog.Info:
+		l.logger = l.logger.Level(zerolog.InfoLevel)
+	case hclog.Warn:
+		l.logger = l.logger.Level(zerolog.WarnLevel)
+	case hclog.Error:
+		l.logger = l.logger.Level(zerolog.ErrorLevel)
+	}
+}
+
+func (l *pluginLogger) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(l.StandardWriter(opts), "", 0)
+}
+
+func (l *pluginLogger) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return &pluginLogWriter{logger: l}
+}
+
+// pluginLogWriter logs every write as a line at info level
+type pluginLogWriter struct {
+	logger *pluginLogger
+}
+
+func (w *pluginLogWriter) Write(p []byte) (int, error) {
+	w.logger.Info(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// policyEnforcementAlert logs violations of the policy and records them with the run, but applies the changes anyway
+	policyEnforcementAlert = "alert"
+	// policyEnforcementFail fails the run before applying any changes if the policy is violated
+	policyEnforcementFail = "fail"
+)
+
+// Policy is a compliance rule for the membership of directory groups, checked after fetching the directory and before applying changes
+type Policy struct {
+	Name string `yaml:"name"`
+	// Groups is a regular expression matched against the directory group name and email; if empty the policy applies to all groups
+	Groups string `yaml:"groups,omitempty"`
+	// MaxMembers is the maximum number of members a group can have
+	MaxMembers *int `yaml:"maxMembers,omitempty"`
+	// AllowedDomains are the only email domains members can have; members without email aren't checked
+	AllowedDomains []string `yaml:"allowedDomains,omitempty"`
+	// AllowExternalMembers is the required value of the group setting; groups without retrieved settings aren't checked
+	AllowExternalMembers *bool `yaml:"allowExternalMembers,omitempty"`
+	// Enforcement is either alert or fail, it defaults to alert
+	Enforcement string `yaml:"enforcement,omitempty"`
+}
+
+// PolicyViolation is a directory group violating a policy
+type PolicyViolation struct {
+	Policy           string `json:"policy"`
+	DirectoryGroupID string `json:"directoryGroupID"`
+	DirectoryGroup   string `json:"directoryGroup"`
+	Message          string `json:"message"`
+	Enforcement      string `json:"enforcement"`
+}
+
+// String returns a human-readable description of the violation
+func (v *PolicyViolation) String() string {
+	return fmt.Sprintf("group %v violates policy %v: %v", v.DirectoryGroup, v.Policy, v.Message)
+}
+
+// compiledPolicy is a validated Policy with its group pattern compiled
+type compiledPolicy struct {
+	*Policy
+	groups *regexp.Regexp
+}
+
+// compilePolicies validates the policies and compiles their group patterns
+func compilePolicies(policies []*Policy) (compiled []*compiledPolicy, err error) {
+
+	compiled = make([]*compiledPolicy, 0, len(policies))
+	names := map[string]bool{}
+
+	for i, p := range policies {
+		if p == nil || p.Name == "" {
+			return nil, fmt.Errorf("Policy %v has no name", i+1)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("Policy %v is defined more than once", p.Name)
+		}
+		names[p.Name] = true
+
+		if p.MaxMembers == nil && len(p.AllowedDomains) == 0 && p.AllowExternalMembers == nil {
+			return nil, fmt.Errorf("Policy %v has to set at least one of maxMembers, allowedDomains or allowExternalMembers", p.Name)
+		}
+		if p.MaxMembers != nil && *p.MaxMembers < 0 {
+			return nil, fmt.Errorf("Policy %v has negative maxMembers %v", p.Name, *p.MaxMembers)
+		}
+
+		switch p.Enforcement {
+		case "":
+			p.Enforcement = policyEnforcementAlert
+		case policyEnforcementAlert, policyEnforcementFail:
+		default:
+			return nil, fmt.Errorf("Policy %v has invalid enforcement %v, it has to be %v or %v", p.Name, p.Enforcement, policyEnforcementAlert, policyEnforcementFail)
+		}
+
+		groups, err := regexp.Compile(p.Groups)
+		if err != nil {
+			return nil, fmt.Errorf("Policy %v has invalid groups pattern %v: %w", p.Name, p.Groups, err)
+		}
+
+		compiled = append(compiled, &compiledPolicy{Policy: p, groups: groups})
+	}
+
+	return compiled, nil
+}
+
+// evaluatePolicies returns the violations of the policies by the directory groups, sorted by policy and group
+func evaluatePolicies(policies []*compiledPolicy, groupMembers map[*DirectoryGroup][]*DirectoryMember) (violations []*PolicyViolation) {
+
+	violations = make([]*PolicyViolation, 0)
+
+	for _, p := range policies {
+		for gg, members := range groupMembers {
+			if !p.groups.MatchString(gg.Name) && !p.groups.MatchString(gg.Email) {
+				continue
+			}
+
+			violate := func(format string, a ...interface{}) {
+				violations = append(violations, &PolicyViolation{
+					Policy:           p.Name,
+					DirectoryGroupID: gg.ID,
+					DirectoryGroup:   gg.Name,
+					Message:          fmt.Sprintf(format, a...),
+					Enforcement:      p.Enforcement,
+				})
+			}
+
+			if p.MaxMembers != nil && len(members) > *p.MaxMembers {
+				violate("has %v members, at most %v are allowed", len(members), *p.MaxMembers)
+			}
+
+			if len(p.AllowedDomains) > 0 {
+				external := make([]string, 0)
+				for _, m := range members {
+					if m.Email != "" && !domainAllowed(m.Email, p.AllowedDomains) {
+						external = append(external, m.Email)
+					}
+				}
+				if len(external) > 0 {
+					sort.Strings(external)
+					violate("has members outside of %v: %v", strings.Join(p.AllowedDomains, ", "), strings.Join(external, ", "))
+				}
+			}
+
+			if p.AllowExternalMembers != nil && gg.Settings != nil && gg.Settings.AllowExternalMembers != *p.AllowExternalMembers {
+				violate("has allowExternalMembers set to %v, it has to be %v", gg.Settings.AllowExternalMembers, *p.AllowExternalMembers)
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool {
+		if violations[i].Policy != violations[j].Policy {
+			return violations[i].Policy < violations[j].Policy
+		}
+		return violations[i].DirectoryGroup < violations[j].DirectoryGroup
+	})
+
+	return
+}
+
+// domainAllowed checks whether the domain of the email address is one of the domains, ignoring case
+func domainAllowed(email string, domains []string) bool {
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, d := range domains {
+		if strings.EqualFold(domain, d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enforcePolicies logs the violations and returns an error if any of them violates a policy enforced by failing the run
+func enforcePolicies(violations []*PolicyViolation) error {
+
+	failed := make([]string, 0)
+	for _, v := range violations {
+		if v.Enforcement == policyEnforcementFail {
+			log.Error().Msgf("Policy violation: %v", v)
+			failed = append(failed, v.String())
+		} else {
+			log.Warn().Msgf("Policy violation: %v", v)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("Failed policy checks: %v", strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCompilePolicies(t *testing.T) {
+	t.Run("DefaultsEnforcementToAlert", func(t *testing.T) {
+
+		var config Config
+		err := yaml.UnmarshalStrict([]byte(`
+policies:
+- name: small-admin-groups
+  groups: ^ci-admin
+  maxMembers: 2
+`), &config)
+		assert.Nil(t, err)
+
+		// act
+		policies, err := compilePolicies(config.Policies)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(policies))
+		assert.Equal(t, policyEnforcementAlert, policies[0].Enforcement)
+	})
+
+	t.Run("ReturnsErrorIfPolicyHasNoRules", func(t *testing.T) {
+
+		// act
+		_, err := compilePolicies([]*Policy{{Name: "empty"}})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForInvalidEnforcement", func(t *testing.T) {
+
+		maxMembers := 2
+
+		// act
+		_, err := compilePolicies([]*Policy{{Name: "small", MaxMembers: &maxMembers, Enforcement: "block"}})
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForDuplicateNames", func(t *testing.T) {
+
+		maxMembers := 2
+
+		// act
+		_, err := compilePolicies([]*Policy{{Name: "small", MaxMembers: &maxMembers}, {Name: "small", AllowedDomains: []string{"example.com"}}})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestEvaluatePolicies(t *testing.T) {
+
+	maxMembers := 1
+	allowExternalMembers := false
+	policies, err := compilePolicies([]*Policy{
+		{Name: "small-admin-groups", Groups: "^ci-admin", MaxMembers: &maxMembers, Enforcement: policyEnforcementFail},
+		{Name: "internal-members", AllowedDomains: []string{"example.com"}},
+		{Name: "no-external-members", AllowExternalMembers: &allowExternalMembers},
+	})
+	assert.Nil(t, err)
+
+	t.Run("ReturnsViolationsForMatchingGroups", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "g1", Name: "ci-admins", Settings: &DirectoryGroupSettings{AllowExternalMembers: true}}: {{ID: "m1", Email: "alice@example.com"}, {ID: "m2", Email: "bob@contractor.com"}},
+			{ID: "g2", Name: "ci-platform"}: {{ID: "m1", Email: "alice@EXAMPLE.com"}, {ID: "m3", Email: "carol@example.com"}},
+		}
+
+		// act
+		violations := evaluatePolicies(policies, groupMembers)
+
+		assert.Equal(t, []*PolicyViolation{
+			{Policy: "internal-members", DirectoryGroupID: "g1", DirectoryGroup: "ci-admins", Message: "has members outside of example.com: bob@contractor.com", Enforcement: policyEnforcementAlert},
+			{Policy: "no-external-members", DirectoryGroupID: "g1", DirectoryGroup: "ci-admins", Message: "has allowExternalMembers set to true, it has to be false", Enforcement: policyEnforcementAlert},
+			{Policy: "small-admin-groups", DirectoryGroupID: "g1", DirectoryGroup: "ci-admins", Message: "has 2 members, at most 1 are allowed", Enforcement: policyEnforcementFail},
+		}, violations)
+	})
+
+	t.Run("ReturnsNoViolationsForCompliantGroups", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "g1", Name: "ci-admins"}: {{ID: "m1", Email: "alice@example.com"}},
+		}
+
+		// act
+		violations := evaluatePolicies(policies, groupMembers)
+
+		assert.Equal(t, 0, len(violations))
+	})
+}
+
+func TestEnforcePolicies(t *testing.T) {
+	t.Run("ReturnsErrorOnlyForFailingPolicies", func(t *testing.T) {
+
+		alerts := []*PolicyViolation{{Policy: "internal-members", DirectoryGroup: "ci-admins", Message: "has members outside of example.com", Enforcement: policyEnforcementAlert}}
+		failures := append(alerts, &PolicyViolation{Policy: "small-admin-groups", DirectoryGroup: "ci-admins", Message: "has 2 members", Enforcement: policyEnforcementFail})
+
+		// act
+		alertErr := enforcePolicies(alerts)
+		failErr := enforcePolicies(failures)
+
+		assert.Nil(t, alertErr)
+		assert.NotNil(t, failErr)
+	})
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// PreflightCheck is the outcome of a single check of the configuration, credentials or reachability of the apis
+type PreflightCheck struct {
+	Name string
+	// Err is nil if the check passed
+	Err error
+	// Remediation tells how to fix a failed check
+	Remediation string
+}
+
+func (c *PreflightCheck) String() string {
+	if c.Err == nil {
+		return fmt.Sprintf("%v: ok", c.Name)
+	}
+	if c.Remediation == "" {
+		return fmt.Sprintf("%v: %v", c.Name, c.Err)
+	}
+
+	return fmt.Sprintf("%v: %v; %v", c.Name, c.Err, c.Remediation)
+}
+
+// logPreflight logs the outcome of every check
+func logPreflight(checks []*PreflightCheck) {
+	for _, c := range checks {
+		if c.Err != nil {
+			log.Error().Msgf("Preflight check %v", c)
+		} else {
+			log.Info().Msgf("Preflight check %v", c)
+		}
+	}
+}
+
+// preflightFailed returns an error listing the failed checks, or nil if all passed
+func preflightFailed(checks []*PreflightCheck) error {
+	failed := make([]string, 0)
+	for _, c := range checks {
+		if c.Err != nil {
+			failed = append(failed, c.String())
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("Failed preflight checks: %v", strings.Join(failed, "; "))
+}
+
+// runPreflight checks the estafette login and the directory credentials up front, so a misconfiguration is reported with a remediation instead of failing deep inside the first list call; full checks listing the estafette entities and directory groups as well. Checks depending on a failed one are skipped
+func runPreflight(ctx context.Context, apiClient ApiClient, full bool) (checks []*PreflightCheck) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Preflight::Run")
+	defer span.Finish()
+
+	checks = checkEstafette(ctx, apiClient, full)
+
+	if *provider == gsuiteProviderName && *gsuiteAPIEndpoint == "" {
+		gsuiteChecks := checkGsuiteCredentials(ctx, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), gsuiteAdminEmails(), gsuiteScopes(gsuiteFeaturesFromFlags()), "")
+		checks = append(checks, gsuiteChecks...)
+		if preflightFailed(gsuiteChecks) != nil {
+			return checks
+		}
+	}
+
+	if full {
+		checks = append(checks, checkDirectory(ctx))
+	}
+
+	return checks
+}
+
+// checkEstafette checks whether the client can log in and, if listEntities is set, list the entities the syncer reads
+func checkEstafette(ctx context.Context, apiClient ApiClient, listEntities bool) (checks []*PreflightCheck) {
+
+	secret, err := getClientSecret()
+	if err != nil {
+		return append(checks, &PreflightCheck{Name: "estafette client secret", Err: err, Remediation: "mount the client secret at the path in --client-secret-file"})
+	}
+	token, err := apiClient.GetToken(ctx, *clientID, secret)
+	login := &PreflightCheck{Name: "estafette login", Err: err}
+	switch {
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrForbidden):
+		login.Remediation = "the client id or secret is wrong, check --client-id and --client-secret against the client configured in estafette"
+	case errors.Is(err, ErrNotFound):
+		login.Remediation = "the login endpoint doesn't exist, check whether --api-base-url points at the estafette-ci-api rather than the web ui"
+	case err != nil:
+		login.Remediation = "the estafette-ci-api can't be reached, check --api-base-url and whether the syncer can connect to it"
+	}
+	checks = append(checks, login)
+	if err != nil {
+		return checks
+	}
+
+	if !listEntities {
+		return checks
+	}
+
+	for _, name := range []string{"organizations", "groups", "users"} {
+		switch name {
+		case "organizations":
+			_, err = apiClient.GetOrganizations(ctx, token)
+		case "groups":
+			_, err = apiClient.GetGroups(ctx, token)
+		case "users":
+			_, err = apiClient.GetUsers(ctx, token)
+		}
+
+		check := &PreflightCheck{Name: "estafette " + name + " endpoint", Err: err}
+		switch {
+		case errors.Is(err, ErrForbidden), errors.Is(err, ErrUnauthorized):
+			check.Remediation = fmt.Sprintf("the client isn't allowed to list %v, give it a role like administrator in estafette", name)
+		case errors.Is(err, ErrNotFound):
+			check.Remediation = fmt.Sprintf("the estafette-ci-api doesn't serve /api/%v, upgrade it", name)
+		case err != nil:
+			check.Remediation = fmt.Sprintf("listing %v fails, check the estafette-ci-api logs", name)
+		}
+		checks = append(checks, check)
+	}
+
+	return checks
+}
+
+// serviceAccountKey holds the fields of a service account key file the checks report on
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	ClientID    string `json:"client_id"`
+}
+
+// checkGsuiteCredentials checks whether the service account key is valid, domain-wide delegation is granted for each scope and one of the admin emails can be impersonated and read groups, trying them in order like the sync does; tokenURL overrides the google token endpoint, for testing
+func checkGsuiteCredentials(ctx context.Context, keyFile string, adminEmails []string, scopes []string, tokenURL string) (checks []*PreflightCheck) {
+
+	keyCheck := &PreflightCheck{Name: "service account key"}
+	checks = append(checks, keyCheck)
+
+	if keyFile == "" {
+		keyCheck.Err = fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS is not set")
+		keyCheck.Remediation = "set it to the path of the json key of the service account with domain-wide delegation"
+		return
+	}
+	keyJSON, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		keyCheck.Err = err
+		keyCheck.Remediation = "mount the json key of the service account at the path in GOOGLE_APPLICATION_CREDENTIALS"
+		return
+	}
+	var key serviceAccountKey
+	if err = json.Unmarshal(keyJSON, &key); err != nil || key.Type != "service_account" {
+		keyCheck.Err = fmt.Errorf("%v is not a service account key", keyFile)
+		keyCheck.Remediation = "create a json key for the service account in the gcp console, not an oauth client or user credential"
+		return
+	}
+	if _, err = google.JWTConfigFromJSON(keyJSON); err != nil {
+		keyCheck.Err = err
+		keyCheck.Remediation = "the key is damaged, create a new json key for the service account"
+		return
+	}
+
+	// request a token for every scope on its own, to tell exactly which scope lacks delegation
+	delegated := true
+	for _, scope := range scopes {
+		check := &PreflightCheck{Name: "domain-wide delegation for " + scope}
+		for i, adminEmail := range adminEmails {
+			jwtConfig, _ := google.JWTConfigFromJSON(keyJSON, scope)
+			jwtConfig.Subject = adminEmail
+			if tokenURL != "" {
+				jwtConfig.TokenURL = tokenURL
+			}
+
+			_, err := jwtConfig.TokenSource(ctx).Token()
+			if err == nil {
+				check.Err, check.Remediation = nil, ""
+				break
+			}
+			// the primary admin's failure is reported, it's the one that's expected to work
+			if i == 0 {
+				check.Err = err
+				check.Remediation = delegationRemediation(err, key, adminEmail, scope)
+			}
+		}
+		if check.Err != nil {
+			delegated = false
+		}
+		checks = append(checks, check)
+	}
+	if !delegated {
+		return
+	}
+
+	// a token for the admin email is no proof it may read groups, that depends on its admin roles
+	failedImpersonations := make([]*PreflightCheck, 0)
+	for _, adminEmail := range adminEmails {
+		jwtConfig, _ := google.JWTConfigFromJSON(keyJSON, scopes...)
+		jwtConfig.Subject = adminEmail
+		if tokenURL != "" {
+			jwtConfig.TokenURL = tokenURL
+		}
+
+		check := checkImpersonation(ctx, jwtConfig.TokenSource(ctx), adminEmail)
+		if check.Err == nil {
+			for _, failed := range failedImpersonations {
+				logFromContext(ctx).Warn().Msgf("Preflight check %v, falling back to %v", failed, adminEmail)
+			}
+			return append(checks, check)
+		}
+		failedImpersonations = append(failedImpersonations, check)
+	}
+
+	return append(checks, failedImpersonations...)
+}
+
+// checkImpersonation checks whether the impersonated admin can list the groups of the domain
+func checkImpersonation(ctx context.Context, tokenSource oauth2.TokenSource, adminEmail string) *PreflightCheck {
+	check := &PreflightCheck{Name: "impersonation of " + adminEmail}
+
+	adminService, err := admin.NewService(ctx, option.WithTokenSource(tokenSource))
+	if err != nil {
+		check.Err = err
+		return check
+	}
+
+	customerID := firstCustomerID(*gsuiteCustomerIDs)
+	_, err = listGroupsIn(adminService.Groups.List(), *gsuiteDomain, customerID).MaxResults(1).Context(ctx).Do()
+	check.Err = err
+
+	var apiErr *googleapi.Error
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == 403:
+		check.Remediation = fmt.Sprintf("%v isn't allowed to read groups, set --gsuite-admin-email to a gsuite admin with at least the groups reader role", adminEmail)
+	case errors.As(err, &apiErr) && (apiErr.Code == 400 || apiErr.Code == 404):
+		check.Remediation = fmt.Sprintf("domain %v isn't known, check --gsuite-domain", *gsuiteDomain)
+		if customerID != "" {
+			check.Remediation = fmt.Sprintf("customer %v isn't known or not managed by %v, check --gsuite-customer-id", customerID, adminEmail)
+		}
+	case err != nil:
+		check.Remediation = "the directory api can't be reached, check whether the syncer can connect to googleapis.com"
+	}
+
+	return check
+}
+
+// delegationRemediation explains a failure to get a token for the admin email and scope, from the error code of the token endpoint
+func delegationRemediation(err error, key serviceAccountKey, adminEmail, scope string) string {
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return "the google token endpoint can't be reached, check whether the syncer can connect to oauth2.googleapis.com"
+	}
+
+	var tokenErr struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	json.Unmarshal(retrieveErr.Body, &tokenErr)
+
+	switch {
+	case tokenErr.Error == "unauthorized_client":
+		return fmt.Sprintf("grant client id %v of %v the scope %v under domain-wide delegation in the admin console, at Security > API controls", key.ClientID, key.ClientEmail, scope)
+	case tokenErr.Error == "invalid_grant" && strings.Contains(tokenErr.Description, "email"):
+		return fmt.Sprintf("%v can't be impersonated, set --gsuite-admin-email to an existing, active user of the domain", adminEmail)
+	case tokenErr.Error == "invalid_grant":
+		return "the key is rejected, check whether it was deleted or disabled and whether the clock of the syncer is correct"
+	}
+
+	return "check the service account and its domain-wide delegation in the admin console"
+}
+
+// checkDirectory checks whether the groups and members can be retrieved from the directory provider
+func checkDirectory(ctx context.Context) *PreflightCheck {
+	check := &PreflightCheck{Name: *provider + " groups and members"}
+
+	directoryProvider, err := createProvider(ctx)
+	if err == nil {
+		_, err = directoryProvider.GetGroupsWithMembers(ctx)
+		closeProvider(ctx, directoryProvider)
+	}
+	if err != nil {
+		check.Err = err
+		check.Remediation = fmt.Sprintf("check the %v flags and credentials", *provider)
+	}
+
+	return check
+}
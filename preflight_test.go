@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestCheckEstafette(t *testing.T) {
+	t.Run("ReportsRejectedCredentialsWithRemediation", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer server.Close()
+
+		client := NewApiClient(server.URL, nil, ApiClientOptions{MaxRetries: 1})
+
+		// act
+		checks := checkEstafette(context.Background(), client, true)
+
+		if assert.Equal(t, 1, len(checks)) {
+			assert.NotNil(t, checks[0].Err)
+			assert.Contains(t, checks[0].Remediation, "--client-secret")
+		}
+	})
+}
+
+func TestCheckGsuiteCredentials(t *testing.T) {
+	t.Run("ReportsMissingKeyFile", func(t *testing.T) {
+
+		// act
+		checks := checkGsuiteCredentials(context.Background(), "", []string{"admin@example.com"}, gsuiteScopes(gsuiteFeatures{}), "")
+
+		if assert.Equal(t, 1, len(checks)) {
+			assert.NotNil(t, checks[0].Err)
+			assert.Contains(t, checks[0].Remediation, "json key")
+		}
+	})
+
+	t.Run("ReportsEveryScopeWithoutDomainWideDelegation", func(t *testing.T) {
+
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err)
+		keyJSON, _ := json.Marshal(map[string]string{
+			"type":         "service_account",
+			"client_email": "syncer@project.iam.gserviceaccount.com",
+			"client_id":    "1234567890",
+			"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})),
+		})
+		dir, err := ioutil.TempDir("", "preflight")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "key.json")
+		assert.Nil(t, ioutil.WriteFile(keyFile, keyJSON, 0600))
+
+		// the token endpoint only grants the groups scope
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.ParseForm()
+			w.Header().Set("Content-Type", "application/json")
+			assertion := r.Form.Get("assertion")
+			if strings.Contains(decodeJWTClaims(assertion), admin.AdminDirectoryUserReadonlyScope) {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error":"unauthorized_client","error_description":"Client is unauthorized to retrieve access tokens using this method"}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"fake-token","token_type":"Bearer","expires_in":3600}`)
+		}))
+		defer tokenServer.Close()
+
+		// act
+		checks := checkGsuiteCredentials(context.Background(), keyFile, []string{"admin@example.com"}, gsuiteScopes(gsuiteFeatures{syncUsers: true}), tokenServer.URL)
+
+		if assert.Equal(t, 3, len(checks)) {
+			assert.Nil(t, checks[0].Err)
+			assert.Nil(t, checks[1].Err)
+			assert.NotNil(t, checks[2].Err)
+			assert.Equal(t, "grant client id 1234567890 of syncer@project.iam.gserviceaccount.com the scope "+admin.AdminDirectoryUserReadonlyScope+" under domain-wide delegation in the admin console, at Security > API controls", checks[2].Remediation)
+		}
+	})
+}
+
+// decodeJWTClaims returns the claims of the jwt as json string, without verifying it
+func decodeJWTClaims(jwt string) string {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	return string(claims)
+}
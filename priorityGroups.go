@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// groupPriority marks the security-sensitive groups, like the admins and release managers, that get fetched and reconciled before all other groups in every run; like the entitySampling it's set up once in main, and it's nil unless --priority-groups is set, in which case no group goes first
+var groupPriority *groupPrioritizer
+
+// groupPrioritizer matches directory and estafette groups against the --priority-groups patterns
+type groupPrioritizer struct {
+	// patterns are lowercased glob patterns for the emails or names of directory groups, or the names of estafette groups
+	patterns []string
+}
+
+// newGroupPrioritizer validates the patterns and returns a groupPrioritizer, or nil if there are none
+func newGroupPrioritizer(patterns []string) (*groupPrioritizer, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	p := &groupPrioritizer{}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid priority group pattern %v: %w", pattern, err)
+		}
+		p.patterns = append(p.patterns, strings.ToLower(pattern))
+	}
+
+	return p, nil
+}
+
+// matches checks whether any of the emails or names matches a pattern; a nil groupPrioritizer matches nothing
+func (p *groupPrioritizer) matches(values ...string) bool {
+	if p == nil {
+		return false
+	}
+
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		for _, pattern := range p.patterns {
+			if matched, _ := path.Match(pattern, strings.ToLower(v)); matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// splitGsuiteGroups returns the priority gsuite groups and the others, keeping their order
+func (p *groupPrioritizer) splitGsuiteGroups(groups []*admin.Group) (priority, others []*admin.Group) {
+	for _, g := range groups {
+		if p.matches(g.Email, g.Name) {
+			priority = append(priority, g)
+		} else {
+			others = append(others, g)
+		}
+	}
+
+	return
+}
+
+// prioritizeGsuiteGroups returns the gsuite groups with the priority ones first, so their members get fetched before the others
+func (p *groupPrioritizer) prioritizeGsuiteGroups(groups []*admin.Group) []*admin.Group {
+	if p == nil {
+		return groups
+	}
+	priority, others := p.splitGsuiteGroups(groups)
+
+	return append(priority, others...)
+}
+
+// priorityDirectoryGroupIDs returns the ids of the directory groups matching a pattern by email or name
+func (p *groupPrioritizer) priorityDirectoryGroupIDs(groupMembers map[*DirectoryGroup][]*DirectoryMember) map[string]bool {
+	ids := map[string]bool{}
+	for gg := range groupMembers {
+		if p.matches(gg.Email, gg.Name) {
+			ids[gg.ID] = true
+		}
+	}
+
+	return ids
+}
+
+// isPriorityGroup checks whether the estafette group matches a pattern by name, or has an identity of the provider for one of the priority directory groups
+func (p *groupPrioritizer) isPriorityGroup(group *contracts.Group, provider string, directoryGroupIDs map[string]bool) bool {
+	if group == nil || p == nil {
+		return false
+	}
+	if p.matches(group.Name) {
+		return true
+	}
+	for _, i := range group.Identities {
+		if i.Provider == provider && (directoryGroupIDs[i.ID] || p.matches(i.Name)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitPriorityActions returns the actions reconciling the priority groups and the others, keeping their order; the priority actions are the creates and updates of priority groups, the user updates changing a membership of one, and the organization changes the created groups may be attached to. User updates that also add a membership of a group created with the other actions wait for it, and deleting groups always waits for the other actions, so no priority action references a group that doesn't exist yet or doesn't exist anymore
+func (p *groupPrioritizer) splitPriorityActions(actions []*Action, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) (priority, others []*Action) {
+	if p == nil || provider == nil {
+		return nil, actions
+	}
+
+	directoryGroupIDs := p.priorityDirectoryGroupIDs(groupMembers)
+	isPriority := func(g *contracts.Group) bool {
+		return p.isPriorityGroup(g, provider.Name(), directoryGroupIDs)
+	}
+
+	createdLater := map[string]bool{}
+	for _, a := range actions {
+		if a.Type == ActionCreateGroup && !isPriority(a.Group) {
+			createdLater[a.Group.Name] = true
+		}
+	}
+
+	for _, a := range actions {
+		switch a.Type {
+		case ActionCreateOrganization, ActionUpdateOrganization:
+			priority = append(priority, a)
+			continue
+
+		case ActionCreateGroup, ActionUpdateGroup:
+			if isPriority(a.Group) {
+				priority = append(priority, a)
+				continue
+			}
+
+		case ActionUpdateUser:
+			if changesPriorityMembership(a, isPriority) && !addsGroupCreatedLater(a, createdLater) {
+				priority = append(priority, a)
+				continue
+			}
+		}
+		others = append(others, a)
+	}
+
+	return
+}
+
+// changesPriorityMembership checks whether the user update adds or removes a membership of a priority group
+func changesPriorityMembership(a *Action, isPriority func(g *contracts.Group) bool) bool {
+	before, after := []*contracts.Group(nil), []*contracts.Group(nil)
+	if a.UserBefore != nil {
+		before = a.UserBefore.Groups
+	}
+	if a.User != nil {
+		after = a.User.Groups
+	}
+
+	for _, g := range before {
+		if isPriority(g) && !containsGroup(after, g) {
+			return true
+		}
+	}
+	for _, g := range after {
+		if isPriority(g) && !containsGroup(before, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addsGroupCreatedLater checks whether the user update adds a membership of a group created with the other actions
+func addsGroupCreatedLater(a *Action, createdLater map[string]bool) bool {
+	if a.User == nil {
+		return false
+	}
+	for _, g := range a.User.Groups {
+		if g.ID == "" && createdLater[g.Name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsGroup checks whether the groups hold the group, by id or else by name for groups that aren't created yet
+func containsGroup(groups []*contracts.Group, group *contracts.Group) bool {
+	for _, g := range groups {
+		if (group.ID != "" && g.ID == group.ID) || (group.ID == "" && g.ID == "" && g.Name == group.Name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyPriorityActionsFirst applies the actions of the priority groups before all others, so the most security-sensitive memberships are correct even if applying the others fails or gets interrupted; a failing priority action doesn't stop the others, like a failing tier doesn't stop the next ones
+func applyPriorityActionsFirst(ctx context.Context, apiClient ApiClient, token string, actions []*Action, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) error {
+	priority, others := groupPriority.splitPriorityActions(actions, provider, groupMembers)
+	if len(priority) == 0 {
+		return apiClient.ApplyActions(ctx, token, others)
+	}
+
+	logFromContext(ctx).Info().Msgf("Applying %v actions of priority groups before the other %v actions", len(priority), len(others))
+	err := apiClient.ApplyActions(ctx, token, priority)
+	othersErr := apiClient.ApplyActions(ctx, token, others)
+	if err == nil {
+		err = othersErr
+	}
+
+	return err
+}
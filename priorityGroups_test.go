@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestSplitPriorityActions(t *testing.T) {
+	t.Run("ReturnsActionsOfPriorityGroupsByDirectoryEmailOrGroupName", func(t *testing.T) {
+
+		prioritizer, err := newGroupPrioritizer([]string{"ci-admins@*", "release-managers"})
+		assert.Nil(t, err)
+		provider := &gsuiteClient{}
+		admins := &contracts.Group{ID: "g1", Name: "admins", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-admins"}}}
+		release := &contracts.Group{ID: "g2", Name: "release-managers"}
+		platform := &contracts.Group{ID: "g3", Name: "platform"}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-admins", Email: "ci-admins@example.com"}:     {},
+			{ID: "0def", Name: "ci-platform", Email: "ci-platform@example.com"}: {},
+		}
+		updateAdmins := &Action{Type: ActionUpdateGroup, GroupBefore: admins, Group: admins}
+		updatePlatform := &Action{Type: ActionUpdateGroup, GroupBefore: platform, Group: platform}
+		deleteRelease := &Action{Type: ActionDeleteGroup, GroupBefore: release, Group: release}
+		joinAdmins := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1", Groups: []*contracts.Group{admins}}}
+		leaveRelease := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u2", Groups: []*contracts.Group{release, platform}}, User: &contracts.User{ID: "u2", Groups: []*contracts.Group{platform}}}
+		joinPlatform := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u3"}, User: &contracts.User{ID: "u3", Groups: []*contracts.Group{platform}}}
+		createOrganization := &Action{Type: ActionCreateOrganization, Organization: &contracts.Organization{Name: "retail"}}
+
+		// act
+		priority, others := prioritizer.splitPriorityActions([]*Action{updatePlatform, updateAdmins, deleteRelease, joinPlatform, joinAdmins, leaveRelease, createOrganization}, provider, groupMembers)
+
+		assert.Equal(t, []*Action{updateAdmins, joinAdmins, leaveRelease, createOrganization}, priority)
+		assert.Equal(t, []*Action{updatePlatform, deleteRelease, joinPlatform}, others)
+	})
+
+	t.Run("LeavesUserUpdatesAddingGroupsCreatedLaterWithTheOthers", func(t *testing.T) {
+
+		prioritizer, err := newGroupPrioritizer([]string{"admins"})
+		assert.Nil(t, err)
+		provider := &gsuiteClient{}
+		admins := &contracts.Group{Name: "admins"}
+		platform := &contracts.Group{Name: "platform"}
+		createAdmins := &Action{Type: ActionCreateGroup, Group: admins}
+		createPlatform := &Action{Type: ActionCreateGroup, Group: platform}
+		joinAdmins := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1", Groups: []*contracts.Group{admins}}}
+		joinBoth := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u2"}, User: &contracts.User{ID: "u2", Groups: []*contracts.Group{admins, platform}}}
+
+		// act
+		priority, others := prioritizer.splitPriorityActions([]*Action{createAdmins, createPlatform, joinAdmins, joinBoth}, provider, nil)
+
+		assert.Equal(t, []*Action{createAdmins, joinAdmins}, priority)
+		assert.Equal(t, []*Action{createPlatform, joinBoth}, others)
+	})
+
+	t.Run("ReturnsAllActionsAsOthersWithoutPriorityGroups", func(t *testing.T) {
+
+		actions := []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "admins"}}}
+
+		// act
+		priority, others := groupPriority.splitPriorityActions(actions, &gsuiteClient{}, nil)
+
+		assert.Nil(t, priority)
+		assert.Equal(t, actions, others)
+	})
+}
+
+func TestApplyPriorityActionsFirst(t *testing.T) {
+	t.Run("AppliesPriorityActionsBeforeTheOthers", func(t *testing.T) {
+
+		defer func(p *groupPrioritizer) { groupPriority = p }(groupPriority)
+		groupPriority, _ = newGroupPrioritizer([]string{"admins"})
+		createPlatform := &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}}
+		createAdmins := &Action{Type: ActionCreateGroup, Group: &contracts.Group{Name: "admins"}}
+		apiClient := &recordingApiClient{}
+
+		// act
+		err := applyPriorityActionsFirst(context.Background(), apiClient, "token", []*Action{createPlatform, createAdmins}, &gsuiteClient{}, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, [][]*Action{{createAdmins}, {createPlatform}}, apiClient.batches)
+	})
+}
+
+func TestPrioritizeGsuiteGroups(t *testing.T) {
+	t.Run("MovesPriorityGroupsFirstKeepingTheOrder", func(t *testing.T) {
+
+		prioritizer, err := newGroupPrioritizer([]string{"*-admins@example.com", "Release Managers"})
+		assert.Nil(t, err)
+		platform := &admin.Group{Email: "ci-platform@example.com", Name: "Platform"}
+		admins := &admin.Group{Email: "ci-admins@example.com", Name: "Admins"}
+		release := &admin.Group{Email: "ci-release@example.com", Name: "Release Managers"}
+
+		// act
+		groups := prioritizer.prioritizeGsuiteGroups([]*admin.Group{platform, admins, release})
+
+		assert.Equal(t, []*admin.Group{admins, release, platform}, groups)
+	})
+
+	t.Run("ReturnsErrorForInvalidPattern", func(t *testing.T) {
+
+		// act
+		_, err := newGroupPrioritizer([]string{"ci-[admins"})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestStreamGroupsWithMembersPriorityGroups(t *testing.T) {
+	t.Run("StreamsPriorityGroupsFirst", func(t *testing.T) {
+
+		defer func(p *groupPrioritizer) { groupPriority = p }(groupPriority)
+		groupPriority, _ = newGroupPrioritizer([]string{"ci-release@example.com"})
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("ci-platform@example.com", "ci-platform", "", &admin.Member{Email: "john@example.com", Type: "USER", Status: "ACTIVE"})
+		directoryAPI.seedGroup("ci-release@example.com", "ci-release", "", &admin.Member{Email: "jane@example.com", Type: "USER", Status: "ACTIVE"})
+		ctx := context.Background()
+		client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+		groupsWithMembers := make(chan *DirectoryGroupWithMembers, 2)
+
+		// act
+		err = client.(StreamingProvider).StreamGroupsWithMembers(ctx, groupsWithMembers)
+
+		assert.Nil(t, err)
+		emails := make([]string, 0)
+		for gm := range groupsWithMembers {
+			emails = append(emails, gm.Group.Email)
+		}
+		assert.Equal(t, []string{"ci-release@example.com", "ci-platform@example.com"}, emails)
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Provider is a directory that serves as the source of truth for groups and their members
+type Provider interface {
+	// Name returns the provider name stored in the identities of groups created from this provider
+	Name() string
+	// UserIdentityProvider returns the estafette user identity provider member ids can be matched against; if empty members are matched by email address
+	UserIdentityProvider() string
+	GetGroupsWithMembers(ctx context.Context) (groupMembers map[*DirectoryGroup][]*DirectoryMember, err error)
+}
+
+// StreamingProvider is a Provider that can pass on groups with their members one by one, so large directories don't have to be held in memory at once
+type StreamingProvider interface {
+	Provider
+	// StreamGroupsWithMembers sends every group with its members to the channel and closes it when done or failed
+	StreamGroupsWithMembers(ctx context.Context, groupsWithMembers chan<- *DirectoryGroupWithMembers) (err error)
+}
+
+// UserProvider is a Provider that can retrieve the users in the directory, to enrich the matching estafette users with
+type UserProvider interface {
+	Provider
+	GetDirectoryUsers(ctx context.Context) (users []*DirectoryUser, err error)
+}
+
+// IdentityLookupProvider is a Provider that can look up single groups and users by the id stored in estafette identities, including the ones outside the synced groups, to find identities referencing groups and users deleted from the directory
+type IdentityLookupProvider interface {
+	Provider
+	// DirectoryGroupExists and DirectoryUserExists only return false if the directory confirms the group or user doesn't exist anymore
+	DirectoryGroupExists(ctx context.Context, id string) (exists bool, err error)
+	DirectoryUserExists(ctx context.Context, id string) (exists bool, err error)
+}
+
+// DirectoryGroup is a group as retrieved from a Provider
+type DirectoryGroup struct {
+	// ID is stored as the id of the estafette group identity and has to be stable across runs
+	ID    string
+	Name  string
+	Email string
+	// Description is the description admins wrote for the group in the directory, without its annotations
+	Description string
+	// Annotations hold the sync settings for the group set in the directory, if any
+	Annotations *GroupAnnotations
+	// Settings hold the access settings of the group; nil if the provider doesn't retrieve them
+	Settings *DirectoryGroupSettings
+	// Dynamic is set for groups whose members the directory resolves from a query instead of them being added by hand
+	Dynamic *DirectoryGroupDynamic
+	// Domain is the domain the group is homed in, for directories listing groups of several domains; empty if all groups come from the same domain
+	Domain string
+	// Aggregate is set for groups generated by the syncer rather than retrieved from the directory, whose name is used as is
+	Aggregate bool
+}
+
+// DirectoryGroupDynamic holds the membership query of a dynamic DirectoryGroup
+type DirectoryGroupDynamic struct {
+	Query string
+}
+
+// DirectoryGroupSettings are the access settings of a DirectoryGroup, for policy checks on the estafette group
+type DirectoryGroupSettings struct {
+	AllowExternalMembers bool
+	// WhoCanJoin is one of ANYONE_CAN_JOIN, ALL_IN_DOMAIN_CAN_JOIN, INVITED_CAN_JOIN and CAN_REQUEST_TO_JOIN
+	WhoCanJoin string
+}
+
+// DirectoryMember is a member of a DirectoryGroup
+type DirectoryMember struct {
+	ID    string
+	Email string
+	// ExpireTime is set for time-bound memberships, the member is removed from the estafette group once it passed
+	ExpireTime *time.Time
+}
+
+// DirectoryGroupWithMembers is a DirectoryGroup with its members, as passed on by a StreamingProvider
+type DirectoryGroupWithMembers struct {
+	Group   *DirectoryGroup
+	Members []*DirectoryMember
+}
+
+// DirectoryUser is a user as retrieved from a UserProvider; it's matched to estafette users like a DirectoryMember
+type DirectoryUser struct {
+	ID    string
+	Email string
+	// IsAdmin is set for directory administrators, the members of the admins aggregate group
+	IsAdmin bool
+	// Suspended is set for users that can't log in, who aren't reported as unprovisioned
+	Suspended bool
+	// AdminRoles are the names of the directory admin roles assigned to the user; only retrieved if admin roles are synchronized
+	AdminRoles []string
+	// Profile holds the names and avatar to keep the estafette user up to date with; if nil they're left alone
+	Profile *DirectoryUserProfile
+	// Attributes are the directory user fields mapped to estafette user properties; an empty value removes the property
+	Attributes map[string]string
+}
+
+// DirectoryUserProfile holds the personal details of a DirectoryUser
+type DirectoryUserProfile struct {
+	Name       string
+	GivenName  string
+	FamilyName string
+	AvatarURL  string
+}
+
+// closeProvider stops what the provider holds on to, like the process of a plugin, once the directory is fetched; providers without anything to stop are left alone
+func closeProvider(ctx context.Context, directoryProvider Provider) {
+	closer, ok := directoryProvider.(io.Closer)
+	if !ok {
+		return
+	}
+
+	err := closer.Close()
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msgf("Failed closing provider %v", directoryProvider.Name())
+	}
+}
@@ -0,0 +1,129 @@
+// Package reconcile computes the changes to bring estafette entities in line with the directory, without any api interaction.
+//
+// It uses a three-way merge between the desired state from the directory, the actual state in estafette and the state the
+// syncer applied last time, so values added in estafette by hand are kept unless the directory takes ownership of them.
+// Without a last-applied state the merge falls back to making the owned fields equal to the desired state.
+package reconcile
+
+// GroupFields are the fields of an estafette group that are owned by the syncer
+type GroupFields struct {
+	Name string `json:"name"`
+	// Roles and Organizations are nil if the directory doesn't set them, in which case they're left alone
+	Roles         []string `json:"roles,omitempty"`
+	Organizations []string `json:"organizations,omitempty"`
+}
+
+// Snapshot holds the group fields as last applied by the syncer, by the key returned from GroupKey
+type Snapshot struct {
+	Groups map[string]*GroupFields `json:"groups"`
+}
+
+// NewSnapshot returns an empty Snapshot
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		Groups: map[string]*GroupFields{},
+	}
+}
+
+// GroupKey returns the key of a group in the Snapshot, made up of the provider and id of its directory identity
+func GroupKey(provider, id string) string {
+	return provider + "/" + id
+}
+
+// LastAppliedGroup returns the fields last applied to the group with key, or nil if the snapshot is nil or doesn't hold the group
+func (s *Snapshot) LastAppliedGroup(key string) *GroupFields {
+	if s == nil {
+		return nil
+	}
+
+	return s.Groups[key]
+}
+
+// MergeGroup returns the actual fields with the changes between the last applied and the desired fields applied, and whether they differ from the actual fields
+func MergeGroup(desired, actual GroupFields, lastApplied *GroupFields) (merged GroupFields, changed bool) {
+
+	if lastApplied == nil {
+		merged = GroupFields{
+			Name:          desired.Name,
+			Roles:         MergeStrings(desired.Roles, actual.Roles, nil),
+			Organizations: MergeStrings(desired.Organizations, actual.Organizations, nil),
+		}
+	} else {
+		merged = GroupFields{
+			Name:          MergeString(desired.Name, actual.Name, &lastApplied.Name),
+			Roles:         MergeStrings(desired.Roles, actual.Roles, lastApplied.Roles),
+			Organizations: MergeStrings(desired.Organizations, actual.Organizations, lastApplied.Organizations),
+		}
+	}
+
+	changed = merged.Name != actual.Name || !SameStrings(merged.Roles, actual.Roles) || !SameStrings(merged.Organizations, actual.Organizations)
+
+	return
+}
+
+// MergeString returns the desired value if it changed since it was last applied, or the actual value if it didn't, so edits made in estafette are kept
+func MergeString(desired, actual string, lastApplied *string) string {
+	if lastApplied == nil || desired != *lastApplied {
+		return desired
+	}
+
+	return actual
+}
+
+// MergeStrings returns the actual values without the ones removed from the desired values since they were last applied, and with all desired values added.
+//
+// If lastApplied is nil, all actual values missing from the desired values are removed as well; if both desired and lastApplied are nil the actual values are returned as is.
+func MergeStrings(desired, actual, lastApplied []string) (merged []string) {
+	if desired == nil && lastApplied == nil {
+		return actual
+	}
+
+	// without a last-applied state the syncer owns all values
+	if lastApplied == nil {
+		lastApplied = actual
+	}
+
+	merged = make([]string, 0, len(actual)+len(desired))
+	for _, v := range actual {
+		if contains(lastApplied, v) && !contains(desired, v) {
+			continue
+		}
+		merged = append(merged, v)
+	}
+	for _, v := range desired {
+		if !contains(merged, v) {
+			merged = append(merged, v)
+		}
+	}
+
+	return merged
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SameStrings checks whether a and b hold the same values, regardless of order
+func SameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := map[string]int{}
+	for _, v := range a {
+		counts[v]++
+	}
+	for _, v := range b {
+		counts[v]--
+		if counts[v] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
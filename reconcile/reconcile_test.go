@@ -0,0 +1,75 @@
+package reconcile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeGroup(t *testing.T) {
+	t.Run("KeepsRolesAddedInEstafette", func(t *testing.T) {
+
+		desired := GroupFields{Name: "platform", Roles: []string{"operator"}}
+		actual := GroupFields{Name: "platform", Roles: []string{"operator", "administrator"}}
+		lastApplied := &GroupFields{Name: "platform", Roles: []string{"operator"}}
+
+		// act
+		merged, changed := MergeGroup(desired, actual, lastApplied)
+
+		assert.False(t, changed)
+		assert.Equal(t, []string{"operator", "administrator"}, merged.Roles)
+	})
+
+	t.Run("RemovesRolesRemovedFromDirectory", func(t *testing.T) {
+
+		desired := GroupFields{Name: "platform", Roles: []string{"viewer"}}
+		actual := GroupFields{Name: "platform", Roles: []string{"operator", "administrator"}}
+		lastApplied := &GroupFields{Name: "platform", Roles: []string{"operator"}}
+
+		// act
+		merged, changed := MergeGroup(desired, actual, lastApplied)
+
+		assert.True(t, changed)
+		assert.Equal(t, []string{"administrator", "viewer"}, merged.Roles)
+	})
+
+	t.Run("KeepsNameEditedInEstafetteIfUnchangedInDirectory", func(t *testing.T) {
+
+		desired := GroupFields{Name: "platform"}
+		actual := GroupFields{Name: "platform-engineering"}
+		lastApplied := &GroupFields{Name: "platform"}
+
+		// act
+		merged, changed := MergeGroup(desired, actual, lastApplied)
+
+		assert.False(t, changed)
+		assert.Equal(t, "platform-engineering", merged.Name)
+	})
+
+	t.Run("RenamesIfNameChangedInDirectory", func(t *testing.T) {
+
+		desired := GroupFields{Name: "platform-team"}
+		actual := GroupFields{Name: "platform-engineering"}
+		lastApplied := &GroupFields{Name: "platform"}
+
+		// act
+		merged, changed := MergeGroup(desired, actual, lastApplied)
+
+		assert.True(t, changed)
+		assert.Equal(t, "platform-team", merged.Name)
+	})
+
+	t.Run("OverwritesOwnedFieldsWithoutLastApplied", func(t *testing.T) {
+
+		desired := GroupFields{Name: "platform", Roles: []string{"operator"}}
+		actual := GroupFields{Name: "platform-engineering", Roles: []string{"operator", "administrator"}, Organizations: []string{"retail"}}
+
+		// act
+		merged, changed := MergeGroup(desired, actual, nil)
+
+		assert.True(t, changed)
+		assert.Equal(t, "platform", merged.Name)
+		assert.Equal(t, []string{"operator"}, merged.Roles)
+		assert.Equal(t, []string{"retail"}, merged.Organizations)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/opentracing/opentracing-go"
+)
+
+// redisDialTimeout is the timeout for connecting to the redis server
+const redisDialTimeout = 10 * time.Second
+
+// NewRedisStateStore returns a StateStore keeping every key as redis string under the key prefix; redis sets strings atomically, so an interrupted write keeps the previous value
+func NewRedisStateStore(client *redis.Client, keyPrefix string) StateStore {
+	return &redisStateStore{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+type redisStateStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+// redisOptions returns the options to connect to the redis server at the address, which is either a host:port or a redis:// or rediss:// url, the latter connecting over tls; the password overrides the one in the url unless it's empty
+func redisOptions(address, password string) (*redis.Options, error) {
+	options := &redis.Options{Addr: address}
+	if strings.HasPrefix(address, "redis://") || strings.HasPrefix(address, "rediss://") {
+		var err error
+		options, err = redis.ParseURL(address)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid redis url %v: %w", address, err)
+		}
+	}
+	if password != "" {
+		options.Password = password
+	}
+	options.DialTimeout = redisDialTimeout
+
+	return options, nil
+}
+
+func (s *redisStateStore) Read(ctx context.Context, key string) (data []byte, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "RedisStateStore::Read")
+	defer span.Finish()
+
+	redisKey := s.keyPrefix + stateKey(key)
+	data, err = s.client.WithContext(ctx).Get(redisKey).Bytes()
+	if err == redis.Nil {
+		return nil, fmt.Errorf("%w: redis key %v doesn't exist", ErrStateNotFound, redisKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed getting redis key %v: %w", redisKey, err)
+	}
+
+	return data, nil
+}
+
+func (s *redisStateStore) Write(ctx context.Context, key string, data []byte) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "RedisStateStore::Write")
+	defer span.Finish()
+
+	redisKey := s.keyPrefix + stateKey(key)
+	err := s.client.WithContext(ctx).Set(redisKey, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("Failed setting redis key %v: %w", redisKey, err)
+	}
+
+	return nil
+}
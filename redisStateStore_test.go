@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRedisTestStore returns a state store connected to the redis server at the address with the password
+func newRedisTestStore(t *testing.T, address, password string) StateStore {
+	options, err := redisOptions(address, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewRedisStateStore(redis.NewClient(options), "syncer:")
+}
+
+func TestRedisStateStore(t *testing.T) {
+	t.Run("WritesAndReadsKeysUnderPrefix", func(t *testing.T) {
+
+		server := miniredis.NewMiniRedis()
+		server.RequireAuth("secret")
+		if err := server.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		store := newRedisTestStore(t, server.Addr(), "secret")
+		ctx := context.Background()
+
+		// act
+		err := store.Write(ctx, "/data/work-queue.json", []byte("[\r\n]"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"syncer:work-queue.json"}, server.Keys())
+		data, err := store.Read(ctx, "/data/work-queue.json")
+		assert.Nil(t, err)
+		assert.Equal(t, "[\r\n]", string(data))
+	})
+
+	t.Run("ReturnsErrStateNotFoundForMissingKey", func(t *testing.T) {
+
+		server, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		store := newRedisTestStore(t, server.Addr(), "")
+
+		// act
+		_, err = store.Read(context.Background(), "stats-history.json")
+
+		assert.True(t, errors.Is(err, ErrStateNotFound))
+	})
+
+	t.Run("ReturnsErrorIfAuthenticationFails", func(t *testing.T) {
+
+		server := miniredis.NewMiniRedis()
+		server.RequireAuth("secret")
+		if err := server.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		store := newRedisTestStore(t, server.Addr(), "wrong")
+
+		// act
+		_, err := store.Read(context.Background(), "stats-history.json")
+
+		assert.NotNil(t, err)
+		assert.False(t, errors.Is(err, ErrStateNotFound))
+	})
+
+	t.Run("ReturnsErrorReplyOfServer", func(t *testing.T) {
+
+		server, err := miniredis.Run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		server.Lpush("syncer:stats-history.json", "not a string")
+		store := newRedisTestStore(t, server.Addr(), "")
+
+		// act
+		_, err = store.Read(context.Background(), "stats-history.json")
+
+		if assert.NotNil(t, err) {
+			assert.False(t, errors.Is(err, ErrStateNotFound))
+			assert.Contains(t, err.Error(), "WRONGTYPE")
+		}
+	})
+
+	t.Run("ConnectsOverTLSWithRedissURL", func(t *testing.T) {
+
+		// borrow the self-signed certificate of a tls test server for 127.0.0.1
+		certificateServer := httptest.NewTLSServer(nil)
+		defer certificateServer.Close()
+		server, err := miniredis.RunTLS(&tls.Config{Certificates: certificateServer.TLS.Certificates})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		server.RequireAuth("secret")
+		options, err := redisOptions("rediss://"+server.Addr(), "secret")
+		if !assert.Nil(t, err) || !assert.NotNil(t, options.TLSConfig) {
+			return
+		}
+		options.TLSConfig.RootCAs = x509.NewCertPool()
+		options.TLSConfig.RootCAs.AddCert(certificateServer.Certificate())
+		store := NewRedisStateStore(redis.NewClient(options), "syncer:")
+		ctx := context.Background()
+
+		// act
+		err = store.Write(ctx, "work-queue.json", []byte("[]"))
+
+		assert.Nil(t, err)
+		data, err := store.Read(ctx, "work-queue.json")
+		assert.Nil(t, err)
+		assert.Equal(t, "[]", string(data))
+	})
+}
+
+func TestRedisOptions(t *testing.T) {
+	t.Run("UsesPasswordOfUrlUnlessPasswordIsSet", func(t *testing.T) {
+
+		// act
+		fromURL, err := redisOptions("redis://:from-url@redis:6380", "")
+		assert.Nil(t, err)
+		overridden, err := redisOptions("redis://:from-url@redis:6380", "from-flag")
+		assert.Nil(t, err)
+
+		assert.Equal(t, "redis:6380", fromURL.Addr)
+		assert.Equal(t, "from-url", fromURL.Password)
+		assert.Nil(t, fromURL.TLSConfig)
+		assert.Equal(t, "from-flag", overridden.Password)
+	})
+
+	t.Run("ConnectsToHostAndPortWithoutTLS", func(t *testing.T) {
+
+		// act
+		options, err := redisOptions("redis:6379", "secret")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "redis:6379", options.Addr)
+		assert.Nil(t, options.TLSConfig)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Report is the structured result of a sync run with all its actions and errors, written to --report-file for callers running the binary
+type Report struct {
+	RunID            string    `json:"runID"`
+	Provider         string    `json:"provider"`
+	Tenant           string    `json:"tenant,omitempty"`
+	StartedAt        time.Time `json:"startedAt"`
+	FinishedAt       time.Time `json:"finishedAt"`
+	Succeeded        bool      `json:"succeeded"`
+	Error            string    `json:"error,omitempty"`
+	DirectoryGroups  int       `json:"directoryGroups"`
+	DirectoryMembers int       `json:"directoryMembers"`
+	Groups           int       `json:"groups"`
+	Users            int       `json:"users"`
+	// NextMembershipExpiry is when the next time-bound membership expires, for scheduling a sync to remove it on time
+	NextMembershipExpiry *time.Time `json:"nextMembershipExpiry,omitempty"`
+
+	Actions          []*ReportAction    `json:"actions"`
+	NameConflicts    []*NameConflict    `json:"nameConflicts,omitempty"`
+	PolicyViolations []*PolicyViolation `json:"policyViolations,omitempty"`
+	Anomalies        []*StatsAnomaly    `json:"anomalies,omitempty"`
+	DeadLetters      []*DeadLetter      `json:"deadLetters,omitempty"`
+	// UnprovisionedUsers are the directory users that never logged in to estafette, only reported with --sync-all-domain-users
+	UnprovisionedUsers []string `json:"unprovisionedUsers,omitempty"`
+
+	GsuiteAPICalls       int64 `json:"gsuiteApiCalls,omitempty"`
+	GsuiteQuotaRemaining *int  `json:"gsuiteQuotaRemaining,omitempty"`
+}
+
+// ReportAction is a single action of a sync run with its error, if it failed
+type ReportAction struct {
+	Type        ActionType `json:"type"`
+	EntityID    string     `json:"entityID,omitempty"`
+	Description string     `json:"description"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// runWithReport synchronizes the directory groups to estafette once, as configured with the flags and config, and returns a Report with all actions and errors; the report is returned for failed runs as well. The sync still reads the package level flags of this binary, so it can't be embedded by other programs yet
+func runWithReport(ctx context.Context, config *Config) (*Report, error) {
+	run, err := syncOnce(ctx, config)
+	if run == nil {
+		run = &SyncRun{Provider: *provider, Tenant: tenantName(), Err: err}
+	}
+
+	return newReport(run), err
+}
+
+// newReport returns the report for the run
+func newReport(run *SyncRun) *Report {
+
+	report := &Report{
+		RunID:                run.ID,
+		Provider:             run.Provider,
+		Tenant:               run.Tenant,
+		StartedAt:            run.StartedAt,
+		FinishedAt:           run.FinishedAt,
+		Succeeded:            run.Err == nil,
+		DirectoryGroups:      run.DirectoryGroups,
+		DirectoryMembers:     run.DirectoryMembers,
+		Groups:               run.Groups,
+		Users:                run.Users,
+		NextMembershipExpiry: run.NextMembershipExpiry,
+		Actions:              make([]*ReportAction, 0, len(run.Actions)),
+		NameConflicts:        run.NameConflicts,
+		PolicyViolations:     run.PolicyViolations,
+		Anomalies:            run.Anomalies,
+		DeadLetters:          run.DeadLetters,
+		UnprovisionedUsers:   run.UnprovisionedUsers,
+		GsuiteAPICalls:       run.GsuiteAPICalls,
+		GsuiteQuotaRemaining: run.GsuiteQuotaRemaining,
+	}
+
+	if run.Err != nil {
+		report.Error = run.Err.Error()
+	}
+
+	for _, a := range run.Actions {
+		action := &ReportAction{
+			Type:        a.Type,
+			EntityID:    a.entityID(),
+			Description: a.String(),
+		}
+		if a.Err != nil {
+			action.Error = a.Err.Error()
+		}
+		report.Actions = append(report.Actions, action)
+	}
+
+	return report
+}
+
+// writeReport writes the report as json to the path
+func writeReport(path string, report *Report) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed marshalling report: %w", err)
+	}
+
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Failed writing report to %v: %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"golang.org/x/oauth2/google"
+	gmail "google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// ReportMailer sends the formatted report of a sync run to the distribution lists
+type ReportMailer interface {
+	SendReport(ctx context.Context, subject, contentType, body string) error
+}
+
+// NewReportMailer returns a ReportMailer sending as from to the recipients, through the smtp server or, if via is gmail, through the gmail api with the domain-wide delegated service account impersonating the sender
+func NewReportMailer(via, from string, to []string, smtpAddress, smtpUsername, smtpPassword string) (ReportMailer, error) {
+	if from == "" || len(to) == 0 {
+		return nil, fmt.Errorf("Sending reports needs --report-email-from and --report-email-to")
+	}
+
+	if via == "gmail" {
+		return &gmailMailer{from: from, to: to}, nil
+	}
+	if smtpAddress == "" {
+		return nil, fmt.Errorf("Sending reports through smtp needs --smtp-address")
+	}
+
+	return &smtpMailer{address: smtpAddress, username: smtpUsername, password: smtpPassword, from: from, to: to}, nil
+}
+
+type smtpMailer struct {
+	address  string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (m *smtpMailer) SendReport(ctx context.Context, subject, contentType, body string) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "SmtpMailer::SendReport")
+	defer span.Finish()
+
+	// servers accepting unauthenticated mail from within the network don't need credentials
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.address)
+		if err != nil {
+			return fmt.Errorf("Invalid --smtp-address %v: %w", m.address, err)
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+
+	err := smtp.SendMail(m.address, auth, m.from, m.to, buildReportMessage(m.from, m.to, subject, contentType, body, time.Now()))
+	if err != nil {
+		return fmt.Errorf("Failed sending report through %v: %w", m.address, err)
+	}
+
+	return nil
+}
+
+type gmailMailer struct {
+	from string
+	to   []string
+}
+
+func (m *gmailMailer) SendReport(ctx context.Context, subject, contentType, body string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GmailMailer::SendReport")
+	defer span.Finish()
+
+	serviceAccountKeyFileBytes, err := ioutil.ReadFile(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	if err != nil {
+		return err
+	}
+
+	// the service account sends as the sender, which needs the gmail.send scope in its domain-wide delegation
+	jwtConfig, err := google.JWTConfigFromJSON(serviceAccountKeyFileBytes, gmail.GmailSendScope)
+	if err != nil {
+		return err
+	}
+	jwtConfig.Subject = m.from
+
+	gmailService, err := gmail.NewService(ctx, option.WithHTTPClient(jwtConfig.Client(sharedTransportContext(ctx))))
+	if err != nil {
+		return err
+	}
+
+	raw := base64.URLEncoding.EncodeToString(buildReportMessage(m.from, m.to, subject, contentType, body, time.Now()))
+	_, err = gmailService.Users.Messages.Send("me", &gmail.Message{Raw: raw}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("Failed sending report through gmail as %v: %w", m.from, err)
+	}
+
+	return nil
+}
+
+// buildReportMessage returns the report as email message with the headers both smtp and the gmail api need
+func buildReportMessage(from string, to []string, subject, contentType, body string, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %v\r\n", from)
+	fmt.Fprintf(&b, "To: %v\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %v\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %v\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: %v; charset=utf-8\r\n", contentType)
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return b.Bytes()
+}
+
+// reportSubject returns the email subject summarizing the run
+func reportSubject(report *Report) string {
+	outcome := "succeeded"
+	if !report.Succeeded {
+		outcome = "failed"
+	}
+
+	subject := fmt.Sprintf("%v sync %v %v with %v changes", report.Provider, report.RunID, outcome, len(report.Actions))
+	if report.Tenant != "" {
+		subject = fmt.Sprintf("[%v] %v", report.Tenant, subject)
+	}
+
+	return subject
+}
+
+var reportMarkdownTemplate = texttemplate.Must(texttemplate.New("report").Parse(`# {{.Provider}} sync {{.RunID}} {{if .Succeeded}}succeeded{{else}}failed{{end}}
+
+| | |
+|---|---|
+| Started | {{.StartedAt.Format "2006-01-02 15:04:05 MST"}} |
+| Finished | {{.FinishedAt.Format "2006-01-02 15:04:05 MST"}} |
+| Directory groups | {{.DirectoryGroups}} |
+| Directory members | {{.DirectoryMembers}} |
+| Estafette groups | {{.Groups}} |
+| Estafette users | {{.Users}} |
+{{if .Error}}
+**Error:** {{.Error}}
+{{end}}
+## Changes
+{{range .Actions}}
+- {{.Description}}{{if .Error}} **failed:** {{.Error}}{{end}}{{else}}
+No changes.{{end}}
+{{if .NameConflicts}}
+## Name conflicts
+{{range .NameConflicts}}
+- {{.}}{{end}}
+{{end}}{{if .PolicyViolations}}
+## Policy violations
+{{range .PolicyViolations}}
+- {{.}} ({{.Enforcement}}){{end}}
+{{end}}{{if .Anomalies}}
+## Anomalies
+{{range .Anomalies}}
+- {{.}}{{end}}
+{{end}}{{if .DeadLetters}}
+## Groups left as they are
+{{range .DeadLetters}}
+- {{.Group}}: {{.Error}}{{end}}
+{{end}}{{if .UnprovisionedUsers}}
+## Unprovisioned users
+{{range .UnprovisionedUsers}}
+- {{.}}{{end}}
+{{end}}`))
+
+var reportHTMLTemplate = htmltemplate.Must(htmltemplate.New("report").Parse(`<html>
+<body>
+<h1>{{.Provider}} sync {{.RunID}} {{if .Succeeded}}succeeded{{else}}failed{{end}}</h1>
+<table>
+<tr><td>Started</td><td>{{.StartedAt.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Finished</td><td>{{.FinishedAt.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><td>Directory groups</td><td>{{.DirectoryGroups}}</td></tr>
+<tr><td>Directory members</td><td>{{.DirectoryMembers}}</td></tr>
+<tr><td>Estafette groups</td><td>{{.Groups}}</td></tr>
+<tr><td>Estafette users</td><td>{{.Users}}</td></tr>
+</table>
+{{if .Error}}<p><strong>Error:</strong> {{.Error}}</p>
+{{end}}<h2>Changes</h2>
+{{if .Actions}}<ul>
+{{range .Actions}}<li>{{.Description}}{{if .Error}} <strong>failed:</strong> {{.Error}}{{end}}</li>
+{{end}}</ul>
+{{else}}<p>No changes.</p>
+{{end}}{{if .NameConflicts}}<h2>Name conflicts</h2>
+<ul>
+{{range .NameConflicts}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{if .PolicyViolations}}<h2>Policy violations</h2>
+<ul>
+{{range .PolicyViolations}}<li>{{.}} ({{.Enforcement}})</li>
+{{end}}</ul>
+{{end}}{{if .Anomalies}}<h2>Anomalies</h2>
+<ul>
+{{range .Anomalies}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{if .DeadLetters}}<h2>Groups left as they are</h2>
+<ul>
+{{range .DeadLetters}}<li>{{.Group}}: {{.Error}}</li>
+{{end}}</ul>
+{{end}}{{if .UnprovisionedUsers}}<h2>Unprovisioned users</h2>
+<ul>
+{{range .UnprovisionedUsers}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}</body>
+</html>
+`))
+
+// renderReport formats the report as markdown or html, returning the content type to send it with; markdown is sent as plain text, which reads well in every mail client
+func renderReport(report *Report, format string) (contentType, body string, err error) {
+	var b bytes.Buffer
+	if format == "html" {
+		contentType = "text/html"
+		err = reportHTMLTemplate.Execute(&b, report)
+	} else {
+		contentType = "text/plain"
+		err = reportMarkdownTemplate.Execute(&b, report)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("Failed rendering %v report: %w", format, err)
+	}
+
+	return contentType, b.String(), nil
+}
+
+// reportEmailRecipients returns the comma-separated recipients of --report-email-to
+func reportEmailRecipients() (recipients []string) {
+	for _, r := range strings.Split(*reportEmailTo, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			recipients = append(recipients, r)
+		}
+	}
+
+	return
+}
+
+// emailReport sends the report of the run to --report-email-to if set; failures are only logged since the report is informational
+func emailReport(ctx context.Context, run *SyncRun) {
+	recipients := reportEmailRecipients()
+	if len(recipients) == 0 || run == nil {
+		return
+	}
+
+	mailer, err := NewReportMailer(*reportEmailVia, *reportEmailFrom, recipients, *smtpAddress, *smtpUsername, *smtpPassword)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed creating report mailer")
+		return
+	}
+
+	report := newReport(run)
+	contentType, body, err := renderReport(report, *reportEmailFormat)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed rendering sync report")
+		return
+	}
+
+	err = mailer.SendReport(ctx, reportSubject(report), contentType, body)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed emailing sync report")
+		return
+	}
+
+	logFromContext(ctx).Info().Msgf("Emailed sync report to %v", strings.Join(recipients, ", "))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderReport(t *testing.T) {
+
+	report := newReport(&SyncRun{
+		ID:              "run-1",
+		Provider:        gsuiteProviderName,
+		DirectoryGroups: 2,
+		Actions:         []*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "<platform>"}, Err: errors.New("conflict")}},
+		DeadLetters:     []*DeadLetter{{Group: "ci-release@example.com", Error: "group not found"}},
+		Err:             errors.New("Failed applying <1> action"),
+	})
+
+	t.Run("RendersMarkdownWithChangesAndIssues", func(t *testing.T) {
+
+		// act
+		contentType, body, err := renderReport(report, "markdown")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "text/plain", contentType)
+		assert.True(t, strings.HasPrefix(body, "# gsuite sync run-1 failed\n"))
+		assert.Contains(t, body, "| Directory groups | 2 |")
+		assert.Contains(t, body, "- create group <platform> **failed:** conflict")
+		assert.Contains(t, body, "- ci-release@example.com: group not found")
+	})
+
+	t.Run("RendersEscapedHTML", func(t *testing.T) {
+
+		// act
+		contentType, body, err := renderReport(report, "html")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "text/html", contentType)
+		assert.Contains(t, body, "<li>create group &lt;platform&gt; <strong>failed:</strong> conflict</li>")
+		assert.Contains(t, body, "Failed applying &lt;1&gt; action")
+	})
+}
+
+func TestSmtpMailer(t *testing.T) {
+	t.Run("SendsReportToAllRecipients", func(t *testing.T) {
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		defer listener.Close()
+		received := make(chan []string, 1)
+		go serveFakeSMTP(listener, received)
+
+		mailer, err := NewReportMailer("smtp", "syncer@example.com", []string{"security@example.com", "platform@example.com"}, listener.Addr().String(), "", "")
+		assert.Nil(t, err)
+
+		// act
+		err = mailer.SendReport(context.Background(), "gsuite sync run-1 succeeded with 0 changes", "text/plain", "No changes.\n")
+
+		assert.Nil(t, err)
+		commands := <-received
+		assert.Contains(t, commands, "RCPT TO:<security@example.com>")
+		assert.Contains(t, commands, "RCPT TO:<platform@example.com>")
+		assert.Contains(t, commands, "Subject: gsuite sync run-1 succeeded with 0 changes")
+		assert.Contains(t, commands, "No changes.")
+	})
+
+	t.Run("ReturnsErrorWithoutRecipients", func(t *testing.T) {
+
+		// act
+		_, err := NewReportMailer("smtp", "syncer@example.com", nil, "localhost:25", "", "")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestBuildReportMessage(t *testing.T) {
+	t.Run("EncodesNonASCIISubject", func(t *testing.T) {
+
+		// act
+		message := buildReportMessage("syncer@example.com", []string{"security@example.com"}, "rename group releases → release", "text/html", "<p>done</p>", time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC))
+
+		assert.Equal(t, "From: syncer@example.com\r\nTo: security@example.com\r\nSubject: =?utf-8?q?rename_group_releases_=E2=86=92_release?=\r\nDate: Mon, 01 Jun 2020 12:00:00 +0000\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\n\r\n<p>done</p>", string(message))
+	})
+}
+
+// serveFakeSMTP accepts a single smtp session without authentication and sends the commands and message lines it received on received
+func serveFakeSMTP(listener net.Listener, received chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	lines := make([]string, 0)
+	reader := bufio.NewReader(conn)
+	write := func(response string) { _, _ = conn.Write([]byte(response + "\r\n")) }
+
+	write("220 localhost ESMTP")
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			received <- lines
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+
+		switch {
+		case inData && line == ".":
+			inData = false
+			write("250 OK")
+		case inData:
+		case strings.HasPrefix(line, "EHLO"):
+			write("250 localhost")
+		case line == "DATA":
+			inData = true
+			write("354 Go ahead")
+		case line == "QUIT":
+			write("221 Bye")
+			received <- lines
+			return
+		default:
+			write("250 OK")
+		}
+	}
+}
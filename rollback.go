@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/opentracing/opentracing-go"
+)
+
+// rollbackRun reverses the mutations recorded in the audit entries of a run on top of the current estafette state; groups are rolled back first, so the memberships of recreated groups can be restored with the ids estafette assigned them. Like apply it refuses if any of the reversed fields changed in estafette since the run
+func rollbackRun(ctx context.Context, apiClient ApiClient, entries []*AuditEntry, dryRun bool) (actions []*Action, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Rollback::Run")
+	defer span.Finish()
+
+	s, err := fetchEstafetteState(ctx, apiClient)
+	if err != nil {
+		return
+	}
+
+	actions, skipped, err := planRollback(entries, s)
+	if err != nil {
+		return
+	}
+	for _, reason := range skipped {
+		logFromContext(ctx).Warn().Msgf("Not rolling back: %v", reason)
+	}
+
+	groupActions, userActions := make([]*Action, 0), make([]*Action, 0)
+	for _, a := range actions {
+		if a.Type == ActionUpdateUser {
+			userActions = append(userActions, a)
+		} else {
+			groupActions = append(groupActions, a)
+		}
+	}
+
+	if err = rebasePlan(groupActions, s); err != nil {
+		return
+	}
+	if dryRun {
+		return actions, rebasePlan(userActions, s)
+	}
+
+	err = apiClient.ApplyActions(ctx, s.token, groupActions)
+	if err != nil || len(userActions) == 0 {
+		return
+	}
+
+	// recreated groups got new ids, which the memberships to restore have to point to
+	s, err = fetchEstafetteState(ctx, apiClient)
+	if err != nil {
+		return
+	}
+	remapRecreatedGroups(userActions, s.groups)
+	if err = rebasePlan(userActions, s); err != nil {
+		return
+	}
+
+	err = apiClient.ApplyActions(ctx, s.token, userActions)
+
+	return
+}
+
+// readRunAuditEntries returns the audit entries of the mutations of the run, in the order they were recorded
+func readRunAuditEntries(r io.Reader, runID string) ([]*AuditEntry, error) {
+	entries := make([]*AuditEntry, 0)
+	reader := bufio.NewReader(r)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry AuditEntry
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				return nil, fmt.Errorf("Line %v isn't an audit entry: %w", lineNumber, jsonErr)
+			}
+			if entry.RunID == runID && entry.Action != ActionRunSummary {
+				entries = append(entries, &entry)
+			}
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// planRollback returns the actions reversing the mutations recorded in the audit entries, newest first: created groups are deleted, deleted groups are recreated and updated groups and users get their fields from before the run back; failed mutations weren't applied so they're skipped, as are mutations the audit log can't reverse
+func planRollback(entries []*AuditEntry, s state) (actions []*Action, skipped []string, err error) {
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if e.Error != "" {
+			continue
+		}
+
+		switch e.Action {
+		case ActionCreateGroup:
+			// created groups are recorded without the id estafette assigned, so they're found by name
+			var created *contracts.Group
+			for _, g := range s.groups {
+				if strings.EqualFold(g.Name, e.EntityName) {
+					created = g
+				}
+			}
+			if created == nil {
+				skipped = append(skipped, fmt.Sprintf("created group %v doesn't exist anymore", e.EntityName))
+				continue
+			}
+			actions = append(actions, &Action{Type: ActionDeleteGroup, GroupBefore: created, Group: created})
+
+		case ActionDeleteGroup:
+			deleted := &contracts.Group{}
+			if err = json.Unmarshal(e.Before, deleted); err != nil {
+				return nil, nil, fmt.Errorf("Failed unmarshalling deleted group %v: %w", e.EntityName, err)
+			}
+			deleted.ID = ""
+			actions = append(actions, &Action{Type: ActionCreateGroup, Group: deleted})
+
+		case ActionUpdateGroup:
+			before, after := &contracts.Group{}, &contracts.Group{}
+			if err = unmarshalAuditSnapshots(e, before, after); err != nil {
+				return nil, nil, err
+			}
+			actions = append(actions, &Action{Type: ActionUpdateGroup, GroupBefore: after, Group: before})
+
+		case ActionUpdateUser:
+			before, after := &contracts.User{}, &contracts.User{}
+			if err = unmarshalAuditSnapshots(e, before, after); err != nil {
+				return nil, nil, err
+			}
+			actions = append(actions, &Action{Type: ActionUpdateUser, UserBefore: after, User: before})
+
+		default:
+			skipped = append(skipped, fmt.Sprintf("%v of %v %v isn't recorded with the fields to reverse it", e.Action, e.EntityType, e.EntityName))
+		}
+	}
+
+	return actions, skipped, nil
+}
+
+// unmarshalAuditSnapshots unmarshals the entity before and after the mutation of the audit entry
+func unmarshalAuditSnapshots(e *AuditEntry, before, after interface{}) error {
+	if len(e.Before) == 0 || len(e.After) == 0 {
+		return fmt.Errorf("Audit entry for %v of %v %v has no before and after snapshot", e.Action, e.EntityType, e.EntityName)
+	}
+	if err := json.Unmarshal(e.Before, before); err != nil {
+		return fmt.Errorf("Failed unmarshalling %v %v before %v: %w", e.EntityType, e.EntityName, e.Action, err)
+	}
+	if err := json.Unmarshal(e.After, after); err != nil {
+		return fmt.Errorf("Failed unmarshalling %v %v after %v: %w", e.EntityType, e.EntityName, e.Action, err)
+	}
+
+	return nil
+}
+
+// remapRecreatedGroups points the memberships of the user actions at recreated groups to the ids estafette assigned them, matching them by name
+func remapRecreatedGroups(actions []*Action, groups []*contracts.Group) {
+	ids := make(map[string]bool, len(groups))
+	byName := make(map[string]*contracts.Group, len(groups))
+	for _, g := range groups {
+		ids[g.ID] = true
+		byName[strings.ToLower(g.Name)] = g
+	}
+
+	for _, a := range actions {
+		if a.Type != ActionUpdateUser {
+			continue
+		}
+		for i, g := range a.User.Groups {
+			if ids[g.ID] {
+				continue
+			}
+			if recreated, ok := byName[strings.ToLower(g.Name)]; ok {
+				a.User.Groups[i] = recreated
+			}
+		}
+	}
+}
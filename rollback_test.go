@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanRollback(t *testing.T) {
+	t.Run("ReversesMutationsNewestFirstAndSkipsFailedOnes", func(t *testing.T) {
+
+		platform := &contracts.Group{ID: "g1", Name: "platform"}
+		release := &contracts.Group{ID: "g2", Name: "release"}
+		renamed := &contracts.Group{ID: "g2", Name: "releases"}
+		marshal := func(v interface{}) json.RawMessage {
+			data, _ := json.Marshal(v)
+			return data
+		}
+		entries := []*AuditEntry{
+			{Action: ActionCreateGroup, EntityType: "group", EntityName: "platform", After: marshal(&contracts.Group{Name: "platform"})},
+			{Action: ActionUpdateGroup, EntityType: "group", EntityID: "g2", EntityName: "releases", Before: marshal(release), After: marshal(renamed)},
+			{Action: ActionDeleteGroup, EntityType: "group", EntityID: "g3", EntityName: "legacy", Before: marshal(&contracts.Group{ID: "g3", Name: "legacy"}), After: marshal(&contracts.Group{ID: "g3", Name: "legacy"})},
+			{Action: ActionCreateGroup, EntityType: "group", EntityName: "failed", Error: "conflict"},
+		}
+
+		// act
+		actions, skipped, err := planRollback(entries, state{groups: []*contracts.Group{platform, renamed}})
+
+		assert.Nil(t, err)
+		assert.Empty(t, skipped)
+		assert.Equal(t, []*Action{
+			{Type: ActionCreateGroup, Group: &contracts.Group{Name: "legacy"}},
+			{Type: ActionUpdateGroup, GroupBefore: renamed, Group: release},
+			{Type: ActionDeleteGroup, GroupBefore: platform, Group: platform},
+		}, actions)
+	})
+
+	t.Run("SkipsCreatedGroupsThatDontExistAnymore", func(t *testing.T) {
+
+		entries := []*AuditEntry{
+			{Action: ActionCreateGroup, EntityType: "group", EntityName: "platform"},
+		}
+
+		// act
+		actions, skipped, err := planRollback(entries, state{})
+
+		assert.Nil(t, err)
+		assert.Empty(t, actions)
+		assert.Equal(t, []string{"created group platform doesn't exist anymore"}, skipped)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	// syncRunIDHeader carries the run id on requests to the estafette api, so its logs can be correlated with the run
+	syncRunIDHeader = "X-Sync-Run-ID"
+	// idempotencyKeyHeader carries the key derived from the run, the entity and its desired state on create requests, so the estafette api can detect retried creates
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set by the estafette api on the response to a create it detected as a duplicate of an earlier attempt
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	// syncRunIDBaggageKey carries the run id as span baggage, so it's propagated to all spans of the run and to downstream services
+	syncRunIDBaggageKey = "sync-run-id"
+)
+
+type runIDContextKey struct{}
+
+// newRunID returns a random id in uuid v4 format for a sync run
+func newRunID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		// crypto/rand doesn't fail on supported platforms, an id that's unique enough for correlating logs is all that's needed anyway
+		return fmt.Sprintf("%x", b)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// contextWithRunID returns the context with the run id, and sets it as baggage and tag on the span in the context if any
+func contextWithRunID(ctx context.Context, runID string) context.Context {
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		span.SetBaggageItem(syncRunIDBaggageKey, runID)
+		span.SetTag(syncRunIDBaggageKey, runID)
+	}
+
+	return context.WithValue(ctx, runIDContextKey{}, runID)
+}
+
+// runIDFromContext returns the run id set with contextWithRunID, or from the baggage of the span in the context if it came from elsewhere; empty outside of a run
+func runIDFromContext(ctx context.Context) string {
+	if runID, ok := ctx.Value(runIDContextKey{}).(string); ok {
+		return runID
+	}
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		return span.BaggageItem(syncRunIDBaggageKey)
+	}
+
+	return ""
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// exitCodeRunTimeout is the exit code of a sync stopped by --run-timeout, so schedulers can tell it apart from a failed sync
+const exitCodeRunTimeout = 3
+
+var (
+	// ErrRunTimeout is returned for a sync stopped by --run-timeout before it finished
+	ErrRunTimeout = errors.New("run timeout exceeded")
+	// ErrRetryBudgetExhausted is returned for requests that weren't retried anymore, because the run used up --retry-budget
+	ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+	// ErrDirectoryLimitExceeded is returned for a sync stopped because the directory returned more groups or users than --max-groups or --max-users
+	ErrDirectoryLimitExceeded = errors.New("directory limit exceeded")
+)
+
+// retryBudget limits the number of retries of all api requests in a run combined, so a degraded api can't keep a run retrying for hours
+type retryBudget struct {
+	remaining int64
+}
+
+// newRetryBudget returns a retryBudget allowing the given number of retries, or nil if retries is zero so retries are only limited per request
+func newRetryBudget(retries int) *retryBudget {
+	if retries <= 0 {
+		return nil
+	}
+
+	return &retryBudget{remaining: int64(retries)}
+}
+
+// take uses up a retry and returns false if none are left; a nil retryBudget always allows retrying
+func (b *retryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+
+	return atomic.AddInt64(&b.remaining, -1) >= 0
+}
+
+type retryBudgetContextKey struct{}
+
+// contextWithRetryBudget returns the context with the retry budget shared by all requests made with it
+func contextWithRetryBudget(ctx context.Context, budget *retryBudget) context.Context {
+	return context.WithValue(ctx, retryBudgetContextKey{}, budget)
+}
+
+// retryBudgetFromContext returns the retry budget set with contextWithRetryBudget, or nil if there's none
+func retryBudgetFromContext(ctx context.Context) *retryBudget {
+	budget, _ := ctx.Value(retryBudgetContextKey{}).(*retryBudget)
+	return budget
+}
+
+// requestRetries holds the retry state of a single request: a cancel func to stop it from being retried, and the wait the api asked for before retrying
+type requestRetries struct {
+	cancel     context.CancelFunc
+	stopped    int32
+	retryAfter int64
+}
+
+type requestRetriesContextKey struct{}
+
+// contextWithRequestRetries returns a context for a single request that's canceled by stopRetries
+func contextWithRequestRetries(ctx context.Context) (context.Context, *requestRetries) {
+	ctx, cancel := context.WithCancel(ctx)
+	retries := &requestRetries{cancel: cancel}
+
+	return context.WithValue(ctx, requestRetriesContextKey{}, retries), retries
+}
+
+// requestRetriesFromContext returns the retry state set with contextWithRequestRetries, or nil if there's none
+func requestRetriesFromContext(ctx context.Context) *requestRetries {
+	retries, _ := ctx.Value(requestRetriesContextKey{}).(*requestRetries)
+	return retries
+}
+
+// stopRetries cancels the request made with the context from contextWithRequestRetries, if any
+func stopRetries(ctx context.Context) {
+	if retries := requestRetriesFromContext(ctx); retries != nil {
+		atomic.StoreInt32(&retries.stopped, 1)
+		retries.cancel()
+	}
+}
+
+// wasStopped checks whether the request was canceled by stopRetries
+func (r *requestRetries) wasStopped() bool {
+	return atomic.LoadInt32(&r.stopped) == 1
+}
+
+// setRetryAfter records the wait the api asked for in the last response; it's a no-op for nil
+func (r *requestRetries) setRetryAfter(wait time.Duration) {
+	if r != nil {
+		atomic.StoreInt64(&r.retryAfter, int64(wait))
+	}
+}
+
+// takeRetryAfter returns the wait the api asked for in the last response and clears it, so it's only waited for once
+func (r *requestRetries) takeRetryAfter() time.Duration {
+	if r == nil {
+		return 0
+	}
+
+	return time.Duration(atomic.SwapInt64(&r.retryAfter, 0))
+}
+
+// directoryLimits caps the number of groups and users a sync accepts from the directory, guarding against runaway group creation when the directory returns far more than expected; zero is unlimited
+type directoryLimits struct {
+	maxGroups int
+	maxUsers  int
+	// members holds the keys of the distinct members counted so far
+	members map[string]bool
+}
+
+// newDirectoryLimits returns directoryLimits for the maximum number of groups and users
+func newDirectoryLimits(maxGroups, maxUsers int) *directoryLimits {
+	return &directoryLimits{maxGroups: maxGroups, maxUsers: maxUsers, members: map[string]bool{}}
+}
+
+// checkGroups counts the distinct members of the groups and returns ErrDirectoryLimitExceeded if the groups counted so far exceed the limits; a nil directoryLimits is unlimited
+func (l *directoryLimits) checkGroups(groups int, members []*DirectoryMember) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.maxGroups > 0 && groups > l.maxGroups {
+		return fmt.Errorf("%w: the directory returned more than %v groups, raise --max-groups if that's expected", ErrDirectoryLimitExceeded, l.maxGroups)
+	}
+
+	if l.maxUsers > 0 {
+		for _, m := range members {
+			l.members[aggregateMemberKey(m)] = true
+		}
+		if len(l.members) > l.maxUsers {
+			return fmt.Errorf("%w: the directory groups hold more than %v distinct members, raise --max-users if that's expected", ErrDirectoryLimitExceeded, l.maxUsers)
+		}
+	}
+
+	return nil
+}
+
+// checkUsers returns ErrDirectoryLimitExceeded if the directory users exceed the limit; a nil directoryLimits is unlimited
+func (l *directoryLimits) checkUsers(directoryUsers []*DirectoryUser) error {
+	if l == nil || l.maxUsers <= 0 || len(directoryUsers) <= l.maxUsers {
+		return nil
+	}
+
+	return fmt.Errorf("%w: the directory returned %v users, more than --max-users of %v; raise it if that's expected", ErrDirectoryLimitExceeded, len(directoryUsers), l.maxUsers)
+}
+
+// partialReport describes how far a run that didn't finish got, for logging
+func partialReport(run *SyncRun) string {
+	if run == nil {
+		return "nothing was fetched or applied"
+	}
+
+	applied, failed := 0, make([]string, 0)
+	for _, a := range run.Actions {
+		if a.Err == nil {
+			applied++
+		} else {
+			failed = append(failed, a.String())
+		}
+	}
+
+	report := fmt.Sprintf("fetched %v %v groups with %v members, applied %v of %v actions", run.DirectoryGroups, run.Provider, run.DirectoryMembers, applied, len(run.Actions))
+	if len(failed) > 0 {
+		report += fmt.Sprintf("; failed or not applied: %v", strings.Join(failed, ", "))
+	}
+
+	return report
+}
+
+// maxRetryAfter caps the wait asked for by the api, so a misconfigured Retry-After header can't stall a run
+const maxRetryAfter = time.Minute
+
+// retryAfterTransport records the Retry-After header of rate limited and unavailable responses for the request, so it's waited for before retrying
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.next.RoundTrip(request)
+	if err != nil {
+		return response, err
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := parseRetryAfter(response.Header.Get("Retry-After"), time.Now()); ok {
+			requestRetriesFromContext(request.Context()).setRetryAfter(wait)
+		}
+	}
+
+	return response, nil
+}
+
+// parseRetryAfter returns the wait from a Retry-After header in seconds or as http date, capped at maxRetryAfter; it returns false if the header is missing or invalid
+func parseRetryAfter(value string, now time.Time) (wait time.Duration, ok bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+	} else {
+		return 0, false
+	}
+
+	if wait < 0 {
+		wait = 0
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+
+	return wait, true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// ShadowDiscrepancy is a difference between the views of the primary and the shadow provider on a single group
+type ShadowDiscrepancy struct {
+	// Group is the lowercased name the group is matched by, since ids differ between providers
+	Group            string
+	MissingInShadow  bool
+	MissingInPrimary bool
+	// MembersOnlyInPrimary and MembersOnlyInShadow are the lowercased emails of the members only one of the providers lists
+	MembersOnlyInPrimary []string
+	MembersOnlyInShadow  []string
+}
+
+func (d *ShadowDiscrepancy) String() string {
+	switch {
+	case d.MissingInShadow:
+		return fmt.Sprintf("group %v is missing in the shadow provider", d.Group)
+	case d.MissingInPrimary:
+		return fmt.Sprintf("group %v is missing in the primary provider", d.Group)
+	}
+
+	return fmt.Sprintf("group %v has members %v only in the primary provider and %v only in the shadow provider", d.Group, d.MembersOnlyInPrimary, d.MembersOnlyInShadow)
+}
+
+// compareShadowProvider fetches the groups and members from the provider set with --shadow-provider and logs where its view differs from the primary provider's; it never fails the sync, since only the primary provider is applied
+func compareShadowProvider(ctx context.Context, primary Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember) []*ShadowDiscrepancy {
+	if *shadowProvider == "" {
+		return nil
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ShadowProvider::Compare")
+	defer span.Finish()
+
+	shadow, err := createNamedProvider(ctx, *shadowProvider)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msgf("Failed creating shadow provider %v, skipping comparison", *shadowProvider)
+		return nil
+	}
+	defer closeProvider(ctx, shadow)
+
+	// groups the shadow fails to list shouldn't protect the estafette groups of the primary, so the shadow fails as a whole instead
+	shadowGroupMembers, err := shadow.GetGroupsWithMembers(contextWithDeadLetterList(ctx, nil))
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msgf("Failed fetching %v groups and members for the shadow comparison, skipping it", shadow.Name())
+		return nil
+	}
+
+	discrepancies := diffProviderViews(groupMembers, shadowGroupMembers)
+	for _, d := range discrepancies {
+		logFromContext(ctx).Warn().Str("primary", primary.Name()).Str("shadow", shadow.Name()).Msgf("Shadow discrepancy: %v", d)
+	}
+
+	span.LogKV("discrepancies", len(discrepancies))
+	logFromContext(ctx).Info().Msgf("Compared %v %v groups with %v %v groups from the shadow provider, found %v discrepancies", len(groupMembers), primary.Name(), len(shadowGroupMembers), shadow.Name(), len(discrepancies))
+
+	return discrepancies
+}
+
+// diffProviderViews matches the groups of both providers by name and their members by email, and returns the differences sorted by group name
+func diffProviderViews(primary, shadow map[*DirectoryGroup][]*DirectoryMember) []*ShadowDiscrepancy {
+
+	primaryGroups := membersByGroupName(primary)
+	shadowGroups := membersByGroupName(shadow)
+
+	discrepancies := make([]*ShadowDiscrepancy, 0)
+	for name, primaryMembers := range primaryGroups {
+		shadowMembers, ok := shadowGroups[name]
+		if !ok {
+			discrepancies = append(discrepancies, &ShadowDiscrepancy{Group: name, MissingInShadow: true})
+			continue
+		}
+
+		onlyInPrimary := membersMissingIn(primaryMembers, shadowMembers)
+		onlyInShadow := membersMissingIn(shadowMembers, primaryMembers)
+		if len(onlyInPrimary) > 0 || len(onlyInShadow) > 0 {
+			discrepancies = append(discrepancies, &ShadowDiscrepancy{Group: name, MembersOnlyInPrimary: onlyInPrimary, MembersOnlyInShadow: onlyInShadow})
+		}
+	}
+	for name := range shadowGroups {
+		if _, ok := primaryGroups[name]; !ok {
+			discrepancies = append(discrepancies, &ShadowDiscrepancy{Group: name, MissingInPrimary: true})
+		}
+	}
+
+	sort.Slice(discrepancies, func(i, j int) bool {
+		return discrepancies[i].Group < discrepancies[j].Group
+	})
+
+	return discrepancies
+}
+
+func membersByGroupName(groupMembers map[*DirectoryGroup][]*DirectoryMember) map[string]map[string]bool {
+	groups := make(map[string]map[string]bool, len(groupMembers))
+	for g, members := range groupMembers {
+		emails := make(map[string]bool, len(members))
+		for _, m := range members {
+			emails[strings.ToLower(m.Email)] = true
+		}
+		groups[strings.ToLower(g.Name)] = emails
+	}
+
+	return groups
+}
+
+// membersMissingIn returns the sorted emails in members that aren't in other
+func membersMissingIn(members, other map[string]bool) []string {
+	missing := make([]string, 0)
+	for email := range members {
+		if !other[email] {
+			missing = append(missing, email)
+		}
+	}
+	sort.Strings(missing)
+
+	return missing
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffProviderViews(t *testing.T) {
+	t.Run("ReturnsNoDiscrepanciesIfBothProvidersAgree", func(t *testing.T) {
+
+		primary := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "1", Name: "Team"}: {{ID: "a", Email: "John@example.com"}},
+		}
+		shadow := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "cn=team", Name: "team"}: {{ID: "uid=john", Email: "john@example.com"}},
+		}
+
+		// act
+		discrepancies := diffProviderViews(primary, shadow)
+
+		assert.Empty(t, discrepancies)
+	})
+
+	t.Run("ReturnsMissingGroupsAndDifferingMembersSortedByGroup", func(t *testing.T) {
+
+		primary := map[*DirectoryGroup][]*DirectoryMember{
+			{Name: "team-b"}: {{Email: "john@example.com"}, {Email: "jane@example.com"}},
+			{Name: "team-a"}: {},
+		}
+		shadow := map[*DirectoryGroup][]*DirectoryMember{
+			{Name: "team-b"}: {{Email: "john@example.com"}, {Email: "joe@example.com"}},
+			{Name: "team-c"}: {},
+		}
+
+		// act
+		discrepancies := diffProviderViews(primary, shadow)
+
+		assert.Equal(t, []*ShadowDiscrepancy{
+			{Group: "team-a", MissingInShadow: true},
+			{Group: "team-b", MembersOnlyInPrimary: []string{"jane@example.com"}, MembersOnlyInShadow: []string{"joe@example.com"}},
+			{Group: "team-c", MissingInPrimary: true},
+		}, discrepancies)
+		assert.Equal(t, "group team-b has members [jane@example.com] only in the primary provider and [joe@example.com] only in the shadow provider", discrepancies[1].String())
+	})
+}
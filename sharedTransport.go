@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	sharedTransportOnce sync.Once
+	sharedTransport     http.RoundTripper
+
+	// httpConnections counts the new and reused connections per host, served on /debug/vars in daemon mode
+	httpConnections = expvar.NewMap("http_connections")
+)
+
+// sharedRoundTripper returns the transport all clients of the syncer send their requests through, so connections to the same host are pooled and reused across clients and runs instead of every client dialing its own
+func sharedRoundTripper() http.RoundTripper {
+	sharedTransportOnce.Do(func() {
+		sharedTransport = &connectionCountingTransport{next: newPooledTransport(*httpMaxConnsPerHost)}
+	})
+
+	return sharedTransport
+}
+
+// sharedTransportContext returns the context for creating oauth2 clients, whose token and api requests then go through the shared transport as well
+func sharedTransportContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: sharedRoundTripper()})
+}
+
+// newPooledTransport returns a transport keeping up to maxConnsPerHost connections per host open for reuse, negotiating http/2 where the server supports it
+func newPooledTransport(maxConnsPerHost int) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   maxConnsPerHost,
+		MaxConnsPerHost:       maxConnsPerHost,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// connectionCountingTransport counts for every request whether it got a new or a reused connection, to tell whether pooling works
+type connectionCountingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *connectionCountingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	host := request.URL.Host
+
+	// the hook is added to the ones already on the request, like the tracing of the connection setup
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				httpConnections.Add(host+" reused", 1)
+			} else {
+				httpConnections.Add(host+" new", 1)
+			}
+		},
+	}
+
+	return t.next.RoundTrip(request.WithContext(httptrace.WithClientTrace(request.Context(), trace)))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionCountingTransport(t *testing.T) {
+	t.Run("CountsNewAndReusedConnectionsPerHost", func(t *testing.T) {
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+		serverURL, _ := url.Parse(server.URL)
+		client := &http.Client{Transport: &connectionCountingTransport{next: newPooledTransport(2)}}
+
+		// act
+		for i := 0; i < 3; i++ {
+			response, err := client.Get(server.URL)
+			if assert.Nil(t, err) {
+				_, _ = ioutil.ReadAll(response.Body)
+				response.Body.Close()
+			}
+		}
+
+		assert.Equal(t, "1", httpConnections.Get(serverURL.Host+" new").String())
+		assert.Equal(t, "2", httpConnections.Get(serverURL.Host+" reused").String())
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// exitCodeShutdown is the exit code of a sync stopped by SIGTERM or SIGINT before it applied all actions, so schedulers can tell a partially applied sync apart from a failed one
+const exitCodeShutdown = 4
+
+// ErrShutdown is set on actions that weren't applied because the syncer was asked to shut down, and returned for the sync they belong to
+var ErrShutdown = errors.New("shutdown requested")
+
+// shutdownSignal is closed on SIGTERM or SIGINT, after which no new mutations are started; the requests in flight get the grace period to finish before they're canceled
+type shutdownSignal struct {
+	gracePeriod time.Duration
+	requested   chan struct{}
+	expired     chan struct{}
+	once        sync.Once
+}
+
+// newShutdownSignal returns a shutdownSignal giving in-flight requests the grace period to finish once shutdown is requested
+func newShutdownSignal(gracePeriod time.Duration) *shutdownSignal {
+	return &shutdownSignal{
+		gracePeriod: gracePeriod,
+		requested:   make(chan struct{}),
+		expired:     make(chan struct{}),
+	}
+}
+
+// notify requests shutdown on the first SIGTERM or SIGINT, for instance when kubernetes evicts the pod, and exits right away on the second
+func (s *shutdownSignal) notify() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		sig := <-signals
+		log.Warn().Msgf("Received %v, finishing the requests in flight within --shutdown-grace-period of %v and stopping", sig, s.gracePeriod)
+		s.request()
+
+		sig = <-signals
+		log.Error().Msgf("Received %v again, exiting without finishing the requests in flight", sig)
+		os.Exit(exitCodeShutdown)
+	}()
+}
+
+// request stops new mutations from being started and cancels the ones in flight after the grace period
+func (s *shutdownSignal) request() {
+	s.once.Do(func() {
+		close(s.requested)
+		time.AfterFunc(s.gracePeriod, func() { close(s.expired) })
+	})
+}
+
+// isRequested checks whether shutdown was requested; a nil shutdownSignal never is
+func (s *shutdownSignal) isRequested() bool {
+	if s == nil {
+		return false
+	}
+
+	select {
+	case <-s.requested:
+		return true
+	default:
+		return false
+	}
+}
+
+// done returns a channel that's closed once shutdown is requested; for a nil shutdownSignal it's nil, so it never fires
+func (s *shutdownSignal) done() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+
+	return s.requested
+}
+
+// bound returns a context that's canceled once the grace period after a shutdown request expired, so requests still in flight by then are aborted
+func (s *shutdownSignal) bound(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if s == nil {
+		return ctx, cancel
+	}
+
+	go func() {
+		select {
+		case <-s.expired:
+			logFromContext(ctx).Warn().Msgf("Canceling the requests still in flight after --shutdown-grace-period of %v", s.gracePeriod)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// shutdownError returns an error wrapping ErrShutdown with the number of applied actions if the syncer was asked to shut down before all actions were applied, or err otherwise
+func shutdownError(ctx context.Context, actions []*Action, err error) error {
+	if err == nil || !shutdownSignalFromContext(ctx).isRequested() {
+		return err
+	}
+
+	return fmt.Errorf("%w, applied %v of %v actions before stopping: %v", ErrShutdown, countAppliedActions(actions), len(actions), err)
+}
+
+// handleShutdown exits with exitCodeShutdown for an error caused by a shutdown request, after flushing the spans
+func handleShutdown(jaegerCloser io.Closer, err error, message string) {
+	if errors.Is(err, ErrShutdown) {
+		jaegerCloser.Close()
+		log.Error().Err(err).Msg(message)
+		os.Exit(exitCodeShutdown)
+	}
+}
+
+type shutdownSignalContextKey struct{}
+
+// contextWithShutdownSignal returns the context with the shutdown signal checked before every mutation
+func contextWithShutdownSignal(ctx context.Context, s *shutdownSignal) context.Context {
+	return context.WithValue(ctx, shutdownSignalContextKey{}, s)
+}
+
+// shutdownSignalFromContext returns the shutdown signal set with contextWithShutdownSignal, or nil if there's none
+func shutdownSignalFromContext(ctx context.Context) *shutdownSignal {
+	s, _ := ctx.Value(shutdownSignalContextKey{}).(*shutdownSignal)
+	return s
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownSignal(t *testing.T) {
+	t.Run("IsNeverRequestedIfNil", func(t *testing.T) {
+
+		var s *shutdownSignal
+
+		// act
+		requested := s.isRequested()
+
+		assert.False(t, requested)
+		assert.Nil(t, s.done())
+	})
+
+	t.Run("CancelsBoundContextOnceGracePeriodExpired", func(t *testing.T) {
+
+		s := newShutdownSignal(20 * time.Millisecond)
+		ctx, cancel := s.bound(context.Background())
+		defer cancel()
+
+		// act
+		s.request()
+
+		assert.True(t, s.isRequested())
+		assert.Nil(t, ctx.Err())
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatal("bound context wasn't canceled after the grace period")
+		}
+	})
+}
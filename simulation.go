@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// Simulation is the estafette access a proposed directory membership change would grant, so approvers of a workspace group request can see what they're approving
+type Simulation struct {
+	User  string
+	Group string
+	// AlreadyMember is set if the user is a member of the directory group already, in which case nothing changes
+	AlreadyMember bool
+	// Groups are the estafette groups the user would be a member of after the change
+	Groups []*SimulatedGroup
+	// GainedRoles and GainedOrganizations are the roles and organizations the user would get that they don't have yet, directly or through their current groups
+	GainedRoles         []string
+	GainedOrganizations []string
+}
+
+// SimulatedGroup is an estafette group the user would be a member of, with the roles and organizations the sync gives it
+type SimulatedGroup struct {
+	Name          string
+	Roles         []string
+	Organizations []string
+	// New is set if the user isn't a member of the group yet
+	New bool
+	// Created is set if the group doesn't exist in estafette yet and would be created by the next sync
+	Created bool
+}
+
+// simulateMembership returns what adding the user to the directory group would change in estafette, by comparing the estafette groups the user gets from the directory as it is and with the member added, including the aggregate groups
+func simulateMembership(groups []*contracts.Group, users []*contracts.User, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember, directoryUsers []*DirectoryUser, options planOptions, userEmail, groupEmail string) (*Simulation, error) {
+	var target *DirectoryGroup
+	for gg := range groupMembers {
+		if strings.EqualFold(gg.Email, groupEmail) || strings.EqualFold(gg.Name, groupEmail) {
+			target = gg
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("Directory group %v isn't synced, check its name and the group prefixes", groupEmail)
+	}
+
+	member := simulatedMember(groupMembers, directoryUsers, userEmail)
+	simulation := &Simulation{User: userEmail, Group: groupEmail}
+
+	after := make(map[*DirectoryGroup][]*DirectoryMember, len(groupMembers))
+	for gg, members := range groupMembers {
+		after[gg] = members
+		if gg != target {
+			continue
+		}
+		for _, m := range members {
+			if sameMember(m, member) {
+				simulation.AlreadyMember = true
+			}
+		}
+		if !simulation.AlreadyMember {
+			after[gg] = append(append([]*DirectoryMember{}, members...), member)
+		}
+	}
+
+	groupsBefore := simulatedGroups(groups, provider, options.syncedGroupMembers(groupMembers, directoryUsers), options, member)
+	groupsAfter := simulatedGroups(groups, provider, options.syncedGroupMembers(after, directoryUsers), options, member)
+
+	var roles, organizations []string
+	if u := estafetteUserForMember(users, provider, member); u != nil {
+		roles = append(roles, userRoles(u)...)
+	}
+	before := map[string]bool{}
+	for _, g := range groupsBefore {
+		before[g.Name] = true
+		roles = appendMissingStrings(roles, g.Roles)
+		organizations = appendMissingStrings(organizations, g.Organizations)
+	}
+
+	for _, g := range groupsAfter {
+		g.New = !before[g.Name]
+		for _, r := range g.Roles {
+			if !containsString(roles, r) && !containsString(simulation.GainedRoles, r) {
+				simulation.GainedRoles = append(simulation.GainedRoles, r)
+			}
+		}
+		for _, o := range g.Organizations {
+			if !containsString(organizations, o) && !containsString(simulation.GainedOrganizations, o) {
+				simulation.GainedOrganizations = append(simulation.GainedOrganizations, o)
+			}
+		}
+	}
+	simulation.Groups = groupsAfter
+	sort.Strings(simulation.GainedRoles)
+	sort.Strings(simulation.GainedOrganizations)
+
+	return simulation, nil
+}
+
+// simulatedMember returns the directory member for the user, with its id if the user is known as a directory user or as a member of any group, since members are matched by id for providers with a user identity provider
+func simulatedMember(groupMembers map[*DirectoryGroup][]*DirectoryMember, directoryUsers []*DirectoryUser, email string) *DirectoryMember {
+	for _, du := range directoryUsers {
+		if strings.EqualFold(du.Email, email) {
+			return &DirectoryMember{ID: du.ID, Email: du.Email}
+		}
+	}
+	for _, members := range groupMembers {
+		for _, m := range members {
+			if strings.EqualFold(m.Email, email) {
+				return &DirectoryMember{ID: m.ID, Email: m.Email}
+			}
+		}
+	}
+
+	return &DirectoryMember{Email: email}
+}
+
+// sameMember checks whether the directory members are the same user, by id if both have one and by email otherwise
+func sameMember(a, b *DirectoryMember) bool {
+	if a.ID != "" && b.ID != "" {
+		return a.ID == b.ID
+	}
+
+	return strings.EqualFold(a.Email, b.Email)
+}
+
+// simulatedGroups returns the estafette groups of the directory groups the member is in, with the roles and organizations the sync gives them: the ones annotated on the directory group or set for its group prefix, or else the ones of the existing estafette group
+func simulatedGroups(groups []*contracts.Group, provider Provider, groupMembers map[*DirectoryGroup][]*DirectoryMember, options planOptions, member *DirectoryMember) []*SimulatedGroup {
+	simulated := make([]*SimulatedGroup, 0)
+	for gg, members := range groupMembers {
+		isMember := false
+		for _, m := range members {
+			isMember = isMember || sameMember(m, member)
+		}
+		if !isMember {
+			continue
+		}
+
+		group := &SimulatedGroup{Name: options.groupName(gg), Created: true}
+		for _, g := range groups {
+			if hasIdentity(g, provider.Name(), gg.ID) {
+				group.Name = g.Name
+				group.Roles = groupRoles(g)
+				group.Organizations = groupOrganizations(g)
+				group.Created = false
+			}
+		}
+		if annotations := options.groupAnnotations(gg); annotations != nil {
+			if annotations.Roles != nil {
+				group.Roles = annotations.Roles
+			}
+			if annotations.Organizations != nil {
+				group.Organizations = annotations.Organizations
+			}
+		}
+		simulated = append(simulated, group)
+	}
+	sort.Slice(simulated, func(i, j int) bool {
+		return simulated[i].Name < simulated[j].Name
+	})
+
+	return simulated
+}
+
+// estafetteUserForMember returns the estafette user matching the directory member, or nil if the user never logged in to estafette
+func estafetteUserForMember(users []*contracts.User, provider Provider, member *DirectoryMember) *contracts.User {
+	for _, u := range users {
+		if userMatchesMember(u, provider, member) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// userRoles returns the roles of the estafette user
+func userRoles(user *contracts.User) []string {
+	roles := make([]string, 0, len(user.Roles))
+	for _, r := range user.Roles {
+		if r != nil {
+			roles = append(roles, *r)
+		}
+	}
+
+	return roles
+}
+
+// writeSimulation writes the simulation as text
+func writeSimulation(w io.Writer, s *Simulation) error {
+	var b strings.Builder
+	if s.AlreadyMember {
+		fmt.Fprintf(&b, "%v is a member of %v already, nothing changes\n", s.User, s.Group)
+	} else {
+		fmt.Fprintf(&b, "adding %v to %v would make them a member of these estafette groups:\n", s.User, s.Group)
+	}
+	for _, g := range s.Groups {
+		marker := " "
+		if g.New {
+			marker = "+"
+		}
+		note := ""
+		if g.Created {
+			note = " (created by the next sync)"
+		}
+		fmt.Fprintf(&b, "%v %v%v, roles: %v, organizations: %v\n", marker, g.Name, note, describeList(g.Roles), describeList(g.Organizations))
+	}
+	fmt.Fprintf(&b, "gained roles: %v\n", describeList(s.GainedRoles))
+	fmt.Fprintf(&b, "gained organizations: %v\n", describeList(s.GainedOrganizations))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// describeList returns the values separated by commas, or none if there are none
+func describeList(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulateMembership(t *testing.T) {
+	administrator := "administrator"
+	operator := "operator"
+	groups := []*contracts.Group{
+		{ID: "g1", Name: "platform", Roles: []*string{&administrator, &operator}, Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-platform"}}},
+		{ID: "g2", Name: "release", Roles: []*string{&operator}, Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0def", Name: "ci-release"}}},
+	}
+	users := []*contracts.User{
+		{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: "google", ID: "101", Email: "jane@example.com"}}},
+	}
+	options := planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}}
+
+	t.Run("ReturnsRolesGainedThroughTheGroup", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-platform", Email: "ci-platform@example.com"}: {{ID: "102", Email: "ted@example.com"}},
+			{ID: "0def", Name: "ci-release", Email: "ci-release@example.com"}:   {{ID: "101", Email: "jane@example.com"}},
+		}
+
+		// act
+		simulation, err := simulateMembership(groups, users, &gsuiteClient{}, groupMembers, nil, options, "jane@example.com", "ci-platform@example.com")
+
+		assert.Nil(t, err)
+		assert.False(t, simulation.AlreadyMember)
+		assert.Equal(t, []string{"administrator"}, simulation.GainedRoles)
+		if assert.Equal(t, 2, len(simulation.Groups)) {
+			assert.Equal(t, "platform", simulation.Groups[0].Name)
+			assert.True(t, simulation.Groups[0].New)
+			assert.Equal(t, "release", simulation.Groups[1].Name)
+			assert.False(t, simulation.Groups[1].New)
+		}
+	})
+
+	t.Run("UsesRolesOfGroupPrefixForGroupThatIsCreated", func(t *testing.T) {
+
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0ghi", Name: "ci-security", Email: "ci-security@example.com"}: {{ID: "102", Email: "ted@example.com"}},
+		}
+		options := planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-", roles: []string{"security-admin"}}}}
+
+		// act
+		simulation, err := simulateMembership(groups, users, &gsuiteClient{}, groupMembers, nil, options, "jane@example.com", "ci-security@example.com")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"security-admin"}, simulation.GainedRoles)
+		if assert.Equal(t, 1, len(simulation.Groups)) {
+			assert.Equal(t, "security", simulation.Groups[0].Name)
+			assert.True(t, simulation.Groups[0].Created)
+		}
+	})
+
+	t.Run("ReturnsErrorForGroupThatIsNotSynced", func(t *testing.T) {
+
+		// act
+		_, err := simulateMembership(groups, users, &gsuiteClient{}, map[*DirectoryGroup][]*DirectoryMember{}, nil, options, "jane@example.com", "marketing@example.com")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestWriteSimulation(t *testing.T) {
+	t.Run("MarksNewGroups", func(t *testing.T) {
+
+		simulation := &Simulation{User: "jane@example.com", Group: "ci-platform@example.com", Groups: []*SimulatedGroup{{Name: "platform", Roles: []string{"administrator"}, New: true}}, GainedRoles: []string{"administrator"}}
+		var b bytes.Buffer
+
+		// act
+		err := writeSimulation(&b, simulation)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "adding jane@example.com to ci-platform@example.com would make them a member of these estafette groups:\n+ platform, roles: administrator, organizations: none\ngained roles: administrator\ngained organizations: none\n", b.String())
+	})
+}
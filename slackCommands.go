@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxSlackRequestAge is how old a slash command request may be, so captured requests can't be replayed later
+const maxSlackRequestAge = 5 * time.Minute
+
+// maxSlackDriftActions limits the actions listed in a diff reply, so it stays readable in a slack channel
+const maxSlackDriftActions = 20
+
+// slackCommandHandler handles the /gsuite-sync status, diff and run slash commands in daemon mode, so on-call engineers can inspect drift and trigger a sync from slack; requests are verified with the slack signing secret
+type slackCommandHandler struct {
+	signingSecret string
+	now           func() time.Time
+	// lastRun returns the last sync run, or nil if there's none yet
+	lastRun func() *SyncRun
+	// computeDrift plans the actions a sync would apply now, without applying them
+	computeDrift func(ctx context.Context) ([]*Action, error)
+	// triggerSync starts a sync right away and returns false if one is already pending
+	triggerSync func() bool
+	// isPaused checks whether syncing is paused through the admin api
+	isPaused func() bool
+	client   *http.Client
+}
+
+// slackMessage is a reply to a slash command, only visible to the user who sent it
+type slackMessage struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// newSlackCommandHandler returns a slackCommandHandler, or nil if the signing secret is empty so slash commands aren't served
+func newSlackCommandHandler(signingSecret string, lastRun func() *SyncRun, computeDrift func(ctx context.Context) ([]*Action, error), triggerSync func() bool, isPaused func() bool) *slackCommandHandler {
+	if signingSecret == "" {
+		return nil
+	}
+
+	return &slackCommandHandler{
+		signingSecret: signingSecret,
+		now:           time.Now,
+		lastRun:       lastRun,
+		computeDrift:  computeDrift,
+		triggerSync:   triggerSync,
+		isPaused:      isPaused,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// register adds the slash command endpoint to the mux; it's a no-op for a nil slackCommandHandler
+func (h *slackCommandHandler) register(mux *http.ServeMux) {
+	if h == nil {
+		return
+	}
+
+	mux.HandleFunc("/slack/commands", h.handleCommand)
+}
+
+func (h *slackCommandHandler) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "unreadable body", http.StatusBadRequest)
+		return
+	}
+	if err = h.verifySignature(r.Header, body); err != nil {
+		log.Warn().Err(err).Msg("Rejected slack command")
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	subcommand := strings.TrimSpace(form.Get("text"))
+	log.Info().Msgf("Received slack command %v %v from %v", form.Get("command"), subcommand, form.Get("user_name"))
+
+	var text string
+	switch subcommand {
+	case "status":
+		text = h.status()
+	case "diff":
+		// slack gives up on replies after 3 seconds, so the drift is posted to the response url once it's computed
+		go h.postDrift(form.Get("response_url"))
+		text = "Computing the drift between the directory and estafette..."
+	case "run":
+		text = h.run()
+	default:
+		text = "Usage: /gsuite-sync status|diff|run"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(&slackMessage{ResponseType: "ephemeral", Text: text})
+}
+
+// verifySignature checks the X-Slack-Signature header, an hmac of the timestamp and body with the signing secret, and rejects requests older than maxSlackRequestAge
+func (h *slackCommandHandler) verifySignature(header http.Header, body []byte) error {
+	timestamp, err := strconv.ParseInt(header.Get("X-Slack-Request-Timestamp"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid slack request timestamp: %w", err)
+	}
+	if age := h.now().Sub(time.Unix(timestamp, 0)); age > maxSlackRequestAge || age < -maxSlackRequestAge {
+		return fmt.Errorf("Slack request timestamp is %v off", age)
+	}
+
+	expected := slackSignature(h.signingSecret, header.Get("X-Slack-Request-Timestamp"), body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("Slack request signature doesn't match")
+	}
+
+	return nil
+}
+
+// slackSignature returns the signature slack sends for the timestamp and body
+func slackSignature(signingSecret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// status describes the last sync and whether syncing is paused
+func (h *slackCommandHandler) status() string {
+	text := "No sync has run yet"
+	if run := h.lastRun(); run != nil {
+		report := newReport(run)
+		failed := 0
+		for _, a := range report.Actions {
+			if a.Error != "" {
+				failed++
+			}
+		}
+		result := "succeeded"
+		if !report.Succeeded {
+			result = "failed: " + report.Error
+		}
+		text = fmt.Sprintf("Last sync %v at %v %v, applying %v actions of which %v failed for %v %v groups", report.RunID, report.FinishedAt.Format(time.RFC3339), result, len(report.Actions), failed, report.DirectoryGroups, report.Provider)
+	}
+	if h.isPaused() {
+		text += "\nSyncing is paused"
+	}
+
+	return text
+}
+
+// run triggers a sync unless syncing is paused
+func (h *slackCommandHandler) run() string {
+	if h.isPaused() {
+		return "Syncing is paused, resume it through the admin api first"
+	}
+	if !h.triggerSync() {
+		return "A sync is already pending"
+	}
+
+	return "Triggered a sync"
+}
+
+// postDrift computes the drift and posts it to the response url of the slash command
+func (h *slackCommandHandler) postDrift(responseURL string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var text string
+	actions, err := h.computeDrift(ctx)
+	switch {
+	case err != nil:
+		text = fmt.Sprintf("Failed computing the drift: %v", err)
+	case len(actions) == 0:
+		text = "No drift, estafette is in sync"
+	default:
+		lines := []string{fmt.Sprintf("A sync would apply %v actions:", len(actions))}
+		for i, a := range actions {
+			if i == maxSlackDriftActions {
+				lines = append(lines, fmt.Sprintf("...and %v more", len(actions)-maxSlackDriftActions))
+				break
+			}
+			lines = append(lines, "• "+a.String())
+		}
+		text = strings.Join(lines, "\n")
+	}
+
+	data, _ := json.Marshal(&slackMessage{ResponseType: "ephemeral", Text: text})
+	response, err := h.client.Post(responseURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed posting drift to slack")
+		return
+	}
+	response.Body.Close()
+}
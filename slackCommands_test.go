@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlackCommandHandler(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	newHandler := func(drift []*Action, triggerSync func() bool) *slackCommandHandler {
+		handler := newSlackCommandHandler("signing-secret", func() *SyncRun { return nil }, func(ctx context.Context) ([]*Action, error) { return drift, nil }, triggerSync, func() bool { return false })
+		handler.now = func() time.Time { return now }
+		return handler
+	}
+	command := func(handler *slackCommandHandler, form url.Values, timestamp time.Time, signingSecret string) *httptest.ResponseRecorder {
+		body := form.Encode()
+		r := httptest.NewRequest("POST", "/slack/commands", strings.NewReader(body))
+		r.Header.Set("X-Slack-Request-Timestamp", fmt.Sprint(timestamp.Unix()))
+		r.Header.Set("X-Slack-Signature", slackSignature(signingSecret, fmt.Sprint(timestamp.Unix()), []byte(body)))
+		mux := http.NewServeMux()
+		handler.register(mux)
+		recorder := httptest.NewRecorder()
+		mux.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	t.Run("RejectsRequestsWithInvalidSignature", func(t *testing.T) {
+
+		triggered := false
+		handler := newHandler(nil, func() bool { triggered = true; return true })
+
+		// act
+		recorder := command(handler, url.Values{"command": {"/gsuite-sync"}, "text": {"run"}}, now, "wrong-secret")
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.False(t, triggered)
+	})
+
+	t.Run("RejectsReplayedRequests", func(t *testing.T) {
+
+		handler := newHandler(nil, func() bool { return true })
+
+		// act
+		recorder := command(handler, url.Values{"command": {"/gsuite-sync"}, "text": {"status"}}, now.Add(-10*time.Minute), "signing-secret")
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	})
+
+	t.Run("TriggersSync", func(t *testing.T) {
+
+		triggered := false
+		handler := newHandler(nil, func() bool { triggered = true; return true })
+
+		// act
+		recorder := command(handler, url.Values{"command": {"/gsuite-sync"}, "text": {"run"}}, now, "signing-secret")
+
+		var message slackMessage
+		err := json.Unmarshal(recorder.Body.Bytes(), &message)
+		assert.Nil(t, err)
+		assert.True(t, triggered)
+		assert.Equal(t, "Triggered a sync", message.Text)
+	})
+
+	t.Run("PostsDriftToResponseURL", func(t *testing.T) {
+
+		posted := make(chan string, 1)
+		responseServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			posted <- string(body)
+		}))
+		defer responseServer.Close()
+		handler := newHandler([]*Action{{Type: ActionCreateGroup, Group: &contracts.Group{Name: "platform"}}}, nil)
+
+		// act
+		recorder := command(handler, url.Values{"command": {"/gsuite-sync"}, "text": {"diff"}, "response_url": {responseServer.URL}}, now, "signing-secret")
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		select {
+		case body := <-posted:
+			assert.Contains(t, body, "create group platform")
+		case <-time.After(5 * time.Second):
+			t.Fatal("drift wasn't posted to the response url")
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// stale identity cleanup policies
+const (
+	// staleIdentityPolicyRemove removes the stale identities, keeping the groups and users
+	staleIdentityPolicyRemove = "remove-identity"
+	// staleIdentityPolicyDeactivate deactivates users and deletes groups without any identity of the provider left, since estafette groups can't be deactivated; the stale identities of groups with other identities are removed
+	staleIdentityPolicyDeactivate = "deactivate"
+)
+
+// StaleIdentity is an identity of an estafette group or user referencing a directory group or user that doesn't exist anymore
+type StaleIdentity struct {
+	// Group or User is the estafette entity carrying the identity
+	Group *contracts.Group
+	User  *contracts.User
+	// ID is the id of the deleted directory group or user
+	ID string
+}
+
+// findStaleIdentities returns the identities of the provider on estafette groups and users whose directory group or user doesn't exist anymore; the synced directory groups are known to exist, the others are looked up one by one, and protected groups are left alone
+func findStaleIdentities(ctx context.Context, groups []*contracts.Group, users []*contracts.User, provider IdentityLookupProvider, groupMembers map[*DirectoryGroup][]*DirectoryMember, options planOptions) (stale []*StaleIdentity, err error) {
+	stale = make([]*StaleIdentity, 0)
+
+	groupExists := map[string]bool{}
+	for gg := range groupMembers {
+		groupExists[gg.ID] = true
+	}
+	for _, g := range groups {
+		if options.isProtected(g) {
+			continue
+		}
+		for _, i := range g.Identities {
+			if i.Provider != provider.Name() {
+				continue
+			}
+			exists, known := groupExists[i.ID]
+			if !known {
+				exists, err = provider.DirectoryGroupExists(ctx, i.ID)
+				if err != nil {
+					return stale, err
+				}
+				groupExists[i.ID] = exists
+			}
+			if !exists {
+				stale = append(stale, &StaleIdentity{Group: g, ID: i.ID})
+			}
+		}
+	}
+
+	// members are matched by email if the provider has no user identity provider, so there are no ids to look up
+	if provider.UserIdentityProvider() == "" {
+		return stale, nil
+	}
+
+	userExists := map[string]bool{}
+	for _, u := range users {
+		for _, i := range u.Identities {
+			if i.Provider != provider.UserIdentityProvider() || i.ID == "" {
+				continue
+			}
+			exists, known := userExists[i.ID]
+			if !known {
+				exists, err = provider.DirectoryUserExists(ctx, i.ID)
+				if err != nil {
+					return stale, err
+				}
+				userExists[i.ID] = exists
+			}
+			if !exists {
+				stale = append(stale, &StaleIdentity{User: u, ID: i.ID})
+			}
+		}
+	}
+
+	return stale, nil
+}
+
+// planStaleIdentityCleanup returns the actions cleaning up the stale identities according to the policy; removing a stale group identity removes the settings, domain and other identities derived from it as well
+func planStaleIdentityCleanup(stale []*StaleIdentity, provider Provider, policy string) (actions []*Action) {
+	actions = make([]*Action, 0)
+
+	updatedGroups := map[*contracts.Group]*contracts.Group{}
+	updatedUsers := map[*contracts.User]*contracts.User{}
+	groupOrder := make([]*contracts.Group, 0)
+	userOrder := make([]*contracts.User, 0)
+
+	for _, s := range stale {
+		switch {
+		case s.Group != nil:
+			updated, ok := updatedGroups[s.Group]
+			if !ok {
+				updated = copyGroup(s.Group)
+				updatedGroups[s.Group] = updated
+				groupOrder = append(groupOrder, s.Group)
+			}
+			removeDerivedGroupIdentities(updated, provider.Name(), s.ID)
+
+		case s.User != nil:
+			updated, ok := updatedUsers[s.User]
+			if !ok {
+				updated = copyUser(s.User)
+				updatedUsers[s.User] = updated
+				userOrder = append(userOrder, s.User)
+			}
+			if policy == staleIdentityPolicyDeactivate {
+				updated.Active = false
+			} else {
+				removeUserIdentity(updated, provider.UserIdentityProvider(), s.ID)
+			}
+		}
+	}
+
+	for _, g := range groupOrder {
+		updated := updatedGroups[g]
+		if policy == staleIdentityPolicyDeactivate && !hasProviderIdentity(updated, provider.Name()) {
+			actions = append(actions, &Action{Type: ActionDeleteGroup, GroupBefore: g, Group: g})
+			continue
+		}
+		actions = append(actions, &Action{Type: ActionUpdateGroup, GroupBefore: g, Group: updated})
+	}
+	for _, u := range userOrder {
+		if u.Active == updatedUsers[u].Active && len(u.Identities) == len(updatedUsers[u].Identities) {
+			continue
+		}
+		actions = append(actions, &Action{Type: ActionUpdateUser, UserBefore: u, User: updatedUsers[u]})
+	}
+
+	return actions
+}
+
+// removeDerivedGroupIdentities removes the identity of the provider with the id from the group, and the identities derived from it like provider-settings, and returns whether it had any
+func removeDerivedGroupIdentities(group *contracts.Group, provider, id string) (changed bool) {
+	identities := make([]*contracts.GroupIdentity, 0, len(group.Identities))
+	for _, i := range group.Identities {
+		if i.ID == id && (i.Provider == provider || strings.HasPrefix(i.Provider, provider+"-")) {
+			changed = true
+			continue
+		}
+		identities = append(identities, i)
+	}
+	if changed {
+		group.Identities = identities
+	}
+
+	return
+}
+
+// removeUserIdentity removes the identity with the provider and id from the user and returns whether it had one
+func removeUserIdentity(user *contracts.User, provider, id string) (changed bool) {
+	identities := make([]*contracts.UserIdentity, 0, len(user.Identities))
+	for _, i := range user.Identities {
+		if i.Provider == provider && i.ID == id {
+			changed = true
+			continue
+		}
+		identities = append(identities, i)
+	}
+	if changed {
+		user.Identities = identities
+	}
+
+	return
+}
+
+// describeStaleIdentities returns a line per stale identity, for the cleanup-identities command to print
+func describeStaleIdentities(stale []*StaleIdentity) []string {
+	lines := make([]string, 0, len(stale))
+	for _, s := range stale {
+		if s.Group != nil {
+			lines = append(lines, fmt.Sprintf("group %v (%v) references deleted directory group %v", s.Group.Name, s.Group.ID, s.ID))
+		} else {
+			lines = append(lines, fmt.Sprintf("user %v (%v) references deleted directory user %v", s.User.GetEmail(), s.User.ID, s.ID))
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindStaleIdentities(t *testing.T) {
+	t.Run("ReturnsIdentitiesOfDeletedGroupsAndUsers", func(t *testing.T) {
+
+		directoryAPI := newFakeDirectoryAPI()
+		defer directoryAPI.Close()
+		directoryAPI.seedGroup("marketing@example.com", "marketing", "")
+		directoryAPI.seedUser("101", "jane@example.com")
+		ctx := context.Background()
+		client, err := NewGsuiteClient(ctx, "example.com", GsuiteClientOptions{GroupPrefixes: []string{"ci-"}, Concurrency: 1, APIEndpoint: directoryAPI.URL})
+		assert.Nil(t, err)
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0abc", Name: "ci-platform"}}},
+			{ID: "g2", Name: "marketing", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "marketing@example.com", Name: "marketing"}}},
+			{ID: "g3", Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0def", Name: "ci-release"}}},
+			{ID: "g4", Name: "admins", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "0ghi", Name: "ci-admins"}}},
+		}
+		users := []*contracts.User{
+			{ID: "u1", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "101", Email: "jane@example.com"}}},
+			{ID: "u2", Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "102", Email: "ted@example.com"}}},
+		}
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{
+			{ID: "0abc", Name: "ci-platform"}: {},
+		}
+		options := planOptions{protectedGroups: []string{"admins"}}
+
+		// act
+		stale, err := findStaleIdentities(ctx, groups, users, client.(IdentityLookupProvider), groupMembers, options)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(stale)) {
+			assert.Equal(t, &StaleIdentity{Group: groups[2], ID: "0def"}, stale[0])
+			assert.Equal(t, &StaleIdentity{User: users[1], ID: "102"}, stale[1])
+		}
+	})
+}
+
+func TestPlanStaleIdentityCleanup(t *testing.T) {
+	group := &contracts.Group{ID: "g1", Name: "release", Identities: []*contracts.GroupIdentity{
+		{Provider: gsuiteProviderName, ID: "0def", Name: "ci-release"},
+		{Provider: gsuiteProviderName + groupDomainProviderSuffix, ID: "0def", Name: "example.com"},
+	}}
+	user := &contracts.User{ID: "u1", Active: true, Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "102", Email: "ted@example.com"}}}
+	stale := []*StaleIdentity{{Group: group, ID: "0def"}, {User: user, ID: "102"}}
+
+	t.Run("RemovesStaleIdentitiesAndTheIdentitiesDerivedFromThem", func(t *testing.T) {
+
+		// act
+		actions := planStaleIdentityCleanup(stale, &gsuiteClient{}, staleIdentityPolicyRemove)
+
+		if assert.Equal(t, 2, len(actions)) {
+			assert.Equal(t, ActionUpdateGroup, actions[0].Type)
+			assert.Equal(t, 0, len(actions[0].Group.Identities))
+			assert.Equal(t, ActionUpdateUser, actions[1].Type)
+			assert.Equal(t, 0, len(actions[1].User.Identities))
+			assert.True(t, actions[1].User.Active)
+		}
+		assert.Equal(t, 2, len(group.Identities))
+	})
+
+	t.Run("DeactivatesUsersAndDeletesGroupsWithoutIdentity", func(t *testing.T) {
+
+		// act
+		actions := planStaleIdentityCleanup(stale, &gsuiteClient{}, staleIdentityPolicyDeactivate)
+
+		if assert.Equal(t, 2, len(actions)) {
+			assert.Equal(t, ActionDeleteGroup, actions[0].Type)
+			assert.Equal(t, "g1", actions[0].Group.ID)
+			assert.Equal(t, ActionUpdateUser, actions[1].Type)
+			assert.False(t, actions[1].User.Active)
+			assert.Equal(t, "update user ted@example.com, deactivate", actions[1].String())
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"google.golang.org/api/storage/v1"
+)
+
+// state store types selectable with --state-store
+const (
+	stateStoreFile      = "file"
+	stateStoreConfigMap = "configmap"
+	stateStoreSecret    = "secret"
+	stateStoreGCS       = "gcs"
+	stateStoreRedis     = "redis"
+)
+
+// ErrStateNotFound is returned by a StateStore for keys nothing was written to yet
+var ErrStateNotFound = errors.New("state not found")
+
+// stateStore keeps the last-applied snapshot, work queue, stats history, directory snapshot and next membership expiry between runs; like the gsuiteQuota it's set up once in main, and it's nil in tests, in which case the state is kept in local files
+var stateStore StateStore
+
+// StateStore persists the state the syncer carries from one run to the next by key, so checkpointing, anomaly detection and change events keep working where the local disk doesn't survive a restart of the pod
+type StateStore interface {
+	// Read returns the data stored under the key, or ErrStateNotFound if nothing was written to it yet
+	Read(ctx context.Context, key string) (data []byte, err error)
+	// Write replaces the data stored under the key, in a way an interrupted write doesn't lose the previous data
+	Write(ctx context.Context, key string, data []byte) error
+}
+
+// newStateStore returns the StateStore selected with --state-store
+func newStateStore(ctx context.Context) (StateStore, error) {
+	if *stateStoreType != stateStoreFile {
+		if err := validateStateKeys(stateFiles()); err != nil {
+			return nil, err
+		}
+	}
+
+	switch *stateStoreType {
+	case stateStoreConfigMap, stateStoreSecret:
+		client, err := newInClusterKubernetesClient()
+		if err != nil {
+			return nil, err
+		}
+		return NewKubernetesStateStore(client, *stateStoreType, *stateStoreName), nil
+
+	case stateStoreGCS:
+		if !strings.HasPrefix(*stateStoreGCSLocation, "gs://") {
+			return nil, fmt.Errorf("Flag --state-store-gcs-location has to be a gs://bucket/path location with --state-store=gcs")
+		}
+		bucketAndPrefix := strings.SplitN(strings.TrimPrefix(*stateStoreGCSLocation, "gs://"), "/", 2)
+		prefix := ""
+		if len(bucketAndPrefix) > 1 {
+			prefix = bucketAndPrefix[1]
+		}
+		storageService, err := storage.NewService(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Failed creating gcs client: %w", err)
+		}
+		return NewGCSStateStore(storageService, bucketAndPrefix[0], prefix), nil
+
+	case stateStoreRedis:
+		if *stateStoreRedisAddress == "" {
+			return nil, fmt.Errorf("Flag --state-store-redis-address is required with --state-store=redis")
+		}
+		options, err := redisOptions(*stateStoreRedisAddress, *stateStoreRedisPassword)
+		if err != nil {
+			return nil, err
+		}
+		if options.Password != "" && options.TLSConfig == nil {
+			logFromContext(ctx).Warn().Msgf("Authenticating to redis server %v without tls sends the password in cleartext, use a rediss:// url for --state-store-redis-address", options.Addr)
+		}
+		return NewRedisStateStore(redis.NewClient(options), *stateStoreRedisKeyPrefix), nil
+	}
+
+	return NewFileStateStore(), nil
+}
+
+// getStateStore returns the configured stateStore, or a file StateStore if there's none
+func getStateStore() StateStore {
+	if stateStore == nil {
+		return NewFileStateStore()
+	}
+
+	return stateStore
+}
+
+// stateKey returns the key for state kept outside the local file system, which is the file name of the path configured for it
+func stateKey(path string) string {
+	return filepath.Base(path)
+}
+
+// stateFiles returns the paths of the state kept in the state store by the flag configuring them
+func stateFiles() map[string]string {
+	return map[string]string{
+		"last-applied-file":       *lastAppliedFile,
+		"work-queue-file":         *workQueueFile,
+		"stats-history-file":      *statsHistoryFile,
+		"directory-snapshot-file": *directorySnapshotFile,
+		"membership-expiry-file":  *membershipExpiryFile,
+	}
+}
+
+// validateStateKeys returns an error if the paths configured by two flags have the same file name, since they'd overwrite each other's state under the same key in stores other than the file store
+func validateStateKeys(paths map[string]string) error {
+	flags := make([]string, 0, len(paths))
+	for flag := range paths {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+
+	flagsByKey := map[string]string{}
+	for _, flag := range flags {
+		if paths[flag] == "" {
+			continue
+		}
+		key := stateKey(paths[flag])
+		if other, ok := flagsByKey[key]; ok {
+			return fmt.Errorf("Flags --%v and --%v both keep their state under key %v in the --state-store, give them distinct file names", other, flag, key)
+		}
+		flagsByKey[key] = flag
+	}
+
+	return nil
+}
+
+// NewFileStateStore returns a StateStore keeping every key in the local file with the key as path
+func NewFileStateStore() StateStore {
+	return &fileStateStore{}
+}
+
+type fileStateStore struct{}
+
+func (s *fileStateStore) Read(ctx context.Context, key string) (data []byte, err error) {
+	data, err = ioutil.ReadFile(key)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: file %v doesn't exist", ErrStateNotFound, key)
+	}
+
+	return data, err
+}
+
+// Write writes to a temporary file first and renames it, so an interrupted write doesn't lose the previous file
+func (s *fileStateStore) Write(ctx context.Context, key string, data []byte) error {
+	err := ioutil.WriteFile(key+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(key+".tmp", key)
+}
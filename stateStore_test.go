@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateStateKeys(t *testing.T) {
+	t.Run("ReturnsErrorForPathsWithTheSameFileName", func(t *testing.T) {
+
+		// act
+		err := validateStateKeys(map[string]string{
+			"work-queue-file":    "/data/sync/state.json",
+			"stats-history-file": "/data/stats/state.json",
+			"last-applied-file":  "",
+		})
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "--stats-history-file and --work-queue-file")
+		}
+	})
+
+	t.Run("AcceptsDistinctFileNamesAndEmptyPaths", func(t *testing.T) {
+
+		// act
+		err := validateStateKeys(map[string]string{
+			"work-queue-file":         "/data/work-queue.json",
+			"stats-history-file":      "/data/stats-history.json",
+			"last-applied-file":       "",
+			"directory-snapshot-file": "",
+		})
+
+		assert.Nil(t, err)
+	})
+}
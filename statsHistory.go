@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrStatsAnomaly is returned for a sync that wasn't applied because the directory changed more since the previous run than --anomaly-threshold allows
+var ErrStatsAnomaly = errors.New("sync statistics anomaly")
+
+// RunStats are the counts of a successful sync run kept in the stats history
+type RunStats struct {
+	RunID            string    `json:"runID"`
+	StartedAt        time.Time `json:"startedAt"`
+	DirectoryGroups  int       `json:"directoryGroups"`
+	DirectoryMembers int       `json:"directoryMembers"`
+	Groups           int       `json:"groups"`
+	Users            int       `json:"users"`
+	Actions          int       `json:"actions"`
+}
+
+// StatsHistory holds the stats of the most recent successful sync runs, oldest first
+type StatsHistory struct {
+	Runs []*RunStats `json:"runs"`
+}
+
+// StatsAnomaly is a count that dropped more than --anomaly-threshold since the previous run, which usually means the directory returned a partial view rather than that access was revoked on purpose
+type StatsAnomaly struct {
+	Metric   string  `json:"metric"`
+	Previous int     `json:"previous"`
+	Current  int     `json:"current"`
+	Change   float64 `json:"change"`
+}
+
+func (a *StatsAnomaly) String() string {
+	return fmt.Sprintf("%v dropped %.0f%% from %v to %v since the last run", a.Metric, -a.Change*100, a.Previous, a.Current)
+}
+
+// newRunStats returns the stats of the run
+func newRunStats(run *SyncRun) *RunStats {
+	return &RunStats{
+		RunID:            run.ID,
+		StartedAt:        run.StartedAt,
+		DirectoryGroups:  run.DirectoryGroups,
+		DirectoryMembers: run.DirectoryMembers,
+		Groups:           run.Groups,
+		Users:            run.Users,
+		Actions:          len(run.Actions),
+	}
+}
+
+// readStatsHistory reads the stats history from the state store; it returns nil if path is empty and an empty history if there's none yet
+func readStatsHistory(ctx context.Context, path string) (*StatsHistory, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := getStateStore().Read(ctx, path)
+	if errors.Is(err, ErrStateNotFound) {
+		return &StatsHistory{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading stats history %v: %w", path, err)
+	}
+
+	var history StatsHistory
+	err = json.Unmarshal(data, &history)
+	if err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling stats history %v: %w", path, err)
+	}
+
+	return &history, nil
+}
+
+// writeStatsHistory replaces the stats history in the state store
+func writeStatsHistory(ctx context.Context, path string, history *StatsHistory) error {
+	if path == "" || history == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = getStateStore().Write(ctx, path, data)
+	if err != nil {
+		return fmt.Errorf("Failed writing stats history %v: %w", path, err)
+	}
+
+	return nil
+}
+
+// record appends the stats and drops the oldest runs beyond size
+func (h *StatsHistory) record(stats *RunStats, size int) {
+	h.Runs = append(h.Runs, stats)
+	if size > 0 && len(h.Runs) > size {
+		h.Runs = h.Runs[len(h.Runs)-size:]
+	}
+}
+
+// last returns the stats of the most recent run, or nil if there's none; it's nil-safe
+func (h *StatsHistory) last() *RunStats {
+	if h == nil || len(h.Runs) == 0 {
+		return nil
+	}
+
+	return h.Runs[len(h.Runs)-1]
+}
+
+// detectAnomalies returns the directory counts that dropped by more than the threshold from the previous to the current run; only drops are anomalies, since a partial directory view is what makes a sync remove access it shouldn't
+func detectAnomalies(previous, current *RunStats, threshold float64) []*StatsAnomaly {
+	if previous == nil || threshold <= 0 {
+		return nil
+	}
+
+	anomalies := make([]*StatsAnomaly, 0)
+	for _, m := range []struct {
+		metric            string
+		previous, current int
+	}{
+		{"directory groups", previous.DirectoryGroups, current.DirectoryGroups},
+		{"directory members", previous.DirectoryMembers, current.DirectoryMembers},
+	} {
+		if m.previous == 0 {
+			continue
+		}
+		change := float64(m.current-m.previous) / float64(m.previous)
+		if change <= -threshold {
+			anomalies = append(anomalies, &StatsAnomaly{Metric: m.metric, Previous: m.previous, Current: m.current, Change: change})
+		}
+	}
+
+	return anomalies
+}
+
+// checkStatsAnomalies compares the counts of the run with the last run in the --stats-history-file and returns ErrStatsAnomaly if any dropped beyond --anomaly-threshold, unless --force is set
+func checkStatsAnomalies(ctx context.Context, run *SyncRun) error {
+	history, err := readStatsHistory(ctx, *statsHistoryFile)
+	if err != nil {
+		return err
+	}
+
+	run.Anomalies = detectAnomalies(history.last(), newRunStats(run), *anomalyThreshold)
+	if len(run.Anomalies) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(run.Anomalies))
+	for _, a := range run.Anomalies {
+		descriptions = append(descriptions, a.String())
+	}
+
+	if *syncForce {
+		log.Warn().Msgf("Applying anyway because of --force: %v", strings.Join(descriptions, ", "))
+		return nil
+	}
+
+	return fmt.Errorf("%w exceeding --anomaly-threshold of %.0f%%, use --force to apply anyway: %v", ErrStatsAnomaly, *anomalyThreshold*100, strings.Join(descriptions, ", "))
+}
+
+// recordRunStats appends the stats of a successful run to the --stats-history-file, so the next run is compared with it; failed and blocked runs aren't recorded, so an anomaly keeps blocking until it's resolved or forced
+func recordRunStats(ctx context.Context, run *SyncRun) error {
+	if run.Err != nil {
+		return nil
+	}
+
+	history, err := readStatsHistory(ctx, *statsHistoryFile)
+	if err != nil || history == nil {
+		return err
+	}
+
+	history.record(newRunStats(run), *statsHistorySize)
+
+	return writeStatsHistory(ctx, *statsHistoryFile, history)
+}
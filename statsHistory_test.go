@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectAnomalies(t *testing.T) {
+	t.Run("ReturnsNoAnomaliesWithoutPreviousRun", func(t *testing.T) {
+
+		// act
+		anomalies := detectAnomalies(nil, &RunStats{DirectoryGroups: 10, DirectoryMembers: 100}, 0.4)
+
+		assert.Empty(t, anomalies)
+	})
+
+	t.Run("ReturnsCountsThatDroppedBeyondThreshold", func(t *testing.T) {
+
+		previous := &RunStats{DirectoryGroups: 10, DirectoryMembers: 100}
+		current := &RunStats{DirectoryGroups: 8, DirectoryMembers: 60}
+
+		// act
+		anomalies := detectAnomalies(previous, current, 0.4)
+
+		assert.Equal(t, []*StatsAnomaly{{Metric: "directory members", Previous: 100, Current: 60, Change: -0.4}}, anomalies)
+		assert.Equal(t, "directory members dropped 40% from 100 to 60 since the last run", anomalies[0].String())
+	})
+
+	t.Run("IgnoresGrowingCounts", func(t *testing.T) {
+
+		previous := &RunStats{DirectoryGroups: 10, DirectoryMembers: 100}
+		current := &RunStats{DirectoryGroups: 30, DirectoryMembers: 300}
+
+		// act
+		anomalies := detectAnomalies(previous, current, 0.4)
+
+		assert.Empty(t, anomalies)
+	})
+}
+
+func TestStatsHistoryRecord(t *testing.T) {
+	t.Run("DropsOldestRunsBeyondSize", func(t *testing.T) {
+
+		history := &StatsHistory{}
+
+		// act
+		for _, id := range []string{"run-1", "run-2", "run-3"} {
+			history.record(&RunStats{RunID: id}, 2)
+		}
+
+		assert.Equal(t, []*RunStats{{RunID: "run-2"}, {RunID: "run-3"}}, history.Runs)
+		assert.Equal(t, "run-3", history.last().RunID)
+	})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/opentracing/opentracing-go"
+)
+
+// streamingResult summarizes a streaming synchronization for logging and history
+type streamingResult struct {
+	actions          []*Action
+	directoryGroups  int
+	directoryMembers int
+	policyViolations []*PolicyViolation
+	// nextMembershipExpiry is the earliest expiration of the memberships streamed so far
+	nextMembershipExpiry *time.Time
+}
+
+// streamGroupsAndMembers applies the group changes for every directory group as soon as the provider has resolved its members, and updates the users once all groups are processed; only the user memberships are kept in memory instead of the entire directory
+func streamGroupsAndMembers(ctx context.Context, apiClient ApiClient, token string, groups []*contracts.Group, users []*contracts.User, provider StreamingProvider, directoryUsers []*DirectoryUser, options planOptions, policies []*compiledPolicy, limits *directoryLimits, confirmUserActions func(userActions []*Action) error) (result streamingResult, err error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Streaming::GroupsAndMembers")
+	defer span.Finish()
+
+	result.actions = make([]*Action, 0)
+	result.policyViolations = make([]*PolicyViolation, 0)
+
+	// stop the provider when returning early, so it doesn't block on a channel nobody reads anymore
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	groupsWithMembers := make(chan *DirectoryGroupWithMembers)
+	streamErr := make(chan error, 1)
+	go func() {
+		streamErr <- provider.StreamGroupsWithMembers(ctx, groupsWithMembers)
+	}()
+
+	groupsByIdentityID := indexGroupsByIdentityID(groups, provider)
+	usersByMemberKey := indexUsersByMemberKey(users, provider)
+	userGroups := map[string][]*contracts.Group{}
+
+	directoryUsers = options.memberFilter.filterDirectoryUsers(directoryUsers)
+
+	for gm := range groupsWithMembers {
+		// groups processed earlier are applied already, so exceeding the limits only stops the sync from going any further
+		if limitErr := limits.checkGroups(result.directoryGroups+1, gm.Members); limitErr != nil {
+			return result, limitErr
+		}
+
+		gm.Members = options.memberFilter.filterMembers(activeMembers(gm.Group, gm.Members, provider.Name(), time.Now()))
+		result.nextMembershipExpiry = earliestExpiry(result.nextMembershipExpiry, gm.Members)
+		groupMembers := map[*DirectoryGroup][]*DirectoryMember{gm.Group: gm.Members}
+
+		// groups processed earlier are applied already, so a failing policy only stops the sync from going any further
+		violations := evaluatePolicies(policies, groupMembers)
+		result.policyViolations = append(result.policyViolations, violations...)
+		if policyErr := enforcePolicies(violations); policyErr != nil {
+			return result, policyErr
+		}
+
+		// estafette groups whose identities are of an older version are still indexed by the id they had then
+		linkedGroups := groupsByIdentityID[gm.Group.ID]
+		for _, id := range legacyIdentityIDs(gm.Group) {
+			linkedGroups = append(linkedGroups, groupsByIdentityID[id]...)
+		}
+
+		groupActions, plannedGroups := planGroups(linkedGroups, provider, groupMembers, options)
+
+		if len(groupActions) > 0 {
+			applyErr := apiClient.ApplyActions(ctx, token, groupActions)
+			if applyErr != nil && err == nil {
+				err = applyErr
+			}
+			result.actions = append(result.actions, groupActions...)
+		}
+		recordLastApplied(options.lastApplied, provider, groupMembers, options, groupActions)
+
+		// remember which estafette groups each user should be in; groups created in this run get their members on the next run, like in a regular sync
+		for _, g := range plannedGroups {
+			for _, m := range gm.Members {
+				for _, u := range usersByMemberKey[memberKey(provider, m)] {
+					userGroups[u.ID] = append(userGroups[u.ID], g)
+				}
+			}
+		}
+
+		result.directoryGroups++
+		result.directoryMembers += len(gm.Members)
+
+		logFromContext(ctx).Info().Msgf("Processed %v group %v with %v members and %v actions, %v groups done", provider.Name(), gm.Group.Name, len(gm.Members), len(groupActions), result.directoryGroups)
+	}
+
+	// the provider closes the channel once it's done or failed, so this never blocks
+	if streamingErr := <-streamErr; streamingErr != nil {
+		// don't remove users from groups based on an incomplete directory
+		return result, streamingErr
+	}
+
+	userActions := planUsers(users, provider, options, func(user *contracts.User) []*contracts.Group {
+		return userGroups[user.ID]
+	}, indexDirectoryUsers(users, provider, directoryUsers), indexGroupsByID(groups))
+
+	if len(userActions) > 0 {
+		// membership removals are only known once all groups are processed, so they can only be confirmed here
+		confirmErr := confirmUserActions(userActions)
+		if confirmErr != nil {
+			return result, confirmErr
+		}
+
+		applyErr := apiClient.ApplyActions(ctx, token, userActions)
+		if applyErr != nil && err == nil {
+			err = applyErr
+		}
+		result.actions = append(result.actions, userActions...)
+	}
+
+	span.LogKV("groups", result.directoryGroups, "actions", len(result.actions))
+
+	return
+}
+
+// indexGroupsByIdentityID returns the estafette groups by the ids of their identities for the provider
+func indexGroupsByIdentityID(groups []*contracts.Group, provider Provider) map[string][]*contracts.Group {
+	index := map[string][]*contracts.Group{}
+	for _, g := range groups {
+		for _, i := range g.Identities {
+			if i.Provider == provider.Name() {
+				index[i.ID] = append(index[i.ID], g)
+			}
+		}
+	}
+
+	return index
+}
+
+// indexUsersByMemberKey returns the estafette users by the keys directory members are matched on, see userMatchesMember
+func indexUsersByMemberKey(users []*contracts.User, provider Provider) map[string][]*contracts.User {
+	index := map[string][]*contracts.User{}
+	for _, u := range users {
+		keys := map[string]bool{}
+		for _, ui := range u.Identities {
+			if provider.UserIdentityProvider() != "" {
+				if ui.Provider == provider.UserIdentityProvider() && ui.ID != "" {
+					keys[ui.ID] = true
+				}
+			} else if ui.Email != "" {
+				keys[strings.ToLower(ui.Email)] = true
+			}
+		}
+		for key := range keys {
+			index[key] = append(index[key], u)
+		}
+	}
+
+	return index
+}
+
+// memberKey returns the key to look up the estafette users matching the directory member in the index from indexUsersByMemberKey
+func memberKey(provider Provider, member *DirectoryMember) string {
+	if provider.UserIdentityProvider() != "" {
+		return member.ID
+	}
+
+	return strings.ToLower(member.Email)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStreamingProvider struct {
+	gsuiteClient
+	groupsWithMembers []*DirectoryGroupWithMembers
+}
+
+func (p *fakeStreamingProvider) StreamGroupsWithMembers(ctx context.Context, groupsWithMembers chan<- *DirectoryGroupWithMembers) error {
+	defer close(groupsWithMembers)
+	for _, gm := range p.groupsWithMembers {
+		groupsWithMembers <- gm
+	}
+	return nil
+}
+
+type recordingApiClient struct {
+	ApiClient
+	mutex   sync.Mutex
+	batches [][]*Action
+}
+
+func (c *recordingApiClient) ApplyActions(ctx context.Context, token string, actions []*Action) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.batches = append(c.batches, actions)
+	return nil
+}
+
+func TestStreamGroupsAndMembers(t *testing.T) {
+	t.Run("AppliesGroupActionsPerGroupAndUserActionsAtTheEnd", func(t *testing.T) {
+
+		groups := []*contracts.Group{
+			{ID: "g1", Name: "platform", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-platform@example.com", Name: "ci-platform"}}},
+			{ID: "g2", Name: "release", Identities: []*contracts.GroupIdentity{{Provider: gsuiteProviderName, ID: "ci-release@example.com", Name: "ci-release"}}},
+		}
+		users := []*contracts.User{
+			{
+				ID:         "u1",
+				Identities: []*contracts.UserIdentity{{Provider: googleProviderName, ID: "1234", Email: "john@example.com"}},
+				Groups:     []*contracts.Group{{ID: "g2", Name: "release"}},
+			},
+		}
+		provider := &fakeStreamingProvider{
+			groupsWithMembers: []*DirectoryGroupWithMembers{
+				{Group: &DirectoryGroup{ID: "ci-platform@example.com", Name: "ci-platform-team"}, Members: []*DirectoryMember{{ID: "1234"}}},
+				{Group: &DirectoryGroup{ID: "ci-release@example.com", Name: "ci-release"}, Members: []*DirectoryMember{}},
+				{Group: &DirectoryGroup{ID: "ci-security@example.com", Name: "ci-security"}, Members: []*DirectoryMember{{ID: "5678"}}},
+			},
+		}
+		apiClient := &recordingApiClient{}
+
+		// act
+		result, err := streamGroupsAndMembers(context.Background(), apiClient, "token", groups, users, provider, nil, planOptions{groupPrefixes: []*groupPrefix{{prefix: "ci-"}}}, nil, nil, func([]*Action) error { return nil })
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, result.directoryGroups)
+		assert.Equal(t, 2, result.directoryMembers)
+		if assert.Equal(t, 3, len(apiClient.batches)) {
+			assert.Equal(t, "rename group platform to platform-team", apiClient.batches[0][0].String())
+			assert.Equal(t, "create group security", apiClient.batches[1][0].String())
+			assert.Equal(t, "update user john@example.com, add to groups platform-team, remove from groups release", apiClient.batches[2][0].String())
+		}
+		assert.Equal(t, 3, len(result.actions))
+	})
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/estafette/estafette-ci-gsuite-synchronizer/reconcile"
+	"github.com/opentracing/opentracing-go"
+	"github.com/rs/zerolog/log"
+)
+
+// state holds the estafette and directory state a synchronization is planned from
+type state struct {
+	token         string
+	organizations []*contracts.Organization
+	groups        []*contracts.Group
+	users         []*contracts.User
+	provider      Provider
+	groupMembers  map[*DirectoryGroup][]*DirectoryMember
+	// directoryUsers is only fetched if user profiles or properties are synchronized
+	directoryUsers []*DirectoryUser
+	// lastApplied is only read if --last-applied-file is set
+	lastApplied *reconcile.Snapshot
+	// deadLetters are the directory groups whose members couldn't be listed, see DeadLetter
+	deadLetters []*DeadLetter
+}
+
+// syncOnce runs a single synchronization with its own audit log, and records it in the history even if it failed
+func syncOnce(ctx context.Context, config *Config) (run *SyncRun, err error) {
+
+	runID := newRunID()
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Sync::Run")
+	defer span.Finish()
+	ctx = contextWithRunID(ctx, runID)
+	ctx = contextWithRunLogger(ctx, runID)
+
+	// syncs never run concurrently, so the global logger can carry the fields of the run as well for the few log lines without a context
+	globalLogger := log.Logger
+	log.Logger = *logFromContext(ctx)
+	defer func() {
+		log.Logger = globalLogger
+	}()
+
+	// recording the run isn't bound by the run timeout, so timed out runs show up in the audit log and history as well
+	reportCtx := ctx
+	syncCtx := contextWithRetryBudget(ctx, newRetryBudget(*retryBudgetSize))
+	syncCtx = contextWithDeadLetterList(syncCtx, newDeadLetterList())
+	if *runTimeout > 0 {
+		var cancel context.CancelFunc
+		syncCtx, cancel = context.WithTimeout(syncCtx, *runTimeout)
+		defer cancel()
+	}
+
+	// a shutdown request stops new mutations right away, but only cancels the ones in flight after the grace period
+	syncCtx, cancelSync := shutdownSignalFromContext(ctx).bound(syncCtx)
+	defer cancelSync()
+
+	auditLogger, err := NewAuditLogger(reportCtx, *auditLog, *auditLogSigningKeyFile, *triggeredBy)
+	if err != nil {
+		return nil, fmt.Errorf("Failed creating audit logger: %w", err)
+	}
+
+	apiClient := newApiClient(auditLogger)
+
+	// mutations that failed in earlier runs are re-attempted before reconciling the fresh state
+	queue, queueErr := processWorkQueue(syncCtx, apiClient)
+	if queueErr != nil {
+		logFromContext(ctx).Warn().Err(queueErr).Msg("Failed reading work queue, leaving it as it is")
+	}
+
+	if *syncStreaming {
+		run, err = syncGroupsStreaming(syncCtx, config, apiClient)
+	} else {
+		run, err = syncGroups(syncCtx, config, apiClient)
+	}
+
+	run.ID = runID
+	run.Tenant = tenantName()
+	if err != nil && errors.Is(syncCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("%w after %v, stopped with: %v", ErrRunTimeout, *runTimeout, err)
+		run.Err = err
+		logFromContext(ctx).Error().Msgf("Sync exceeded --run-timeout of %v, partial result: %v", *runTimeout, partialReport(run))
+	}
+	if err != nil && !errors.Is(err, ErrRunTimeout) && shutdownSignalFromContext(ctx).isRequested() {
+		err = shutdownError(ctx, run.Actions, err)
+		run.Err = err
+		logFromContext(ctx).Error().Msgf("Sync stopped by a shutdown request, partial result: %v", partialReport(run))
+	}
+
+	// close the audit log and export history before returning the error, so failed runs get recorded as well
+	auditErr := auditLogger.Close(reportCtx)
+	if queueErr == nil {
+		if writeErr := writeWorkQueue(reportCtx, *workQueueFile, enqueueFailedActions(queue, run.Actions, time.Now().UTC())); writeErr != nil {
+			logFromContext(ctx).Warn().Err(writeErr).Msg("Failed writing work queue")
+		}
+	}
+	recordQuotaUsage(run)
+	recordRunMetrics(run)
+	if statsErr := recordRunStats(reportCtx, run); statsErr != nil {
+		logFromContext(ctx).Warn().Err(statsErr).Msg("Failed recording run stats")
+	}
+	if expiryErr := trackMembershipExpiry(reportCtx, *membershipExpiryFile, run, time.Now()); expiryErr != nil {
+		logFromContext(ctx).Warn().Err(expiryErr).Msg("Failed tracking membership expiry")
+	}
+	exportHistory(reportCtx, run)
+	postIntegrationLog(reportCtx, apiClient, run)
+	triggerPipeline(reportCtx, apiClient, run)
+	recordKubernetesEvents(reportCtx, run)
+	emailReport(reportCtx, run)
+
+	if err == nil && auditErr != nil {
+		err = fmt.Errorf("Failed closing audit log: %w", auditErr)
+	}
+
+	return run, err
+}
+
+// syncGroups fetches the entire directory and estafette state and applies all changes needed to bring estafette in sync
+func syncGroups(ctx context.Context, config *Config, apiClient ApiClient) (run *SyncRun, err error) {
+
+	run = &SyncRun{
+		StartedAt: time.Now().UTC(),
+		Provider:  *provider,
+	}
+	defer func() {
+		run.FinishedAt = time.Now().UTC()
+		run.Err = err
+	}()
+
+	state, err := fetchState(ctx, apiClient)
+	if err != nil {
+		return
+	}
+
+	run.DirectoryGroups = len(state.groupMembers)
+	run.DirectoryMembers = countMembers(state.groupMembers)
+	run.NextMembershipExpiry = nextMembershipExpiry(state.groupMembers)
+	run.Groups = len(state.groups)
+	run.Users = len(state.users)
+	run.DeadLetters = state.deadLetters
+	run.UnprovisionedUsers = reportUnprovisionedUsers(ctx, state)
+	logDuplicateIdentities(ctx, state)
+
+	actions, err := planState(ctx, config, state)
+	if err != nil {
+		return
+	}
+	for _, a := range actions {
+		logFromContext(ctx).Info().Msgf("Planned action: %v", a)
+	}
+
+	options, err := getPlanOptions(config, state)
+	if err != nil {
+		return
+	}
+	run.NameConflicts = detectNameConflicts(state.groups, state.provider, options.syncedGroupMembers(state.groupMembers, state.directoryUsers), options)
+	logNameConflicts(run.NameConflicts)
+
+	err = checkGuardrails(ctx, config, state, actions, run)
+	if err != nil {
+		return
+	}
+
+	if *directorySnapshotFile != "" {
+		publishDirectoryChanges(ctx, *directorySnapshotFile, newChangeEventPublisher(), state.provider, state.groupMembers)
+	}
+
+	approvalGate, err := newApprovalGate()
+	if err != nil {
+		return
+	}
+	immediate, destructive := actions, []*Action(nil)
+	if approvalGate != nil {
+		immediate, destructive = splitDestructiveActions(actions)
+	}
+
+	run.Actions = append(immediate, destructive...)
+	err = applyPriorityActionsFirst(ctx, apiClient, state.token, immediate, state.provider, state.groupMembers)
+	if len(destructive) > 0 {
+		if err != nil {
+			for _, a := range destructive {
+				a.Err = fmt.Errorf("Skipped action %v since applying the other actions failed", a)
+			}
+		} else {
+			err = applyApprovedActions(ctx, approvalGate, apiClient, state.token, destructive)
+		}
+	}
+
+	recordLastApplied(state.lastApplied, state.provider, state.groupMembers, options.withNameConflicts(run.NameConflicts), run.Actions)
+	if writeErr := writeLastApplied(ctx, *lastAppliedFile, state.lastApplied); writeErr != nil && err == nil {
+		err = writeErr
+	}
+
+	return
+}
+
+// syncGroupsStreaming applies the changes group by group while the directory is being fetched, to bound memory usage for very large directories
+func syncGroupsStreaming(ctx context.Context, config *Config, apiClient ApiClient) (run *SyncRun, err error) {
+
+	if *verifyMemberEmails {
+		logFromContext(ctx).Warn().Msg("Verifying member emails needs all directory users, falling back to a regular sync")
+		return syncGroups(ctx, config, apiClient)
+	}
+	if *syncAllDomainUsers {
+		logFromContext(ctx).Warn().Msg("Reporting unprovisioned users needs all directory users, falling back to a regular sync")
+		return syncGroups(ctx, config, apiClient)
+	}
+	if *approvalWebhookURL != "" || *approvalPlanFile != "" {
+		logFromContext(ctx).Warn().Msg("Approving destructive changes needs all actions up front, falling back to a regular sync")
+		return syncGroups(ctx, config, apiClient)
+	}
+	if *shadowProvider != "" {
+		logFromContext(ctx).Warn().Msg("Comparing with the shadow provider needs the entire directory, falling back to a regular sync")
+		return syncGroups(ctx, config, apiClient)
+	}
+
+	directoryProvider, err := createProvider(ctx)
+	if err != nil {
+		return &SyncRun{StartedAt: time.Now().UTC(), FinishedAt: time.Now().UTC(), Provider: *provider, Err: err}, err
+	}
+
+	// the regular sync creates a provider of its own, so this one is stopped first
+	streamingProvider, ok := directoryProvider.(StreamingProvider)
+	if !ok {
+		closeProvider(ctx, directoryProvider)
+		logFromContext(ctx).Warn().Msgf("Provider %v doesn't support streaming, falling back to a regular sync", directoryProvider.Name())
+		return syncGroups(ctx, config, apiClient)
+	}
+	defer closeProvider(ctx, streamingProvider)
+	if *statsHistoryFile != "" {
+		logFromContext(ctx).Warn().Msg("Anomalies aren't checked with --streaming, since the directory counts are only known once all changes are applied")
+	}
+	if *directorySnapshotFile != "" {
+		logFromContext(ctx).Warn().Msg("Directory changes aren't logged with --streaming, since the entire directory isn't kept in memory")
+	}
+	if *aggregateEveryoneGroup != "" || *aggregateAdminsGroup != "" {
+		logFromContext(ctx).Warn().Msg("Aggregate groups aren't synchronized with --streaming, since the entire directory isn't kept in memory")
+	}
+
+	run = &SyncRun{
+		StartedAt: time.Now().UTC(),
+		Provider:  streamingProvider.Name(),
+	}
+	defer func() {
+		run.FinishedAt = time.Now().UTC()
+		run.Err = err
+	}()
+
+	state, err := fetchEstafetteState(ctx, apiClient)
+	if err != nil {
+		return
+	}
+	state.provider = streamingProvider
+	logDuplicateIdentities(ctx, state)
+
+	run.Groups = len(state.groups)
+	run.Users = len(state.users)
+
+	state.directoryUsers, err = fetchDirectoryUsers(ctx, streamingProvider)
+	if err != nil {
+		return
+	}
+	limits := newDirectoryLimits(*maxGroups, *maxUsers)
+	if err = limits.checkUsers(state.directoryUsers); err != nil {
+		return
+	}
+
+	options, err := getPlanOptions(config, state)
+	if err != nil {
+		return
+	}
+
+	policies, err := compilePolicies(config.Policies)
+	if err != nil {
+		err = fmt.Errorf("Invalid policies: %w", err)
+		return
+	}
+
+	hierarchyActions, err := planResourceHierarchy(ctx, state)
+	if err != nil {
+		return
+	}
+	err = apiClient.ApplyActions(ctx, state.token, hierarchyActions)
+
+	result, streamErr := streamGroupsAndMembers(ctx, apiClient, state.token, state.groups, state.users, streamingProvider, state.directoryUsers, options, policies, limits, func(userActions []*Action) error {
+		return confirmChanges(userActions, state.groups, state.users)
+	})
+	if err == nil {
+		err = streamErr
+	}
+
+	run.DirectoryGroups = result.directoryGroups
+	run.DirectoryMembers = result.directoryMembers
+	run.PolicyViolations = result.policyViolations
+	run.NextMembershipExpiry = result.nextMembershipExpiry
+	run.Actions = append(hierarchyActions, result.actions...)
+
+	if writeErr := writeLastApplied(ctx, *lastAppliedFile, state.lastApplied); writeErr != nil && err == nil {
+		err = writeErr
+	}
+
+	return
+}
+
+// planState computes all actions to bring estafette in sync with the fetched state
+func planState(ctx context.Context, config *Config, s state) (actions []*Action, err error) {
+
+	options, err := getPlanOptions(config, s)
+	if err != nil {
+		return
+	}
+
+	actions, err = planResourceHierarchy(ctx, s)
+	if err != nil {
+		return
+	}
+
+	return append(actions, planGroupsAndMembers(s.groups, s.users, s.provider, s.groupMembers, s.directoryUsers, options)...), nil
+}
+
+// checkGuardrails returns an error if the directory counts dropped beyond --anomaly-threshold, the directory violates a policy enforced by failing the run, or the actions remove more than --max-change-ratio allows; sync, plan, apply and tui all check them before applying anything, and record the anomalies and violations on the run
+func checkGuardrails(ctx context.Context, config *Config, s state, actions []*Action, run *SyncRun) error {
+	err := checkStatsAnomalies(ctx, run)
+	if err != nil {
+		return err
+	}
+
+	policies, err := compilePolicies(config.Policies)
+	if err != nil {
+		return fmt.Errorf("Invalid policies: %w", err)
+	}
+	run.PolicyViolations = evaluatePolicies(policies, s.groupMembers)
+	err = enforcePolicies(run.PolicyViolations)
+	if err != nil {
+		return err
+	}
+
+	return confirmChanges(actions, s.groups, s.users)
+}
+
+// newStateRun returns a run with the directory and estafette counts of the state, for the commands checking the guardrails outside a sync
+func newStateRun(s state) *SyncRun {
+	return &SyncRun{
+		StartedAt:        time.Now().UTC(),
+		Provider:         s.provider.Name(),
+		DirectoryGroups:  len(s.groupMembers),
+		DirectoryMembers: countMembers(s.groupMembers),
+		Groups:           len(s.groups),
+		Users:            len(s.users),
+	}
+}
+
+// confirmChanges returns an error if the actions remove a larger share of the existing group memberships, groups or user roles than --max-change-ratio allows, unless --force is set or it's confirmed interactively
+func confirmChanges(actions []*Action, groups []*contracts.Group, users []*contracts.User) error {
+	ratio := removalRatio(actions, groups, users)
+	if ratio <= *syncMaxChangeRatio {
+		return nil
+	}
+
+	if *syncForce {
+		log.Warn().Msgf("Removing %.0f%% of the group memberships, groups or user roles exceeds --max-change-ratio of %.0f%%, applying anyway because of --force", ratio*100, *syncMaxChangeRatio*100)
+		return nil
+	}
+
+	// only ask for confirmation when running in a terminal
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		fmt.Printf("This sync removes %.0f%% of the group memberships, groups or user roles, which exceeds --max-change-ratio of %.0f%%. Apply anyway? [y/N] ", ratio*100, *syncMaxChangeRatio*100)
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if strings.EqualFold(strings.TrimSpace(answer), "y") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Removing %.0f%% of the group memberships, groups or user roles exceeds --max-change-ratio of %.0f%%, use --force to apply anyway", ratio*100, *syncMaxChangeRatio*100)
+}
+
+// exportHistory appends the run to the bigquery history tables if enabled; failures are only logged since history is informational
+func exportHistory(ctx context.Context, run *SyncRun) {
+	if *historyBigQueryDataset == "" || run == nil {
+		return
+	}
+
+	bigQueryClient, err := NewBigQueryClient(ctx, *historyBigQueryProject, *historyBigQueryDataset)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed creating bigquery client for sync history")
+		return
+	}
+
+	err = NewHistoryExporter(bigQueryClient, *historyBigQueryRunsTable, *historyBigQueryActionsTable).ExportRun(ctx, run)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed exporting sync history to bigquery")
+	}
+}
+
+// logNameConflicts warns about every directory group that maps to an estafette group name claimed by another one
+func logNameConflicts(conflicts []*NameConflict) {
+	for _, c := range conflicts {
+		log.Warn().Msgf("Name conflict: %v", c)
+	}
+}
+
+// newChangeEventPublisher returns the publisher for directory change events configured with the flags, or nil if none is configured
+func newChangeEventPublisher() ChangeEventPublisher {
+	if *changeEventsWebhookURL == "" {
+		return nil
+	}
+
+	return NewWebhookPublisher(*changeEventsWebhookURL, *changeEventsWebhookTimeout)
+}
+
+// postIntegrationLog records the run in estafette if enabled; failures are only logged since the integration log is informational
+func postIntegrationLog(ctx context.Context, apiClient ApiClient, run *SyncRun) {
+	if !*apiIntegrationLog || run == nil {
+		return
+	}
+
+	err := apiClient.PostIntegrationLog(ctx, "", newIntegrationLog(run, *triggeredBy))
+	if errors.Is(err, ErrNotFound) {
+		logFromContext(ctx).Warn().Msg("The estafette api doesn't support integration logs, upgrade it or disable --api-integration-log")
+	} else if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed posting integration log to estafette")
+	}
+}
+
+// triggerPipeline starts a build of --trigger-pipeline if the run applied any change, so downstream automation runs exactly when access changed; that includes runs that failed halfway, since their applied changes won't show up in the next run
+func triggerPipeline(ctx context.Context, apiClient ApiClient, run *SyncRun) {
+	if *triggerPipelineName == "" || run == nil {
+		return
+	}
+
+	applied := countAppliedActions(run.Actions)
+	if applied == 0 {
+		logFromContext(ctx).Debug().Msgf("Not triggering pipeline %v, the run didn't change anything", *triggerPipelineName)
+		return
+	}
+
+	err := apiClient.TriggerPipeline(ctx, "", *triggerPipelineName, *triggerPipelineBranch)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msgf("Failed triggering pipeline %v after applying %v changes", *triggerPipelineName, applied)
+		return
+	}
+
+	logFromContext(ctx).Info().Msgf("Triggered pipeline %v on branch %v after applying %v changes", *triggerPipelineName, *triggerPipelineBranch, applied)
+}
+
+// recordKubernetesEvents reports the run as kubernetes events on the job or deployment running the syncer if enabled; failures are only logged since the events are informational
+func recordKubernetesEvents(ctx context.Context, run *SyncRun) {
+	if !*kubernetesEvents || run == nil {
+		return
+	}
+
+	recorder, err := newInClusterEventRecorder(*kubernetesEventsLargeChangeSet)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed creating kubernetes event recorder")
+		return
+	}
+
+	err = recorder.RecordRun(ctx, run)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed recording kubernetes events")
+	}
+}
+
+// countAppliedActions returns the number of actions that were applied without error
+func countAppliedActions(actions []*Action) (count int) {
+	for _, a := range actions {
+		if a.Err == nil {
+			count++
+		}
+	}
+	return
+}
+
+func countMembers(groupMembers map[*DirectoryGroup][]*DirectoryMember) (count int) {
+	for _, members := range groupMembers {
+		count += len(members)
+	}
+	return
+}
+
+func fetchState(ctx context.Context, apiClient ApiClient) (s state, err error) {
+	s, err = fetchEstafetteState(ctx, apiClient)
+	if err != nil {
+		return
+	}
+
+	directoryProvider, err := createProvider(ctx)
+	if err != nil {
+		return
+	}
+	defer closeProvider(ctx, directoryProvider)
+
+	groupMembers, err := directoryProvider.GetGroupsWithMembers(ctx)
+	if err != nil {
+		return s, fmt.Errorf("Failed fetching %v groups and members: %w", directoryProvider.Name(), err)
+	}
+
+	logFromContext(ctx).Info().Msgf("Fetched %v %v groups", len(groupMembers), directoryProvider.Name())
+
+	sortDirectoryMembers(groupMembers)
+	limits := newDirectoryLimits(*maxGroups, *maxUsers)
+	for _, group := range sortedDirectoryGroups(groupMembers) {
+		members := groupMembers[group]
+		logFromContext(ctx).Info().Msgf("Fetched %v %v members for group %v", len(members), directoryProvider.Name(), group.Name)
+		if err = limits.checkGroups(len(groupMembers), members); err != nil {
+			return
+		}
+	}
+
+	compareShadowProvider(ctx, directoryProvider, groupMembers)
+
+	s.provider = directoryProvider
+	s.groupMembers = dropExpiredMembers(groupMembers, directoryProvider.Name(), time.Now())
+	s.deadLetters = deadLetterListFromContext(ctx).list()
+
+	s.directoryUsers, err = fetchDirectoryUsers(ctx, directoryProvider)
+	if err != nil {
+		return
+	}
+	if err = limits.checkUsers(s.directoryUsers); err != nil {
+		return
+	}
+
+	if *verifyMemberEmails {
+		return verifyStateMembers(s)
+	}
+
+	return s, nil
+}
+
+// planResourceHierarchy returns the actions to mirror the gcp resource hierarchy as estafette organizations, if enabled
+func planResourceHierarchy(ctx context.Context, s state) ([]*Action, error) {
+	if !*gsuiteSyncResourceHierarchy {
+		return nil, nil
+	}
+
+	gsuiteClient, ok := s.provider.(GsuiteClient)
+	if !ok {
+		return nil, nil
+	}
+
+	nodes, err := gsuiteClient.GetResourceHierarchy(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed fetching gcp resource hierarchy: %w", err)
+	}
+
+	logFromContext(ctx).Info().Msgf("Fetched %v gcp organizations, folders and projects", len(nodes))
+
+	return planOrganizations(s.organizations, nodes), nil
+}
+
+// getPlanOptions returns the options to plan with from the flags and config file, resolving the organization rules against the fetched estafette organizations
+func getPlanOptions(config *Config, s state) (planOptions, error) {
+	rules, err := parseOrganizationRules(*organizationRules, s.organizations)
+	if err != nil {
+		return planOptions{}, fmt.Errorf("Invalid organization rules: %w", err)
+	}
+
+	fields, err := parseManagedFields(*managedFields)
+	if err != nil {
+		return planOptions{}, fmt.Errorf("Invalid managed fields: %w", err)
+	}
+
+	if *syncEmptyGroups && *cleanupEmptyGroups {
+		return planOptions{}, fmt.Errorf("--sync-empty-groups and --cleanup-empty-groups can't be combined, empty groups would be created and deleted every sync")
+	}
+
+	nameTransforms, err := compileNameTransforms(config.NameTransforms)
+	if err != nil {
+		return planOptions{}, fmt.Errorf("Invalid name transforms: %w", err)
+	}
+
+	groupPrefixes, err := compileGroupPrefixes(*gsuiteGroupPrefixes, config.GroupPrefixes)
+	if err != nil {
+		return planOptions{}, fmt.Errorf("Invalid group prefixes: %w", err)
+	}
+
+	memberFilter, err := newMemberFilter(getMemberPatterns(*includeMembers, config.IncludeMembers), getMemberPatterns(*excludeMembers, config.ExcludeMembers), *excludeServiceAccounts)
+	if err != nil {
+		return planOptions{}, fmt.Errorf("Invalid member filter: %w", err)
+	}
+
+	return planOptions{
+		groupPrefixes:     groupPrefixes,
+		nameTransforms:    nameTransforms,
+		organizationRules: rules,
+		protectedGroups:   append(append(getProtectedGroups(*protectedGroups, config), deadLetteredGroupIDs(s.groups, s.provider, s.deadLetters)...), duplicateGroupIDs(s.groups, s.users, s.provider)...),
+		lastApplied:       s.lastApplied,
+		managedFields:     fields,
+		memberFilter:      memberFilter,
+		everyoneGroup:     *aggregateEveryoneGroup,
+		adminsGroup:       *aggregateAdminsGroup,
+		adminRoles:        config.AdminRoles,
+
+		syncEmptyGroups:        *syncEmptyGroups,
+		syncDescriptions:       config.SyncGroupDescriptions,
+		cleanupEmptyGroups:     *cleanupEmptyGroups,
+		nameConflictResolution: *nameConflictResolution,
+	}, nil
+}
+
+// directoryUsersNeeded checks whether user profiles, properties or the admins aggregate group are synchronized or member emails are verified, which need the directory users
+func directoryUsersNeeded() bool {
+	return *gsuiteSyncUserProfiles || len(*gsuiteUserAttributeMapping) > 0 || *aggregateAdminsGroup != "" || *verifyMemberEmails || *syncAllDomainUsers || *gsuiteSyncAdminRoles
+}
+
+// fetchDirectoryUsers retrieves the directory users if the provider supports it and they're needed
+func fetchDirectoryUsers(ctx context.Context, directoryProvider Provider) ([]*DirectoryUser, error) {
+	userProvider, ok := directoryProvider.(UserProvider)
+	if !ok || !directoryUsersNeeded() {
+		return nil, nil
+	}
+
+	directoryUsers, err := userProvider.GetDirectoryUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed fetching %v users: %w", directoryProvider.Name(), err)
+	}
+
+	logFromContext(ctx).Info().Msgf("Fetched %v %v users", len(directoryUsers), directoryProvider.Name())
+
+	return directoryUsers, nil
+}
+
+// fetchEstafetteState retrieves the token, organizations, groups and users from estafette, without the directory state
+func fetchEstafetteState(ctx context.Context, apiClient ApiClient) (s state, err error) {
+	secret, err := getClientSecret()
+	if err != nil {
+		return s, err
+	}
+	token, err := apiClient.GetToken(ctx, *clientID, secret)
+	if err != nil {
+		return s, fmt.Errorf("Failed retrieving JWT token: %w", err)
+	}
+
+	organizations, err := apiClient.GetOrganizations(ctx, token)
+	if err != nil {
+		return s, fmt.Errorf("Failed fetching organizations: %w", err)
+	}
+
+	logFromContext(ctx).Info().Msgf("Fetched %v organizations", len(organizations))
+
+	groups, err := apiClient.GetGroups(ctx, token)
+	if err != nil {
+		return s, fmt.Errorf("Failed fetching groups: %w", err)
+	}
+
+	logFromContext(ctx).Info().Msgf("Fetched %v groups", len(groups))
+
+	users, err := apiClient.GetUsers(ctx, token)
+	if err != nil {
+		return s, fmt.Errorf("Failed fetching users: %w", err)
+	}
+
+	logFromContext(ctx).Info().Msgf("Fetched %v users", len(users))
+
+	sortEstafetteState(groups, users)
+
+	lastApplied, err := readLastApplied(ctx, *lastAppliedFile)
+	if err != nil {
+		return s, err
+	}
+
+	return state{
+		token:         token,
+		organizations: organizations,
+		groups:        groups,
+		users:         users,
+		lastApplied:   lastApplied,
+	}, nil
+}
+
+// createProvider returns the Provider for the provider selected with --provider
+func createProvider(ctx context.Context) (Provider, error) {
+	return createNamedProvider(ctx, *provider)
+}
+
+// createNamedProvider returns the Provider with the name, configured with its flags
+func createNamedProvider(ctx context.Context, name string) (Provider, error) {
+	switch name {
+	case ldapProviderName:
+		return NewLdapClient(*ldapURL, *ldapBindDN, *ldapBindPassword, *ldapBaseDN, *ldapGroupFilter, *ldapMemberAttribute, *ldapUserFilter, *ldapEmailAttribute), nil
+
+	case githubProviderName:
+		return NewGithubClient(*githubAPIBaseURL, *githubOrganization, *githubToken), nil
+
+	case pluginProviderName:
+		pluginClient, err := NewPluginClient(ctx, *pluginPath, *pluginArgs)
+		if err != nil {
+			return nil, fmt.Errorf("Failed creating plugin client: %w", err)
+		}
+		return pluginClient, nil
+	}
+
+	gsuiteClient, err := NewGsuiteClient(ctx, *gsuiteDomain, GsuiteClientOptions{
+		CustomerIDs:          *gsuiteCustomerIDs,
+		AdminEmails:          gsuiteAdminEmails(),
+		GroupPrefixes:        *gsuiteGroupPrefixes,
+		GroupDomains:         *gsuiteGroupDomains,
+		Concurrency:          gsuiteQuota.concurrency(*gsuiteConcurrency),
+		UserAttributeMapping: *gsuiteUserAttributeMapping,
+		SyncUserProfiles:     *gsuiteSyncUserProfiles,
+		SyncGroupSettings:    *gsuiteSyncGroupSettings,
+		SyncMembershipExpiry: *gsuiteSyncMembershipExpiry,
+		SyncDynamicGroups:    *gsuiteSyncDynamicGroups,
+		SyncAdminRoles:       *gsuiteSyncAdminRoles,
+		Scopes:               gsuiteScopes(gsuiteFeaturesFromFlags()),
+		MemberCache:          gsuiteMemberCache,
+		ListCache:            gsuiteListCache,
+		Quota:                gsuiteQuota,
+		APIEndpoint:          *gsuiteAPIEndpoint,
+		Faults:               newFaultInjector(*faultInjectionRate, time.Now().UnixNano()),
+		HTTPLog:              newHTTPLogger(*logHTTP, *logHTTPBodies),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed creating gsuite client: %w", err)
+	}
+
+	gsuiteOrganizations, err := gsuiteClient.GetOrganizations(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed fetching gsuite organizations: %w", err)
+	}
+
+	logFromContext(ctx).Info().Msgf("Fetched %v gsuite organizations", len(gsuiteOrganizations))
+
+	return gsuiteClient, nil
+}
+
+// checkConnectivity checks whether the estafette credentials are valid and the estafette api and the directory are reachable
+func checkConnectivity(ctx context.Context, apiClient ApiClient) (directoryProvider Provider, err error) {
+	secret, err := getClientSecret()
+	if err != nil {
+		return nil, err
+	}
+	token, err := apiClient.GetToken(ctx, *clientID, secret)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving JWT token, check --api-base-url, --client-id and --client-secret: %w", err)
+	}
+
+	_, err = apiClient.GetOrganizations(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("Failed fetching organizations, check whether the client has the required roles: %w", err)
+	}
+
+	return createProvider(ctx)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// syncSchedule determines when the daemon syncs next, either a fixed interval after the previous sync or at the times of a cron expression, each delayed by a random jitter so replicas and other scheduled jobs don't all hit the apis at once. It only uses timers, so it works the same on every os
+type syncSchedule struct {
+	interval time.Duration
+	spec     string
+	cron     cron.Schedule
+	jitter   time.Duration
+	random   *rand.Rand
+}
+
+// newSyncSchedule returns the schedule for syncing every interval, or at the times of the standard 5-field cron expression if spec is set
+func newSyncSchedule(interval time.Duration, spec string, jitter time.Duration) (*syncSchedule, error) {
+	if interval > 0 && spec != "" {
+		return nil, errors.New("flags --interval and --schedule can't be combined")
+	}
+	if jitter < 0 {
+		return nil, errors.New("flag --jitter can't be negative")
+	}
+
+	s := &syncSchedule{
+		interval: interval,
+		spec:     spec,
+		jitter:   jitter,
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if spec != "" {
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid --schedule %q: %w", spec, err)
+		}
+		s.cron = schedule
+	}
+
+	return s, nil
+}
+
+// next returns when to sync after a sync that started at startedAt finished at now; on a cron schedule the times that passed while that sync was still running are skipped, rather than starting the next sync right away or running syncs concurrently, and their number is returned
+func (s *syncSchedule) next(startedAt, now time.Time) (next time.Time, skipped int) {
+	if s.cron == nil {
+		return now.Add(s.interval + s.randomJitter()), 0
+	}
+
+	next = s.cron.Next(startedAt)
+	for !next.After(now) {
+		skipped++
+		next = s.cron.Next(next)
+	}
+
+	return next.Add(s.randomJitter()), skipped
+}
+
+// approximateInterval returns the interval, or the time between the next two times of the cron expression, for estimating how often the daemon syncs
+func (s *syncSchedule) approximateInterval(now time.Time) time.Duration {
+	if s.cron == nil {
+		return s.interval
+	}
+
+	first := s.cron.Next(now)
+
+	return s.cron.Next(first).Sub(first)
+}
+
+// randomJitter returns a random delay of up to the jitter
+func (s *syncSchedule) randomJitter() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+
+	return time.Duration(s.random.Int63n(int64(s.jitter)))
+}
+
+func (s *syncSchedule) String() string {
+	if s.cron != nil {
+		return fmt.Sprintf("on schedule %q", s.spec)
+	}
+
+	return fmt.Sprintf("every %v", s.interval)
+}
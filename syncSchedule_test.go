@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncSchedule(t *testing.T) {
+	t.Run("SyncsIntervalAfterThePreviousSyncFinished", func(t *testing.T) {
+
+		schedule, err := newSyncSchedule(time.Hour, "", 0)
+		assert.Nil(t, err)
+		startedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		// act
+		next, skipped := schedule.next(startedAt, startedAt.Add(5*time.Minute))
+
+		assert.Equal(t, startedAt.Add(65*time.Minute), next)
+		assert.Equal(t, 0, skipped)
+	})
+
+	t.Run("SyncsAtTheNextTimeOfTheCronExpression", func(t *testing.T) {
+
+		schedule, err := newSyncSchedule(0, "*/15 * * * *", 0)
+		assert.Nil(t, err)
+		startedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		// act
+		next, skipped := schedule.next(startedAt, startedAt.Add(5*time.Minute))
+
+		assert.Equal(t, time.Date(2020, 6, 1, 12, 15, 0, 0, time.UTC), next)
+		assert.Equal(t, 0, skipped)
+		assert.Equal(t, 15*time.Minute, schedule.approximateInterval(startedAt))
+	})
+
+	t.Run("SkipsTimesPassingWhileThePreviousSyncWasStillRunning", func(t *testing.T) {
+
+		schedule, err := newSyncSchedule(0, "*/15 * * * *", 0)
+		assert.Nil(t, err)
+		startedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		// act
+		next, skipped := schedule.next(startedAt, startedAt.Add(40*time.Minute))
+
+		assert.Equal(t, time.Date(2020, 6, 1, 12, 45, 0, 0, time.UTC), next)
+		assert.Equal(t, 2, skipped)
+	})
+
+	t.Run("DelaysSyncsByUpToTheJitter", func(t *testing.T) {
+
+		schedule, err := newSyncSchedule(0, "0 * * * *", 10*time.Minute)
+		assert.Nil(t, err)
+		startedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		for i := 0; i < 20; i++ {
+			// act
+			next, _ := schedule.next(startedAt, startedAt.Add(time.Minute))
+
+			assert.False(t, next.Before(time.Date(2020, 6, 1, 13, 0, 0, 0, time.UTC)))
+			assert.True(t, next.Before(time.Date(2020, 6, 1, 13, 10, 0, 0, time.UTC)))
+		}
+	})
+
+	t.Run("ReturnsErrorForInvalidOrCombinedSchedules", func(t *testing.T) {
+
+		// act
+		_, invalidErr := newSyncSchedule(0, "every quarter", 0)
+		_, combinedErr := newSyncSchedule(time.Hour, "*/15 * * * *", 0)
+
+		assert.NotNil(t, invalidErr)
+		assert.NotNil(t, combinedErr)
+	})
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opentracing-contrib/go-stdlib/nethttp"
+)
+
+// syncRunMetrics counts the runs and changes of the syncer by tenant and provider, served on /debug/vars in daemon mode, so one dashboard can cover the syncers of all estafette environments
+var syncRunMetrics = expvar.NewMap("sync_runs")
+
+// tenantName returns the estafette environment set with --tenant, or else the domain or other tenant of the directory
+func tenantName() string {
+	if *tenant != "" {
+		return *tenant
+	}
+
+	return directoryDomain()
+}
+
+// metricsLabels returns the key the metrics of the tenant and provider are kept under, in the tenant=...,provider=... format dashboards can split into labels
+func metricsLabels(tenant, provider string) string {
+	return fmt.Sprintf("tenant=%v,provider=%v", tenant, provider)
+}
+
+// recordRunMetrics adds the run to the metrics of its tenant and provider
+func recordRunMetrics(run *SyncRun) {
+	labels := metricsLabels(run.Tenant, run.Provider)
+	metrics, ok := syncRunMetrics.Get(labels).(*expvar.Map)
+	if !ok {
+		metrics = new(expvar.Map).Init()
+		syncRunMetrics.Set(labels, metrics)
+	}
+
+	failedActions := 0
+	for _, a := range run.Actions {
+		if a.Err != nil {
+			failedActions++
+		}
+	}
+	if run.Err != nil {
+		metrics.Add("failed", 1)
+	} else {
+		metrics.Add("succeeded", 1)
+	}
+	metrics.Add("actions", int64(len(run.Actions)))
+	metrics.Add("failedActions", int64(failedActions))
+
+	lastRun := new(expvar.Int)
+	lastRun.Set(run.FinishedAt.Unix())
+	metrics.Set("lastRunTimestamp", lastRun)
+	duration := new(expvar.Float)
+	duration.Set(run.FinishedAt.Sub(run.StartedAt).Seconds())
+	metrics.Set("lastRunDurationSeconds", duration)
+}
+
+// TenantSummary is the health of the syncers of all estafette environments, as served on /tenants in daemon mode
+type TenantSummary struct {
+	// Healthy is set if the last sync of every tenant succeeded and all peers could be reached
+	Healthy bool            `json:"healthy"`
+	Tenants []*TenantHealth `json:"tenants"`
+}
+
+// TenantHealth is the last sync of the syncer of a tenant
+type TenantHealth struct {
+	Tenant   string `json:"tenant"`
+	Provider string `json:"provider,omitempty"`
+	// URL is the base url of the health endpoints of a peer, empty for this syncer
+	URL      string            `json:"url,omitempty"`
+	LastSync *lastSyncResponse `json:"lastSync,omitempty"`
+	// Error is set if the peer couldn't be reached
+	Error string `json:"error,omitempty"`
+}
+
+// healthy checks whether the syncer of the tenant could be reached and its last sync succeeded; a syncer that didn't sync yet counts as healthy
+func (h *TenantHealth) healthy() bool {
+	return h.Error == "" && h.LastSync != nil && h.LastSync.Result != "failed"
+}
+
+// tenantSummarizer aggregates the health of this syncer with the /lastsync of the syncers of the other tenants
+type tenantSummarizer struct {
+	// peers are the base urls of the health endpoints of the syncers of the other tenants
+	peers  []string
+	client *http.Client
+}
+
+// newTenantSummarizer returns a tenantSummarizer fetching the last sync of the peers
+func newTenantSummarizer(peers []string) *tenantSummarizer {
+	return &tenantSummarizer{
+		peers:  peers,
+		client: &http.Client{Transport: &nethttp.Transport{RoundTripper: sharedRoundTripper()}, Timeout: 5 * time.Second},
+	}
+}
+
+// summarize returns the health of this syncer and its peers, fetched in parallel
+func (s *tenantSummarizer) summarize(ctx context.Context, lastRun *SyncRun) *TenantSummary {
+	summary := &TenantSummary{Healthy: true, Tenants: make([]*TenantHealth, len(s.peers)+1)}
+
+	own := &TenantHealth{Tenant: tenantName(), Provider: *provider, LastSync: newLastSyncResponse(lastRun)}
+	summary.Tenants[0] = own
+
+	var wg sync.WaitGroup
+	for i, peer := range s.peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			summary.Tenants[i+1] = s.fetchPeer(ctx, peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	for _, t := range summary.Tenants {
+		summary.Healthy = summary.Healthy && t.healthy()
+	}
+
+	return summary
+}
+
+// fetchPeer returns the health of the peer from its /lastsync endpoint
+func (s *tenantSummarizer) fetchPeer(ctx context.Context, peer string) *TenantHealth {
+	health := &TenantHealth{URL: peer}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(peer, "/")+"/lastsync", nil)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		health.Error = fmt.Sprintf("status code %v", response.StatusCode)
+		return health
+	}
+
+	var lastSync lastSyncResponse
+	err = json.NewDecoder(response.Body).Decode(&lastSync)
+	if err != nil {
+		health.Error = fmt.Sprintf("Failed unmarshalling last sync: %v", err)
+		return health
+	}
+	health.Tenant = lastSync.Tenant
+	health.Provider = lastSync.Provider
+	health.LastSync = &lastSync
+
+	return health
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordRunMetrics(t *testing.T) {
+	t.Run("CountsRunsByTenantAndProvider", func(t *testing.T) {
+
+		// the metrics outlive the test, so start from scratch when it runs repeatedly
+		syncRunMetrics.Delete("tenant=metrics-test,provider=gsuite")
+		startedAt := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		run := &SyncRun{Tenant: "metrics-test", Provider: gsuiteProviderName, StartedAt: startedAt, FinishedAt: startedAt.Add(2 * time.Second), Actions: []*Action{{Type: ActionCreateGroup}, {Type: ActionUpdateUser, Err: errors.New("conflict")}}}
+
+		// act
+		recordRunMetrics(run)
+		recordRunMetrics(&SyncRun{Tenant: "metrics-test", Provider: gsuiteProviderName, StartedAt: startedAt, FinishedAt: startedAt, Err: errors.New("unauthorized")})
+
+		metrics, ok := syncRunMetrics.Get("tenant=metrics-test,provider=gsuite").(*expvar.Map)
+		if assert.True(t, ok) {
+			assert.Equal(t, "1", metrics.Get("succeeded").String())
+			assert.Equal(t, "1", metrics.Get("failed").String())
+			assert.Equal(t, "2", metrics.Get("actions").String())
+			assert.Equal(t, "1", metrics.Get("failedActions").String())
+			assert.Equal(t, "0", metrics.Get("lastRunDurationSeconds").String())
+		}
+	})
+}
+
+func TestTenantSummarizer(t *testing.T) {
+	defer func(value string) { *tenant = value }(*tenant)
+	*tenant = "production"
+
+	t.Run("AggregatesLastSyncOfPeers", func(t *testing.T) {
+
+		peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/lastsync" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(&lastSyncResponse{Result: "failed", Tenant: "staging", Provider: ldapProviderName, Error: "unauthorized"})
+		}))
+		defer peer.Close()
+		summarizer := newTenantSummarizer([]string{peer.URL})
+
+		// act
+		summary := summarizer.summarize(context.Background(), &SyncRun{ID: "run-1", Tenant: "production", Provider: gsuiteProviderName})
+
+		assert.False(t, summary.Healthy)
+		if assert.Equal(t, 2, len(summary.Tenants)) {
+			assert.Equal(t, "production", summary.Tenants[0].Tenant)
+			assert.Equal(t, "succeeded", summary.Tenants[0].LastSync.Result)
+			assert.Equal(t, "staging", summary.Tenants[1].Tenant)
+			assert.Equal(t, ldapProviderName, summary.Tenants[1].Provider)
+			assert.Equal(t, "unauthorized", summary.Tenants[1].LastSync.Error)
+		}
+	})
+
+	t.Run("ReportsUnreachablePeerAsUnhealthy", func(t *testing.T) {
+
+		peer := httptest.NewServer(http.NotFoundHandler())
+		defer peer.Close()
+		summarizer := newTenantSummarizer([]string{peer.URL})
+
+		// act
+		summary := summarizer.summarize(context.Background(), nil)
+
+		assert.False(t, summary.Healthy)
+		assert.True(t, summary.Tenants[0].healthy())
+		assert.Equal(t, "status code 404", summary.Tenants[1].Error)
+	})
+}
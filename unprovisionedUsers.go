@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+)
+
+// findUnprovisionedUsers returns the emails, or ids if they have none, of the directory users no estafette user matches with; estafette creates users when they first log in, so these users exist in the directory but never logged in to estafette
+func findUnprovisionedUsers(users []*contracts.User, provider Provider, directoryUsers []*DirectoryUser) (unprovisioned []string) {
+	unprovisioned = make([]string, 0)
+	if len(directoryUsers) == 0 {
+		return
+	}
+
+	usersByMemberKey := indexUsersByMemberKey(users, provider)
+	for _, du := range directoryUsers {
+		if du.Suspended || len(usersByMemberKey[memberKey(provider, &DirectoryMember{ID: du.ID, Email: du.Email})]) > 0 {
+			continue
+		}
+		if du.Email != "" {
+			unprovisioned = append(unprovisioned, du.Email)
+		} else {
+			unprovisioned = append(unprovisioned, du.ID)
+		}
+	}
+	sort.Strings(unprovisioned)
+
+	return
+}
+
+// reportUnprovisionedUsers returns the unprovisioned directory users, see findUnprovisionedUsers, and logs them if --sync-all-domain-users is set; otherwise the directory users are only fetched as far as the other features need them, so it returns none
+func reportUnprovisionedUsers(ctx context.Context, s state) []string {
+	if !*syncAllDomainUsers {
+		return nil
+	}
+
+	unprovisioned := findUnprovisionedUsers(s.users, s.provider, s.directoryUsers)
+	if len(unprovisioned) > 0 {
+		logFromContext(ctx).Warn().Msgf("%v of %v %v users have no estafette user yet, they're provisioned when they first log in to estafette", len(unprovisioned), len(s.directoryUsers), s.provider.Name())
+	}
+	for _, u := range unprovisioned {
+		logFromContext(ctx).Info().Msgf("Unprovisioned %v user: %v", s.provider.Name(), u)
+	}
+
+	return unprovisioned
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindUnprovisionedUsers(t *testing.T) {
+	t.Run("ReturnsDirectoryUsersWithoutEstafetteUser", func(t *testing.T) {
+
+		users := []*contracts.User{
+			{ID: "1", Identities: []*contracts.UserIdentity{{Provider: "google", ID: "101", Email: "jane@example.com"}}},
+		}
+		directoryUsers := []*DirectoryUser{
+			{ID: "101", Email: "jane@example.com"},
+			{ID: "102", Email: "ted@example.com"},
+			{ID: "103", Email: "bob@example.com"},
+		}
+
+		// act
+		unprovisioned := findUnprovisionedUsers(users, &gsuiteClient{}, directoryUsers)
+
+		assert.Equal(t, []string{"bob@example.com", "ted@example.com"}, unprovisioned)
+	})
+
+	t.Run("SkipsSuspendedDirectoryUsers", func(t *testing.T) {
+
+		directoryUsers := []*DirectoryUser{
+			{ID: "102", Email: "ted@example.com", Suspended: true},
+		}
+
+		// act
+		unprovisioned := findUnprovisionedUsers([]*contracts.User{}, &gsuiteClient{}, directoryUsers)
+
+		assert.Equal(t, 0, len(unprovisioned))
+	})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compiledProviders are the directory providers built into the syncer, selectable with --provider and --shadow-provider
+var compiledProviders = []string{gsuiteProviderName, ldapProviderName, githubProviderName, pluginProviderName}
+
+// VersionInfo is the build of the syncer and, if requested, what it can do, so operators and support can quickly see what a deployed instance runs
+type VersionInfo struct {
+	App          string        `json:"app"`
+	Version      string        `json:"version"`
+	Branch       string        `json:"branch,omitempty"`
+	Revision     string        `json:"revision,omitempty"`
+	BuildDate    string        `json:"buildDate,omitempty"`
+	GoVersion    string        `json:"goVersion"`
+	Capabilities *Capabilities `json:"capabilities,omitempty"`
+}
+
+// Capabilities are the providers compiled into the syncer, the ones it's configured with and the optional features with whether they're enabled
+type Capabilities struct {
+	CompiledProviders []string   `json:"compiledProviders"`
+	Provider          string     `json:"provider"`
+	ShadowProvider    string     `json:"shadowProvider,omitempty"`
+	Features          []*Feature `json:"features"`
+}
+
+// Feature is an optional feature, named by the flag enabling it
+type Feature struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// newVersionInfo returns the build info, with the capabilities as configured with the flags if withCapabilities is set
+func newVersionInfo(withCapabilities bool) *VersionInfo {
+	info := &VersionInfo{
+		App:       app,
+		Version:   version,
+		Branch:    branch,
+		Revision:  revision,
+		BuildDate: buildDate,
+		GoVersion: goVersion,
+	}
+	if withCapabilities {
+		info.Capabilities = &Capabilities{
+			CompiledProviders: compiledProviders,
+			Provider:          *provider,
+			ShadowProvider:    *shadowProvider,
+			Features:          enabledFeatures(),
+		}
+	}
+
+	return info
+}
+
+// enabledFeatures returns the optional features and whether the flags enable them
+func enabledFeatures() []*Feature {
+	return []*Feature{
+		{Name: "config-file", Enabled: *configFile != ""},
+		{Name: "audit-log", Enabled: *auditLog != ""},
+		{Name: "audit-log-signing-key-file", Enabled: *auditLogSigningKeyFile != ""},
+		{Name: "work-queue-file", Enabled: *workQueueFile != ""},
+		{Name: "last-applied-file", Enabled: *lastAppliedFile != ""},
+		{Name: "stats-history-file", Enabled: *statsHistoryFile != ""},
+		{Name: "directory-snapshot-file", Enabled: *directorySnapshotFile != ""},
+		{Name: "state-store", Enabled: *stateStoreType != stateStoreFile},
+		{Name: "tenant-peer", Enabled: len(*tenantPeers) > 0},
+		{Name: "priority-groups", Enabled: *priorityGroups != ""},
+		{Name: "change-events-webhook-url", Enabled: *changeEventsWebhookURL != ""},
+		{Name: "approval-webhook-url", Enabled: *approvalWebhookURL != ""},
+		{Name: "approval-plan-file", Enabled: *approvalPlanFile != ""},
+		{Name: "trigger-pipeline", Enabled: *triggerPipelineName != ""},
+		{Name: "api-integration-log", Enabled: *apiIntegrationLog},
+		{Name: "report-email-to", Enabled: *reportEmailTo != ""},
+		{Name: "kubernetes-events", Enabled: *kubernetesEvents},
+		{Name: "history-bigquery-dataset", Enabled: *historyBigQueryDataset != ""},
+		{Name: "verify-member-emails", Enabled: *verifyMemberEmails},
+		{Name: "sync-all-domain-users", Enabled: *syncAllDomainUsers},
+		{Name: "aggregate-group-everyone", Enabled: *aggregateEveryoneGroup != ""},
+		{Name: "aggregate-group-admins", Enabled: *aggregateAdminsGroup != ""},
+		{Name: "admin-api-token", Enabled: *adminAPIToken != ""},
+		{Name: "slack-signing-secret", Enabled: *slackSigningSecret != ""},
+		{Name: "gsuite-group-domain", Enabled: len(*gsuiteGroupDomains) > 0},
+		{Name: "gsuite-sync-user-profiles", Enabled: *gsuiteSyncUserProfiles},
+		{Name: "gsuite-sync-group-settings", Enabled: *gsuiteSyncGroupSettings},
+		{Name: "gsuite-sync-membership-expiry", Enabled: *gsuiteSyncMembershipExpiry},
+		{Name: "gsuite-sync-dynamic-groups", Enabled: *gsuiteSyncDynamicGroups},
+		{Name: "gsuite-sync-admin-roles", Enabled: *gsuiteSyncAdminRoles},
+		{Name: "gsuite-sync-resource-hierarchy", Enabled: *gsuiteSyncResourceHierarchy},
+		{Name: "gsuite-conditional-fetch", Enabled: *gsuiteConditionalFetch},
+		{Name: "gsuite-member-cache-ttl", Enabled: *gsuiteMemberCacheTTL > 0},
+		{Name: "trace-groups", Enabled: len(*traceGroups) > 0},
+		{Name: "log-http", Enabled: *logHTTP},
+		{Name: "fault-injection-rate", Enabled: *faultInjectionRate > 0},
+	}
+}
+
+// writeVersionInfo writes the version info as text, with a line per feature if it has capabilities
+func writeVersionInfo(w io.Writer, info *VersionInfo) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%v %v\n", info.App, info.Version)
+	fmt.Fprintf(&b, "branch: %v\n", info.Branch)
+	fmt.Fprintf(&b, "revision: %v\n", info.Revision)
+	fmt.Fprintf(&b, "build date: %v\n", info.BuildDate)
+	fmt.Fprintf(&b, "go version: %v\n", info.GoVersion)
+
+	if c := info.Capabilities; c != nil {
+		fmt.Fprintf(&b, "compiled providers: %v\n", strings.Join(c.CompiledProviders, ", "))
+		fmt.Fprintf(&b, "provider: %v\n", c.Provider)
+		if c.ShadowProvider != "" {
+			fmt.Fprintf(&b, "shadow provider: %v\n", c.ShadowProvider)
+		}
+		b.WriteString("features:\n")
+		for _, f := range c.Features {
+			state := "disabled"
+			if f.Enabled {
+				state = "enabled"
+			}
+			fmt.Fprintf(&b, "  %v: %v\n", f.Name, state)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
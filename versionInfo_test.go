@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteVersionInfo(t *testing.T) {
+	t.Run("WritesBuildInfoOnlyWithoutCapabilities", func(t *testing.T) {
+
+		info := &VersionInfo{App: "estafette-ci-gsuite-synchronizer", Version: "1.2.3", Branch: "main", Revision: "abc123", BuildDate: "2020-07-01", GoVersion: "go1.14"}
+		var b bytes.Buffer
+
+		// act
+		err := writeVersionInfo(&b, info)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "estafette-ci-gsuite-synchronizer 1.2.3\nbranch: main\nrevision: abc123\nbuild date: 2020-07-01\ngo version: go1.14\n", b.String())
+	})
+
+	t.Run("WritesProvidersAndFeaturesWithCapabilities", func(t *testing.T) {
+
+		info := &VersionInfo{
+			App:       "estafette-ci-gsuite-synchronizer",
+			Version:   "1.2.3",
+			GoVersion: "go1.14",
+			Capabilities: &Capabilities{
+				CompiledProviders: []string{"gsuite", "ldap"},
+				Provider:          "gsuite",
+				ShadowProvider:    "ldap",
+				Features:          []*Feature{{Name: "audit-log", Enabled: true}, {Name: "work-queue-file"}},
+			},
+		}
+		var b bytes.Buffer
+
+		// act
+		err := writeVersionInfo(&b, info)
+
+		assert.Nil(t, err)
+		assert.Contains(t, b.String(), "compiled providers: gsuite, ldap\nprovider: gsuite\nshadow provider: ldap\nfeatures:\n  audit-log: enabled\n  work-queue-file: disabled\n")
+	})
+}
+
+func TestNewVersionInfo(t *testing.T) {
+	t.Run("ReportsFeaturesEnabledByFlags", func(t *testing.T) {
+
+		defer func(value string) { *workQueueFile = value }(*workQueueFile)
+		*workQueueFile = "queue.json"
+
+		// act
+		info := newVersionInfo(true)
+
+		enabled := map[string]bool{}
+		for _, f := range info.Capabilities.Features {
+			enabled[f.Name] = f.Enabled
+		}
+		assert.True(t, enabled["work-queue-file"])
+	})
+
+	t.Run("LeavesOutCapabilitiesUnlessRequested", func(t *testing.T) {
+
+		// act
+		info := newVersionInfo(false)
+
+		assert.Nil(t, info.Capabilities)
+	})
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// QueuedMutation is an action that failed even after its retries, waiting in the work queue to be re-attempted at the start of the next run, so changes aren't lost when the estafette api is down for longer than a single run
+type QueuedMutation struct {
+	Action   *Action   `json:"action"`
+	Error    string    `json:"error"`
+	Attempts int       `json:"attempts"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// readWorkQueue reads the queued mutations from the state store; it returns none if path is empty or nothing was queued yet
+func readWorkQueue(ctx context.Context, path string) ([]*QueuedMutation, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := getStateStore().Read(ctx, path)
+	if errors.Is(err, ErrStateNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading work queue %v: %w", path, err)
+	}
+
+	var queue []*QueuedMutation
+	err = json.Unmarshal(data, &queue)
+	if err != nil {
+		return nil, fmt.Errorf("Failed unmarshalling work queue %v: %w", path, err)
+	}
+
+	return queue, nil
+}
+
+// writeWorkQueue replaces the work queue in the state store
+func writeWorkQueue(ctx context.Context, path string, queue []*QueuedMutation) error {
+	if path == "" {
+		return nil
+	}
+
+	if queue == nil {
+		queue = []*QueuedMutation{}
+	}
+	data, err := json.MarshalIndent(queue, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = getStateStore().Write(ctx, path, data)
+	if err != nil {
+		return fmt.Errorf("Failed writing work queue %v: %w", path, err)
+	}
+
+	return nil
+}
+
+// mutationKey identifies the entity an action mutates, so a mutation that fails again replaces its earlier queued attempt instead of being queued twice
+func mutationKey(a *Action) string {
+	if id := a.entityID(); id != "" {
+		return string(a.Type) + "/" + id
+	}
+	switch {
+	case a.Group != nil:
+		return string(a.Type) + "/" + a.Group.Name
+	case a.Organization != nil:
+		return string(a.Type) + "/" + a.Organization.Name
+	}
+
+	return string(a.Type)
+}
+
+// replayWorkQueue re-attempts the queued mutations before the run reconciles the fresh state, and returns the ones failing again with their attempts counted; the ones that failed maxAttempts times are dropped, since the reconcile plans whatever is still needed anyway
+func replayWorkQueue(ctx context.Context, apiClient ApiClient, queue []*QueuedMutation, maxAttempts int) (remaining []*QueuedMutation, err error) {
+	if len(queue) == 0 {
+		return nil, nil
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "WorkQueue::Replay")
+	defer span.Finish()
+
+	secret, err := getClientSecret()
+	if err != nil {
+		return queue, err
+	}
+	token, err := apiClient.GetToken(ctx, *clientID, secret)
+	if err != nil {
+		return queue, fmt.Errorf("Failed retrieving JWT token: %w", err)
+	}
+
+	actions := make([]*Action, 0, len(queue))
+	for _, m := range queue {
+		actions = append(actions, m.Action)
+	}
+	logFromContext(ctx).Info().Msgf("Re-attempting %v queued mutations", len(actions))
+
+	// the failures are recorded on the actions and kept in the queue, they don't fail the run
+	_ = apiClient.ApplyActions(ctx, token, actions)
+
+	remaining = make([]*QueuedMutation, 0)
+	for _, m := range queue {
+		if m.Action.Err == nil {
+			logFromContext(ctx).Info().Msgf("Applied queued mutation %v after %v failed attempts", m.Action, m.Attempts)
+			continue
+		}
+		m.Attempts++
+		m.Error = m.Action.Err.Error()
+		if maxAttempts > 0 && m.Attempts >= maxAttempts {
+			logFromContext(ctx).Warn().Msgf("Dropping queued mutation %v after %v failed attempts, the last one failed with: %v", m.Action, m.Attempts, m.Error)
+			continue
+		}
+		remaining = append(remaining, m)
+	}
+
+	span.LogKV("queued", len(queue), "remaining", len(remaining))
+
+	return remaining, nil
+}
+
+// enqueueFailedActions adds the actions of the run that failed to the queue, replacing queued mutations of the same entity, and removes the queued mutations of entities the run changed successfully
+func enqueueFailedActions(queue []*QueuedMutation, actions []*Action, now time.Time) []*QueuedMutation {
+	byKey := map[string]*QueuedMutation{}
+	for _, m := range queue {
+		byKey[mutationKey(m.Action)] = m
+	}
+
+	applied := map[string]bool{}
+	for _, a := range actions {
+		key := mutationKey(a)
+		if a.Err == nil {
+			applied[key] = true
+			continue
+		}
+		if m, ok := byKey[key]; ok {
+			m.Action = a
+			m.Error = a.Err.Error()
+			continue
+		}
+		m := &QueuedMutation{Action: a, Error: a.Err.Error(), Attempts: 1, QueuedAt: now}
+		byKey[key] = m
+		queue = append(queue, m)
+	}
+
+	updated := make([]*QueuedMutation, 0, len(queue))
+	for _, m := range queue {
+		if applied[mutationKey(m.Action)] {
+			continue
+		}
+		updated = append(updated, m)
+	}
+
+	return updated
+}
+
+// processWorkQueue replays the mutations queued by earlier runs and returns the queue to add the failures of this run to; it fails if the queue can't be read, in which case it's left as it is
+func processWorkQueue(ctx context.Context, apiClient ApiClient) ([]*QueuedMutation, error) {
+	queue, err := readWorkQueue(ctx, *workQueueFile)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining, err := replayWorkQueue(ctx, apiClient, queue, *workQueueMaxAttempts)
+	if err != nil {
+		logFromContext(ctx).Warn().Err(err).Msg("Failed re-attempting queued mutations, keeping them for the next run")
+	}
+
+	return remaining, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	contracts "github.com/estafette/estafette-ci-contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnqueueFailedActions(t *testing.T) {
+	t.Run("QueuesFailedActionsOnly", func(t *testing.T) {
+
+		failed := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1"}, Err: errors.New("service unavailable")}
+		applied := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u2"}, User: &contracts.User{ID: "u2"}}
+		now := time.Now().UTC()
+
+		// act
+		queue := enqueueFailedActions(nil, []*Action{failed, applied}, now)
+
+		if assert.Equal(t, 1, len(queue)) {
+			assert.Equal(t, failed, queue[0].Action)
+			assert.Equal(t, "service unavailable", queue[0].Error)
+			assert.Equal(t, 1, queue[0].Attempts)
+			assert.Equal(t, now, queue[0].QueuedAt)
+		}
+	})
+
+	t.Run("ReplacesQueuedMutationOfTheSameEntity", func(t *testing.T) {
+
+		queued := &QueuedMutation{Action: &Action{Type: ActionUpdateUser, User: &contracts.User{ID: "u1"}}, Error: "service unavailable", Attempts: 3}
+		failed := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1"}, Err: errors.New("bad gateway")}
+
+		// act
+		queue := enqueueFailedActions([]*QueuedMutation{queued}, []*Action{failed}, time.Now().UTC())
+
+		if assert.Equal(t, 1, len(queue)) {
+			assert.Equal(t, failed, queue[0].Action)
+			assert.Equal(t, "bad gateway", queue[0].Error)
+			assert.Equal(t, 3, queue[0].Attempts)
+		}
+	})
+
+	t.Run("RemovesQueuedMutationOfEntityTheRunChanged", func(t *testing.T) {
+
+		queued := &QueuedMutation{Action: &Action{Type: ActionUpdateUser, User: &contracts.User{ID: "u1"}, Err: errors.New("service unavailable")}, Attempts: 2}
+		applied := &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1"}}
+
+		// act
+		queue := enqueueFailedActions([]*QueuedMutation{queued}, []*Action{applied}, time.Now().UTC())
+
+		assert.Equal(t, 0, len(queue))
+	})
+}
+
+func TestReplayWorkQueue(t *testing.T) {
+	t.Run("AppliesQueuedMutationsAndKeepsTheOnesFailingAgain", func(t *testing.T) {
+
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+		estafetteAPI.seedUser("u1", "1234", "john@example.com")
+		client := NewApiClient(estafetteAPI.URL, nil, ApiClientOptions{MaxRetries: 1})
+		defer func(secret, secretFile string) { *clientSecret, *clientSecretFile = secret, secretFile }(*clientSecret, *clientSecretFile)
+		*clientSecret, *clientSecretFile = "secret", ""
+
+		platform := &contracts.Group{ID: "g1", Name: "platform"}
+		queue := []*QueuedMutation{
+			{Action: &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u1"}, User: &contracts.User{ID: "u1", Groups: []*contracts.Group{platform}}}, Attempts: 1},
+			{Action: &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u2"}, User: &contracts.User{ID: "u2", Groups: []*contracts.Group{platform}}}, Attempts: 1},
+		}
+
+		// act
+		remaining, err := replayWorkQueue(context.Background(), client, queue, 5)
+
+		assert.Nil(t, err)
+		assert.Contains(t, estafetteAPI.recordedMutations(), "PUT /api/users/u1")
+		if assert.Equal(t, 1, len(remaining)) {
+			assert.Equal(t, "u2", remaining[0].Action.User.ID)
+			assert.Equal(t, 2, remaining[0].Attempts)
+			assert.NotEmpty(t, remaining[0].Error)
+		}
+	})
+
+	t.Run("DropsMutationsThatFailedMaxAttemptsTimes", func(t *testing.T) {
+
+		estafetteAPI := newFakeEstafetteAPI()
+		defer estafetteAPI.Close()
+		client := NewApiClient(estafetteAPI.URL, nil, ApiClientOptions{MaxRetries: 1})
+		defer func(secret, secretFile string) { *clientSecret, *clientSecretFile = secret, secretFile }(*clientSecret, *clientSecretFile)
+		*clientSecret, *clientSecretFile = "secret", ""
+
+		queue := []*QueuedMutation{
+			{Action: &Action{Type: ActionUpdateUser, UserBefore: &contracts.User{ID: "u2"}, User: &contracts.User{ID: "u2", Groups: []*contracts.Group{{ID: "g1", Name: "platform"}}}}, Attempts: 4},
+		}
+
+		// act
+		remaining, err := replayWorkQueue(context.Background(), client, queue, 5)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(remaining))
+	})
+}
+
+func TestWorkQueueFile(t *testing.T) {
+	t.Run("RoundTripsQueuedMutations", func(t *testing.T) {
+
+		dir, err := ioutil.TempDir("", "workqueue")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "queue.json")
+		queue := []*QueuedMutation{{Action: &Action{Type: ActionDeleteGroup, Group: &contracts.Group{ID: "g1", Name: "legacy"}}, Error: "service unavailable", Attempts: 2}}
+
+		// act
+		err = writeWorkQueue(context.Background(), path, queue)
+		read, readErr := readWorkQueue(context.Background(), path)
+
+		assert.Nil(t, err)
+		assert.Nil(t, readErr)
+		if assert.Equal(t, 1, len(read)) {
+			assert.Equal(t, "g1", read[0].Action.Group.ID)
+			assert.Equal(t, 2, read[0].Attempts)
+		}
+	})
+
+	t.Run("ReturnsNoMutationsIfFileDoesNotExist", func(t *testing.T) {
+
+		// act
+		queue, err := readWorkQueue(context.Background(), filepath.Join(os.TempDir(), "does-not-exist", "queue.json"))
+
+		assert.Nil(t, err)
+		assert.Nil(t, queue)
+	})
+}